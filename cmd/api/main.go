@@ -3,12 +3,16 @@ package main
 import (
 	"fmt"
 	"log"
+	"os"
 	"runtime"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	_ "github.com/heroku/x/hmetrics/onload"
 	"github.com/windoze95/saltybytes-api/internal/config"
 	"github.com/windoze95/saltybytes-api/internal/db"
+	"github.com/windoze95/saltybytes-api/internal/logging"
+	"github.com/windoze95/saltybytes-api/internal/openai"
 	"github.com/windoze95/saltybytes-api/internal/router"
 )
 
@@ -45,6 +49,30 @@ func main() {
 	if err := cfg.LoadOpenaiPrompts(); err != nil {
 		log.Fatalf("Error loading OpenAI prompts: %v", err)
 	}
+	if err := cfg.CheckOpenaiPrompts(); err != nil {
+		log.Fatalf("Error checking OpenAI prompts: %v", err)
+	}
+
+	// Check that the configured OpenAI models can be used
+	if err := openai.ValidateModels(cfg); err != nil {
+		log.Fatalf("Error checking OpenAI models: %v", err)
+	}
+
+	// Run Gin in release mode, unless $GIN_MODE sets another mode
+	if os.Getenv(gin.EnvGinMode) == "" {
+		gin.SetMode(gin.ReleaseMode)
+	}
+
+	// Check that at least one CORS origin is allowed
+	if err := cfg.CheckCORSOrigins(gin.Mode() == gin.ReleaseMode); err != nil {
+		log.Fatalf("Error checking CORS origins: %v", err)
+	}
+	log.Printf("CORS allowed origins: %s", strings.Join(cfg.CORSAllowedOrigins(), ", "))
+
+	// Check that the trusted proxies the client IP is found behind are valid
+	if err := cfg.CheckProxyOptions(); err != nil {
+		log.Fatalf("Error checking proxy options: %v", err)
+	}
 
 	// Connect to the database
 	database, err := db.New(cfg)
@@ -60,11 +88,9 @@ func main() {
 	r.Run(":" + cfg.Env.Port.Value())
 }
 
-// ConfigureLogger sets up the logging environment.
+// ConfigureLogger sets up the structured logger, which lines logged with the log package go through too.
 func ConfigureLogger() {
-	log.SetFlags(0)
-	log.SetPrefix("[GIN] ")
-	log.SetOutput(gin.DefaultWriter)
+	logging.Setup(gin.DefaultWriter)
 }
 
 // ConfigureRuntime sets the number of operating system threads.
@@ -1,23 +1,445 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"os"
 	"reflect"
 	"strings"
 	"sync"
+	"text/template"
+	"time"
 )
 
 // Config struct to hold the configuration.
 type Config struct {
-	Env Env `json:"env"`
+	Env         Env         `json:"env"`
+	OptionalEnv OptionalEnv `json:"optional_env"`
 	// Prompts are actually the templates to construct the usable prompts.
 	// Use the FillSysPrompt and FillUserPrompt methods to retrieve a prompt.
 	OpenaiPrompts         OpenaiPrompts `json:"openai_prompts"`
 	OpenaiKeys            []string      `json:"openai_keys"`
 	CurrentOpenaiKeyIndex int
 	Mutex                 sync.RWMutex
+	Images                ImageOptions           `json:"images"`
+	Limits                LimitOptions           `json:"limits"`
+	LanguageCheck         LanguageCheckOptions   `json:"language_check"`
+	Locale                LocaleOptions          `json:"locale"`
+	Recaptcha             RecaptchaOptions       `json:"recaptcha"`
+	WelcomeRecipe         WelcomeRecipeOptions   `json:"welcome_recipe"`
+	Auth                  AuthOptions            `json:"auth"`
+	GenerationCache       GenerationCacheOptions `json:"generation_cache"`
+	PasswordReset         PasswordResetOptions   `json:"password_reset"`
+	Email                 EmailOptions           `json:"email"`
+	Facebook              FacebookOptions        `json:"facebook"`
+	Models                ModelOptions           `json:"models"`
+	OpenaiRetry           OpenaiRetryOptions     `json:"openai_retry"`
+	Trash                 TrashOptions           `json:"trash"`
+	CORS                  CORSOptions            `json:"cors"`
+	Stripe                StripeOptions          `json:"stripe"`
+	Database              DatabaseOptions        `json:"database"`
+	RecipeCache           RecipeCacheOptions     `json:"recipe_cache"`
+	Proxy                 ProxyOptions           `json:"proxy"`
+	SimilarRecipes        SimilarRecipesOptions  `json:"similar_recipes"`
+}
+
+// SimilarRecipesOptions struct to hold the options of reusing a user's recent recipe instead of generating it again.
+type SimilarRecipesOptions struct {
+	// WindowHours is how recent a recipe generated from the same prompt must be to be reused, 0 never reuses one.
+	WindowHours int `json:"window_hours"`
+}
+
+// Window returns how recent a recipe generated from the same prompt must be to be reused.
+func (s *SimilarRecipesOptions) Window() time.Duration {
+	return time.Duration(s.WindowHours) * time.Hour
+}
+
+// ProxyOptions struct to hold how the client IP is found behind proxies, which rate limiting is keyed on.
+// With neither option set, X-Forwarded-For is ignored and the client IP is the address of the connection.
+type ProxyOptions struct {
+	// TrustedProxies are the IPs and CIDRs of the proxies whose X-Forwarded-For header is trusted, e.g. "10.0.0.0/8".
+	TrustedProxies []string `json:"trusted_proxies"`
+	// TrustedHops is how many proxies, whose IPs aren't known up front, append to X-Forwarded-For in front of the API,
+	// e.g. 1 for Heroku's router. The client IP is that many entries from the end. It takes precedence over TrustedProxies.
+	TrustedHops int `json:"trusted_hops"`
+}
+
+// CheckProxyOptions checks that every trusted proxy is an IP or a CIDR, and that TrustedHops isn't negative.
+func (c *Config) CheckProxyOptions() error {
+	for _, proxy := range c.Proxy.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err == nil {
+			continue
+		}
+		if net.ParseIP(proxy) == nil {
+			return fmt.Errorf("trusted proxy %q is neither an IP nor a CIDR", proxy)
+		}
+	}
+
+	if c.Proxy.TrustedHops < 0 {
+		return errors.New("proxy.trusted_hops can't be negative")
+	}
+
+	return nil
+}
+
+// RecipeCacheOptions struct to hold the options of the cache of viewed recipes.
+type RecipeCacheOptions struct {
+	// Enabled caches recipes when they're viewed, so popular recipes don't read the database on every view.
+	Enabled bool `json:"enabled"`
+	// TTLSeconds is how long a recipe stays cached. Changes made through another instance can be this stale.
+	TTLSeconds int `json:"ttl_seconds"`
+	// MaxEntries caps how many recipes are cached, 0 means no limit.
+	MaxEntries int `json:"max_entries"`
+}
+
+// TTL returns how long a recipe stays cached.
+func (r *RecipeCacheOptions) TTL() time.Duration {
+	return time.Duration(r.TTLSeconds) * time.Second
+}
+
+// DatabaseOptions struct to hold the database options.
+type DatabaseOptions struct {
+	// MigrateOnStartup brings the schema up to date with the models when the API starts.
+	// Turn it off to migrate separately, e.g. when another instance already does.
+	MigrateOnStartup bool `json:"migrate_on_startup"`
+	// MaxOpenConns caps the open connections to the database, 0 means unlimited.
+	MaxOpenConns int `json:"max_open_conns"`
+	// MaxIdleConns caps the idle connections kept in the pool, 0 keeps the database/sql default.
+	MaxIdleConns int `json:"max_idle_conns"`
+	// ConnMaxLifetimeMinutes is how long a connection is reused before it's closed, 0 means forever.
+	ConnMaxLifetimeMinutes int `json:"conn_max_lifetime_minutes"`
+	// StatementTimeoutMillis is how long a query may run before the database cancels it, 0 means no timeout.
+	StatementTimeoutMillis int `json:"statement_timeout_millis"`
+}
+
+// ConnMaxLifetime returns how long a connection is reused before it's closed.
+func (d *DatabaseOptions) ConnMaxLifetime() time.Duration {
+	return time.Duration(d.ConnMaxLifetimeMinutes) * time.Minute
+}
+
+// StatementTimeout returns how long a query may run before the database cancels it.
+func (d *DatabaseOptions) StatementTimeout() time.Duration {
+	return time.Duration(d.StatementTimeoutMillis) * time.Millisecond
+}
+
+// CORSOptions struct to hold the CORS policy of the API.
+// OptionalEnv.CORSAllowedOrigins, a comma-separated list, overrides AllowedOrigins when it's set.
+type CORSOptions struct {
+	// AllowedOrigins are the origins allowed to call the API. "*" allows any origin, outside of release mode only.
+	AllowedOrigins []string `json:"allowed_origins"`
+}
+
+// CORSWildcard is the allowed origin that allows any origin.
+const CORSWildcard = "*"
+
+// CORSAllowedOrigins returns the origins allowed to call the API, from the environment if it's set.
+func (c *Config) CORSAllowedOrigins() []string {
+	value := c.OptionalEnv.CORSAllowedOrigins.Value()
+	if strings.TrimSpace(value) == "" {
+		return c.CORS.AllowedOrigins
+	}
+
+	var origins []string
+	for _, origin := range strings.Split(value, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	return origins
+}
+
+// CheckCORSOrigins checks that at least one origin is allowed to call the API,
+// and that the wildcard is only allowed outside of release mode.
+func (c *Config) CheckCORSOrigins(releaseMode bool) error {
+	origins := c.CORSAllowedOrigins()
+	if len(origins) == 0 {
+		return fmt.Errorf("no CORS origins are allowed, set cors.allowed_origins or $%s", c.OptionalEnv.CORSAllowedOrigins)
+	}
+
+	for _, origin := range origins {
+		if origin == CORSWildcard && releaseMode {
+			return fmt.Errorf("the CORS wildcard origin %q isn't allowed in release mode", CORSWildcard)
+		}
+	}
+
+	return nil
+}
+
+// StripeOptions struct to hold the options of Stripe subscription webhooks.
+// The webhook signing secret is read from OptionalEnv.StripeWebhookSecret.
+type StripeOptions struct {
+	// SignatureToleranceSeconds is how old a webhook event's signature may be, to keep old events from being replayed.
+	SignatureToleranceSeconds int `json:"signature_tolerance_seconds"`
+	// SubscriptionDays is how long the first billing cycle of a subscription lasts from its checkout.
+	SubscriptionDays int `json:"subscription_days"`
+}
+
+// SignatureTolerance returns how old a webhook event's signature may be.
+func (s *StripeOptions) SignatureTolerance() time.Duration {
+	return time.Duration(s.SignatureToleranceSeconds) * time.Second
+}
+
+// TrashOptions struct to hold the options of the recipe trash.
+type TrashOptions struct {
+	// RetentionDays is how long a deleted recipe stays in the trash, and can be restored, before it's purged.
+	RetentionDays int `json:"retention_days"`
+	// PurgeIntervalMinutes is how often expired recipes are purged, 0 disables purging.
+	PurgeIntervalMinutes int `json:"purge_interval_minutes"`
+	// PurgeBatchSize is how many recipes are purged per transaction.
+	PurgeBatchSize int `json:"purge_batch_size"`
+}
+
+// Retention returns how long a deleted recipe stays in the trash before it's purged.
+func (t *TrashOptions) Retention() time.Duration {
+	return time.Duration(t.RetentionDays) * 24 * time.Hour
+}
+
+// PurgeInterval returns how often expired recipes are purged.
+func (t *TrashOptions) PurgeInterval() time.Duration {
+	return time.Duration(t.PurgeIntervalMinutes) * time.Minute
+}
+
+// OpenaiRetryOptions struct to hold the retry options of OpenAI API calls.
+// Retries back off exponentially with jitter, a rate limited call waits as long as its Retry-After header asks instead.
+// Non-positive delays fall back to the defaults of the openai package.
+type OpenaiRetryOptions struct {
+	// MaxRetries is how many times a failed call is retried, 0 to never retry.
+	// Unset or negative falls back to the default of the openai package.
+	MaxRetries *int `json:"max_retries"`
+	// BaseDelayMillis is the wait before the first retry, doubled for each one after.
+	BaseDelayMillis int `json:"base_delay_millis"`
+	// MaxDelayMillis caps the wait between retries.
+	MaxDelayMillis int `json:"max_delay_millis"`
+}
+
+// ModelOptions struct to hold the OpenAI models recipes and their images are generated with.
+// Empty fields fall back to the defaults of the openai package.
+type ModelOptions struct {
+	// RecipeModel is the chat model recipes are generated with.
+	RecipeModel string `json:"recipe_model"`
+	// RecipeModelsByTier overrides RecipeModel for generations on the platform keys, keyed by subscription tier.
+	RecipeModelsByTier map[string]string `json:"recipe_models_by_tier"`
+	// ImageModel is the model recipe images are generated with.
+	ImageModel string `json:"image_model"`
+	// ImageSize is the size of recipe images, e.g. "512x512".
+	ImageSize string `json:"image_size"`
+}
+
+// RecipeModelFor returns the recipe model for a subscription tier, empty for the default model.
+func (m *ModelOptions) RecipeModelFor(tier string) string {
+	if model := m.RecipeModelsByTier[tier]; model != "" {
+		return model
+	}
+	return m.RecipeModel
+}
+
+// FacebookOptions struct to hold the options of Facebook login.
+// The app secret is read from OptionalEnv.FacebookAppSecret.
+type FacebookOptions struct {
+	// AppID is the Facebook app access tokens must have been issued for.
+	AppID string `json:"app_id"`
+	// GraphURL overrides the Graph API base URL.
+	GraphURL string `json:"graph_url"`
+	// TimeoutSeconds is how long to wait on the Graph API.
+	TimeoutSeconds int `json:"timeout_seconds"`
+}
+
+// PasswordResetOptions struct to hold the options of the password reset flow.
+type PasswordResetOptions struct {
+	// TokenTTLMinutes is how long a reset link stays valid.
+	TokenTTLMinutes int `json:"token_ttl_minutes"`
+	// ResetURL is the page of the frontend that resets the password, the token is added as the token query parameter.
+	ResetURL string `json:"reset_url"`
+	// MaxOutstandingTokens is how many unused, unexpired reset links a user can have, no more are emailed until one
+	// is used or expires. Non-positive values fall back to the default.
+	MaxOutstandingTokens int `json:"max_outstanding_tokens"`
+}
+
+// defaultMaxOutstandingPasswordResetTokens is used when the config doesn't set how many reset links a user can have.
+const defaultMaxOutstandingPasswordResetTokens = 3
+
+// TokenTTL returns how long a reset link stays valid.
+func (p *PasswordResetOptions) TokenTTL() time.Duration {
+	return time.Duration(p.TokenTTLMinutes) * time.Minute
+}
+
+// MaxOutstanding returns how many unused, unexpired reset links a user can have, the default when it isn't positive.
+func (p *PasswordResetOptions) MaxOutstanding() int {
+	if p.MaxOutstandingTokens <= 0 {
+		return defaultMaxOutstandingPasswordResetTokens
+	}
+	return p.MaxOutstandingTokens
+}
+
+// EmailOptions struct to hold the options of outgoing email.
+type EmailOptions struct {
+	// From is the sender address.
+	From string `json:"from"`
+	// SMTPHost is the SMTP server emails are sent through, when empty they're logged instead.
+	SMTPHost string `json:"smtp_host"`
+	SMTPPort int    `json:"smtp_port"`
+}
+
+// GenerationCacheOptions struct to hold the options of the recipe generation cache.
+type GenerationCacheOptions struct {
+	// Enabled reuses the recipe generated for an identical prompt and personalization, instead of calling OpenAI.
+	// Only generations on the platform keys are cached, users with a personal key always get a fresh recipe.
+	Enabled bool `json:"enabled"`
+	// TTLHours is how long a generated recipe is reused for.
+	TTLHours int `json:"ttl_hours"`
+}
+
+// TTL returns how long a generated recipe is reused for.
+func (g *GenerationCacheOptions) TTL() time.Duration {
+	return time.Duration(g.TTLHours) * time.Hour
+}
+
+// Token blocklist stores.
+const (
+	TokenBlocklistDatabase = "database"
+	TokenBlocklistMemory   = "memory"
+)
+
+// defaultTokenTTL is how long issued tokens are valid for when it isn't configured.
+const defaultTokenTTL = 24 * time.Hour
+
+// AuthOptions struct to hold the authentication options.
+type AuthOptions struct {
+	// TokenTTLHours is how long issued tokens are valid for, 24 hours when unset. Every token expires.
+	TokenTTLHours int `json:"token_ttl_hours"`
+	// TokenBlocklist is where revoked tokens are kept, "database" (the default) or "memory".
+	TokenBlocklist string `json:"token_blocklist"`
+	// RefreshWindowHours is how long before it expires a token can be exchanged for a new one.
+	RefreshWindowHours int `json:"refresh_window_hours"`
+}
+
+// TokenTTL returns how long issued tokens are valid for, the default when it isn't positive.
+func (a *AuthOptions) TokenTTL() time.Duration {
+	if a.TokenTTLHours <= 0 {
+		return defaultTokenTTL
+	}
+	return time.Duration(a.TokenTTLHours) * time.Hour
+}
+
+// RefreshWindow returns how long before it expires a token can be exchanged for a new one.
+func (a *AuthOptions) RefreshWindow() time.Duration {
+	return time.Duration(a.RefreshWindowHours) * time.Hour
+}
+
+// WelcomeRecipeOptions struct to hold the options of the recipe given to new users.
+type WelcomeRecipeOptions struct {
+	// Enabled gives every new user a copy of the template recipe when they sign up.
+	Enabled bool `json:"enabled"`
+	// TemplateRecipeID is the curated recipe that's copied, so signing up never calls OpenAI.
+	TemplateRecipeID uint `json:"template_recipe_id"`
+}
+
+// RecaptchaOptions struct to hold the reCAPTCHA verification options.
+// The secret key is read from OptionalEnv.RecaptchaSecretKey.
+type RecaptchaOptions struct {
+	// Enabled requires a valid reCAPTCHA token to sign up.
+	Enabled bool `json:"enabled"`
+	// EnforceOnLogin also requires a valid reCAPTCHA token to log in.
+	EnforceOnLogin bool `json:"enforce_on_login"`
+	// MinScore is the lowest reCAPTCHA v3 score accepted, 0 accepts any score.
+	MinScore float64 `json:"min_score"`
+	// FailOpen lets requests through when the verify endpoint can't be reached, instead of rejecting them.
+	FailOpen bool `json:"fail_open"`
+	// VerifyURL overrides Google's verify endpoint.
+	VerifyURL string `json:"verify_url"`
+	// TimeoutSeconds is how long to wait on the verify endpoint.
+	TimeoutSeconds int `json:"timeout_seconds"`
+}
+
+// LocaleOptions struct to hold the locale options.
+type LocaleOptions struct {
+	// DefaultLanguage is the language used when neither the request nor the user picks a supported one.
+	DefaultLanguage string `json:"default_language"`
+}
+
+// LanguageCheckOptions struct to hold the options of the post-generation language consistency check.
+type LanguageCheckOptions struct {
+	// Enabled opts in to checking recipes generated in a language other than English.
+	Enabled bool `json:"enabled"`
+	// MaxMismatchRatio is the largest share of the recipe allowed to come back in English.
+	MaxMismatchRatio float64 `json:"max_mismatch_ratio"`
+	// MaxRegenerations is how many times a mismatched recipe is regenerated before it's kept as is.
+	MaxRegenerations int `json:"max_regenerations"`
+}
+
+// LimitOptions struct to hold the usage limits.
+type LimitOptions struct {
+	// DailyGenerationCaps is the number of recipe generations allowed per user per UTC day, keyed by subscription tier.
+	// A missing or non-positive cap means unlimited.
+	DailyGenerationCaps map[string]int `json:"daily_generation_caps"`
+	// MaxBodyBytes is the largest request body accepted, larger bodies are rejected with 413. 0 means unlimited.
+	MaxBodyBytes int64 `json:"max_body_bytes"`
+	// MaxImportBodyBytes is the largest request body accepted by bulk imports, which the users of a full import
+	// don't fit in MaxBodyBytes. 0 means unlimited.
+	MaxImportBodyBytes int64 `json:"max_import_body_bytes"`
+	// QuotaResetIntervalMinutes is how often the subscriptions whose billing cycle ended are refilled in the background,
+	// 0 disables it. A subscription is also refilled when its user generates a recipe.
+	QuotaResetIntervalMinutes int `json:"quota_reset_interval_minutes"`
+}
+
+// QuotaResetInterval returns how often the subscriptions whose billing cycle ended are refilled.
+func (l *LimitOptions) QuotaResetInterval() time.Duration {
+	return time.Duration(l.QuotaResetIntervalMinutes) * time.Minute
+}
+
+// DailyGenerationCap returns the daily generation cap for a subscription tier, 0 means unlimited.
+func (l *LimitOptions) DailyGenerationCap(tier string) int {
+	return l.DailyGenerationCaps[tier]
+}
+
+// ImageOptions struct to hold the recipe image generation options.
+type ImageOptions struct {
+	// Disabled turns off image generation for every recipe, e.g. to control cost.
+	Disabled bool `json:"disabled"`
+	// PlaceholderURL is used as the recipe image when image generation is disabled.
+	PlaceholderURL string `json:"placeholder_url"`
+	// CORSAllowedOrigins are the origins allowed to load recipe images through the image proxy, "*" allows any.
+	CORSAllowedOrigins []string `json:"cors_allowed_origins"`
+	// MaxPromptLength is the longest image prompt, in characters, sent to the image model. Longer prompts are truncated.
+	MaxPromptLength int `json:"max_prompt_length"`
+	// Upload controls how recipe images are uploaded to S3.
+	Upload ImageUploadOptions `json:"upload"`
+	// ThumbnailWidth is the width, in pixels, of the thumbnail uploaded alongside each recipe image.
+	ThumbnailWidth int `json:"thumbnail_width"`
+	// WebPQuality is the quality, from 1 to 100, of the WebP copy uploaded alongside each recipe image.
+	WebPQuality int `json:"webp_quality"`
+	// Presigned serves recipe images from a private bucket through presigned URLs.
+	Presigned PresignedImageOptions `json:"presigned"`
+}
+
+// PresignedImageOptions struct to hold the options of presigned recipe image URLs.
+type PresignedImageOptions struct {
+	// Enabled hands out presigned GET URLs for recipe images instead of their public URLs, for private buckets.
+	Enabled bool `json:"enabled"`
+	// ExpiryMinutes is how long a presigned URL can be used, non-positive values fall back to the default of the s3 package.
+	ExpiryMinutes int `json:"expiry_minutes"`
+}
+
+// Expiry returns how long a presigned URL can be used.
+func (p *PresignedImageOptions) Expiry() time.Duration {
+	return time.Duration(p.ExpiryMinutes) * time.Minute
+}
+
+// ImageUploadOptions struct to hold the retry and circuit breaker options of recipe image uploads.
+type ImageUploadOptions struct {
+	// MaxAttempts is how many times an upload is tried before it's given up on.
+	MaxAttempts int `json:"max_attempts"`
+	// InitialBackoffMillis is the wait before the first retry, doubled for each retry after it.
+	InitialBackoffMillis int `json:"initial_backoff_millis"`
+	// BreakerFailureThreshold is the number of consecutive failed uploads that pauses uploads, 0 disables the breaker.
+	BreakerFailureThreshold int `json:"breaker_failure_threshold"`
+	// BreakerCooldownSeconds is how long uploads are paused before another one is tried.
+	BreakerCooldownSeconds int `json:"breaker_cooldown_seconds"`
 }
 
 // Env struct to hold the environment variables.
@@ -34,6 +456,24 @@ type Env struct {
 	OpenaiKeysPath     EnvVar `json:"openai_keys_path"`
 }
 
+// OptionalEnv struct to hold the environment variables that are only needed by optional features.
+// Unlike Env, these aren't required to be set at startup.
+type OptionalEnv struct {
+	OpenaiKeyEncryptionKey EnvVar `json:"openai_key_encryption_key"`
+	// OpenaiKeyEncryptionKeyID is the ID of OpenaiKeyEncryptionKey, stored with each personal key it encrypts
+	OpenaiKeyEncryptionKeyID EnvVar `json:"openai_key_encryption_key_id"`
+	// OpenaiKeyPreviousEncryptionKeys are the keys rotated out, as id:hex pairs, kept to decrypt what they encrypted
+	OpenaiKeyPreviousEncryptionKeys EnvVar `json:"openai_key_previous_encryption_keys"`
+	RecaptchaSecretKey              EnvVar `json:"recaptcha_secret_key"`
+	SMTPUsername                    EnvVar `json:"smtp_username"`
+	SMTPPassword                    EnvVar `json:"smtp_password"`
+	FacebookAppSecret               EnvVar `json:"facebook_app_secret"`
+	CORSAllowedOrigins              EnvVar `json:"cors_allowed_origins"`
+	StripeWebhookSecret             EnvVar `json:"stripe_webhook_secret"`
+	// S3KeyNamespace prefixes the S3 keys of recipe images, e.g. "staging", so environments can share a bucket
+	S3KeyNamespace EnvVar `json:"s3_key_namespace"`
+}
+
 // EnvVar is a string that represents an environment variable.
 type EnvVar string
 
@@ -53,11 +493,47 @@ type OpenaiPrompts struct {
 	GenNewVisionImportRecipeUser OpenaiPromptTemplate `json:"/saltybytes/openai_prompts/gen_new_vision_import_recipe_user"`
 	RegenRecipeSys               OpenaiPromptTemplate `json:"/saltybytes/openai_prompts/regen_recipe_sys"`
 	RegenRecipeUser              OpenaiPromptTemplate `json:"/saltybytes/openai_prompts/regen_recipe_user"`
+	// GenNewRecipeSysByTier are variants of GenNewRecipeSys for subscription tiers, keyed by tier, e.g. with more
+	// elaborate instructions for Premium. They're loaded from the parameters under GenNewRecipeSysByTierPath.
+	GenNewRecipeSysByTier map[string]OpenaiPromptTemplate `json:"-"`
+}
+
+// GenNewRecipeSysByTierPath is the parameter path of the tier variants of GenNewRecipeSys, each named after its tier,
+// e.g. /saltybytes/openai_prompts/gen_new_recipe_sys_by_tier/Premium.
+const GenNewRecipeSysByTierPath = "/saltybytes/openai_prompts/gen_new_recipe_sys_by_tier/"
+
+// GenNewRecipeSysFor returns the new recipe system prompt template for a subscription tier, the default one for tiers
+// without a variant.
+func (p *OpenaiPrompts) GenNewRecipeSysFor(tier string) OpenaiPromptTemplate {
+	if promptTemplate := p.GenNewRecipeSysByTier[tier]; promptTemplate != "" {
+		return promptTemplate
+	}
+	return p.GenNewRecipeSys
+}
+
+// SysPromptData is what a system prompt template is filled with.
+// Go templates refer to its fields, e.g. {{.Requirements}}, legacy templates to {unitSystem} and {requirements}.
+type SysPromptData struct {
+	UnitSystem   string
+	Requirements string
+}
+
+// UserPromptData is what a user prompt template is filled with.
+// Go templates refer to its field as {{.UserPrompt}}, legacy templates to {userPrompt}.
+type UserPromptData struct {
+	UserPrompt string
 }
 
 // OpenaiPromptTemplate is a string that represents an OpenAI prompt template.
 type OpenaiPromptTemplate string
 
+// Version returns a short, stable fingerprint of the prompt template.
+// Any edit to the template in the parameter store produces a new version.
+func (t OpenaiPromptTemplate) Version() string {
+	sum := sha256.Sum256([]byte(t))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
 // LoadConfig reads a JSON configuration file and returns a Config struct.
 func LoadConfig(filePath string) (*Config, error) {
 	file, err := os.ReadFile(filePath)
@@ -181,24 +657,104 @@ func (c *Config) LoadOpenaiPrompts() error {
 }
 
 // FillSysPrompt fetches a system prompt and replaces placeholders.
-func (p *OpenaiPrompts) FillSysPrompt(promptTemplate OpenaiPromptTemplate, unitSystem string, requirements string) string {
-	prompt := string(promptTemplate)
-
+// Go templates are executed, so the values are inserted as they are and never read as part of the template. A Go
+// template that can't be filled is an error, rather than a prompt sent with its actions left in.
+func (p *OpenaiPrompts) FillSysPrompt(promptTemplate OpenaiPromptTemplate, unitSystem string, requirements string) (string, error) {
 	sanitizedRequirements := strings.Replace(requirements, "`", "", -1)
 
+	if promptTemplate.isGoTemplate() {
+		return promptTemplate.execute(SysPromptData{UnitSystem: unitSystem, Requirements: sanitizedRequirements})
+	}
+
+	prompt := string(promptTemplate)
 	prompt = strings.Replace(prompt, "{unitSystem}", unitSystem, -1)
 	prompt = strings.Replace(prompt, "{requirements}", sanitizedRequirements, 1)
 
-	return prompt
+	return prompt, nil
 }
 
 // FillUserPrompt fetches a user prompt and replaces placeholders.
-func (p *OpenaiPrompts) FillUserPrompt(promptTemplate OpenaiPromptTemplate, userPrompt string) string {
-	prompt := string(promptTemplate)
-
+// A Go template that can't be filled is an error, like with FillSysPrompt.
+func (p *OpenaiPrompts) FillUserPrompt(promptTemplate OpenaiPromptTemplate, userPrompt string) (string, error) {
 	sanitizedUserPrompt := strings.Replace(userPrompt, "`", "", -1)
 
-	prompt = strings.Replace(prompt, "{userPrompt}", sanitizedUserPrompt, 1)
+	if promptTemplate.isGoTemplate() {
+		return promptTemplate.execute(UserPromptData{UserPrompt: sanitizedUserPrompt})
+	}
+
+	prompt := strings.Replace(string(promptTemplate), "{userPrompt}", sanitizedUserPrompt, 1)
+
+	return prompt, nil
+}
+
+// isGoTemplate reports whether the prompt template is a Go template, rather than one with legacy placeholders.
+func (t OpenaiPromptTemplate) isGoTemplate() bool {
+	return strings.Contains(string(t), "{{")
+}
+
+// parse parses the prompt template as a Go template. Missing fields are an error rather than an empty string.
+func (t OpenaiPromptTemplate) parse() (*template.Template, error) {
+	return template.New("prompt").Option("missingkey=error").Parse(string(t))
+}
+
+// execute fills the prompt template, a Go template, with the data.
+// CheckOpenaiPrompts catches templates that can't be filled at startup, but prompts can change at runtime.
+func (t OpenaiPromptTemplate) execute(data interface{}) (string, error) {
+	tmpl, err := t.parse()
+	if err != nil {
+		return "", fmt.Errorf("failed to parse prompt template %s: %v", t.Version(), err)
+	}
+
+	var prompt strings.Builder
+	if err := tmpl.Execute(&prompt, data); err != nil {
+		return "", fmt.Errorf("failed to execute prompt template %s: %v", t.Version(), err)
+	}
+	return prompt.String(), nil
+}
 
-	return prompt
+// CheckOpenaiPrompts checks that every system prompt template that's a Go template, including the tier variants,
+// can be filled with SysPromptData, and every user prompt template with UserPromptData.
+func (c *Config) CheckOpenaiPrompts() error {
+	prompts := c.OpenaiPrompts
+	sysTemplates := map[string]OpenaiPromptTemplate{
+		"gen_new_recipe_sys":               prompts.GenNewRecipeSys,
+		"gen_new_vision_import_args_sys":   prompts.GenNewVisionImportArgsSys,
+		"gen_new_vision_import_recipe_sys": prompts.GenNewVisionImportRecipeSys,
+		"regen_recipe_sys":                 prompts.RegenRecipeSys,
+	}
+	for tier, promptTemplate := range prompts.GenNewRecipeSysByTier {
+		sysTemplates["gen_new_recipe_sys_by_tier/"+tier] = promptTemplate
+	}
+	userTemplates := map[string]OpenaiPromptTemplate{
+		"gen_new_recipe_user":               prompts.GenNewRecipeUser,
+		"gen_new_vision_import_args_user":   prompts.GenNewVisionImportArgsUser,
+		"gen_new_vision_import_recipe_user": prompts.GenNewVisionImportRecipeUser,
+		"regen_recipe_user":                 prompts.RegenRecipeUser,
+	}
+
+	check := func(name string, promptTemplate OpenaiPromptTemplate, data interface{}) error {
+		if !promptTemplate.isGoTemplate() {
+			return nil
+		}
+		tmpl, err := promptTemplate.parse()
+		if err != nil {
+			return fmt.Errorf("prompt template %s can't be parsed: %v", name, err)
+		}
+		if err := tmpl.Execute(io.Discard, data); err != nil {
+			return fmt.Errorf("prompt template %s can't be filled: %v", name, err)
+		}
+		return nil
+	}
+	for name, promptTemplate := range sysTemplates {
+		if err := check(name, promptTemplate, SysPromptData{}); err != nil {
+			return err
+		}
+	}
+	for name, promptTemplate := range userTemplates {
+		if err := check(name, promptTemplate, UserPromptData{}); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
@@ -100,6 +100,15 @@ func (s *SSMService) GetOpenaiPromptsFromParameters(path string) (*OpenaiPrompts
 
 // mapParameterToStructField maps a parameter to the corresponding field in the OpenaiPrompts struct
 func mapParameterToStructField(prompts *OpenaiPrompts, param types.Parameter) error {
+	// Tier variants are keyed by the tier they're named after
+	if tier, ok := strings.CutPrefix(*param.Name, GenNewRecipeSysByTierPath); ok && tier != "" {
+		if prompts.GenNewRecipeSysByTier == nil {
+			prompts.GenNewRecipeSysByTier = make(map[string]OpenaiPromptTemplate)
+		}
+		prompts.GenNewRecipeSysByTier[tier] = OpenaiPromptTemplate(*param.Value)
+		return nil
+	}
+
 	paramJSON := fmt.Sprintf(`{"%s": %q}`, *param.Name, *param.Value)
 	return json.Unmarshal([]byte(paramJSON), prompts)
 }
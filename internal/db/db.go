@@ -3,6 +3,9 @@ package db
 import (
 	"fmt"
 	"log"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	_ "github.com/heroku/x/hmetrics/onload"
@@ -13,9 +16,29 @@ import (
 	"github.com/windoze95/saltybytes-api/internal/models"
 )
 
-// New creates a new database connection.
+// New creates a new database connection, and migrates the schema if the config asks for it.
 func New(cfg *config.Config) (*gorm.DB, error) {
-	return connectToDatabaseWithRetry(cfg.Env.DatabaseUrl.Value())
+	dbURL, err := withStatementTimeout(cfg.Env.DatabaseUrl.Value(), cfg.Database.StatementTimeout())
+	if err != nil {
+		return nil, err
+	}
+
+	database, err := connectToDatabaseWithRetry(dbURL)
+	if err != nil {
+		return nil, err
+	}
+	configurePool(database, &cfg.Database)
+
+	if !cfg.Database.MigrateOnStartup {
+		log.Printf("Skipping migrations, database.migrate_on_startup is off")
+		return database, nil
+	}
+	if err := Migrate(database); err != nil {
+		database.Close()
+		return nil, err
+	}
+
+	return database, nil
 }
 
 // connectToDatabaseWithRetry connects to the database and retries if necessary.
@@ -36,20 +59,93 @@ func connectToDatabaseWithRetry(dbURL string) (*gorm.DB, error) {
 		time.Sleep(5 * time.Second)
 	}
 
-	// Set a 5-second timeout for all queries in this session
-	// db.Exec("SET statement_timeout = 5000")
+	return database, err
+}
+
+// configurePool applies the pool options to the connections of the database, and logs the settings in effect.
+// Non-positive options keep the defaults of database/sql.
+func configurePool(database *gorm.DB, options *config.DatabaseOptions) {
+	sqlDB := database.DB()
+	if options.MaxOpenConns > 0 {
+		sqlDB.SetMaxOpenConns(options.MaxOpenConns)
+	}
+	if options.MaxIdleConns > 0 {
+		sqlDB.SetMaxIdleConns(options.MaxIdleConns)
+	}
+	if lifetime := options.ConnMaxLifetime(); lifetime > 0 {
+		sqlDB.SetConnMaxLifetime(lifetime)
+	}
+
+	log.Printf("Database pool: max open connections %s, max idle connections %s, connection max lifetime %s, statement timeout %s",
+		poolSetting(options.MaxOpenConns, "unlimited"),
+		poolSetting(options.MaxIdleConns, "default (2)"),
+		durationSetting(options.ConnMaxLifetime(), "unlimited"),
+		durationSetting(options.StatementTimeout(), "none"))
+}
 
-	database.AutoMigrate(
-		&models.User{},
-		&models.UserAuth{},
-		&models.Subscription{},
-		&models.UserSettings{},
-		&models.Personalization{},
-		&models.Recipe{},
-		&models.Tag{},
-		&models.RecipeHistory{},
-		&models.RecipeHistoryEntry{},
-	)
+// poolSetting formats a pool option for the log, or what it falls back to when it's not set.
+func poolSetting(value int, fallback string) string {
+	if value <= 0 {
+		return fallback
+	}
+	return strconv.Itoa(value)
+}
 
-	return database, err
+// durationSetting formats a duration option for the log, or what it falls back to when it's not set.
+func durationSetting(value time.Duration, fallback string) string {
+	if value <= 0 {
+		return fallback
+	}
+	return value.String()
+}
+
+// withStatementTimeout adds the statement timeout to the database URL as a run-time parameter, so every connection
+// of the pool has it, not only the one a SET statement happens to run on. A non-positive timeout leaves it unchanged.
+// Both the URL and the keyword/value forms of the connection string are supported.
+func withStatementTimeout(dbURL string, timeout time.Duration) (string, error) {
+	if timeout <= 0 {
+		return dbURL, nil
+	}
+	millis := strconv.FormatInt(timeout.Milliseconds(), 10)
+
+	if !strings.HasPrefix(dbURL, "postgres://") && !strings.HasPrefix(dbURL, "postgresql://") {
+		return dbURL + " statement_timeout=" + millis, nil
+	}
+
+	u, err := url.Parse(dbURL)
+	if err != nil {
+		return "", fmt.Errorf("could not parse database URL: %w", err)
+	}
+	query := u.Query()
+	query.Set("statement_timeout", millis)
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// migrateUserRoles gives every user from before roles the user role, or the admin role if they had the is_admin flag
+// that roles replace. The flag is dropped once it's migrated, so running it again changes nothing.
+func migrateUserRoles(database *gorm.DB) error {
+	tx := database.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	if tx.Dialect().HasColumn("users", "is_admin") {
+		if err := tx.Exec("UPDATE users SET role = ? WHERE is_admin", models.RoleAdmin).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Model(&models.User{}).DropColumn("is_admin").Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+		log.Printf("Migrated the is_admin flag of users to roles")
+	}
+
+	if err := tx.Exec("UPDATE users SET role = ? WHERE role IS NULL OR role = ''", models.RoleUser).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
 }
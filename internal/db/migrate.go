@@ -0,0 +1,91 @@
+package db
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/jinzhu/gorm"
+	"github.com/windoze95/saltybytes-api/internal/models"
+)
+
+// migratedModels are the models whose tables, and the join tables of their many to many associations,
+// are created and kept up to date by Migrate.
+var migratedModels = []interface{}{
+	&models.User{},
+	&models.UserAuth{},
+	&models.Subscription{},
+	&models.UserSettings{},
+	&models.Personalization{},
+	&models.UserSession{},
+	&models.PasswordResetToken{},
+	&models.StripeEvent{},
+	&models.Recipe{},
+	&models.Tag{},
+	&models.RecipeHistory{},
+	&models.RecipeHistoryEntry{},
+	&models.RecipeMade{},
+	&models.RecipeRating{},
+	&models.TokenUsage{},
+	&models.RecipeExplanation{},
+	&models.RecipeGenerationCache{},
+}
+
+// Migrate brings the schema up to date with the models, then runs the data migrations.
+// Tables, columns, and indexes are only ever added, never altered or dropped, so running it again changes nothing.
+// Every table and column it adds is logged.
+func Migrate(database *gorm.DB) error {
+	changes := 0
+	for _, model := range migratedModels {
+		missingTables, missingColumns := missingSchema(database, model)
+
+		if err := database.AutoMigrate(model).Error; err != nil {
+			return fmt.Errorf("could not migrate %T: %w", model, err)
+		}
+
+		for _, table := range missingTables {
+			log.Printf("Migration created table %s", table)
+		}
+		for _, column := range missingColumns {
+			log.Printf("Migration added column %s", column)
+		}
+		changes += len(missingTables) + len(missingColumns)
+	}
+
+	if err := migrateUserRoles(database); err != nil {
+		return fmt.Errorf("could not migrate user roles: %w", err)
+	}
+
+	if changes == 0 {
+		log.Printf("Migration found the schema up to date")
+	}
+
+	return nil
+}
+
+// missingSchema returns the tables, the model's own and its join tables, and the columns of the model that don't
+// exist yet, so AutoMigrate, which doesn't report what it did, can be logged.
+// Columns are table.column, and aren't listed for a table that's missing entirely.
+func missingSchema(database *gorm.DB, model interface{}) (tables []string, columns []string) {
+	scope := database.NewScope(model)
+	dialect := database.Dialect()
+	tableName := scope.TableName()
+	hasTable := dialect.HasTable(tableName)
+	if !hasTable {
+		tables = append(tables, tableName)
+	}
+
+	for _, field := range scope.GetModelStruct().StructFields {
+		if hasTable && field.IsNormal && !field.IsIgnored && !dialect.HasColumn(tableName, field.DBName) {
+			columns = append(columns, tableName+"."+field.DBName)
+		}
+
+		if relationship := field.Relationship; relationship != nil && relationship.JoinTableHandler != nil {
+			joinTableName := relationship.JoinTableHandler.Table(database)
+			if !dialect.HasTable(joinTableName) {
+				tables = append(tables, joinTableName)
+			}
+		}
+	}
+
+	return tables, columns
+}
@@ -1,10 +1,32 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/windoze95/saltybytes-api/internal/logging"
+	"github.com/windoze95/saltybytes-api/internal/util"
 )
 
+// requestLogger returns the logger of the request, which logs its request ID.
+func requestLogger(c *gin.Context) *slog.Logger {
+	return logging.FromContext(c.Request.Context())
+}
+
+// excludeViewerAllergens leaves the recipes with allergens the viewer excludes out of a listing.
+// The viewer is optional, anonymous visitors see every recipe.
+func excludeViewerAllergens(c *gin.Context, filter *util.RecipeFilter) {
+	if user, _ := util.GetUserFromContext(c); user != nil && user.Personalization != nil {
+		filter.ExcludeAllergens = user.Personalization.AllergenExclusions
+	}
+}
+
 // parseUintParam parses a string into a uint.
 func parseUintParam(param string) (uint, error) {
 	parsed, err := strconv.ParseUint(param, 10, 64)
@@ -16,3 +38,33 @@ func parseUintParam(param string) (uint, error) {
 	}
 	return uint(parsed), nil
 }
+
+// unknownFieldError is returned by bindJSONStrict when the request body has a field the request doesn't.
+type unknownFieldError struct {
+	field string
+}
+
+// Error returns the error message.
+func (e unknownFieldError) Error() string {
+	return fmt.Sprintf("unexpected field %s", e.field)
+}
+
+// bindJSONStrict binds the JSON request body like ShouldBindJSON, but rejects fields obj doesn't have,
+// so a typo'd field fails instead of being silently ignored.
+func bindJSONStrict(c *gin.Context, obj interface{}) error {
+	if c.Request.Body == nil {
+		return errors.New("request body is empty")
+	}
+
+	decoder := json.NewDecoder(c.Request.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(obj); err != nil {
+		// The decoder reports unknown fields as `json: unknown field "name"`
+		if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+			return unknownFieldError{field: field}
+		}
+		return err
+	}
+
+	return binding.Validator.ValidateStruct(obj)
+}
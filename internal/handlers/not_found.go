@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxSuggestionDistance is the largest total edit distance between a requested path and a route for it to be suggested.
+const maxSuggestionDistance = 2
+
+// NotFound returns a JSON handler for unknown routes that suggests the closest registered route, if there is one.
+func NotFound(engine *gin.Engine) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		response := gin.H{
+			"error": "Route not found",
+			"path":  c.Request.URL.Path,
+		}
+
+		if suggestion := suggestRoute(engine.Routes(), c.Request.Method, c.Request.URL.Path); suggestion != "" {
+			response["suggestion"] = suggestion
+			response["message"] = "Did you mean " + suggestion + "?"
+		}
+
+		c.JSON(http.StatusNotFound, response)
+	}
+}
+
+// suggestRoute finds the registered route closest to the requested path and fills in its parameters from the request.
+// Routes registered for the request method are preferred over routes for other methods.
+func suggestRoute(routes gin.RoutesInfo, method, path string) string {
+	requestSegments := splitPath(path)
+
+	bestSuggestion := ""
+	bestDistance := maxSuggestionDistance + 1
+	bestSameMethod := false
+	for _, route := range routes {
+		suggestion, distance, ok := matchRoute(splitPath(route.Path), requestSegments)
+		// An exact match only differs by method, which isn't a typo we can suggest a fix for
+		if !ok || distance == 0 || distance > maxSuggestionDistance {
+			continue
+		}
+
+		sameMethod := route.Method == method
+		if distance < bestDistance || (distance == bestDistance && sameMethod && !bestSameMethod) {
+			bestSuggestion = suggestion
+			bestDistance = distance
+			bestSameMethod = sameMethod
+		}
+	}
+
+	return bestSuggestion
+}
+
+// matchRoute compares route segments against request segments, returning the filled in route and the total edit distance.
+func matchRoute(routeSegments, requestSegments []string) (string, int, bool) {
+	if len(routeSegments) != len(requestSegments) {
+		return "", 0, false
+	}
+
+	distance := 0
+	filled := make([]string, len(routeSegments))
+	for i, routeSegment := range routeSegments {
+		// Parameters match any value
+		if strings.HasPrefix(routeSegment, ":") || strings.HasPrefix(routeSegment, "*") {
+			filled[i] = requestSegments[i]
+			continue
+		}
+
+		distance += levenshtein(strings.ToLower(routeSegment), strings.ToLower(requestSegments[i]))
+		filled[i] = routeSegment
+	}
+
+	return "/" + strings.Join(filled, "/"), distance, true
+}
+
+// splitPath splits a URL path into its non-empty segments.
+func splitPath(path string) []string {
+	var segments []string
+	for _, segment := range strings.Split(path, "/") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	return segments
+}
+
+// levenshtein returns the edit distance between two strings.
+func levenshtein(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(previous[j]+1, minInt(current[j-1]+1, previous[j-1]+cost))
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(b)]
+}
+
+// minInt returns the smaller of two ints.
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestNotFoundSuggestsRoute(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	noop := func(c *gin.Context) {}
+	r.GET("/v1/recipes/:recipe_id", noop)
+	r.GET("/v1/users/me", noop)
+	r.POST("/v1/recipes/chat", noop)
+	r.NoRoute(NotFound(r))
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		wantSuggestion string
+	}{
+		{"typo'd path", http.MethodGet, "/v1/recipe/5", "/v1/recipes/5"},
+		{"typo'd case", http.MethodGet, "/v1/Users/mee", "/v1/users/me"},
+		{"too far off", http.MethodGet, "/v1/ingredients/5", ""},
+		{"more segments", http.MethodGet, "/v1/recipes/5/steps", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+
+			if w.Code != http.StatusNotFound {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusNotFound)
+			}
+			var response struct {
+				Error      string `json:"error"`
+				Path       string `json:"path"`
+				Suggestion string `json:"suggestion"`
+				Message    string `json:"message"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("response isn't JSON: %v", err)
+			}
+			if response.Error == "" || response.Path != tt.path {
+				t.Fatalf("got response %+v, want an error for %s", response, tt.path)
+			}
+			if response.Suggestion != tt.wantSuggestion {
+				t.Fatalf("suggestion = %q, want %q", response.Suggestion, tt.wantSuggestion)
+			}
+			if tt.wantSuggestion != "" && response.Message != "Did you mean "+tt.wantSuggestion+"?" {
+				t.Fatalf("message = %q, want it to suggest %s", response.Message, tt.wantSuggestion)
+			}
+		})
+	}
+}
+
+func TestSuggestRoutePrefersRequestMethod(t *testing.T) {
+	routes := gin.RoutesInfo{
+		{Method: http.MethodGet, Path: "/v1/recipes/:recipe_id/fork"},
+		{Method: http.MethodPost, Path: "/v1/recipes/:recipe_id/form"},
+	}
+
+	if got := suggestRoute(routes, http.MethodPost, "/v1/recipes/5/forl"); got != "/v1/recipes/5/form" {
+		t.Fatalf("suggestion = %q, want the POST route", got)
+	}
+}
@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/windoze95/saltybytes-api/internal/models"
+)
+
+// occasionResponse is the response object for a recipe occasion.
+type occasionResponse struct {
+	Key    string `json:"key"`
+	Name   string `json:"name"`
+	Timely bool   `json:"timely"`
+}
+
+// ListOccasions lists the occasions recipes can be themed for.
+// The timely ones are also listed on their own, for suggesting a theme when the user asks to be surprised.
+func ListOccasions(c *gin.Context) {
+	now := time.Now()
+
+	occasions := make([]occasionResponse, len(models.Occasions))
+	for i, occasion := range models.Occasions {
+		occasions[i] = occasionResponse{
+			Key:    occasion.Key,
+			Name:   occasion.Name,
+			Timely: occasion.IsTimely(now),
+		}
+	}
+
+	suggested := []string{}
+	for _, occasion := range models.TimelyOccasions(now) {
+		suggested = append(suggested, occasion.Key)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"occasions": occasions, "suggested": suggested})
+}
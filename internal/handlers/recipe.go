@@ -1,10 +1,17 @@
 package handlers
 
 import (
-	"log"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/windoze95/saltybytes-api/internal/models"
+	"github.com/windoze95/saltybytes-api/internal/openai"
 	"github.com/windoze95/saltybytes-api/internal/repository"
 	"github.com/windoze95/saltybytes-api/internal/service"
 	"github.com/windoze95/saltybytes-api/internal/util"
@@ -29,14 +36,1086 @@ func (h *RecipeHandler) GetRecipe(c *gin.Context) {
 		return
 	}
 
-	recipeResponse, err := h.Service.GetRecipeByID(recipeID)
+	// The user is optional, anonymous visitors can view recipes too
+	user, _ := util.GetUserFromContext(c)
+
+	recipeResponse, err := h.Service.GetRecipeByID(recipeID, user)
+	if err != nil {
+		requestLogger(c).Error("getting recipe", "error", err)
+		switch e := err.(type) {
+		case repository.NotFoundError:
+			c.JSON(http.StatusNotFound, gin.H{"error": e.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": e.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recipe": recipeResponse})
+}
+
+// GetRecipeIngredients gets the ingredients of a recipe and its sub-recipes, with repeated ingredients summed.
+func (h *RecipeHandler) GetRecipeIngredients(c *gin.Context) {
+	recipeIDStr := c.Param("recipe_id")
+	recipeID, err := parseUintParam(recipeIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid recipe ID"})
+		return
+	}
+
+	ingredients, err := h.Service.GetCombinedIngredients(recipeID)
+	if err != nil {
+		requestLogger(c).Error("getting recipe ingredients", "error", err)
+		switch e := err.(type) {
+		case repository.NotFoundError:
+			c.JSON(http.StatusNotFound, gin.H{"error": e.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": e.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ingredients": ingredients})
+}
+
+// GetRecipePairings gets the drinks suggested to go with a recipe.
+func (h *RecipeHandler) GetRecipePairings(c *gin.Context) {
+	recipeIDStr := c.Param("recipe_id")
+	recipeID, err := parseUintParam(recipeIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid recipe ID"})
+		return
+	}
+
+	pairings, err := h.Service.GetRecipePairings(recipeID)
+	if err != nil {
+		requestLogger(c).Error("getting recipe pairings", "error", err)
+		switch e := err.(type) {
+		case repository.NotFoundError:
+			c.JSON(http.StatusNotFound, gin.H{"error": e.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": e.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, pairings)
+}
+
+// RegenerateRecipePairings replaces the drinks suggested to go with one of the user's recipes with fresh ones,
+// without regenerating the rest of the recipe.
+func (h *RecipeHandler) RegenerateRecipePairings(c *gin.Context) {
+	// Retrieve the user from the context
+	user, err := util.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	recipeIDStr := c.Param("recipe_id")
+	recipeID, err := parseUintParam(recipeIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid recipe ID"})
+		return
+	}
+
+	language := util.ResolveLocale(h.Service.Cfg, user, c.Request)
+	pairings, err := h.Service.RegenerateRecipePairings(user, recipeID, language)
+	if err != nil {
+		requestLogger(c).Error("regenerating recipe pairings", "error", err)
+		switch e := err.(type) {
+		case repository.NotFoundError:
+			c.JSON(http.StatusNotFound, gin.H{"error": e.Error()})
+		case service.ForbiddenError:
+			c.JSON(http.StatusForbidden, gin.H{"error": e.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": e.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, pairings)
+}
+
+// GetShoppingList returns the shopping list of a recipe and its sub-recipes. More recipes can be added to the list,
+// e.g. for a meal plan, with the comma-separated ids query parameter.
+func (h *RecipeHandler) GetShoppingList(c *gin.Context) {
+	recipeIDStr := c.Param("recipe_id")
+	recipeID, err := parseUintParam(recipeIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid recipe ID"})
+		return
+	}
+
+	recipeIDs := []uint{recipeID}
+	if ids := c.Query("ids"); ids != "" {
+		for _, idStr := range strings.Split(ids, ",") {
+			id, err := parseUintParam(strings.TrimSpace(idStr))
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid recipe ID in ids: " + idStr})
+				return
+			}
+			recipeIDs = append(recipeIDs, id)
+		}
+	}
+
+	shoppingList, err := h.Service.BuildShoppingList(recipeIDs...)
+	if err != nil {
+		switch e := err.(type) {
+		case service.ValidationError:
+			c.JSON(http.StatusBadRequest, gin.H{"error": e.Error()})
+		case repository.NotFoundError:
+			c.JSON(http.StatusNotFound, gin.H{"error": e.Error()})
+		default:
+			requestLogger(c).Error("building shopping list", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build shopping list"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"shopping_list": shoppingList})
+}
+
+// ScaleRecipe returns a recipe with its ingredient amounts scaled by the factor query parameter, or to serve the
+// servings query parameter.
+func (h *RecipeHandler) ScaleRecipe(c *gin.Context) {
+	recipeIDStr := c.Param("recipe_id")
+	recipeID, err := parseUintParam(recipeIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid recipe ID"})
+		return
+	}
+
+	var factor float64
+	var servings int
+	if servingsStr := c.Query("servings"); servingsStr != "" {
+		if servings, err = strconv.Atoi(servingsStr); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid servings"})
+			return
+		}
+	} else if factor, err = strconv.ParseFloat(c.Query("factor"), 64); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid factor"})
+		return
+	}
+
+	// The user is optional, anonymous visitors can scale recipes too
+	user, _ := util.GetUserFromContext(c)
+
+	recipeResponse, factor, err := h.Service.ScaleRecipe(recipeID, user, factor, servings)
+	if err != nil {
+		switch e := err.(type) {
+		case service.ValidationError:
+			c.JSON(http.StatusBadRequest, gin.H{"error": e.Error()})
+		case repository.NotFoundError:
+			c.JSON(http.StatusNotFound, gin.H{"error": e.Error()})
+		default:
+			requestLogger(c).Error("scaling recipe", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": e.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recipe": recipeResponse, "factor": factor})
+}
+
+// DiffRecipeHistory returns what changed between the two recipe history entries at the from and to query parameters.
+func (h *RecipeHandler) DiffRecipeHistory(c *gin.Context) {
+	recipeID, err := parseUintParam(c.Param("recipe_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid recipe ID"})
+		return
+	}
+
+	from, err := strconv.Atoi(c.Query("from"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from entry"})
+		return
+	}
+	to, err := strconv.Atoi(c.Query("to"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to entry"})
+		return
+	}
+
+	diff, err := h.Service.DiffHistoryEntries(recipeID, from, to)
+	if err != nil {
+		switch e := err.(type) {
+		case service.ValidationError:
+			c.JSON(http.StatusBadRequest, gin.H{"error": e.Error()})
+		case repository.NotFoundError:
+			c.JSON(http.StatusNotFound, gin.H{"error": e.Error()})
+		default:
+			requestLogger(c).Error("diffing recipe history", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to diff recipe history"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"diff": diff})
+}
+
+// ListUserRecipes lists a page of the recipes a user created.
+func (h *RecipeHandler) ListUserRecipes(c *gin.Context) {
+	h.listUserRecipes(c, h.Service.ListRecipesByUser)
+}
+
+// ListTagRecipes lists a page of the recipes tagged with a hashtag.
+func (h *RecipeHandler) ListTagRecipes(c *gin.Context) {
+	hashtag := c.Param("hashtag")
+
+	limit, offset, err := util.ParseLimitOffset(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	filter, err := util.ParseRecipeFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	excludeViewerAllergens(c, &filter)
+
+	recipes, total, err := h.Service.GetRecipesByTag(hashtag, filter, limit, offset)
+	if err != nil {
+		requestLogger(c).Error("listing tag recipes", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list recipes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"recipes":  recipes,
+		"total":    total,
+		"has_more": int64(offset+len(recipes)) < total,
+	})
+}
+
+// ListRecipeFeed lists a page of the newest recipes, continuing after the cursor query parameter.
+// Pages are chained by next_cursor, so recipes created while scrolling don't shift the pages after the first.
+func (h *RecipeHandler) ListRecipeFeed(c *gin.Context) {
+	limit, cursor, err := util.ParseLimitCursor(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	filter, err := util.ParseRecipeFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	excludeViewerAllergens(c, &filter)
+
+	recipes, nextCursor, err := h.Service.ListRecipeFeed(cursor, filter, limit)
+	if err != nil {
+		requestLogger(c).Error("listing recipe feed", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list recipes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"recipes":     recipes,
+		"next_cursor": nextCursor,
+		"has_more":    nextCursor != "",
+	})
+}
+
+// ListPopularTags lists the hashtags with the most recipes, as many as the limit query parameter.
+func (h *RecipeHandler) ListPopularTags(c *gin.Context) {
+	limit, _, err := util.ParseLimitOffset(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tags, err := h.Service.ListPopularTags(limit)
+	if err != nil {
+		requestLogger(c).Error("listing popular tags", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list tags"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tags": tags})
+}
+
+// ListUserCollectedRecipes lists a page of the recipes a user collected.
+func (h *RecipeHandler) ListUserCollectedRecipes(c *gin.Context) {
+	h.listUserRecipes(c, h.Service.ListCollectedRecipesByUser)
+}
+
+// listUserRecipes responds with a page of a user's recipes from list, with the total and whether there are more.
+func (h *RecipeHandler) listUserRecipes(c *gin.Context, list func(userID uint, filter util.RecipeFilter, limit, offset int) ([]*service.RecipeResponse, int64, error)) {
+	userIDStr := c.Param("user_id")
+	userID, err := parseUintParam(userIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	limit, offset, err := util.ParseLimitOffset(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	filter, err := util.ParseRecipeFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	recipes, total, err := list(userID, filter, limit, offset)
+	if err != nil {
+		requestLogger(c).Error("listing user recipes", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list recipes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"recipes":  recipes,
+		"total":    total,
+		"has_more": int64(offset+len(recipes)) < total,
+	})
+}
+
+// CollectRecipe saves a recipe to the user's collected recipes.
+func (h *RecipeHandler) CollectRecipe(c *gin.Context) {
+	h.changeUserCollection(c, h.Service.CollectRecipe, "Recipe collected")
+}
+
+// UncollectRecipe removes a recipe from the user's collected recipes.
+func (h *RecipeHandler) UncollectRecipe(c *gin.Context) {
+	h.changeUserCollection(c, h.Service.UncollectRecipe, "Recipe uncollected")
+}
+
+// changeUserCollection applies change to the user's collected recipes and responds with message.
+// Users can only change their own collection.
+func (h *RecipeHandler) changeUserCollection(c *gin.Context, change func(userID, recipeID uint) error, message string) {
+	// Retrieve the user from the context
+	user, err := util.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, err := parseUintParam(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	if userID != user.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the owner can change this collection"})
+		return
+	}
+
+	recipeID, err := parseUintParam(c.Param("recipe_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid recipe ID"})
+		return
+	}
+
+	if err := change(user.ID, recipeID); err != nil {
+		switch e := err.(type) {
+		case repository.NotFoundError:
+			c.JSON(http.StatusNotFound, gin.H{"error": e.Error()})
+		case service.ConflictError:
+			c.JSON(http.StatusConflict, gin.H{"error": e.Error()})
+		default:
+			requestLogger(c).Error("changing collected recipes", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change collected recipes"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": message})
+}
+
+// GetGenerationStatus gets the generation status of one of the user's recipes, with the completed recipe once it's complete.
+func (h *RecipeHandler) GetGenerationStatus(c *gin.Context) {
+	// Retrieve the user from the context
+	user, err := util.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	recipeID, err := parseUintParam(c.Param("recipe_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid recipe ID"})
+		return
+	}
+
+	status, err := h.Service.GetGenerationStatus(recipeID, user)
+	if err != nil {
+		switch e := err.(type) {
+		case service.ForbiddenError:
+			c.JSON(http.StatusForbidden, gin.H{"error": e.Error()})
+		case repository.NotFoundError:
+			c.JSON(http.StatusNotFound, gin.H{"error": e.Error()})
+		default:
+			requestLogger(c).Error("getting recipe generation status", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get generation status"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+// StreamRecipeGeneration streams the progress of a recipe's generation as Server-Sent Events.
+// Closing the stream cancels the generation, unless another stream of it is still open.
+func (h *RecipeHandler) StreamRecipeGeneration(c *gin.Context) {
+	// Retrieve the user from the context
+	user, err := util.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	recipeIDStr := c.Param("recipe_id")
+	recipeID, err := parseUintParam(recipeIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid recipe ID"})
+		return
+	}
+
+	events, unsubscribe, err := h.Service.SubscribeGeneration(recipeID, user)
+	if err != nil {
+		switch e := err.(type) {
+		case service.ForbiddenError:
+			c.JSON(http.StatusForbidden, gin.H{"error": e.Error()})
+		case repository.NotFoundError:
+			c.JSON(http.StatusNotFound, gin.H{"error": e.Error()})
+		default:
+			requestLogger(c).Error("streaming recipe generation", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": e.Error()})
+		}
+		return
+	}
+	defer unsubscribe()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // Keep proxies from buffering the events
+
+	// Each event is flushed as it's written, the stream ends when the generation does or the client goes away
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return false
+			}
+			c.SSEvent(string(event.Type), event.Data)
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+// GetRecipeImage proxies a recipe's image, so it can be used cross-origin.
+func (h *RecipeHandler) GetRecipeImage(c *gin.Context) {
+	recipeIDStr := c.Param("recipe_id")
+	recipeID, err := parseUintParam(recipeIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid recipe ID"})
+		return
+	}
+
+	imageBytes, err := h.Service.GetRecipeImage(recipeID)
+	if err != nil {
+		requestLogger(c).Error("getting recipe image", "error", err)
+		switch e := err.(type) {
+		case repository.NotFoundError:
+			c.JSON(http.StatusNotFound, gin.H{"error": e.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": e.Error()})
+		}
+		return
+	}
+
+	c.Header("Cache-Control", "public, max-age=86400")
+	c.Data(http.StatusOK, http.DetectContentType(imageBytes), imageBytes)
+}
+
+// GetRecipeImageURL returns the URL a recipe's image can be loaded from, presigned for private buckets.
+func (h *RecipeHandler) GetRecipeImageURL(c *gin.Context) {
+	recipeID, err := parseUintParam(c.Param("recipe_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid recipe ID"})
+		return
+	}
+
+	imageURL, err := h.Service.GetRecipeImageURL(recipeID)
+	if err != nil {
+		switch e := err.(type) {
+		case repository.NotFoundError:
+			c.JSON(http.StatusNotFound, gin.H{"error": e.Error()})
+		default:
+			requestLogger(c).Error("getting recipe image URL", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get recipe image URL"})
+		}
+		return
+	}
+
+	// Presigned URLs expire, so they aren't cached
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, imageURL)
+}
+
+// RegenerateRecipeImage replaces the image of one of the user's recipes with a newly generated one.
+func (h *RecipeHandler) RegenerateRecipeImage(c *gin.Context) {
+	// Retrieve the user from the context
+	user, err := util.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	recipeID, err := parseUintParam(c.Param("recipe_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid recipe ID"})
+		return
+	}
+
+	// Parse the optional request body for the image options to override
+	var options openai.ImageOptions
+	if c.Request.ContentLength > 0 {
+		if err := bindJSONStrict(c, &options); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+			return
+		}
+	}
+
+	recipeResponse, err := h.Service.RegenerateRecipeImage(c.Request.Context(), user, recipeID, options)
+	if err != nil {
+		switch e := err.(type) {
+		case repository.NotFoundError:
+			c.JSON(http.StatusNotFound, gin.H{"error": e.Error()})
+		case service.ForbiddenError:
+			c.JSON(http.StatusForbidden, gin.H{"error": e.Error()})
+		case service.ValidationError:
+			c.JSON(http.StatusBadRequest, gin.H{"error": e.Error()})
+		case service.TooManyRequestsError:
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": e.Error()})
+		case service.UnavailableError:
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": e.Error()})
+		default:
+			requestLogger(c).Error("regenerating recipe image", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to regenerate recipe image"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recipe": recipeResponse})
+}
+
+// GetRecipePDF returns a recipe rendered as a printable PDF download.
+func (h *RecipeHandler) GetRecipePDF(c *gin.Context) {
+	recipeIDStr := c.Param("recipe_id")
+	recipeID, err := parseUintParam(recipeIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid recipe ID"})
+		return
+	}
+
+	pdfBytes, filename, err := h.Service.RenderRecipePDF(c.Request.Context(), recipeID)
+	if err != nil {
+		requestLogger(c).Error("rendering recipe PDF", "error", err)
+		switch e := err.(type) {
+		case repository.NotFoundError:
+			c.JSON(http.StatusNotFound, gin.H{"error": e.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render recipe PDF"})
+		}
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Data(http.StatusOK, "application/pdf", pdfBytes)
+}
+
+// ExportRecipe returns a recipe exported as a Markdown, JSON, or PDF download, as chosen by the format query parameter.
+func (h *RecipeHandler) ExportRecipe(c *gin.Context) {
+	recipeIDStr := c.Param("recipe_id")
+	recipeID, err := parseUintParam(recipeIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid recipe ID"})
+		return
+	}
+
+	export, err := h.Service.ExportRecipe(c.Request.Context(), recipeID, c.DefaultQuery("format", service.ExportFormatMarkdown))
+	if err != nil {
+		switch e := err.(type) {
+		case service.ValidationError:
+			c.JSON(http.StatusBadRequest, gin.H{"error": e.Error()})
+		case repository.NotFoundError:
+			c.JSON(http.StatusNotFound, gin.H{"error": e.Error()})
+		default:
+			requestLogger(c).Error("exporting recipe", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export recipe"})
+		}
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", export.Filename))
+	c.Data(http.StatusOK, export.ContentType, export.Data)
+}
+
+// GetRecipeHistory returns a recipe history by ID.
+func (h *RecipeHandler) GetRecipeHistory(c *gin.Context) {
+	historyIDStr := c.Param("history_id")
+	historyID, err := parseUintParam(historyIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid recipe history ID"})
+		return
+	}
+
+	// The user is optional, only the owner sees the prompts
+	user, _ := util.GetUserFromContext(c)
+
+	history, err := h.Service.GetRecipeHistoryByID(c.Request.Context(), historyID, user)
+	if err != nil {
+		requestLogger(c).Error("getting recipe history", "error", err)
+		switch e := err.(type) {
+		case repository.NotFoundError:
+			c.JSON(http.StatusNotFound, gin.H{"error": e.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": e.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recipeHistory": history})
+}
+
+// RetagRecipe regenerates the hashtags of a recipe from its current content.
+func (h *RecipeHandler) RetagRecipe(c *gin.Context) {
+	// Retrieve the user from the context
+	user, err := util.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Abort()
+		return
+	}
+
+	recipeIDStr := c.Param("recipe_id")
+	recipeID, err := parseUintParam(recipeIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid recipe ID"})
+		return
+	}
+
+	// Parse the optional request body for the hashtags to pin
+	var request struct {
+		PinnedHashtags []string `json:"pinned_hashtags"`
+	}
+
+	if c.Request.ContentLength > 0 {
+		if err := bindJSONStrict(c, &request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+			return
+		}
+	}
+
+	recipeResponse, err := h.Service.RetagRecipe(c.Request.Context(), user, recipeID, request.PinnedHashtags)
+	if err != nil {
+		requestLogger(c).Error("retagging recipe", "error", err)
+		switch e := err.(type) {
+		case repository.NotFoundError:
+			c.JSON(http.StatusNotFound, gin.H{"error": e.Error()})
+		case service.ForbiddenError:
+			c.JSON(http.StatusForbidden, gin.H{"error": e.Error()})
+		case service.TooManyRequestsError:
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": e.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": e.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recipe": recipeResponse})
+}
+
+// LogRecipeMade records that the user made a recipe.
+func (h *RecipeHandler) LogRecipeMade(c *gin.Context) {
+	// Retrieve the user from the context
+	user, err := util.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Abort()
+		return
+	}
+
+	recipeIDStr := c.Param("recipe_id")
+	recipeID, err := parseUintParam(recipeIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid recipe ID"})
+		return
+	}
+
+	// Parse the optional request body, every field is optional
+	var request struct {
+		MadeAt *time.Time `json:"made_at"`
+		Notes  string     `json:"notes"`
+		Rating *int       `json:"rating"`
+	}
+
+	if c.Request.ContentLength > 0 {
+		if err := bindJSONStrict(c, &request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+			return
+		}
+	}
+
+	madeResponse, err := h.Service.LogRecipeMade(user, recipeID, request.MadeAt, request.Notes, request.Rating)
+	if err != nil {
+		switch e := err.(type) {
+		case repository.NotFoundError:
+			c.JSON(http.StatusNotFound, gin.H{"error": e.Error()})
+		case service.ValidationError:
+			c.JSON(http.StatusBadRequest, gin.H{"error": e.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": e.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"made": madeResponse})
+}
+
+// RateRecipe saves the user's rating of a recipe they collected.
+func (h *RecipeHandler) RateRecipe(c *gin.Context) {
+	// Retrieve the user from the context
+	user, err := util.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	recipeIDStr := c.Param("recipe_id")
+	recipeID, err := parseUintParam(recipeIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid recipe ID"})
+		return
+	}
+
+	var request struct {
+		Score   int    `json:"score" binding:"required"`
+		Comment string `json:"comment"`
+	}
+	if err := bindJSONStrict(c, &request); err != nil {
+		if e, ok := err.(unknownFieldError); ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": e.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A score is required"})
+		return
+	}
+
+	ratingResponse, err := h.Service.RateRecipe(user, recipeID, request.Score, request.Comment)
+	if err != nil {
+		switch e := err.(type) {
+		case repository.NotFoundError:
+			c.JSON(http.StatusNotFound, gin.H{"error": e.Error()})
+		case service.ValidationError:
+			c.JSON(http.StatusBadRequest, gin.H{"error": e.Error()})
+		case service.ForbiddenError:
+			c.JSON(http.StatusForbidden, gin.H{"error": e.Error()})
+		default:
+			requestLogger(c).Error("rating recipe", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rate recipe"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"rating": ratingResponse})
+}
+
+// ListRecipeRatings responds with a page of a recipe's ratings, with the total and whether there are more.
+func (h *RecipeHandler) ListRecipeRatings(c *gin.Context) {
+	recipeIDStr := c.Param("recipe_id")
+	recipeID, err := parseUintParam(recipeIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid recipe ID"})
+		return
+	}
+
+	limit, offset, err := util.ParseLimitOffset(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ratings, total, err := h.Service.GetRecipeRatings(recipeID, limit, offset)
+	if err != nil {
+		switch e := err.(type) {
+		case repository.NotFoundError:
+			c.JSON(http.StatusNotFound, gin.H{"error": e.Error()})
+		default:
+			requestLogger(c).Error("listing recipe ratings", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list ratings"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ratings":  ratings,
+		"total":    total,
+		"has_more": int64(offset+len(ratings)) < total,
+	})
+}
+
+// ExplainRecipe explains the techniques behind a recipe's key steps, in the user's language.
+func (h *RecipeHandler) ExplainRecipe(c *gin.Context) {
+	// Retrieve the user from the context
+	user, err := util.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	recipeIDStr := c.Param("recipe_id")
+	recipeID, err := parseUintParam(recipeIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid recipe ID"})
+		return
+	}
+
+	language := util.ResolveLocale(h.Service.Cfg, user, c.Request)
+	explanation, err := h.Service.ExplainRecipe(c.Request.Context(), user, recipeID, language)
+	if err != nil {
+		switch e := err.(type) {
+		case repository.NotFoundError:
+			c.JSON(http.StatusNotFound, gin.H{"error": e.Error()})
+		case service.TooManyRequestsError:
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": e.Error()})
+		default:
+			requestLogger(c).Error("explaining recipe", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": e.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"explanation": explanation})
+}
+
+// ForkRecipe starts a copy of a recipe for the user, modified per their prompt.
+func (h *RecipeHandler) ForkRecipe(c *gin.Context) {
+	// Retrieve the user from the context
+	user, err := util.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	recipeIDStr := c.Param("recipe_id")
+	recipeID, err := parseUintParam(recipeIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid recipe ID"})
+		return
+	}
+
+	// Parse the request body for the user's prompt
+	var request struct {
+		UserPrompt string `json:"user_prompt"`
+	}
+
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	if request.UserPrompt == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User prompt is required"})
+		return
+	}
+
+	language := util.ResolveLocale(h.Service.Cfg, user, c.Request)
+	recipeResponse, _, err := h.Service.ForkRecipe(c.Request.Context(), user, recipeID, request.UserPrompt, language)
+	if err != nil {
+		switch e := err.(type) {
+		case repository.NotFoundError:
+			c.JSON(http.StatusNotFound, gin.H{"error": e.Error()})
+		case service.TooManyRequestsError:
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": e.Error()})
+		default:
+			requestLogger(c).Error("forking recipe", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": e.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recipe": recipeResponse, "message": "Forking recipe"})
+}
+
+// DuplicateRecipe copies a recipe into a new one owned by the user, without generating anything, so they can edit it
+// by hand.
+func (h *RecipeHandler) DuplicateRecipe(c *gin.Context) {
+	// Retrieve the user from the context
+	user, err := util.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	recipeIDStr := c.Param("recipe_id")
+	recipeID, err := parseUintParam(recipeIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid recipe ID"})
+		return
+	}
+
+	recipeResponse, err := h.Service.DuplicateRecipe(user, recipeID)
+	if err != nil {
+		switch e := err.(type) {
+		case repository.NotFoundError:
+			c.JSON(http.StatusNotFound, gin.H{"error": e.Error()})
+		case service.ValidationError:
+			c.JSON(http.StatusBadRequest, gin.H{"error": e.Error()})
+		default:
+			requestLogger(c).Error("duplicating recipe", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": e.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"recipe": recipeResponse, "message": "Recipe duplicated"})
+}
+
+// EditRecipe applies the owner's manual edit to the title, ingredients, instructions, or cook time of a recipe.
+func (h *RecipeHandler) EditRecipe(c *gin.Context) {
+	// Retrieve the user from the context
+	user, err := util.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	recipeIDStr := c.Param("recipe_id")
+	recipeID, err := parseUintParam(recipeIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid recipe ID"})
+		return
+	}
+
+	var edit service.RecipeEdit
+	if err := bindJSONStrict(c, &edit); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	recipeResponse, err := h.Service.EditRecipe(user, recipeID, &edit)
+	if err != nil {
+		switch e := err.(type) {
+		case repository.NotFoundError:
+			c.JSON(http.StatusNotFound, gin.H{"error": e.Error()})
+		case service.ForbiddenError:
+			c.JSON(http.StatusForbidden, gin.H{"error": e.Error()})
+		case service.ConflictError:
+			c.JSON(http.StatusConflict, gin.H{"error": e.Error()})
+		case service.ValidationError:
+			c.JSON(http.StatusBadRequest, gin.H{"error": e.Error()})
+		default:
+			requestLogger(c).Error("editing recipe", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": e.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recipe": recipeResponse, "message": "Recipe updated"})
+}
+
+// RefineRecipe updates one of the user's recipes per a follow-up prompt.
+func (h *RecipeHandler) RefineRecipe(c *gin.Context) {
+	// Retrieve the user from the context
+	user, err := util.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	recipeID, err := parseUintParam(c.Param("recipe_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid recipe ID"})
+		return
+	}
+
+	// Parse the request body for the user's follow-up prompt
+	var request struct {
+		UserPrompt string `json:"user_prompt"`
+	}
+
+	if err := bindJSONStrict(c, &request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	language := util.ResolveLocale(h.Service.Cfg, user, c.Request)
+	recipeResponse, err := h.Service.RefineRecipe(c.Request.Context(), recipeID, user, request.UserPrompt, language)
+	if err != nil {
+		switch e := err.(type) {
+		case service.ValidationError:
+			c.JSON(http.StatusBadRequest, gin.H{"error": e.Error()})
+		case repository.NotFoundError:
+			c.JSON(http.StatusNotFound, gin.H{"error": e.Error()})
+		case service.ForbiddenError:
+			c.JSON(http.StatusForbidden, gin.H{"error": e.Error()})
+		case service.ConflictError:
+			c.JSON(http.StatusConflict, gin.H{"error": e.Error()})
+		case service.TooManyRequestsError:
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": e.Error()})
+		default:
+			requestLogger(c).Error("refining recipe", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refine recipe"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recipe": recipeResponse})
+}
+
+// RevertRecipe restores one of the user's recipes to an earlier entry of its history.
+func (h *RecipeHandler) RevertRecipe(c *gin.Context) {
+	// Retrieve the user from the context
+	user, err := util.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	recipeID, err := parseUintParam(c.Param("recipe_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid recipe ID"})
+		return
+	}
+
+	// Parse the request body for the index of the history entry to revert to
+	var request struct {
+		HistoryEntryIndex *int `json:"history_entry_index" binding:"required"`
+	}
+
+	if err := bindJSONStrict(c, &request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	recipeResponse, err := h.Service.RevertRecipe(user, recipeID, *request.HistoryEntryIndex)
 	if err != nil {
-		log.Printf("Error getting recipe: %v", err)
 		switch e := err.(type) {
+		case service.ValidationError:
+			c.JSON(http.StatusBadRequest, gin.H{"error": e.Error()})
 		case repository.NotFoundError:
 			c.JSON(http.StatusNotFound, gin.H{"error": e.Error()})
+		case service.ForbiddenError:
+			c.JSON(http.StatusForbidden, gin.H{"error": e.Error()})
+		case service.ConflictError:
+			c.JSON(http.StatusConflict, gin.H{"error": e.Error()})
 		default:
-			c.JSON(http.StatusInternalServerError, gin.H{"error": e.Error()})
+			requestLogger(c).Error("reverting recipe", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revert recipe"})
 		}
 		return
 	}
@@ -44,31 +1123,351 @@ func (h *RecipeHandler) GetRecipe(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"recipe": recipeResponse})
 }
 
-// GetRecipeHistory returns a recipe history by ID.
-func (h *RecipeHandler) GetRecipeHistory(c *gin.Context) {
-	historyIDStr := c.Param("history_id")
-	historyID, err := parseUintParam(historyIDStr)
+// ResolveRecipeLinks links one of the user's recipes to the existing recipes matching its link suggestions.
+func (h *RecipeHandler) ResolveRecipeLinks(c *gin.Context) {
+	// Retrieve the user from the context
+	user, err := util.GetUserFromContext(c)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid recipe history ID"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	recipeID, err := parseUintParam(c.Param("recipe_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid recipe ID"})
+		return
+	}
+
+	recipeResponse, err := h.Service.ResolveRecipeLinks(user, recipeID)
+	if err != nil {
+		switch e := err.(type) {
+		case repository.NotFoundError:
+			c.JSON(http.StatusNotFound, gin.H{"error": e.Error()})
+		case service.ForbiddenError:
+			c.JSON(http.StatusForbidden, gin.H{"error": e.Error()})
+		case service.ConflictError:
+			c.JSON(http.StatusConflict, gin.H{"error": e.Error()})
+		default:
+			requestLogger(c).Error("resolving recipe links", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve recipe links"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recipe": recipeResponse})
+}
+
+// DeleteRecipe moves one of the user's recipes to their trash.
+func (h *RecipeHandler) DeleteRecipe(c *gin.Context) {
+	// Retrieve the user from the context
+	user, err := util.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	recipeID, err := parseUintParam(c.Param("recipe_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid recipe ID"})
+		return
+	}
+
+	expiresAt, err := h.Service.SoftDeleteRecipe(user, recipeID)
+	if err != nil {
+		switch e := err.(type) {
+		case repository.NotFoundError:
+			c.JSON(http.StatusNotFound, gin.H{"error": e.Error()})
+		case service.ForbiddenError:
+			c.JSON(http.StatusForbidden, gin.H{"error": e.Error()})
+		default:
+			requestLogger(c).Error("deleting recipe", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete recipe"})
+		}
 		return
 	}
 
-	history, err := h.Service.GetRecipeHistoryByID(historyID)
+	c.JSON(http.StatusOK, gin.H{"message": "Recipe moved to trash", "expires_at": expiresAt})
+}
+
+// ListTrash lists a page of the recipes in the user's trash.
+func (h *RecipeHandler) ListTrash(c *gin.Context) {
+	// Retrieve the user from the context
+	user, err := util.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, err := parseUintParam(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	limit, offset, err := util.ParseLimitOffset(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	recipes, total, err := h.Service.ListTrashedRecipesByUser(user, userID, limit, offset)
+	if err != nil {
+		switch e := err.(type) {
+		case service.ForbiddenError:
+			c.JSON(http.StatusForbidden, gin.H{"error": e.Error()})
+		default:
+			requestLogger(c).Error("listing trash", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list trash"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"recipes":  recipes,
+		"total":    total,
+		"has_more": int64(offset+len(recipes)) < total,
+	})
+}
+
+// RestoreRecipe takes one of the user's recipes out of their trash.
+func (h *RecipeHandler) RestoreRecipe(c *gin.Context) {
+	// Retrieve the user from the context
+	user, err := util.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, err := parseUintParam(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	recipeID, err := parseUintParam(c.Param("recipe_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid recipe ID"})
+		return
+	}
+
+	recipeResponse, err := h.Service.RestoreRecipe(user, userID, recipeID)
 	if err != nil {
-		log.Printf("Error getting recipe history: %v", err)
 		switch e := err.(type) {
 		case repository.NotFoundError:
 			c.JSON(http.StatusNotFound, gin.H{"error": e.Error()})
+		case service.ForbiddenError:
+			c.JSON(http.StatusForbidden, gin.H{"error": e.Error()})
+		default:
+			requestLogger(c).Error("restoring recipe", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore recipe"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recipe": recipeResponse})
+}
+
+// BulkDeleteRecipes permanently deletes several of the user's recipes at once, given by the ids query parameter, and
+// returns the result of each.
+func (h *RecipeHandler) BulkDeleteRecipes(c *gin.Context) {
+	// Retrieve the user from the context
+	user, err := util.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, err := parseUintParam(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var recipeIDs []uint
+	if ids := c.Query("ids"); ids != "" {
+		for _, idStr := range strings.Split(ids, ",") {
+			id, err := parseUintParam(strings.TrimSpace(idStr))
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid recipe ID in ids: " + idStr})
+				return
+			}
+			recipeIDs = append(recipeIDs, id)
+		}
+	}
+
+	results, err := h.Service.BulkDeleteRecipes(user, userID, recipeIDs)
+	if err != nil {
+		switch e := err.(type) {
+		case service.ValidationError:
+			c.JSON(http.StatusBadRequest, gin.H{"error": e.Error()})
+		case service.ForbiddenError:
+			c.JSON(http.StatusForbidden, gin.H{"error": e.Error()})
+		default:
+			requestLogger(c).Error("bulk deleting recipes", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete recipes"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"results": results})
+}
+
+// PreviewPrompt returns the messages a recipe generation would send to OpenAI for the user's prompt,
+// with their current personalization, without generating anything.
+func (h *RecipeHandler) PreviewPrompt(c *gin.Context) {
+	// Retrieve the user from the context
+	user, err := util.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	userPrompt := c.Query("prompt")
+	if userPrompt == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User prompt is required"})
+		return
+	}
+
+	language := util.ResolveLocale(h.Service.Cfg, user, c.Request)
+	preview, err := h.Service.PreviewRecipePrompt(user, userPrompt, language, c.Query("occasion"))
+	if err != nil {
+		switch e := err.(type) {
+		case service.ValidationError:
+			c.JSON(http.StatusBadRequest, gin.H{"error": e.Error()})
 		default:
+			requestLogger(c).Error("previewing prompt", "error", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": e.Error()})
 		}
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"recipeHistory": history})
+	c.JSON(http.StatusOK, gin.H{"preview": preview})
+}
+
+// NormalizeTags re-cleans every existing tag and merges duplicates, for admins.
+func (h *RecipeHandler) NormalizeTags(c *gin.Context) {
+	// Retrieve the acting admin from the context
+	admin, err := util.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := h.Service.NormalizeTags()
+	if err != nil {
+		requestLogger(c).Error("normalizing tags", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	requestLogger(c).Info("admin normalized tags", "admin_id", admin.ID, "renamed", result.Renamed, "merged", result.Merged, "removed", result.Removed)
+
+	c.JSON(http.StatusOK, gin.H{"result": result})
+}
+
+// GetRecipeCacheStats fetches the hit and miss counts of the recipe cache, for admins.
+func (h *RecipeHandler) GetRecipeCacheStats(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"recipe_cache": h.Service.GetRecipeCacheStats()})
+}
+
+// ListRecipesForAdmin lists a page of every user's recipes, filtered by the query parameters, for moderators.
+func (h *RecipeHandler) ListRecipesForAdmin(c *gin.Context) {
+	limit, offset, err := util.ParseLimitOffset(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	filter, err := parseAdminRecipeFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	recipes, total, err := h.Service.ListRecipesForAdmin(filter, limit, offset)
+	if err != nil {
+		requestLogger(c).Error("listing recipes for admin", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list recipes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"recipes":  recipes,
+		"total":    total,
+		"has_more": int64(offset+len(recipes)) < total,
+	})
+}
+
+// parseAdminRecipeFilter parses the recipe filter query parameters, along with the user_id, status, title, and
+// include_deleted ones only moderators can filter by.
+func parseAdminRecipeFilter(c *gin.Context) (repository.AdminRecipeFilter, error) {
+	recipeFilter, err := util.ParseRecipeFilter(c)
+	if err != nil {
+		return repository.AdminRecipeFilter{}, err
+	}
+	filter := repository.AdminRecipeFilter{
+		Recipe: recipeFilter,
+		Title:  strings.TrimSpace(c.Query("title")),
+	}
+
+	if userIDStr := c.Query("user_id"); userIDStr != "" {
+		if filter.CreatedByID, err = parseUintParam(userIDStr); err != nil {
+			return repository.AdminRecipeFilter{}, errors.New("user_id must be a user ID")
+		}
+	}
+
+	if status := c.Query("status"); status != "" {
+		filter.GenerationStatus = models.GenerationStatus(strings.ToLower(status))
+		if !filter.GenerationStatus.IsValidGenerationStatus() {
+			return repository.AdminRecipeFilter{}, fmt.Errorf("unknown status %q", status)
+		}
+	}
+
+	if includeDeletedStr := c.Query("include_deleted"); includeDeletedStr != "" {
+		if filter.IncludeDeleted, err = strconv.ParseBool(includeDeletedStr); err != nil {
+			return repository.AdminRecipeFilter{}, errors.New("include_deleted must be true or false")
+		}
+	}
+
+	return filter, nil
+}
+
+// ModerateRecipe removes a recipe on behalf of the acting moderator.
+func (h *RecipeHandler) ModerateRecipe(c *gin.Context) {
+	// Retrieve the acting moderator from the context
+	moderator, err := util.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	recipeIDStr := c.Param("recipe_id")
+	recipeID, err := parseUintParam(recipeIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid recipe ID"})
+		return
+	}
+
+	if err := h.Service.ModerateRecipe(moderator, recipeID); err != nil {
+		switch e := err.(type) {
+		case repository.NotFoundError:
+			c.JSON(http.StatusNotFound, gin.H{"error": e.Error()})
+		default:
+			requestLogger(c).Error("moderating recipe", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove recipe"})
+		}
+		return
+	}
+
+	requestLogger(c).Info("moderator removed recipe", "moderator_id", moderator.ID, "role", moderator.Role, "recipe_id", recipeID)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Recipe removed"})
 }
 
-// CreateRecipe creates a new recipe.
+// GenerateRecipeWithChat creates a new recipe, generated from the user's prompt.
+// By default it responds right away with the pending recipe, which has an ID but no content yet. Its completed
+// recipe comes from the status endpoint, or the generation stream, once it's generated. With wait set, it responds
+// once the recipe is generated instead, with the completed recipe, while its image is still generated in the background.
 func (h *RecipeHandler) GenerateRecipeWithChat(c *gin.Context) {
 	// Retrieve the user from the context
 	user, err := util.GetUserFromContext(c)
@@ -80,7 +1479,11 @@ func (h *RecipeHandler) GenerateRecipeWithChat(c *gin.Context) {
 
 	// Parse the request body for the user's prompt
 	var request struct {
-		UserPrompt string `json:"user_prompt"`
+		UserPrompt   string `json:"user_prompt"`
+		Occasion     string `json:"occasion"`      // Optional, a key or name from the occasion list
+		Force        bool   `json:"force"`         // Optional, skips the generation cache for a fresh recipe
+		Wait         bool   `json:"wait"`          // Optional, responds with the completed recipe
+		ReuseSimilar bool   `json:"reuse_similar"` // Optional, responds with a recent recipe from the same prompt if there is one
 	}
 
 	if err := c.BindJSON(&request); err != nil {
@@ -93,13 +1496,103 @@ func (h *RecipeHandler) GenerateRecipeWithChat(c *gin.Context) {
 		return
 	}
 
-	recipeResponse, err := h.Service.InitGenerateRecipeWithChat(user, request.UserPrompt)
+	language := util.ResolveLocale(h.Service.Cfg, user, c.Request)
+
+	// A recent recipe from the same prompt is returned instead of generating it again, when asked for and not forced
+	var recipeResponse *service.RecipeResponse
+	reused := false
+	if request.ReuseSimilar && !request.Force {
+		similar, err := h.Service.FindSimilarRecentRecipe(user, request.UserPrompt, language, request.Occasion)
+		if err != nil {
+			requestLogger(c).Error("finding similar recipe", "error", err)
+		} else if similar != nil {
+			recipeResponse = similar
+			reused = true
+			// Nothing is generated, so the subscription use spent on the request is given back
+			c.Set("generation_reused", true)
+		}
+	}
+
+	if recipeResponse == nil {
+		var err error
+		recipeResponse, err = h.Service.InitGenerateRecipeWithChat(c.Request.Context(), user, request.UserPrompt, language, request.Occasion, request.Force)
+		if err != nil {
+			switch e := err.(type) {
+			case service.ValidationError:
+				c.JSON(http.StatusBadRequest, gin.H{"error": e.Error()})
+			case service.TooManyRequestsError:
+				c.JSON(http.StatusTooManyRequests, gin.H{"error": e.Error()})
+			default:
+				c.JSON(http.StatusInternalServerError, gin.H{"error": e.Error()})
+			}
+			return
+		}
+	}
+
+	if !request.Wait {
+		message := "Generating recipe"
+		if reused {
+			message = "Found a recent recipe from the same prompt"
+		}
+		c.JSON(http.StatusOK, gin.H{"recipe": recipeResponse, "message": message, "reused": reused})
+		return
+	}
+
+	status, err := h.Service.WaitForGeneration(c.Request.Context(), recipeResponse.ID, user)
+	if err != nil {
+		// The client went away, the recipe is still generated
+		if c.Request.Context().Err() != nil {
+			return
+		}
+		requestLogger(c).Error("waiting for recipe generation", "recipe_id", recipeResponse.ID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get the generated recipe", "recipe_id": recipeResponse.ID})
+		return
+	}
+	if status.Status != models.GenerationComplete {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Recipe generation failed", "recipe_id": recipeResponse.ID})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recipe": status.Recipe, "message": "Recipe generated", "reused": reused})
+}
+
+// GenerateRecipeFromIngredients creates a new recipe, generated from the ingredients the user has on hand.
+// It responds right away with the pending recipe like GenerateRecipeWithChat. The completed recipe lists which of the
+// ingredients it uses and which others the user needs.
+func (h *RecipeHandler) GenerateRecipeFromIngredients(c *gin.Context) {
+	// Retrieve the user from the context
+	user, err := util.GetUserFromContext(c)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	// go h.Service.FinishGenerateRecipeWithChat(recipe, user, request.UserPrompt)
+	var request struct {
+		Ingredients []string `json:"ingredients"`
+		Note        string   `json:"note"`     // Optional, what the user would like to make
+		Occasion    string   `json:"occasion"` // Optional, a key or name from the occasion list
+		Force       bool     `json:"force"`    // Optional, skips the generation cache for a fresh recipe
+	}
+
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	language := util.ResolveLocale(h.Service.Cfg, user, c.Request)
+	recipeResponse, err := h.Service.InitGenerateRecipeFromIngredients(c.Request.Context(), user, request.Ingredients, request.Note, language, request.Occasion, request.Force)
+	if err != nil {
+		switch e := err.(type) {
+		case service.ValidationError:
+			c.JSON(http.StatusBadRequest, gin.H{"error": e.Error()})
+		case service.TooManyRequestsError:
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": e.Error()})
+		default:
+			requestLogger(c).Error("generating recipe from ingredients", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": e.Error()})
+		}
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{"recipe": recipeResponse, "message": "Generating recipe"})
 }
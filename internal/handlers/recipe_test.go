@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/windoze95/saltybytes-api/internal/config"
+	"github.com/windoze95/saltybytes-api/internal/models"
+	"github.com/windoze95/saltybytes-api/internal/repository"
+	"github.com/windoze95/saltybytes-api/internal/service"
+	"github.com/windoze95/saltybytes-api/internal/service/servicetest"
+	"github.com/windoze95/saltybytes-api/internal/util"
+)
+
+// serveRecipeHandler serves a request to a recipe handler, with a recipe service backed by the mock repository.
+func serveRecipeHandler(repo *servicetest.MockRecipeRepository, route string, handler func(h *RecipeHandler) gin.HandlerFunc, target string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	h := NewRecipeHandler(service.NewRecipeService(&config.Config{}, repo, &servicetest.MockUserRepository{}))
+
+	r := gin.New()
+	r.GET(route, handler(h))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+	return w
+}
+
+func TestGetRecipePDF(t *testing.T) {
+	repo := &servicetest.MockRecipeRepository{
+		GetRecipeByIDFunc: func(recipeID uint) (*models.Recipe, error) {
+			return &models.Recipe{
+				Model: gorm.Model{ID: recipeID},
+				RecipeDef: models.RecipeDef{
+					Title:        "Tomato Soup",
+					Ingredients:  models.Ingredients{{Name: "Tomatoes", Unit: "g", Amount: 500}},
+					Instructions: []string{"Simmer the tomatoes", "Blend"},
+					CookTime:     30,
+				},
+			}, nil
+		},
+	}
+
+	w := serveRecipeHandler(repo, "/recipes/:recipe_id/pdf", func(h *RecipeHandler) gin.HandlerFunc { return h.GetRecipePDF }, "/recipes/3/pdf")
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	if contentType := w.Header().Get("Content-Type"); contentType != "application/pdf" {
+		t.Fatalf("Content-Type = %q, want application/pdf", contentType)
+	}
+	if disposition := w.Header().Get("Content-Disposition"); !strings.HasPrefix(disposition, "attachment; filename=") || !strings.Contains(disposition, ".pdf") {
+		t.Fatalf("Content-Disposition = %q, want a PDF attachment", disposition)
+	}
+	if !bytes.HasPrefix(w.Body.Bytes(), []byte("%PDF-")) || w.Body.Len() <= len("%PDF-") {
+		t.Fatalf("body isn't a PDF, it starts with %q", w.Body.Bytes()[:min(w.Body.Len(), 16)])
+	}
+}
+
+func TestGetRecipePDFErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		target     string
+		err        error
+		wantStatus int
+		wantError  string
+	}{
+		{"invalid ID", "/recipes/abc/pdf", nil, http.StatusBadRequest, "Invalid recipe ID"},
+		{"not found", "/recipes/3/pdf", repository.NotFoundError{}, http.StatusNotFound, ""},
+		{"internal error", "/recipes/3/pdf", errors.New("pq: connection to 10.0.0.5 refused"), http.StatusInternalServerError, "Failed to render recipe PDF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &servicetest.MockRecipeRepository{
+				GetRecipeByIDFunc: func(recipeID uint) (*models.Recipe, error) {
+					return nil, tt.err
+				},
+			}
+
+			w := serveRecipeHandler(repo, "/recipes/:recipe_id/pdf", func(h *RecipeHandler) gin.HandlerFunc { return h.GetRecipePDF }, tt.target)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			// Internal errors are logged, never sent to the client
+			if strings.Contains(w.Body.String(), "10.0.0.5") {
+				t.Fatalf("response leaks the internal error: %s", w.Body.String())
+			}
+			if tt.wantError != "" && !strings.Contains(w.Body.String(), tt.wantError) {
+				t.Fatalf("response %s, want error %q", w.Body.String(), tt.wantError)
+			}
+		})
+	}
+}
+
+// pagedRecipes returns the page of a listing of total recipes at limit and offset.
+func pagedRecipes(total, limit, offset int) []models.Recipe {
+	var recipes []models.Recipe
+	for id := offset + 1; id <= total && len(recipes) < limit; id++ {
+		recipes = append(recipes, models.Recipe{Model: gorm.Model{ID: uint(id)}, CreatedBy: &models.User{Username: "chef"}})
+	}
+	return recipes
+}
+
+func TestListUserRecipes(t *testing.T) {
+	tests := []struct {
+		name        string
+		query       string
+		wantLimit   int
+		wantOffset  int
+		wantCount   int
+		wantHasMore bool
+	}{
+		{"defaults", "", 20, 0, 20, true},
+		{"last page", "?limit=10&offset=20", 10, 20, 5, false},
+		{"limit is capped", "?limit=1000", 100, 0, 25, false},
+		{"limit below 1", "?limit=0&offset=-5", 1, 0, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotUserID uint
+			var gotLimit, gotOffset int
+			list := func(userID uint, filter util.RecipeFilter, limit, offset int) ([]models.Recipe, int64, error) {
+				gotUserID, gotLimit, gotOffset = userID, limit, offset
+				return pagedRecipes(25, limit, offset), 25, nil
+			}
+
+			routes := []struct {
+				path    string
+				repo    *servicetest.MockRecipeRepository
+				handler func(h *RecipeHandler) gin.HandlerFunc
+			}{
+				{"recipes", &servicetest.MockRecipeRepository{ListRecipesByUserFunc: list}, func(h *RecipeHandler) gin.HandlerFunc { return h.ListUserRecipes }},
+				{"collected", &servicetest.MockRecipeRepository{ListCollectedRecipesByUserFunc: list}, func(h *RecipeHandler) gin.HandlerFunc { return h.ListUserCollectedRecipes }},
+			}
+			for _, route := range routes {
+				w := serveRecipeHandler(route.repo, "/users/:user_id/"+route.path, route.handler, "/users/4/"+route.path+tt.query)
+				if w.Code != http.StatusOK {
+					t.Fatalf("%s status = %d, want %d: %s", route.path, w.Code, http.StatusOK, w.Body.String())
+				}
+				if gotUserID != 4 || gotLimit != tt.wantLimit || gotOffset != tt.wantOffset {
+					t.Fatalf("%s listed user %d with limit %d and offset %d, want user 4 with %d and %d", route.path, gotUserID, gotLimit, gotOffset, tt.wantLimit, tt.wantOffset)
+				}
+
+				var response struct {
+					Recipes []service.RecipeResponse `json:"recipes"`
+					Total   int64                    `json:"total"`
+					HasMore bool                     `json:"has_more"`
+				}
+				if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+					t.Fatalf("response isn't JSON: %v", err)
+				}
+				if len(response.Recipes) != tt.wantCount || response.Total != 25 || response.HasMore != tt.wantHasMore {
+					t.Fatalf("%s got %d recipes of %d with has_more %v, want %d of 25 with %v", route.path, len(response.Recipes), response.Total, response.HasMore, tt.wantCount, tt.wantHasMore)
+				}
+			}
+		})
+	}
+}
+
+func TestListUserRecipesInvalid(t *testing.T) {
+	handler := func(h *RecipeHandler) gin.HandlerFunc { return h.ListUserRecipes }
+	for _, target := range []string{"/users/me/recipes", "/users/4/recipes?limit=all", "/users/4/recipes?offset=next"} {
+		// The repository has no funcs, so listing recipes would panic
+		w := serveRecipeHandler(&servicetest.MockRecipeRepository{}, "/users/:user_id/recipes", handler, target)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("%s status = %d, want %d", target, w.Code, http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/windoze95/saltybytes-api/internal/config"
+	"github.com/windoze95/saltybytes-api/internal/models"
+	"github.com/windoze95/saltybytes-api/internal/util"
+)
+
+// unitResponse is the response object for a supported unit.
+type unitResponse struct {
+	Symbol      string          `json:"symbol"`
+	DisplayName string          `json:"display_name"`
+	Kind        models.UnitKind `json:"kind"`
+	Systems     []string        `json:"systems"`
+	BaseUnit    string          `json:"base_unit,omitempty"`
+	ToBase      float64         `json:"to_base,omitempty"`
+}
+
+// ListUnits lists the supported ingredient units with their display names and conversion factors.
+// The display names are in the resolved locale of the request.
+func ListUnits(cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// The user is optional
+		user, _ := util.GetUserFromContext(c)
+		locale := util.ResolveLocale(cfg, user, c.Request)
+
+		units := make([]unitResponse, len(models.Units))
+		for i, unit := range models.Units {
+			units[i] = unitResponse{
+				Symbol:      unit.Symbol,
+				DisplayName: unit.DisplayName(locale),
+				Kind:        unit.Kind,
+				Systems:     unit.Systems,
+				BaseUnit:    unit.BaseUnit(),
+				ToBase:      unit.ToBase,
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{"locale": locale, "units": units})
+	}
+}
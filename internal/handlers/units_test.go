@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	goopenai "github.com/sashabaranov/go-openai"
+	"github.com/sashabaranov/go-openai/jsonschema"
+	"github.com/windoze95/saltybytes-api/internal/config"
+	"github.com/windoze95/saltybytes-api/internal/models"
+	"github.com/windoze95/saltybytes-api/internal/openai"
+	"github.com/windoze95/saltybytes-api/internal/openai/openaitest"
+	"github.com/windoze95/saltybytes-api/internal/util"
+)
+
+// schemaUnits returns the units the recipe generation's function schema lets the model choose from.
+func schemaUnits(t *testing.T) []string {
+	t.Helper()
+	client := &openaitest.MockClient{
+		CreateChatCompletionFunc: func(ctx context.Context, request goopenai.ChatCompletionRequest) (goopenai.ChatCompletionResponse, error) {
+			return openaitest.FunctionCallResponse(request.Model, "create_recipe", openai.FunctionCallArgument{RecipeDef: models.RecipeDef{
+				Title:        "Tomato Soup",
+				Ingredients:  models.Ingredients{{Name: "Tomatoes", Unit: "g", Amount: 500}},
+				Instructions: []string{"Simmer the tomatoes"},
+			}})
+		},
+	}
+	recipeManager := &openai.RecipeManager{Cfg: &config.Config{}, UserPrompt: "tomato soup", RecipeGenerator: client}
+	if err := recipeManager.GenerateRecipeWithChat(); err != nil {
+		t.Fatalf("GenerateRecipeWithChat: %v", err)
+	}
+
+	requests := client.ChatCompletionRequests()
+	if len(requests) == 0 || len(requests[0].Functions) == 0 {
+		t.Fatal("recipe generation didn't request a function call")
+	}
+	parameters, ok := requests[0].Functions[0].Parameters.(jsonschema.Definition)
+	if !ok {
+		t.Fatalf("function parameters are %T, want a jsonschema.Definition", requests[0].Functions[0].Parameters)
+	}
+	ingredients, ok := parameters.Properties["ingredients"]
+	if !ok || ingredients.Items == nil {
+		t.Fatal("function schema has no ingredients")
+	}
+	return ingredients.Items.Properties["unit"].Enum
+}
+
+func TestListUnitsListsSchemaUnits(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	r.GET("/units", ListUnits(&config.Config{}))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/units", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	var response struct {
+		Locale string         `json:"locale"`
+		Units  []unitResponse `json:"units"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("response isn't JSON: %v", err)
+	}
+	listed := make(map[string]unitResponse, len(response.Units))
+	for _, unit := range response.Units {
+		listed[unit.Symbol] = unit
+	}
+
+	enum := schemaUnits(t)
+	if len(enum) == 0 {
+		t.Fatal("function schema doesn't list any units")
+	}
+	for _, symbol := range enum {
+		unit, ok := listed[symbol]
+		if !ok {
+			t.Fatalf("unit %q of the OpenAI schema isn't listed", symbol)
+		}
+		if unit.DisplayName == "" {
+			t.Fatalf("unit %q has no display name", symbol)
+		}
+	}
+}
+
+func TestListUnitsDisplayNamesInLocale(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	r.GET("/units", ListUnits(&config.Config{}))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/units?"+util.LocaleQueryParam+"=es", nil))
+
+	var response struct {
+		Locale string         `json:"locale"`
+		Units  []unitResponse `json:"units"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("response isn't JSON: %v", err)
+	}
+	if response.Locale != "es" {
+		t.Fatalf("locale = %q, want es", response.Locale)
+	}
+	for _, unit := range response.Units {
+		if unit.Symbol == "cup" && unit.DisplayName != "taza" {
+			t.Fatalf("cup display name = %q, want taza", unit.DisplayName)
+		}
+	}
+}
@@ -2,23 +2,25 @@ package handlers
 
 import (
 	"fmt"
-	"log"
 	"net/http"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
+	"github.com/windoze95/saltybytes-api/internal/models"
+	"github.com/windoze95/saltybytes-api/internal/repository"
 	"github.com/windoze95/saltybytes-api/internal/service"
 	"github.com/windoze95/saltybytes-api/internal/util"
 )
 
 // UserHandler is the handler for user-related requests.
 type UserHandler struct {
-	Service *service.UserService
+	Service       *service.UserService
+	RecipeService *service.RecipeService
 }
 
 // NewUserHandler is the constructor function for initializing a new UserHandler.
-func NewUserHandler(userService *service.UserService) *UserHandler {
-	return &UserHandler{Service: userService}
+func NewUserHandler(userService *service.UserService, recipeService *service.RecipeService) *UserHandler {
+	return &UserHandler{Service: userService, RecipeService: recipeService}
 }
 
 // CreateUser creates a new user.
@@ -28,14 +30,24 @@ func (h *UserHandler) CreateUser(c *gin.Context) {
 		FirstName string `json:"first_name"`
 		Email     string `json:"email" binding:"required"`
 		Password  string `json:"password" binding:"required"`
+		// RecaptchaToken is only required when reCAPTCHA is enabled
+		RecaptchaToken string `json:"recaptcha_token"`
 	}
 
 	// Returns error if a required field is not included
-	if err := c.ShouldBindJSON(&newUser); err != nil {
+	if err := bindJSONStrict(c, &newUser); err != nil {
+		if e, ok := err.(unknownFieldError); ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": e.Error()})
+			return
+		}
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Username, email, and password fields are required"})
 		return
 	}
 
+	if h.Service.RecaptchaRequired(false) && !h.verifyRecaptcha(c, newUser.RecaptchaToken) {
+		return
+	}
+
 	// Validate username
 	if err := h.Service.ValidateUsername(newUser.Username); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -57,19 +69,35 @@ func (h *UserHandler) CreateUser(c *gin.Context) {
 	// Create user
 	user, err := h.Service.CreateUser(newUser.Username, newUser.FirstName, newUser.Email, newUser.Password)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		switch e := err.(type) {
+		case service.ConflictError:
+			c.JSON(http.StatusConflict, gin.H{"error": e.Error()})
+		default:
+			requestLogger(c).Error("creating user", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+		}
 		return
 	}
 
+	// Give the new user the welcome recipe, signing up still succeeds without it
+	response := gin.H{"message": "User signed up successfully", "user": user}
+	welcomeRecipe, err := h.RecipeService.CreateWelcomeRecipe(user)
+	if err != nil {
+		requestLogger(c).Error("creating welcome recipe", "user_id", user.ID, "error", err)
+	} else if welcomeRecipe != nil {
+		response["welcome_recipe"] = welcomeRecipe
+	}
+
 	// Log the user in
-	tokenString, err := generateAuthToken(user.ID, h.Service.Cfg.Env.JwtSecretKey.Value())
+	tokenString, err := h.startSession(c, user.ID)
 	if err != nil {
-		log.Printf("error: handlers.LoginUser: %v", err)
+		requestLogger(c).Error("signing up user", "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	response["access_token"] = tokenString
 
-	c.JSON(http.StatusOK, gin.H{"access_token": tokenString, "message": "User signed up successfully", "user": user})
+	c.JSON(http.StatusOK, response)
 }
 
 // LoginUser logs a user in.
@@ -77,13 +105,23 @@ func (h *UserHandler) LoginUser(c *gin.Context) {
 	var userCredentials struct {
 		Username string `json:"username" binding:"required"`
 		Password string `json:"password" binding:"required"`
+		// RecaptchaToken is only required when reCAPTCHA is enforced on login
+		RecaptchaToken string `json:"recaptcha_token"`
 	}
 
-	if err := c.ShouldBindJSON(&userCredentials); err != nil {
+	if err := bindJSONStrict(c, &userCredentials); err != nil {
+		if e, ok := err.(unknownFieldError); ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": e.Error()})
+			return
+		}
 		c.JSON(http.StatusBadRequest, gin.H{"error": "All fields are required"})
 		return
 	}
 
+	if h.Service.RecaptchaRequired(true) && !h.verifyRecaptcha(c, userCredentials.RecaptchaToken) {
+		return
+	}
+
 	userResponse, err := h.Service.LoginUser(userCredentials.Username, userCredentials.Password)
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
@@ -91,9 +129,9 @@ func (h *UserHandler) LoginUser(c *gin.Context) {
 	}
 
 	// Log the user in
-	tokenString, err := generateAuthToken(userResponse.ID, h.Service.Cfg.Env.JwtSecretKey.Value())
+	tokenString, err := h.startSession(c, userResponse.ID)
 	if err != nil {
-		log.Printf("error: handlers.LoginUser: %v", err)
+		requestLogger(c).Error("logging in user", "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -101,14 +139,185 @@ func (h *UserHandler) LoginUser(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"access_token": tokenString, "message": "User logged in successfully", "user": userResponse})
 }
 
-// generateAuthToken generates a JWT token for a user.
-func generateAuthToken(userID uint, secretKey string) (string, error) {
+// LoginWithFacebook logs a user in with a Facebook access token, signing them up on their first login.
+func (h *UserHandler) LoginWithFacebook(c *gin.Context) {
+	var request struct {
+		AccessToken string `json:"access_token" binding:"required"`
+	}
+
+	if err := bindJSONStrict(c, &request); err != nil {
+		if e, ok := err.(unknownFieldError); ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": e.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Access token is required"})
+		return
+	}
+
+	user, created, err := h.Service.LoginWithFacebook(request.AccessToken)
+	if err != nil {
+		switch e := err.(type) {
+		case service.ForbiddenError:
+			c.JSON(http.StatusUnauthorized, gin.H{"error": e.Error()})
+		case service.ConflictError:
+			c.JSON(http.StatusConflict, gin.H{"error": e.Error()})
+		case service.UnavailableError:
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": e.Error()})
+		default:
+			requestLogger(c).Error("logging in with Facebook", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log in with Facebook"})
+		}
+		return
+	}
+
+	response := gin.H{"message": "User logged in successfully", "user": service.ToUserResponse(user)}
+	if created {
+		// Give the new user the welcome recipe, signing up still succeeds without it
+		response["message"] = "User signed up successfully"
+		welcomeRecipe, err := h.RecipeService.CreateWelcomeRecipe(user)
+		if err != nil {
+			requestLogger(c).Error("creating welcome recipe", "user_id", user.ID, "error", err)
+		} else if welcomeRecipe != nil {
+			response["welcome_recipe"] = welcomeRecipe
+		}
+	}
+
+	// Log the user in
+	tokenString, err := h.startSession(c, user.ID)
+	if err != nil {
+		requestLogger(c).Error("logging in with Facebook", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	response["access_token"] = tokenString
+
+	c.JSON(http.StatusOK, response)
+}
+
+// RequestPasswordReset emails a password reset link to the address, if it belongs to a user.
+// The response is the same either way, so it can't be used to find out who has an account.
+func (h *UserHandler) RequestPasswordReset(c *gin.Context) {
+	var request struct {
+		Email string `json:"email" binding:"required"`
+	}
+
+	if err := bindJSONStrict(c, &request); err != nil {
+		if e, ok := err.(unknownFieldError); ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": e.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Email is required"})
+		return
+	}
+
+	if err := h.Service.RequestPasswordReset(request.Email); err != nil {
+		requestLogger(c).Error("requesting password reset", "error", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "If an account uses that email, a password reset link has been sent to it"})
+}
+
+// ConfirmPasswordReset sets a new password with the token from a password reset link.
+func (h *UserHandler) ConfirmPasswordReset(c *gin.Context) {
+	var request struct {
+		Token       string `json:"token" binding:"required"`
+		NewPassword string `json:"new_password" binding:"required"`
+	}
+
+	if err := bindJSONStrict(c, &request); err != nil {
+		if e, ok := err.(unknownFieldError); ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": e.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "All fields are required"})
+		return
+	}
+
+	if err := h.Service.ResetPassword(request.Token, request.NewPassword); err != nil {
+		switch e := err.(type) {
+		case service.ValidationError:
+			c.JSON(http.StatusBadRequest, gin.H{"error": e.Error()})
+		default:
+			requestLogger(c).Error("confirming password reset", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password reset successfully, log in with the new password"})
+}
+
+// verifyRecaptcha verifies a reCAPTCHA token, writing the error response and returning false if it's rejected.
+func (h *UserHandler) verifyRecaptcha(c *gin.Context, token string) bool {
+	if err := h.Service.VerifyRecaptcha(token); err != nil {
+		switch e := err.(type) {
+		case service.ForbiddenError:
+			c.JSON(http.StatusForbidden, gin.H{"error": e.Error()})
+		case service.UnavailableError:
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": e.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": e.Error()})
+		}
+		return false
+	}
+	return true
+}
+
+// startSession records the login from the requesting device and generates the session's token.
+func (h *UserHandler) startSession(c *gin.Context, userID uint) (string, error) {
+	session, err := h.Service.StartSession(userID, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		return "", err
+	}
+	return generateAuthToken(userID, session, h.Service.Cfg.Env.JwtSecretKey.Value())
+}
+
+// RefreshToken exchanges a token that's about to expire for a new one.
+func (h *UserHandler) RefreshToken(c *gin.Context) {
+	userID, err := util.GetUserIDFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	session, err := h.Service.RefreshSession(userID, util.GetTokenIDFromContext(c), util.GetTokenExpiryFromContext(c),
+		c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		switch e := err.(type) {
+		case service.ValidationError:
+			c.JSON(http.StatusBadRequest, gin.H{"error": e.Error()})
+		case repository.NotFoundError:
+			c.JSON(http.StatusUnauthorized, gin.H{"error": e.Error()})
+		default:
+			requestLogger(c).Error("refreshing token", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh token"})
+		}
+		return
+	}
+
+	tokenString, err := generateAuthToken(userID, session, h.Service.Cfg.Env.JwtSecretKey.Value())
+	if err != nil {
+		requestLogger(c).Error("refreshing token", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"access_token": tokenString, "message": "Token refreshed successfully"})
+}
+
+// generateAuthToken generates a JWT token for a user's session.
+func generateAuthToken(userID uint, session *models.UserSession, secretKey string) (string, error) {
 	// Create a new token object, specifying signing method and the claims you would like it to contain.
 	token := jwt.New(jwt.SigningMethodHS256)
 
 	// Set claims
 	claims := token.Claims.(jwt.MapClaims)
 	claims["user_id"] = userID
+	claims["jti"] = session.TokenID
+	claims["iat"] = session.IssuedAt.Unix()
+	if session.ExpiresAt != nil {
+		claims["exp"] = session.ExpiresAt.Unix()
+	}
 
 	// Sign and get the complete encoded token as a string using the secret
 	tokenString, err := token.SignedString([]byte(secretKey))
@@ -153,3 +362,465 @@ func (h *UserHandler) GetUserSettings(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"settings": user.Settings})
 }
+
+// GetUserStats fetches the aggregate stats of a user's recipes and usage.
+func (h *UserHandler) GetUserStats(c *gin.Context) {
+	// Retrieve the user from the context
+	user, err := util.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	stats, err := h.Service.GetUserStats(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"stats": stats})
+}
+
+// GetTokenUsage returns the user's OpenAI token usage and its estimated cost this billing period.
+func (h *UserHandler) GetTokenUsage(c *gin.Context) {
+	// Retrieve the user from the context
+	user, err := util.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	usage, err := h.Service.GetTokenUsage(user)
+	if err != nil {
+		requestLogger(c).Error("getting token usage", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get usage"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"usage": usage})
+}
+
+// LogoutUser logs the user out of the device the request was made from, invalidating its token.
+func (h *UserHandler) LogoutUser(c *gin.Context) {
+	// Retrieve the user from the context
+	user, err := util.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.Service.LogoutUser(user.ID, util.GetTokenIDFromContext(c)); err != nil {
+		switch e := err.(type) {
+		case service.ValidationError:
+			c.JSON(http.StatusBadRequest, gin.H{"error": e.Error()})
+		case repository.NotFoundError:
+			c.JSON(http.StatusNotFound, gin.H{"error": e.Error()})
+		default:
+			requestLogger(c).Error("logging out user", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User logged out successfully"})
+}
+
+// ListSessions lists the user's active login sessions.
+func (h *UserHandler) ListSessions(c *gin.Context) {
+	// Retrieve the user from the context
+	user, err := util.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	sessions, err := h.Service.ListSessions(user.ID, util.GetTokenIDFromContext(c))
+	if err != nil {
+		requestLogger(c).Error("listing sessions", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions})
+}
+
+// RevokeSession revokes one of the user's login sessions, logging out the device it belongs to.
+func (h *UserHandler) RevokeSession(c *gin.Context) {
+	// Retrieve the user from the context
+	user, err := util.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	sessionIDStr := c.Param("session_id")
+	sessionID, err := parseUintParam(sessionIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
+
+	if err := h.Service.RevokeSession(user.ID, sessionID); err != nil {
+		switch e := err.(type) {
+		case repository.NotFoundError:
+			c.JSON(http.StatusNotFound, gin.H{"error": e.Error()})
+		default:
+			requestLogger(c).Error("revoking session", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked"})
+}
+
+// SetUserFeatureFlag enables or disables a beta feature for a user, for admins.
+func (h *UserHandler) SetUserFeatureFlag(c *gin.Context) {
+	// Retrieve the acting admin from the context
+	admin, err := util.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	userIDStr := c.Param("user_id")
+	userID, err := parseUintParam(userIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var request struct {
+		Enabled *bool `json:"enabled" binding:"required"`
+	}
+	if err := bindJSONStrict(c, &request); err != nil {
+		if e, ok := err.(unknownFieldError); ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": e.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The enabled field is required"})
+		return
+	}
+
+	flag := models.FeatureFlag(c.Param("flag"))
+	if err := h.Service.SetFeatureFlag(userID, flag, *request.Enabled); err != nil {
+		switch e := err.(type) {
+		case service.ValidationError:
+			c.JSON(http.StatusBadRequest, gin.H{"error": e.Error()})
+		case repository.NotFoundError:
+			c.JSON(http.StatusNotFound, gin.H{"error": e.Error()})
+		default:
+			requestLogger(c).Error("setting user feature flag", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": e.Error()})
+		}
+		return
+	}
+
+	requestLogger(c).Info("admin set feature flag", "admin_id", admin.ID, "flag", flag, "enabled", *request.Enabled, "user_id", userID)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Feature flag updated"})
+}
+
+// SetUserRole changes a user's role, for admins.
+func (h *UserHandler) SetUserRole(c *gin.Context) {
+	// Retrieve the acting admin from the context
+	admin, err := util.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	userIDStr := c.Param("user_id")
+	userID, err := parseUintParam(userIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var request struct {
+		Role models.Role `json:"role" binding:"required"`
+	}
+	if err := bindJSONStrict(c, &request); err != nil {
+		if e, ok := err.(unknownFieldError); ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": e.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The role field is required"})
+		return
+	}
+
+	if err := h.Service.SetRole(admin, userID, request.Role); err != nil {
+		switch e := err.(type) {
+		case service.ValidationError:
+			c.JSON(http.StatusBadRequest, gin.H{"error": e.Error()})
+		case service.ForbiddenError:
+			c.JSON(http.StatusForbidden, gin.H{"error": e.Error()})
+		case repository.NotFoundError:
+			c.JSON(http.StatusNotFound, gin.H{"error": e.Error()})
+		default:
+			requestLogger(c).Error("setting user role", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update role"})
+		}
+		return
+	}
+
+	requestLogger(c).Info("admin set user role", "admin_id", admin.ID, "role", request.Role, "user_id", userID)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Role updated"})
+}
+
+// RotateOpenAIKeyEncryption re-encrypts the stored personal OpenAI keys with the current encryption key, for admins.
+func (h *UserHandler) RotateOpenAIKeyEncryption(c *gin.Context) {
+	// Retrieve the acting admin from the context
+	admin, err := util.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := h.Service.RotateOpenAIKeyEncryption()
+	if err != nil {
+		switch e := err.(type) {
+		case service.ValidationError:
+			c.JSON(http.StatusBadRequest, gin.H{"error": e.Error()})
+		default:
+			requestLogger(c).Error("rotating OpenAI key encryption", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rotate OpenAI key encryption"})
+		}
+		return
+	}
+
+	requestLogger(c).Info("admin rotated OpenAI key encryption", "admin_id", admin.ID, "reencrypted", result.Reencrypted, "current", result.Current, "failed", len(result.Failed))
+
+	c.JSON(http.StatusOK, gin.H{"result": result})
+}
+
+// BackfillPersonalizations creates the missing personalizations of existing users, for admins.
+func (h *UserHandler) BackfillPersonalizations(c *gin.Context) {
+	// Retrieve the acting admin from the context
+	admin, err := util.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := h.Service.BackfillPersonalizations()
+	if err != nil {
+		requestLogger(c).Error("backfilling personalizations", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to backfill personalizations"})
+		return
+	}
+
+	requestLogger(c).Info("admin backfilled personalizations", "admin_id", admin.ID, "created", result.Created, "uids_assigned", result.UIDsAssigned)
+
+	c.JSON(http.StatusOK, gin.H{"result": result})
+}
+
+// ImportUsers creates users in bulk, reporting the outcome of each row, for admins.
+func (h *UserHandler) ImportUsers(c *gin.Context) {
+	// Retrieve the acting admin from the context
+	admin, err := util.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var request struct {
+		Users []service.UserImportRow `json:"users" binding:"required"`
+	}
+	if err := bindJSONStrict(c, &request); err != nil {
+		if e, ok := err.(unknownFieldError); ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": e.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A users list is required"})
+		return
+	}
+
+	results, err := h.Service.ImportUsers(request.Users)
+	if err != nil {
+		switch e := err.(type) {
+		case service.ValidationError:
+			c.JSON(http.StatusBadRequest, gin.H{"error": e.Error()})
+		default:
+			requestLogger(c).Error("importing users", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import users"})
+		}
+		return
+	}
+
+	created := 0
+	for _, result := range results {
+		if result.Status == service.UserImportCreated {
+			created++
+		}
+	}
+	requestLogger(c).Info("admin imported users", "admin_id", admin.ID, "created", created, "total", len(results))
+
+	c.JSON(http.StatusOK, gin.H{"results": results})
+}
+
+// UpdateUserSettings applies a partial update to a user's settings and personalization.
+func (h *UserHandler) UpdateUserSettings(c *gin.Context) {
+	// Retrieve the user from the context
+	user, err := util.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var update service.SettingsUpdate
+	if err := bindJSONStrict(c, &update); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	settingsResponse, err := h.Service.UpdateSettings(user, &update)
+	if err != nil {
+		switch e := err.(type) {
+		case service.ValidationError:
+			c.JSON(http.StatusBadRequest, gin.H{"error": e.Error()})
+		default:
+			requestLogger(c).Error("updating user settings", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": e.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, settingsResponse)
+}
+
+// UpdateGuidingContent replaces the unit system and requirements of the user's personalization.
+func (h *UserHandler) UpdateGuidingContent(c *gin.Context) {
+	// Retrieve the user from the context
+	user, err := util.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var request struct {
+		UnitSystem   *models.UnitSystem `json:"unit_system" binding:"required"`
+		Requirements string             `json:"requirements"`
+	}
+	if err := bindJSONStrict(c, &request); err != nil {
+		if e, ok := err.(unknownFieldError); ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": e.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A unit system is required"})
+		return
+	}
+
+	if err := h.Service.UpdateGuidingContent(user.ID, *request.UnitSystem, request.Requirements); err != nil {
+		switch e := err.(type) {
+		case service.ValidationError:
+			c.JSON(http.StatusBadRequest, gin.H{"error": e.Error()})
+		default:
+			requestLogger(c).Error("updating personalization", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update personalization"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Personalization updated"})
+}
+
+// UpdateDietaryRestrictions replaces the user's dietary restrictions.
+func (h *UserHandler) UpdateDietaryRestrictions(c *gin.Context) {
+	// Retrieve the user from the context
+	user, err := util.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var request struct {
+		DietaryRestrictions *[]string `json:"dietary_restrictions"`
+	}
+	if err := bindJSONStrict(c, &request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	if request.DietaryRestrictions == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Dietary restrictions are required"})
+		return
+	}
+
+	settingsResponse, err := h.Service.UpdateSettings(user, &service.SettingsUpdate{DietaryRestrictions: request.DietaryRestrictions})
+	if err != nil {
+		switch e := err.(type) {
+		case service.ValidationError:
+			c.JSON(http.StatusBadRequest, gin.H{"error": e.Error()})
+		default:
+			requestLogger(c).Error("updating dietary restrictions", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": e.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, settingsResponse)
+}
+
+// UpdateAllergenExclusions replaces the allergens the user avoids.
+func (h *UserHandler) UpdateAllergenExclusions(c *gin.Context) {
+	// Retrieve the user from the context
+	user, err := util.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var request struct {
+		AllergenExclusions *[]string `json:"allergen_exclusions"`
+	}
+	if err := bindJSONStrict(c, &request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	if request.AllergenExclusions == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Allergen exclusions are required"})
+		return
+	}
+
+	settingsResponse, err := h.Service.UpdateSettings(user, &service.SettingsUpdate{AllergenExclusions: request.AllergenExclusions})
+	if err != nil {
+		switch e := err.(type) {
+		case service.ValidationError:
+			c.JSON(http.StatusBadRequest, gin.H{"error": e.Error()})
+		default:
+			requestLogger(c).Error("updating allergen exclusions", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": e.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, settingsResponse)
+}
+
+// HandleStripeWebhook applies a Stripe subscription event to the subscription of the user it's for.
+// The raw body is read, since the Stripe signature is computed over it.
+func (h *UserHandler) HandleStripeWebhook(c *gin.Context) {
+	payload, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+		return
+	}
+
+	if err := h.Service.HandleStripeWebhook(payload, c.GetHeader("Stripe-Signature")); err != nil {
+		switch e := err.(type) {
+		case service.ValidationError:
+			c.JSON(http.StatusBadRequest, gin.H{"error": e.Error()})
+		case service.UnavailableError:
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": e.Error()})
+		default:
+			requestLogger(c).Error("handling Stripe webhook", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to handle Stripe event"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"received": true})
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/windoze95/saltybytes-api/internal/config"
+	"github.com/windoze95/saltybytes-api/internal/models"
+	"github.com/windoze95/saltybytes-api/internal/service"
+	"github.com/windoze95/saltybytes-api/internal/service/servicetest"
+)
+
+func TestUpdateUserSettingsRejectsUnknownFields(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name        string
+		body        string
+		wantStatus  int
+		wantField   string
+		wantUpdated bool
+	}{
+		{"known fields", `{"keep_screen_awake": true}`, http.StatusOK, "", true},
+		{"typo'd field", `{"keepScreenAwake": true}`, http.StatusBadRequest, "keepScreenAwake", false},
+		{"typo'd field among known ones", `{"keep_screen_awake": true, "apiKey": "sk-test"}`, http.StatusBadRequest, "apiKey", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			updated := false
+			repo := &servicetest.MockUserRepository{
+				UpdateSettingsAndPersonalizationFunc: func(userID uint, applyChanges func(*models.UserSettings, *models.Personalization) error) error {
+					updated = true
+					return applyChanges(&models.UserSettings{}, &models.Personalization{})
+				},
+				GetUserByIDFunc: func(userID uint) (*models.User, error) {
+					return &models.User{Model: gorm.Model{ID: userID}, Settings: &models.UserSettings{}, Personalization: &models.Personalization{}}, nil
+				},
+			}
+			h := NewUserHandler(service.NewUserService(&config.Config{}, repo, nil), nil)
+
+			r := gin.New()
+			r.PATCH("/users/settings", func(c *gin.Context) {
+				c.Set("user", &models.User{Model: gorm.Model{ID: 1}})
+			}, h.UpdateUserSettings)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodPatch, "/users/settings", strings.NewReader(tt.body)))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			// The error names the unexpected field
+			var response struct {
+				Error string `json:"error"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("response isn't JSON: %v", err)
+			}
+			if tt.wantField != "" && !strings.Contains(response.Error, `unexpected field "`+tt.wantField+`"`) {
+				t.Fatalf("error = %q, want it to name %s", response.Error, tt.wantField)
+			}
+			if updated != tt.wantUpdated {
+				t.Fatalf("updated = %v, want %v", updated, tt.wantUpdated)
+			}
+		})
+	}
+}
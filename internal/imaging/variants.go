@@ -0,0 +1,70 @@
+package imaging
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	_ "image/png" // Register the PNG decoder for the DALL-E images
+
+	"github.com/chai2010/webp"
+	"golang.org/x/image/draw"
+)
+
+// Defaults used when the variant options aren't configured.
+const (
+	defaultThumbnailWidth = 256
+	defaultWebPQuality    = 80
+	thumbnailJPEGQuality  = 80
+)
+
+// Decode decodes a recipe image.
+func Decode(imgBytes []byte) (image.Image, error) {
+	img, _, err := image.Decode(bytes.NewReader(imgBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	return img, nil
+}
+
+// EncodeWebP encodes an image as a lossy WebP at the quality, from 1 to 100.
+func EncodeWebP(img image.Image, quality int) ([]byte, error) {
+	if quality <= 0 || quality > 100 {
+		quality = defaultWebPQuality
+	}
+
+	var buf bytes.Buffer
+	if err := webp.Encode(&buf, img, &webp.Options{Quality: float32(quality)}); err != nil {
+		return nil, fmt.Errorf("failed to encode WebP: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Thumbnail scales an image down to the width, keeping its aspect ratio, and encodes it as a JPEG.
+// Images narrower than the width aren't scaled up.
+func Thumbnail(img image.Image, width int) ([]byte, error) {
+	if width <= 0 {
+		width = defaultThumbnailWidth
+	}
+
+	bounds := img.Bounds()
+	if bounds.Dx() == 0 || bounds.Dy() == 0 {
+		return nil, fmt.Errorf("failed to create thumbnail: image is empty")
+	}
+	if bounds.Dx() < width {
+		width = bounds.Dx()
+	}
+	height := bounds.Dy() * width / bounds.Dx()
+	if height < 1 {
+		height = 1
+	}
+
+	thumb := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(thumb, thumb.Bounds(), img, bounds, draw.Src, nil)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: thumbnailJPEGQuality}); err != nil {
+		return nil, fmt.Errorf("failed to encode thumbnail: %w", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,84 @@
+// Package mail sends the emails of account flows, such as password resets.
+package mail
+
+import (
+	"fmt"
+	"log"
+	"net"
+	netmail "net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+)
+
+// Sender sends plain text emails.
+type Sender interface {
+	Send(to, subject, body string) error
+}
+
+// Make sure LogSender and SMTPSender are Senders.
+var (
+	_ Sender = LogSender{}
+	_ Sender = (*SMTPSender)(nil)
+)
+
+// LogSender logs emails instead of sending them, for development and deployments without an SMTP server.
+type LogSender struct{}
+
+// Send logs the email.
+func (LogSender) Send(to, subject, body string) error {
+	log.Printf("Email to %s: %s\n%s", to, subject, body)
+	return nil
+}
+
+// SMTPSender sends emails through an SMTP server.
+type SMTPSender struct {
+	From     string
+	Host     string
+	Port     int
+	Username string
+	Password string
+}
+
+// NewSMTPSender creates an SMTPSender. Without a username, emails are sent unauthenticated.
+func NewSMTPSender(from, host string, port int, username, password string) *SMTPSender {
+	return &SMTPSender{
+		From:     from,
+		Host:     host,
+		Port:     port,
+		Username: username,
+		Password: password,
+	}
+}
+
+// Send sends the email.
+func (s *SMTPSender) Send(to, subject, body string) error {
+	// Headers can't contain line breaks, or a recipient could inject their own
+	if strings.ContainsAny(to, "\r\n") || strings.ContainsAny(subject, "\r\n") {
+		return fmt.Errorf("invalid email header")
+	}
+
+	// The envelope sender is the bare address, From may include a display name
+	from, err := netmail.ParseAddress(s.From)
+	if err != nil {
+		return fmt.Errorf("invalid sender address: %v", err)
+	}
+
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+
+	message := "From: " + s.From + "\r\n" +
+		"To: " + to + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" + body
+
+	addr := net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+	if err := smtp.SendMail(addr, auth, from.Address, []string{to}, []byte(message)); err != nil {
+		return fmt.Errorf("error sending email: %v", err)
+	}
+	return nil
+}
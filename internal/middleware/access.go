@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/windoze95/saltybytes-api/internal/models"
+	"github.com/windoze95/saltybytes-api/internal/util"
+)
+
+// RequireAdmin rejects requests from users who aren't admins.
+// It must run after AttachUserToContext.
+func RequireAdmin() gin.HandlerFunc {
+	return RequireRole(models.RoleAdmin)
+}
+
+// RequireModerator rejects requests from users who aren't moderators or admins.
+// It must run after AttachUserToContext.
+func RequireModerator() gin.HandlerFunc {
+	return RequireRole(models.RoleModerator)
+}
+
+// RequireRole rejects requests from users who don't have the role or one that's allowed more.
+// It must run after AttachUserToContext.
+func RequireRole(role models.Role) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := util.GetUserFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			c.Abort()
+			return
+		}
+
+		if !user.HasRole(role) {
+			// e.g. "Admin access required"
+			name := string(role)
+			c.JSON(http.StatusForbidden, gin.H{"error": strings.ToUpper(name[:1]) + name[1:] + " access required"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireFeature rejects requests from users who don't have the beta feature enabled.
+// It must run after AttachUserToContext.
+func RequireFeature(flag models.FeatureFlag) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := util.GetUserFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			c.Abort()
+			return
+		}
+
+		if !user.HasFeature(flag) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "This feature is not enabled for your account"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/windoze95/saltybytes-api/internal/models"
+)
+
+func TestRequireFeature(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name        string
+		user        *models.User
+		wantStatus  int
+		wantHandled bool
+	}{
+		{"flag on", &models.User{Model: gorm.Model{ID: 1}, FeatureFlags: []string{string(models.FeatureStreaming)}}, http.StatusOK, true},
+		{"flag off", &models.User{Model: gorm.Model{ID: 1}}, http.StatusForbidden, false},
+		{"other flag on", &models.User{Model: gorm.Model{ID: 1}, FeatureFlags: []string{string(models.FeatureMealPlans)}}, http.StatusForbidden, false},
+		{"no user", nil, http.StatusUnauthorized, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handled := false
+			r := gin.New()
+			r.GET("/", func(c *gin.Context) {
+				if tt.user != nil {
+					c.Set("user", tt.user)
+				}
+			}, RequireFeature(models.FeatureStreaming), func(c *gin.Context) {
+				handled = true
+				c.Status(http.StatusOK)
+			})
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if handled != tt.wantHandled {
+				t.Fatalf("handled = %v, want %v", handled, tt.wantHandled)
+			}
+		})
+	}
+}
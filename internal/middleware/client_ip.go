@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"net"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ForwardedClientIP sets the address of each request to the client IP the trusted proxies in front of the API saw,
+// so c.ClientIP() and everything keyed on it, like rate limiting, sees the client instead of the last proxy.
+// Each of the hops proxies appends the address it was connected from to X-Forwarded-For, so the client IP is hops
+// entries from the end. Entries before it were sent by the client, and can't be trusted.
+// Requests with fewer entries didn't come through the proxies, and keep the address of their connection.
+func ForwardedClientIP(hops int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if hops <= 0 {
+			c.Next()
+			return
+		}
+
+		var entries []string
+		for _, header := range c.Request.Header.Values("X-Forwarded-For") {
+			entries = append(entries, strings.Split(header, ",")...)
+		}
+
+		if len(entries) >= hops {
+			if ip := net.ParseIP(strings.TrimSpace(entries[len(entries)-hops])); ip != nil {
+				port := "0"
+				if _, remotePort, err := net.SplitHostPort(c.Request.RemoteAddr); err == nil {
+					port = remotePort
+				}
+				c.Request.RemoteAddr = net.JoinHostPort(ip.String(), port)
+			}
+		}
+
+		c.Next()
+	}
+}
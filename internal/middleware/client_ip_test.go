@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// proxiedRequest returns a request from the proxy at 10.0.0.1 with the X-Forwarded-For headers.
+func proxiedRequest(forwardedFor ...string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.1:4321"
+	for _, header := range forwardedFor {
+		req.Header.Add("X-Forwarded-For", header)
+	}
+	return req
+}
+
+func TestForwardedClientIP(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name         string
+		hops         int
+		forwardedFor []string
+		want         string
+	}{
+		{"no hops", 0, []string{"203.0.113.7"}, "10.0.0.1"},
+		{"one hop", 1, []string{"203.0.113.7"}, "203.0.113.7"},
+		// An IP the client put in the header itself doesn't change which is the client's
+		{"spoofed entry", 1, []string{"198.51.100.1, 203.0.113.7"}, "203.0.113.7"},
+		{"two hops", 2, []string{"198.51.100.1, 203.0.113.7", "10.0.0.2"}, "203.0.113.7"},
+		{"fewer entries than hops", 2, []string{"203.0.113.7"}, "10.0.0.1"},
+		{"not forwarded", 1, nil, "10.0.0.1"},
+		{"invalid entry", 1, []string{"unknown"}, "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			// Like the router with hops set, gin trusts no proxy itself
+			r.SetTrustedProxies(nil)
+			r.Use(ForwardedClientIP(tt.hops))
+			var clientIP string
+			r.GET("/", func(c *gin.Context) {
+				clientIP = c.ClientIP()
+			})
+
+			r.ServeHTTP(httptest.NewRecorder(), proxiedRequest(tt.forwardedFor...))
+			if clientIP != tt.want {
+				t.Fatalf("client IP = %s, want %s", clientIP, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimitByIPBehindProxy(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	r.SetTrustedProxies(nil)
+	r.Use(ForwardedClientIP(1), RateLimitByIP(1, time.Hour, time.Hour))
+	r.GET("/", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	requests := []struct {
+		name         string
+		forwardedFor string
+		want         int
+	}{
+		{"first client", "203.0.113.7", http.StatusOK},
+		// Every request comes from the proxy, but distinct clients get distinct limiters
+		{"second client", "203.0.113.8", http.StatusOK},
+		{"first client again", "203.0.113.7", http.StatusTooManyRequests},
+		{"first client with a spoofed entry", "198.51.100.1, 203.0.113.7", http.StatusTooManyRequests},
+	}
+	for _, request := range requests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, proxiedRequest(request.forwardedFor))
+		if w.Code != request.want {
+			t.Fatalf("%s: status = %d, want %d", request.name, w.Code, request.want)
+		}
+	}
+}
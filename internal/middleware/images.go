@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ImageCORS sets the CORS headers of proxied images for the allowed origins, "*" allows any origin.
+// Images are served without credentials, so they can be drawn to a canvas without tainting it.
+func ImageCORS(allowedOrigins []string) gin.HandlerFunc {
+	allowAny := false
+	allowed := make(map[string]bool, len(allowedOrigins))
+	for _, origin := range allowedOrigins {
+		if origin == "*" {
+			allowAny = true
+		}
+		allowed[origin] = true
+	}
+
+	return func(c *gin.Context) {
+		// The allowed origin depends on the request's origin
+		c.Writer.Header().Add("Vary", "Origin")
+
+		origin := c.GetHeader("Origin")
+		if origin != "" && (allowAny || allowed[origin]) {
+			if allowAny {
+				c.Header("Access-Control-Allow-Origin", "*")
+			} else {
+				c.Header("Access-Control-Allow-Origin", origin)
+			}
+			c.Header("Access-Control-Allow-Methods", "GET, OPTIONS")
+			c.Header("Cross-Origin-Resource-Policy", "cross-origin")
+		}
+
+		// Answer preflight requests
+		if c.Request.Method == http.MethodOptions {
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestImageCORS(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name           string
+		allowedOrigins []string
+		method         string
+		origin         string
+		wantAllow      string
+		wantStatus     int
+	}{
+		{"allowed origin", []string{"https://app.saltybytes.ai"}, http.MethodGet, "https://app.saltybytes.ai", "https://app.saltybytes.ai", http.StatusOK},
+		{"other origin", []string{"https://app.saltybytes.ai"}, http.MethodGet, "https://evil.example", "", http.StatusOK},
+		{"any origin", []string{"*"}, http.MethodGet, "https://evil.example", "*", http.StatusOK},
+		{"no origin", []string{"*"}, http.MethodGet, "", "", http.StatusOK},
+		{"preflight", []string{"https://app.saltybytes.ai"}, http.MethodOptions, "https://app.saltybytes.ai", "https://app.saltybytes.ai", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.Use(ImageCORS(tt.allowedOrigins))
+			r.GET("/images/:recipe_id", func(c *gin.Context) {
+				c.Data(http.StatusOK, "image/jpeg", []byte("image"))
+			})
+			r.OPTIONS("/images/:recipe_id", func(c *gin.Context) {
+				t.Fatal("preflight reached the handler")
+			})
+
+			req := httptest.NewRequest(tt.method, "/images/3", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.wantAllow {
+				t.Fatalf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantAllow)
+			}
+			if got := w.Header().Get("Vary"); got != "Origin" {
+				t.Fatalf("Vary = %q, want Origin", got)
+			}
+		})
+	}
+}
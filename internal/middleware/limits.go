@@ -0,0 +1,153 @@
+package middleware
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/windoze95/saltybytes-api/internal/logging"
+	"github.com/windoze95/saltybytes-api/internal/service"
+	"github.com/windoze95/saltybytes-api/internal/util"
+)
+
+// EnforceDailyGenerationCap rejects recipe generation once the user has hit their daily cap.
+// The generation is counted before the handler runs so concurrent requests can't go over the cap, and is given back
+// if the handler fails, sets generation_reused in the context, or the generation fails in the background,
+// like EnforceSubscriptionQuota.
+// It must run after AttachUserToContext.
+func EnforceDailyGenerationCap(userService *service.UserService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := util.GetUserFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
+
+		day, err := userService.ConsumeDailyGeneration(user)
+		if err != nil {
+			switch e := err.(type) {
+			case service.TooManyRequestsError:
+				c.JSON(http.StatusTooManyRequests, gin.H{"error": e.Error()})
+			default:
+				c.JSON(http.StatusInternalServerError, gin.H{"error": e.Error()})
+			}
+			c.Abort()
+			return
+		}
+
+		if day.IsZero() {
+			c.Next()
+			return
+		}
+
+		logger := logging.FromContext(c.Request.Context())
+		var restore sync.Once
+		refund := func() {
+			restore.Do(func() {
+				if err := userService.RestoreDailyGeneration(user, day); err != nil {
+					logger.Error("restoring daily generation", "user_id", user.ID, "error", err)
+				}
+			})
+		}
+		c.Request = c.Request.WithContext(service.WithGenerationRefund(c.Request.Context(), refund))
+
+		c.Next()
+
+		if c.Writer.Status() >= http.StatusBadRequest || c.GetBool("generation_reused") {
+			refund()
+		}
+	}
+}
+
+// EnforceSubscriptionQuota rejects recipe generation once the user has no remaining uses in their billing cycle.
+// The use is spent before the handler runs so concurrent requests can't overspend it, and is restored if the handler
+// fails, or sets generation_reused in the context because it returned an existing recipe instead of generating one.
+// Generations that finish in the background after the handler responded restore it through the request's context
+// if they fail. It's restored at most once.
+// It must run after AttachUserToContext.
+func EnforceSubscriptionQuota(userService *service.UserService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := util.GetUserFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
+
+		spent, err := userService.ConsumeSubscriptionUse(user)
+		if err != nil {
+			switch e := err.(type) {
+			case service.PaymentRequiredError:
+				c.JSON(http.StatusPaymentRequired, gin.H{"error": e.Error()})
+			default:
+				c.JSON(http.StatusInternalServerError, gin.H{"error": e.Error()})
+			}
+			c.Abort()
+			return
+		}
+
+		if !spent {
+			c.Next()
+			return
+		}
+
+		logger := logging.FromContext(c.Request.Context())
+		var restore sync.Once
+		refund := func() {
+			restore.Do(func() {
+				if err := userService.RestoreSubscriptionUse(user); err != nil {
+					logger.Error("restoring remaining use", "user_id", user.ID, "error", err)
+				}
+			})
+		}
+		c.Request = c.Request.WithContext(service.WithGenerationRefund(c.Request.Context(), refund))
+
+		c.Next()
+
+		if c.Writer.Status() >= http.StatusBadRequest || c.GetBool("generation_reused") {
+			refund()
+		}
+	}
+}
+
+// LimitRequestBody rejects requests with a body larger than maxBytes with 413. The body is read before the handler
+// runs, so a body without a Content-Length can't get past the limit either. A non-positive maxBytes disables the limit.
+func LimitRequestBody(maxBytes int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if maxBytes <= 0 || c.Request.Body == nil || c.Request.Body == http.NoBody {
+			c.Next()
+			return
+		}
+
+		if c.Request.ContentLength > maxBytes {
+			rejectRequestBody(c, maxBytes)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes))
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				rejectRequestBody(c, maxBytes)
+				return
+			}
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		c.Next()
+	}
+}
+
+// rejectRequestBody responds that the request body is over the limit.
+func rejectRequestBody(c *gin.Context, maxBytes int64) {
+	c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Request body is larger than %d bytes", maxBytes)})
+	c.Abort()
+}
@@ -0,0 +1,222 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/windoze95/saltybytes-api/internal/config"
+	"github.com/windoze95/saltybytes-api/internal/models"
+	"github.com/windoze95/saltybytes-api/internal/service"
+	"github.com/windoze95/saltybytes-api/internal/service/servicetest"
+)
+
+func TestEnforceDailyGenerationCapRestoresFailedGenerations(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name             string
+		status           int
+		reused           bool
+		backgroundFailed bool
+		wantRestore      bool
+	}{
+		{"generated", http.StatusCreated, false, false, false},
+		{"reused", http.StatusOK, true, false, true},
+		{"rejected", http.StatusBadRequest, false, false, true},
+		{"failed", http.StatusInternalServerError, false, false, true},
+		{"failed in the background", http.StatusOK, false, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var countedDay, restoredDay time.Time
+			restores := 0
+			repo := &servicetest.MockUserRepository{
+				IncrementDailyGenerationsFunc: func(userID uint, day time.Time, dailyCap int) (bool, error) {
+					countedDay = day
+					return true, nil
+				},
+				RestoreDailyGenerationFunc: func(userID uint, day time.Time) error {
+					restoredDay = day
+					restores++
+					return nil
+				},
+			}
+			cfg := &config.Config{}
+			cfg.Limits.DailyGenerationCaps = map[string]int{string(models.Free): 5}
+			userService := service.NewUserService(cfg, repo, nil)
+			user := &models.User{Model: gorm.Model{ID: 1}, Subscription: &models.Subscription{SubscriptionTier: models.Free}}
+
+			var generationCtx context.Context
+			r := gin.New()
+			r.POST("/", func(c *gin.Context) {
+				c.Set("user", user)
+			}, EnforceDailyGenerationCap(userService), func(c *gin.Context) {
+				if tt.reused {
+					c.Set("generation_reused", true)
+				}
+				generationCtx = c.Request.Context()
+				c.Status(tt.status)
+			})
+			r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil))
+
+			// The generation fails after the request was responded to
+			if tt.backgroundFailed {
+				service.RefundGeneration(generationCtx)
+			}
+
+			if countedDay.IsZero() {
+				t.Fatal("generation wasn't counted")
+			}
+			if restored := !restoredDay.IsZero(); restored != tt.wantRestore {
+				t.Fatalf("restored = %v, want %v", restored, tt.wantRestore)
+			}
+			if restores > 1 {
+				t.Fatalf("restored %d generations, want 1", restores)
+			}
+			if tt.wantRestore && !restoredDay.Equal(countedDay) {
+				t.Fatalf("restored day %v, want the counted day %v", restoredDay, countedDay)
+			}
+		})
+	}
+}
+
+func TestEnforceDailyGenerationCapRejectsOverTheCap(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	repo := &servicetest.MockUserRepository{
+		IncrementDailyGenerationsFunc: func(userID uint, day time.Time, dailyCap int) (bool, error) {
+			return false, nil
+		},
+	}
+	cfg := &config.Config{}
+	cfg.Limits.DailyGenerationCaps = map[string]int{string(models.Free): 5}
+	userService := service.NewUserService(cfg, repo, nil)
+	user := &models.User{Model: gorm.Model{ID: 1}, Subscription: &models.Subscription{SubscriptionTier: models.Free}}
+
+	handled := false
+	r := gin.New()
+	r.POST("/", func(c *gin.Context) {
+		c.Set("user", user)
+	}, EnforceDailyGenerationCap(userService), func(c *gin.Context) {
+		handled = true
+	})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", nil))
+
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	if handled {
+		t.Fatal("handler ran over the cap")
+	}
+}
+
+func TestEnforceSubscriptionQuotaRestoresBackgroundFailures(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name             string
+		status           int
+		backgroundFailed bool
+		wantRestores     int
+	}{
+		{"generating", http.StatusOK, false, 0},
+		{"failed in the background", http.StatusOK, true, 1},
+		{"failed while waited for", http.StatusInternalServerError, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			restores := 0
+			repo := &servicetest.MockUserRepository{
+				DecrementRemainingUsesFunc: func(userID uint) (bool, error) {
+					return true, nil
+				},
+				RestoreRemainingUseFunc: func(userID uint) error {
+					restores++
+					return nil
+				},
+			}
+			userService := service.NewUserService(&config.Config{}, repo, nil)
+			user := &models.User{Model: gorm.Model{ID: 1}, Subscription: &models.Subscription{SubscriptionTier: models.Free, ExpiresAt: time.Now().Add(time.Hour)}}
+
+			var generationCtx context.Context
+			r := gin.New()
+			r.POST("/", func(c *gin.Context) {
+				c.Set("user", user)
+			}, EnforceSubscriptionQuota(userService), func(c *gin.Context) {
+				generationCtx = c.Request.Context()
+				c.Status(tt.status)
+			})
+			r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil))
+
+			// The generation fails after the request was responded to
+			if tt.backgroundFailed {
+				service.RefundGeneration(generationCtx)
+			}
+			if restores != tt.wantRestores {
+				t.Fatalf("restored %d uses, want %d", restores, tt.wantRestores)
+			}
+		})
+	}
+}
+
+func TestLimitRequestBody(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name          string
+		maxBytes      int64
+		body          string
+		contentLength bool
+		wantStatus    int
+	}{
+		{"under the limit", 10, "0123456789", true, http.StatusOK},
+		{"oversized", 10, "0123456789a", true, http.StatusRequestEntityTooLarge},
+		{"oversized without a content length", 10, "0123456789a", false, http.StatusRequestEntityTooLarge},
+		{"under the limit without a content length", 10, "0123", false, http.StatusOK},
+		{"disabled", 0, strings.Repeat("a", 1000), true, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var handled string
+			r := gin.New()
+			r.POST("/", LimitRequestBody(tt.maxBytes), func(c *gin.Context) {
+				body, err := io.ReadAll(c.Request.Body)
+				if err != nil {
+					t.Errorf("reading body: %v", err)
+				}
+				handled = string(body)
+				c.Status(http.StatusOK)
+			})
+
+			var body io.Reader = strings.NewReader(tt.body)
+			if !tt.contentLength {
+				// Hide the length, so the body is sent as if it were chunked
+				body = io.MultiReader(body)
+			}
+			req := httptest.NewRequest(http.MethodPost, "/", body)
+			if !tt.contentLength {
+				req.ContentLength = -1
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusOK && handled != tt.body {
+				t.Fatalf("handler read %q, want the whole body", handled)
+			}
+			if tt.wantStatus != http.StatusOK && handled != "" {
+				t.Fatal("handler ran for an oversized body")
+			}
+		})
+	}
+}
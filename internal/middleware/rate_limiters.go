@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/windoze95/saltybytes-api/internal/util"
 	"golang.org/x/time/rate"
 )
 
@@ -17,6 +18,17 @@ type limiterInfo struct {
 
 // RateLimitByIP applies rate limiting to requests per IP address.
 func RateLimitByIP(rps int, cleanupInterval time.Duration, expiration time.Duration) gin.HandlerFunc {
+	return rateLimitByIP(rate.Limit(rps), rps, cleanupInterval, expiration)
+}
+
+// RateLimitByIPPerMinute applies rate limiting to requests per IP address, allowing perMinute requests a minute in
+// bursts of up to perMinute. It's for routes that need a tighter limit than the global one.
+func RateLimitByIPPerMinute(perMinute int, cleanupInterval time.Duration, expiration time.Duration) gin.HandlerFunc {
+	return rateLimitByIP(rate.Every(time.Minute/time.Duration(perMinute)), perMinute, cleanupInterval, expiration)
+}
+
+// rateLimitByIP applies rate limiting to requests per IP address, with a limiter of the limit and burst per address.
+func rateLimitByIP(limit rate.Limit, burst int, cleanupInterval time.Duration, expiration time.Duration) gin.HandlerFunc {
 	var limiters sync.Map
 
 	// Cleanup goroutine
@@ -36,19 +48,123 @@ func RateLimitByIP(rps int, cleanupInterval time.Duration, expiration time.Durat
 
 		// Use LoadOrStore to ensure thread safety
 		actual, _ := limiters.LoadOrStore(ip, &limiterInfo{
-			limiter:  rate.NewLimiter(rate.Limit(rps), rps),
+			limiter:  rate.NewLimiter(limit, burst),
+			lastSeen: time.Now(),
+		})
+
+		info := actual.(*limiterInfo)
+		info.lastSeen = time.Now()
+
+		if !info.limiter.Allow() {
+			// Too many requests
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RateLimitByUser applies rate limiting to requests per user, allowing perMinute requests a minute in bursts of up to perMinute.
+// The user ID must already be in the context, requests without one are rejected.
+func RateLimitByUser(perMinute int, cleanupInterval time.Duration, expiration time.Duration) gin.HandlerFunc {
+	var limiters sync.Map
+
+	// Cleanup goroutine
+	go func() {
+		for range time.Tick(cleanupInterval) {
+			limiters.Range(func(key, value interface{}) bool {
+				if time.Since(value.(*limiterInfo).lastSeen) > expiration {
+					limiters.Delete(key)
+				}
+				return true
+			})
+		}
+	}()
+
+	return func(c *gin.Context) {
+		userID, err := util.GetUserIDFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			c.Abort()
+			return
+		}
+
+		// Use LoadOrStore to ensure thread safety
+		actual, _ := limiters.LoadOrStore(userID, &limiterInfo{
+			limiter:  rate.NewLimiter(rate.Every(time.Minute/time.Duration(perMinute)), perMinute),
+			lastSeen: time.Now(),
+		})
+
+		info := actual.(*limiterInfo)
+		info.lastSeen = time.Now()
+
+		if !info.limiter.Allow() {
+			// Too many requests
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RateLimitPublicOpenAIKey applies rate limiting to requests made on the platform's OpenAI keys.
+// Each user gets their own bucket of perUserPerMinute requests a minute, so one user can't starve the others, and
+// globalPerMinute caps the requests of all users together to protect the keys' budget.
+// Users generating with their personal OpenAI key aren't limited. It must run after AttachUserToContext.
+func RateLimitPublicOpenAIKey(perUserPerMinute int, globalPerMinute int, cleanupInterval time.Duration, expiration time.Duration) gin.HandlerFunc {
+	var limiters sync.Map
+	globalLimiter := rate.NewLimiter(rate.Every(time.Minute/time.Duration(globalPerMinute)), globalPerMinute)
+
+	// Cleanup goroutine
+	go func() {
+		for range time.Tick(cleanupInterval) {
+			limiters.Range(func(key, value interface{}) bool {
+				if time.Since(value.(*limiterInfo).lastSeen) > expiration {
+					limiters.Delete(key)
+				}
+				return true
+			})
+		}
+	}()
+
+	return func(c *gin.Context) {
+		user, err := util.GetUserFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			c.Abort()
+			return
+		}
+
+		if user.Settings != nil && user.Settings.UsesOpenAIKey() {
+			c.Next()
+			return
+		}
+
+		// Use LoadOrStore to ensure thread safety
+		actual, _ := limiters.LoadOrStore(user.ID, &limiterInfo{
+			limiter:  rate.NewLimiter(rate.Every(time.Minute/time.Duration(perUserPerMinute)), perUserPerMinute),
 			lastSeen: time.Now(),
 		})
 
 		info := actual.(*limiterInfo)
 		info.lastSeen = time.Now()
 
+		// The user's own bucket is checked first, so a user over their limit doesn't use up the global one
 		if !info.limiter.Allow() {
 			// Too many requests
 			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests"})
 			c.Abort()
 			return
 		}
+		if !globalLimiter.Allow() {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Recipe generation is busy, please try again shortly"})
+			c.Abort()
+			return
+		}
 
 		c.Next()
 	}
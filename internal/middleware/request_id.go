@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/windoze95/saltybytes-api/internal/logging"
+)
+
+// requestIDHeader is the header the request ID is read from and returned in.
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength is the longest request ID accepted from a client.
+const maxRequestIDLength = 64
+
+// RequestID assigns each request an ID, and logs the request with it once it's handled.
+// A valid ID sent by the client, e.g. by a proxy, is kept, otherwise a new one is generated.
+// The ID is returned in the X-Request-ID header and added to JSON error responses, so users can reference it.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(requestIDHeader)
+		if !isValidRequestID(requestID) {
+			requestID = uuid.NewString()
+		}
+
+		c.Set("request_id", requestID)
+		c.Request = c.Request.WithContext(logging.WithRequestID(c.Request.Context(), requestID))
+		c.Header(requestIDHeader, requestID)
+		c.Writer = &errorResponseWriter{ResponseWriter: c.Writer, requestID: requestID}
+
+		start := time.Now()
+		c.Next()
+
+		logging.FromContext(c.Request.Context()).Info("request",
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", c.Writer.Status(),
+			"latency", time.Since(start).String(),
+			"client_ip", c.ClientIP(),
+		)
+	}
+}
+
+// isValidRequestID checks that a client's request ID is short and only has letters, digits, dashes, and underscores.
+func isValidRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range requestID {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// errorResponseWriter adds the request ID to JSON error responses.
+type errorResponseWriter struct {
+	gin.ResponseWriter
+	requestID string
+	written   bool
+}
+
+// Write writes the body, adding the request ID to it if it's the start of a JSON object in an error response.
+func (w *errorResponseWriter) Write(data []byte) (int, error) {
+	first := !w.written
+	w.written = true
+
+	isJSON := strings.HasPrefix(w.Header().Get("Content-Type"), "application/json")
+	if !first || w.Status() < 400 || !isJSON || !bytes.HasPrefix(data, []byte("{")) {
+		return w.ResponseWriter.Write(data)
+	}
+
+	requestID, err := json.Marshal(w.requestID)
+	if err != nil {
+		return w.ResponseWriter.Write(data)
+	}
+	body := append([]byte(`{"request_id":`), requestID...)
+	if rest := bytes.TrimSpace(data[1:]); !bytes.HasPrefix(rest, []byte("}")) {
+		body = append(body, ',')
+	}
+	body = append(body, data[1:]...)
+
+	if _, err := w.ResponseWriter.Write(body); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// WriteString writes the body like Write.
+func (w *errorResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
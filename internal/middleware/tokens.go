@@ -1,43 +1,66 @@
 package middleware
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
 	"github.com/windoze95/saltybytes-api/internal/config"
+	"github.com/windoze95/saltybytes-api/internal/util"
 )
 
-// VerifyTokenMiddleware verifies the JWT token provided in the Authorization header.
-func VerifyTokenMiddleware(cfg *config.Config) gin.HandlerFunc {
+// VerifyTokenMiddleware verifies the JWT token provided in the Authorization header,
+// rejecting tokens on the blocklist and tokens without an expiry.
+func VerifyTokenMiddleware(cfg *config.Config, blocklist util.TokenBlocklist) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		tokenString := authHeader // Token is directly provided in the Authorization header
 
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			return []byte(cfg.Env.JwtSecretKey.Value()), nil
-		})
+		token, err := jwt.Parse(tokenString, jwtKeyFunc(cfg))
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"message": "Invalid or expired token"})
+			c.JSON(http.StatusUnauthorized, gin.H{"message": tokenErrorMessage(err)})
 			c.Abort()
 			return
 		}
 
 		// Check if the token is valid
 		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-			// Type assert to float64 (default for JSON numbers)
-			if idFloat, ok := claims["user_id"].(float64); ok {
-				// Convert to uint
-				userID := uint(idFloat)
-				// Set the userID in the context
-				c.Set("user_id", userID)
-				c.Next()
-			} else {
+			// Tokens that never expire would be valid for good, tokens without an ID can't even be revoked
+			exp, ok := claims["exp"].(float64)
+			if !ok {
+				c.JSON(http.StatusUnauthorized, gin.H{"message": "Token has no expiry, log in again"})
+				c.Abort()
+				return
+			}
+			userID, err := userIDFromClaims(claims)
+			if err != nil {
 				// Handle error: claim is not a float64
 				c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid user_id in token"})
 				c.Abort()
 				return
 			}
+			// Set the userID in the context
+			c.Set("user_id", userID)
+			// Tokens issued before sessions were tracked don't have an ID, and can't be revoked
+			if tokenID, ok := claims["jti"].(string); ok {
+				blocked, err := blocklist.IsBlocked(tokenID)
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to verify token"})
+					c.Abort()
+					return
+				}
+				if blocked {
+					c.JSON(http.StatusUnauthorized, gin.H{"message": "Invalid or expired token"})
+					c.Abort()
+					return
+				}
+				c.Set("token_id", tokenID)
+			}
+			c.Set("token_expires_at", time.Unix(int64(exp), 0))
+			c.Next()
 		} else {
 			c.JSON(http.StatusUnauthorized, gin.H{"message": "Unauthorized"})
 			c.Abort()
@@ -45,3 +68,77 @@ func VerifyTokenMiddleware(cfg *config.Config) gin.HandlerFunc {
 		}
 	}
 }
+
+// OptionalTokenMiddleware sets the user ID in the context when a valid JWT token is provided in the Authorization header.
+// Unlike VerifyTokenMiddleware, requests without a valid token are let through anonymously, and so are requests with a
+// token on the blocklist, or one that can't be checked against it, or one without an expiry.
+func OptionalTokenMiddleware(cfg *config.Config, blocklist util.TokenBlocklist) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenString := c.GetHeader("Authorization")
+		if tokenString == "" {
+			c.Next()
+			return
+		}
+
+		token, err := jwt.Parse(tokenString, jwtKeyFunc(cfg))
+		if err != nil {
+			c.Next()
+			return
+		}
+
+		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
+			// A token without an expiry is anonymous too, like in VerifyTokenMiddleware
+			if _, ok := claims["exp"].(float64); !ok {
+				c.Next()
+				return
+			}
+			// A revoked token is anonymous, it mustn't see what only its user can
+			if tokenID, ok := claims["jti"].(string); ok {
+				if blocked, err := blocklist.IsBlocked(tokenID); err != nil || blocked {
+					c.Next()
+					return
+				}
+			}
+			if userID, err := userIDFromClaims(claims); err == nil {
+				c.Set("user_id", userID)
+			}
+		}
+		c.Next()
+	}
+}
+
+// jwtKeyFunc returns the key func tokens are verified with. Tokens are only ever signed with HS256, so any other
+// signing method is rejected before the secret is used, which rules out "none" and algorithm-confusion attacks.
+func jwtKeyFunc(cfg *config.Config) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(cfg.Env.JwtSecretKey.Value()), nil
+	}
+}
+
+// tokenErrorMessage explains why a token was rejected, so clients know whether to refresh or log in again.
+func tokenErrorMessage(err error) string {
+	if e, ok := err.(*jwt.ValidationError); ok {
+		switch {
+		case e.Errors&jwt.ValidationErrorExpired != 0:
+			return "Token has expired, log in again"
+		case e.Errors&jwt.ValidationErrorNotValidYet != 0:
+			return "Token is not valid yet"
+		}
+	}
+	return "Invalid or expired token"
+}
+
+// userIDFromClaims reads the user ID from the token claims.
+func userIDFromClaims(claims jwt.MapClaims) (uint, error) {
+	// Type assert to float64 (default for JSON numbers)
+	idFloat, ok := claims["user_id"].(float64)
+	if !ok {
+		return 0, errors.New("invalid user_id in token")
+	}
+
+	// Convert to uint
+	return uint(idFloat), nil
+}
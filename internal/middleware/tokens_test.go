@@ -0,0 +1,216 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/windoze95/saltybytes-api/internal/config"
+	"github.com/windoze95/saltybytes-api/internal/util"
+)
+
+const testSecretEnv = "SALTYBYTES_TEST_JWT_SECRET"
+
+// testConfig returns a config whose JWT secret is read from an environment variable set for the test.
+func testConfig(t *testing.T) *config.Config {
+	t.Helper()
+	os.Setenv(testSecretEnv, "test-secret")
+	t.Cleanup(func() { os.Unsetenv(testSecretEnv) })
+
+	cfg := &config.Config{}
+	cfg.Env.JwtSecretKey = testSecretEnv
+	return cfg
+}
+
+// signHS256 signs the claims with the test secret.
+func signHS256(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+	tokenString, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("test-secret"))
+	if err != nil {
+		t.Fatalf("signing token: %v", err)
+	}
+	return tokenString
+}
+
+// optionalTokenUserID runs OptionalTokenMiddleware on a request with the token, and returns the user ID it set, if any.
+func optionalTokenUserID(t *testing.T, cfg *config.Config, blocklist util.TokenBlocklist, tokenString string) (interface{}, bool) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	var userID interface{}
+	var found bool
+	r := gin.New()
+	r.GET("/", OptionalTokenMiddleware(cfg, blocklist), func(c *gin.Context) {
+		userID, found = c.Get("user_id")
+		c.Status(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if tokenString != "" {
+		req.Header.Set("Authorization", tokenString)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	return userID, found
+}
+
+func TestOptionalTokenMiddleware(t *testing.T) {
+	cfg := testConfig(t)
+	blocklist := util.NewMemoryTokenBlocklist(time.Hour)
+	if err := blocklist.Block("revoked", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("blocking token: %v", err)
+	}
+	exp := time.Now().Add(time.Hour).Unix()
+
+	tests := []struct {
+		name       string
+		token      string
+		wantUserID bool
+	}{
+		{"no token", "", false},
+		{"valid token", signHS256(t, jwt.MapClaims{"user_id": 7, "jti": "active", "exp": exp}), true},
+		{"token without an ID", signHS256(t, jwt.MapClaims{"user_id": 7, "exp": exp}), true},
+		{"revoked token", signHS256(t, jwt.MapClaims{"user_id": 7, "jti": "revoked", "exp": exp}), false},
+		{"token without an expiry", signHS256(t, jwt.MapClaims{"user_id": 7, "jti": "active"}), false},
+		{"garbage", "not-a-token", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID, found := optionalTokenUserID(t, cfg, blocklist, tt.token)
+			if found != tt.wantUserID {
+				t.Fatalf("user_id set = %v, want %v", found, tt.wantUserID)
+			}
+			if found && userID != uint(7) {
+				t.Fatalf("user_id = %v, want 7", userID)
+			}
+		})
+	}
+}
+
+// verifyTokenStatus runs VerifyTokenMiddleware on a request with the token, and returns the response status.
+func verifyTokenStatus(t *testing.T, cfg *config.Config, tokenString string) int {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	r.GET("/", VerifyTokenMiddleware(cfg, util.NewMemoryTokenBlocklist(time.Hour)), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", tokenString)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestVerifyTokenMiddlewareSigningMethods(t *testing.T) {
+	cfg := testConfig(t)
+	claims := jwt.MapClaims{"user_id": 7, "jti": "active", "exp": time.Now().Add(time.Hour).Unix()}
+
+	noneToken, err := jwt.NewWithClaims(jwt.SigningMethodNone, claims).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatalf("signing none token: %v", err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating RSA key: %v", err)
+	}
+	rs256Token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(rsaKey)
+	if err != nil {
+		t.Fatalf("signing RS256 token: %v", err)
+	}
+	hs512Token, err := jwt.NewWithClaims(jwt.SigningMethodHS512, claims).SignedString([]byte("test-secret"))
+	if err != nil {
+		t.Fatalf("signing HS512 token: %v", err)
+	}
+	wrongSecretToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("other-secret"))
+	if err != nil {
+		t.Fatalf("signing token: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		token      string
+		wantStatus int
+	}{
+		{"valid HS256", signHS256(t, claims), http.StatusOK},
+		{"alg none", noneToken, http.StatusUnauthorized},
+		{"RS256", rs256Token, http.StatusUnauthorized},
+		{"HS512", hs512Token, http.StatusUnauthorized},
+		{"wrong secret", wrongSecretToken, http.StatusUnauthorized},
+		{"expired", signHS256(t, jwt.MapClaims{"user_id": 7, "exp": time.Now().Add(-time.Minute).Unix()}), http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status := verifyTokenStatus(t, cfg, tt.token); status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestVerifyTokenMiddlewareExpiry(t *testing.T) {
+	cfg := testConfig(t)
+	now := time.Now()
+	nearExpiry := now.Add(10 * time.Minute).Unix()
+
+	tests := []struct {
+		name        string
+		claims      jwt.MapClaims
+		wantStatus  int
+		wantMessage string
+	}{
+		{"expired", jwt.MapClaims{"user_id": 7, "iat": now.Add(-25 * time.Hour).Unix(), "exp": now.Add(-time.Hour).Unix()}, http.StatusUnauthorized, "Token has expired, log in again"},
+		{"not yet valid", jwt.MapClaims{"user_id": 7, "nbf": now.Add(time.Hour).Unix(), "exp": now.Add(25 * time.Hour).Unix()}, http.StatusUnauthorized, "Token is not valid yet"},
+		{"valid but near expiry", jwt.MapClaims{"user_id": 7, "iat": now.Add(-24 * time.Hour).Unix(), "exp": nearExpiry}, http.StatusOK, ""},
+		{"without an expiry", jwt.MapClaims{"user_id": 7, "jti": "active", "iat": now.Unix()}, http.StatusUnauthorized, "Token has no expiry, log in again"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			var expiresAt time.Time
+			r := gin.New()
+			r.GET("/", VerifyTokenMiddleware(cfg, util.NewMemoryTokenBlocklist(time.Hour)), func(c *gin.Context) {
+				expiresAt = util.GetTokenExpiryFromContext(c)
+				c.Status(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", signHS256(t, tt.claims))
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				var response struct {
+					Message string `json:"message"`
+				}
+				if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+					t.Fatalf("response isn't JSON: %v", err)
+				}
+				if response.Message != tt.wantMessage {
+					t.Fatalf("message = %q, want %q", response.Message, tt.wantMessage)
+				}
+				return
+			}
+			// The expiry is passed on so the token can be refreshed
+			if expiresAt.Unix() != nearExpiry {
+				t.Fatalf("token expiry = %v, want %v", expiresAt, time.Unix(nearExpiry, 0))
+			}
+		})
+	}
+}
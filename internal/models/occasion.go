@@ -0,0 +1,126 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// OccasionWindow is a yearly date range an occasion is timely in, inclusive.
+// A window whose end comes before its start wraps around the new year.
+type OccasionWindow struct {
+	StartMonth time.Month
+	StartDay   int
+	EndMonth   time.Month
+	EndDay     int
+}
+
+// Contains checks if the month and day of t fall in the window.
+func (w OccasionWindow) Contains(t time.Time) bool {
+	day := monthDay(t.Month(), t.Day())
+	start := monthDay(w.StartMonth, w.StartDay)
+	end := monthDay(w.EndMonth, w.EndDay)
+	if start <= end {
+		return day >= start && day <= end
+	}
+	return day >= start || day <= end
+}
+
+// monthDay orders a month and day within the year.
+func monthDay(month time.Month, day int) int {
+	return int(month)*100 + day
+}
+
+// Occasion is a holiday or event a recipe can be themed for.
+type Occasion struct {
+	Key  string
+	Name string
+	// Guidance is added to the system prompt of recipes generated for the occasion.
+	Guidance string
+	// Hashtag is added to the tags of recipes generated for the occasion.
+	Hashtag string
+	// Windows are the dates the occasion is timely, none means it's never suggested by date.
+	Windows []OccasionWindow
+}
+
+// IsTimely checks if the occasion is in season at t.
+func (o *Occasion) IsTimely(t time.Time) bool {
+	for _, window := range o.Windows {
+		if window.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Occasions is the curated list of occasions recipes can be themed for.
+var Occasions = []Occasion{
+	{Key: "new_years", Name: "New Year's", Hashtag: "newyears",
+		Guidance: "Make it festive and shareable, suited to ringing in the new year, such as party bites or a celebratory main.",
+		Windows:  []OccasionWindow{{time.December, 26, time.January, 2}}},
+	{Key: "lunar_new_year", Name: "Lunar New Year", Hashtag: "lunarnewyear",
+		Guidance: "Draw on dishes traditionally served for Lunar New Year, such as dumplings, long noodles, or whole fish, and their symbolism of luck and prosperity.",
+		Windows:  []OccasionWindow{{time.January, 15, time.February, 20}}},
+	{Key: "game_day", Name: "Game Day", Hashtag: "gameday",
+		Guidance: "Make it crowd-pleasing finger food or a big-batch dish that's easy to eat in front of the TV and holds up on a buffet.",
+		Windows:  []OccasionWindow{{time.September, 1, time.February, 15}}},
+	{Key: "valentines_day", Name: "Valentine's Day", Hashtag: "valentinesday",
+		Guidance: "Make it romantic and a little indulgent, sized for two, with an elegant presentation.",
+		Windows:  []OccasionWindow{{time.February, 1, time.February, 14}}},
+	{Key: "st_patricks_day", Name: "St. Patrick's Day", Hashtag: "stpatricksday",
+		Guidance: "Draw on Irish cooking, such as soda bread, stews, or colcannon, or give the dish a green theme.",
+		Windows:  []OccasionWindow{{time.March, 1, time.March, 17}}},
+	{Key: "easter", Name: "Easter", Hashtag: "easter",
+		Guidance: "Make it a springtime holiday dish, such as a brunch centerpiece, a glazed ham or lamb, or a dessert with spring flavors.",
+		Windows:  []OccasionWindow{{time.March, 15, time.April, 25}}},
+	{Key: "cinco_de_mayo", Name: "Cinco de Mayo", Hashtag: "cincodemayo",
+		Guidance: "Draw on Mexican cooking, such as tacos, salsas, or elotes, suited to a festive gathering.",
+		Windows:  []OccasionWindow{{time.April, 25, time.May, 5}}},
+	{Key: "mothers_day", Name: "Mother's Day", Hashtag: "mothersday",
+		Guidance: "Make it a special brunch or dinner someone can prepare to treat their mother, impressive but manageable.",
+		Windows:  []OccasionWindow{{time.May, 1, time.May, 14}}},
+	{Key: "fourth_of_july", Name: "Fourth of July", Hashtag: "fourthofjuly",
+		Guidance: "Make it a summer cookout dish, such as something grilled or a picnic side that travels well, optionally red, white, and blue.",
+		Windows:  []OccasionWindow{{time.June, 20, time.July, 4}}},
+	{Key: "summer_bbq", Name: "Summer BBQ", Hashtag: "summerbbq",
+		Guidance: "Make it for the grill or a backyard barbecue, using peak summer produce.",
+		Windows:  []OccasionWindow{{time.June, 1, time.August, 31}}},
+	{Key: "back_to_school", Name: "Back to School", Hashtag: "backtoschool",
+		Guidance: "Make it quick, kid-friendly, and suited to busy weeknights or packed lunches.",
+		Windows:  []OccasionWindow{{time.August, 10, time.September, 15}}},
+	{Key: "halloween", Name: "Halloween", Hashtag: "halloween",
+		Guidance: "Give it a spooky or playful Halloween theme, or use autumn flavors like pumpkin, apple, and warm spices.",
+		Windows:  []OccasionWindow{{time.October, 1, time.October, 31}}},
+	{Key: "thanksgiving", Name: "Thanksgiving", Hashtag: "thanksgiving",
+		Guidance: "Make it suited to a Thanksgiving feast, such as turkey, a classic side, or a pie, with make-ahead tips for a busy holiday kitchen.",
+		Windows:  []OccasionWindow{{time.November, 1, time.November, 30}}},
+	{Key: "christmas", Name: "Christmas", Hashtag: "christmas",
+		Guidance: "Make it a festive holiday dish, such as a roast centerpiece, a holiday side, or cookies and sweets to share.",
+		Windows:  []OccasionWindow{{time.December, 1, time.December, 25}}},
+	{Key: "kids_birthday", Name: "Kids' Birthday", Hashtag: "kidsbirthday",
+		Guidance: "Make it fun and kid-friendly for a birthday party, colorful, easy to serve to a group, and free of strong or spicy flavors."},
+	{Key: "date_night", Name: "Date Night", Hashtag: "datenight",
+		Guidance: "Make it an impressive dinner for two that can be cooked together or plated beautifully."},
+	{Key: "potluck", Name: "Potluck", Hashtag: "potluck",
+		Guidance: "Make it a dish that feeds a crowd, travels well, and tastes good warm or at room temperature."},
+}
+
+// LookupOccasion finds a curated occasion by its key or name, ignoring case.
+func LookupOccasion(keyOrName string) (*Occasion, bool) {
+	for i := range Occasions {
+		if strings.EqualFold(Occasions[i].Key, keyOrName) || strings.EqualFold(Occasions[i].Name, keyOrName) {
+			return &Occasions[i], true
+		}
+	}
+	return nil, false
+}
+
+// TimelyOccasions returns the occasions in season at t, for suggesting a timely theme.
+func TimelyOccasions(t time.Time) []Occasion {
+	var occasions []Occasion
+	for _, occasion := range Occasions {
+		if occasion.IsTimely(t) {
+			occasions = append(occasions, occasion)
+		}
+	}
+	return occasions
+}
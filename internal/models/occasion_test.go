@@ -0,0 +1,57 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOccasionWindowContains(t *testing.T) {
+	tests := []struct {
+		name   string
+		window OccasionWindow
+		date   time.Time
+		want   bool
+	}{
+		{"inside", OccasionWindow{time.October, 1, time.October, 31}, time.Date(2026, time.October, 16, 0, 0, 0, 0, time.UTC), true},
+		{"first day", OccasionWindow{time.October, 1, time.October, 31}, time.Date(2026, time.October, 1, 0, 0, 0, 0, time.UTC), true},
+		{"last day", OccasionWindow{time.October, 1, time.October, 31}, time.Date(2026, time.October, 31, 23, 0, 0, 0, time.UTC), true},
+		{"outside", OccasionWindow{time.October, 1, time.October, 31}, time.Date(2026, time.November, 1, 0, 0, 0, 0, time.UTC), false},
+		{"wrapped, before the new year", OccasionWindow{time.December, 26, time.January, 2}, time.Date(2026, time.December, 31, 0, 0, 0, 0, time.UTC), true},
+		{"wrapped, after the new year", OccasionWindow{time.December, 26, time.January, 2}, time.Date(2027, time.January, 2, 0, 0, 0, 0, time.UTC), true},
+		{"wrapped, outside", OccasionWindow{time.December, 26, time.January, 2}, time.Date(2027, time.January, 3, 0, 0, 0, 0, time.UTC), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.window.Contains(tt.date); got != tt.want {
+				t.Fatalf("Contains(%s) = %v, want %v", tt.date.Format("Jan 2"), got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLookupOccasion(t *testing.T) {
+	for _, keyOrName := range []string{"game_day", "Game Day", "GAME DAY"} {
+		if occasion, ok := LookupOccasion(keyOrName); !ok || occasion.Key != "game_day" {
+			t.Fatalf("LookupOccasion(%q) = %v, %v, want game_day", keyOrName, occasion, ok)
+		}
+	}
+	if _, ok := LookupOccasion("festivus"); ok {
+		t.Fatal("found an occasion that isn't curated")
+	}
+}
+
+func TestTimelyOccasions(t *testing.T) {
+	occasions := TimelyOccasions(time.Date(2026, time.November, 20, 0, 0, 0, 0, time.UTC))
+
+	keys := make(map[string]bool)
+	for _, occasion := range occasions {
+		keys[occasion.Key] = true
+	}
+	if !keys["thanksgiving"] || !keys["game_day"] {
+		t.Fatalf("timely occasions %v don't include Thanksgiving and Game Day", keys)
+	}
+	// Occasions without dates are never suggested by date
+	if keys["halloween"] || keys["potluck"] {
+		t.Fatalf("timely occasions %v include ones out of season", keys)
+	}
+}
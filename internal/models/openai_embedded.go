@@ -11,13 +11,28 @@ import (
 
 // RecipeDef is a struct that represents the JSON schema that is passed to the OpenAI API for recipe generation using function calling.
 type RecipeDef struct {
-	Title             string         `json:"title" gorm:"column:title"`
-	Ingredients       Ingredients    `json:"ingredients" gorm:"type:jsonb;column:ingredients"`
-	Instructions      pq.StringArray `json:"instructions" gorm:"type:text[];column:instructions"`
-	CookTime          int            `json:"cook_time" gorm:"column:cook_time"`
+	Title        string         `json:"title" gorm:"column:title"`
+	Ingredients  Ingredients    `json:"ingredients" gorm:"type:jsonb;column:ingredients"`
+	Instructions pq.StringArray `json:"instructions" gorm:"type:text[];column:instructions"`
+	CookTime     int            `json:"cook_time" gorm:"column:cook_time"`
+	// Servings is how many people the recipe serves. Recipes from before it was asked for have none.
+	Servings          int            `json:"servings" gorm:"column:servings"`
+	Difficulty        Difficulty     `json:"difficulty" gorm:"type:text;column:difficulty"`
 	ImagePrompt       string         `json:"image_prompt" gorm:"column:image_prompt"`
 	Hashtags          []string       `json:"hashtags"` // Hashtags is shadowed by the Hashtags field in the Recipe model
 	LinkedSuggestions pq.StringArray `json:"linked_recipe_suggestions" gorm:"type:text[];column:linked_recipe_suggestions"`
+	// Allergens are the common allergens the recipe contains, each an Allergen. Recipes from before they were detected have none.
+	Allergens pq.StringArray `json:"allergens" gorm:"type:text[];column:allergens"`
+	// Pairings are the drinks suggested to go with the recipe. They're optional, recipes from before they were
+	// suggested have none.
+	Pairings Pairings `json:"pairings,omitempty" gorm:"type:jsonb;column:pairings"`
+	// UsedIngredients and AdditionalIngredients are set on recipes generated from the ingredients the user has on hand,
+	// the ones it uses and the ones the user still needs.
+	UsedIngredients       pq.StringArray `json:"used_ingredients,omitempty" gorm:"type:text[];column:used_ingredients"`
+	AdditionalIngredients pq.StringArray `json:"additional_ingredients,omitempty" gorm:"type:text[];column:additional_ingredients"`
+	// SubRecipes are the components that are recipes of their own, e.g. the buns of a burger.
+	// They're stored as recipes linked to this one, so they only live in the history and not in a column.
+	SubRecipes []RecipeDef `json:"sub_recipes,omitempty" gorm:"-"`
 	// UnitSystem              UnitSystem   `json:"unit_system"`
 }
 
@@ -69,3 +84,68 @@ func (j *Ingredients) Scan(value interface{}) error {
 func (j Ingredients) Value() (driver.Value, error) {
 	return json.Marshal(j)
 }
+
+// TechniqueExplanation is a struct that represents the explanation of a technique used in a recipe step.
+type TechniqueExplanation struct {
+	Step      string `json:"step"`
+	Technique string `json:"technique"`
+	Why       string `json:"why"`
+}
+
+// TechniqueExplanations is a slice of TechniqueExplanation.
+// This is a workaround for GORM to embed a slice of structs into a JSONB field.
+type TechniqueExplanations []TechniqueExplanation
+
+// Scan is a GORM hook that scans jsonb into TechniqueExplanations.
+func (j *TechniqueExplanations) Scan(value interface{}) error {
+	bytes, ok := value.([]byte)
+	if !ok {
+		return errors.New(fmt.Sprint("Failed to unmarshal JSONB value:", value))
+	}
+
+	result := TechniqueExplanations{}
+	err := json.Unmarshal(bytes, &result)
+	*j = TechniqueExplanations(result)
+
+	return err
+}
+
+// Value is a GORM hook that returns json value of TechniqueExplanations.
+func (j TechniqueExplanations) Value() (driver.Value, error) {
+	return json.Marshal(j)
+}
+
+// Pairing is a struct that represents a drink suggested to go with a recipe.
+type Pairing struct {
+	Beverage  string `json:"beverage"`
+	Rationale string `json:"rationale"`
+}
+
+// Pairings is a slice of Pairing.
+// This is a workaround for GORM to embed a slice of structs into a JSONB field.
+type Pairings []Pairing
+
+// Scan is a GORM hook that scans jsonb into Pairings.
+// Recipes from before pairings were suggested have none, so NULL scans into no pairings.
+func (j *Pairings) Scan(value interface{}) error {
+	if value == nil {
+		*j = nil
+		return nil
+	}
+
+	bytes, ok := value.([]byte)
+	if !ok {
+		return errors.New(fmt.Sprint("Failed to unmarshal JSONB value:", value))
+	}
+
+	result := Pairings{}
+	err := json.Unmarshal(bytes, &result)
+	*j = Pairings(result)
+
+	return err
+}
+
+// Value is a GORM hook that returns json value of Pairings.
+func (j Pairings) Value() (driver.Value, error) {
+	return json.Marshal(j)
+}
@@ -1,8 +1,13 @@
 package models
 
 import (
+	"sort"
+	"strings"
+	"time"
+
 	"github.com/google/uuid"
 	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
 )
 
 // Recipe is the model for a recipe.
@@ -14,12 +19,18 @@ type Recipe struct {
 	// Ingredients  Ingredients    `gorm:"type:jsonb"` // Embedded slice of Ingredient
 	// Instructions pq.StringArray `gorm:"type:text[]"`
 	// CookTime      int
-	UnitSystem    UnitSystem `gorm:"type:int"`
-	LinkedRecipes []*Recipe  `gorm:"many2many:recipe_linked_recipes;association_jointable_foreignkey:link_recipe_id"`
+	UnitSystem     UnitSystem `gorm:"type:int"`
+	LinkedRecipes  []*Recipe  `gorm:"many2many:recipe_linked_recipes;association_jointable_foreignkey:link_recipe_id"`
+	ParentRecipeID *uint      `gorm:"index"` // Recipe this is a sub-recipe of, which owns it
 	// LinkedSuggestions  pq.StringArray `gorm:"type:text[]"`
-	Hashtags []*Tag `gorm:"many2many:recipe_tags;"`
+	Hashtags       []*Tag         `gorm:"many2many:recipe_tags;"`
+	PinnedHashtags pq.StringArray `gorm:"type:text[]"` // Hashtags the owner wants kept when the recipe is retagged
 	// ImagePrompt        string
 	ImageURL           string
+	ThumbnailURL       string // Scaled down copy of the image, empty if it couldn't be created
+	WebPURL            string // Compressed WebP copy of the image, empty if it couldn't be created
+	ImageKey           string // S3 key of the uploaded image, which its variants' keys derive from. Empty for images uploaded under the legacy key
+	ImageUploadPending bool   `gorm:"default:false"` // The image couldn't be uploaded and the placeholder is shown until it is
 	CreatedByID        uint
 	CreatedBy          *User `gorm:"foreignKey:CreatedByID"`
 	PersonalizationUID uuid.UUID
@@ -27,8 +38,18 @@ type Recipe struct {
 	HistoryID          uint           `gorm:"unique;index"`
 	History            *RecipeHistory `gorm:"foreignKey:HistoryID"`
 	ForkedFromID       *uint
-	ForkedFrom         *Recipe    `gorm:"foreignKey:ForkedFromID"`
-	CreateType         RecipeType `gorm:"type:text"`
+	ForkedFrom         *Recipe          `gorm:"foreignKey:ForkedFromID"`
+	CreateType         RecipeType       `gorm:"type:text"`
+	UserPrompt         string           // Prompt the recipe was originally generated from, only shown to the owner
+	PromptHash         string           `gorm:"index"` // Hash of the normalized prompt, to find recent recipes generated from the same one
+	GeneratedWithModel string           // OpenAI model that produced the current recipe def
+	PromptVersion      string           // Version of the system prompt template used for generation
+	Persona            Persona          `gorm:"type:text"`    // Persona the recipe was generated as
+	Occasion           string           `gorm:"default:null"` // Key of the occasion the recipe was themed for, if any
+	GenerationStatus   GenerationStatus `gorm:"type:text;default:'complete'"`
+	AverageRating      float64          `gorm:"default:0"` // Kept in sync with the recipe's ratings, for listing and sorting
+	RatingCount        int              `gorm:"default:0"`
+	ModeratedByID      *uint            // Moderator who removed the recipe, which keeps it out of its owner's trash
 }
 
 // RecipeHistory is the model for a recipe history and the current entry that is being used to represent the recipe.
@@ -48,6 +69,51 @@ type RecipeHistoryEntry struct {
 	Version         int        // To track the order of the entries
 }
 
+// RecipeMade is the model for a user logging that they cooked a recipe.
+// A user can make the same recipe any number of times, each is its own entry.
+type RecipeMade struct {
+	gorm.Model
+	RecipeID uint `gorm:"index"`
+	UserID   uint `gorm:"index"`
+	MadeAt   time.Time
+	Notes    string
+	Rating   *int // Optional, from 1 to 5
+}
+
+// RecipeRating is the model for a user's rating of a recipe they collected. A user has one rating per recipe.
+type RecipeRating struct {
+	gorm.Model
+	RecipeID uint  `gorm:"unique_index:idx_recipe_rating_user"`
+	UserID   uint  `gorm:"unique_index:idx_recipe_rating_user;index"`
+	User     *User `gorm:"foreignKey:UserID"`
+	Score    int   // From 1 to 5
+	Comment  string
+}
+
+// TokenUsage is the model for a user's usage of an OpenAI model while generating or changing a recipe.
+// Rows are kept when the recipe is deleted, they count towards the user's usage either way.
+type TokenUsage struct {
+	gorm.Model
+	UserID           uint   `gorm:"index"`
+	RecipeID         uint   `gorm:"index"`
+	APIModel         string `gorm:"column:api_model"` // OpenAI model that was used
+	PromptTokens     int
+	CompletionTokens int
+	Images           int
+	CostMicros       int64 // Estimated cost in micro-dollars
+	PersonalKey      bool  // Paid for with the user's personal OpenAI key
+}
+
+// RecipeExplanation is the model for a cached explanation of the techniques behind a recipe's steps, per language.
+// It's kept apart from the recipe content and deleted when the recipe changes.
+type RecipeExplanation struct {
+	gorm.Model
+	RecipeID   uint                  `gorm:"unique_index:idx_recipe_explanation_language"`
+	Language   string                `gorm:"unique_index:idx_recipe_explanation_language"`
+	Summary    string                `json:"summary"`
+	Techniques TechniqueExplanations `json:"techniques" gorm:"type:jsonb"`
+}
+
 // Tag is the model for a recipe hashtag.
 type Tag struct {
 	gorm.Model
@@ -60,6 +126,7 @@ type RecipeType string
 // RecipeType enum values.
 const (
 	RecipeTypeChat            RecipeType = "chat"
+	RecipeTypeFromIngredients RecipeType = "from_ingredients"
 	RecipeTypeBasedOn         RecipeType = "based_on"
 	RecipeTypeRegenChat       RecipeType = "regen_chat"
 	RecipeTypeCopycat         RecipeType = "copycat"
@@ -67,4 +134,120 @@ const (
 	RecipeTypeImportLink      RecipeType = "import_link"
 	RecipeTypeImportCopypasta RecipeType = "import_text"
 	RecipeTypeManualEntry     RecipeType = "user_input"
+	RecipeTypeRevert          RecipeType = "revert"
+	RecipeTypeDuplicate       RecipeType = "duplicate"
+)
+
+// GenerationStatus is the type for the GenerationStatus enum.
+type GenerationStatus string
+
+// GenerationStatus enum values.
+// Recipes that aren't generated, such as copies of the welcome recipe, are complete from the start.
+const (
+	GenerationPending    GenerationStatus = "pending"
+	GenerationGenerating GenerationStatus = "generating"
+	GenerationComplete   GenerationStatus = "complete"
+	GenerationFailed     GenerationStatus = "failed" // The recipe is deleted, its status is kept so the client knows why
+)
+
+// IsValidGenerationStatus checks if the GenerationStatus is valid.
+func (s GenerationStatus) IsValidGenerationStatus() bool {
+	switch s {
+	case GenerationPending, GenerationGenerating, GenerationComplete, GenerationFailed:
+		return true
+	default:
+		return false
+	}
+}
+
+// Difficulty is the type for the Difficulty enum, how hard a recipe is to make as estimated when it's generated.
+type Difficulty string
+
+// Difficulty enum values.
+const (
+	DifficultyEasy   Difficulty = "easy"
+	DifficultyMedium Difficulty = "medium"
+	DifficultyHard   Difficulty = "hard"
+)
+
+// Difficulties lists the Difficulty values, easiest first.
+var Difficulties = []Difficulty{DifficultyEasy, DifficultyMedium, DifficultyHard}
+
+// IsValidDifficulty checks if the Difficulty is valid.
+func (d Difficulty) IsValidDifficulty() bool {
+	switch d {
+	case DifficultyEasy, DifficultyMedium, DifficultyHard:
+		return true
+	default:
+		return false
+	}
+}
+
+// NormalizeDifficulty lowercases and trims a difficulty, and drops it if it isn't one of the Difficulty values.
+func NormalizeDifficulty(d Difficulty) Difficulty {
+	d = Difficulty(strings.ToLower(strings.TrimSpace(string(d))))
+	if !d.IsValidDifficulty() {
+		return ""
+	}
+	return d
+}
+
+// Allergen is the type for the Allergen enum, a common allergen a recipe contains as detected when it's generated.
+type Allergen string
+
+// Allergen enum values.
+const (
+	AllergenDairy     Allergen = "dairy"
+	AllergenEgg       Allergen = "egg"
+	AllergenFish      Allergen = "fish"
+	AllergenGluten    Allergen = "gluten"
+	AllergenNuts      Allergen = "nuts"
+	AllergenPeanuts   Allergen = "peanuts"
+	AllergenSesame    Allergen = "sesame"
+	AllergenShellfish Allergen = "shellfish"
+	AllergenSoy       Allergen = "soy"
 )
+
+// Allergens lists the Allergen values, alphabetically.
+var Allergens = []Allergen{AllergenDairy, AllergenEgg, AllergenFish, AllergenGluten, AllergenNuts, AllergenPeanuts,
+	AllergenSesame, AllergenShellfish, AllergenSoy}
+
+// IsValidAllergen checks if the Allergen is valid.
+func (a Allergen) IsValidAllergen() bool {
+	switch a {
+	case AllergenDairy, AllergenEgg, AllergenFish, AllergenGluten, AllergenNuts, AllergenPeanuts, AllergenSesame,
+		AllergenShellfish, AllergenSoy:
+		return true
+	default:
+		return false
+	}
+}
+
+// NormalizeAllergens lowercases and trims allergens, and drops blank, repeated, and unknown ones.
+// They're sorted, so the same allergens are always stored the same way. It never returns nil.
+func NormalizeAllergens(allergens []string) pq.StringArray {
+	normalized := pq.StringArray{}
+	seen := make(map[string]bool, len(allergens))
+	for _, allergen := range allergens {
+		allergen = strings.ToLower(strings.TrimSpace(allergen))
+		if seen[allergen] || !Allergen(allergen).IsValidAllergen() {
+			continue
+		}
+		seen[allergen] = true
+		normalized = append(normalized, allergen)
+	}
+	sort.Strings(normalized)
+	return normalized
+}
+
+// RecipeGenerationCache is the model for a cached recipe generation, reused for identical prompts generated with the
+// same personalization, language, occasion, model, and system prompt version. Each reuse copies the recipe def into a
+// new recipe, the cached def is never shared.
+type RecipeGenerationCache struct {
+	gorm.Model
+	CacheKey           string     `gorm:"unique_index"`
+	RecipeDef          *RecipeDef `gorm:"type:jsonb"`
+	GeneratedWithModel string
+	PromptVersion      string
+	ExpiresAt          time.Time `gorm:"index"`
+}
@@ -0,0 +1,124 @@
+package models
+
+import "strings"
+
+// UnitKind is the type for the UnitKind enum, the quantity a unit measures.
+type UnitKind string
+
+// UnitKind enum values.
+const (
+	UnitKindVolume UnitKind = "volume"
+	UnitKindWeight UnitKind = "weight"
+	UnitKindCount  UnitKind = "count"
+)
+
+// Base units that conversion factors are relative to.
+const (
+	BaseUnitVolume = "mL"
+	BaseUnitWeight = "g"
+)
+
+// Unit is an ingredient unit the recipe generator may use.
+type Unit struct {
+	Symbol string
+	Kind   UnitKind
+	// Systems are the unit systems the unit belongs to, by their text representation.
+	Systems []string
+	// ToBase is the number of base units (mL or g) in one of this unit, 0 if it can't be converted.
+	ToBase float64
+	// DisplayNames are keyed by language code, falling back to English.
+	DisplayNames map[string]string
+}
+
+// Units is the table of supported ingredient units and their conversion factors.
+// The recipe generation schema restricts ingredient units to these symbols.
+var Units = []Unit{
+	{Symbol: "pieces", Kind: UnitKindCount, Systems: []string{USCustomaryText, MetricText},
+		DisplayNames: map[string]string{"en": "pieces", "es": "piezas", "fr": "pièces", "de": "Stück"}},
+	{Symbol: "tsp", Kind: UnitKindVolume, Systems: []string{USCustomaryText}, ToBase: 4.92892,
+		DisplayNames: map[string]string{"en": "teaspoon", "es": "cucharadita", "fr": "cuillère à café", "de": "Teelöffel"}},
+	{Symbol: "tbsp", Kind: UnitKindVolume, Systems: []string{USCustomaryText}, ToBase: 14.7868,
+		DisplayNames: map[string]string{"en": "tablespoon", "es": "cucharada", "fr": "cuillère à soupe", "de": "Esslöffel"}},
+	{Symbol: "fl oz", Kind: UnitKindVolume, Systems: []string{USCustomaryText}, ToBase: 29.5735,
+		DisplayNames: map[string]string{"en": "fluid ounce", "es": "onza líquida", "fr": "once liquide", "de": "Flüssigunze"}},
+	{Symbol: "cup", Kind: UnitKindVolume, Systems: []string{USCustomaryText}, ToBase: 236.588,
+		DisplayNames: map[string]string{"en": "cup", "es": "taza", "fr": "tasse", "de": "Tasse"}},
+	{Symbol: "pt", Kind: UnitKindVolume, Systems: []string{USCustomaryText}, ToBase: 473.176,
+		DisplayNames: map[string]string{"en": "pint", "es": "pinta", "fr": "pinte", "de": "Pint"}},
+	{Symbol: "qt", Kind: UnitKindVolume, Systems: []string{USCustomaryText}, ToBase: 946.353,
+		DisplayNames: map[string]string{"en": "quart", "es": "cuarto de galón", "fr": "quart de gallon", "de": "Quart"}},
+	{Symbol: "gal", Kind: UnitKindVolume, Systems: []string{USCustomaryText}, ToBase: 3785.41,
+		DisplayNames: map[string]string{"en": "gallon", "es": "galón", "fr": "gallon", "de": "Gallone"}},
+	{Symbol: "oz", Kind: UnitKindWeight, Systems: []string{USCustomaryText}, ToBase: 28.3495,
+		DisplayNames: map[string]string{"en": "ounce", "es": "onza", "fr": "once", "de": "Unze"}},
+	{Symbol: "lb", Kind: UnitKindWeight, Systems: []string{USCustomaryText}, ToBase: 453.592,
+		DisplayNames: map[string]string{"en": "pound", "es": "libra", "fr": "livre", "de": "Pfund"}},
+	{Symbol: "mL", Kind: UnitKindVolume, Systems: []string{MetricText}, ToBase: 1,
+		DisplayNames: map[string]string{"en": "milliliter", "es": "mililitro", "fr": "millilitre", "de": "Milliliter"}},
+	{Symbol: "L", Kind: UnitKindVolume, Systems: []string{MetricText}, ToBase: 1000,
+		DisplayNames: map[string]string{"en": "liter", "es": "litro", "fr": "litre", "de": "Liter"}},
+	{Symbol: "mg", Kind: UnitKindWeight, Systems: []string{MetricText}, ToBase: 0.001,
+		DisplayNames: map[string]string{"en": "milligram", "es": "miligramo", "fr": "milligramme", "de": "Milligramm"}},
+	{Symbol: "g", Kind: UnitKindWeight, Systems: []string{MetricText}, ToBase: 1,
+		DisplayNames: map[string]string{"en": "gram", "es": "gramo", "fr": "gramme", "de": "Gramm"}},
+	{Symbol: "kg", Kind: UnitKindWeight, Systems: []string{MetricText}, ToBase: 1000,
+		DisplayNames: map[string]string{"en": "kilogram", "es": "kilogramo", "fr": "kilogramme", "de": "Kilogramm"}},
+	// Pinch, dash and drop are approximate by nature, the factors follow the common kitchen convention
+	{Symbol: "pinch", Kind: UnitKindVolume, Systems: []string{USCustomaryText, MetricText}, ToBase: 0.308,
+		DisplayNames: map[string]string{"en": "pinch", "es": "pizca", "fr": "pincée", "de": "Prise"}},
+	{Symbol: "dash", Kind: UnitKindVolume, Systems: []string{USCustomaryText, MetricText}, ToBase: 0.616,
+		DisplayNames: map[string]string{"en": "dash", "es": "chorrito", "fr": "trait", "de": "Spritzer"}},
+	{Symbol: "drop", Kind: UnitKindVolume, Systems: []string{USCustomaryText, MetricText}, ToBase: 0.05,
+		DisplayNames: map[string]string{"en": "drop", "es": "gota", "fr": "goutte", "de": "Tropfen"}},
+	{Symbol: "bushel", Kind: UnitKindVolume, Systems: []string{USCustomaryText}, ToBase: 35239.1,
+		DisplayNames: map[string]string{"en": "bushel", "es": "bushel", "fr": "boisseau", "de": "Scheffel"}},
+}
+
+// UnitSymbols returns the symbols of every supported unit, in table order.
+func UnitSymbols() []string {
+	symbols := make([]string, len(Units))
+	for i, unit := range Units {
+		symbols[i] = unit.Symbol
+	}
+	return symbols
+}
+
+// LookupUnit finds a supported unit by its symbol, ignoring case.
+func LookupUnit(symbol string) (Unit, bool) {
+	for _, unit := range Units {
+		if strings.EqualFold(unit.Symbol, symbol) {
+			return unit, true
+		}
+	}
+	return Unit{}, false
+}
+
+// InSystem checks if the unit belongs to the unit system with the given text representation.
+func (u Unit) InSystem(system string) bool {
+	for _, s := range u.Systems {
+		if s == system {
+			return true
+		}
+	}
+	return false
+}
+
+// BaseUnit returns the unit the conversion factor is relative to, or an empty string if the unit can't be converted.
+func (u Unit) BaseUnit() string {
+	switch u.Kind {
+	case UnitKindVolume:
+		return BaseUnitVolume
+	case UnitKindWeight:
+		return BaseUnitWeight
+	default:
+		return ""
+	}
+}
+
+// DisplayName returns the unit's name in the given language, falling back to English.
+func (u Unit) DisplayName(language string) string {
+	if name, ok := u.DisplayNames[language]; ok {
+		return name
+	}
+	return u.DisplayNames[DefaultLanguage]
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
 )
 
 // User is the model for a user.
@@ -19,6 +20,95 @@ type User struct {
 	Settings         *UserSettings    `gorm:"foreignKey:UserID"`
 	Personalization  *Personalization `gorm:"foreignKey:UserID"`
 	CollectedRecipes []*Recipe        `gorm:"many2many:user_collected_recipes;"`
+	Role             Role             `gorm:"type:text;default:'user'"`
+	FeatureFlags     pq.StringArray   `gorm:"type:text[]"` // Beta features enabled for the user
+	LastLoginAt      *time.Time
+	StripeCustomerID *string `gorm:"unique_index"` // Set once the user has checked out a subscription
+}
+
+// Role is the type for the Role enum, what a user is allowed to do beyond using the app.
+type Role string
+
+// Role enum values, each allowed everything the ones before it are.
+const (
+	RoleUser      Role = "user"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+// roleRanks orders the roles from least to most allowed.
+var roleRanks = map[Role]int{
+	RoleUser:      1,
+	RoleModerator: 2,
+	RoleAdmin:     3,
+}
+
+// IsValidRole checks if the Role is valid.
+func (r Role) IsValidRole() bool {
+	_, ok := roleRanks[r]
+	return ok
+}
+
+// HasRole checks if the user has the role or one that's allowed more.
+func (u *User) HasRole(role Role) bool {
+	rank, ok := roleRanks[u.Role]
+	return ok && rank >= roleRanks[role]
+}
+
+// BeforeCreate is a GORM hook that runs before creating a new User.
+func (u *User) BeforeCreate(tx *gorm.DB) (err error) {
+	if u.Role == "" {
+		// Set default
+		u.Role = RoleUser
+	}
+
+	if !u.Role.IsValidRole() {
+		// Cancel transaction
+		return errors.New("invalid Role provided")
+	}
+
+	return nil
+}
+
+// BeforeUpdate is a GORM hook that runs before updating a User.
+// Partial updates don't load the role, so only a role that's set is validated.
+func (u *User) BeforeUpdate(tx *gorm.DB) (err error) {
+	if u.Role != "" && !u.Role.IsValidRole() {
+		// Cancel transaction
+		return errors.New("invalid Role provided")
+	}
+
+	return nil
+}
+
+// FeatureFlag is the type for the FeatureFlag enum.
+type FeatureFlag string
+
+// FeatureFlag enum values.
+const (
+	FeatureDallE3    FeatureFlag = "dalle3"
+	FeatureMealPlans FeatureFlag = "meal_plans"
+	FeatureStreaming FeatureFlag = "streaming"
+)
+
+// IsValidFeatureFlag checks if the FeatureFlag is valid.
+func (f FeatureFlag) IsValidFeatureFlag() bool {
+	switch f {
+	case FeatureDallE3, FeatureMealPlans, FeatureStreaming:
+		return true
+	default:
+		return false
+	}
+}
+
+// HasFeature checks if a beta feature is enabled for the user.
+func (u *User) HasFeature(flag FeatureFlag) bool {
+	for _, enabled := range u.FeatureFlags {
+		if enabled == string(flag) {
+			return true
+		}
+	}
+	return false
 }
 
 // UserAuth is the model for a user's authentication information.
@@ -27,6 +117,37 @@ type UserAuth struct {
 	UserID         uint `gorm:"unique;index"`
 	HashedPassword string
 	AuthType       UserAuthType `gorm:"type:text"`
+	FacebookID     *string      `gorm:"unique_index"` // Only set for Facebook users
+}
+
+// UserSession is the model for a login, one per issued token.
+// Revoking a session invalidates its token.
+type UserSession struct {
+	gorm.Model
+	UserID    uint   `gorm:"index"`
+	TokenID   string `gorm:"unique_index"` // The jti claim of the session's token
+	UserAgent string
+	IPAddress string
+	IssuedAt  time.Time
+	ExpiresAt *time.Time // When the token expires, nil for sessions from before every token expired
+	RevokedAt *time.Time
+}
+
+// PasswordResetToken is the model for a password reset link sent to a user.
+// Only a hash of the token is stored, and it can be used once before it expires.
+type PasswordResetToken struct {
+	gorm.Model
+	UserID    uint   `gorm:"index"`
+	TokenHash string `gorm:"unique_index"` // Hex SHA-256 of the token
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+// StripeEvent is the model for a Stripe webhook event that's been applied to a subscription.
+// Stripe delivers events at least once, so each event ID is stored once and a redelivered event is skipped.
+type StripeEvent struct {
+	gorm.Model
+	EventID string `gorm:"unique_index;not null"`
 }
 
 // UserAuthType is the type for the UserAuthType enum.
@@ -35,12 +156,13 @@ type UserAuthType string
 // UserAuthType enum values.
 const (
 	Standard UserAuthType = "standard"
+	Facebook UserAuthType = "facebook"
 )
 
 // IsValidAuthType checks if the AuthType is valid.
 func (ua *UserAuth) IsValidAuthType() bool {
 	switch ua.AuthType {
-	case Standard:
+	case Standard, Facebook:
 		return true
 	default:
 		return false
@@ -77,13 +199,30 @@ const (
 	Premium SubscriptionTier = "Premium" // Premium
 )
 
+// monthlyUses is the number of recipe generations each tier gets per billing cycle.
+var monthlyUses = map[SubscriptionTier]int{
+	Free:    5,
+	Basic:   30,
+	Premium: 90,
+}
+
+// MonthlyUses returns the number of recipe generations the tier gets per billing cycle.
+// A subscription's remaining uses are refilled to it at the start of each cycle.
+func (t SubscriptionTier) MonthlyUses() int {
+	return monthlyUses[t]
+}
+
 // Subscription is the model for a user's subscription.
 type Subscription struct {
 	gorm.Model
 	UserID           uint             `gorm:"unique;index"`
 	SubscriptionTier SubscriptionTier `gorm:"type:text;default:'Free'"`
+	// ExpiresAt is the end of the current billing cycle, the remaining uses are refilled once it passes
 	ExpiresAt        time.Time
-	RemainingTokens  int `gorm:"default:50000"`
+	RemainingTokens  int        `gorm:"default:50000"`
+	RemainingUses    int        `gorm:"default:0"` // Generations left in the current billing cycle
+	GenerationsToday int        `gorm:"default:0"` // Generations counted towards the daily cap
+	GenerationsDay   *time.Time // UTC day that GenerationsToday is counted for
 }
 
 // IsValidSubscriptionTier checks if the SubscriptionTier is valid.
@@ -119,17 +258,129 @@ func (s *Subscription) BeforeUpdate(tx *gorm.DB) (err error) {
 // UserSettings is the model for a user's settings.
 type UserSettings struct {
 	gorm.Model
-	UserID          uint `gorm:"unique;index"`
-	KeepScreenAwake bool `gorm:"default:true"`
+	UserID             uint   `gorm:"unique;index"`
+	KeepScreenAwake    bool   `gorm:"default:true"`
+	EncryptedOpenAIKey string `json:"-"` // Personal OpenAI key, encrypted at rest
+	UsePersonalAPIKey  bool   `gorm:"default:false"`
+	// MonthlySpendCapCents caps the estimated monthly spend on the personal OpenAI key, 0 means no cap
+	MonthlySpendCapCents   int        `gorm:"default:0"`
+	PersonalKeySpendMicros int64      `gorm:"default:0"` // Estimated spend on the personal key in PersonalKeySpendMonth, in micro-dollars
+	PersonalKeySpendMonth  *time.Time // First day of the UTC month that PersonalKeySpendMicros is counted for
+	// ImageSize, ImageQuality, and ImageStyle are the default options of the user's recipe images, empty for the configured ones
+	ImageSize    string
+	ImageQuality string
+	ImageStyle   string
+}
+
+// PersonalKeySpendMicrosIn returns the estimated spend on the personal key in the UTC month starting at month.
+func (s *UserSettings) PersonalKeySpendMicrosIn(month time.Time) int64 {
+	if s.PersonalKeySpendMonth == nil || !s.PersonalKeySpendMonth.Equal(month) {
+		return 0
+	}
+	return s.PersonalKeySpendMicros
+}
+
+// HasOpenAIKey checks if the user has stored a personal OpenAI key.
+func (s *UserSettings) HasOpenAIKey() bool {
+	return s.EncryptedOpenAIKey != ""
+}
+
+// UsesOpenAIKey checks if the user generates recipes with their personal OpenAI key.
+func (s *UserSettings) UsesOpenAIKey() bool {
+	return s.UsePersonalAPIKey && s.HasOpenAIKey()
 }
 
 // Personalization is the model for a user's personalization settings.
 type Personalization struct {
 	gorm.Model
-	UserID       uint       `gorm:"unique;index"`
-	UnitSystem   UnitSystem `gorm:"type:int"`
-	Requirements string     // Additional instructions or guidelines
-	UID          uuid.UUID
+	UserID              uint           `gorm:"unique;index"`
+	UnitSystem          UnitSystem     `gorm:"type:int"`
+	Requirements        string         // Additional instructions or guidelines
+	DietaryRestrictions pq.StringArray `gorm:"type:text[]"`  // Hard constraints every recipe must meet, each a DietaryRestriction
+	AllergenExclusions  pq.StringArray `gorm:"type:text[]"`  // Allergens the user avoids, each an Allergen, filtered out of feeds and warned about on view
+	Language            string         `gorm:"default:'en'"` // ISO 639-1 code of the language recipes are generated in
+	Persona             Persona        `gorm:"type:text;default:'michelin_chef'"`
+	UID                 uuid.UUID
+}
+
+// DietaryRestriction is the type for the DietaryRestriction enum, a hard constraint on the recipes generated for a user.
+type DietaryRestriction string
+
+// DietaryRestriction enum values.
+const (
+	DietaryRestrictionVegan      DietaryRestriction = "vegan"
+	DietaryRestrictionVegetarian DietaryRestriction = "vegetarian"
+	DietaryRestrictionGlutenFree DietaryRestriction = "gluten-free"
+	DietaryRestrictionDairyFree  DietaryRestriction = "dairy-free"
+	DietaryRestrictionNutFree    DietaryRestriction = "nut-free"
+	DietaryRestrictionKosher     DietaryRestriction = "kosher"
+	DietaryRestrictionHalal      DietaryRestriction = "halal"
+)
+
+// IsValidDietaryRestriction checks if the DietaryRestriction is valid.
+func (d DietaryRestriction) IsValidDietaryRestriction() bool {
+	switch d {
+	case DietaryRestrictionVegan, DietaryRestrictionVegetarian, DietaryRestrictionGlutenFree, DietaryRestrictionDairyFree,
+		DietaryRestrictionNutFree, DietaryRestrictionKosher, DietaryRestrictionHalal:
+		return true
+	default:
+		return false
+	}
+}
+
+// Persona is the type for the Persona enum, the chef recipes are generated as.
+type Persona string
+
+// Persona enum values.
+const (
+	PersonaMichelinChef    Persona = "michelin_chef" // Default, the framing of the system prompt templates
+	PersonaHomeCook        Persona = "home_cook"
+	PersonaBudgetStudent   Persona = "budget_student"
+	PersonaMealPrepAthlete Persona = "meal_prep_athlete"
+)
+
+// IsValidPersona checks if the Persona is valid.
+func (p Persona) IsValidPersona() bool {
+	switch p {
+	case PersonaMichelinChef, PersonaHomeCook, PersonaBudgetStudent, PersonaMealPrepAthlete:
+		return true
+	default:
+		return false
+	}
+}
+
+// DefaultLanguage is the language recipes are generated in unless another one is chosen.
+const DefaultLanguage = "en"
+
+// SupportedLanguages maps the ISO 639-1 codes of the languages recipes can be generated in to their English names.
+var SupportedLanguages = map[string]string{
+	"en": "English",
+	"es": "Spanish",
+	"fr": "French",
+	"de": "German",
+	"it": "Italian",
+	"pt": "Portuguese",
+	"nl": "Dutch",
+	"pl": "Polish",
+	"ru": "Russian",
+	"uk": "Ukrainian",
+	"ja": "Japanese",
+	"zh": "Chinese",
+	"ko": "Korean",
+}
+
+// IsSupportedLanguage checks if recipes can be generated in the language.
+func IsSupportedLanguage(language string) bool {
+	_, ok := SupportedLanguages[language]
+	return ok
+}
+
+// LanguageName returns the English name of a language code, falling back to the code itself.
+func LanguageName(language string) string {
+	if name, ok := SupportedLanguages[language]; ok {
+		return name
+	}
+	return language
 }
 
 // UnitSystem is the type for the UnitSystem enum.
@@ -167,11 +418,26 @@ func (p *Personalization) GetUnitSystemText() string {
 
 // BeforeCreate is a GORM hook that runs before creating a new user Personalization.
 func (p *Personalization) BeforeCreate(tx *gorm.DB) (err error) {
+	if p.UID == uuid.Nil {
+		// Recipes record the UID they were generated with, so every personalization needs one
+		p.UID = uuid.New()
+	}
+
 	if !p.IsValidUnitSystem() {
 		// Set default
 		p.UnitSystem = USCustomary
 	}
 
+	if !IsSupportedLanguage(p.Language) {
+		// Set default
+		p.Language = DefaultLanguage
+	}
+
+	if !p.Persona.IsValidPersona() {
+		// Set default
+		p.Persona = PersonaMichelinChef
+	}
+
 	return nil
 }
 
@@ -182,5 +448,15 @@ func (p *Personalization) BeforeUpdate(tx *gorm.DB) (err error) {
 		p.UnitSystem = USCustomary
 	}
 
+	if !IsSupportedLanguage(p.Language) {
+		// Set default
+		p.Language = DefaultLanguage
+	}
+
+	if !p.Persona.IsValidPersona() {
+		// Set default
+		p.Persona = PersonaMichelinChef
+	}
+
 	return nil
 }
@@ -9,6 +9,28 @@ import (
 	"github.com/windoze95/saltybytes-api/internal/util"
 )
 
+// NewRecipeChatMessages builds the messages a new recipe is generated from with chat, from the user prompt and the
+// personalization, language, persona, occasion, and ingredients on hand of the recipe manager. It doesn't call OpenAI.
+func (r *RecipeManager) NewRecipeChatMessages() ([]openai.ChatCompletionMessage, error) {
+	sysPromptTemplate := r.newRecipeSysPrompt()
+	// userPromptTemplate := r.Cfg.OpenaiPrompts.GenNewRecipeUser
+	sysPrompt, err := BuildSystemPrompt(r.Cfg, sysPromptTemplate, r.UnitSystem, r.Requirements, r.DietaryRestrictions, r.Persona, r.Occasion)
+	if err != nil {
+		return nil, err
+	}
+	// userPrompt := r.Cfg.OpenaiPrompts.FillUserPrompt(userPromptTemplate, r.UserPrompt)
+	chatCompletionMessages := []openai.ChatCompletionMessage{
+		createSysMsg(sysPrompt),
+	}
+	if instruction := languageInstruction(r.Language); instruction != "" {
+		chatCompletionMessages = append(chatCompletionMessages, createSysMsg(instruction))
+	}
+	if len(r.PantryIngredients) > 0 {
+		chatCompletionMessages = append(chatCompletionMessages, createSysMsg(pantryInstruction))
+	}
+	return append(chatCompletionMessages, createUserMsg(r.UserPrompt)), nil
+}
+
 // GenerateNewRecipe generates a new recipe.
 func generateRecipeWithChat(r *RecipeManager) error {
 	// New recipe, there shouldn't be a history
@@ -17,27 +39,29 @@ func generateRecipeWithChat(r *RecipeManager) error {
 	}
 
 	// Build the chat completion message stream
-	sysPromptTemplate := r.Cfg.OpenaiPrompts.GenNewRecipeSys
-	// userPromptTemplate := r.Cfg.OpenaiPrompts.GenNewRecipeUser
-	sysPrompt := r.Cfg.OpenaiPrompts.FillSysPrompt(sysPromptTemplate, r.UnitSystem, r.Requirements)
-	// userPrompt := r.Cfg.OpenaiPrompts.FillUserPrompt(userPromptTemplate, r.UserPrompt)
-	chatCompletionMessages := []openai.ChatCompletionMessage{
-		createSysMsg(sysPrompt),
-		createUserMsg(r.UserPrompt),
+	sysPromptTemplate := r.newRecipeSysPrompt()
+	chatCompletionMessages, err := r.NewRecipeChatMessages()
+	if err != nil {
+		return err
 	}
 
 	// Create the request
-	recipeDefRequest, err := createRecipeDefRequest(chatCompletionMessages, false)
+	recipeDefRequest, err := createRecipeDefRequest(chatCompletionMessages, false, len(r.PantryIngredients) > 0)
 	if err != nil {
 		return err
 	}
+	recipeDefRequest.Model = r.recipeModel()
 
 	// Perform the chat completion
-	resp, err := createChatCompletionWithRetry(recipeDefRequest, r.Cfg)
+	resp, err := r.createChatCompletion(recipeDefRequest)
 	if err != nil {
 		return fmt.Errorf("failed to create chat completion: %v", err)
 	}
 
+	// Record what produced the recipe def
+	r.GeneratedWithModel = resp.Model
+	r.PromptVersion = sysPromptTemplate.Version()
+
 	// Get the recipe def
 	recipeDefJSON := resp.Choices[0].Message.FunctionCall.Arguments
 	if len(resp.Choices) == 0 || recipeDefJSON == "" {
@@ -6,69 +6,171 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"time"
+	"strings"
+	"sync/atomic"
+	"unicode"
 
 	openai "github.com/sashabaranov/go-openai"
 	"github.com/windoze95/saltybytes-api/internal/config"
 )
 
+// defaultMaxImagePromptLength is DALL-E 2's prompt limit in characters, used when no limit is configured.
+const defaultMaxImagePromptLength = 1000
+
+// ImageOptions are the options a recipe image is generated with.
+// Empty fields fall back to the configured ones, and options the image model doesn't support are dropped.
+type ImageOptions struct {
+	// Size is the size of the image, e.g. "512x512".
+	Size string `json:"size,omitempty"`
+	// Quality is "standard" or "hd", only DALL-E 3 supports it.
+	Quality string `json:"quality,omitempty"`
+	// Style is "vivid" or "natural", only DALL-E 3 supports it.
+	Style string `json:"style,omitempty"`
+}
+
+// ValidateImageOptions checks that the image options are ones some image model supports.
+func ValidateImageOptions(options ImageOptions) error {
+	if options.Size != "" && !isKnownImageSize(options.Size) {
+		return fmt.Errorf("unknown image size %q", options.Size)
+	}
+
+	switch options.Quality {
+	case "", openai.CreateImageQualityStandard, openai.CreateImageQualityHD:
+	default:
+		return fmt.Errorf("unknown image quality %q", options.Quality)
+	}
+
+	switch options.Style {
+	case "", openai.CreateImageStyleVivid, openai.CreateImageStyleNatural:
+	default:
+		return fmt.Errorf("unknown image style %q", options.Style)
+	}
+
+	return nil
+}
+
+// isKnownImageSize checks if any of the priced image models supports the size.
+func isKnownImageSize(size string) bool {
+	for _, sizes := range imagePrices {
+		if _, ok := sizes[size]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveImageOptions returns the configured image model and the options an image is generated with by it.
+// A size the model doesn't support falls back to the configured size, quality and style are only kept for DALL-E 3.
+func resolveImageOptions(cfg *config.Config, options ImageOptions) (string, ImageOptions) {
+	model, size := imageModelAndSize(cfg)
+	resolved := ImageOptions{Size: size}
+
+	if options.Size != "" {
+		if _, ok := imagePrices[model][options.Size]; ok {
+			resolved.Size = options.Size
+		} else {
+			log.Printf("Image size %s isn't supported by %s, generating at %s", options.Size, model, size)
+		}
+	}
+
+	if model == openai.CreateImageModelDallE3 {
+		resolved.Quality = options.Quality
+		resolved.Style = options.Style
+	}
+
+	return model, resolved
+}
+
 // generateRecipeImage generates an image using DALL-E based on the prompt in RecipeManager.RecipeDef.ImagePrompt,
-// then assigns the image bytes to RecipeManager.ImageBytes.
-func generateRecipeImage(r *RecipeManager) error {
+// with the options in RecipeManager.ImageOptions, then assigns the image bytes to RecipeManager.ImageBytes and the size
+// it was generated at to RecipeManager.ImageSize.
+func generateRecipeImage(ctx context.Context, r *RecipeManager) error {
 	// Tests for the presence of a prompt
 	if r.RecipeDef.ImagePrompt == "" {
 		return errors.New("ImagePrompt is nil")
 	}
 
-	imageBytes, err := createImage(r.RecipeDef.ImagePrompt, r.Cfg)
+	// Over-length prompts are rejected by the API, so truncate them instead of losing the image
+	maxLength := r.Cfg.Images.MaxPromptLength
+	if maxLength <= 0 {
+		maxLength = defaultMaxImagePromptLength
+	}
+	prompt, truncated := truncateImagePrompt(r.RecipeDef.ImagePrompt, maxLength)
+	if truncated {
+		log.Printf("Image prompt truncated from %d to %d characters", len([]rune(r.RecipeDef.ImagePrompt)), len([]rune(prompt)))
+	}
+
+	model, options := resolveImageOptions(r.Cfg, r.ImageOptions)
+	imageBytes, err := createImage(ctx, prompt, r.ImageGenerator, r.Cfg, model, options)
 	if err != nil {
 		log.Printf("error: failed to create recipe image completion: %v", err)
 		return err
 	}
 
 	r.ImageBytes = imageBytes
+	r.ImageSize = options.Size
+	costMicros := imageCostMicros(model, options)
+	atomic.AddInt64(&r.SpendMicros, costMicros)
+	r.addUsage(TokenUsage{Model: model, Images: 1, CostMicros: costMicros})
 
 	return nil
 }
 
-// createImage generates an image using DALL-E based on the provided prompt.
-func createImage(prompt string, cfg *config.Config) ([]byte, error) {
-	maxRetries := 3
+// truncateImagePrompt shortens the prompt to at most maxLength characters, cutting on a word boundary when there is one.
+// Reports whether the prompt was truncated.
+func truncateImagePrompt(prompt string, maxLength int) (string, bool) {
+	runes := []rune(prompt)
+	if len(runes) <= maxLength {
+		return prompt, false
+	}
+
+	cut := maxLength
+	// Back up to the last space if the cut lands inside a word
+	if !unicode.IsSpace(runes[cut]) {
+		for i := cut - 1; i > 0; i-- {
+			if unicode.IsSpace(runes[i]) {
+				cut = i
+				break
+			}
+		}
+	}
+
+	return strings.TrimRightFunc(string(runes[:cut]), unicode.IsSpace), true
+}
+
+// createImage generates an image using DALL-E based on the provided prompt, with the model and options.
+// If the generator is nil, a new OpenAI API client is created for each try so the API key rotates.
+func createImage(ctx context.Context, prompt string, generator ImageGenerator, cfg *config.Config, model string, options ImageOptions) ([]byte, error) {
 	var respBase64 openai.ImageResponse
-	var err error
 
-	for i := 0; i < maxRetries; i++ {
-		c, err := newOpenaiClient(cfg)
-		if err != nil {
-			log.Printf("error: failed to create image service: %v", err)
-			return nil, err
+	err := withRetry(ctx, cfg, "Image generation", func(ctx context.Context) error {
+		tryGenerator := generator
+		if tryGenerator == nil {
+			c, err := newOpenaiClient(cfg)
+			if err != nil {
+				log.Printf("error: failed to create image service: %v", err)
+				return err
+			}
+			tryGenerator = c.Client
 		}
 
-		respBase64, err = c.Client.CreateImage(
-			context.Background(),
+		var err error
+		respBase64, err = tryGenerator.CreateImage(
+			ctx,
 			openai.ImageRequest{
 				Prompt:         prompt,
-				Size:           openai.CreateImageSize512x512,
+				Model:          model,
+				Size:           options.Size,
+				Quality:        options.Quality,
+				Style:          options.Style,
 				ResponseFormat: openai.CreateImageResponseFormatB64JSON,
 				N:              1,
 			},
 		)
-
-		if err == nil {
-			break
-		}
-
-		shouldRetry, waitTime, noRetryErr := handleAPIError(err)
-		if !shouldRetry {
-			return nil, noRetryErr
-		}
-
-		// Wait before next retry
-		time.Sleep(waitTime * time.Duration(i))
-	}
-
+		return err
+	})
 	if err != nil {
-		return nil, fmt.Errorf("exhausted maximum retries. Exiting. CreateImage error: %v", err)
+		return nil, fmt.Errorf("CreateImage error: %v", err)
 	}
 
 	if len(respBase64.Data) == 0 || respBase64.Data[0].B64JSON == "" {
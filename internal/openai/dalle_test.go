@@ -0,0 +1,77 @@
+package openai
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/windoze95/saltybytes-api/internal/config"
+	"github.com/windoze95/saltybytes-api/internal/models"
+	"github.com/windoze95/saltybytes-api/internal/openai/openaitest"
+)
+
+func TestTruncateImagePrompt(t *testing.T) {
+	tests := []struct {
+		name          string
+		prompt        string
+		maxLength     int
+		want          string
+		wantTruncated bool
+	}{
+		{"short enough", "A bowl of soup", 20, "A bowl of soup", false},
+		{"exact length", "A bowl of soup", 14, "A bowl of soup", false},
+		{"cut inside a word", "A bowl of tomato soup", 12, "A bowl of", true},
+		{"cut on a space", "A bowl of tomato soup", 9, "A bowl of", true},
+		{"no space to cut on", "Bouillabaisse", 5, "Bouil", true},
+		{"counts characters, not bytes", "Crème brûlée au café", 12, "Crème brûlée", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, truncated := truncateImagePrompt(tt.prompt, tt.maxLength)
+			if got != tt.want || truncated != tt.wantTruncated {
+				t.Fatalf("truncateImagePrompt = %q, %v, want %q, %v", got, truncated, tt.want, tt.wantTruncated)
+			}
+		})
+	}
+}
+
+func TestGenerateRecipeImageTruncatesLongPrompt(t *testing.T) {
+	client := &openaitest.MockClient{
+		CreateImageFunc: func(ctx context.Context, request openai.ImageRequest) (openai.ImageResponse, error) {
+			// Like the API, reject prompts over the limit
+			if len([]rune(request.Prompt)) > 100 {
+				return openai.ImageResponse{}, &openai.APIError{HTTPStatusCode: 400, Message: "prompt is too long"}
+			}
+			return openaitest.ImageResponse([]byte("image")), nil
+		},
+	}
+	cfg := &config.Config{}
+	cfg.Images.MaxPromptLength = 100
+	longPrompt := strings.Repeat("A rustic bowl of tomato soup ", 20)
+	recipeManager := &RecipeManager{
+		Cfg:            cfg,
+		RecipeDef:      &models.RecipeDef{ImagePrompt: longPrompt},
+		ImageGenerator: client,
+	}
+
+	if err := recipeManager.GenerateRecipeImage(context.Background()); err != nil {
+		t.Fatalf("GenerateRecipeImage: %v", err)
+	}
+
+	requests := client.ImageRequests()
+	if len(requests) != 1 {
+		t.Fatalf("got %d image requests, want 1", len(requests))
+	}
+	prompt := requests[0].Prompt
+	if len([]rune(prompt)) > 100 || !strings.HasPrefix(longPrompt, prompt) {
+		t.Fatalf("prompt %q isn't the original truncated to 100 characters", prompt)
+	}
+	// Cut on a word boundary
+	if next := longPrompt[len(prompt)]; next != ' ' {
+		t.Fatalf("prompt %q was cut inside a word", prompt)
+	}
+	if string(recipeManager.ImageBytes) != "image" {
+		t.Fatalf("image bytes = %q, want the generated image", recipeManager.ImageBytes)
+	}
+}
@@ -0,0 +1,111 @@
+package openai
+
+import (
+	"errors"
+	"fmt"
+
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/sashabaranov/go-openai/jsonschema"
+	"github.com/windoze95/saltybytes-api/internal/models"
+	"github.com/windoze95/saltybytes-api/internal/util"
+)
+
+// ExplanationFunctionCallArgument is the argument returned by the explain_recipe function call.
+type ExplanationFunctionCallArgument struct {
+	Summary    string                       `json:"summary"`
+	Techniques models.TechniqueExplanations `json:"techniques"`
+}
+
+// generateRecipeExplanation explains the techniques behind the key steps of the current recipe def,
+// then assigns the explanation to RecipeManager.Explanation.
+func generateRecipeExplanation(r *RecipeManager) error {
+	// Tests for the presence of a recipe to explain
+	if r.RecipeDef == nil {
+		return errors.New("RecipeDef is nil")
+	}
+
+	// Serialize the recipe def
+	recipeDefJSON, err := util.SerializeToJSONString(r.RecipeDef)
+	if err != nil {
+		return fmt.Errorf("failed to serialize RecipeDef: %v", err)
+	}
+
+	// Build the chat completion message stream
+	chatCompletionMessages := []openai.ChatCompletionMessage{
+		createSysMsg("You are a patient cooking teacher. Explain the techniques and the reasons behind the key steps of recipes, " +
+			"like why dough rests or why meat is seared first, so a learning cook understands what each step does. " +
+			"Don't rewrite the recipe."),
+	}
+	if instruction := languageInstruction(r.Language); instruction != "" {
+		chatCompletionMessages = append(chatCompletionMessages, createSysMsg(instruction))
+	}
+	chatCompletionMessages = append(chatCompletionMessages, createUserMsg("Explain the following recipe: "+recipeDefJSON))
+
+	// Perform the chat completion
+	resp, err := createChatCompletionWithRetry(r.requestContext(), createExplanationRequest(chatCompletionMessages, RecipeModel(r.Cfg)), r.RecipeGenerator, r.Cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create chat completion: %v", err)
+	}
+	r.recordUsage(resp)
+
+	// Get the explanation
+	if len(resp.Choices) == 0 || resp.Choices[0].Message.FunctionCall == nil || resp.Choices[0].Message.FunctionCall.Arguments == "" {
+		return errors.New("OpenAI API returned an empty message")
+	}
+	explanationJSON := resp.Choices[0].Message.FunctionCall.Arguments
+
+	// Deserialize the explanation
+	var functionCallArgument ExplanationFunctionCallArgument
+	if err = util.DeserializeFromJSONString(explanationJSON, &functionCallArgument); err != nil {
+		return fmt.Errorf("failed to deserialize ExplanationFunctionCallArgument: %v", err)
+	}
+
+	// Set the explanation
+	r.Explanation = &functionCallArgument
+
+	return nil
+}
+
+// createExplanationRequest creates a chat completion request for an explanation of a recipe's techniques.
+func createExplanationRequest(chatCompletionMessages []openai.ChatCompletionMessage, model string) *openai.ChatCompletionRequest {
+	// Define the function for use in the API call
+	functionDef := openai.FunctionDefinition{
+		Name: "explain_recipe",
+		Parameters: jsonschema.Definition{
+			Type: jsonschema.Object,
+			Properties: map[string]jsonschema.Definition{
+				"summary": {
+					Type:        jsonschema.String,
+					Description: "A short overview of what makes the recipe work",
+				},
+				"techniques": {
+					Type:        jsonschema.Array,
+					Description: "The key steps worth understanding, in recipe order. Skip steps that need no explanation.",
+					Items: &jsonschema.Definition{
+						Type: jsonschema.Object,
+						Properties: map[string]jsonschema.Definition{
+							"step":      {Type: jsonschema.String, Description: "The recipe step, quoted or briefly paraphrased"},
+							"technique": {Type: jsonschema.String, Description: "Name of the technique used in the step"},
+							"why":       {Type: jsonschema.String, Description: "Why the step is done this way and what it does to the food"},
+						},
+					},
+				},
+			},
+			Required: []string{"summary", "techniques"},
+		},
+	}
+
+	// Create and return the chat completion request
+	return &openai.ChatCompletionRequest{
+		Model:       model,
+		Messages:    chatCompletionMessages,
+		Temperature: 0.5,
+		TopP:        0.9,
+		N:           1,
+		Stream:      false,
+		Functions:   []openai.FunctionDefinition{functionDef},
+		FunctionCall: &openai.FunctionCall{
+			Name: functionDef.Name,
+		},
+	}
+}
@@ -0,0 +1,91 @@
+package openai
+
+import (
+	"errors"
+	"fmt"
+
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/sashabaranov/go-openai/jsonschema"
+	"github.com/windoze95/saltybytes-api/internal/util"
+)
+
+// HashtagsFunctionCallArgument is the argument returned by the create_hashtags function call.
+type HashtagsFunctionCallArgument struct {
+	Hashtags []string `json:"hashtags"`
+}
+
+// generateRecipeHashtags generates fresh hashtags for the current recipe def.
+func generateRecipeHashtags(r *RecipeManager) error {
+	// Tests for the presence of a recipe to tag
+	if r.RecipeDef == nil {
+		return errors.New("RecipeDef is nil")
+	}
+
+	// Serialize the recipe def
+	recipeDefJSON, err := util.SerializeToJSONString(r.RecipeDef)
+	if err != nil {
+		return fmt.Errorf("failed to serialize RecipeDef: %v", err)
+	}
+
+	// Build the chat completion message stream
+	chatCompletionMessages := []openai.ChatCompletionMessage{
+		createUserMsg("Provide hashtags for the following recipe: " + recipeDefJSON),
+	}
+
+	// Perform the chat completion
+	resp, err := createChatCompletionWithRetry(r.requestContext(), createHashtagsRequest(chatCompletionMessages), r.RecipeGenerator, r.Cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create chat completion: %v", err)
+	}
+	r.recordUsage(resp)
+
+	// Get the hashtags
+	if len(resp.Choices) == 0 || resp.Choices[0].Message.FunctionCall == nil || resp.Choices[0].Message.FunctionCall.Arguments == "" {
+		return errors.New("OpenAI API returned an empty message")
+	}
+	hashtagsJSON := resp.Choices[0].Message.FunctionCall.Arguments
+
+	// Deserialize the hashtags
+	var functionCallArgument HashtagsFunctionCallArgument
+	if err = util.DeserializeFromJSONString(hashtagsJSON, &functionCallArgument); err != nil {
+		return fmt.Errorf("failed to deserialize HashtagsFunctionCallArgument: %v", err)
+	}
+
+	// Set the hashtags
+	r.RecipeDef.Hashtags = functionCallArgument.Hashtags
+
+	return nil
+}
+
+// createHashtagsRequest creates a chat completion request for a list of recipe hashtags.
+func createHashtagsRequest(chatCompletionMessages []openai.ChatCompletionMessage) *openai.ChatCompletionRequest {
+	// Define the function for use in the API call
+	functionDef := openai.FunctionDefinition{
+		Name: "create_hashtags",
+		Parameters: jsonschema.Definition{
+			Type: jsonschema.Object,
+			Properties: map[string]jsonschema.Definition{
+				"hashtags": {
+					Type:        jsonschema.Array,
+					Description: hashtagsDescription,
+					Items:       &jsonschema.Definition{Type: jsonschema.String},
+				},
+			},
+			Required: []string{"hashtags"},
+		},
+	}
+
+	// Create and return the chat completion request
+	return &openai.ChatCompletionRequest{
+		Model:       openai.GPT4TurboPreview,
+		Messages:    chatCompletionMessages,
+		Temperature: 0.7,
+		TopP:        0.9,
+		N:           1,
+		Stream:      false,
+		Functions:   []openai.FunctionDefinition{functionDef},
+		FunctionCall: &openai.FunctionCall{
+			Name: functionDef.Name,
+		},
+	}
+}
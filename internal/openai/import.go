@@ -1,6 +1,7 @@
 package openai
 
 import (
+	"context"
 	"errors"
 	"fmt"
 
@@ -19,15 +20,24 @@ func generateRecipeWithImportVision(r *RecipeManager) error {
 
 	sysPromptTemplate := r.Cfg.OpenaiPrompts.GenNewVisionImportArgsSys
 	userPromptTemplate := r.Cfg.OpenaiPrompts.GenNewVisionImportArgsUser
-	sysPrompt := r.Cfg.OpenaiPrompts.FillSysPrompt(sysPromptTemplate, r.UnitSystem, r.Requirements)
-	userPrompt := r.Cfg.OpenaiPrompts.FillUserPrompt(userPromptTemplate, r.UserPrompt)
+	sysPrompt, err := r.Cfg.OpenaiPrompts.FillSysPrompt(sysPromptTemplate, r.UnitSystem, r.Requirements)
+	if err != nil {
+		return err
+	}
+	userPrompt, err := r.Cfg.OpenaiPrompts.FillUserPrompt(userPromptTemplate, r.UserPrompt)
+	if err != nil {
+		return err
+	}
 	chatCompletionMessages := []openai.ChatCompletionMessage{
 		createSysMsg(sysPrompt),
-		createUserMultiMsgVision(userPrompt, r.VisionImageURL),
 	}
+	if instruction := languageInstruction(r.Language); instruction != "" {
+		chatCompletionMessages = append(chatCompletionMessages, createSysMsg(instruction))
+	}
+	chatCompletionMessages = append(chatCompletionMessages, createUserMultiMsgVision(userPrompt, r.VisionImageURL))
 
 	// Generate the unformatted recipe
-	visionReplyMessage, err := createVisionChatCompletion(chatCompletionMessages, r.Cfg)
+	visionReplyMessage, err := createVisionChatCompletion(r.requestContext(), chatCompletionMessages, r.RecipeGenerator, r.Cfg)
 	if err != nil {
 		return fmt.Errorf("failed to create chat completion: %v", err)
 	}
@@ -37,16 +47,21 @@ func generateRecipeWithImportVision(r *RecipeManager) error {
 	chatCompletionMessages = append(chatCompletionMessages, createUserMsg("Proceed."))
 
 	// Create the request
-	recipeDefRequest, err := createRecipeDefRequest(chatCompletionMessages, false)
+	recipeDefRequest, err := createRecipeDefRequest(chatCompletionMessages, false, false)
 	if err != nil {
 		return err
 	}
 
 	// Generate the recipe def
-	resp, err := createChatCompletionWithRetry(recipeDefRequest, r.Cfg)
+	resp, err := createChatCompletionWithRetry(r.requestContext(), recipeDefRequest, r.RecipeGenerator, r.Cfg)
 	if err != nil {
 		return fmt.Errorf("failed to create chat completion: %v", err)
 	}
+	r.recordUsage(resp)
+
+	// Record what produced the recipe def
+	r.GeneratedWithModel = resp.Model
+	r.PromptVersion = sysPromptTemplate.Version()
 
 	// Get the recipe def
 	recipeDefJSON := resp.Choices[0].Message.FunctionCall.Arguments
@@ -74,14 +89,14 @@ func generateRecipeWithImportVision(r *RecipeManager) error {
 }
 
 // createVisionChatCompletion generates a chat completion with vision from the provided chat completion messages.
-func createVisionChatCompletion(chatCompletionMessages []openai.ChatCompletionMessage, cfg *config.Config) (*openai.ChatCompletionMessage, error) {
+func createVisionChatCompletion(ctx context.Context, chatCompletionMessages []openai.ChatCompletionMessage, generator RecipeGenerator, cfg *config.Config) (*openai.ChatCompletionMessage, error) {
 	// Validate the chat completion messages
 	if chatCompletionMessages == nil {
 		return nil, errors.New("chatCompletionMessages is nil")
 	}
 
 	// Perform the chat completion
-	resp, err := createChatCompletionWithRetry(&openai.ChatCompletionRequest{
+	resp, err := createChatCompletionWithRetry(ctx, &openai.ChatCompletionRequest{
 		Model:            openai.GPT4VisionPreview,
 		Messages:         chatCompletionMessages,
 		Temperature:      0.7,
@@ -90,7 +105,7 @@ func createVisionChatCompletion(chatCompletionMessages []openai.ChatCompletionMe
 		Stream:           false,
 		PresencePenalty:  0.2,
 		FrequencyPenalty: 0,
-	}, cfg)
+	}, generator, cfg)
 	if err != nil {
 		return nil, err
 	}
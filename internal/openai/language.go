@@ -0,0 +1,62 @@
+package openai
+
+import (
+	"log"
+
+	"github.com/windoze95/saltybytes-api/internal/models"
+	"github.com/windoze95/saltybytes-api/internal/util"
+)
+
+// generateWithLanguageCheck runs a recipe generation and, when the language consistency check is enabled,
+// regenerates while too much of the recipe isn't in the requested language.
+func generateWithLanguageCheck(r *RecipeManager, generate func(*RecipeManager) error) error {
+	check := r.Cfg.LanguageCheck
+
+	for attempt := 0; ; attempt++ {
+		if err := generate(r); err != nil {
+			return err
+		}
+
+		// English output can't be mixed with English
+		if !check.Enabled || r.Language == "" || r.Language == models.DefaultLanguage {
+			return nil
+		}
+
+		mismatch := languageMismatchRatio(r.RecipeDef)
+		if mismatch <= check.MaxMismatchRatio {
+			return nil
+		}
+
+		if attempt >= check.MaxRegenerations {
+			log.Printf("warning: recipe language mismatch of %.2f exceeds %.2f after %d regenerations, keeping the recipe", mismatch, check.MaxMismatchRatio, attempt)
+			return nil
+		}
+
+		log.Printf("recipe language mismatch of %.2f exceeds %.2f, regenerating", mismatch, check.MaxMismatchRatio)
+	}
+}
+
+// languageMismatchRatio returns the share of the recipe's title, ingredient names, and instructions that are in English.
+func languageMismatchRatio(recipeDef *models.RecipeDef) float64 {
+	if recipeDef == nil {
+		return 0
+	}
+
+	texts := []string{recipeDef.Title}
+	for _, ingredient := range recipeDef.Ingredients {
+		texts = append(texts, ingredient.Name)
+	}
+	texts = append(texts, recipeDef.Instructions...)
+
+	return util.EnglishTextRatio(texts)
+}
+
+// languageInstruction returns the instruction that asks for the recipe to be written in the given language.
+// English is the default, no instruction is needed.
+func languageInstruction(language string) string {
+	if language == "" || language == models.DefaultLanguage {
+		return ""
+	}
+
+	return "Write every part of the recipe, including the title, ingredient names, and instructions, in " + models.LanguageName(language) + "."
+}
@@ -0,0 +1,75 @@
+package openai
+
+import (
+	"testing"
+
+	"github.com/windoze95/saltybytes-api/internal/config"
+	"github.com/windoze95/saltybytes-api/internal/models"
+)
+
+// mixedRecipeDef returns an Italian recipe whose instructions came back in English.
+func mixedRecipeDef() *models.RecipeDef {
+	return &models.RecipeDef{
+		Title:       "Risotto ai funghi",
+		Ingredients: models.Ingredients{{Name: "Riso carnaroli"}, {Name: "Funghi porcini"}},
+		Instructions: []string{
+			"Heat the butter in a large pan",
+			"Add the rice and stir for two minutes",
+			"Add the stock and cook until absorbed",
+		},
+	}
+}
+
+// italianRecipeDef returns an Italian recipe written entirely in Italian.
+func italianRecipeDef() *models.RecipeDef {
+	return &models.RecipeDef{
+		Title:        "Risotto ai funghi",
+		Ingredients:  models.Ingredients{{Name: "Riso carnaroli"}, {Name: "Funghi porcini"}},
+		Instructions: []string{"Sciogliere il burro in una padella", "Tostare il riso", "Aggiungere il brodo"},
+	}
+}
+
+func TestLanguageMismatchRatioFlagsMixedRecipe(t *testing.T) {
+	if ratio := languageMismatchRatio(mixedRecipeDef()); ratio < 0.5 {
+		t.Fatalf("mixed recipe mismatch = %.2f, want at least 0.5", ratio)
+	}
+	if ratio := languageMismatchRatio(italianRecipeDef()); ratio != 0 {
+		t.Fatalf("Italian recipe mismatch = %.2f, want 0", ratio)
+	}
+}
+
+func TestGenerateWithLanguageCheck(t *testing.T) {
+	tests := []struct {
+		name         string
+		enabled      bool
+		language     string
+		generated    []*models.RecipeDef
+		wantAttempts int
+	}{
+		{"disabled", false, "it", []*models.RecipeDef{mixedRecipeDef()}, 1},
+		{"english", true, models.DefaultLanguage, []*models.RecipeDef{mixedRecipeDef()}, 1},
+		{"consistent", true, "it", []*models.RecipeDef{italianRecipeDef()}, 1},
+		{"regenerated", true, "it", []*models.RecipeDef{mixedRecipeDef(), italianRecipeDef()}, 2},
+		{"kept after max regenerations", true, "it", []*models.RecipeDef{mixedRecipeDef(), mixedRecipeDef(), mixedRecipeDef()}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{}
+			cfg.LanguageCheck = config.LanguageCheckOptions{Enabled: tt.enabled, MaxMismatchRatio: 0.2, MaxRegenerations: 2}
+			r := &RecipeManager{Cfg: cfg, Language: tt.language}
+
+			attempts := 0
+			err := generateWithLanguageCheck(r, func(r *RecipeManager) error {
+				r.RecipeDef = tt.generated[attempts]
+				attempts++
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("generateWithLanguageCheck: %v", err)
+			}
+			if attempts != tt.wantAttempts {
+				t.Fatalf("got %d generations, want %d", attempts, tt.wantAttempts)
+			}
+		})
+	}
+}
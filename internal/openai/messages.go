@@ -27,6 +27,18 @@ func processExistingRecipeHistoryEntries(historyIn []models.RecipeHistoryEntry)
 				Role:    openai.ChatMessageRoleUser,
 				Content: "The following response from you is a simulated response containing the current revision of the recipe.",
 			})
+		case models.RecipeTypeRevert:
+			// Revert type entry restores an earlier revision, simulated as a response for context.
+			messagesOut = append(messagesOut, openai.ChatCompletionMessage{
+				Role:    openai.ChatMessageRoleUser,
+				Content: "The following response from you is a simulated response reverting the recipe to an earlier revision.",
+			})
+		case models.RecipeTypeBasedOn:
+			// Based on type entry is a copy of another recipe, simulated as a response for context.
+			messagesOut = append(messagesOut, openai.ChatCompletionMessage{
+				Role:    openai.ChatMessageRoleUser,
+				Content: "The following response from you is a simulated response containing the recipe this one is based on.",
+			})
 		default:
 			messagesOut = append(messagesOut, openai.ChatCompletionMessage{
 				Role:    openai.ChatMessageRoleUser,
@@ -5,7 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"time"
+	"sort"
+	"sync"
+	"sync/atomic"
 
 	openai "github.com/sashabaranov/go-openai"
 	"github.com/windoze95/saltybytes-api/internal/config"
@@ -17,11 +19,25 @@ type OpenaiClient struct {
 	Client *openai.Client
 }
 
+// RecipeGenerator creates the chat completions recipes are generated from. It's implemented by the OpenAI API client.
+type RecipeGenerator interface {
+	CreateChatCompletion(ctx context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error)
+}
+
+// ImageGenerator creates recipe images. It's implemented by the OpenAI API client.
+type ImageGenerator interface {
+	CreateImage(ctx context.Context, request openai.ImageRequest) (openai.ImageResponse, error)
+}
+
 // RecipeManager is a wrapper for the recipe generation process.
 type RecipeManager struct {
 	UserPrompt             string
 	Requirements           string
+	DietaryRestrictions    []string
 	UnitSystem             string
+	Language               string
+	Persona                models.Persona
+	Occasion               *models.Occasion
 	CreateType             models.RecipeType
 	RecipeHistoryEntries   []models.RecipeHistoryEntry
 	NextRecipeHistoryEntry models.RecipeHistoryEntry
@@ -29,85 +45,200 @@ type RecipeManager struct {
 	ImageBytes             []byte
 	Cfg                    *config.Config
 	RecipeDef              *models.RecipeDef
+	Explanation            *ExplanationFunctionCallArgument
+	GeneratedWithModel     string
+	PromptVersion          string
+	// RecipeGenerator and ImageGenerator are optional, when nil an OpenAI API client is created with the current API key.
+	RecipeGenerator RecipeGenerator
+	ImageGenerator  ImageGenerator
+	// Model optionally overrides the configured model recipes are generated with.
+	Model string
+	// SpendMicros is the estimated cost of the API calls made so far, in micro-dollars.
+	SpendMicros int64
+	// Ctx optionally cancels the API calls of the recipe generation.
+	Ctx context.Context
+	// ImageOptions optionally overrides the configured options the recipe image is generated with.
+	ImageOptions ImageOptions
+	// ImageSize is the size the recipe image was generated at.
+	ImageSize string
+	// OnRecipeChunk optionally streams the recipe generation, receiving the recipe JSON as it's generated.
+	OnRecipeChunk func(chunk string)
+	// PantryIngredients are the ingredients the user has on hand, when the recipe is generated from them.
+	PantryIngredients []string
+	// SubscriptionTier optionally picks the tier's variant of the system prompt new recipes are generated with.
+	SubscriptionTier string
+	// usage is the usage of each API call made so far, guarded by usageMu since the image is generated concurrently
+	usage   []TokenUsage
+	usageMu sync.Mutex
+}
+
+// TokenUsage is the usage of an OpenAI model by API calls.
+type TokenUsage struct {
+	Model            string
+	PromptTokens     int
+	CompletionTokens int
+	Images           int
+	// CostMicros is the estimated cost of the usage, in micro-dollars.
+	CostMicros int64
+}
+
+// recipeModel returns the model the recipe is generated with, the override if there is one, otherwise the configured one.
+func (rm *RecipeManager) recipeModel() string {
+	if rm.Model != "" {
+		return rm.Model
+	}
+	return RecipeModel(rm.Cfg)
+}
+
+// newRecipeSysPrompt returns the system prompt template new recipes are generated with, the variant of the
+// subscription tier if it has one.
+func (rm *RecipeManager) newRecipeSysPrompt() config.OpenaiPromptTemplate {
+	return rm.Cfg.OpenaiPrompts.GenNewRecipeSysFor(rm.SubscriptionTier)
+}
+
+// recordUsage adds the token usage of a chat completion to the usage, and its cost to the spend.
+func (rm *RecipeManager) recordUsage(resp *openai.ChatCompletionResponse) {
+	costMicros := usageCostMicros(resp.Model, resp.Usage)
+	atomic.AddInt64(&rm.SpendMicros, costMicros)
+	rm.addUsage(TokenUsage{
+		Model:            resp.Model,
+		PromptTokens:     resp.Usage.PromptTokens,
+		CompletionTokens: resp.Usage.CompletionTokens,
+		CostMicros:       costMicros,
+	})
+}
+
+// addUsage adds the usage of an API call.
+func (rm *RecipeManager) addUsage(usage TokenUsage) {
+	rm.usageMu.Lock()
+	defer rm.usageMu.Unlock()
+	rm.usage = append(rm.usage, usage)
+}
+
+// Usage returns the usage of the API calls made so far, summed per model and sorted by model.
+func (rm *RecipeManager) Usage() []TokenUsage {
+	rm.usageMu.Lock()
+	defer rm.usageMu.Unlock()
+
+	indexByModel := make(map[string]int)
+	var usages []TokenUsage
+	for _, usage := range rm.usage {
+		i, ok := indexByModel[usage.Model]
+		if !ok {
+			i = len(usages)
+			indexByModel[usage.Model] = i
+			usages = append(usages, TokenUsage{Model: usage.Model})
+		}
+		total := &usages[i]
+		total.PromptTokens += usage.PromptTokens
+		total.CompletionTokens += usage.CompletionTokens
+		total.Images += usage.Images
+		total.CostMicros += usage.CostMicros
+	}
+
+	sort.Slice(usages, func(i, j int) bool { return usages[i].Model < usages[j].Model })
+	return usages
 }
 
 // GenerateRecipeWithChat generates a new recipe using chat.
 func (rm *RecipeManager) GenerateRecipeWithChat() error {
-	return generateRecipeWithChat(rm)
+	return generateWithLanguageCheck(rm, generateRecipeWithChat)
+}
+
+// GenerateRecipeWithRegenChat regenerates the recipe in RecipeHistoryEntries, modified per the user prompt.
+func (rm *RecipeManager) GenerateRecipeWithRegenChat() error {
+	return generateWithLanguageCheck(rm, generateRecipeWithRegenChat)
 }
 
 // GenerateRecipeWithImportVision generates a new recipe using vision import.
 func (rm *RecipeManager) GenerateRecipeWithImportVision() error {
-	return generateRecipeWithImportVision(rm)
+	return generateWithLanguageCheck(rm, generateRecipeWithImportVision)
+}
+
+// GenerateRecipeHashtags generates fresh hashtags for the current RecipeManager.RecipeDef,
+// then assigns them to RecipeManager.RecipeDef.Hashtags.
+func (rm *RecipeManager) GenerateRecipeHashtags() error {
+	return generateRecipeHashtags(rm)
+}
+
+// GenerateRecipePairings suggests fresh drink pairings for the current RecipeManager.RecipeDef,
+// then assigns them to RecipeManager.RecipeDef.Pairings.
+func (rm *RecipeManager) GenerateRecipePairings() error {
+	return generateRecipePairings(rm)
+}
+
+// ExplainRecipe explains the techniques behind the key steps of the current RecipeManager.RecipeDef,
+// then assigns the explanation to RecipeManager.Explanation.
+func (rm *RecipeManager) ExplainRecipe() error {
+	return generateRecipeExplanation(rm)
 }
 
 // GenerateRecipeImage generates an image using DALL-E based on the prompt in RecipeManager.RecipeDef.ImagePrompt,
 // then assigns the image bytes to RecipeManager.ImageBytes.
-func (rm *RecipeManager) GenerateRecipeImage() error {
-	return generateRecipeImage(rm)
+// The context cancels the image generation, separately from Ctx so the image can outlive the recipe's generation.
+func (rm *RecipeManager) GenerateRecipeImage(ctx context.Context) error {
+	return generateRecipeImage(ctx, rm)
 }
 
 // newOpenaiClient creates a new OpenAI client.
 func newOpenaiClient(cfg *config.Config) (*OpenaiClient, error) {
 	return &OpenaiClient{
-		Client: openai.NewClient(cfg.GetCurrentAPIKey()),
+		Client: newClientWithRetryAfter(cfg.GetCurrentAPIKey()),
 	}, nil
 }
 
 // createChatCompletionWithRetry creates a chat completion and retries if necessary.
-func createChatCompletionWithRetry(chatCompletionRequest *openai.ChatCompletionRequest, cfg *config.Config) (*openai.ChatCompletionResponse, error) {
-	maxRetries := 5
+// If the generator is nil, a new OpenAI API client is created for each try so the API key rotates.
+func createChatCompletionWithRetry(ctx context.Context, chatCompletionRequest *openai.ChatCompletionRequest, generator RecipeGenerator, cfg *config.Config) (*openai.ChatCompletionResponse, error) {
 	var resp openai.ChatCompletionResponse
-	var chatCompletionRespErr error
-	for i := 0; i < maxRetries; i++ {
-		c, err := newOpenaiClient(cfg)
-		if err != nil {
-			log.Printf("error: failed to create chat service: %v", err)
-			return nil, err
-		}
-
-		resp, chatCompletionRespErr = c.Client.CreateChatCompletion(
-			context.Background(),
-			*chatCompletionRequest,
-		)
-
-		if chatCompletionRespErr == nil && len(resp.Choices) > 0 {
-			break
+	err := withRetry(ctx, cfg, "Chat completion", func(ctx context.Context) error {
+		tryGenerator := generator
+		if tryGenerator == nil {
+			c, err := newOpenaiClient(cfg)
+			if err != nil {
+				log.Printf("error: failed to create chat service: %v", err)
+				return err
+			}
+			tryGenerator = c.Client
 		}
 
-		shouldRetry, waitTime, noRetryErr := handleAPIError(chatCompletionRespErr)
-		if !shouldRetry {
-			return nil, fmt.Errorf("error: failed to create chat completion: %v", noRetryErr)
+		var err error
+		resp, err = tryGenerator.CreateChatCompletion(ctx, *chatCompletionRequest)
+		if err == nil && len(resp.Choices) == 0 {
+			return errEmptyChoices
 		}
-
-		// Wait before next retry
-		// Wait time increases slightly per iteration
-		time.Sleep(waitTime * time.Duration(i))
-	}
-	if chatCompletionRespErr != nil {
-		return nil, fmt.Errorf("error: failed to create chat completion: exhausted maximum retries. Exiting. ChatCompletion error: %v", chatCompletionRespErr)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error: failed to create chat completion: %v", err)
 	}
 
 	return &resp, nil
 }
 
-// handleAPIError handles API errors and returns whether or not to retry, the wait time, and the error.
-func handleAPIError(respErr error) (shouldRetry bool, waitTime time.Duration, err error) {
+// errEmptyChoices is returned when a chat completion comes back without any choices, which is worth retrying.
+var errEmptyChoices = errors.New("chat completion returned no choices")
+
+// handleAPIError handles API errors and returns whether or not to retry, and the error.
+func handleAPIError(respErr error) (shouldRetry bool, err error) {
+	if errors.Is(respErr, errEmptyChoices) {
+		return true, respErr
+	}
 	e := &openai.APIError{}
 	if errors.As(respErr, &e) {
 		switch e.HTTPStatusCode {
 		case 401:
 			log.Printf("error: invalid auth or key. Will retry: %v", respErr)
-			return true, 0, errors.New("invalid auth or key. Will retry")
+			return true, errors.New("invalid auth or key. Will retry")
 			// We will rotate the keys on retry now
-			// return false, 0, errors.New("invalid auth or key. Do not retry")
+			// return false, errors.New("invalid auth or key. Do not retry")
 		case 429:
-			return true, 2 * time.Second, errors.New("rate limiting or engine overload. Will retry")
+			return true, errors.New("rate limiting or engine overload. Will retry")
 		case 500:
-			return true, 2 * time.Second, errors.New("openAI server error. Will retry")
+			return true, errors.New("openAI server error. Will retry")
 		default:
-			return false, 0, fmt.Errorf("unhandled error: %v", respErr)
+			return false, fmt.Errorf("unhandled error: %v", respErr)
 		}
 	}
-	return false, 0, fmt.Errorf("unhandled error: %v", respErr)
+	return false, fmt.Errorf("unhandled error: %v", respErr)
 }
@@ -0,0 +1,98 @@
+// Package openaitest provides a mock OpenAI API client, so recipe generation can be exercised without real API calls.
+package openaitest
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sync"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// MockClient implements the openai.RecipeGenerator and openai.ImageGenerator interfaces with configurable responses.
+// It records every request it receives.
+type MockClient struct {
+	// CreateChatCompletionFunc handles chat completion requests, when nil they fail.
+	CreateChatCompletionFunc func(ctx context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error)
+	// CreateImageFunc handles image requests, when nil they fail.
+	CreateImageFunc func(ctx context.Context, request openai.ImageRequest) (openai.ImageResponse, error)
+
+	mu                     sync.Mutex
+	chatCompletionRequests []openai.ChatCompletionRequest
+	imageRequests          []openai.ImageRequest
+}
+
+// CreateChatCompletion records the request and calls CreateChatCompletionFunc.
+func (m *MockClient) CreateChatCompletion(ctx context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	m.mu.Lock()
+	m.chatCompletionRequests = append(m.chatCompletionRequests, request)
+	m.mu.Unlock()
+
+	if m.CreateChatCompletionFunc == nil {
+		return openai.ChatCompletionResponse{}, errors.New("mock chat completion isn't configured")
+	}
+	return m.CreateChatCompletionFunc(ctx, request)
+}
+
+// CreateImage records the request and calls CreateImageFunc.
+func (m *MockClient) CreateImage(ctx context.Context, request openai.ImageRequest) (openai.ImageResponse, error) {
+	m.mu.Lock()
+	m.imageRequests = append(m.imageRequests, request)
+	m.mu.Unlock()
+
+	if m.CreateImageFunc == nil {
+		return openai.ImageResponse{}, errors.New("mock image isn't configured")
+	}
+	return m.CreateImageFunc(ctx, request)
+}
+
+// ChatCompletionRequests returns the chat completion requests received so far.
+func (m *MockClient) ChatCompletionRequests() []openai.ChatCompletionRequest {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]openai.ChatCompletionRequest(nil), m.chatCompletionRequests...)
+}
+
+// ImageRequests returns the image requests received so far.
+func (m *MockClient) ImageRequests() []openai.ImageRequest {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]openai.ImageRequest(nil), m.imageRequests...)
+}
+
+// FunctionCallResponse builds a chat completion response calling the named function with the arguments serialized to JSON,
+// like the responses recipes and hashtags are read from.
+func FunctionCallResponse(model string, name string, arguments interface{}) (openai.ChatCompletionResponse, error) {
+	argumentsJSON, err := json.Marshal(arguments)
+	if err != nil {
+		return openai.ChatCompletionResponse{}, err
+	}
+
+	return openai.ChatCompletionResponse{
+		Model: model,
+		Choices: []openai.ChatCompletionChoice{
+			{
+				Message: openai.ChatCompletionMessage{
+					Role: openai.ChatMessageRoleAssistant,
+					FunctionCall: &openai.FunctionCall{
+						Name:      name,
+						Arguments: string(argumentsJSON),
+					},
+				},
+			},
+		},
+	}, nil
+}
+
+// ImageResponse builds an image response carrying the image bytes base64 encoded.
+func ImageResponse(imageBytes []byte) openai.ImageResponse {
+	return openai.ImageResponse{
+		Data: []openai.ImageResponseDataInner{
+			{B64JSON: base64.StdEncoding.EncodeToString(imageBytes)},
+		},
+	}
+}
@@ -0,0 +1,105 @@
+package openai
+
+import (
+	"errors"
+	"fmt"
+
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/sashabaranov/go-openai/jsonschema"
+	"github.com/windoze95/saltybytes-api/internal/models"
+	"github.com/windoze95/saltybytes-api/internal/util"
+)
+
+// PairingsFunctionCallArgument is the argument returned by the create_pairings function call.
+type PairingsFunctionCallArgument struct {
+	Pairings models.Pairings `json:"pairings"`
+}
+
+// pairingsParam describes the drink pairings wanted from the model, shared by every function that returns them.
+func pairingsParam() jsonschema.Definition {
+	return jsonschema.Definition{
+		Type:        jsonschema.Array,
+		Description: "A few drinks that pair well with the recipe, such as wines, beers, or non-alcoholic options, each with a short rationale",
+		Items: &jsonschema.Definition{
+			Type: jsonschema.Object,
+			Properties: map[string]jsonschema.Definition{
+				"beverage":  {Type: jsonschema.String, Description: "Name of the drink, specific enough to buy or make, e.g. a grape variety or beer style"},
+				"rationale": {Type: jsonschema.String, Description: "One sentence on why the drink goes with the recipe"},
+			},
+		},
+	}
+}
+
+// generateRecipePairings suggests fresh drink pairings for the current recipe def.
+func generateRecipePairings(r *RecipeManager) error {
+	// Tests for the presence of a recipe to pair
+	if r.RecipeDef == nil {
+		return errors.New("RecipeDef is nil")
+	}
+
+	// Serialize the recipe def
+	recipeDefJSON, err := util.SerializeToJSONString(r.RecipeDef)
+	if err != nil {
+		return fmt.Errorf("failed to serialize RecipeDef: %v", err)
+	}
+
+	// Build the chat completion message stream
+	chatCompletionMessages := []openai.ChatCompletionMessage{}
+	if instruction := languageInstruction(r.Language); instruction != "" {
+		chatCompletionMessages = append(chatCompletionMessages, createSysMsg(instruction))
+	}
+	chatCompletionMessages = append(chatCompletionMessages, createUserMsg("Suggest drinks to pair with the following recipe: "+recipeDefJSON))
+
+	// Perform the chat completion
+	resp, err := createChatCompletionWithRetry(r.requestContext(), createPairingsRequest(chatCompletionMessages, RecipeModel(r.Cfg)), r.RecipeGenerator, r.Cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create chat completion: %v", err)
+	}
+	r.recordUsage(resp)
+
+	// Get the pairings
+	if len(resp.Choices) == 0 || resp.Choices[0].Message.FunctionCall == nil || resp.Choices[0].Message.FunctionCall.Arguments == "" {
+		return errors.New("OpenAI API returned an empty message")
+	}
+	pairingsJSON := resp.Choices[0].Message.FunctionCall.Arguments
+
+	// Deserialize the pairings
+	var functionCallArgument PairingsFunctionCallArgument
+	if err = util.DeserializeFromJSONString(pairingsJSON, &functionCallArgument); err != nil {
+		return fmt.Errorf("failed to deserialize PairingsFunctionCallArgument: %v", err)
+	}
+
+	// Set the pairings
+	r.RecipeDef.Pairings = functionCallArgument.Pairings
+
+	return nil
+}
+
+// createPairingsRequest creates a chat completion request for the drink pairings of a recipe.
+func createPairingsRequest(chatCompletionMessages []openai.ChatCompletionMessage, model string) *openai.ChatCompletionRequest {
+	// Define the function for use in the API call
+	functionDef := openai.FunctionDefinition{
+		Name: "create_pairings",
+		Parameters: jsonschema.Definition{
+			Type: jsonschema.Object,
+			Properties: map[string]jsonschema.Definition{
+				"pairings": pairingsParam(),
+			},
+			Required: []string{"pairings"},
+		},
+	}
+
+	// Create and return the chat completion request
+	return &openai.ChatCompletionRequest{
+		Model:       model,
+		Messages:    chatCompletionMessages,
+		Temperature: 0.7,
+		TopP:        0.9,
+		N:           1,
+		Stream:      false,
+		Functions:   []openai.FunctionDefinition{functionDef},
+		FunctionCall: &openai.FunctionCall{
+			Name: functionDef.Name,
+		},
+	}
+}
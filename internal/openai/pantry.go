@@ -0,0 +1,40 @@
+package openai
+
+import (
+	"strings"
+
+	"github.com/sashabaranov/go-openai/jsonschema"
+)
+
+// pantryInstruction tells the model to cook with the ingredients the user has on hand, and to report what it used.
+const pantryInstruction = "The user wants to cook with the ingredients they have on hand, listed in their message. Build the recipe around them, using as many as make a good dish, and keep ingredients they don't have to a minimum. Salt, pepper, cooking oil, and water can be assumed. List the user's ingredients the recipe uses in used_ingredients, spelled as they gave them, and every other ingredient it needs in additional_ingredients."
+
+// BuildPantryPrompt builds the user prompt of a recipe generated from the ingredients the user has on hand,
+// followed by their optional note on what they'd like to make.
+func BuildPantryPrompt(ingredients []string, note string) string {
+	var b strings.Builder
+	b.WriteString("Ingredients I have on hand:\n")
+	for _, ingredient := range ingredients {
+		b.WriteString("- " + ingredient + "\n")
+	}
+	if note = strings.TrimSpace(note); note != "" {
+		b.WriteString("\n" + note)
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// pantryParams returns the recipe def parameters reporting which of the user's ingredients a recipe uses.
+func pantryParams() map[string]jsonschema.Definition {
+	return map[string]jsonschema.Definition{
+		"used_ingredients": {
+			Type:        jsonschema.Array,
+			Description: "The ingredients the user has on hand that the recipe uses, spelled as the user gave them",
+			Items:       &jsonschema.Definition{Type: jsonschema.String},
+		},
+		"additional_ingredients": {
+			Type:        jsonschema.Array,
+			Description: "The ingredients the recipe needs that the user doesn't have on hand, aside from salt, pepper, cooking oil, and water",
+			Items:       &jsonschema.Definition{Type: jsonschema.String},
+		},
+	}
+}
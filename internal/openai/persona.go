@@ -0,0 +1,49 @@
+package openai
+
+import (
+	"strings"
+
+	"github.com/windoze95/saltybytes-api/internal/config"
+	"github.com/windoze95/saltybytes-api/internal/models"
+)
+
+// personaFramings swap the framing of the system prompt for a persona and set its default constraints.
+// The Michelin chef is the framing the system prompt templates are written in, so it has none.
+var personaFramings = map[models.Persona]string{
+	models.PersonaHomeCook: "Instead of a Michelin-starred chef, write as an experienced home cook. " +
+		"Favor familiar ingredients from an ordinary grocery store, standard kitchen equipment, and forgiving techniques. " +
+		"Keep the recipe approachable for a weeknight unless the request asks otherwise.",
+	models.PersonaBudgetStudent: "Instead of a Michelin-starred chef, write as a resourceful student cooking on a tight budget. " +
+		"Favor cheap pantry staples, few ingredients, minimal equipment (a single pot or pan where possible), and leftovers that keep. " +
+		"Suggest inexpensive substitutes for pricey ingredients.",
+	models.PersonaMealPrepAthlete: "Instead of a Michelin-starred chef, write as a meal-prep coach for athletes. " +
+		"Favor high-protein, nutrient-dense ingredients, recipes that scale to several portions, and dishes that store and reheat well. " +
+		"Mention how to portion and store the recipe in the instructions.",
+}
+
+// BuildSystemPrompt fills the system prompt template and applies the framing of the persona,
+// then the theme of the occasion when there is one, then the dietary restrictions.
+// Unknown personas get the template's own framing.
+func BuildSystemPrompt(cfg *config.Config, template config.OpenaiPromptTemplate, unitSystem string, requirements string, dietaryRestrictions []string, persona models.Persona, occasion *models.Occasion) (string, error) {
+	sysPrompt, err := cfg.OpenaiPrompts.FillSysPrompt(template, unitSystem, requirements)
+	if err != nil {
+		return "", err
+	}
+
+	if framing, ok := personaFramings[persona]; ok {
+		sysPrompt += "\n\n" + framing
+	}
+
+	if occasion != nil {
+		sysPrompt += "\n\nThe recipe is for " + occasion.Name + ". " + occasion.Guidance
+	}
+
+	// Dietary restrictions come last and apart from the freeform requirements, so nothing before them overrides them
+	if len(dietaryRestrictions) > 0 {
+		sysPrompt += "\n\nThe recipe must be " + strings.Join(dietaryRestrictions, ", ") + ". " +
+			"These dietary restrictions are hard constraints that hold regardless of anything else requested. " +
+			"Never use an ingredient that breaks one, substitute it instead."
+	}
+
+	return sysPrompt, nil
+}
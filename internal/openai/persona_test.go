@@ -0,0 +1,88 @@
+package openai
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/windoze95/saltybytes-api/internal/config"
+	"github.com/windoze95/saltybytes-api/internal/models"
+)
+
+func TestBuildSystemPromptPersonas(t *testing.T) {
+	cfg := &config.Config{}
+	template := config.OpenaiPromptTemplate("You are CulinaryAI, a Michelin-starred chef. Use {unitSystem}. {requirements}")
+	personas := []models.Persona{
+		models.PersonaMichelinChef,
+		models.PersonaHomeCook,
+		models.PersonaBudgetStudent,
+		models.PersonaMealPrepAthlete,
+	}
+
+	prompts := make(map[string]models.Persona, len(personas))
+	for _, persona := range personas {
+		prompt, err := BuildSystemPrompt(cfg, template, "Metric", "No nuts", nil, persona, nil)
+		if err != nil {
+			t.Fatalf("BuildSystemPrompt: %v", err)
+		}
+		if !strings.HasPrefix(prompt, "You are CulinaryAI, a Michelin-starred chef. Use Metric. No nuts") {
+			t.Fatalf("%s prompt %q doesn't start with the filled template", persona, prompt)
+		}
+		if other, ok := prompts[prompt]; ok {
+			t.Fatalf("%s and %s have the same prompt", persona, other)
+		}
+		prompts[prompt] = persona
+	}
+}
+
+func TestBuildSystemPromptUnknownPersona(t *testing.T) {
+	cfg := &config.Config{}
+	template := config.OpenaiPromptTemplate("You are CulinaryAI.")
+
+	prompt, err := BuildSystemPrompt(cfg, template, "Metric", "", nil, models.Persona("pirate"), nil)
+	if err != nil {
+		t.Fatalf("BuildSystemPrompt: %v", err)
+	}
+	if prompt != "You are CulinaryAI." {
+		t.Fatalf("prompt = %q, want the template's own framing", prompt)
+	}
+}
+
+func TestBuildSystemPromptDietaryRestrictionsComeLast(t *testing.T) {
+	cfg := &config.Config{}
+	template := config.OpenaiPromptTemplate("You are CulinaryAI.")
+	occasion := &models.Occasion{Name: "Game Day", Guidance: "Make it shareable."}
+
+	prompt, err := BuildSystemPrompt(cfg, template, "Metric", "", []string{"vegan", "gluten_free"}, models.PersonaHomeCook, occasion)
+	if err != nil {
+		t.Fatalf("BuildSystemPrompt: %v", err)
+	}
+	persona := strings.Index(prompt, "home cook")
+	theme := strings.Index(prompt, "Game Day")
+	restrictions := strings.Index(prompt, "The recipe must be vegan, gluten_free.")
+	if persona < 0 || theme < persona || restrictions < theme {
+		t.Fatalf("prompt %q doesn't have the persona, occasion, and dietary restrictions in order", prompt)
+	}
+}
+
+func TestBuildSystemPromptOccasion(t *testing.T) {
+	cfg := &config.Config{}
+	template := config.OpenaiPromptTemplate("You are CulinaryAI.")
+	occasion, _ := models.LookupOccasion("thanksgiving")
+
+	prompt, err := BuildSystemPrompt(cfg, template, "Metric", "", nil, models.Persona(""), occasion)
+	if err != nil {
+		t.Fatalf("BuildSystemPrompt: %v", err)
+	}
+	if want := "You are CulinaryAI.\n\nThe recipe is for Thanksgiving. " + occasion.Guidance; prompt != want {
+		t.Fatalf("prompt = %q, want %q", prompt, want)
+	}
+}
+
+func TestBuildSystemPromptTemplateThatCantBeFilled(t *testing.T) {
+	cfg := &config.Config{}
+	template := config.OpenaiPromptTemplate("You are CulinaryAI. Use {{.Units}}.")
+
+	if prompt, err := BuildSystemPrompt(cfg, template, "Metric", "", nil, models.PersonaHomeCook, nil); err == nil {
+		t.Fatalf("BuildSystemPrompt = %q, want an error", prompt)
+	}
+}
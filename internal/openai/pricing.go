@@ -0,0 +1,162 @@
+package openai
+
+import (
+	"fmt"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/windoze95/saltybytes-api/internal/config"
+)
+
+// modelPrice is the price of a chat model in micro-dollars per 1K tokens.
+type modelPrice struct {
+	prompt     int64
+	completion int64
+}
+
+// modelPrices are the prices of the chat models in use, matched by model name prefix.
+// Longer prefixes come first so a dated model version matches its own family.
+var modelPrices = []struct {
+	prefix string
+	price  modelPrice
+}{
+	{"gpt-4-vision", modelPrice{prompt: 10000, completion: 30000}},
+	{"gpt-4-turbo", modelPrice{prompt: 10000, completion: 30000}},
+	{"gpt-4-1106", modelPrice{prompt: 10000, completion: 30000}},
+	{"gpt-4-0125", modelPrice{prompt: 10000, completion: 30000}},
+	{"gpt-4", modelPrice{prompt: 30000, completion: 60000}},
+	{"gpt-3.5-turbo", modelPrice{prompt: 500, completion: 1500}},
+}
+
+// imagePrices are the prices of one image in micro-dollars, keyed by image model then size.
+var imagePrices = map[string]map[string]int64{
+	openai.CreateImageModelDallE2: {
+		openai.CreateImageSize256x256:   16000,
+		openai.CreateImageSize512x512:   18000,
+		openai.CreateImageSize1024x1024: 20000,
+	},
+	openai.CreateImageModelDallE3: {
+		openai.CreateImageSize1024x1024: 40000,
+		openai.CreateImageSize1792x1024: 80000,
+		openai.CreateImageSize1024x1792: 80000,
+	},
+}
+
+// imageHDPrices are the prices of one HD quality image in micro-dollars, for the image models that support it.
+var imageHDPrices = map[string]map[string]int64{
+	openai.CreateImageModelDallE3: {
+		openai.CreateImageSize1024x1024: 80000,
+		openai.CreateImageSize1792x1024: 120000,
+		openai.CreateImageSize1024x1792: 120000,
+	},
+}
+
+// Typical token counts of a recipe generation, used to estimate its cost before it runs.
+const (
+	typicalPromptTokens     = 2000
+	typicalCompletionTokens = 1500
+)
+
+// DefaultRecipeModel is the model recipes are generated with.
+const DefaultRecipeModel = openai.GPT4TurboPreview
+
+// BudgetRecipeModel is the cheaper model recipes are generated with when the full cost doesn't fit a budget.
+const BudgetRecipeModel = openai.GPT3Dot5Turbo
+
+// DefaultImageModel and DefaultImageSize are what recipe images are generated with when the config doesn't say.
+const (
+	DefaultImageModel = openai.CreateImageModelDallE2
+	DefaultImageSize  = openai.CreateImageSize512x512
+)
+
+// RecipeModel returns the configured recipe model, or the default one.
+func RecipeModel(cfg *config.Config) string {
+	if cfg.Models.RecipeModel != "" {
+		return cfg.Models.RecipeModel
+	}
+	return DefaultRecipeModel
+}
+
+// imageModelAndSize returns the configured image model and size, or the default ones.
+func imageModelAndSize(cfg *config.Config) (string, string) {
+	model, size := cfg.Models.ImageModel, cfg.Models.ImageSize
+	if model == "" {
+		model = DefaultImageModel
+	}
+	if size == "" {
+		size = DefaultImageSize
+	}
+	return model, size
+}
+
+// ValidateModels checks that the configured models are ones the API serves and that are priced here,
+// and that the image size is supported by the image model.
+func ValidateModels(cfg *config.Config) error {
+	recipeModels := map[string]string{"recipe_model": cfg.Models.RecipeModel}
+	for tier, model := range cfg.Models.RecipeModelsByTier {
+		recipeModels["recipe_models_by_tier."+tier] = model
+	}
+	for field, model := range recipeModels {
+		if model != "" && !isKnownChatModel(model) {
+			return fmt.Errorf("models.%s: unknown chat model %q", field, model)
+		}
+	}
+
+	model, size := imageModelAndSize(cfg)
+	sizes, ok := imagePrices[model]
+	if !ok {
+		return fmt.Errorf("models.image_model: unknown image model %q", model)
+	}
+	if _, ok := sizes[size]; !ok {
+		return fmt.Errorf("models.image_size: %q isn't supported by %s", size, model)
+	}
+
+	return nil
+}
+
+// isKnownChatModel checks if a chat model belongs to one of the priced model families.
+func isKnownChatModel(model string) bool {
+	for _, modelPrice := range modelPrices {
+		if strings.HasPrefix(model, modelPrice.prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// imageCostMicros returns the price of one image with the image model and options, in micro-dollars.
+func imageCostMicros(model string, options ImageOptions) int64 {
+	if options.Quality == openai.CreateImageQualityHD {
+		if price, ok := imageHDPrices[model][options.Size]; ok {
+			return price
+		}
+	}
+	return imagePrices[model][options.Size]
+}
+
+// usageCostMicros returns the cost of the token usage of a model in micro-dollars.
+// Unknown models are priced like GPT-4 so the cost isn't underestimated.
+func usageCostMicros(model string, usage openai.Usage) int64 {
+	price := modelPrice{prompt: 30000, completion: 60000}
+	for _, modelPrice := range modelPrices {
+		if strings.HasPrefix(model, modelPrice.prefix) {
+			price = modelPrice.price
+			break
+		}
+	}
+
+	return (int64(usage.PromptTokens)*price.prompt + int64(usage.CompletionTokens)*price.completion) / 1000
+}
+
+// EstimateGenerationCostMicros estimates the cost in micro-dollars of generating a recipe with the model,
+// and its image with the configured image model and size.
+func EstimateGenerationCostMicros(cfg *config.Config, model string) int64 {
+	usage := openai.Usage{PromptTokens: typicalPromptTokens, CompletionTokens: typicalCompletionTokens}
+	imageModel, imageOptions := resolveImageOptions(cfg, ImageOptions{})
+	return usageCostMicros(model, usage) + imageCostMicros(imageModel, imageOptions)
+}
+
+// NewClient creates an OpenAI API client with the given API key, e.g. a user's personal key.
+func NewClient(apiKey string) *openai.Client {
+	return newClientWithRetryAfter(apiKey)
+}
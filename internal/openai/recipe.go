@@ -9,13 +9,36 @@ import (
 	"github.com/windoze95/saltybytes-api/internal/models"
 )
 
+// hashtagsDescription describes the hashtags wanted from the model, shared by every function that returns hashtags.
+const hashtagsDescription = "Provide a lengthy and thorough list (ten or more) of hashtags relevant to the recipe, not the prompting. Alphanumeric characters only. No '#'. Exclude terms like 'recipe', 'homemade', 'DIY', or similar words, as they are understood to be implied. Omit the '#' symbol. Use camelCase formatting if more than one word (if it starts with a letter, the first letter is always lowercase). Note that the following example hashtags are for categorization purposes only and should not influence the actual recipe or ingredients: Instead of specific terms like 'grillSeason', 'grassFedBeef', and 'beetrootKetchup', use more general terms that could apply to similar dishes like 'grilled', 'grill', 'grassFed', 'burgers', 'beef', 'beetroot', 'ketchup'."
+
+// FunctionCallArgument is the argument returned by the create_recipe function call.
 type FunctionCallArgument struct {
 	models.RecipeDef
 	Summary string `json:"summarize_recipe_changes"`
 }
 
+// difficultyEnum returns the difficulties the model can choose from.
+func difficultyEnum() []string {
+	difficulties := make([]string, len(models.Difficulties))
+	for i, difficulty := range models.Difficulties {
+		difficulties[i] = string(difficulty)
+	}
+	return difficulties
+}
+
+// allergenEnum returns the allergens the model can detect.
+func allergenEnum() []string {
+	allergens := make([]string, len(models.Allergens))
+	for i, allergen := range models.Allergens {
+		allergens[i] = string(allergen)
+	}
+	return allergens
+}
+
 // createRecipeDefRequest creates a chat completion request for a recipe definition based on the chat completion messages.
-func createRecipeDefRequest(chatCompletionMessages []openai.ChatCompletionMessage, isRegen bool) (*openai.ChatCompletionRequest, error) {
+// A recipe generated from the user's ingredients also reports which of them it uses.
+func createRecipeDefRequest(chatCompletionMessages []openai.ChatCompletionMessage, isRegen bool, fromPantry bool) (*openai.ChatCompletionRequest, error) {
 	// Validate the chat completion messages
 	if len(chatCompletionMessages) == 0 {
 		return nil, errors.New("failed to create recipe chat completion: chatCompletionMessages is empty")
@@ -34,7 +57,7 @@ func createRecipeDefRequest(chatCompletionMessages []openai.ChatCompletionMessag
 				Type: jsonschema.Object,
 				Properties: map[string]jsonschema.Definition{
 					"name":   {Type: jsonschema.String, Description: "Name of the ingredient, do not include unit or amount in this field"},
-					"unit":   {Type: jsonschema.String, Description: "Unit for the ingredient, comply with UnitSystem specified.", Enum: []string{"pieces", "tsp", "tbsp", "fl oz", "cup", "pt", "qt", "gal", "oz", "lb", "mL", "L", "mg", "g", "kg", "pinch", "dash", "drop", "bushel"}},
+					"unit":   {Type: jsonschema.String, Description: "Unit for the ingredient, comply with UnitSystem specified.", Enum: models.UnitSymbols()},
 					"amount": {Type: jsonschema.Number, Description: "Amount of the ingredient"},
 				},
 			},
@@ -48,6 +71,15 @@ func createRecipeDefRequest(chatCompletionMessages []openai.ChatCompletionMessag
 			Type:        jsonschema.Number,
 			Description: "Total time to prepare the recipe(s) in minutes",
 		},
+		"servings": {
+			Type:        jsonschema.Integer,
+			Description: "Number of people the recipe serves",
+		},
+		"difficulty": {
+			Type:        jsonschema.String,
+			Description: "How hard the recipe is for a home cook to make",
+			Enum:        difficultyEnum(),
+		},
 		"image_prompt": {
 			Type:        jsonschema.String,
 			Description: "Prompt to generate an image for the recipe, this should be relavent to the recipe and not the user request",
@@ -59,9 +91,15 @@ func createRecipeDefRequest(chatCompletionMessages []openai.ChatCompletionMessag
 		// },
 		"hashtags": {
 			Type:        jsonschema.Array,
-			Description: "Provide a lengthy and thorough list (ten or more) of hashtags relevant to the recipe, not the prompting. Alphanumeric characters only. No '#'. Exclude terms like 'recipe', 'homemade', 'DIY', or similar words, as they are understood to be implied. Omit the '#' symbol. Use camelCase formatting if more than one word (if it starts with a letter, the first letter is always lowercase). Note that the following example hashtags are for categorization purposes only and should not influence the actual recipe or ingredients: Instead of specific terms like 'grillSeason', 'grassFedBeef', and 'beetrootKetchup', use more general terms that could apply to similar dishes like 'grilled', 'grill', 'grassFed', 'burgers', 'beef', 'beetroot', 'ketchup'.",
+			Description: hashtagsDescription,
 			Items:       &jsonschema.Definition{Type: jsonschema.String},
 		},
+		"allergens": {
+			Type:        jsonschema.Array,
+			Description: "Common allergens any ingredient of the recipe contains, including its sub-recipes. Leave empty if it contains none.",
+			Items:       &jsonschema.Definition{Type: jsonschema.String, Enum: allergenEnum()},
+		},
+		"pairings": pairingsParam(),
 		"linked_recipe_suggestions": {
 			Type:        jsonschema.Array,
 			Description: "Provide a list of recipe suggestions(just the titles) based on: 1. Homemade versions of store-bought ingredients used in this recipe. 2. Something that would pair well with this recipe.",
@@ -69,6 +107,23 @@ func createRecipeDefRequest(chatCompletionMessages []openai.ChatCompletionMessag
 		},
 	}
 
+	// Sub-recipes have the core fields of the main recipe
+	recipeDefParams["sub_recipes"] = jsonschema.Definition{
+		Type:        jsonschema.Array,
+		Description: "Components of the recipe that are recipes of their own and made from scratch, e.g. the buns and the sauce of a burger. List each one as an ingredient of the main recipe too. Leave empty if the recipe has a single component.",
+		Items: &jsonschema.Definition{
+			Type: jsonschema.Object,
+			Properties: map[string]jsonschema.Definition{
+				"title":        recipeDefParams["title"],
+				"ingredients":  recipeDefParams["ingredients"],
+				"instructions": recipeDefParams["instructions"],
+				"cook_time":    recipeDefParams["cook_time"],
+				"difficulty":   recipeDefParams["difficulty"],
+				"allergens":    recipeDefParams["allergens"],
+			},
+		},
+	}
+
 	// Conditionally add summarize_recipe_changes to parameters if this is a regenerate request
 	if summarizeChanges := os.Getenv("SUMMARIZE_RECIPE_CHANGES"); isRegen {
 		recipeDefParams["summarize_recipe_changes"] = jsonschema.Definition{
@@ -77,6 +132,12 @@ func createRecipeDefRequest(chatCompletionMessages []openai.ChatCompletionMessag
 		}
 	}
 
+	if fromPantry {
+		for name, param := range pantryParams() {
+			recipeDefParams[name] = param
+		}
+	}
+
 	// Define the function for use in the API call
 	functionDef := openai.FunctionDefinition{
 		Name: "create_recipe",
@@ -92,7 +153,7 @@ func createRecipeDefRequest(chatCompletionMessages []openai.ChatCompletionMessag
 
 	// Create and return the chat completion request
 	return &openai.ChatCompletionRequest{
-		Model:            openai.GPT4TurboPreview,
+		Model:            DefaultRecipeModel,
 		Messages:         chatCompletionMessages,
 		Temperature:      0.7,
 		TopP:             0.9,
@@ -0,0 +1,78 @@
+package openai
+
+import (
+	"errors"
+	"fmt"
+
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/windoze95/saltybytes-api/internal/models"
+	"github.com/windoze95/saltybytes-api/internal/util"
+)
+
+// generateRecipeWithRegenChat regenerates a recipe from its history, modified per the user prompt.
+func generateRecipeWithRegenChat(r *RecipeManager) error {
+	// Regeneration modifies an existing recipe, there must be a history
+	if len(r.RecipeHistoryEntries) == 0 {
+		return errors.New("RecipeHistoryEntries was empty")
+	}
+
+	// Build the chat completion message stream
+	sysPromptTemplate := r.Cfg.OpenaiPrompts.RegenRecipeSys
+	sysPrompt, err := BuildSystemPrompt(r.Cfg, sysPromptTemplate, r.UnitSystem, r.Requirements, r.DietaryRestrictions, r.Persona, r.Occasion)
+	if err != nil {
+		return err
+	}
+	chatCompletionMessages := []openai.ChatCompletionMessage{
+		createSysMsg(sysPrompt),
+	}
+	if instruction := languageInstruction(r.Language); instruction != "" {
+		chatCompletionMessages = append(chatCompletionMessages, createSysMsg(instruction))
+	}
+	historyMessages, err := processExistingRecipeHistoryEntries(r.RecipeHistoryEntries)
+	if err != nil {
+		return err
+	}
+	chatCompletionMessages = append(chatCompletionMessages, historyMessages...)
+	chatCompletionMessages = append(chatCompletionMessages, createUserMsg(r.UserPrompt))
+
+	// Create the request
+	recipeDefRequest, err := createRecipeDefRequest(chatCompletionMessages, true, false)
+	if err != nil {
+		return err
+	}
+	recipeDefRequest.Model = r.recipeModel()
+
+	// Perform the chat completion
+	resp, err := r.createChatCompletion(recipeDefRequest)
+	if err != nil {
+		return fmt.Errorf("failed to create chat completion: %v", err)
+	}
+
+	// Record what produced the recipe def
+	r.GeneratedWithModel = resp.Model
+	r.PromptVersion = sysPromptTemplate.Version()
+
+	// Get the recipe def
+	if len(resp.Choices) == 0 || resp.Choices[0].Message.FunctionCall == nil || resp.Choices[0].Message.FunctionCall.Arguments == "" {
+		return errors.New("OpenAI API returned an empty message")
+	}
+	recipeDefJSON := resp.Choices[0].Message.FunctionCall.Arguments
+
+	// Deserialize the recipe def
+	var functionCallArgument FunctionCallArgument
+	if err = util.DeserializeFromJSONString(recipeDefJSON, &functionCallArgument); err != nil {
+		return fmt.Errorf("failed to deserialize FunctionCallArgument: %v", err)
+	}
+
+	// Set the recipe def
+	r.RecipeDef = &functionCallArgument.RecipeDef
+
+	// Set the next history message
+	r.NextRecipeHistoryEntry = models.RecipeHistoryEntry{
+		UserPrompt:     r.UserPrompt,
+		RecipeResponse: &functionCallArgument.RecipeDef,
+		Type:           models.RecipeTypeRegenChat,
+	}
+
+	return nil
+}
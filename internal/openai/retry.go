@@ -0,0 +1,131 @@
+package openai
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/windoze95/saltybytes-api/internal/config"
+)
+
+// Defaults of the retry options that aren't configured.
+const (
+	defaultMaxRetries = 4
+	defaultBaseDelay  = time.Second
+	defaultMaxDelay   = 30 * time.Second
+)
+
+// retryAfterKey is the context key under which a request's Retry-After header is recorded.
+type retryAfterKey struct{}
+
+// retryAfterTransport records the Retry-After header of rate limited responses in the request's context,
+// since the errors of the API client don't carry the response headers.
+type retryAfterTransport struct {
+	base http.RoundTripper
+}
+
+// RoundTrip makes the request and records its Retry-After header if it was rate limited.
+func (t retryAfterTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusTooManyRequests {
+		return resp, err
+	}
+	if retryAfter, ok := req.Context().Value(retryAfterKey{}).(*time.Duration); ok {
+		*retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	}
+	return resp, err
+}
+
+// newClientWithRetryAfter creates an OpenAI API client whose rate limited requests record their Retry-After header.
+func newClientWithRetryAfter(apiKey string) *openai.Client {
+	clientConfig := openai.DefaultConfig(apiKey)
+	clientConfig.HTTPClient = &http.Client{Transport: retryAfterTransport{base: http.DefaultTransport}}
+	return openai.NewClientWithConfig(clientConfig)
+}
+
+// parseRetryAfter parses a Retry-After header, either a number of seconds or an HTTP date. It returns 0 if the header
+// is missing or invalid.
+func parseRetryAfter(header string, now time.Time) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(header); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
+
+// withRetry calls fn until it succeeds, fails with an error that isn't worth retrying, runs out of retries, or the
+// context is done. The waits back off exponentially from the configured base delay with jitter, unless a rate limited
+// attempt asked for a wait with its Retry-After header. The attempt's context carries where that header is recorded.
+func withRetry(ctx context.Context, cfg *config.Config, operation string, fn func(ctx context.Context) error) error {
+	maxRetries, baseDelay, maxDelay := retryOptions(cfg)
+
+	var err error
+	for retry := 0; ; retry++ {
+		var retryAfter time.Duration
+		attemptCtx := context.WithValue(ctx, retryAfterKey{}, &retryAfter)
+
+		err = fn(attemptCtx)
+		if err == nil {
+			return nil
+		}
+		// A canceled or timed out call isn't retried
+		if ctx.Err() != nil {
+			return err
+		}
+
+		shouldRetry, apiErr := handleAPIError(err)
+		if !shouldRetry {
+			return apiErr
+		}
+		if retry >= maxRetries {
+			return err
+		}
+
+		wait := retryAfter
+		if wait <= 0 {
+			wait = backoffDelay(retry, baseDelay, maxDelay)
+		}
+		log.Printf("%s attempt %d of %d failed, retrying in %v: %v", operation, retry+1, maxRetries+1, wait, apiErr)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// retryOptions returns the configured retry options, or the defaults. Configuring 0 retries disables retrying.
+func retryOptions(cfg *config.Config) (int, time.Duration, time.Duration) {
+	opts := cfg.OpenaiRetry
+	maxRetries, baseDelay, maxDelay := defaultMaxRetries, time.Duration(opts.BaseDelayMillis)*time.Millisecond, time.Duration(opts.MaxDelayMillis)*time.Millisecond
+	if opts.MaxRetries != nil && *opts.MaxRetries >= 0 {
+		maxRetries = *opts.MaxRetries
+	}
+	if baseDelay <= 0 {
+		baseDelay = defaultBaseDelay
+	}
+	if maxDelay <= 0 {
+		maxDelay = defaultMaxDelay
+	}
+	return maxRetries, baseDelay, maxDelay
+}
+
+// backoffDelay returns the wait before a retry, the base delay doubled per retry and capped at the max delay.
+// Half of it is random, so clients that failed together don't retry together.
+func backoffDelay(retry int, baseDelay, maxDelay time.Duration) time.Duration {
+	delay := maxDelay
+	if retry < 30 && baseDelay<<uint(retry) < maxDelay {
+		delay = baseDelay << uint(retry)
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
@@ -0,0 +1,145 @@
+package openai
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/windoze95/saltybytes-api/internal/config"
+)
+
+// intPtr returns a pointer to the int.
+func intPtr(i int) *int {
+	return &i
+}
+
+func TestRetryOptionsMaxRetries(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxRetries *int
+		want       int
+	}{
+		{"unset", nil, defaultMaxRetries},
+		{"negative", intPtr(-1), defaultMaxRetries},
+		{"no retries", intPtr(0), 0},
+		{"configured", intPtr(2), 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{}
+			cfg.OpenaiRetry.MaxRetries = tt.maxRetries
+			if got, _, _ := retryOptions(cfg); got != tt.want {
+				t.Fatalf("max retries = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithRetryAttempts(t *testing.T) {
+	tests := []struct {
+		name         string
+		maxRetries   *int
+		wantAttempts int
+	}{
+		{"no retries", intPtr(0), 1},
+		{"two retries", intPtr(2), 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{}
+			cfg.OpenaiRetry.MaxRetries = tt.maxRetries
+			cfg.OpenaiRetry.BaseDelayMillis = 1
+			cfg.OpenaiRetry.MaxDelayMillis = 1
+
+			attempts := 0
+			err := withRetry(context.Background(), cfg, "test call", func(ctx context.Context) error {
+				attempts++
+				return &openai.APIError{HTTPStatusCode: 500}
+			})
+			if err == nil {
+				t.Fatal("withRetry succeeded, want the last attempt's error")
+			}
+			if attempts != tt.wantAttempts {
+				t.Fatalf("got %d attempts, want %d", attempts, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestWithRetryDoesNotRetryUnhandledErrors(t *testing.T) {
+	attempts := 0
+	err := withRetry(context.Background(), &config.Config{}, "test call", func(ctx context.Context) error {
+		attempts++
+		return &openai.APIError{HTTPStatusCode: 400}
+	})
+	if err == nil || attempts != 1 {
+		t.Fatalf("got %d attempts and error %v, want 1 failed attempt", attempts, err)
+	}
+}
+
+func TestWithRetryStopsWhenCanceled(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.OpenaiRetry.MaxRetries = intPtr(3)
+	cfg.OpenaiRetry.BaseDelayMillis = 60000
+	cfg.OpenaiRetry.MaxDelayMillis = 60000
+
+	ctx, cancel := context.WithCancel(context.Background())
+	attempts := 0
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+
+	// The first attempt fails with an error worth retrying, the wait before the retry is cut short
+	start := time.Now()
+	err := withRetry(ctx, cfg, "test call", func(ctx context.Context) error {
+		attempts++
+		return &openai.APIError{HTTPStatusCode: 500}
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("withRetry error = %v, want context.Canceled", err)
+	}
+	if attempts != 1 || time.Since(start) > 5*time.Second {
+		t.Fatalf("got %d attempts in %v, want 1 attempt ended by the cancellation", attempts, time.Since(start))
+	}
+}
+
+func TestCreateChatCompletionWithRetryCanceledContext(t *testing.T) {
+	// The server stalls until the client gives up on the request
+	aborted := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The server only notices the client hanging up once it's read the body
+		io.Copy(io.Discard, r.Body)
+		select {
+		case <-r.Context().Done():
+			close(aborted)
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	defer server.Close()
+
+	clientConfig := openai.DefaultConfig("sk-test")
+	clientConfig.BaseURL = server.URL + "/v1"
+	client := openai.NewClientWithConfig(clientConfig)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := createChatCompletionWithRetry(ctx, &openai.ChatCompletionRequest{Model: openai.GPT4}, client, &config.Config{})
+	if err == nil {
+		t.Fatal("chat completion succeeded, want the context's error")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("chat completion returned after %v, want it aborted at the timeout", elapsed)
+	}
+	select {
+	case <-aborted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("in-flight request wasn't aborted")
+	}
+}
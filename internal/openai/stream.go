@@ -0,0 +1,132 @@
+package openai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// RecipeStreamer streams the chat completions recipes are generated from. It's implemented by the OpenAI API client.
+type RecipeStreamer interface {
+	CreateChatCompletionStream(ctx context.Context, request openai.ChatCompletionRequest) (*openai.ChatCompletionStream, error)
+}
+
+// charsPerToken is roughly how many characters of English text make up a token.
+const charsPerToken = 4
+
+// requestContext returns the context the recipe manager's API calls are made with.
+func (rm *RecipeManager) requestContext() context.Context {
+	if rm.Ctx == nil {
+		return context.Background()
+	}
+	return rm.Ctx
+}
+
+// createChatCompletion creates the chat completion of a recipe generation, streaming it when there's a chunk handler
+// and the generator can stream. Otherwise, it's created in one go with retries.
+func (rm *RecipeManager) createChatCompletion(request *openai.ChatCompletionRequest) (*openai.ChatCompletionResponse, error) {
+	if rm.OnRecipeChunk == nil {
+		resp, err := createChatCompletionWithRetry(rm.requestContext(), request, rm.RecipeGenerator, rm.Cfg)
+		if err != nil {
+			return nil, err
+		}
+		rm.recordUsage(resp)
+		return resp, nil
+	}
+
+	var streamer RecipeStreamer
+	if rm.RecipeGenerator == nil {
+		c, err := newOpenaiClient(rm.Cfg)
+		if err != nil {
+			return nil, err
+		}
+		streamer = c.Client
+	} else if s, ok := rm.RecipeGenerator.(RecipeStreamer); ok {
+		streamer = s
+	} else {
+		// The generator can't stream, so the recipe arrives as a single chunk
+		resp, err := createChatCompletionWithRetry(rm.requestContext(), request, rm.RecipeGenerator, rm.Cfg)
+		if err != nil {
+			return nil, err
+		}
+		rm.recordUsage(resp)
+		if len(resp.Choices) > 0 && resp.Choices[0].Message.FunctionCall != nil {
+			rm.OnRecipeChunk(resp.Choices[0].Message.FunctionCall.Arguments)
+		}
+		return resp, nil
+	}
+
+	resp, err := createChatCompletionStream(rm.requestContext(), request, streamer, rm.OnRecipeChunk)
+	if err != nil {
+		return nil, err
+	}
+	rm.recordUsage(resp)
+	return resp, nil
+}
+
+// createChatCompletionStream streams a function call chat completion, passing each chunk of the function call
+// arguments to onChunk, and assembles the chunks into a response.
+// Streams don't report their token usage, so it's estimated from the length of the messages.
+func createChatCompletionStream(ctx context.Context, request *openai.ChatCompletionRequest, streamer RecipeStreamer, onChunk func(string)) (*openai.ChatCompletionResponse, error) {
+	streamRequest := *request
+	streamRequest.Stream = true
+
+	stream, err := streamer.CreateChatCompletionStream(ctx, streamRequest)
+	if err != nil {
+		_, apiErr := handleAPIError(err)
+		return nil, fmt.Errorf("error: failed to create chat completion stream: %v", apiErr)
+	}
+	defer stream.Close()
+
+	var model, functionName string
+	var arguments strings.Builder
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error: chat completion stream failed: %v", err)
+		}
+
+		model = chunk.Model
+		if len(chunk.Choices) == 0 || chunk.Choices[0].Delta.FunctionCall == nil {
+			continue
+		}
+		delta := chunk.Choices[0].Delta.FunctionCall
+		if delta.Name != "" {
+			functionName = delta.Name
+		}
+		if delta.Arguments != "" {
+			arguments.WriteString(delta.Arguments)
+			onChunk(delta.Arguments)
+		}
+	}
+
+	var promptChars int
+	for _, message := range request.Messages {
+		promptChars += len(message.Content)
+	}
+
+	return &openai.ChatCompletionResponse{
+		Model: model,
+		Choices: []openai.ChatCompletionChoice{{
+			Message: openai.ChatCompletionMessage{
+				Role:         openai.ChatMessageRoleAssistant,
+				FunctionCall: &openai.FunctionCall{Name: functionName, Arguments: arguments.String()},
+			},
+			FinishReason: openai.FinishReasonFunctionCall,
+		}},
+		Usage: openai.Usage{
+			PromptTokens:     promptChars / charsPerToken,
+			CompletionTokens: arguments.Len() / charsPerToken,
+		},
+	}, nil
+}
+
+// Make sure the OpenAI API client can stream recipes.
+var _ RecipeStreamer = (*openai.Client)(nil)
@@ -0,0 +1,137 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/jpeg" // Register the JPEG decoder for image.DecodeConfig
+	_ "image/png"  // Register the PNG decoder for image.DecodeConfig
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jung-kurt/gofpdf"
+	"github.com/windoze95/saltybytes-api/internal/models"
+)
+
+// Page layout in millimeters.
+const (
+	pageMargin = 20.0
+	imageWidth = 90.0
+	lineHeight = 6.0
+)
+
+// RenderRecipe renders a recipe to a printable PDF: title, metadata, image, ingredients, and steps.
+// The image is optional, recipes without a usable image are rendered text-only.
+func RenderRecipe(recipe *models.Recipe, imageBytes []byte) ([]byte, error) {
+	doc := gofpdf.New("P", "mm", "Letter", "")
+	tr := doc.UnicodeTranslatorFromDescriptor("")
+
+	doc.SetTitle(recipe.Title, true)
+	doc.SetMargins(pageMargin, pageMargin, pageMargin)
+	doc.SetAutoPageBreak(true, pageMargin)
+	doc.AddPage()
+
+	// Title
+	doc.SetFont("Helvetica", "B", 22)
+	doc.MultiCell(0, 10, tr(recipe.Title), "", "L", false)
+
+	// Metadata
+	doc.SetFont("Helvetica", "", 10)
+	doc.SetTextColor(100, 100, 100)
+	doc.MultiCell(0, lineHeight, tr(recipeMetadata(recipe)), "", "L", false)
+	doc.SetTextColor(0, 0, 0)
+	doc.Ln(lineHeight)
+
+	// Image
+	if imageType := detectImageType(imageBytes); imageType != "" {
+		options := gofpdf.ImageOptions{ImageType: imageType, ReadDpi: true}
+		doc.RegisterImageOptionsReader("recipe", options, bytes.NewReader(imageBytes))
+		doc.ImageOptions("recipe", pageMargin, doc.GetY(), imageWidth, 0, true, options, 0, "")
+		doc.Ln(lineHeight)
+	}
+
+	// Ingredients
+	writeHeading(doc, tr("Ingredients"))
+	doc.SetFont("Helvetica", "", 11)
+	for _, ingredient := range recipe.Ingredients {
+		doc.MultiCell(0, lineHeight, tr("- "+FormatIngredient(ingredient)), "", "L", false)
+	}
+	doc.Ln(lineHeight)
+
+	// Instructions
+	writeHeading(doc, tr("Instructions"))
+	doc.SetFont("Helvetica", "", 11)
+	for i, instruction := range recipe.Instructions {
+		doc.MultiCell(0, lineHeight, tr(fmt.Sprintf("%d. %s", i+1, instruction)), "", "L", false)
+		doc.Ln(1)
+	}
+
+	var buffer bytes.Buffer
+	if err := doc.Output(&buffer); err != nil {
+		return nil, fmt.Errorf("failed to render recipe PDF: %v", err)
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// FormatIngredient formats an ingredient as a single line, e.g. "2 cup flour".
+func FormatIngredient(ingredient models.Ingredient) string {
+	parts := []string{}
+	if ingredient.Amount > 0 {
+		parts = append(parts, strconv.FormatFloat(ingredient.Amount, 'f', -1, 64))
+	}
+	if ingredient.Unit != "" && ingredient.Unit != "pieces" {
+		parts = append(parts, ingredient.Unit)
+	}
+	parts = append(parts, ingredient.Name)
+
+	return strings.Join(parts, " ")
+}
+
+// recipeMetadata returns the line of metadata shown under the title.
+func recipeMetadata(recipe *models.Recipe) string {
+	var metadata []string
+	if recipe.CookTime > 0 {
+		metadata = append(metadata, fmt.Sprintf("Cook time: %d minutes", recipe.CookTime))
+	}
+	if recipe.CreatedBy != nil && recipe.CreatedBy.Username != "" {
+		metadata = append(metadata, "By "+recipe.CreatedBy.Username)
+	}
+	if len(recipe.Hashtags) > 0 {
+		hashtags := make([]string, 0, len(recipe.Hashtags))
+		for _, tag := range recipe.Hashtags {
+			hashtags = append(hashtags, "#"+tag.Hashtag)
+		}
+		metadata = append(metadata, strings.Join(hashtags, " "))
+	}
+
+	return strings.Join(metadata, "  |  ")
+}
+
+// writeHeading writes a section heading.
+func writeHeading(doc *gofpdf.Fpdf, heading string) {
+	doc.SetFont("Helvetica", "B", 14)
+	doc.CellFormat(0, 8, heading, "", 1, "L", false, 0, "")
+}
+
+// detectImageType returns the gofpdf image type of the image bytes, or an empty string if they can't be embedded.
+func detectImageType(imageBytes []byte) string {
+	if len(imageBytes) == 0 {
+		return ""
+	}
+
+	// Make sure the image is intact, gofpdf can't recover from a broken one
+	if _, _, err := image.DecodeConfig(bytes.NewReader(imageBytes)); err != nil {
+		return ""
+	}
+
+	switch http.DetectContentType(imageBytes) {
+	case "image/png":
+		return "PNG"
+	case "image/jpeg":
+		return "JPG"
+	default:
+		return ""
+	}
+}
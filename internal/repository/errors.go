@@ -5,7 +5,32 @@ type NotFoundError struct {
 	message string
 }
 
+// NewNotFoundError creates a NotFoundError, for resources found missing outside the repository.
+func NewNotFoundError(message string) NotFoundError {
+	return NotFoundError{message: message}
+}
+
 // Error returns the error message.
 func (e NotFoundError) Error() string {
 	return e.message
 }
+
+// DuplicateError is an error type for when a unique field is already taken by another resource.
+type DuplicateError struct {
+	message string
+}
+
+// Error returns the error message.
+func (e DuplicateError) Error() string {
+	return e.message
+}
+
+// AlreadyProcessedError is an error type for when an event was already applied, so it's skipped.
+type AlreadyProcessedError struct {
+	message string
+}
+
+// Error returns the error message.
+func (e AlreadyProcessedError) Error() string {
+	return e.message
+}
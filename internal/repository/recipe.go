@@ -3,9 +3,14 @@ package repository
 import (
 	"errors"
 	"log"
+	"strings"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
 	"github.com/windoze95/saltybytes-api/internal/models"
+	"github.com/windoze95/saltybytes-api/internal/util"
 )
 
 // RecipeRepository is a repository for interacting with recipes.
@@ -26,6 +31,7 @@ func (r *RecipeRepository) GetRecipeByID(recipeID uint) (*models.Recipe, error)
 		Preload("CreatedBy", func(db *gorm.DB) *gorm.DB {
 			return db.Select("Username") // Select only Username
 		}).
+		Preload("LinkedRecipes").
 		Where("id = ?", recipeID).
 		First(&recipe).Error
 	if err != nil {
@@ -41,6 +47,219 @@ func (r *RecipeRepository) GetRecipeByID(recipeID uint) (*models.Recipe, error)
 	return &recipe, nil
 }
 
+// GetRecipeWithLinkedRecipes retrieves a recipe with its linked sub-recipes.
+func (r *RecipeRepository) GetRecipeWithLinkedRecipes(recipeID uint) (*models.Recipe, error) {
+	var recipe models.Recipe
+
+	err := r.DB.Preload("LinkedRecipes").
+		Where("id = ?", recipeID).
+		First(&recipe).Error
+	if err != nil {
+		log.Printf("Error retrieving recipe with linked recipes: %v", err)
+
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, NotFoundError{message: "Recipe not found"}
+		}
+
+		return nil, err
+	}
+
+	return &recipe, nil
+}
+
+// applyRecipeFilter narrows a recipe query down to the recipes that match the filter.
+func applyRecipeFilter(query *gorm.DB, filter util.RecipeFilter) *gorm.DB {
+	if filter.MinCookTime > 0 {
+		query = query.Where("recipes.cook_time >= ?", filter.MinCookTime)
+	}
+	if filter.MaxCookTime > 0 {
+		query = query.Where("recipes.cook_time <= ?", filter.MaxCookTime)
+	}
+	if filter.Difficulty != "" {
+		query = query.Where("recipes.difficulty = ?", filter.Difficulty)
+	}
+	if len(filter.ExcludeAllergens) > 0 {
+		// Recipes saved before allergens were detected have none, so they aren't left out
+		query = query.Where("NOT (COALESCE(recipes.allergens, '{}') && ?)", pq.Array(filter.ExcludeAllergens))
+	}
+	return query
+}
+
+// ListRecipesByUser retrieves a page of the recipes a user created that match the filter, newest first, and how many
+// there are in total. Sub-recipes are left out, they're listed with the recipe they're part of.
+func (r *RecipeRepository) ListRecipesByUser(userID uint, filter util.RecipeFilter, limit, offset int) ([]models.Recipe, int64, error) {
+	var total int64
+	query := r.DB.Model(&models.Recipe{}).Where("created_by_id = ? AND parent_recipe_id IS NULL", userID)
+	query = applyRecipeFilter(query, filter)
+	if err := query.Count(&total).Error; err != nil {
+		log.Printf("Error counting user recipes: %v", err)
+		return nil, 0, err
+	}
+
+	var recipes []models.Recipe
+	err := query.Preload("Hashtags").
+		Preload("CreatedBy", func(db *gorm.DB) *gorm.DB {
+			return db.Select("id, username")
+		}).
+		Order("created_at DESC").
+		Limit(limit).
+		Offset(offset).
+		Find(&recipes).Error
+	if err != nil {
+		log.Printf("Error listing user recipes: %v", err)
+		return nil, 0, err
+	}
+
+	return recipes, total, nil
+}
+
+// ListCollectedRecipesByUser retrieves a page of the recipes a user collected that match the filter, newest first, and
+// how many there are in total.
+func (r *RecipeRepository) ListCollectedRecipesByUser(userID uint, filter util.RecipeFilter, limit, offset int) ([]models.Recipe, int64, error) {
+	var total int64
+	query := r.DB.Model(&models.Recipe{}).
+		Joins("JOIN user_collected_recipes ON user_collected_recipes.recipe_id = recipes.id").
+		Where("user_collected_recipes.user_id = ?", userID)
+	query = applyRecipeFilter(query, filter)
+	if err := query.Count(&total).Error; err != nil {
+		log.Printf("Error counting collected recipes: %v", err)
+		return nil, 0, err
+	}
+
+	// Only select the recipe columns, the tag columns share their names
+	var recipes []models.Recipe
+	err := query.Select("recipes.*").
+		Preload("Hashtags").
+		Preload("CreatedBy", func(db *gorm.DB) *gorm.DB {
+			return db.Select("id, username")
+		}).
+		Order("recipes.created_at DESC").
+		Limit(limit).
+		Offset(offset).
+		Find(&recipes).Error
+	if err != nil {
+		log.Printf("Error listing collected recipes: %v", err)
+		return nil, 0, err
+	}
+
+	return recipes, total, nil
+}
+
+// IsRecipeCollected checks if a user collected a recipe.
+func (r *RecipeRepository) IsRecipeCollected(userID uint, recipeID uint) (bool, error) {
+	var count int
+	err := r.DB.Table("user_collected_recipes").
+		Where("user_id = ? AND recipe_id = ?", userID, recipeID).
+		Count(&count).Error
+	if err != nil {
+		log.Printf("Error checking collected recipe: %v", err)
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// CollectRecipe adds a recipe to a user's collected recipes, unless it's already there.
+func (r *RecipeRepository) CollectRecipe(userID uint, recipeID uint) error {
+	user := &models.User{Model: gorm.Model{ID: userID}}
+	recipe := &models.Recipe{Model: gorm.Model{ID: recipeID}}
+
+	err := r.DB.Model(user).Association("CollectedRecipes").Append(recipe).Error
+	if err != nil {
+		log.Printf("Error collecting recipe: %v", err)
+	}
+	return err
+}
+
+// UncollectRecipe removes a recipe from a user's collected recipes.
+func (r *RecipeRepository) UncollectRecipe(userID uint, recipeID uint) error {
+	user := &models.User{Model: gorm.Model{ID: userID}}
+	recipe := &models.Recipe{Model: gorm.Model{ID: recipeID}}
+
+	err := r.DB.Model(user).Association("CollectedRecipes").Delete(recipe).Error
+	if err != nil {
+		log.Printf("Error uncollecting recipe: %v", err)
+	}
+	return err
+}
+
+// ListRecipesByTag retrieves a page of the recipes tagged with a hashtag that match the filter, newest first, and how
+// many there are in total.
+func (r *RecipeRepository) ListRecipesByTag(hashtag string, filter util.RecipeFilter, limit, offset int) ([]models.Recipe, int64, error) {
+	var total int64
+	query := r.DB.Model(&models.Recipe{}).
+		Joins("JOIN recipe_tags ON recipe_tags.recipe_id = recipes.id").
+		Joins("JOIN tags ON tags.id = recipe_tags.tag_id").
+		Where("tags.hashtag = ? AND tags.deleted_at IS NULL", hashtag)
+	query = applyRecipeFilter(query, filter)
+	if err := query.Count(&total).Error; err != nil {
+		log.Printf("Error counting tagged recipes: %v", err)
+		return nil, 0, err
+	}
+
+	var recipes []models.Recipe
+	err := query.Preload("Hashtags").
+		Preload("CreatedBy", func(db *gorm.DB) *gorm.DB {
+			return db.Select("id, username")
+		}).
+		Order("recipes.created_at DESC").
+		Limit(limit).
+		Offset(offset).
+		Find(&recipes).Error
+	if err != nil {
+		log.Printf("Error listing tagged recipes: %v", err)
+		return nil, 0, err
+	}
+
+	return recipes, total, nil
+}
+
+// ListFeedRecipes lists the newest completed recipes that match the filter, sub-recipes aside, created before the cursor,
+// newest first.
+// A nil cursor starts from the newest recipe. Recipes created while paging come before the cursor, so they never shift
+// the pages after it.
+func (r *RecipeRepository) ListFeedRecipes(before *util.FeedCursor, filter util.RecipeFilter, limit int) ([]models.Recipe, error) {
+	query := r.DB.Model(&models.Recipe{}).
+		Where("recipes.parent_recipe_id IS NULL AND recipes.generation_status = ?", models.GenerationComplete)
+	if before != nil {
+		query = query.Where("(recipes.created_at, recipes.id) < (?, ?)", before.CreatedAt, before.ID)
+	}
+	query = applyRecipeFilter(query, filter)
+
+	var recipes []models.Recipe
+	err := query.Preload("Hashtags").
+		Preload("CreatedBy", func(db *gorm.DB) *gorm.DB {
+			return db.Select("id, username")
+		}).
+		Order("recipes.created_at DESC, recipes.id DESC").
+		Limit(limit).
+		Find(&recipes).Error
+	if err != nil {
+		log.Printf("Error listing feed recipes: %v", err)
+		return nil, err
+	}
+
+	return recipes, nil
+}
+
+// ListPopularTags retrieves the hashtags with the most recipes, and how many recipes each has.
+func (r *RecipeRepository) ListPopularTags(limit int) ([]NameCount, error) {
+	var tags []NameCount
+	err := r.DB.Raw(`SELECT tags.hashtag AS name, COUNT(*) AS count
+		FROM tags
+		JOIN recipe_tags ON recipe_tags.tag_id = tags.id
+		JOIN recipes ON recipes.id = recipe_tags.recipe_id
+		WHERE tags.deleted_at IS NULL AND recipes.deleted_at IS NULL
+		GROUP BY tags.hashtag ORDER BY count DESC, name LIMIT ?`, limit).
+		Scan(&tags).Error
+	if err != nil {
+		log.Printf("Error listing popular tags: %v", err)
+		return nil, err
+	}
+
+	return tags, nil
+}
+
 // GetHistoryByID retrieves a recipe history by its ID.
 func (r *RecipeRepository) GetHistoryByID(historyID uint) (*models.RecipeHistory, error) {
 	history := new(models.RecipeHistory)
@@ -55,6 +274,26 @@ func (r *RecipeRepository) GetHistoryByID(historyID uint) (*models.RecipeHistory
 	return history, nil
 }
 
+// GetRecipeOwnerByHistoryID retrieves the ID of the user who created the recipe a history belongs to.
+// Deleted recipes are included, their history is still theirs.
+func (r *RecipeRepository) GetRecipeOwnerByHistoryID(historyID uint) (uint, error) {
+	var recipe models.Recipe
+
+	err := r.DB.Unscoped().
+		Select("id, created_by_id").
+		Where("history_id = ?", historyID).
+		First(&recipe).Error
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return 0, NotFoundError{message: "Recipe not found"}
+		}
+		log.Printf("Error retrieving recipe owner by history: %v", err)
+		return 0, err
+	}
+
+	return recipe.CreatedByID, nil
+}
+
 // GetRecipeHistoryEntriesAfterID retrieves entries belonging to a specific RecipeHistory
 // and having an ID greater than a given value.
 func (r *RecipeRepository) GetRecipeHistoryEntriesAfterID(historyID uint, afterID uint) ([]models.RecipeHistoryEntry, error) {
@@ -87,15 +326,327 @@ func (r *RecipeRepository) CreateRecipe(recipe *models.Recipe) error {
 	return tx.Commit().Error
 }
 
-// DeleteRecipe deletes a recipe.
+// DeleteRecipe deletes a recipe, with the sub-recipes it owns.
+// They're deleted at the same time as the recipe, so RestoreRecipe can tell which to restore with it.
 func (r *RecipeRepository) DeleteRecipe(recipeID uint) error {
-	err := r.DB.Delete(&models.Recipe{}, recipeID).Error
+	err := r.DB.Model(&models.Recipe{}).
+		Where("id = ? OR parent_recipe_id = ?", recipeID, recipeID).
+		UpdateColumn("deleted_at", gorm.NowFunc()).Error
 	if err != nil {
 		log.Printf("Error deleting recipe: %v", err)
 	}
 	return err
 }
 
+// AdminRecipeFilter narrows the recipes listed for moderators down. Zero values don't filter.
+type AdminRecipeFilter struct {
+	Recipe           util.RecipeFilter
+	CreatedByID      uint
+	GenerationStatus models.GenerationStatus
+	Title            string // Part of the title, regardless of case
+	IncludeDeleted   bool
+}
+
+// ListRecipesForAdmin retrieves a page of every user's recipes that match the filter, newest first, and how many there
+// are in total, including the deleted ones if the filter asks for them.
+func (r *RecipeRepository) ListRecipesForAdmin(filter AdminRecipeFilter, limit, offset int) ([]models.Recipe, int64, error) {
+	query := r.DB.Model(&models.Recipe{})
+	if filter.IncludeDeleted {
+		query = query.Unscoped()
+	}
+	query = applyRecipeFilter(query, filter.Recipe)
+	if filter.CreatedByID != 0 {
+		query = query.Where("recipes.created_by_id = ?", filter.CreatedByID)
+	}
+	if filter.GenerationStatus != "" {
+		query = query.Where("recipes.generation_status = ?", filter.GenerationStatus)
+	}
+	if filter.Title != "" {
+		query = query.Where("recipes.title ILIKE ?", "%"+escapeLike(filter.Title)+"%")
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		log.Printf("Error counting recipes for admin: %v", err)
+		return nil, 0, err
+	}
+
+	var recipes []models.Recipe
+	err := query.Preload("Hashtags").
+		Preload("CreatedBy", func(db *gorm.DB) *gorm.DB {
+			return db.Select("id, username")
+		}).
+		Order("recipes.created_at DESC").
+		Limit(limit).
+		Offset(offset).
+		Find(&recipes).Error
+	if err != nil {
+		log.Printf("Error listing recipes for admin: %v", err)
+		return nil, 0, err
+	}
+
+	return recipes, total, nil
+}
+
+// ModerateRecipe deletes a recipe on behalf of a moderator, with the sub-recipes it owns, and records the moderator.
+// The recipe is purged with the rest of the trash, but its owner can't restore it.
+func (r *RecipeRepository) ModerateRecipe(recipeID uint, moderatorID uint) error {
+	tx := r.DB.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	result := tx.Model(&models.Recipe{}).
+		Where("id = ?", recipeID).
+		UpdateColumn("moderated_by_id", moderatorID)
+	if result.Error != nil {
+		tx.Rollback()
+		log.Printf("Error moderating recipe: %v", result.Error)
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		tx.Rollback()
+		return NotFoundError{message: "Recipe not found"}
+	}
+
+	err := tx.Model(&models.Recipe{}).
+		Where("id = ? OR parent_recipe_id = ?", recipeID, recipeID).
+		UpdateColumn("deleted_at", gorm.NowFunc()).Error
+	if err != nil {
+		tx.Rollback()
+		log.Printf("Error deleting moderated recipe: %v", err)
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		log.Printf("Error committing transaction in ModerateRecipe: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// ListTrashedRecipesByUser retrieves a page of the recipes a user deleted since deletedSince, most recently deleted first,
+// and how many there are in total. Recipes whose generation failed or that a moderator removed are deleted too, but
+// they're left out, as are sub-recipes deleted along with their recipe.
+func (r *RecipeRepository) ListTrashedRecipesByUser(userID uint, deletedSince time.Time, limit, offset int) ([]models.Recipe, int64, error) {
+	var total int64
+	query := r.DB.Unscoped().Model(&models.Recipe{}).
+		Where("created_by_id = ? AND deleted_at IS NOT NULL AND deleted_at > ?", userID, deletedSince).
+		Where("generation_status <> ? AND moderated_by_id IS NULL", models.GenerationFailed).
+		Where("NOT EXISTS (SELECT 1 FROM recipes parents WHERE parents.id = recipes.parent_recipe_id AND parents.deleted_at = recipes.deleted_at)")
+	if err := query.Count(&total).Error; err != nil {
+		log.Printf("Error counting trashed recipes: %v", err)
+		return nil, 0, err
+	}
+
+	var recipes []models.Recipe
+	err := query.Preload("Hashtags").
+		Preload("CreatedBy", func(db *gorm.DB) *gorm.DB {
+			return db.Select("id, username")
+		}).
+		Order("deleted_at DESC").
+		Limit(limit).
+		Offset(offset).
+		Find(&recipes).Error
+	if err != nil {
+		log.Printf("Error listing trashed recipes: %v", err)
+		return nil, 0, err
+	}
+
+	return recipes, total, nil
+}
+
+// GetTrashedRecipeByID retrieves a deleted recipe by its ID, with its creator and deletion time.
+func (r *RecipeRepository) GetTrashedRecipeByID(recipeID uint) (*models.Recipe, error) {
+	var recipe models.Recipe
+
+	err := r.DB.Unscoped().
+		Select("id, created_by_id, generation_status, moderated_by_id, deleted_at").
+		Where("id = ? AND deleted_at IS NOT NULL", recipeID).
+		First(&recipe).Error
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, NotFoundError{message: "Recipe not found in trash"}
+		}
+		log.Printf("Error retrieving trashed recipe: %v", err)
+		return nil, err
+	}
+
+	return &recipe, nil
+}
+
+// PurgeTrashedRecipes permanently deletes up to batchSize of the recipes deleted before deletedBefore, with the rows
+// that belong to them, and returns their IDs and image keys so their images can be purged too.
+// The recipes are locked with SKIP LOCKED, so instances purging at the same time each take a different batch.
+func (r *RecipeRepository) PurgeTrashedRecipes(deletedBefore time.Time, batchSize int) ([]models.Recipe, error) {
+	// Start a new transaction
+	tx := r.DB.Begin()
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	var recipes []models.Recipe
+	err := tx.Unscoped().
+		Set("gorm:query_option", "FOR UPDATE SKIP LOCKED").
+		Select("id, history_id, image_key").
+		Where("deleted_at IS NOT NULL AND deleted_at < ?", deletedBefore).
+		Order("deleted_at").
+		Limit(batchSize).
+		Find(&recipes).Error
+	if err != nil {
+		tx.Rollback()
+		log.Printf("Error locking trashed recipes: %v", err)
+		return nil, err
+	}
+
+	if len(recipes) == 0 {
+		return nil, tx.Commit().Error
+	}
+
+	recipeIDs := make([]uint, len(recipes))
+	historyIDs := make([]uint, len(recipes))
+	for i, recipe := range recipes {
+		recipeIDs[i] = recipe.ID
+		historyIDs[i] = recipe.HistoryID
+	}
+
+	if err := purgeRecipes(tx, recipeIDs, historyIDs); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		log.Printf("Error committing transaction in PurgeTrashedRecipes: %v", err)
+		return nil, err
+	}
+
+	return recipes, nil
+}
+
+// ListRecipeOwnership retrieves the ID, owner, parent, and image key of each of the recipes that exist, including those
+// in the trash, so they can be checked before they're deleted.
+func (r *RecipeRepository) ListRecipeOwnership(recipeIDs []uint) ([]models.Recipe, error) {
+	var recipes []models.Recipe
+	err := r.DB.Unscoped().
+		Select("id, created_by_id, parent_recipe_id, image_key").
+		Where("id IN (?)", recipeIDs).
+		Find(&recipes).Error
+	if err != nil {
+		log.Printf("Error retrieving recipe ownership: %v", err)
+		return nil, err
+	}
+
+	return recipes, nil
+}
+
+// PurgeRecipes permanently deletes the recipes, whether or not they're in the trash, with the sub-recipes they own,
+// all in one transaction.
+func (r *RecipeRepository) PurgeRecipes(recipeIDs []uint) error {
+	// Start a new transaction
+	tx := r.DB.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	var recipes []models.Recipe
+	err := tx.Unscoped().
+		Set("gorm:query_option", "FOR UPDATE").
+		Select("id, history_id").
+		Where("id IN (?) OR parent_recipe_id IN (?)", recipeIDs, recipeIDs).
+		Find(&recipes).Error
+	if err != nil {
+		tx.Rollback()
+		log.Printf("Error locking recipes to purge: %v", err)
+		return err
+	}
+
+	if len(recipes) == 0 {
+		return tx.Commit().Error
+	}
+
+	purgeIDs := make([]uint, len(recipes))
+	historyIDs := make([]uint, len(recipes))
+	for i, recipe := range recipes {
+		purgeIDs[i] = recipe.ID
+		historyIDs[i] = recipe.HistoryID
+	}
+
+	if err := purgeRecipes(tx, purgeIDs, historyIDs); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		log.Printf("Error committing transaction in PurgeRecipes: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// purgeRecipes permanently deletes the recipes and their histories in the transaction, with the rows that belong to them.
+func purgeRecipes(tx *gorm.DB, recipeIDs, historyIDs []uint) error {
+	// Delete everything that belongs to the recipes before the recipes themselves
+	purges := []struct {
+		what  string
+		purge func() error
+	}{
+		{"recipe tags", func() error {
+			return tx.Exec("DELETE FROM recipe_tags WHERE recipe_id IN (?)", recipeIDs).Error
+		}},
+		{"recipe links", func() error {
+			return tx.Exec("DELETE FROM recipe_linked_recipes WHERE recipe_id IN (?) OR link_recipe_id IN (?)", recipeIDs, recipeIDs).Error
+		}},
+		{"recipe collections", func() error {
+			return tx.Exec("DELETE FROM user_collected_recipes WHERE recipe_id IN (?)", recipeIDs).Error
+		}},
+		{"recipes made", func() error {
+			return tx.Unscoped().Where("recipe_id IN (?)", recipeIDs).Delete(&models.RecipeMade{}).Error
+		}},
+		{"recipe ratings", func() error {
+			return tx.Unscoped().Where("recipe_id IN (?)", recipeIDs).Delete(&models.RecipeRating{}).Error
+		}},
+		{"recipe explanations", func() error {
+			return tx.Unscoped().Where("recipe_id IN (?)", recipeIDs).Delete(&models.RecipeExplanation{}).Error
+		}},
+		{"recipe history entries", func() error {
+			return tx.Unscoped().Where("recipe_history_id IN (?)", historyIDs).Delete(&models.RecipeHistoryEntry{}).Error
+		}},
+		{"recipe histories", func() error {
+			return tx.Unscoped().Where("id IN (?)", historyIDs).Delete(&models.RecipeHistory{}).Error
+		}},
+		// Forks of the recipes are kept, they just no longer point to them
+		{"fork links", func() error {
+			return tx.Unscoped().Model(&models.Recipe{}).
+				Where("forked_from_id IN (?)", recipeIDs).
+				UpdateColumn("ForkedFromID", nil).Error
+		}},
+		{"recipes", func() error {
+			return tx.Unscoped().Where("id IN (?)", recipeIDs).Delete(&models.Recipe{}).Error
+		}},
+	}
+	for _, p := range purges {
+		if err := p.purge(); err != nil {
+			log.Printf("Error purging %s: %v", p.what, err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RestoreRecipe takes a deleted recipe out of the trash, with the sub-recipes deleted along with it.
+func (r *RecipeRepository) RestoreRecipe(recipeID uint) error {
+	err := r.DB.Unscoped().Model(&models.Recipe{}).
+		Where("id = ? OR (parent_recipe_id = ? AND deleted_at = (SELECT deleted_at FROM recipes WHERE id = ?))", recipeID, recipeID, recipeID).
+		Update("DeletedAt", nil).Error
+	if err != nil {
+		log.Printf("Error restoring recipe: %v", err)
+	}
+	return err
+}
+
 // UpdateRecipeTitle updates the title of a recipe.
 func (r *RecipeRepository) UpdateRecipeTitle(recipe *models.Recipe, title string) error {
 	err := r.DB.Model(recipe).
@@ -106,20 +657,121 @@ func (r *RecipeRepository) UpdateRecipeTitle(recipe *models.Recipe, title string
 	return err
 }
 
-// UpdateRecipeImageURL updates the image URL of a recipe.
-func (r *RecipeRepository) UpdateRecipeImageURL(recipeID uint, imageURL string) error {
+// UpdateRecipeImageURL updates the image URL of a recipe, with the S3 key of the image, empty if it isn't in S3.
+func (r *RecipeRepository) UpdateRecipeImageURL(recipeID uint, imageURL, imageKey string) error {
 	err := r.DB.Model(&models.Recipe{}).
 		Where("id = ?", recipeID).
-		Update("ImageURL", imageURL).Error
+		Updates(map[string]interface{}{"ImageURL": imageURL, "ImageKey": imageKey}).Error
 	if err != nil {
 		log.Printf("Error updating recipe image URL: %v", err)
 	}
 	return err
 }
 
+// UpdateRecipeImageVariantURLs updates the thumbnail and WebP image URLs of a recipe.
+func (r *RecipeRepository) UpdateRecipeImageVariantURLs(recipeID uint, thumbnailURL, webpURL string) error {
+	err := r.DB.Model(&models.Recipe{}).
+		Where("id = ?", recipeID).
+		Updates(map[string]interface{}{"ThumbnailURL": thumbnailURL, "WebPURL": webpURL}).Error
+	if err != nil {
+		log.Printf("Error updating recipe image variant URLs: %v", err)
+	}
+	return err
+}
+
+// UpdateRecipeImageUploadPending marks whether a recipe is waiting on its image to be uploaded.
+func (r *RecipeRepository) UpdateRecipeImageUploadPending(recipeID uint, pending bool) error {
+	err := r.DB.Model(&models.Recipe{}).
+		Where("id = ?", recipeID).
+		Update("ImageUploadPending", pending).Error
+	if err != nil {
+		log.Printf("Error updating recipe image upload pending: %v", err)
+	}
+	return err
+}
+
+// UpdateRecipeGenerationStatus updates the generation status of a recipe, even after it's been deleted.
+func (r *RecipeRepository) UpdateRecipeGenerationStatus(recipeID uint, status models.GenerationStatus) error {
+	err := r.DB.Unscoped().Model(&models.Recipe{}).
+		Where("id = ?", recipeID).
+		Update("GenerationStatus", status).Error
+	if err != nil {
+		log.Printf("Error updating recipe generation status: %v", err)
+	}
+	return err
+}
+
+// GetRecipeGenerationStatus retrieves the generation status of a recipe, with its creator and deletion time.
+// Deleted recipes are included, so a failed generation can still be reported after its recipe is deleted.
+func (r *RecipeRepository) GetRecipeGenerationStatus(recipeID uint) (*models.Recipe, error) {
+	var recipe models.Recipe
+
+	err := r.DB.Unscoped().
+		Select("id, created_by_id, generation_status, deleted_at").
+		Where("id = ?", recipeID).
+		First(&recipe).Error
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, NotFoundError{message: "Recipe not found"}
+		}
+		log.Printf("Error retrieving recipe generation status: %v", err)
+		return nil, err
+	}
+
+	return &recipe, nil
+}
+
+// FindRecentRecipeByPromptHash retrieves the user's newest recipe generated from the prompt hash since the given time,
+// for the personalization it was generated for. Recipes still generating are included, failed and deleted ones aren't.
+func (r *RecipeRepository) FindRecentRecipeByPromptHash(userID uint, promptHash string, personalizationUID uuid.UUID, since time.Time) (*models.Recipe, error) {
+	var recipe models.Recipe
+
+	err := r.DB.Preload("Hashtags").
+		Preload("CreatedBy", func(db *gorm.DB) *gorm.DB {
+			return db.Select("id, username")
+		}).
+		Preload("LinkedRecipes").
+		Where("created_by_id = ? AND prompt_hash = ? AND personalization_uid = ?", userID, promptHash, personalizationUID).
+		Where("created_at >= ? AND generation_status <> ?", since, models.GenerationFailed).
+		Order("created_at DESC").
+		First(&recipe).Error
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, NotFoundError{message: "No recent recipe from the same prompt"}
+		}
+		log.Printf("Error finding recent recipe by prompt: %v", err)
+		return nil, err
+	}
+
+	return &recipe, nil
+}
+
+// UpdateRecipePinnedHashtags updates the hashtags the owner has pinned to a recipe.
+func (r *RecipeRepository) UpdateRecipePinnedHashtags(recipeID uint, pinnedHashtags []string) error {
+	err := r.DB.Model(&models.Recipe{}).
+		Where("id = ?", recipeID).
+		Update("PinnedHashtags", pq.StringArray(pinnedHashtags)).Error
+	if err != nil {
+		log.Printf("Error updating recipe pinned hashtags: %v", err)
+	}
+	return err
+}
+
+// UpdateRecipePairings updates the drink pairings suggested for a recipe.
+func (r *RecipeRepository) UpdateRecipePairings(recipeID uint, pairings models.Pairings) error {
+	err := r.DB.Model(&models.Recipe{}).
+		Where("id = ?", recipeID).
+		Update("Pairings", pairings).Error
+	if err != nil {
+		log.Printf("Error updating recipe pairings: %v", err)
+	}
+	return err
+}
+
 // UpdateRecipeDef updates the core fields of a recipe and appends the new recipe history entry to the history.
 //
-// Core fields: "Title", "Ingredients", "Instructions", "CookTime", "LinkedSuggestions", "ImagePrompt"
+// Core fields: "Title", "Ingredients", "Instructions", "CookTime", "Servings", "LinkedSuggestions", "Allergens", "Pairings", "ImagePrompt"
+// Generation metadata: "GeneratedWithModel", "PromptVersion", "UserEdited"
 func (r *RecipeRepository) UpdateRecipeDef(recipe *models.Recipe, newRecipeHistoryEntry models.RecipeHistoryEntry) error {
 	// Start a new transaction.
 	tx := r.DB.Begin()
@@ -131,12 +783,20 @@ func (r *RecipeRepository) UpdateRecipeDef(recipe *models.Recipe, newRecipeHisto
 	err := tx.Model(&models.Recipe{}).
 		Where("id = ?", recipe.ID).
 		Updates(map[string]interface{}{
-			"Title":             recipe.Title,
-			"Ingredients":       recipe.Ingredients,
-			"Instructions":      recipe.Instructions,
-			"CookTime":          recipe.CookTime,
-			"LinkedSuggestions": recipe.LinkedSuggestions,
-			"ImagePrompt":       recipe.ImagePrompt,
+			"Title":                 recipe.Title,
+			"Ingredients":           recipe.Ingredients,
+			"Instructions":          recipe.Instructions,
+			"CookTime":              recipe.CookTime,
+			"Servings":              recipe.Servings,
+			"LinkedSuggestions":     recipe.LinkedSuggestions,
+			"Allergens":             recipe.Allergens,
+			"Pairings":              recipe.Pairings,
+			"UsedIngredients":       recipe.UsedIngredients,
+			"AdditionalIngredients": recipe.AdditionalIngredients,
+			"ImagePrompt":           recipe.ImagePrompt,
+			"GeneratedWithModel":    recipe.GeneratedWithModel,
+			"PromptVersion":         recipe.PromptVersion,
+			"UserEdited":            recipe.UserEdited,
 		}).Error
 	if err != nil {
 		tx.Rollback()
@@ -152,6 +812,16 @@ func (r *RecipeRepository) UpdateRecipeDef(recipe *models.Recipe, newRecipeHisto
 		return err
 	}
 
+	// Explanations of the previous content no longer apply. They're deleted for good,
+	// or they'd keep holding the recipe and language's unique index.
+	err = tx.Unscoped().Where("recipe_id = ?", recipe.ID).
+		Delete(&models.RecipeExplanation{}).Error
+	if err != nil {
+		tx.Rollback()
+		log.Printf("Error deleting recipe explanations: %v", err)
+		return err
+	}
+
 	newRecipeHistoryEntry.RecipeHistoryID = recipe.HistoryID
 
 	// Insert the new recipe history entry into the database
@@ -171,6 +841,196 @@ func (r *RecipeRepository) UpdateRecipeDef(recipe *models.Recipe, newRecipeHisto
 	return nil
 }
 
+// ReplaceSubRecipes permanently deletes the sub-recipes a recipe owns, and creates the new ones linked to it.
+func (r *RecipeRepository) ReplaceSubRecipes(parentID uint, subRecipes []*models.Recipe) error {
+	// Start a new transaction
+	tx := r.DB.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	var previous []models.Recipe
+	err := tx.Unscoped().
+		Select("id, history_id").
+		Where("parent_recipe_id = ?", parentID).
+		Find(&previous).Error
+	if err != nil {
+		tx.Rollback()
+		log.Printf("Error retrieving sub-recipes: %v", err)
+		return err
+	}
+
+	if len(previous) > 0 {
+		recipeIDs := make([]uint, len(previous))
+		historyIDs := make([]uint, len(previous))
+		for i, recipe := range previous {
+			recipeIDs[i] = recipe.ID
+			historyIDs[i] = recipe.HistoryID
+		}
+		if err := purgeRecipes(tx, recipeIDs, historyIDs); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	for _, subRecipe := range subRecipes {
+		subRecipe.ParentRecipeID = &parentID
+		if err := tx.Create(subRecipe).Error; err != nil {
+			tx.Rollback()
+			log.Printf("Error creating sub-recipe: %v", err)
+			return err
+		}
+
+		err := tx.Exec("INSERT INTO recipe_linked_recipes (recipe_id, link_recipe_id) VALUES (?, ?)", parentID, subRecipe.ID).Error
+		if err != nil {
+			tx.Rollback()
+			log.Printf("Error linking sub-recipe: %v", err)
+			return err
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		log.Printf("Error committing transaction in ReplaceSubRecipes: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// RecipeTitle is the ID and title of a recipe.
+type RecipeTitle struct {
+	ID    uint
+	Title string
+}
+
+// ListRecipeTitlesContaining retrieves up to limit of the completely generated recipes, other than excludeID, whose title
+// contains every word regardless of case, shortest title first.
+func (r *RecipeRepository) ListRecipeTitlesContaining(words []string, excludeID uint, limit int) ([]RecipeTitle, error) {
+	query := r.DB.Table("recipes").
+		Select("id, title").
+		Where("deleted_at IS NULL AND id <> ? AND generation_status = ?", excludeID, models.GenerationComplete)
+	for _, word := range words {
+		query = query.Where("title ILIKE ?", "%"+escapeLike(word)+"%")
+	}
+
+	var titles []RecipeTitle
+	err := query.Order("LENGTH(title), id").
+		Limit(limit).
+		Scan(&titles).Error
+	if err != nil {
+		log.Printf("Error listing recipe titles: %v", err)
+		return nil, err
+	}
+
+	return titles, nil
+}
+
+// escapeLike escapes the LIKE wildcards in a string, so it's matched literally.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// LinkRecipes links the recipes to a recipe, skipping any already linked, and updates its unresolved link suggestions.
+func (r *RecipeRepository) LinkRecipes(recipeID uint, linkRecipeIDs []uint, linkSuggestions []string) error {
+	// Start a new transaction
+	tx := r.DB.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	for _, linkRecipeID := range linkRecipeIDs {
+		err := tx.Exec(`INSERT INTO recipe_linked_recipes (recipe_id, link_recipe_id)
+			SELECT ?, ? WHERE NOT EXISTS (
+				SELECT 1 FROM recipe_linked_recipes WHERE recipe_id = ? AND link_recipe_id = ?
+			)`, recipeID, linkRecipeID, recipeID, linkRecipeID).Error
+		if err != nil {
+			tx.Rollback()
+			log.Printf("Error linking recipe: %v", err)
+			return err
+		}
+	}
+
+	err := tx.Model(&models.Recipe{}).
+		Where("id = ?", recipeID).
+		UpdateColumn("LinkedSuggestions", pq.StringArray(linkSuggestions)).Error
+	if err != nil {
+		tx.Rollback()
+		log.Printf("Error updating link suggestions: %v", err)
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		log.Printf("Error committing transaction in LinkRecipes: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// CreateTokenUsage records a user's usage of an OpenAI model for a recipe.
+func (r *RecipeRepository) CreateTokenUsage(usage *models.TokenUsage) error {
+	err := r.DB.Create(usage).Error
+	if err != nil {
+		log.Printf("Error creating token usage: %v", err)
+	}
+	return err
+}
+
+// GetRecipeExplanation retrieves the cached explanation of a recipe in a language.
+func (r *RecipeRepository) GetRecipeExplanation(recipeID uint, language string) (*models.RecipeExplanation, error) {
+	var explanation models.RecipeExplanation
+	err := r.DB.Where("recipe_id = ? AND language = ?", recipeID, language).
+		First(&explanation).Error
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, NotFoundError{message: "Recipe explanation not found"}
+		}
+
+		log.Printf("Error retrieving recipe explanation: %v", err)
+		return nil, err
+	}
+
+	return &explanation, nil
+}
+
+// CreateRecipeExplanation caches a new recipe explanation.
+func (r *RecipeRepository) CreateRecipeExplanation(explanation *models.RecipeExplanation) error {
+	err := r.DB.Create(explanation).Error
+	if err != nil {
+		log.Printf("Error creating recipe explanation: %v", err)
+	}
+	return err
+}
+
+// GetRecipeGenerationCache retrieves the unexpired cached recipe generation with the given key.
+func (r *RecipeRepository) GetRecipeGenerationCache(cacheKey string) (*models.RecipeGenerationCache, error) {
+	var cached models.RecipeGenerationCache
+	err := r.DB.Where("cache_key = ? AND expires_at > ?", cacheKey, time.Now()).
+		First(&cached).Error
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, NotFoundError{message: "Recipe generation not cached"}
+		}
+
+		log.Printf("Error retrieving cached recipe generation: %v", err)
+		return nil, err
+	}
+
+	return &cached, nil
+}
+
+// SaveRecipeGenerationCache caches a recipe generation, replacing an expired or forced-over one with the same key.
+func (r *RecipeRepository) SaveRecipeGenerationCache(cached *models.RecipeGenerationCache) error {
+	err := r.DB.Set("gorm:insert_option", "ON CONFLICT (cache_key) DO UPDATE SET "+
+		"recipe_def = EXCLUDED.recipe_def, generated_with_model = EXCLUDED.generated_with_model, "+
+		"prompt_version = EXCLUDED.prompt_version, expires_at = EXCLUDED.expires_at, updated_at = EXCLUDED.updated_at").
+		Create(cached).Error
+	if err != nil {
+		log.Printf("Error caching recipe generation: %v", err)
+	}
+	return err
+}
+
 // FindTagByName finds a tag by its name.
 func (r *RecipeRepository) FindTagByName(tagName string) (*models.Tag, error) {
 	var tag models.Tag
@@ -209,3 +1069,227 @@ func (r *RecipeRepository) UpdateRecipeTagsAssociation(recipeID uint, newTags []
 
 	return nil
 }
+
+// TagNormalizationResult reports the changes made by NormalizeTags.
+type TagNormalizationResult struct {
+	Renamed int `json:"renamed"` // Tags whose hashtag was cleaned in place
+	Merged  int `json:"merged"`  // Duplicate tags merged into another and deleted
+	Removed int `json:"removed"` // Tags deleted because nothing was left of them once cleaned
+}
+
+// NormalizeTags re-cleans every tag's hashtag with the normalize function in one transaction.
+// Tags that collide once cleaned are merged: their recipe associations are repointed to one kept tag and the others deleted.
+// Running it again on normalized tags changes nothing.
+func (r *RecipeRepository) NormalizeTags(normalize func(string) string) (*TagNormalizationResult, error) {
+	tx := r.DB.Begin()
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	// Soft-deleted tags still hold their hashtag in the unique index, so they're normalized too
+	var tags []models.Tag
+	if err := tx.Unscoped().Order("id ASC").Find(&tags).Error; err != nil {
+		tx.Rollback()
+		log.Printf("Error retrieving tags: %v", err)
+		return nil, err
+	}
+
+	// Group the tags by their cleaned hashtag, in order of ID
+	groups := make(map[string][]models.Tag)
+	var cleanedHashtags []string
+	for _, tag := range tags {
+		cleaned := normalize(tag.Hashtag)
+		if _, ok := groups[cleaned]; !ok {
+			cleanedHashtags = append(cleanedHashtags, cleaned)
+		}
+		groups[cleaned] = append(groups[cleaned], tag)
+	}
+
+	result := &TagNormalizationResult{}
+	for _, cleaned := range cleanedHashtags {
+		group := groups[cleaned]
+
+		if cleaned == "" {
+			for _, tag := range group {
+				if err := deleteTag(tx, tag.ID); err != nil {
+					tx.Rollback()
+					return nil, err
+				}
+				result.Removed++
+			}
+			continue
+		}
+
+		// Keep the oldest tag that's already clean, or else the oldest tag
+		kept := group[0]
+		for _, tag := range group {
+			if tag.Hashtag == cleaned {
+				kept = tag
+				break
+			}
+		}
+
+		for _, tag := range group {
+			if tag.ID == kept.ID {
+				continue
+			}
+
+			// Repoint the associations, skipping recipes that already have the kept tag
+			err := tx.Exec(`UPDATE recipe_tags SET tag_id = ? WHERE tag_id = ?
+				AND recipe_id NOT IN (SELECT recipe_id FROM recipe_tags WHERE tag_id = ?)`, kept.ID, tag.ID, kept.ID).Error
+			if err != nil {
+				tx.Rollback()
+				log.Printf("Error repointing tag associations: %v", err)
+				return nil, err
+			}
+
+			if err := deleteTag(tx, tag.ID); err != nil {
+				tx.Rollback()
+				return nil, err
+			}
+			result.Merged++
+		}
+
+		// Rename once the duplicates are gone, so the unique index doesn't collide
+		if kept.Hashtag != cleaned {
+			err := tx.Unscoped().Model(&models.Tag{}).
+				Where("id = ?", kept.ID).
+				UpdateColumn("hashtag", cleaned).Error
+			if err != nil {
+				tx.Rollback()
+				log.Printf("Error renaming tag: %v", err)
+				return nil, err
+			}
+			result.Renamed++
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// deleteTag permanently deletes a tag and its remaining recipe associations within a transaction.
+func deleteTag(tx *gorm.DB, tagID uint) error {
+	if err := tx.Exec("DELETE FROM recipe_tags WHERE tag_id = ?", tagID).Error; err != nil {
+		log.Printf("Error deleting tag associations: %v", err)
+		return err
+	}
+
+	if err := tx.Unscoped().Delete(&models.Tag{}, tagID).Error; err != nil {
+		log.Printf("Error deleting tag: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// CreateRecipeMade creates a new entry of a user making a recipe.
+func (r *RecipeRepository) CreateRecipeMade(made *models.RecipeMade) error {
+	if err := r.DB.Create(made).Error; err != nil {
+		log.Printf("Error creating recipe made entry: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// CountRecipeMadeByUser counts the times a user has made a recipe.
+func (r *RecipeRepository) CountRecipeMadeByUser(recipeID uint, userID uint) (int, error) {
+	var count int
+	err := r.DB.Model(&models.RecipeMade{}).
+		Where("recipe_id = ? AND user_id = ?", recipeID, userID).
+		Count(&count).Error
+	if err != nil {
+		log.Printf("Error counting recipe made entries: %v", err)
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// UpsertRecipeRating saves a user's rating of a recipe, replacing their earlier rating of it, and updates the
+// recipe's average rating and rating count in the same transaction.
+func (r *RecipeRepository) UpsertRecipeRating(rating *models.RecipeRating) error {
+	tx := r.DB.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	now := time.Now()
+	err := tx.Exec(`INSERT INTO recipe_ratings (created_at, updated_at, recipe_id, user_id, score, comment)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (recipe_id, user_id) DO UPDATE
+		SET updated_at = EXCLUDED.updated_at, deleted_at = NULL, score = EXCLUDED.score, comment = EXCLUDED.comment`,
+		now, now, rating.RecipeID, rating.UserID, rating.Score, rating.Comment).Error
+	if err != nil {
+		tx.Rollback()
+		log.Printf("Error saving recipe rating: %v", err)
+		return err
+	}
+
+	if err := tx.Where("recipe_id = ? AND user_id = ?", rating.RecipeID, rating.UserID).First(rating).Error; err != nil {
+		tx.Rollback()
+		log.Printf("Error retrieving saved recipe rating: %v", err)
+		return err
+	}
+
+	var aggregate struct {
+		AverageRating float64
+		RatingCount   int
+	}
+	err = tx.Model(&models.RecipeRating{}).
+		Select("COALESCE(AVG(score), 0) AS average_rating, COUNT(*) AS rating_count").
+		Where("recipe_id = ?", rating.RecipeID).
+		Scan(&aggregate).Error
+	if err != nil {
+		tx.Rollback()
+		log.Printf("Error aggregating recipe ratings: %v", err)
+		return err
+	}
+
+	err = tx.Model(&models.Recipe{}).
+		Where("id = ?", rating.RecipeID).
+		UpdateColumns(map[string]interface{}{"AverageRating": aggregate.AverageRating, "RatingCount": aggregate.RatingCount}).Error
+	if err != nil {
+		tx.Rollback()
+		log.Printf("Error updating recipe average rating: %v", err)
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		log.Printf("Error committing transaction in UpsertRecipeRating: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// ListRecipeRatings retrieves a page of a recipe's ratings with their users, newest first, and how many there are in total.
+func (r *RecipeRepository) ListRecipeRatings(recipeID uint, limit, offset int) ([]models.RecipeRating, int64, error) {
+	query := r.DB.Model(&models.RecipeRating{}).Where("recipe_id = ?", recipeID)
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		log.Printf("Error counting recipe ratings: %v", err)
+		return nil, 0, err
+	}
+
+	var ratings []models.RecipeRating
+	err := query.
+		Preload("User", func(db *gorm.DB) *gorm.DB {
+			return db.Select("id, username")
+		}).
+		Order("updated_at DESC").
+		Limit(limit).
+		Offset(offset).
+		Find(&ratings).Error
+	if err != nil {
+		log.Printf("Error listing recipe ratings: %v", err)
+		return nil, 0, err
+	}
+
+	return ratings, total, nil
+}
@@ -0,0 +1,294 @@
+package repository
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/windoze95/saltybytes-api/internal/models"
+)
+
+// newTagTestDB opens an in-memory database with the tables tags are stored and associated in.
+func newTagTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := db.AutoMigrate(&models.Tag{}).Error; err != nil {
+		t.Fatalf("migrating tags: %v", err)
+	}
+	if err := db.Exec("CREATE TABLE recipe_tags (recipe_id integer, tag_id integer, PRIMARY KEY (recipe_id, tag_id))").Error; err != nil {
+		t.Fatalf("creating recipe_tags: %v", err)
+	}
+	return db
+}
+
+// recipeHashtags returns the hashtags associated with each recipe, sorted.
+func recipeHashtags(t *testing.T, db *gorm.DB) map[uint]string {
+	t.Helper()
+	rows, err := db.Raw("SELECT recipe_tags.recipe_id, tags.hashtag FROM recipe_tags JOIN tags ON tags.id = recipe_tags.tag_id").Rows()
+	if err != nil {
+		t.Fatalf("querying associations: %v", err)
+	}
+	defer rows.Close()
+
+	hashtags := make(map[uint][]string)
+	for rows.Next() {
+		var recipeID uint
+		var hashtag string
+		if err := rows.Scan(&recipeID, &hashtag); err != nil {
+			t.Fatalf("scanning association: %v", err)
+		}
+		hashtags[recipeID] = append(hashtags[recipeID], hashtag)
+	}
+
+	joined := make(map[uint]string, len(hashtags))
+	for recipeID, recipeHashtags := range hashtags {
+		sort.Strings(recipeHashtags)
+		joined[recipeID] = strings.Join(recipeHashtags, ",")
+	}
+	return joined
+}
+
+func TestNormalizeTags(t *testing.T) {
+	db := newTagTestDB(t)
+	r := NewRecipeRepository(db)
+
+	// Tags from before hashtags were cleaned the way they are now
+	for _, hashtag := range []string{"Pasta", "pasta", "Quick Dinner", "#", "soup"} {
+		if err := db.Create(&models.Tag{Hashtag: hashtag}).Error; err != nil {
+			t.Fatalf("creating tag %q: %v", hashtag, err)
+		}
+	}
+	associations := [][2]uint{
+		{1, 1}, {1, 2}, // Recipe 1 has both spellings of pasta
+		{2, 1}, {2, 3}, // Recipe 2 has the dirty pasta and quick dinner
+		{3, 4}, {3, 5}, // Recipe 3 has a tag that's empty once cleaned
+	}
+	for _, association := range associations {
+		if err := db.Exec("INSERT INTO recipe_tags (recipe_id, tag_id) VALUES (?, ?)", association[0], association[1]).Error; err != nil {
+			t.Fatalf("associating tag: %v", err)
+		}
+	}
+
+	clean := func(hashtag string) string {
+		return strings.ToLower(strings.NewReplacer("#", "", " ", "").Replace(hashtag))
+	}
+	result, err := r.NormalizeTags(clean)
+	if err != nil {
+		t.Fatalf("NormalizeTags: %v", err)
+	}
+	if *result != (TagNormalizationResult{Renamed: 1, Merged: 1, Removed: 1}) {
+		t.Fatalf("got %+v, want 1 renamed, 1 merged, and 1 removed", *result)
+	}
+
+	var tags []models.Tag
+	if err := db.Unscoped().Order("hashtag").Find(&tags).Error; err != nil {
+		t.Fatalf("retrieving tags: %v", err)
+	}
+	var hashtags []string
+	for _, tag := range tags {
+		hashtags = append(hashtags, tag.Hashtag)
+	}
+	if got, want := strings.Join(hashtags, ","), "pasta,quickdinner,soup"; got != want {
+		t.Fatalf("tags are %s, want %s", got, want)
+	}
+
+	// The merged tag's recipes are repointed to the kept one, without associating a recipe with it twice
+	want := map[uint]string{1: "pasta", 2: "pasta,quickdinner", 3: "soup"}
+	got := recipeHashtags(t, db)
+	for recipeID, wantHashtags := range want {
+		if got[recipeID] != wantHashtags {
+			t.Fatalf("recipe %d hashtags are %q, want %q", recipeID, got[recipeID], wantHashtags)
+		}
+	}
+
+	// Normalizing again changes nothing
+	result, err = r.NormalizeTags(clean)
+	if err != nil {
+		t.Fatalf("NormalizeTags again: %v", err)
+	}
+	if *result != (TagNormalizationResult{}) {
+		t.Fatalf("second run got %+v, want no changes", *result)
+	}
+}
+
+// newRecipeTestDB opens an in-memory database with the tables recipes, and everything that belongs to them, are stored in.
+func newRecipeTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	err = db.AutoMigrate(
+		&models.User{},
+		&models.Recipe{},
+		&models.Tag{},
+		&models.RecipeHistory{},
+		&models.RecipeHistoryEntry{},
+		&models.RecipeMade{},
+		&models.RecipeRating{},
+		&models.RecipeExplanation{},
+	).Error
+	if err != nil {
+		t.Fatalf("migrating recipes: %v", err)
+	}
+	return db
+}
+
+// subRecipe returns a sub-recipe with a history, as the service saves them.
+func subRecipe(title string, ingredients ...string) *models.Recipe {
+	recipeDef := models.RecipeDef{Title: title}
+	for _, name := range ingredients {
+		recipeDef.Ingredients = append(recipeDef.Ingredients, models.Ingredient{Name: name, Unit: "g", Amount: 100})
+	}
+	entryDef := recipeDef
+	return &models.Recipe{
+		RecipeDef:   recipeDef,
+		CreatedByID: 1,
+		History: &models.RecipeHistory{Entries: []models.RecipeHistoryEntry{{
+			UserPrompt:     "Part of Burger",
+			RecipeResponse: &entryDef,
+		}}},
+	}
+}
+
+// linkedTitles returns the titles of the recipe's linked recipes, sorted.
+func linkedTitles(recipe *models.Recipe) string {
+	var titles []string
+	for _, linked := range recipe.LinkedRecipes {
+		titles = append(titles, linked.Title)
+	}
+	sort.Strings(titles)
+	return strings.Join(titles, ",")
+}
+
+func TestReplaceSubRecipesRoundTrip(t *testing.T) {
+	db := newRecipeTestDB(t)
+	r := NewRecipeRepository(db)
+
+	burger := &models.Recipe{
+		RecipeDef:   models.RecipeDef{Title: "Burger"},
+		CreatedByID: 1,
+		History:     &models.RecipeHistory{},
+	}
+	if err := db.Create(burger).Error; err != nil {
+		t.Fatalf("creating recipe: %v", err)
+	}
+
+	if err := r.ReplaceSubRecipes(burger.ID, []*models.Recipe{subRecipe("Buns", "Flour", "Yeast"), subRecipe("Sauce", "Mayonnaise")}); err != nil {
+		t.Fatalf("ReplaceSubRecipes: %v", err)
+	}
+	recipe, err := r.GetRecipeWithLinkedRecipes(burger.ID)
+	if err != nil {
+		t.Fatalf("GetRecipeWithLinkedRecipes: %v", err)
+	}
+	if got := linkedTitles(recipe); got != "Buns,Sauce" {
+		t.Fatalf("linked recipes are %s, want Buns,Sauce", got)
+	}
+	for _, linked := range recipe.LinkedRecipes {
+		if linked.ParentRecipeID == nil || *linked.ParentRecipeID != burger.ID || linked.HistoryID == 0 {
+			t.Fatalf("sub-recipe %s has parent %v and history %d, want it owned by the burger with a history", linked.Title, linked.ParentRecipeID, linked.HistoryID)
+		}
+		// Each component keeps its own ingredients
+		if linked.Title == "Buns" && (len(linked.Ingredients) != 2 || linked.Ingredients[0].Name != "Flour" || linked.Ingredients[0].Amount != 100) {
+			t.Fatalf("buns ingredients are %+v, want flour and yeast", linked.Ingredients)
+		}
+	}
+
+	// Replacing them again purges the previous sub-recipes, with their histories
+	if err := r.ReplaceSubRecipes(burger.ID, []*models.Recipe{subRecipe("Pickles", "Cucumber")}); err != nil {
+		t.Fatalf("ReplaceSubRecipes again: %v", err)
+	}
+	recipe, err = r.GetRecipeWithLinkedRecipes(burger.ID)
+	if err != nil {
+		t.Fatalf("GetRecipeWithLinkedRecipes: %v", err)
+	}
+	if got := linkedTitles(recipe); got != "Pickles" {
+		t.Fatalf("linked recipes are %s, want Pickles", got)
+	}
+	var subRecipes, entries int
+	db.Unscoped().Model(&models.Recipe{}).Where("parent_recipe_id = ?", burger.ID).Count(&subRecipes)
+	db.Unscoped().Model(&models.RecipeHistoryEntry{}).Count(&entries)
+	if subRecipes != 1 || entries != 1 {
+		t.Fatalf("got %d sub-recipes and %d history entries stored, want 1 of each", subRecipes, entries)
+	}
+
+	// Deleting the recipe deletes the sub-recipes it owns
+	if err := r.DeleteRecipe(burger.ID); err != nil {
+		t.Fatalf("DeleteRecipe: %v", err)
+	}
+	db.Model(&models.Recipe{}).Where("parent_recipe_id = ?", burger.ID).Count(&subRecipes)
+	if subRecipes != 0 {
+		t.Fatalf("%d sub-recipes weren't deleted with the recipe", subRecipes)
+	}
+}
+
+// addRecipeDefColumns adds the columns UpdateRecipeDef updates by field name, which AutoMigrate doesn't create
+// for the recipe def embedded in the recipe.
+func addRecipeDefColumns(t *testing.T, db *gorm.DB) {
+	t.Helper()
+	for _, column := range []string{"Ingredients", "Instructions", "CookTime", "Servings", "LinkedSuggestions", "Allergens", "Pairings", "UsedIngredients", "AdditionalIngredients", "ImagePrompt"} {
+		if err := db.Exec("ALTER TABLE recipes ADD COLUMN " + column).Error; err != nil {
+			t.Fatalf("adding column %s: %v", column, err)
+		}
+	}
+}
+
+func TestUpdateRecipeDefClearsExplanations(t *testing.T) {
+	db := newRecipeTestDB(t)
+	addRecipeDefColumns(t, db)
+	r := NewRecipeRepository(db)
+
+	recipe := subRecipe("Risotto", "Rice")
+	if err := db.Create(recipe).Error; err != nil {
+		t.Fatalf("creating recipe: %v", err)
+	}
+
+	// explain returns the cached explanation, caching a new one with the summary when there isn't one,
+	// and reports whether it had to be generated.
+	explain := func(summary string) (*models.RecipeExplanation, bool) {
+		t.Helper()
+		explanation, err := r.GetRecipeExplanation(recipe.ID, "English")
+		if err == nil {
+			return explanation, false
+		}
+		if _, ok := err.(NotFoundError); !ok {
+			t.Fatalf("GetRecipeExplanation: %v", err)
+		}
+		explanation = &models.RecipeExplanation{RecipeID: recipe.ID, Language: "English", Summary: summary}
+		if err := r.CreateRecipeExplanation(explanation); err != nil {
+			t.Fatalf("CreateRecipeExplanation: %v", err)
+		}
+		return explanation, true
+	}
+
+	if _, generated := explain("Stir the rice"); !generated {
+		t.Fatal("first explanation came from the cache")
+	}
+
+	recipe.Title = "Mushroom Risotto"
+	entryDef := recipe.RecipeDef
+	if err := r.UpdateRecipeDef(recipe, models.RecipeHistoryEntry{UserPrompt: "Add mushrooms", RecipeResponse: &entryDef}); err != nil {
+		t.Fatalf("UpdateRecipeDef: %v", err)
+	}
+
+	// The edit clears the explanation, and the new one is cached in its place
+	if _, generated := explain("Brown the mushrooms"); !generated {
+		t.Fatal("explanation of the edited recipe came from the stale cache")
+	}
+	explanation, generated := explain("Brown the mushrooms again")
+	if generated {
+		t.Fatal("explanation of the edited recipe wasn't cached")
+	}
+	if explanation.Summary != "Brown the mushrooms" {
+		t.Fatalf("cached summary is %q, want %q", explanation.Summary, "Brown the mushrooms")
+	}
+}
@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"log"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/windoze95/saltybytes-api/internal/models"
+	"github.com/windoze95/saltybytes-api/internal/util"
+)
+
+// Make sure SessionTokenBlocklist is a TokenBlocklist.
+var _ util.TokenBlocklist = (*SessionTokenBlocklist)(nil)
+
+// SessionTokenBlocklist is a token blocklist kept in the database, as the revoked login sessions.
+// Unlike one kept in memory, it's shared by every instance of the API and survives restarts.
+type SessionTokenBlocklist struct {
+	DB *gorm.DB
+}
+
+// NewSessionTokenBlocklist creates a new SessionTokenBlocklist.
+func NewSessionTokenBlocklist(db *gorm.DB) *SessionTokenBlocklist {
+	return &SessionTokenBlocklist{DB: db}
+}
+
+// Block revokes the session of a token. The session's own expiry outlasts the revocation,
+// so the expiry given is ignored.
+func (b *SessionTokenBlocklist) Block(tokenID string, expiresAt time.Time) error {
+	err := b.DB.Model(&models.UserSession{}).
+		Where("token_id = ? AND revoked_at IS NULL", tokenID).
+		UpdateColumn("revoked_at", time.Now()).Error
+	if err != nil {
+		log.Printf("Error blocking token: %v", err)
+	}
+	return err
+}
+
+// IsBlocked checks if the session of a token has been revoked.
+// Tokens without a session are not blocked.
+func (b *SessionTokenBlocklist) IsBlocked(tokenID string) (bool, error) {
+	var count int
+	err := b.DB.Model(&models.UserSession{}).
+		Where("token_id = ? AND revoked_at IS NOT NULL", tokenID).
+		Count(&count).Error
+	if err != nil {
+		log.Printf("Error checking token revocation: %v", err)
+		return false, err
+	}
+	return count > 0, nil
+}
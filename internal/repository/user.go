@@ -1,10 +1,11 @@
 package repository
 
 import (
-	"errors"
 	"log"
 	"strings"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/jinzhu/gorm"
 	"github.com/lib/pq"
 	"github.com/windoze95/saltybytes-api/internal/models"
@@ -20,28 +21,84 @@ func NewUserRepository(db *gorm.DB) *UserRepository {
 	return &UserRepository{DB: db}
 }
 
-// CreateUser creates a new user.
+// CreateUser creates a new user, with the auth, subscription, settings, and personalization set on it, in one transaction.
+// A username or email that's already taken is a DuplicateError.
 func (r *UserRepository) CreateUser(user *models.User) (*models.User, error) {
 	tx := r.DB.Begin()
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
 	if err := tx.Create(user).Error; err != nil {
 		tx.Rollback()
+		if e, ok := duplicateUserError(err); ok {
+			return nil, e
+		}
+		log.Printf("Error creating user: %v", err)
 		return nil, err
 	}
 	if err := tx.Commit().Error; err != nil {
-		// Check for unique constraints
-		if pgErr, ok := err.(*pq.Error); ok && pgErr.Code == "23505" {
-			if strings.Contains(pgErr.Error(), "username") {
-				return nil, errors.New("username already in use")
-			} else if strings.Contains(pgErr.Error(), "email") {
-				return nil, errors.New("email already in use")
-			}
+		if e, ok := duplicateUserError(err); ok {
+			return nil, e
 		}
+		log.Printf("Error committing transaction in CreateUser: %v", err)
 		return nil, err
 	}
 
 	return user, nil
 }
 
+// duplicateUserError converts a unique constraint violation on a user's username or email to a DuplicateError.
+func duplicateUserError(err error) (DuplicateError, bool) {
+	pgErr, ok := err.(*pq.Error)
+	if !ok || pgErr.Code != "23505" {
+		return DuplicateError{}, false
+	}
+	if strings.Contains(pgErr.Error(), "email") {
+		return DuplicateError{message: "email already in use"}, true
+	}
+	return DuplicateError{message: "username already in use"}, true
+}
+
+// ImportUsers creates the given users in one transaction.
+// A user that collides with an existing username or email is skipped, and the
+// returned slice holds that conflict at the user's index; it's nil for users created.
+func (r *UserRepository) ImportUsers(users []*models.User) ([]error, error) {
+	conflicts := make([]error, len(users))
+
+	tx := r.DB.Begin()
+	for i, user := range users {
+		// A savepoint per user keeps a failed insert from aborting the whole transaction
+		if err := tx.Exec("SAVEPOINT import_user").Error; err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		if err := tx.Create(user).Error; err != nil {
+			duplicate, ok := duplicateUserError(err)
+			if !ok {
+				log.Printf("Error importing user %s: %v", user.Username, err)
+				tx.Rollback()
+				return nil, err
+			}
+			if err := tx.Exec("ROLLBACK TO SAVEPOINT import_user").Error; err != nil {
+				tx.Rollback()
+				return nil, err
+			}
+			conflicts[i] = duplicate
+			continue
+		}
+		if err := tx.Exec("RELEASE SAVEPOINT import_user").Error; err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	return conflicts, nil
+}
+
 // GetUserByID retrieves a user by their ID.
 func (r *UserRepository) GetUserByID(userID uint) (*models.User, error) {
 	var user models.User
@@ -68,6 +125,107 @@ func (r *UserRepository) GetUserAuthByUsername(username string) (*models.User, e
 	return &user, nil
 }
 
+// GetUserByFacebookID retrieves a user with their authentication information by their Facebook user ID.
+func (r *UserRepository) GetUserByFacebookID(facebookID string) (*models.User, error) {
+	var user models.User
+	if err := r.DB.Preload("Auth").
+		Select("users.*").
+		Joins("JOIN user_auths ON user_auths.user_id = users.id AND user_auths.deleted_at IS NULL").
+		Where("user_auths.facebook_id = ?", facebookID).
+		First(&user).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, NotFoundError{message: "User not found"}
+		}
+		log.Printf("Error retrieving user by Facebook ID: %v", err)
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// GetUserByEmail retrieves a user by their email address, ignoring case.
+func (r *UserRepository) GetUserByEmail(email string) (*models.User, error) {
+	var user models.User
+	if err := r.DB.Where("LOWER(email) = LOWER(?)", email).
+		First(&user).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, NotFoundError{message: "User not found"}
+		}
+		log.Printf("Error retrieving user by email: %v", err)
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// CreatePasswordResetToken stores a password reset token.
+func (r *UserRepository) CreatePasswordResetToken(token *models.PasswordResetToken) error {
+	err := r.DB.Create(token).Error
+	if err != nil {
+		log.Printf("Error creating password reset token: %v", err)
+	}
+	return err
+}
+
+// CountOutstandingPasswordResetTokens counts a user's password reset tokens that are unused and unexpired.
+func (r *UserRepository) CountOutstandingPasswordResetTokens(userID uint, now time.Time) (int, error) {
+	var count int
+	err := r.DB.Model(&models.PasswordResetToken{}).
+		Where("user_id = ? AND used_at IS NULL AND expires_at > ?", userID, now).
+		Count(&count).Error
+	if err != nil {
+		log.Printf("Error counting password reset tokens: %v", err)
+	}
+	return count, err
+}
+
+// ResetPassword sets a user's password with an unused, unexpired reset token, in one transaction.
+// The token and every other outstanding token of the user are used up. It returns the user's ID.
+func (r *UserRepository) ResetPassword(tokenHash string, hashedPassword string) (uint, error) {
+	tx := r.DB.Begin()
+	if tx.Error != nil {
+		return 0, tx.Error
+	}
+
+	now := time.Now()
+	var token models.PasswordResetToken
+	err := tx.Set("gorm:query_option", "FOR UPDATE").
+		Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", tokenHash, now).
+		First(&token).Error
+	if err != nil {
+		tx.Rollback()
+		if gorm.IsRecordNotFoundError(err) {
+			return 0, NotFoundError{message: "Password reset token is invalid or expired"}
+		}
+		log.Printf("Error retrieving password reset token: %v", err)
+		return 0, err
+	}
+
+	err = tx.Model(&models.UserAuth{}).
+		Where("user_id = ?", token.UserID).
+		UpdateColumn("hashed_password", hashedPassword).Error
+	if err != nil {
+		tx.Rollback()
+		log.Printf("Error updating password: %v", err)
+		return 0, err
+	}
+
+	err = tx.Model(&models.PasswordResetToken{}).
+		Where("user_id = ? AND used_at IS NULL", token.UserID).
+		UpdateColumn("used_at", now).Error
+	if err != nil {
+		tx.Rollback()
+		log.Printf("Error using up password reset tokens: %v", err)
+		return 0, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return 0, err
+	}
+
+	return token.UserID, nil
+}
+
 // UpdateUserEmail updates a user's email address.
 func (r *UserRepository) UpdateUserEmail(userID uint, email string) error {
 	err := r.DB.Model(&models.User{}).
@@ -80,6 +238,46 @@ func (r *UserRepository) UpdateUserEmail(userID uint, email string) error {
 	return err
 }
 
+// SetUserFeatureFlag enables or disables a feature flag for a user.
+func (r *UserRepository) SetUserFeatureFlag(userID uint, flag string, enabled bool) error {
+	var expr *gorm.SqlExpr
+	if enabled {
+		// Remove first so the flag is never listed twice
+		expr = gorm.Expr("array_append(array_remove(feature_flags, ?), ?)", flag, flag)
+	} else {
+		expr = gorm.Expr("array_remove(feature_flags, ?)", flag)
+	}
+
+	result := r.DB.Model(&models.User{}).
+		Where("id = ?", userID).
+		UpdateColumn("feature_flags", expr)
+	if result.Error != nil {
+		log.Printf("Error updating user feature flags: %v", result.Error)
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return NotFoundError{message: "User not found"}
+	}
+
+	return nil
+}
+
+// SetUserRole changes a user's role.
+func (r *UserRepository) SetUserRole(userID uint, role models.Role) error {
+	result := r.DB.Model(&models.User{}).
+		Where("id = ?", userID).
+		UpdateColumn("role", role)
+	if result.Error != nil {
+		log.Printf("Error updating user role: %v", result.Error)
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return NotFoundError{message: "User not found"}
+	}
+
+	return nil
+}
+
 // UpdateUserSettingsKeepScreenAwake updates a user's KeepScreenAwake setting.
 func (r *UserRepository) UpdateUserSettingsKeepScreenAwake(userID uint, keepScreenAwake bool) error {
 	err := r.DB.Model(&models.UserSettings{}).
@@ -92,6 +290,179 @@ func (r *UserRepository) UpdateUserSettingsKeepScreenAwake(userID uint, keepScre
 	return err
 }
 
+// UpdateSettingsAndPersonalization applies changes to a user's settings and personalization in one transaction.
+// Either record is created with its defaults first if it doesn't exist yet.
+// If applyChanges returns an error, nothing is saved.
+func (r *UserRepository) UpdateSettingsAndPersonalization(userID uint, applyChanges func(*models.UserSettings, *models.Personalization) error) error {
+	tx := r.DB.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	var settings models.UserSettings
+	if err := tx.Where(models.UserSettings{UserID: userID}).
+		Attrs(models.UserSettings{KeepScreenAwake: true}).
+		FirstOrCreate(&settings).Error; err != nil {
+		tx.Rollback()
+		log.Printf("Error retrieving user settings: %v", err)
+		return err
+	}
+
+	var personalization models.Personalization
+	if err := tx.Where(models.Personalization{UserID: userID}).
+		Attrs(models.Personalization{UID: uuid.New()}).
+		FirstOrCreate(&personalization).Error; err != nil {
+		tx.Rollback()
+		log.Printf("Error retrieving personalization: %v", err)
+		return err
+	}
+
+	if err := applyChanges(&settings, &personalization); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Save(&settings).Error; err != nil {
+		tx.Rollback()
+		log.Printf("Error saving updated user settings: %v", err)
+		return err
+	}
+
+	if err := tx.Save(&personalization).Error; err != nil {
+		tx.Rollback()
+		log.Printf("Error saving updated personalization: %v", err)
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+// OpenAIKeyReencryptionResult is the outcome of ReencryptOpenAIKeys.
+type OpenAIKeyReencryptionResult struct {
+	Reencrypted int    `json:"reencrypted"` // Personal keys re-encrypted with the current encryption key
+	Current     int    `json:"current"`     // Personal keys that already were
+	Failed      []uint `json:"failed"`      // IDs of the users whose personal key couldn't be re-encrypted
+}
+
+// ReencryptOpenAIKeys replaces the encrypted personal OpenAI key of every user with the result of reencrypt, in one
+// transaction. reencrypt returns false for a key that doesn't need it, and a key it fails on is left as it is.
+func (r *UserRepository) ReencryptOpenAIKeys(reencrypt func(ciphertext string) (string, bool, error)) (*OpenAIKeyReencryptionResult, error) {
+	tx := r.DB.Begin()
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	var settings []models.UserSettings
+	err := tx.Select("id, user_id, encrypted_openai_key").
+		Where("encrypted_openai_key <> ''").
+		Order("id").
+		Find(&settings).Error
+	if err != nil {
+		tx.Rollback()
+		log.Printf("Error retrieving encrypted OpenAI keys: %v", err)
+		return nil, err
+	}
+
+	result := &OpenAIKeyReencryptionResult{Failed: []uint{}}
+	for _, s := range settings {
+		ciphertext, changed, err := reencrypt(s.EncryptedOpenAIKey)
+		if err != nil {
+			log.Printf("Error re-encrypting the OpenAI key of user %d: %v", s.UserID, err)
+			result.Failed = append(result.Failed, s.UserID)
+			continue
+		}
+		if !changed {
+			result.Current++
+			continue
+		}
+
+		err = tx.Model(&models.UserSettings{}).
+			Where("id = ?", s.ID).
+			UpdateColumn("encrypted_openai_key", ciphertext).Error
+		if err != nil {
+			tx.Rollback()
+			log.Printf("Error saving re-encrypted OpenAI key: %v", err)
+			return nil, err
+		}
+		result.Reencrypted++
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		log.Printf("Error committing transaction in ReencryptOpenAIKeys: %v", err)
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// PersonalizationBackfillResult is the outcome of BackfillPersonalizations.
+type PersonalizationBackfillResult struct {
+	Created      int `json:"created"`       // Personalizations created for users who had none
+	UIDsAssigned int `json:"uids_assigned"` // Existing personalizations given the UID they were missing
+}
+
+// BackfillPersonalizations creates the default personalization for every user who has none, and assigns a UID to
+// every personalization created without one, in one transaction. Running it again changes nothing.
+func (r *UserRepository) BackfillPersonalizations() (*PersonalizationBackfillResult, error) {
+	tx := r.DB.Begin()
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	// Users with a deleted personalization are skipped too, since its user ID is still taken
+	var userIDs []uint
+	err := tx.Table("users").
+		Joins("LEFT JOIN personalizations ON personalizations.user_id = users.id").
+		Where("users.deleted_at IS NULL AND personalizations.id IS NULL").
+		Pluck("users.id", &userIDs).Error
+	if err != nil {
+		tx.Rollback()
+		log.Printf("Error finding users without a personalization: %v", err)
+		return nil, err
+	}
+
+	result := &PersonalizationBackfillResult{}
+	for _, userID := range userIDs {
+		// The BeforeCreate hook fills in the UID and the other defaults
+		personalization := models.Personalization{UserID: userID, UnitSystem: models.USCustomary}
+		if err := tx.Create(&personalization).Error; err != nil {
+			tx.Rollback()
+			log.Printf("Error creating personalization: %v", err)
+			return nil, err
+		}
+		result.Created++
+	}
+
+	var personalizationIDs []uint
+	err = tx.Model(&models.Personalization{}).
+		Where("uid IS NULL OR uid = ?", uuid.Nil).
+		Pluck("id", &personalizationIDs).Error
+	if err != nil {
+		tx.Rollback()
+		log.Printf("Error finding personalizations without a UID: %v", err)
+		return nil, err
+	}
+
+	for _, personalizationID := range personalizationIDs {
+		err := tx.Model(&models.Personalization{}).
+			Where("id = ?", personalizationID).
+			UpdateColumn("uid", uuid.New()).Error
+		if err != nil {
+			tx.Rollback()
+			log.Printf("Error assigning personalization UID: %v", err)
+			return nil, err
+		}
+		result.UIDsAssigned++
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		log.Printf("Error committing transaction in BackfillPersonalizations: %v", err)
+		return nil, err
+	}
+
+	return result, nil
+}
+
 // UpdatePersonalization updates a user's personalization settings.
 func (r *UserRepository) UpdatePersonalization(userID uint, updatedPersonalization *models.Personalization) error {
 	var existingPersonalization models.Personalization
@@ -118,6 +489,267 @@ func (r *UserRepository) UpdatePersonalization(userID uint, updatedPersonalizati
 	return err
 }
 
+// IncrementDailyGenerations atomically counts a generation against a user's daily cap for the given day.
+// It returns false without counting if the cap has already been reached that day.
+func (r *UserRepository) IncrementDailyGenerations(userID uint, day time.Time, dailyCap int) (bool, error) {
+	// UpdateColumns skips the Subscription hooks, the tier isn't loaded here
+	result := r.DB.Model(&models.Subscription{}).
+		Where("user_id = ? AND (generations_day IS NULL OR generations_day <> ? OR generations_today < ?)", userID, day, dailyCap).
+		UpdateColumns(map[string]interface{}{
+			"generations_today": gorm.Expr("CASE WHEN generations_day = ? THEN generations_today + 1 ELSE 1 END", day),
+			"generations_day":   day,
+		})
+	if result.Error != nil {
+		log.Printf("Error incrementing daily generations: %v", result.Error)
+		return false, result.Error
+	}
+
+	return result.RowsAffected > 0, nil
+}
+
+// RestoreDailyGeneration gives back a generation counted against a user's daily cap for the given day, that failed.
+// Nothing is given back once the count has moved on to another day.
+func (r *UserRepository) RestoreDailyGeneration(userID uint, day time.Time) error {
+	err := r.DB.Model(&models.Subscription{}).
+		Where("user_id = ? AND generations_day = ? AND generations_today > 0", userID, day).
+		UpdateColumn("generations_today", gorm.Expr("generations_today - 1")).Error
+	if err != nil {
+		log.Printf("Error restoring daily generation: %v", err)
+	}
+	return err
+}
+
+// DecrementRemainingUses atomically spends one of a user's remaining uses.
+// It returns false without spending if there are none left.
+func (r *UserRepository) DecrementRemainingUses(userID uint) (bool, error) {
+	// UpdateColumn skips the Subscription hooks, the tier isn't loaded here
+	result := r.DB.Model(&models.Subscription{}).
+		Where("user_id = ? AND remaining_uses > 0", userID).
+		UpdateColumn("remaining_uses", gorm.Expr("remaining_uses - 1"))
+	if result.Error != nil {
+		log.Printf("Error decrementing remaining uses: %v", result.Error)
+		return false, result.Error
+	}
+
+	return result.RowsAffected > 0, nil
+}
+
+// RestoreRemainingUse gives back a remaining use that was spent on a generation that failed.
+func (r *UserRepository) RestoreRemainingUse(userID uint) error {
+	err := r.DB.Model(&models.Subscription{}).
+		Where("user_id = ?", userID).
+		UpdateColumn("remaining_uses", gorm.Expr("remaining_uses + 1")).Error
+	if err != nil {
+		log.Printf("Error restoring remaining use: %v", err)
+	}
+	return err
+}
+
+// RefillRemainingUses starts the next billing cycle of a user's subscription, ending at nextExpiresAt, with the
+// remaining uses refilled. The cycle is only started if the one ending at expiresAt is still the current one, so a
+// cycle is never refilled twice. It returns false if another cycle has been started since.
+func (r *UserRepository) RefillRemainingUses(userID uint, expiresAt, nextExpiresAt time.Time, remainingUses int) (bool, error) {
+	// UpdateColumns skips the Subscription hooks, the tier isn't loaded here
+	result := r.DB.Model(&models.Subscription{}).
+		Where("user_id = ? AND expires_at = ?", userID, expiresAt).
+		UpdateColumns(map[string]interface{}{
+			"expires_at":     nextExpiresAt,
+			"remaining_uses": remainingUses,
+		})
+	if result.Error != nil {
+		log.Printf("Error refilling remaining uses: %v", result.Error)
+		return false, result.Error
+	}
+
+	return result.RowsAffected > 0, nil
+}
+
+// ListExpiredSubscriptions lists up to limit subscriptions whose billing cycle ended before the time,
+// the longest expired first.
+func (r *UserRepository) ListExpiredSubscriptions(before time.Time, limit int) ([]models.Subscription, error) {
+	var subscriptions []models.Subscription
+	err := r.DB.Where("expires_at <= ?", before).
+		Order("expires_at").
+		Limit(limit).
+		Find(&subscriptions).Error
+	if err != nil {
+		log.Printf("Error listing expired subscriptions: %v", err)
+		return nil, err
+	}
+	return subscriptions, nil
+}
+
+// StartStripeSubscription stores the Stripe customer ID of a user who checked out a subscription, and updates their
+// subscription to it, in one transaction with recording the Stripe event it's from. A customer ID that belongs to
+// another user is a DuplicateError, and an event that was already applied is an AlreadyProcessedError.
+func (r *UserRepository) StartStripeSubscription(eventID string, userID uint, customerID string, tier models.SubscriptionTier, expiresAt time.Time, remainingUses int) error {
+	tx := r.DB.Begin()
+	if err := recordStripeEvent(tx, eventID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	result := tx.Model(&models.User{}).
+		Where("id = ?", userID).
+		UpdateColumn("stripe_customer_id", customerID)
+	if result.Error != nil {
+		tx.Rollback()
+		if pgErr, ok := result.Error.(*pq.Error); ok && pgErr.Code == "23505" {
+			return DuplicateError{message: "Stripe customer already belongs to another user"}
+		}
+		log.Printf("Error storing Stripe customer ID: %v", result.Error)
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		tx.Rollback()
+		return NotFoundError{message: "User not found"}
+	}
+
+	if err := updateSubscription(tx, userID, tier, expiresAt, remainingUses); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+// EndStripeSubscription updates the subscription of the user with the Stripe customer ID once it has ended,
+// and returns the user's ID. It's done in one transaction with recording the Stripe event it's from, and an event
+// that was already applied is an AlreadyProcessedError.
+func (r *UserRepository) EndStripeSubscription(eventID string, customerID string, tier models.SubscriptionTier, expiresAt time.Time, remainingUses int) (uint, error) {
+	tx := r.DB.Begin()
+	if err := recordStripeEvent(tx, eventID); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	var user models.User
+	if err := tx.Select("id").
+		Where("stripe_customer_id = ?", customerID).
+		First(&user).Error; err != nil {
+		tx.Rollback()
+		if gorm.IsRecordNotFoundError(err) {
+			return 0, NotFoundError{message: "User not found"}
+		}
+		log.Printf("Error retrieving user by Stripe customer ID: %v", err)
+		return 0, err
+	}
+
+	if err := updateSubscription(tx, user.ID, tier, expiresAt, remainingUses); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return 0, err
+	}
+	return user.ID, nil
+}
+
+// recordStripeEvent records that the Stripe event is being applied, in the transaction that applies it.
+// An event that was already recorded, including by a delivery applied concurrently, is an AlreadyProcessedError.
+func recordStripeEvent(tx *gorm.DB, eventID string) error {
+	var count int
+	if err := tx.Model(&models.StripeEvent{}).Where("event_id = ?", eventID).Count(&count).Error; err != nil {
+		log.Printf("Error checking Stripe event: %v", err)
+		return err
+	}
+	if count > 0 {
+		return AlreadyProcessedError{message: "Stripe event was already applied"}
+	}
+
+	if err := tx.Create(&models.StripeEvent{EventID: eventID}).Error; err != nil {
+		if pgErr, ok := err.(*pq.Error); ok && pgErr.Code == "23505" {
+			return AlreadyProcessedError{message: "Stripe event was already applied"}
+		}
+		log.Printf("Error recording Stripe event: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// updateSubscription updates the tier, expiry, and remaining uses of a user's subscription.
+func updateSubscription(db *gorm.DB, userID uint, tier models.SubscriptionTier, expiresAt time.Time, remainingUses int) error {
+	// UpdateColumns skips the Subscription hooks, the tier is validated by the caller
+	err := db.Model(&models.Subscription{}).
+		Where("user_id = ?", userID).
+		UpdateColumns(map[string]interface{}{
+			"subscription_tier": tier,
+			"expires_at":        expiresAt,
+			"remaining_uses":    remainingUses,
+		}).Error
+	if err != nil {
+		log.Printf("Error updating subscription: %v", err)
+	}
+	return err
+}
+
+// RecordLogin creates a session for a login and updates the user's last login time, in one transaction.
+func (r *UserRepository) RecordLogin(session *models.UserSession) error {
+	tx := r.DB.Begin()
+	if err := tx.Create(session).Error; err != nil {
+		tx.Rollback()
+		log.Printf("Error creating user session: %v", err)
+		return err
+	}
+	err := tx.Model(&models.User{}).
+		Where("id = ?", session.UserID).
+		UpdateColumn("last_login_at", session.IssuedAt).Error
+	if err != nil {
+		tx.Rollback()
+		log.Printf("Error updating last login time: %v", err)
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// ListActiveUserSessions lists a user's sessions that haven't been revoked or expired, newest first.
+func (r *UserRepository) ListActiveUserSessions(userID uint) ([]models.UserSession, error) {
+	var sessions []models.UserSession
+	err := r.DB.Where("user_id = ? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)", userID, time.Now()).
+		Order("issued_at DESC").
+		Find(&sessions).Error
+	if err != nil {
+		log.Printf("Error listing user sessions: %v", err)
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// RevokeUserSession revokes one of a user's sessions and returns it.
+func (r *UserRepository) RevokeUserSession(userID uint, sessionID uint) (*models.UserSession, error) {
+	return r.revokeUserSession(r.DB.Where("id = ? AND user_id = ?", sessionID, userID))
+}
+
+// RevokeUserSessionByToken revokes the user's session of a token and returns it.
+func (r *UserRepository) RevokeUserSessionByToken(userID uint, tokenID string) (*models.UserSession, error) {
+	return r.revokeUserSession(r.DB.Where("token_id = ? AND user_id = ?", tokenID, userID))
+}
+
+// revokeUserSession revokes the active session found by the query.
+func (r *UserRepository) revokeUserSession(query *gorm.DB) (*models.UserSession, error) {
+	var session models.UserSession
+	if err := query.Where("revoked_at IS NULL").First(&session).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, NotFoundError{message: "Session not found"}
+		}
+		log.Printf("Error retrieving user session: %v", err)
+		return nil, err
+	}
+
+	now := time.Now()
+	err := r.DB.Model(&session).
+		UpdateColumn("revoked_at", now).Error
+	if err != nil {
+		log.Printf("Error revoking user session: %v", err)
+		return nil, err
+	}
+	session.RevokedAt = &now
+
+	return &session, nil
+}
+
 // UsernameExists checks if a username already exists.
 func (r *UserRepository) UsernameExists(username string) (bool, error) {
 	lowercaseUsername := strings.ToLower(username)
@@ -132,3 +764,125 @@ func (r *UserRepository) UsernameExists(username string) (bool, error) {
 	}
 	return true, nil
 }
+
+// EmailExists checks if an email address is already registered, ignoring case.
+func (r *UserRepository) EmailExists(email string) (bool, error) {
+	var user models.User
+	err := r.DB.Where("LOWER(email) = LOWER(?)", strings.TrimSpace(email)).
+		First(&user).Error
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// NameCount is a name and the number of times it occurs.
+type NameCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// ModelUsage is a user's summed usage of an OpenAI model.
+type ModelUsage struct {
+	Model                 string `json:"model"`
+	PromptTokens          int    `json:"prompt_tokens"`
+	CompletionTokens      int    `json:"completion_tokens"`
+	Images                int    `json:"images"`
+	CostMicros            int64  `json:"cost_micros"`
+	PersonalKeyCostMicros int64  `json:"personal_key_cost_micros"`
+}
+
+// GetTokenUsageByUser sums a user's usage of each OpenAI model since the given time, sorted by model.
+func (r *UserRepository) GetTokenUsageByUser(userID uint, since time.Time) ([]ModelUsage, error) {
+	var usages []ModelUsage
+	err := r.DB.Raw(`SELECT api_model AS model, SUM(prompt_tokens) AS prompt_tokens, SUM(completion_tokens) AS completion_tokens,
+			SUM(images) AS images, SUM(cost_micros) AS cost_micros,
+			COALESCE(SUM(cost_micros) FILTER (WHERE personal_key), 0) AS personal_key_cost_micros
+		FROM token_usages
+		WHERE user_id = ? AND created_at >= ? AND deleted_at IS NULL
+		GROUP BY api_model
+		ORDER BY api_model`, userID, since).
+		Scan(&usages).Error
+	if err != nil {
+		log.Printf("Error summing user token usage: %v", err)
+		return nil, err
+	}
+
+	return usages, nil
+}
+
+// UserRecipeStats are aggregates over the recipes a user has generated.
+type UserRecipeStats struct {
+	RecipeCount    int
+	RecipesSince   int // Recipes created since the time the stats were asked from
+	TotalCookTime  int // In minutes
+	TimesMade      int
+	TopIngredients []NameCount
+	TopHashtags    []NameCount
+}
+
+// GetUserRecipeStats aggregates the recipes created by a user, counting those created since the given time separately.
+// The top ingredients and hashtags are limited to topN each.
+func (r *UserRepository) GetUserRecipeStats(userID uint, since time.Time, topN int) (*UserRecipeStats, error) {
+	var stats UserRecipeStats
+
+	err := r.DB.Raw(`SELECT COUNT(*), COALESCE(SUM(cook_time), 0), COUNT(*) FILTER (WHERE created_at >= ?)
+		FROM recipes WHERE created_by_id = ? AND deleted_at IS NULL`, since, userID).
+		Row().
+		Scan(&stats.RecipeCount, &stats.TotalCookTime, &stats.RecipesSince)
+	if err != nil {
+		log.Printf("Error aggregating user recipes: %v", err)
+		return nil, err
+	}
+
+	err = r.DB.Model(&models.RecipeMade{}).
+		Where("user_id = ?", userID).
+		Count(&stats.TimesMade).Error
+	if err != nil {
+		log.Printf("Error counting user recipe made entries: %v", err)
+		return nil, err
+	}
+
+	// Ingredient names are compared case-insensitively
+	err = r.DB.Raw(`SELECT LOWER(ingredient->>'name') AS name, COUNT(*) AS count
+		FROM recipes, jsonb_array_elements(recipes.ingredients) AS ingredient
+		WHERE recipes.created_by_id = ? AND recipes.deleted_at IS NULL
+		GROUP BY LOWER(ingredient->>'name') ORDER BY count DESC, name LIMIT ?`, userID, topN).
+		Scan(&stats.TopIngredients).Error
+	if err != nil {
+		log.Printf("Error aggregating user recipe ingredients: %v", err)
+		return nil, err
+	}
+
+	err = r.DB.Raw(`SELECT tags.hashtag AS name, COUNT(*) AS count
+		FROM recipes
+		JOIN recipe_tags ON recipe_tags.recipe_id = recipes.id
+		JOIN tags ON tags.id = recipe_tags.tag_id
+		WHERE recipes.created_by_id = ? AND recipes.deleted_at IS NULL
+		GROUP BY tags.hashtag ORDER BY count DESC, name LIMIT ?`, userID, topN).
+		Scan(&stats.TopHashtags).Error
+	if err != nil {
+		log.Printf("Error aggregating user recipe hashtags: %v", err)
+		return nil, err
+	}
+
+	return &stats, nil
+}
+
+// AddPersonalKeySpend atomically adds to a user's estimated spend on their personal OpenAI key for the given month,
+// restarting the count when the month changes.
+func (r *UserRepository) AddPersonalKeySpend(userID uint, month time.Time, spendMicros int64) error {
+	err := r.DB.Model(&models.UserSettings{}).
+		Where("user_id = ?", userID).
+		UpdateColumns(map[string]interface{}{
+			"personal_key_spend_micros": gorm.Expr("CASE WHEN personal_key_spend_month = ? THEN personal_key_spend_micros + ? ELSE ? END", month, spendMicros, spendMicros),
+			"personal_key_spend_month":  month,
+		}).Error
+	if err != nil {
+		log.Printf("Error adding personal key spend: %v", err)
+	}
+	return err
+}
@@ -0,0 +1,236 @@
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+	"github.com/windoze95/saltybytes-api/internal/models"
+)
+
+// newUserTestDB opens an in-memory database with the tables users, and everything created with them, are stored in.
+func newUserTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	err = db.AutoMigrate(
+		&models.User{},
+		&models.UserAuth{},
+		&models.Subscription{},
+		&models.UserSettings{},
+		&models.Personalization{},
+		&models.StripeEvent{},
+	).Error
+	if err != nil {
+		t.Fatalf("migrating users: %v", err)
+	}
+	return db
+}
+
+func TestEmailExists(t *testing.T) {
+	db := newUserTestDB(t)
+	// Stored before emails were lowercased
+	if err := db.Create(&models.User{Username: "chef", Email: "Chef@Example.com"}).Error; err != nil {
+		t.Fatalf("creating user: %v", err)
+	}
+	r := NewUserRepository(db)
+
+	tests := []struct {
+		email string
+		want  bool
+	}{
+		{"Chef@Example.com", true},
+		{"chef@example.com", true},
+		{" CHEF@EXAMPLE.COM ", true},
+		{"cook@example.com", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.email, func(t *testing.T) {
+			exists, err := r.EmailExists(tt.email)
+			if err != nil {
+				t.Fatalf("EmailExists: %v", err)
+			}
+			if exists != tt.want {
+				t.Fatalf("EmailExists(%q) = %v, want %v", tt.email, exists, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateUserCreatesPersonalization(t *testing.T) {
+	db := newUserTestDB(t)
+	r := NewUserRepository(db)
+
+	// Built without a UID, which the hook fills in
+	user, err := r.CreateUser(&models.User{
+		Username:        "chef",
+		Personalization: &models.Personalization{UnitSystem: models.Metric},
+	})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	stored, err := r.GetUserByID(user.ID)
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	personalization := stored.Personalization
+	if personalization == nil || personalization.ID == 0 || personalization.UID == uuid.Nil {
+		t.Fatalf("personalization %+v, want one saved with a UID", personalization)
+	}
+	if personalization.UnitSystem != models.Metric || personalization.Language != models.DefaultLanguage {
+		t.Fatalf("personalization %+v, want the given unit system and the default language", personalization)
+	}
+}
+
+func TestBackfillPersonalizations(t *testing.T) {
+	db := newUserTestDB(t)
+	r := NewUserRepository(db)
+
+	// A user from before personalizations were created on signup, and one whose personalization has no UID
+	without := models.User{Username: "without"}
+	withoutUID := models.User{Username: "withoutuid"}
+	for _, user := range []*models.User{&without, &withoutUID} {
+		if err := db.Create(user).Error; err != nil {
+			t.Fatalf("creating user: %v", err)
+		}
+	}
+	if err := db.Create(&models.Personalization{UserID: withoutUID.ID}).Error; err != nil {
+		t.Fatalf("creating personalization: %v", err)
+	}
+	if err := db.Model(&models.Personalization{}).UpdateColumn("uid", uuid.Nil).Error; err != nil {
+		t.Fatalf("clearing UID: %v", err)
+	}
+
+	result, err := r.BackfillPersonalizations()
+	if err != nil {
+		t.Fatalf("BackfillPersonalizations: %v", err)
+	}
+	if *result != (PersonalizationBackfillResult{Created: 1, UIDsAssigned: 1}) {
+		t.Fatalf("got %+v, want 1 created and 1 UID assigned", *result)
+	}
+	for _, user := range []models.User{without, withoutUID} {
+		stored, err := r.GetUserByID(user.ID)
+		if err != nil {
+			t.Fatalf("GetUserByID: %v", err)
+		}
+		if stored.Personalization == nil || stored.Personalization.UID == uuid.Nil {
+			t.Fatalf("user %s personalization %+v, want one with a UID", user.Username, stored.Personalization)
+		}
+	}
+
+	// Backfilling again changes nothing
+	result, err = r.BackfillPersonalizations()
+	if err != nil {
+		t.Fatalf("BackfillPersonalizations again: %v", err)
+	}
+	if *result != (PersonalizationBackfillResult{}) {
+		t.Fatalf("second run got %+v, want no changes", *result)
+	}
+}
+
+func TestIncrementDailyGenerationsResetsAtTheDayBoundary(t *testing.T) {
+	db := newUserTestDB(t)
+	if err := db.Create(&models.Subscription{UserID: 1, SubscriptionTier: models.Free}).Error; err != nil {
+		t.Fatalf("creating subscription: %v", err)
+	}
+	r := NewUserRepository(db)
+
+	const dailyCap = 2
+	day := time.Date(2026, time.March, 14, 0, 0, 0, 0, time.UTC)
+	nextDay := day.AddDate(0, 0, 1)
+
+	// generationsToday returns the stored count and the day it's counted for.
+	generationsToday := func() (int, time.Time) {
+		t.Helper()
+		var subscription models.Subscription
+		if err := db.Where("user_id = ?", 1).First(&subscription).Error; err != nil {
+			t.Fatalf("getting subscription: %v", err)
+		}
+		if subscription.GenerationsDay == nil {
+			return subscription.GenerationsToday, time.Time{}
+		}
+		return subscription.GenerationsToday, subscription.GenerationsDay.UTC()
+	}
+
+	// Fill the cap on the first day
+	for i := 0; i < dailyCap; i++ {
+		counted, err := r.IncrementDailyGenerations(1, day, dailyCap)
+		if err != nil {
+			t.Fatalf("IncrementDailyGenerations: %v", err)
+		}
+		if !counted {
+			t.Fatalf("generation %d wasn't counted under the cap", i+1)
+		}
+	}
+	counted, err := r.IncrementDailyGenerations(1, day, dailyCap)
+	if err != nil {
+		t.Fatalf("IncrementDailyGenerations: %v", err)
+	}
+	if counted {
+		t.Fatal("generation over the cap was counted")
+	}
+	if count, countedDay := generationsToday(); count != dailyCap || !countedDay.Equal(day) {
+		t.Fatalf("counted %d generations on %v, want %d on %v", count, countedDay, dailyCap, day)
+	}
+
+	// The next day starts counting from 1
+	counted, err = r.IncrementDailyGenerations(1, nextDay, dailyCap)
+	if err != nil {
+		t.Fatalf("IncrementDailyGenerations: %v", err)
+	}
+	if !counted {
+		t.Fatal("generation on the next day wasn't counted")
+	}
+	if count, countedDay := generationsToday(); count != 1 || !countedDay.Equal(nextDay) {
+		t.Fatalf("counted %d generations on %v, want 1 on %v", count, countedDay, nextDay)
+	}
+}
+
+func TestStartStripeSubscriptionAppliesEachEventOnce(t *testing.T) {
+	db := newUserTestDB(t)
+	user := &models.User{Username: "chef", Email: "chef@example.com"}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatalf("creating user: %v", err)
+	}
+	if err := db.Create(&models.Subscription{UserID: user.ID, SubscriptionTier: models.Free}).Error; err != nil {
+		t.Fatalf("creating subscription: %v", err)
+	}
+	r := NewUserRepository(db)
+
+	expiresAt := time.Date(2026, time.April, 1, 0, 0, 0, 0, time.UTC)
+	if err := r.StartStripeSubscription("evt_1", user.ID, "cus_1", models.Premium, expiresAt, 100); err != nil {
+		t.Fatalf("StartStripeSubscription: %v", err)
+	}
+	// Some uses are spent before the event is redelivered
+	if err := db.Model(&models.Subscription{}).Where("user_id = ?", user.ID).UpdateColumn("remaining_uses", 40).Error; err != nil {
+		t.Fatalf("spending uses: %v", err)
+	}
+
+	err := r.StartStripeSubscription("evt_1", user.ID, "cus_1", models.Premium, expiresAt.AddDate(0, 1, 0), 100)
+	if _, ok := err.(AlreadyProcessedError); !ok {
+		t.Fatalf("redelivered StartStripeSubscription = %v, want an AlreadyProcessedError", err)
+	}
+	if _, err := r.EndStripeSubscription("evt_1", "cus_1", models.Free, expiresAt, 10); err == nil {
+		t.Fatal("EndStripeSubscription with an applied event succeeded, want an AlreadyProcessedError")
+	}
+
+	var subscription models.Subscription
+	if err := db.Where("user_id = ?", user.ID).First(&subscription).Error; err != nil {
+		t.Fatalf("getting subscription: %v", err)
+	}
+	if subscription.SubscriptionTier != models.Premium || subscription.RemainingUses != 40 || !subscription.ExpiresAt.Equal(expiresAt) {
+		t.Fatalf("subscription %s with %d uses until %v, want the first event's, with the spent uses kept",
+			subscription.SubscriptionTier, subscription.RemainingUses, subscription.ExpiresAt)
+	}
+
+	// A new event is applied
+	if _, err := r.EndStripeSubscription("evt_2", "cus_1", models.Free, expiresAt, 10); err != nil {
+		t.Fatalf("EndStripeSubscription: %v", err)
+	}
+}
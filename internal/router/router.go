@@ -1,6 +1,8 @@
 package router
 
 import (
+	"log"
+	"slices"
 	"time"
 
 	"github.com/gin-contrib/cors"
@@ -8,30 +10,32 @@ import (
 	"github.com/jinzhu/gorm"
 	"github.com/windoze95/saltybytes-api/internal/config"
 	"github.com/windoze95/saltybytes-api/internal/handlers"
+	"github.com/windoze95/saltybytes-api/internal/mail"
 	"github.com/windoze95/saltybytes-api/internal/middleware"
+	"github.com/windoze95/saltybytes-api/internal/models"
 	"github.com/windoze95/saltybytes-api/internal/repository"
 	"github.com/windoze95/saltybytes-api/internal/service"
+	"github.com/windoze95/saltybytes-api/internal/util"
 )
 
 // SetupRouter sets up the Gin router.
 func SetupRouter(cfg *config.Config, database *gorm.DB) *gin.Engine {
-	// Set Gin mode to release
-	gin.SetMode(gin.ReleaseMode)
-
-	// Create default Gin router
-	r := gin.Default()
-
-	config := cors.DefaultConfig()
-	config.AllowCredentials = true
-	config.AllowOrigins = []string{
-		"https://api.saltybytes.ai",
-		"https://www.api.saltybytes.ai",
-		"https://saltybytes.ai",
-		"https://www.saltybytes.ai",
+	// Create a Gin router that logs each request with its request ID
+	r := gin.New()
+
+	// Find the client IP behind the proxies in front of the API, before anything uses it.
+	// When the hops are set, the proxies are found by position instead, so gin trusts no proxy itself.
+	trustedProxies := cfg.Proxy.TrustedProxies
+	if cfg.Proxy.TrustedHops > 0 {
+		trustedProxies = nil
+	}
+	if err := r.SetTrustedProxies(trustedProxies); err != nil {
+		log.Printf("Error setting trusted proxies, trusting none: %v", err)
+		r.SetTrustedProxies(nil)
 	}
-	config.AllowHeaders = append(config.AllowHeaders, "X-SaltyBytes-Identifier")
+	r.Use(middleware.ForwardedClientIP(cfg.Proxy.TrustedHops))
 
-	r.Use(cors.New(config))
+	r.Use(middleware.RequestID(), gin.Recovery())
 
 	// Define constants and variables related to rate limiting
 	var globalRps int = 20                       // 20 request per second
@@ -40,6 +44,71 @@ func SetupRouter(cfg *config.Config, database *gorm.DB) *gin.Engine {
 
 	// Apply rate limiting middleware to all routes
 	r.Use(middleware.RateLimitByIP(globalRps, globalCleanupInterval, globalExpiration))
+
+	// Reject oversized request bodies. The limit is applied per group, bulk imports get a larger one
+	limitBody := middleware.LimitRequestBody(cfg.Limits.MaxBodyBytes)
+
+	// Revoked tokens are kept in the database unless configured to be kept in memory
+	var tokenBlocklist util.TokenBlocklist = repository.NewSessionTokenBlocklist(database)
+	if cfg.Auth.TokenBlocklist == config.TokenBlocklistMemory {
+		tokenBlocklist = util.NewMemoryTokenBlocklist(10 * time.Minute)
+	}
+
+	// User-related routes setup
+	userRepo := repository.NewUserRepository(database)
+	userService := service.NewUserService(cfg, userRepo, tokenBlocklist)
+	if cfg.Email.SMTPHost != "" {
+		userService.Mailer = mail.NewSMTPSender(cfg.Email.From, cfg.Email.SMTPHost, cfg.Email.SMTPPort,
+			cfg.OptionalEnv.SMTPUsername.Value(), cfg.OptionalEnv.SMTPPassword.Value())
+	}
+
+	// Recipe-related routes setup
+	recipeRepo := repository.NewRecipeRepository(database)
+	recipeService := service.NewRecipeService(cfg, recipeRepo, userRepo)
+	recipeHandler := handlers.NewRecipeHandler(recipeService)
+
+	// Purge the recipes that expired in the trash in the background
+	recipeService.StartTrashPurger()
+
+	// Refill the subscriptions whose billing cycle ended in the background
+	userService.StartQuotaResetter()
+
+	// New users get a welcome recipe, so the user handler needs the recipe service too
+	userHandler := handlers.NewUserHandler(userService, recipeService)
+
+	// Group for the recipe image proxy.
+	// Gin only applies middleware to routes registered after it, so these routes are registered before the
+	// API-wide CORS and ID header middleware. Image origins, like canvas editors, get their own CORS policy
+	// and can load images without the API identifier header.
+	imageProxy := r.Group("/v1/images", middleware.ImageCORS(cfg.Images.CORSAllowedOrigins))
+	{
+		// Get a recipe's image
+		imageProxy.GET("/recipes/:recipe_id", recipeHandler.GetRecipeImage)
+		// Preflight requests are answered by ImageCORS
+		imageProxy.OPTIONS("/recipes/:recipe_id")
+	}
+
+	// Group for webhooks from third parties, which can't send the API identifier header and are verified by signature.
+	// Registered before the API-wide CORS and ID header middleware, like the image proxy.
+	webhooks := r.Group("/v1/webhooks")
+	{
+		webhooks.Use(limitBody)
+
+		// Apply a Stripe subscription event
+		webhooks.POST("/stripe", userHandler.HandleStripeWebhook)
+	}
+
+	corsConfig := cors.DefaultConfig()
+	corsConfig.AllowCredentials = true
+	if origins := cfg.CORSAllowedOrigins(); gin.Mode() != gin.ReleaseMode && slices.Contains(origins, config.CORSWildcard) {
+		// Echo back any origin, browsers don't accept a literal wildcard on credentialed requests
+		corsConfig.AllowOriginFunc = func(string) bool { return true }
+	} else {
+		corsConfig.AllowOrigins = origins
+	}
+	corsConfig.AllowHeaders = append(corsConfig.AllowHeaders, "X-SaltyBytes-Identifier", util.LocaleHeader)
+
+	r.Use(cors.New(corsConfig))
 	r.Use(middleware.CheckIDHeader(cfg.Env.IdHeader.Value()))
 
 	// Ping route for testing
@@ -49,54 +118,163 @@ func SetupRouter(cfg *config.Config, database *gorm.DB) *gin.Engine {
 		})
 	})
 
-	// User-related routes setup
-	userRepo := repository.NewUserRepository(database)
-	userService := service.NewUserService(cfg, userRepo)
-	userHandler := handlers.NewUserHandler(userService)
-
-	// Recipe-related routes setup
-	recipeRepo := repository.NewRecipeRepository(database)
-	recipeService := service.NewRecipeService(cfg, recipeRepo)
-	recipeHandler := handlers.NewRecipeHandler(recipeService)
+	// Each password reset request can email a user, so they're limited per address on top of the global limit
+	passwordResetRateLimit := middleware.RateLimitByIPPerMinute(3, globalCleanupInterval, globalExpiration)
 
 	// Group for API routes that don't require token verification
 	apiPublic := r.Group("/v1")
 	{
+		apiPublic.Use(limitBody)
+
 		// User-related routes
 
 		// Create a new user
 		apiPublic.POST("/users", userHandler.CreateUser)
 		// Login a user
 		apiPublic.POST("/auth/login", userHandler.LoginUser)
+		// Login a user with a Facebook access token, signing them up on their first login
+		apiPublic.POST("/auth/facebook", userHandler.LoginWithFacebook)
+		// Email a password reset link
+		apiPublic.POST("/auth/password-reset/request", passwordResetRateLimit, userHandler.RequestPasswordReset)
+		// Set a new password with a password reset link
+		apiPublic.POST("/auth/password-reset/confirm", userHandler.ConfirmPasswordReset)
 
 		// Recipe-related routes
 
+		// List the newest recipes, a page at a time by cursor
+		apiPublic.GET("/recipes/feed", middleware.OptionalTokenMiddleware(cfg, tokenBlocklist), middleware.AttachUserToContext(userService), recipeHandler.ListRecipeFeed)
 		// Get a single recipe by it's ID
-		apiPublic.GET("/recipes/:recipe_id", recipeHandler.GetRecipe)
+		apiPublic.GET("/recipes/:recipe_id", middleware.OptionalTokenMiddleware(cfg, tokenBlocklist), middleware.AttachUserToContext(userService), recipeHandler.GetRecipe)
+		// Download a recipe as a printable PDF
+		apiPublic.GET("/recipes/:recipe_id/pdf", recipeHandler.GetRecipePDF)
+		// Export a recipe as Markdown, JSON, or PDF
+		apiPublic.GET("/recipes/:recipe_id/export", recipeHandler.ExportRecipe)
+		// Get the ingredients of a recipe and its sub-recipes, with repeated ingredients summed
+		apiPublic.GET("/recipes/:recipe_id/ingredients", recipeHandler.GetRecipeIngredients)
+		// Get the drinks suggested to go with a recipe
+		apiPublic.GET("/recipes/:recipe_id/pairings", recipeHandler.GetRecipePairings)
+		// Get the URL a recipe's image can be loaded from, presigned when the bucket is private
+		apiPublic.GET("/recipes/:recipe_id/image-url", recipeHandler.GetRecipeImageURL)
+		// Get the shopping list of a recipe, or of several with the ids query parameter
+		apiPublic.GET("/recipes/:recipe_id/shopping-list", recipeHandler.GetShoppingList)
+		// List a recipe's ratings, a page at a time
+		apiPublic.GET("/recipes/:recipe_id/ratings", recipeHandler.ListRecipeRatings)
+		// Get a recipe with its ingredient amounts scaled by a factor, or to a number of servings
+		apiPublic.GET("/recipes/:recipe_id/scale", middleware.OptionalTokenMiddleware(cfg, tokenBlocklist), middleware.AttachUserToContext(userService), recipeHandler.ScaleRecipe)
+		// Compare two entries of a recipe's history
+		apiPublic.GET("/recipes/:recipe_id/history/diff", recipeHandler.DiffRecipeHistory)
+		// List the recipes a user created, a page at a time
+		apiPublic.GET("/users/:user_id/recipes", recipeHandler.ListUserRecipes)
+		// List the recipes a user collected, a page at a time
+		apiPublic.GET("/users/:user_id/collected", recipeHandler.ListUserCollectedRecipes)
 		// Get a single recipe history by the recipe history's ID
-		apiPublic.GET("/recipes/chat-history/:history_id", recipeHandler.GetRecipeHistory)
+		apiPublic.GET("/recipes/chat-history/:history_id", middleware.OptionalTokenMiddleware(cfg, tokenBlocklist), middleware.AttachUserToContext(userService), recipeHandler.GetRecipeHistory)
+		// List the recipes tagged with a hashtag
+		apiPublic.GET("/tags/:hashtag/recipes", middleware.OptionalTokenMiddleware(cfg, tokenBlocklist), middleware.AttachUserToContext(userService), recipeHandler.ListTagRecipes)
+		// List the hashtags with the most recipes
+		apiPublic.GET("/tags/popular", recipeHandler.ListPopularTags)
+
+		// Unit-related routes
+
+		// List the supported ingredient units and their conversions
+		apiPublic.GET("/units", middleware.OptionalTokenMiddleware(cfg, tokenBlocklist), middleware.AttachUserToContext(userService), handlers.ListUnits(cfg))
+		// List the occasions recipes can be themed for, and the ones in season
+		apiPublic.GET("/occasions", handlers.ListOccasions)
 	}
 
+	// Explaining a recipe calls OpenAI when it isn't cached yet
+	explainRateLimit := middleware.RateLimitByUser(5, globalCleanupInterval, globalExpiration)
+	// Retagging a recipe calls OpenAI every time
+	retagRateLimit := middleware.RateLimitByUser(5, globalCleanupInterval, globalExpiration)
+	// Regenerating a recipe's pairings calls OpenAI every time
+	pairingsRateLimit := middleware.RateLimitByUser(5, globalCleanupInterval, globalExpiration)
+	// Generations on the platform's OpenAI keys are limited per user, and all together
+	publicOpenAIKeyRateLimit := middleware.RateLimitPublicOpenAIKey(3, 30, globalCleanupInterval, globalExpiration)
+
 	// Group for API routes that require token verification
 	apiProtected := r.Group("/v1")
 	{
-		apiProtected.Use(middleware.VerifyTokenMiddleware(cfg))
+		apiProtected.Use(limitBody, middleware.VerifyTokenMiddleware(cfg, tokenBlocklist))
 
 		// User-related routes
 
 		// Verify a user's token
 		apiProtected.GET("/users/verify", middleware.AttachUserToContext(userService), userHandler.VerifyToken)
+		// Exchange a token that's about to expire for a new one
+		apiProtected.POST("/auth/refresh", userHandler.RefreshToken)
+		// Log out of the device the request was made from
+		apiProtected.POST("/auth/logout", middleware.AttachUserToContext(userService), userHandler.LogoutUser)
 		// Get a user by their ID
 		apiProtected.GET("/users/me", middleware.AttachUserToContext(userService), userHandler.GetUserByID)
+		// Get the aggregate stats of a user's recipes
+		apiProtected.GET("/users/me/stats", middleware.AttachUserToContext(userService), userHandler.GetUserStats)
+		// Get the user's OpenAI token usage and its estimated cost this billing period
+		apiProtected.GET("/users/me/usage", middleware.AttachUserToContext(userService), userHandler.GetTokenUsage)
+		// List the user's active login sessions
+		apiProtected.GET("/users/me/sessions", middleware.AttachUserToContext(userService), userHandler.ListSessions)
+		// Revoke one of the user's login sessions
+		apiProtected.DELETE("/users/me/sessions/:session_id", middleware.AttachUserToContext(userService), userHandler.RevokeSession)
+		// Preview the messages a recipe generation would send to OpenAI
+		apiProtected.GET("/users/me/prompt-preview", middleware.AttachUserToContext(userService), recipeHandler.PreviewPrompt)
 		// Get a user's settings
 		apiProtected.GET("/users/settings", middleware.AttachUserToContext(userService), userHandler.GetUserSettings)
+		// Update any of a user's settings and personalization
+		apiProtected.PATCH("/users/settings", middleware.AttachUserToContext(userService), userHandler.UpdateUserSettings)
+		// Replace the unit system and requirements recipes are generated for a user with
+		apiProtected.PUT("/users/personalization", middleware.AttachUserToContext(userService), userHandler.UpdateGuidingContent)
+		// Replace the dietary restrictions every recipe generated for a user must meet
+		apiProtected.PUT("/users/me/dietary-restrictions", middleware.AttachUserToContext(userService), userHandler.UpdateDietaryRestrictions)
+		// Replace the allergens filtered out of the recipe feeds for a user
+		apiProtected.PUT("/users/me/allergen-exclusions", middleware.AttachUserToContext(userService), userHandler.UpdateAllergenExclusions)
+		// List the recipes in a user's trash
+		apiProtected.GET("/users/:user_id/trash", middleware.AttachUserToContext(userService), recipeHandler.ListTrash)
+		// Restore a recipe from a user's trash
+		apiProtected.PUT("/users/:user_id/trash/:recipe_id/restore", middleware.AttachUserToContext(userService), recipeHandler.RestoreRecipe)
+		// Permanently delete several of a user's recipes at once, skipping the trash
+		apiProtected.DELETE("/users/:user_id/recipes", middleware.AttachUserToContext(userService), recipeHandler.BulkDeleteRecipes)
+		// Collect a recipe
+		apiProtected.PUT("/users/:user_id/recipes/:recipe_id/collect", middleware.AttachUserToContext(userService), recipeHandler.CollectRecipe)
+		// Remove a recipe from the user's collected recipes
+		apiProtected.DELETE("/users/:user_id/recipes/:recipe_id/collect", middleware.AttachUserToContext(userService), recipeHandler.UncollectRecipe)
 
 		// Recipe-related routes
 
 		// // Get a single recipe by it's ID
 		// apiProtected.GET("/recipes/:recipe_id", recipeHandler.GetRecipe)
 		// Generate a new recipe
-		apiProtected.POST("/recipes/chat", middleware.AttachUserToContext(userService), recipeHandler.GenerateRecipeWithChat)
+		apiProtected.POST("/recipes/chat", middleware.AttachUserToContext(userService), publicOpenAIKeyRateLimit, middleware.EnforceSubscriptionQuota(userService), middleware.EnforceDailyGenerationCap(userService), recipeHandler.GenerateRecipeWithChat)
+		// Generate a new recipe from the ingredients the user has on hand
+		apiProtected.POST("/recipes/from-ingredients", middleware.AttachUserToContext(userService), publicOpenAIKeyRateLimit, middleware.EnforceSubscriptionQuota(userService), middleware.EnforceDailyGenerationCap(userService), recipeHandler.GenerateRecipeFromIngredients)
+		// Fork a recipe into a new one, modified per the user's prompt
+		apiProtected.POST("/recipes/:recipe_id/fork", middleware.AttachUserToContext(userService), publicOpenAIKeyRateLimit, middleware.EnforceSubscriptionQuota(userService), middleware.EnforceDailyGenerationCap(userService), recipeHandler.ForkRecipe)
+		// Edit one of the user's recipes by hand
+		apiProtected.PUT("/recipes/:recipe_id", middleware.AttachUserToContext(userService), recipeHandler.EditRecipe)
+		// Copy a recipe into a new one as is, for the user to edit by hand
+		apiProtected.POST("/recipes/:recipe_id/duplicate", middleware.AttachUserToContext(userService), recipeHandler.DuplicateRecipe)
+		// Update one of the user's recipes per a follow-up prompt
+		apiProtected.POST("/recipes/:recipe_id/refine", middleware.AttachUserToContext(userService), publicOpenAIKeyRateLimit, middleware.EnforceSubscriptionQuota(userService), middleware.EnforceDailyGenerationCap(userService), recipeHandler.RefineRecipe)
+		// Restore one of the user's recipes to an earlier entry of its history
+		apiProtected.POST("/recipes/:recipe_id/revert", middleware.AttachUserToContext(userService), recipeHandler.RevertRecipe)
+		// Link one of the user's recipes to the existing recipes matching its link suggestions
+		apiProtected.POST("/recipes/:recipe_id/resolve-links", middleware.AttachUserToContext(userService), recipeHandler.ResolveRecipeLinks)
+		// Get the generation status of a recipe, for polling its generation
+		apiProtected.GET("/recipes/:recipe_id/status", middleware.AttachUserToContext(userService), recipeHandler.GetGenerationStatus)
+		// Stream the progress of a recipe's generation as Server-Sent Events
+		apiProtected.GET("/recipes/:recipe_id/stream", middleware.AttachUserToContext(userService), middleware.RequireFeature(models.FeatureStreaming), recipeHandler.StreamRecipeGeneration)
+		// Regenerate a recipe's hashtags from its current content
+		apiProtected.POST("/recipes/:recipe_id/retag", middleware.AttachUserToContext(userService), retagRateLimit, recipeHandler.RetagRecipe)
+		// Replace the drinks suggested to go with one of the user's recipes, leaving the rest of it as is
+		apiProtected.POST("/recipes/:recipe_id/pairings/regenerate", middleware.AttachUserToContext(userService), pairingsRateLimit, recipeHandler.RegenerateRecipePairings)
+		// Regenerate a recipe's image from its image prompt, without regenerating the recipe
+		apiProtected.POST("/recipes/:recipe_id/image/regenerate", middleware.AttachUserToContext(userService), publicOpenAIKeyRateLimit, middleware.EnforceSubscriptionQuota(userService), recipeHandler.RegenerateRecipeImage)
+		// Explain the techniques behind a recipe's key steps, an extra OpenAI call so it's rate limited per user
+		apiProtected.GET("/recipes/:recipe_id/explain", middleware.AttachUserToContext(userService), explainRateLimit, recipeHandler.ExplainRecipe)
+		// Log that the user made a recipe
+		apiProtected.POST("/recipes/:recipe_id/made", middleware.AttachUserToContext(userService), recipeHandler.LogRecipeMade)
+		// Rate a collected recipe, replacing the user's earlier rating of it
+		apiProtected.POST("/recipes/:recipe_id/rating", middleware.AttachUserToContext(userService), recipeHandler.RateRecipe)
+		// Move a recipe to the trash, it can be restored until it's purged
+		apiProtected.DELETE("/recipes/:recipe_id", middleware.AttachUserToContext(userService), recipeHandler.DeleteRecipe)
 		// Import a recipe with a link
 		// apiProtected.POST("/recipes/import/link", middleware.AttachUserToContext(userService), recipeHandler.ImportRecipeLink)
 		// Import a recipe with vision
@@ -109,5 +287,47 @@ func SetupRouter(cfg *config.Config, database *gorm.DB) *gin.Engine {
 		// apiProtected.POST("/recipes/copycat", middleware.AttachUserToContext(userService), recipeHandler.CopycatRecipe)
 	}
 
+	// Group for API routes that require an admin
+	apiAdmin := r.Group("/v1/admin")
+	{
+		apiAdmin.Use(limitBody, middleware.VerifyTokenMiddleware(cfg, tokenBlocklist), middleware.AttachUserToContext(userService), middleware.RequireAdmin())
+
+		// Change a user's role
+		apiAdmin.PUT("/users/:user_id/role", userHandler.SetUserRole)
+		// Enable or disable a beta feature for a user
+		apiAdmin.PUT("/users/:user_id/features/:flag", userHandler.SetUserFeatureFlag)
+		// Create the personalizations existing users are missing
+		apiAdmin.POST("/users/personalizations/backfill", userHandler.BackfillPersonalizations)
+		// Re-encrypt every personal OpenAI key with the current encryption key
+		apiAdmin.POST("/openai-keys/rotate", userHandler.RotateOpenAIKeyEncryption)
+		// Re-clean every hashtag and merge the duplicates
+		apiAdmin.POST("/tags/normalize", recipeHandler.NormalizeTags)
+		// Get the hit and miss counts of the recipe cache
+		apiAdmin.GET("/recipes/cache", recipeHandler.GetRecipeCacheStats)
+	}
+
+	// Group for admin API routes that take bulk uploads, which are too large for the limit of the other routes
+	apiAdminImport := r.Group("/v1/admin")
+	{
+		apiAdminImport.Use(middleware.LimitRequestBody(cfg.Limits.MaxImportBodyBytes), middleware.VerifyTokenMiddleware(cfg, tokenBlocklist), middleware.AttachUserToContext(userService), middleware.RequireAdmin())
+
+		// Create users in bulk
+		apiAdminImport.POST("/users/import", userHandler.ImportUsers)
+	}
+
+	// Group for admin API routes that moderators can use too
+	apiModeration := r.Group("/v1/admin")
+	{
+		apiModeration.Use(limitBody, middleware.VerifyTokenMiddleware(cfg, tokenBlocklist), middleware.AttachUserToContext(userService), middleware.RequireModerator())
+
+		// List every user's recipes, a page at a time
+		apiModeration.GET("/recipes", recipeHandler.ListRecipesForAdmin)
+		// Remove a recipe, which its owner can't restore
+		apiModeration.DELETE("/recipes/:recipe_id", recipeHandler.ModerateRecipe)
+	}
+
+	// Structured JSON 404 for unknown API routes
+	r.NoRoute(handlers.NotFound(r))
+
 	return r
 }
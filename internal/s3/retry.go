@@ -0,0 +1,132 @@
+package s3
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/windoze95/saltybytes-api/internal/config"
+)
+
+// ErrCircuitOpen is returned when an upload is short-circuited because S3 has been failing persistently.
+var ErrCircuitOpen = errors.New("S3 uploads are paused after repeated failures")
+
+// CircuitBreaker stops calls to S3 after consecutive failures, then lets a single call through once the cooldown has passed.
+type CircuitBreaker struct {
+	mu               sync.Mutex
+	failureThreshold int
+	cooldown         time.Duration
+	failures         int
+	openUntil        time.Time
+}
+
+// NewCircuitBreaker is the constructor function for initializing a new CircuitBreaker.
+// A non-positive failure threshold disables the breaker.
+func NewCircuitBreaker(failureThreshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		failureThreshold: failureThreshold,
+		cooldown:         cooldown,
+	}
+}
+
+// Allow reports whether a call may go through.
+func (b *CircuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failureThreshold <= 0 || b.failures < b.failureThreshold {
+		return true
+	}
+
+	// Let a trial call through once the cooldown has passed, and hold back the others until it reports
+	now := time.Now()
+	if now.Before(b.openUntil) {
+		return false
+	}
+	b.openUntil = now.Add(b.cooldown)
+	return true
+}
+
+// RecordSuccess closes the breaker.
+func (b *CircuitBreaker) RecordSuccess() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.openUntil = time.Time{}
+}
+
+// RecordFailure counts a failed call, opening the breaker once the threshold is reached.
+func (b *CircuitBreaker) RecordFailure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.failureThreshold > 0 && b.failures >= b.failureThreshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+}
+
+// UploadRecipeImageWithRetry uploads a recipe image to S3, retrying transient failures with exponential backoff.
+// Returns ErrCircuitOpen without calling S3 if the breaker is open.
+func UploadRecipeImageWithRetry(cfg *config.Config, breaker *CircuitBreaker, imgBytes []byte, s3Key string, metadata map[string]string) (string, error) {
+	opts := cfg.Images.Upload
+	return retryUpload(breaker, opts.MaxAttempts, time.Duration(opts.InitialBackoffMillis)*time.Millisecond, func() (string, error) {
+		return UploadRecipeImageToS3(cfg, imgBytes, s3Key, metadata)
+	})
+}
+
+// retryUpload calls upload up to maxAttempts times, doubling the backoff between attempts. A failure that isn't
+// transient is given up on right away.
+// The breaker sees the outcome of the upload as a whole, not of each attempt.
+func retryUpload(breaker *CircuitBreaker, maxAttempts int, backoff time.Duration, upload func() (string, error)) (string, error) {
+	if !breaker.Allow() {
+		return "", ErrCircuitOpen
+	}
+
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	var err error
+	attempt := 1
+	for ; attempt <= maxAttempts; attempt++ {
+		var location string
+		location, err = upload()
+		if err == nil {
+			breaker.RecordSuccess()
+			return location, nil
+		}
+
+		if attempt == maxAttempts || !isTransientS3Error(err) {
+			break
+		}
+		log.Printf("S3 upload attempt %d of %d failed, retrying in %v: %v", attempt, maxAttempts, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+
+	breaker.RecordFailure()
+	return "", fmt.Errorf("failed after %d attempts: %w", attempt, err)
+}
+
+// isTransientS3Error reports whether a failed S3 call may succeed if it's tried again, i.e. it was throttled, S3
+// failed on its end, or the request didn't get through. Errors that aren't from S3 are assumed to be transient.
+func isTransientS3Error(err error) bool {
+	var reqErr awserr.RequestFailure
+	if errors.As(err, &reqErr) && reqErr.StatusCode() >= http.StatusInternalServerError {
+		return true
+	}
+
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) {
+		return request.IsErrorRetryable(awsErr) || request.IsErrorThrottle(awsErr)
+	}
+
+	return true
+}
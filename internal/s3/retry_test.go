@@ -0,0 +1,115 @@
+package s3
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// s3Error returns an error like the ones S3 fails requests with.
+func s3Error(code string, statusCode int) error {
+	return awserr.NewRequestFailure(awserr.New(code, "failed", nil), statusCode, "request-id")
+}
+
+// failingUpload returns an upload that fails with the errors in turn, then succeeds, and a count of its calls.
+func failingUpload(errs ...error) (func() (string, error), *int) {
+	calls := 0
+	return func() (string, error) {
+		calls++
+		if calls <= len(errs) {
+			return "", errs[calls-1]
+		}
+		return "https://bucket.s3.amazonaws.com/image.jpg", nil
+	}, &calls
+}
+
+func TestRetryUploadTransientFailures(t *testing.T) {
+	breaker := NewCircuitBreaker(3, time.Minute)
+	upload, calls := failingUpload(s3Error("InternalError", http.StatusInternalServerError), s3Error("SlowDown", http.StatusServiceUnavailable))
+
+	location, err := retryUpload(breaker, 3, time.Millisecond, upload)
+	if err != nil {
+		t.Fatalf("retryUpload: %v", err)
+	}
+	if location == "" {
+		t.Fatal("retryUpload returned no location")
+	}
+	if *calls != 3 {
+		t.Fatalf("got %d upload attempts, want 3", *calls)
+	}
+	if !breaker.Allow() {
+		t.Fatal("breaker is open after an upload that succeeded")
+	}
+}
+
+func TestRetryUploadDoesNotRetryPermanentFailures(t *testing.T) {
+	breaker := NewCircuitBreaker(3, time.Minute)
+	upload, calls := failingUpload(s3Error("AccessDenied", http.StatusForbidden))
+
+	if _, err := retryUpload(breaker, 3, time.Millisecond, upload); err == nil {
+		t.Fatal("retryUpload succeeded, want the S3 error")
+	}
+	if *calls != 1 {
+		t.Fatalf("got %d upload attempts, want 1", *calls)
+	}
+}
+
+func TestRetryUploadPersistentFailuresOpenBreaker(t *testing.T) {
+	breaker := NewCircuitBreaker(2, time.Minute)
+	outage := s3Error("ServiceUnavailable", http.StatusServiceUnavailable)
+
+	// Each upload fails after all of its attempts, which counts as one failure
+	for i := 0; i < 2; i++ {
+		upload, calls := failingUpload(outage, outage)
+		if _, err := retryUpload(breaker, 2, time.Millisecond, upload); err == nil || errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("upload %d error = %v, want the S3 error", i+1, err)
+		}
+		if *calls != 2 {
+			t.Fatalf("upload %d got %d attempts, want 2", i+1, *calls)
+		}
+	}
+
+	// The breaker is open, S3 isn't called
+	upload, calls := failingUpload()
+	if _, err := retryUpload(breaker, 2, time.Millisecond, upload); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("error = %v, want %v", err, ErrCircuitOpen)
+	}
+	if *calls != 0 {
+		t.Fatalf("got %d upload attempts with the breaker open, want 0", *calls)
+	}
+}
+
+func TestCircuitBreakerCooldown(t *testing.T) {
+	breaker := NewCircuitBreaker(1, 20*time.Millisecond)
+	breaker.RecordFailure()
+	if breaker.Allow() {
+		t.Fatal("breaker allowed a call while open")
+	}
+
+	// One trial call goes through after the cooldown, the others wait for it
+	time.Sleep(30 * time.Millisecond)
+	if !breaker.Allow() {
+		t.Fatal("breaker didn't allow a trial call after the cooldown")
+	}
+	if breaker.Allow() {
+		t.Fatal("breaker allowed a second call during the trial")
+	}
+
+	breaker.RecordSuccess()
+	if !breaker.Allow() {
+		t.Fatal("breaker is still open after the trial call succeeded")
+	}
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	breaker := NewCircuitBreaker(0, time.Minute)
+	for i := 0; i < 5; i++ {
+		breaker.RecordFailure()
+	}
+	if !breaker.Allow() {
+		t.Fatal("disabled breaker refused a call")
+	}
+}
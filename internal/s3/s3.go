@@ -2,7 +2,14 @@ package s3
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
@@ -12,8 +19,8 @@ import (
 	"github.com/windoze95/saltybytes-api/internal/config"
 )
 
-// UploadRecipeImageToS3 uploads a given byte array to an S3 bucket and returns the location URL.
-func UploadRecipeImageToS3(cfg *config.Config, imgBytes []byte, s3Key string) (string, error) {
+// UploadRecipeImageToS3 uploads a given byte array to an S3 bucket, with the optional metadata, and returns the location URL.
+func UploadRecipeImageToS3(cfg *config.Config, imgBytes []byte, s3Key string, metadata map[string]string) (string, error) {
 	sess := session.Must(session.NewSession(&aws.Config{
 		Region:      aws.String(cfg.Env.AWSRegion.Value()),
 		Credentials: credentials.NewStaticCredentials(cfg.Env.AWSAccessKeyID.Value(), cfg.Env.AWSSecretAccessKey.Value(), ""),
@@ -22,15 +29,42 @@ func UploadRecipeImageToS3(cfg *config.Config, imgBytes []byte, s3Key string) (s
 	uploader := s3manager.NewUploader(sess)
 
 	result, err := uploader.Upload(&s3manager.UploadInput{
+		Bucket:   aws.String(cfg.Env.S3Bucket.Value()),
+		Key:      aws.String(s3Key),
+		Body:     bytes.NewReader(imgBytes),
+		Metadata: aws.StringMap(metadata),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to upload to S3: %w", err)
+	}
+
+	return result.Location, nil
+}
+
+// GetRecipeImageFromS3 downloads a given image from an S3 bucket.
+func GetRecipeImageFromS3(cfg *config.Config, s3Key string) ([]byte, error) {
+	return GetRecipeImageFromS3WithContext(context.Background(), cfg, s3Key)
+}
+
+// GetRecipeImageFromS3WithContext downloads a given image from an S3 bucket, giving up when the context is done.
+func GetRecipeImageFromS3WithContext(ctx context.Context, cfg *config.Config, s3Key string) ([]byte, error) {
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String(cfg.Env.AWSRegion.Value()),
+		Credentials: credentials.NewStaticCredentials(cfg.Env.AWSAccessKeyID.Value(), cfg.Env.AWSSecretAccessKey.Value(), ""),
+	}))
+
+	downloader := s3manager.NewDownloader(sess)
+
+	buffer := aws.NewWriteAtBuffer([]byte{})
+	_, err := downloader.DownloadWithContext(ctx, buffer, &s3.GetObjectInput{
 		Bucket: aws.String(cfg.Env.S3Bucket.Value()),
 		Key:    aws.String(s3Key),
-		Body:   bytes.NewReader(imgBytes),
 	})
 	if err != nil {
-		return "", fmt.Errorf("failed to upload to S3: %v", err)
+		return nil, fmt.Errorf("failed to download from S3: %v", err)
 	}
 
-	return result.Location, nil
+	return buffer.Bytes(), nil
 }
 
 // DeleteRecipeImageFromS3 deletes a given image from an S3 bucket.
@@ -53,7 +87,103 @@ func DeleteRecipeImageFromS3(cfg *config.Config, s3Key string) error {
 	return nil
 }
 
-// GenerateS3Key generates the S3 key for a recipe image, given the recipe ID.
-func GenerateS3Key(recipeID uint) string {
+// CopyRecipeImageInS3 copies an image to another key of the S3 bucket, and returns the location URL of the copy.
+func CopyRecipeImageInS3(cfg *config.Config, srcKey string, dstKey string) (string, error) {
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String(cfg.Env.AWSRegion.Value()),
+		Credentials: credentials.NewStaticCredentials(cfg.Env.AWSAccessKeyID.Value(), cfg.Env.AWSSecretAccessKey.Value(), ""),
+	}))
+
+	copier := s3.New(sess)
+
+	bucket := cfg.Env.S3Bucket.Value()
+	req, _ := copier.CopyObjectRequest(&s3.CopyObjectInput{
+		Bucket:     aws.String(bucket),
+		Key:        aws.String(dstKey),
+		CopySource: aws.String(url.PathEscape(bucket + "/" + srcKey)),
+	})
+	if err := req.Send(); err != nil {
+		return "", fmt.Errorf("failed to copy in S3: %v", err)
+	}
+
+	// The copy is located where it was put, like an upload
+	location := *req.HTTPRequest.URL
+	location.RawQuery = ""
+	return location.String(), nil
+}
+
+// DefaultPresignExpiry is how long a presigned URL can be used when no expiry is configured.
+const DefaultPresignExpiry = 15 * time.Minute
+
+// GeneratePresignedRecipeImageURL returns a URL that GETs a recipe image from the S3 bucket, even a private one, given
+// its key, until it expires, along with when it does.
+func GeneratePresignedRecipeImageURL(cfg *config.Config, s3Key string) (string, time.Time, error) {
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String(cfg.Env.AWSRegion.Value()),
+		Credentials: credentials.NewStaticCredentials(cfg.Env.AWSAccessKeyID.Value(), cfg.Env.AWSSecretAccessKey.Value(), ""),
+	}))
+
+	getter := s3.New(sess)
+
+	expiry := cfg.Images.Presigned.Expiry()
+	if expiry <= 0 {
+		expiry = DefaultPresignExpiry
+	}
+
+	req, _ := getter.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(cfg.Env.S3Bucket.Value()),
+		Key:    aws.String(s3Key),
+	})
+	// Presigning only signs the request locally, S3 isn't called
+	expiresAt := time.Now().Add(expiry)
+	presignedURL, err := req.Presign(expiry)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to presign S3 URL: %v", err)
+	}
+
+	return presignedURL, expiresAt, nil
+}
+
+// GenerateS3Key generates the S3 key for a recipe image, given the recipe ID and the image. The key is namespaced by
+// environment and named after a hash of the image, so each version of a recipe's image has a key of its own.
+func GenerateS3Key(cfg *config.Config, recipeID uint, imgBytes []byte) string {
+	sum := sha256.Sum256(imgBytes)
+	return recipeImageKey(cfg, recipeID, hex.EncodeToString(sum[:8])+".jpg")
+}
+
+// GenerateS3CopyKey generates the S3 key for a copy of a recipe image made for another recipe, given that recipe's ID
+// and the key of the image. The copy keeps the name of the image.
+func GenerateS3CopyKey(cfg *config.Config, recipeID uint, srcKey string) string {
+	return recipeImageKey(cfg, recipeID, path.Base(srcKey))
+}
+
+// GenerateLegacyS3Key generates the S3 key recipe images were uploaded under before their keys were stored, given the
+// recipe ID. Every version of the image had the same key, outside of any namespace.
+func GenerateLegacyS3Key(recipeID uint) string {
 	return fmt.Sprintf("recipes/%d/images/recipe_image_%d.jpg", recipeID, recipeID)
 }
+
+// GenerateS3ThumbnailKey generates the S3 key for the thumbnail of a recipe image, given the key of the image.
+func GenerateS3ThumbnailKey(imageKey string) string {
+	return strings.TrimSuffix(imageKey, ".jpg") + "_thumb.jpg"
+}
+
+// GenerateS3WebPKey generates the S3 key for the WebP copy of a recipe image, given the key of the image.
+func GenerateS3WebPKey(imageKey string) string {
+	return strings.TrimSuffix(imageKey, ".jpg") + ".webp"
+}
+
+// GenerateS3ImageKeys returns the S3 keys of a recipe image and all its variants, given the key of the image.
+func GenerateS3ImageKeys(imageKey string) []string {
+	return []string{imageKey, GenerateS3ThumbnailKey(imageKey), GenerateS3WebPKey(imageKey)}
+}
+
+// recipeImageKey returns the S3 key of a file among a recipe's images, under the namespace of the environment if
+// there is one.
+func recipeImageKey(cfg *config.Config, recipeID uint, name string) string {
+	key := fmt.Sprintf("recipes/%d/images/%s", recipeID, name)
+	if namespace := strings.Trim(cfg.OptionalEnv.S3KeyNamespace.Value(), "/ "); namespace != "" {
+		key = namespace + "/" + key
+	}
+	return key
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/windoze95/saltybytes-api/internal/models"
+	"github.com/windoze95/saltybytes-api/internal/s3"
+)
+
+// MaxBulkDeleteRecipes is the most recipes that can be deleted in one call.
+const MaxBulkDeleteRecipes = 100
+
+// bulkDeleteImageWorkers is how many recipe images are deleted from S3 at once.
+const bulkDeleteImageWorkers = 8
+
+// BulkDeleteResult is the outcome of deleting one recipe of a bulk delete. A recipe can be deleted with an error, when
+// its image couldn't be.
+type BulkDeleteResult struct {
+	RecipeID uint   `json:"recipe_id"`
+	Deleted  bool   `json:"deleted"`
+	Error    string `json:"error,omitempty"`
+}
+
+// BulkDeleteRecipes permanently deletes several of the user's recipes at once, skipping the trash, and returns the
+// result of each in the order they were given. Recipes the user doesn't own, that don't exist, or that are sub-recipes
+// aren't deleted and get an error of their own, the rest are deleted together in one transaction. Only the user can
+// delete their recipes.
+func (s *RecipeService) BulkDeleteRecipes(user *models.User, userID uint, recipeIDs []uint) ([]BulkDeleteResult, error) {
+	if userID != user.ID {
+		return nil, ForbiddenError{message: "Only the owner can delete these recipes"}
+	}
+
+	uniqueIDs := make([]uint, 0, len(recipeIDs))
+	seen := make(map[uint]bool)
+	for _, recipeID := range recipeIDs {
+		if !seen[recipeID] {
+			seen[recipeID] = true
+			uniqueIDs = append(uniqueIDs, recipeID)
+		}
+	}
+	if len(uniqueIDs) == 0 {
+		return nil, ValidationError{message: "At least one recipe ID is required"}
+	}
+	if len(uniqueIDs) > MaxBulkDeleteRecipes {
+		return nil, ValidationError{message: fmt.Sprintf("At most %d recipes can be deleted at once", MaxBulkDeleteRecipes)}
+	}
+
+	recipes, err := s.Repo.ListRecipeOwnership(uniqueIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get recipes to delete: %w", err)
+	}
+	recipesByID := make(map[uint]models.Recipe, len(recipes))
+	for _, recipe := range recipes {
+		recipesByID[recipe.ID] = recipe
+	}
+
+	results := make([]BulkDeleteResult, len(uniqueIDs))
+	var deleteIDs []uint
+	for i, recipeID := range uniqueIDs {
+		results[i].RecipeID = recipeID
+		recipe, ok := recipesByID[recipeID]
+		switch {
+		case !ok:
+			results[i].Error = "Recipe not found"
+		case recipe.CreatedByID != user.ID:
+			results[i].Error = "Only the owner can delete this recipe"
+		case recipe.ParentRecipeID != nil:
+			results[i].Error = "Sub-recipes are deleted with their recipe"
+		default:
+			deleteIDs = append(deleteIDs, recipeID)
+		}
+	}
+	if len(deleteIDs) == 0 {
+		return results, nil
+	}
+
+	if err := s.Repo.PurgeRecipes(deleteIDs); err != nil {
+		return nil, fmt.Errorf("failed to delete recipes: %w", err)
+	}
+	for _, recipeID := range deleteIDs {
+		s.invalidateCachedRecipe(recipeID)
+	}
+
+	// The recipes are gone already, an image that fails to delete is reported on its recipe
+	var wg sync.WaitGroup
+	workers := make(chan struct{}, bulkDeleteImageWorkers)
+	for i := range results {
+		if results[i].Error != "" {
+			continue
+		}
+		results[i].Deleted = true
+
+		wg.Add(1)
+		workers <- struct{}{}
+		go func(result *BulkDeleteResult, imageKey string) {
+			defer wg.Done()
+			defer func() { <-workers }()
+			for _, s3Key := range s3.GenerateS3ImageKeys(recipeImageKey(result.RecipeID, imageKey)) {
+				if err := s3.DeleteRecipeImageFromS3(s.Cfg, s3Key); err != nil {
+					log.Printf("Error deleting recipe %d image %s from S3: %v", result.RecipeID, s3Key, err)
+					result.Error = "Recipe deleted, but its image couldn't be"
+				}
+			}
+		}(&results[i], recipesByID[results[i].RecipeID].ImageKey)
+	}
+	wg.Wait()
+
+	return results, nil
+}
@@ -0,0 +1,163 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+
+	"github.com/windoze95/saltybytes-api/internal/models"
+	"github.com/windoze95/saltybytes-api/internal/s3"
+)
+
+// DuplicateRecipe copies a recipe into a new one owned by the user, for them to edit by hand. Unlike a fork, nothing is
+// generated: the duplicate gets the recipe's content, sub-recipes, links, hashtags, and a copy of its image as they
+// are, under its own ID and with a fresh history. Only completed recipes can be duplicated.
+func (s *RecipeService) DuplicateRecipe(user *models.User, recipeID uint) (*RecipeResponse, error) {
+	if user.Personalization == nil || user.Personalization.ID == 0 {
+		log.Printf("user %d Personalization is nil", user.ID)
+		return nil, errors.New("user's Personalization is nil")
+	}
+
+	// Soft-deleted recipes aren't found
+	source, err := s.Repo.GetRecipeByID(recipeID)
+	if err != nil {
+		return nil, err
+	}
+	if source.GenerationStatus != models.GenerationComplete {
+		return nil, ValidationError{message: "Only completed recipes can be duplicated"}
+	}
+
+	recipeDef := source.RecipeDef
+	recipeDef.SubRecipes = nil
+	entryDef := recipeDef
+
+	duplicate := &models.Recipe{
+		RecipeDef:          recipeDef,
+		UnitSystem:         source.UnitSystem,
+		PinnedHashtags:     source.PinnedHashtags,
+		ImageURL:           s.Cfg.Images.PlaceholderURL,
+		CreatedBy:          user,
+		PersonalizationUID: user.Personalization.UID,
+		ForkedFromID:       &source.ID,
+		CreateType:         models.RecipeTypeDuplicate,
+		GeneratedWithModel: source.GeneratedWithModel,
+		PromptVersion:      source.PromptVersion,
+		Persona:            source.Persona,
+		Occasion:           source.Occasion,
+		GenerationStatus:   models.GenerationComplete,
+		History: &models.RecipeHistory{
+			Entries: []models.RecipeHistoryEntry{{
+				UserPrompt:     fmt.Sprintf("Duplicate of %s", source.Title),
+				RecipeResponse: &entryDef,
+				Type:           models.RecipeTypeDuplicate,
+			}},
+		},
+	}
+	// The prompt is only shown to the owner, so it's only kept when they duplicate their own recipe
+	if source.CreatedByID == user.ID {
+		duplicate.UserPrompt = source.UserPrompt
+	}
+	// Images that were never uploaded aren't in S3, they're shared as is
+	uploaded := s.hasUploadedImage(source)
+	if !uploaded {
+		duplicate.ImageURL = source.ImageURL
+	}
+
+	if err := s.Repo.CreateRecipe(duplicate); err != nil {
+		return nil, fmt.Errorf("failed to save duplicate recipe record: %w", err)
+	}
+
+	// The rest is best-effort, the duplicate is usable without it
+	if uploaded {
+		if urls, err := s.copyRecipeImage(source, duplicate.ID); err != nil {
+			log.Printf("Error copying recipe %d image to its duplicate %d, keeping the placeholder: %v", source.ID, duplicate.ID, err)
+		} else if err := s.saveRecipeImageURLs(duplicate.ID, urls, slog.Default()); err != nil {
+			log.Printf("Error saving recipe %d image URLs: %v", duplicate.ID, err)
+		}
+	}
+	if err := s.duplicateLinkedRecipes(source, duplicate); err != nil {
+		log.Printf("Error duplicating linked recipes of recipe %d: %v", source.ID, err)
+	}
+	hashtags := make([]string, 0, len(source.Hashtags))
+	for _, tag := range source.Hashtags {
+		hashtags = append(hashtags, tag.Hashtag)
+	}
+	if err := s.AssociateTagsWithRecipe(duplicate, hashtags); err != nil {
+		log.Printf("Error associating tags with recipe %d: %v", duplicate.ID, err)
+	}
+
+	return s.GetRecipeByID(duplicate.ID, user)
+}
+
+// copyRecipeImage copies the uploaded image of a recipe and its variants to the S3 keys of another recipe, and returns
+// the URLs and key of the copies. Only a failure to copy the image itself is returned, the variants are best-effort.
+func (s *RecipeService) copyRecipeImage(source *models.Recipe, recipeID uint) (recipeImageURLs, error) {
+	srcKey := recipeImageKey(source.ID, source.ImageKey)
+	dstKey := s3.GenerateS3CopyKey(s.Cfg, recipeID, srcKey)
+	imageURL, err := s3.CopyRecipeImageInS3(s.Cfg, srcKey, dstKey)
+	if err != nil {
+		return recipeImageURLs{}, err
+	}
+
+	urls := recipeImageURLs{Image: imageURL, Key: dstKey}
+	if source.ThumbnailURL != "" {
+		if urls.Thumbnail, err = s3.CopyRecipeImageInS3(s.Cfg, s3.GenerateS3ThumbnailKey(srcKey), s3.GenerateS3ThumbnailKey(dstKey)); err != nil {
+			log.Printf("Error copying recipe %d image thumbnail: %v", source.ID, err)
+		}
+	}
+	if source.WebPURL != "" {
+		if urls.WebP, err = s3.CopyRecipeImageInS3(s.Cfg, s3.GenerateS3WebPKey(srcKey), s3.GenerateS3WebPKey(dstKey)); err != nil {
+			log.Printf("Error copying recipe %d WebP image: %v", source.ID, err)
+		}
+	}
+
+	return urls, nil
+}
+
+// duplicateLinkedRecipes copies the sub-recipes of a recipe to its duplicate, each with a fresh history, and links the
+// duplicate to the other recipes the recipe links to.
+func (s *RecipeService) duplicateLinkedRecipes(source *models.Recipe, duplicate *models.Recipe) error {
+	var subRecipes []*models.Recipe
+	var linkRecipeIDs []uint
+	for _, linked := range source.LinkedRecipes {
+		if linked.ParentRecipeID == nil || *linked.ParentRecipeID != source.ID {
+			linkRecipeIDs = append(linkRecipeIDs, linked.ID)
+			continue
+		}
+
+		recipeDef := linked.RecipeDef
+		recipeDef.SubRecipes = nil
+		entryDef := recipeDef
+		subRecipes = append(subRecipes, &models.Recipe{
+			RecipeDef:          recipeDef,
+			UnitSystem:         linked.UnitSystem,
+			ImageURL:           s.Cfg.Images.PlaceholderURL,
+			CreatedByID:        duplicate.CreatedBy.ID,
+			PersonalizationUID: duplicate.PersonalizationUID,
+			CreateType:         models.RecipeTypeDuplicate,
+			GeneratedWithModel: linked.GeneratedWithModel,
+			PromptVersion:      linked.PromptVersion,
+			History: &models.RecipeHistory{
+				Entries: []models.RecipeHistoryEntry{{
+					UserPrompt:     fmt.Sprintf("Part of %s", duplicate.Title),
+					RecipeResponse: &entryDef,
+					Type:           models.RecipeTypeDuplicate,
+				}},
+			},
+		})
+	}
+	if len(subRecipes) > 0 {
+		if err := s.Repo.ReplaceSubRecipes(duplicate.ID, subRecipes); err != nil {
+			return err
+		}
+	}
+	if len(linkRecipeIDs) > 0 {
+		if err := s.Repo.LinkRecipes(duplicate.ID, linkRecipeIDs, duplicate.LinkedSuggestions); err != nil {
+			return fmt.Errorf("failed to link recipes: %w", err)
+		}
+	}
+	s.invalidateCachedRecipe(duplicate.ID)
+
+	return nil
+}
@@ -0,0 +1,147 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/windoze95/saltybytes-api/internal/models"
+)
+
+// MaxRecipeTitleLength is the longest title a recipe can be given by hand, in characters.
+const MaxRecipeTitleLength = 200
+
+// MaxRecipeCookTime is the longest cook time a recipe can be given by hand, in minutes. It's a week, for recipes
+// that cure or ferment.
+const MaxRecipeCookTime = 7 * 24 * 60
+
+// RecipeEdit is a manual edit of a recipe's content. Fields left nil are not changed.
+type RecipeEdit struct {
+	Title        *string             `json:"title"`
+	Ingredients  *models.Ingredients `json:"ingredients"`
+	Instructions *[]string           `json:"instructions"`
+	CookTime     *int                `json:"cook_time"` // Minutes
+}
+
+// EditRecipe applies the owner's manual edit to a recipe, e.g. to correct a mistake without regenerating it, and
+// records it in the recipe's history as a manual entry. The image and the rest of the recipe are kept as they are.
+// Invalid edits are a ValidationError.
+func (s *RecipeService) EditRecipe(user *models.User, recipeID uint, edit *RecipeEdit) (*RecipeResponse, error) {
+	if edit.Title == nil && edit.Ingredients == nil && edit.Instructions == nil && edit.CookTime == nil {
+		return nil, ValidationError{message: "Nothing to edit"}
+	}
+
+	recipe, err := s.Repo.GetRecipeByID(recipeID)
+	if err != nil {
+		return nil, err
+	}
+
+	if recipe.CreatedByID != user.ID {
+		return nil, ForbiddenError{message: "Only the owner can edit this recipe"}
+	}
+
+	if recipe.GenerationStatus != models.GenerationComplete {
+		return nil, ConflictError{message: "Recipe is still being generated"}
+	}
+
+	if err := applyRecipeEdit(recipe, edit); err != nil {
+		return nil, err
+	}
+
+	history, err := s.Repo.GetHistoryByID(recipe.HistoryID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get recipe history: %w", err)
+	}
+	recipe.History = history
+	if err := validateRecipeCoreFields(recipe); err != nil {
+		return nil, ValidationError{message: "Recipe needs a title, ingredients, and instructions"}
+	}
+
+	// Sub-recipes aren't edited, the entry keeps the ones of the current version so reverting to it keeps them too
+	recipeDef := recipe.RecipeDef
+	recipeDef.SubRecipes = latestSubRecipes(history.Entries)
+	entry := models.RecipeHistoryEntry{
+		UserPrompt:     "Edited by hand",
+		RecipeResponse: &recipeDef,
+		Type:           models.RecipeTypeManualEntry,
+	}
+	recipe.UserEdited = true
+	if err := s.Repo.UpdateRecipeDef(recipe, entry); err != nil {
+		return nil, fmt.Errorf("failed to save edited recipe: %w", err)
+	}
+	s.invalidateCachedRecipe(recipe.ID)
+
+	return s.GetRecipeByID(recipe.ID, user)
+}
+
+// applyRecipeEdit validates a manual edit and applies it to the recipe. Text is trimmed, blank instructions are
+// dropped, and ingredient units are spelled like the supported unit they name.
+func applyRecipeEdit(recipe *models.Recipe, edit *RecipeEdit) error {
+	if edit.Title != nil {
+		title := strings.TrimSpace(*edit.Title)
+		if title == "" {
+			return ValidationError{message: "Title can't be empty"}
+		}
+		if len([]rune(title)) > MaxRecipeTitleLength {
+			return ValidationError{message: fmt.Sprintf("Title can't be longer than %d characters", MaxRecipeTitleLength)}
+		}
+		recipe.Title = title
+	}
+
+	if edit.Ingredients != nil {
+		if len(*edit.Ingredients) == 0 {
+			return ValidationError{message: "At least one ingredient is required"}
+		}
+		ingredients := make(models.Ingredients, 0, len(*edit.Ingredients))
+		for _, ingredient := range *edit.Ingredients {
+			ingredient.Name = strings.TrimSpace(ingredient.Name)
+			if ingredient.Name == "" {
+				return ValidationError{message: "Ingredients need a name"}
+			}
+			if ingredient.Amount < 0 {
+				return ValidationError{message: fmt.Sprintf("Amount of %s can't be negative", ingredient.Name)}
+			}
+			// Ingredients without a unit, e.g. salt to taste, are kept as they are
+			if unitSymbol := strings.TrimSpace(ingredient.Unit); unitSymbol != "" {
+				unit, ok := models.LookupUnit(unitSymbol)
+				if !ok {
+					return ValidationError{message: fmt.Sprintf("Unknown unit %q of %s", unitSymbol, ingredient.Name)}
+				}
+				ingredient.Unit = unit.Symbol
+			}
+			ingredients = append(ingredients, ingredient)
+		}
+		recipe.Ingredients = ingredients
+	}
+
+	if edit.Instructions != nil {
+		instructions := make([]string, 0, len(*edit.Instructions))
+		for _, instruction := range *edit.Instructions {
+			if instruction = strings.TrimSpace(instruction); instruction != "" {
+				instructions = append(instructions, instruction)
+			}
+		}
+		if len(instructions) == 0 {
+			return ValidationError{message: "At least one instruction is required"}
+		}
+		recipe.Instructions = instructions
+	}
+
+	if edit.CookTime != nil {
+		if *edit.CookTime < 0 || *edit.CookTime > MaxRecipeCookTime {
+			return ValidationError{message: fmt.Sprintf("Cook time must be from 0 to %d minutes", MaxRecipeCookTime)}
+		}
+		recipe.CookTime = *edit.CookTime
+	}
+
+	return nil
+}
+
+// latestSubRecipes returns the sub-recipes of the newest history entry with a recipe def.
+func latestSubRecipes(entries []models.RecipeHistoryEntry) []models.RecipeDef {
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].RecipeResponse != nil {
+			return entries[i].RecipeResponse.SubRecipes
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package service
+
+// ConflictError is an error type for when a request collides with an existing resource.
+type ConflictError struct {
+	message string
+}
+
+// Error returns the error message.
+func (e ConflictError) Error() string {
+	return e.message
+}
+
+// ForbiddenError is an error type for when a user may not act on a resource.
+type ForbiddenError struct {
+	message string
+}
+
+// Error returns the error message.
+func (e ForbiddenError) Error() string {
+	return e.message
+}
+
+// PaymentRequiredError is an error type for when a user has run out of paid usage.
+type PaymentRequiredError struct {
+	message string
+}
+
+// Error returns the error message.
+func (e PaymentRequiredError) Error() string {
+	return e.message
+}
+
+// TooManyRequestsError is an error type for when a user has hit a usage limit.
+type TooManyRequestsError struct {
+	message string
+}
+
+// Error returns the error message.
+func (e TooManyRequestsError) Error() string {
+	return e.message
+}
+
+// UnavailableError is an error type for when a dependency the request needs can't be reached.
+type UnavailableError struct {
+	message string
+}
+
+// Error returns the error message.
+func (e UnavailableError) Error() string {
+	return e.message
+}
+
+// ValidationError is an error type for when a request fails validation.
+type ValidationError struct {
+	message string
+}
+
+// Error returns the error message.
+func (e ValidationError) Error() string {
+	return e.message
+}
@@ -0,0 +1,147 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/windoze95/saltybytes-api/internal/models"
+	"github.com/windoze95/saltybytes-api/internal/pdf"
+)
+
+// Formats a recipe can be exported in.
+const (
+	ExportFormatMarkdown = "md"
+	ExportFormatJSON     = "json"
+	ExportFormatPDF      = "pdf"
+)
+
+// pdfImageFetchTimeout bounds how long the recipe image is waited on for a PDF, before it's rendered text-only.
+const pdfImageFetchTimeout = 10 * time.Second
+
+// recipeDocument is the normalized JSON document of an exported recipe.
+type recipeDocument struct {
+	ID           uint                 `json:"id"`
+	Title        string               `json:"title"`
+	Ingredients  []ingredientDocument `json:"ingredients"`
+	Instructions []string             `json:"instructions"`
+	CookTime     int                  `json:"cook_time_minutes"`
+	Hashtags     []string             `json:"hashtags"`
+	ImageURL     string               `json:"image_url,omitempty"`
+	CreatedBy    string               `json:"created_by,omitempty"`
+}
+
+// ingredientDocument is an ingredient in the JSON document of an exported recipe.
+type ingredientDocument struct {
+	Name   string  `json:"name"`
+	Amount float64 `json:"amount"`
+	Unit   string  `json:"unit"`
+}
+
+// RecipeExport is a recipe rendered in an export format, ready to download.
+type RecipeExport struct {
+	Data        []byte
+	ContentType string
+	// Filename is derived from the recipe title, with the extension of the format.
+	Filename string
+}
+
+// ExportRecipe renders a recipe in the export format: "md" for Markdown, "json" for a normalized JSON document,
+// or "pdf" for a printable PDF. An unknown format is a ValidationError.
+func (s *RecipeService) ExportRecipe(ctx context.Context, recipeID uint, format string) (*RecipeExport, error) {
+	if format != ExportFormatMarkdown && format != ExportFormatJSON && format != ExportFormatPDF {
+		return nil, ValidationError{message: fmt.Sprintf("Unknown export format %q, expected md, json, or pdf", format)}
+	}
+
+	recipe, err := s.Repo.GetRecipeByID(recipeID)
+	if err != nil {
+		return nil, err
+	}
+
+	export := &RecipeExport{Filename: recipeFilename(recipe, format)}
+	switch format {
+	case ExportFormatJSON:
+		document := toRecipeDocument(recipe)
+		// The document links to the image, which a private bucket only serves through a presigned URL
+		if s.Cfg.Images.Presigned.Enabled && s.hasUploadedImage(recipe) {
+			document.ImageURL = s.presignRecipeImageURL(recipe.ID, recipe.ImageURL, recipeImageKey(recipe.ID, recipe.ImageKey))
+		}
+		export.Data, err = json.MarshalIndent(document, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("failed to export recipe as JSON: %w", err)
+		}
+		export.ContentType = "application/json; charset=utf-8"
+	case ExportFormatPDF:
+		export.Data, err = s.renderRecipePDF(ctx, recipe)
+		if err != nil {
+			return nil, err
+		}
+		export.ContentType = "application/pdf"
+	default:
+		export.Data = []byte(renderRecipeMarkdown(recipe))
+		export.ContentType = "text/markdown; charset=utf-8"
+	}
+
+	return export, nil
+}
+
+// toRecipeDocument converts a Recipe to its normalized export document.
+func toRecipeDocument(recipe *models.Recipe) recipeDocument {
+	document := recipeDocument{
+		ID:           recipe.ID,
+		Title:        recipe.Title,
+		Ingredients:  make([]ingredientDocument, len(recipe.Ingredients)),
+		Instructions: append([]string{}, recipe.Instructions...),
+		CookTime:     recipe.CookTime,
+		Hashtags:     make([]string, len(recipe.Hashtags)),
+		ImageURL:     recipe.ImageURL,
+	}
+	for i, ingredient := range recipe.Ingredients {
+		document.Ingredients[i] = ingredientDocument{Name: ingredient.Name, Amount: ingredient.Amount, Unit: ingredient.Unit}
+	}
+	for i, tag := range recipe.Hashtags {
+		document.Hashtags[i] = tag.Hashtag
+	}
+	if recipe.CreatedBy != nil {
+		document.CreatedBy = recipe.CreatedBy.Username
+	}
+
+	return document
+}
+
+// renderRecipeMarkdown renders a recipe as Markdown: title, cook time, ingredients, numbered instructions, and hashtags.
+func renderRecipeMarkdown(recipe *models.Recipe) string {
+	var builder strings.Builder
+
+	fmt.Fprintf(&builder, "# %s\n\n", markdownLine(recipe.Title))
+	if recipe.CookTime > 0 {
+		fmt.Fprintf(&builder, "**Cook time:** %d minutes\n\n", recipe.CookTime)
+	}
+
+	builder.WriteString("## Ingredients\n\n")
+	for _, ingredient := range recipe.Ingredients {
+		fmt.Fprintf(&builder, "- %s\n", markdownLine(pdf.FormatIngredient(ingredient)))
+	}
+
+	builder.WriteString("\n## Instructions\n\n")
+	for i, instruction := range recipe.Instructions {
+		fmt.Fprintf(&builder, "%d. %s\n", i+1, markdownLine(instruction))
+	}
+
+	if len(recipe.Hashtags) > 0 {
+		hashtags := make([]string, len(recipe.Hashtags))
+		for i, tag := range recipe.Hashtags {
+			hashtags[i] = "#" + tag.Hashtag
+		}
+		fmt.Fprintf(&builder, "\n%s\n", strings.Join(hashtags, " "))
+	}
+
+	return builder.String()
+}
+
+// markdownLine flattens text onto a single line, so it can't break out of the list item or heading it's in.
+func markdownLine(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
@@ -0,0 +1,148 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/windoze95/saltybytes-api/internal/models"
+	"github.com/windoze95/saltybytes-api/internal/repository"
+)
+
+// defaultFacebookGraphURL is the base URL of Facebook's Graph API.
+const defaultFacebookGraphURL = "https://graph.facebook.com"
+
+// defaultFacebookTimeout is used when the config doesn't set a timeout.
+const defaultFacebookTimeout = 10 * time.Second
+
+// facebookDebugTokenResponse is the response of the Graph API's debug_token endpoint.
+type facebookDebugTokenResponse struct {
+	Data struct {
+		AppID   string `json:"app_id"`
+		IsValid bool   `json:"is_valid"`
+		UserID  string `json:"user_id"`
+	} `json:"data"`
+}
+
+// facebookProfile is the part of a Facebook user's profile that's read on login.
+type facebookProfile struct {
+	ID        string `json:"id"`
+	FirstName string `json:"first_name"`
+	Email     string `json:"email"` // Empty when the user didn't grant the email permission
+}
+
+// LoginWithFacebook logs in the Facebook user an access token was issued to, signing them up on their first login.
+// It returns whether the user was created. A Facebook account whose email is already used by another account is a
+// ConflictError, the user has to log in to that account instead.
+func (s *UserService) LoginWithFacebook(accessToken string) (*models.User, bool, error) {
+	profile, err := s.verifyFacebookToken(accessToken)
+	if err != nil {
+		return nil, false, err
+	}
+
+	user, err := s.Repo.GetUserByFacebookID(profile.ID)
+	if err == nil {
+		return user, false, nil
+	}
+	if _, ok := err.(repository.NotFoundError); !ok {
+		return nil, false, fmt.Errorf("error looking up Facebook user: %v", err)
+	}
+
+	if profile.Email != "" {
+		_, err := s.Repo.GetUserByEmail(profile.Email)
+		if err == nil {
+			return nil, false, ConflictError{message: "an account already uses this Facebook account's email, log in to it instead"}
+		}
+		if _, ok := err.(repository.NotFoundError); !ok {
+			return nil, false, fmt.Errorf("error looking up user by email: %v", err)
+		}
+	}
+
+	user, err = s.createUser(newFacebookUser(profile))
+	if err != nil {
+		return nil, false, err
+	}
+
+	return user, true, nil
+}
+
+// newFacebookUser builds a user with Facebook auth and the default settings and personalization.
+// Facebook users don't pick a username, so it's derived from their Facebook user ID, which is unique and alphanumeric.
+func newFacebookUser(profile *facebookProfile) *models.User {
+	facebookID := profile.ID
+	user := newStandardUser("fb"+facebookID, profile.FirstName, profile.Email, "", models.Free)
+	user.Auth.AuthType = models.Facebook
+	user.Auth.FacebookID = &facebookID
+	return user
+}
+
+// verifyFacebookToken checks that an access token is valid and was issued for the app, and reads its user's profile.
+// An invalid token is a ForbiddenError, and a Graph API that can't be reached is an UnavailableError.
+func (s *UserService) verifyFacebookToken(accessToken string) (*facebookProfile, error) {
+	appID := s.Cfg.Facebook.AppID
+	appSecret := s.Cfg.OptionalEnv.FacebookAppSecret.Value()
+	if appID == "" || appSecret == "" {
+		return nil, UnavailableError{message: "Facebook login is not available"}
+	}
+
+	var debugToken facebookDebugTokenResponse
+	err := s.getFacebookGraph("debug_token", url.Values{
+		"input_token":  {accessToken},
+		"access_token": {appID + "|" + appSecret},
+	}, &debugToken)
+	if err != nil {
+		log.Printf("Error verifying Facebook access token: %v", err)
+		return nil, UnavailableError{message: "Facebook login is unavailable, please try again later"}
+	}
+	if !debugToken.Data.IsValid || debugToken.Data.AppID != appID || debugToken.Data.UserID == "" {
+		return nil, ForbiddenError{message: "invalid Facebook access token"}
+	}
+
+	var profile facebookProfile
+	err = s.getFacebookGraph("me", url.Values{
+		"fields":       {"id,first_name,email"},
+		"access_token": {accessToken},
+	}, &profile)
+	if err != nil {
+		log.Printf("Error reading Facebook profile: %v", err)
+		return nil, UnavailableError{message: "Facebook login is unavailable, please try again later"}
+	}
+	if profile.ID != debugToken.Data.UserID {
+		return nil, ForbiddenError{message: "invalid Facebook access token"}
+	}
+
+	return &profile, nil
+}
+
+// getFacebookGraph gets a Graph API path and decodes the response into v.
+func (s *UserService) getFacebookGraph(path string, query url.Values, v interface{}) error {
+	graphURL := s.Cfg.Facebook.GraphURL
+	if graphURL == "" {
+		graphURL = defaultFacebookGraphURL
+	}
+	timeout := time.Duration(s.Cfg.Facebook.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = defaultFacebookTimeout
+	}
+	client := &http.Client{Timeout: timeout}
+
+	resp, err := client.Get(strings.TrimSuffix(graphURL, "/") + "/" + path + "?" + query.Encode())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status from Graph API %s: %s", path, resp.Status)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("error decoding Graph API %s response: %v", path, err)
+	}
+
+	return nil
+}
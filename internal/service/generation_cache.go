@@ -0,0 +1,114 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/windoze95/saltybytes-api/internal/models"
+	"github.com/windoze95/saltybytes-api/internal/openai"
+	"github.com/windoze95/saltybytes-api/internal/repository"
+	"github.com/windoze95/saltybytes-api/internal/util"
+)
+
+// generationCacheKey returns the key a recipe generation is cached under, or an empty string when it isn't cached.
+// Only generations on the platform keys are cached, so users with matching settings share them and nobody's personal
+// key pays for a recipe that someone else gets.
+//
+// The personalization UID is unique to each user, so the settings it versions are keyed instead. Any change to the
+// prompt, the personalization, the language, the occasion, the model, or the system prompt template is a cache miss.
+func (s *RecipeService) generationCacheKey(user *models.User, userPrompt string, language string, occasion *models.Occasion, plan *generationPlan) string {
+	if !s.Cfg.GenerationCache.Enabled || s.Cfg.GenerationCache.TTLHours <= 0 || plan.apiKey != "" {
+		return ""
+	}
+
+	model := plan.model
+	if model == "" {
+		model = openai.RecipeModel(s.Cfg)
+	}
+	occasionKey := ""
+	if occasion != nil {
+		occasionKey = occasion.Key
+	}
+
+	personalization := user.Personalization
+	parts := []string{
+		normalizeCachePrompt(userPrompt),
+		strconv.Itoa(int(personalization.UnitSystem)),
+		normalizeCachePrompt(personalization.Requirements),
+		strings.Join(personalization.DietaryRestrictions, ","),
+		string(personalization.Persona),
+		language,
+		occasionKey,
+		model,
+		s.Cfg.OpenaiPrompts.GenNewRecipeSysFor(subscriptionTier(user)).Version(),
+	}
+	// Recipes from the ingredients on hand are asked for differently than a prompt listing the same ingredients
+	if len(plan.pantry) > 0 {
+		parts = append(parts, "pantry")
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// normalizeCachePrompt lowercases a prompt and collapses its whitespace, so trivially different prompts share a cache entry.
+func normalizeCachePrompt(prompt string) string {
+	return strings.Join(strings.Fields(strings.ToLower(prompt)), " ")
+}
+
+// generateRecipeWithCache generates a recipe with chat, reusing the cached generation under the cache key if there is
+// one. Fresh generations are cached under the key for the next identical request. An empty key always generates.
+func (s *RecipeService) generateRecipeWithCache(recipeManager *openai.RecipeManager, cacheKey string) error {
+	if cacheKey == "" {
+		return recipeManager.GenerateRecipeWithChat()
+	}
+
+	cached, err := s.Repo.GetRecipeGenerationCache(cacheKey)
+	if err == nil && cached.RecipeDef != nil {
+		// The cached def is read fresh from the database, so the recipe gets its own copy
+		recipeManager.RecipeDef = cached.RecipeDef
+		recipeManager.GeneratedWithModel = cached.GeneratedWithModel
+		recipeManager.PromptVersion = cached.PromptVersion
+		recipeManager.NextRecipeHistoryEntry = models.RecipeHistoryEntry{
+			UserPrompt:     recipeManager.UserPrompt,
+			RecipeResponse: cached.RecipeDef,
+			Type:           models.RecipeTypeChat,
+		}
+
+		// The cached recipe is streamed as a single chunk
+		if recipeManager.OnRecipeChunk != nil {
+			if recipeDefJSON, err := util.SerializeToJSONString(cached.RecipeDef); err == nil {
+				recipeManager.OnRecipeChunk(recipeDefJSON)
+			}
+		}
+		return nil
+	}
+	if err != nil {
+		// The cache is only an optimization, generate as usual
+		if _, ok := err.(repository.NotFoundError); !ok {
+			log.Printf("Error reading recipe generation cache: %v", err)
+		}
+	}
+
+	if err := recipeManager.GenerateRecipeWithChat(); err != nil {
+		return err
+	}
+
+	recipeDef := *recipeManager.RecipeDef
+	cached = &models.RecipeGenerationCache{
+		CacheKey:           cacheKey,
+		RecipeDef:          &recipeDef,
+		GeneratedWithModel: recipeManager.GeneratedWithModel,
+		PromptVersion:      recipeManager.PromptVersion,
+		ExpiresAt:          time.Now().Add(s.Cfg.GenerationCache.TTL()),
+	}
+	if err := s.Repo.SaveRecipeGenerationCache(cached); err != nil {
+		log.Printf("Error caching recipe generation: %v", err)
+	}
+
+	return nil
+}
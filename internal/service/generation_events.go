@@ -0,0 +1,198 @@
+package service
+
+import (
+	"context"
+	"sync"
+)
+
+// GenerationEventType is the type for the GenerationEventType enum.
+type GenerationEventType string
+
+// GenerationEventType enum values.
+const (
+	GenerationRecipeStarted  GenerationEventType = "recipe_started"
+	GenerationRecipeChunk    GenerationEventType = "recipe_chunk"
+	GenerationRecipeComplete GenerationEventType = "recipe_complete"
+	GenerationImageStarted   GenerationEventType = "image_generating"
+	GenerationImageComplete  GenerationEventType = "image_complete"
+	GenerationError          GenerationEventType = "error"
+)
+
+// GenerationEvent is a step in the progress of a recipe generation.
+type GenerationEvent struct {
+	Type GenerationEventType
+	Data interface{}
+}
+
+// generationEventBuffer is how many events a slow subscriber can fall behind by before chunks are dropped for it.
+const generationEventBuffer = 64
+
+// generationBroadcast fans the events of one recipe generation out to its subscribers.
+type generationBroadcast struct {
+	// subscribers are true for streams, which cancel the generation once they've all left, and false for watchers
+	subscribers map[chan GenerationEvent]bool
+	// statuses are the events other than chunks so far, replayed to late subscribers
+	statuses []GenerationEvent
+	// cancel cancels the generation, it's nil until the generation has started
+	cancel   context.CancelFunc
+	canceled bool
+}
+
+// generationEvents tracks the recipe generations in flight and who is listening to them.
+// The zero value is ready to use.
+type generationEvents struct {
+	mu         sync.Mutex
+	broadcasts map[uint]*generationBroadcast
+}
+
+// register starts tracking a recipe generation, before anyone can subscribe to it.
+func (g *generationEvents) register(recipeID uint) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.broadcasts == nil {
+		g.broadcasts = make(map[uint]*generationBroadcast)
+	}
+	g.broadcasts[recipeID] = &generationBroadcast{subscribers: make(map[chan GenerationEvent]bool)}
+}
+
+// setCancel sets how a generation is canceled, canceling it right away if the last subscriber already left.
+func (g *generationEvents) setCancel(recipeID uint, cancel context.CancelFunc) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	broadcast, ok := g.broadcasts[recipeID]
+	if !ok {
+		return
+	}
+	broadcast.cancel = cancel
+	if broadcast.canceled {
+		cancel()
+	}
+}
+
+// publish sends an event to the subscribers of a generation.
+// Chunks are dropped for subscribers that have fallen behind, so a slow client can't stall the generation. Other events
+// are never dropped, the chunks queued for a subscriber that has fallen behind are dropped to make room for them.
+func (g *generationEvents) publish(recipeID uint, event GenerationEvent) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	broadcast, ok := g.broadcasts[recipeID]
+	if !ok {
+		return
+	}
+	if event.Type != GenerationRecipeChunk {
+		broadcast.statuses = append(broadcast.statuses, event)
+	}
+	for events := range broadcast.subscribers {
+		select {
+		case events <- event:
+		default:
+			if event.Type != GenerationRecipeChunk {
+				dropQueuedChunks(events)
+				events <- event
+			}
+		}
+	}
+}
+
+// dropQueuedChunks removes the chunks queued on a subscriber's stream, keeping the other events in order.
+// The stream has room for every status of a generation, so there's room for the next once the chunks are gone.
+// It must be called with the lock held, so nothing else is sent on the stream meanwhile.
+func dropQueuedChunks(events chan GenerationEvent) {
+	var kept []GenerationEvent
+drain:
+	for queued := len(events); queued > 0; queued-- {
+		select {
+		case event := <-events:
+			if event.Type != GenerationRecipeChunk {
+				kept = append(kept, event)
+			}
+		default:
+			// The subscriber read the rest meanwhile
+			break drain
+		}
+	}
+	for _, event := range kept {
+		events <- event
+	}
+}
+
+// finish stops tracking a generation and closes the streams of its subscribers.
+func (g *generationEvents) finish(recipeID uint) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	broadcast, ok := g.broadcasts[recipeID]
+	if !ok {
+		return
+	}
+	for events := range broadcast.subscribers {
+		close(events)
+	}
+	delete(g.broadcasts, recipeID)
+}
+
+// subscribe listens to a generation in flight, starting with a replay of its status so far.
+// It returns false if the recipe isn't being generated. Unsubscribing the last subscriber cancels the generation.
+func (g *generationEvents) subscribe(recipeID uint) (<-chan GenerationEvent, func(), bool) {
+	return g.listen(recipeID, true)
+}
+
+// watch listens to a generation in flight like subscribe, but leaving never cancels the generation.
+func (g *generationEvents) watch(recipeID uint) (<-chan GenerationEvent, func(), bool) {
+	return g.listen(recipeID, false)
+}
+
+// listen adds a subscriber to a generation in flight, one that cancels the generation if it's the last stream to leave.
+func (g *generationEvents) listen(recipeID uint, cancels bool) (<-chan GenerationEvent, func(), bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	broadcast, ok := g.broadcasts[recipeID]
+	if !ok {
+		return nil, nil, false
+	}
+
+	events := make(chan GenerationEvent, generationEventBuffer+len(broadcast.statuses))
+	for _, event := range broadcast.statuses {
+		events <- event
+	}
+	broadcast.subscribers[events] = cancels
+
+	unsubscribe := func() {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+
+		// The generation finished and the stream was already closed
+		if current, ok := g.broadcasts[recipeID]; !ok || current != broadcast {
+			return
+		}
+		if _, ok := broadcast.subscribers[events]; !ok {
+			return
+		}
+		delete(broadcast.subscribers, events)
+		close(events)
+
+		// Nobody is streaming anymore, the client went away
+		if cancels && !broadcast.streaming() {
+			broadcast.canceled = true
+			if broadcast.cancel != nil {
+				broadcast.cancel()
+			}
+		}
+	}
+
+	return events, unsubscribe, true
+}
+
+// streaming checks if any subscriber that cancels the generation is still listening.
+func (b *generationBroadcast) streaming() bool {
+	for _, cancels := range b.subscribers {
+		if cancels {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+package service
+
+import "testing"
+
+func TestPublishKeepsStatusesForFullSubscribers(t *testing.T) {
+	var g generationEvents
+	g.register(1)
+	g.publish(1, GenerationEvent{Type: GenerationRecipeStarted})
+
+	streamed, _, _ := g.subscribe(1)
+	watched, _, _ := g.watch(1)
+
+	// Nobody reads, so the streams fill up with chunks well past their buffer
+	for i := 0; i < generationEventBuffer*3; i++ {
+		g.publish(1, GenerationEvent{Type: GenerationRecipeChunk, Data: i})
+	}
+	g.publish(1, GenerationEvent{Type: GenerationRecipeComplete})
+	g.publish(1, GenerationEvent{Type: GenerationImageStarted})
+	g.publish(1, GenerationEvent{Type: GenerationError})
+	g.finish(1)
+
+	want := []GenerationEventType{GenerationRecipeStarted, GenerationRecipeComplete, GenerationImageStarted, GenerationError}
+	for name, events := range map[string]<-chan GenerationEvent{"stream": streamed, "watcher": watched} {
+		var statuses []GenerationEventType
+		for event := range events {
+			if event.Type != GenerationRecipeChunk {
+				statuses = append(statuses, event.Type)
+			}
+		}
+		if len(statuses) != len(want) {
+			t.Fatalf("%s got statuses %v, want %v", name, statuses, want)
+		}
+		for i := range want {
+			if statuses[i] != want[i] {
+				t.Fatalf("%s got statuses %v, want %v", name, statuses, want)
+			}
+		}
+	}
+}
+
+func TestPublishDropsChunksForFullSubscribers(t *testing.T) {
+	var g generationEvents
+	g.register(1)
+	events, _, _ := g.subscribe(1)
+
+	for i := 0; i < generationEventBuffer*2; i++ {
+		g.publish(1, GenerationEvent{Type: GenerationRecipeChunk, Data: i})
+	}
+	g.finish(1)
+
+	// The chunks that fit are kept in order, the rest are dropped without blocking the generation
+	next := 0
+	for event := range events {
+		if event.Data != next {
+			t.Fatalf("got chunk %v, want %d", event.Data, next)
+		}
+		next++
+	}
+	if next != generationEventBuffer {
+		t.Fatalf("got %d chunks, want %d", next, generationEventBuffer)
+	}
+}
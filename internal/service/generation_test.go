@@ -0,0 +1,906 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+	goopenai "github.com/sashabaranov/go-openai"
+	"github.com/windoze95/saltybytes-api/internal/config"
+	"github.com/windoze95/saltybytes-api/internal/models"
+	"github.com/windoze95/saltybytes-api/internal/openai"
+	"github.com/windoze95/saltybytes-api/internal/openai/openaitest"
+	"github.com/windoze95/saltybytes-api/internal/repository"
+	"github.com/windoze95/saltybytes-api/internal/service"
+	"github.com/windoze95/saltybytes-api/internal/service/servicetest"
+	"github.com/windoze95/saltybytes-api/internal/util"
+)
+
+// generationRecorder records what a recipe generation did to its recipe through a mock repository.
+type generationRecorder struct {
+	mu       sync.Mutex
+	saved    *models.Recipe
+	statuses chan models.GenerationStatus
+	deleted  chan uint
+	// usages are recorded last, once the generation is done
+	usages chan models.TokenUsage
+}
+
+// newGenerationService returns a service that generates recipes with the mock client, without images, and the recorder
+// of what the generation did. The recipes are created with ID 1.
+func newGenerationService(client *openaitest.MockClient) (*service.RecipeService, *generationRecorder) {
+	recorder := &generationRecorder{
+		statuses: make(chan models.GenerationStatus, 10),
+		deleted:  make(chan uint, 1),
+		usages:   make(chan models.TokenUsage, 10),
+	}
+	repo := &servicetest.MockRecipeRepository{
+		CreateRecipeFunc: func(recipe *models.Recipe) error {
+			recipe.ID = 1
+			return nil
+		},
+		UpdateRecipeGenerationStatusFunc: func(recipeID uint, status models.GenerationStatus) error {
+			recorder.statuses <- status
+			return nil
+		},
+		UpdateRecipeDefFunc: func(recipe *models.Recipe, newHistoryEntry models.RecipeHistoryEntry) error {
+			recorder.mu.Lock()
+			defer recorder.mu.Unlock()
+			saved := *recipe
+			recorder.saved = &saved
+			return nil
+		},
+		ReplaceSubRecipesFunc: func(parentRecipeID uint, subRecipes []*models.Recipe) error {
+			return nil
+		},
+		FindTagByNameFunc: func(tagName string) (*models.Tag, error) {
+			return nil, gorm.ErrRecordNotFound
+		},
+		CreateTagFunc: func(tag *models.Tag) error {
+			return nil
+		},
+		UpdateRecipeTagsAssociationFunc: func(recipeID uint, newTags []models.Tag) error {
+			return nil
+		},
+		CreateTokenUsageFunc: func(usage *models.TokenUsage) error {
+			recorder.usages <- *usage
+			return nil
+		},
+		// A failed recipe is looked up to be deleted, it's reported gone so its image isn't deleted from S3
+		GetRecipeByIDFunc: func(recipeID uint) (*models.Recipe, error) {
+			recorder.deleted <- recipeID
+			return nil, repository.NotFoundError{}
+		},
+	}
+
+	cfg := &config.Config{}
+	cfg.Images.Disabled = true
+	s := service.NewRecipeService(cfg, repo, &servicetest.MockUserRepository{})
+	s.RecipeGenerator = client
+	s.ImageGenerator = client
+	return s, recorder
+}
+
+// waitForDeletion waits until the generation deletes its recipe.
+func (r *generationRecorder) waitForDeletion(t *testing.T) {
+	t.Helper()
+	select {
+	case <-r.deleted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("recipe wasn't deleted")
+	}
+}
+
+// waitForUsage waits until the generation is done and records what it used.
+func (r *generationRecorder) waitForUsage(t *testing.T) {
+	t.Helper()
+	select {
+	case <-r.usages:
+	case <-time.After(5 * time.Second):
+		t.Fatal("generation didn't record its usage")
+	}
+}
+
+// waitForStatus waits until the generation ends up complete or failed, and returns which.
+func (r *generationRecorder) waitForStatus(t *testing.T) models.GenerationStatus {
+	t.Helper()
+	for {
+		select {
+		case status := <-r.statuses:
+			if status == models.GenerationComplete || status == models.GenerationFailed {
+				return status
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("generation didn't finish")
+		}
+	}
+}
+
+// generationUser returns a user who can generate recipes.
+func generationUser() *models.User {
+	user := testUser(1)
+	user.Personalization = &models.Personalization{Model: gorm.Model{ID: 1}}
+	user.Subscription = &models.Subscription{SubscriptionTier: models.Free}
+	return user
+}
+
+// recipeCompletion responds to a chat completion with a complete recipe.
+func recipeCompletion(ctx context.Context, request goopenai.ChatCompletionRequest) (goopenai.ChatCompletionResponse, error) {
+	return openaitest.FunctionCallResponse(request.Model, "create_recipe", openai.FunctionCallArgument{RecipeDef: models.RecipeDef{
+		Title:        "Tomato Soup",
+		Ingredients:  models.Ingredients{{Name: "Tomatoes", Unit: "g", Amount: 500}},
+		Instructions: []string{"Simmer the tomatoes", "Blend"},
+		CookTime:     30,
+		ImagePrompt:  "A bowl of tomato soup",
+		Hashtags:     []string{"soup"},
+	}})
+}
+
+func TestGenerateRecipeWithChat(t *testing.T) {
+	client := &openaitest.MockClient{CreateChatCompletionFunc: recipeCompletion}
+	s, recorder := newGenerationService(client)
+
+	if _, err := s.InitGenerateRecipeWithChat(context.Background(), generationUser(), "tomato soup", "en", "", true); err != nil {
+		t.Fatalf("InitGenerateRecipeWithChat: %v", err)
+	}
+
+	if status := recorder.waitForStatus(t); status != models.GenerationComplete {
+		t.Fatalf("status = %s, want %s", status, models.GenerationComplete)
+	}
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	if recorder.saved == nil || recorder.saved.Title != "Tomato Soup" {
+		t.Fatalf("saved recipe %+v, want the generated one", recorder.saved)
+	}
+	if len(recorder.deleted) > 0 {
+		t.Fatal("generated recipe was deleted")
+	}
+	if got := len(client.ChatCompletionRequests()); got != 1 {
+		t.Fatalf("got %d OpenAI requests, want 1", got)
+	}
+}
+
+func TestGenerateRecipeWithChatNewUser(t *testing.T) {
+	userRepo := &servicetest.MockUserRepository{
+		CreateUserFunc: func(user *models.User) (*models.User, error) {
+			// Like gorm, which saves the user's associations with it
+			user.ID = 1
+			user.Personalization.ID = 1
+			user.Personalization.UserID = 1
+			return user, nil
+		},
+	}
+	user, err := service.NewUserService(&config.Config{}, userRepo, nil).CreateUser("chef42", "Chef", "chef@example.com", "password123")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if user.Personalization == nil || user.Personalization.UID == uuid.Nil {
+		t.Fatalf("new user's personalization %+v, want one with a UID", user.Personalization)
+	}
+
+	client := &openaitest.MockClient{CreateChatCompletionFunc: recipeCompletion}
+	s, recorder := newGenerationService(client)
+	if _, err := s.InitGenerateRecipeWithChat(context.Background(), user, "tomato soup", "en", "", true); err != nil {
+		t.Fatalf("InitGenerateRecipeWithChat: %v", err)
+	}
+	if status := recorder.waitForStatus(t); status != models.GenerationComplete {
+		t.Fatalf("status = %s, want %s", status, models.GenerationComplete)
+	}
+}
+
+func TestGenerateRecipeWithChatWithoutPersonalization(t *testing.T) {
+	// The repository and client have no funcs, so nothing is generated
+	s := service.NewRecipeService(&config.Config{}, &servicetest.MockRecipeRepository{}, &servicetest.MockUserRepository{})
+	s.RecipeGenerator = &openaitest.MockClient{}
+
+	user := generationUser()
+	user.Personalization = nil
+	if _, err := s.InitGenerateRecipeWithChat(context.Background(), user, "tomato soup", "en", "", true); err == nil {
+		t.Fatal("InitGenerateRecipeWithChat succeeded, want an error for the missing personalization")
+	}
+}
+
+func TestGenerateRecipeWithChatOpenAIError(t *testing.T) {
+	client := &openaitest.MockClient{
+		CreateChatCompletionFunc: func(ctx context.Context, request goopenai.ChatCompletionRequest) (goopenai.ChatCompletionResponse, error) {
+			return goopenai.ChatCompletionResponse{}, &goopenai.APIError{HTTPStatusCode: http.StatusBadRequest, Message: "bad request"}
+		},
+	}
+	s, recorder := newGenerationService(client)
+
+	if _, err := s.InitGenerateRecipeWithChat(context.Background(), generationUser(), "tomato soup", "en", "", true); err != nil {
+		t.Fatalf("InitGenerateRecipeWithChat: %v", err)
+	}
+
+	if status := recorder.waitForStatus(t); status != models.GenerationFailed {
+		t.Fatalf("status = %s, want %s", status, models.GenerationFailed)
+	}
+	recorder.waitForDeletion(t)
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	if recorder.saved != nil {
+		t.Fatal("failed recipe was saved")
+	}
+	// Bad requests aren't retried
+	if got := len(client.ChatCompletionRequests()); got != 1 {
+		t.Fatalf("got %d OpenAI requests, want 1", got)
+	}
+}
+
+func TestGenerateRecipeFromIngredientsReconcilesPantry(t *testing.T) {
+	client := &openaitest.MockClient{
+		CreateChatCompletionFunc: func(ctx context.Context, request goopenai.ChatCompletionRequest) (goopenai.ChatCompletionResponse, error) {
+			return openaitest.FunctionCallResponse(request.Model, "create_recipe", openai.FunctionCallArgument{RecipeDef: models.RecipeDef{
+				Title:                 "Tomato Soup",
+				Ingredients:           models.Ingredients{{Name: "Tomatoes", Unit: "g", Amount: 500}, {Name: "Fresh basil", Unit: "g", Amount: 10}},
+				Instructions:          []string{"Simmer the tomatoes", "Blend with the basil"},
+				ImagePrompt:           "A bowl of tomato soup",
+				UsedIngredients:       []string{"tomatoes", "saffron"},
+				AdditionalIngredients: []string{"Basil", "Cream", "cream"},
+			}})
+		},
+	}
+	s, recorder := newGenerationService(client)
+
+	if _, err := s.InitGenerateRecipeFromIngredients(context.Background(), generationUser(), []string{"Tomatoes", "basil", "Garlic"}, "", "en", "", true); err != nil {
+		t.Fatalf("InitGenerateRecipeFromIngredients: %v", err)
+	}
+	if status := recorder.waitForStatus(t); status != models.GenerationComplete {
+		t.Fatalf("status = %s, want %s", status, models.GenerationComplete)
+	}
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	// Saffron isn't on hand, and the basil the recipe names is, spelled as the user gave it
+	if got, want := strings.Join(recorder.saved.UsedIngredients, ","), "Tomatoes,basil"; got != want {
+		t.Fatalf("used ingredients = %q, want %q", got, want)
+	}
+	// Basil is on hand after all, and cream is only needed once
+	if got, want := strings.Join(recorder.saved.AdditionalIngredients, ","), "Cream"; got != want {
+		t.Fatalf("additional ingredients = %q, want %q", got, want)
+	}
+}
+
+func TestGenerateRecipeWithChatPromptTemplateThatCantBeFilled(t *testing.T) {
+	client := &openaitest.MockClient{CreateChatCompletionFunc: recipeCompletion}
+	s, recorder := newGenerationService(client)
+	s.Cfg.OpenaiPrompts.GenNewRecipeSys = "You are CulinaryAI. Use {{.Units}}."
+
+	if _, err := s.PreviewRecipePrompt(generationUser(), "tomato soup", "en", ""); err == nil {
+		t.Fatal("PreviewRecipePrompt succeeded, want an error")
+	}
+
+	if _, err := s.InitGenerateRecipeWithChat(context.Background(), generationUser(), "tomato soup", "en", "", true); err != nil {
+		t.Fatalf("InitGenerateRecipeWithChat: %v", err)
+	}
+	if status := recorder.waitForStatus(t); status != models.GenerationFailed {
+		t.Fatalf("status = %s, want %s", status, models.GenerationFailed)
+	}
+	recorder.waitForDeletion(t)
+	// The prompt is never sent with the template's actions left in
+	if got := len(client.ChatCompletionRequests()); got != 0 {
+		t.Fatalf("got %d OpenAI requests, want none", got)
+	}
+}
+
+func TestGenerateRecipeWithChatRefundsFailedGenerations(t *testing.T) {
+	tests := []struct {
+		name       string
+		completion func(ctx context.Context, request goopenai.ChatCompletionRequest) (goopenai.ChatCompletionResponse, error)
+		wantStatus models.GenerationStatus
+		wantRefund bool
+	}{
+		{"generated", recipeCompletion, models.GenerationComplete, false},
+		{"failed", func(ctx context.Context, request goopenai.ChatCompletionRequest) (goopenai.ChatCompletionResponse, error) {
+			return goopenai.ChatCompletionResponse{}, &goopenai.APIError{HTTPStatusCode: http.StatusBadRequest, Message: "bad request"}
+		}, models.GenerationFailed, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, recorder := newGenerationService(&openaitest.MockClient{CreateChatCompletionFunc: tt.completion})
+			refunded := make(chan struct{}, 2)
+			ctx := service.WithGenerationRefund(context.Background(), func() { refunded <- struct{}{} })
+
+			// The request has been responded to by the time the generation finishes in the background
+			if _, err := s.InitGenerateRecipeWithChat(ctx, generationUser(), "tomato soup", "en", "", true); err != nil {
+				t.Fatalf("InitGenerateRecipeWithChat: %v", err)
+			}
+
+			if status := recorder.waitForStatus(t); status != tt.wantStatus {
+				t.Fatalf("status = %s, want %s", status, tt.wantStatus)
+			}
+			// The failed recipe is deleted after it's refunded, the generated one's usage is recorded once it's done
+			if tt.wantRefund {
+				recorder.waitForDeletion(t)
+			} else {
+				recorder.waitForUsage(t)
+			}
+			if got := len(refunded); (got == 1) != tt.wantRefund || got > 1 {
+				t.Fatalf("refunded %d times, want refund %v", got, tt.wantRefund)
+			}
+		})
+	}
+}
+
+func TestGenerateRecipeWithChatTimeout(t *testing.T) {
+	canceled := make(chan error, 1)
+	client := &openaitest.MockClient{
+		CreateChatCompletionFunc: func(ctx context.Context, request goopenai.ChatCompletionRequest) (goopenai.ChatCompletionResponse, error) {
+			<-ctx.Done()
+			canceled <- ctx.Err()
+			return goopenai.ChatCompletionResponse{}, ctx.Err()
+		},
+	}
+	s, recorder := newGenerationService(client)
+	s.GenerationTimeout = 50 * time.Millisecond
+
+	if _, err := s.InitGenerateRecipeWithChat(context.Background(), generationUser(), "tomato soup", "en", "", true); err != nil {
+		t.Fatalf("InitGenerateRecipeWithChat: %v", err)
+	}
+
+	if status := recorder.waitForStatus(t); status != models.GenerationFailed {
+		t.Fatalf("status = %s, want %s", status, models.GenerationFailed)
+	}
+	if err := <-canceled; !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("OpenAI request ended with %v, want %v", err, context.DeadlineExceeded)
+	}
+	recorder.waitForDeletion(t)
+}
+
+func TestGenerateRecipeWithChatRecipePanic(t *testing.T) {
+	client := &openaitest.MockClient{
+		CreateChatCompletionFunc: func(ctx context.Context, request goopenai.ChatCompletionRequest) (goopenai.ChatCompletionResponse, error) {
+			var recipe *models.Recipe
+			_ = recipe.Title // nil dereference
+			return goopenai.ChatCompletionResponse{}, nil
+		},
+	}
+	s, recorder := newGenerationService(client)
+
+	if _, err := s.InitGenerateRecipeWithChat(context.Background(), generationUser(), "tomato soup", "en", "", true); err != nil {
+		t.Fatalf("InitGenerateRecipeWithChat: %v", err)
+	}
+
+	// The panic fails the recipe like any other error, instead of crashing the test binary
+	if status := recorder.waitForStatus(t); status != models.GenerationFailed {
+		t.Fatalf("status = %s, want %s", status, models.GenerationFailed)
+	}
+	recorder.waitForDeletion(t)
+}
+
+func TestGenerateRecipeWithChatImagePanic(t *testing.T) {
+	client := &openaitest.MockClient{
+		CreateChatCompletionFunc: recipeCompletion,
+		CreateImageFunc: func(ctx context.Context, request goopenai.ImageRequest) (goopenai.ImageResponse, error) {
+			panic("image generator panicked")
+		},
+	}
+	s, recorder := newGenerationService(client)
+	s.Cfg.Images.Disabled = false
+
+	if _, err := s.InitGenerateRecipeWithChat(context.Background(), generationUser(), "tomato soup", "en", "", true); err != nil {
+		t.Fatalf("InitGenerateRecipeWithChat: %v", err)
+	}
+
+	// The recipe was saved before the image panicked, so it's kept without its image
+	if status := recorder.waitForStatus(t); status != models.GenerationComplete {
+		t.Fatalf("status = %s, want %s", status, models.GenerationComplete)
+	}
+	recorder.waitForUsage(t)
+	if len(recorder.deleted) > 0 {
+		t.Fatal("saved recipe was deleted")
+	}
+}
+
+func TestGenerateRecipeWithChatImageError(t *testing.T) {
+	client := &openaitest.MockClient{
+		CreateChatCompletionFunc: recipeCompletion,
+		CreateImageFunc: func(ctx context.Context, request goopenai.ImageRequest) (goopenai.ImageResponse, error) {
+			return goopenai.ImageResponse{}, &goopenai.APIError{HTTPStatusCode: http.StatusBadRequest, Message: "content policy"}
+		},
+	}
+	s, recorder := newGenerationService(client)
+	s.Cfg.Images.Disabled = false
+
+	if _, err := s.InitGenerateRecipeWithChat(context.Background(), generationUser(), "tomato soup", "en", "", true); err != nil {
+		t.Fatalf("InitGenerateRecipeWithChat: %v", err)
+	}
+
+	// The recipe step succeeded, a failed image only leaves the recipe without one
+	if status := recorder.waitForStatus(t); status != models.GenerationComplete {
+		t.Fatalf("status = %s, want %s", status, models.GenerationComplete)
+	}
+	recorder.waitForUsage(t)
+	if len(recorder.deleted) > 0 {
+		t.Fatal("saved recipe was deleted")
+	}
+	if got := len(client.ImageRequests()); got != 1 {
+		t.Fatalf("got %d image requests, want 1", got)
+	}
+}
+
+func TestGenerateRecipeWithChatImageTimeout(t *testing.T) {
+	canceled := make(chan error, 1)
+	client := &openaitest.MockClient{
+		CreateChatCompletionFunc: recipeCompletion,
+		CreateImageFunc: func(ctx context.Context, request goopenai.ImageRequest) (goopenai.ImageResponse, error) {
+			<-ctx.Done()
+			canceled <- ctx.Err()
+			return goopenai.ImageResponse{}, ctx.Err()
+		},
+	}
+	s, recorder := newGenerationService(client)
+	s.Cfg.Images.Disabled = false
+	s.GenerationTimeout = 50 * time.Millisecond
+
+	if _, err := s.InitGenerateRecipeWithChat(context.Background(), generationUser(), "tomato soup", "en", "", true); err != nil {
+		t.Fatalf("InitGenerateRecipeWithChat: %v", err)
+	}
+
+	if status := recorder.waitForStatus(t); status != models.GenerationComplete {
+		t.Fatalf("status = %s, want %s", status, models.GenerationComplete)
+	}
+	// The one context of the generation cancels the image request once it times out
+	select {
+	case err := <-canceled:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("image request ended with %v, want %v", err, context.DeadlineExceeded)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("image request wasn't canceled")
+	}
+	recorder.waitForUsage(t)
+	if len(recorder.deleted) > 0 {
+		t.Fatal("saved recipe was deleted")
+	}
+}
+
+func TestGenerateRecipeWithChatRecordsModelAndPromptVersion(t *testing.T) {
+	client := &openaitest.MockClient{
+		CreateChatCompletionFunc: func(ctx context.Context, request goopenai.ChatCompletionRequest) (goopenai.ChatCompletionResponse, error) {
+			resp, err := recipeCompletion(ctx, request)
+			// The model that actually answered, which can be a dated snapshot of the one requested
+			resp.Model = "gpt-4o-2024-08-06"
+			return resp, err
+		},
+	}
+	s, recorder := newGenerationService(client)
+	s.Cfg.OpenaiPrompts.GenNewRecipeSys = "You are a chef. Use the {{.UnitSystem}} system."
+
+	if _, err := s.InitGenerateRecipeWithChat(context.Background(), generationUser(), "tomato soup", "en", "", true); err != nil {
+		t.Fatalf("InitGenerateRecipeWithChat: %v", err)
+	}
+	if status := recorder.waitForStatus(t); status != models.GenerationComplete {
+		t.Fatalf("status = %s, want %s", status, models.GenerationComplete)
+	}
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	if recorder.saved == nil {
+		t.Fatal("recipe wasn't saved")
+	}
+	if recorder.saved.GeneratedWithModel != "gpt-4o-2024-08-06" {
+		t.Fatalf("saved GeneratedWithModel = %q, want %q", recorder.saved.GeneratedWithModel, "gpt-4o-2024-08-06")
+	}
+	if want := s.Cfg.OpenaiPrompts.GenNewRecipeSys.Version(); recorder.saved.PromptVersion != want {
+		t.Fatalf("saved PromptVersion = %q, want %q", recorder.saved.PromptVersion, want)
+	}
+}
+
+func TestGenerateRecipeWithChatImagesDisabled(t *testing.T) {
+	client := &openaitest.MockClient{CreateChatCompletionFunc: recipeCompletion}
+	s, recorder := newGenerationService(client)
+	s.Cfg.Images.PlaceholderURL = "https://example.com/placeholder.jpg"
+	placeholders := make(chan string, 1)
+	s.Repo.(*servicetest.MockRecipeRepository).UpdateRecipeImageURLFunc = func(recipeID uint, imageURL string, imageKey string) error {
+		placeholders <- imageURL
+		return nil
+	}
+
+	recipeResponse, err := s.InitGenerateRecipeWithChat(context.Background(), generationUser(), "tomato soup", "en", "", true)
+	if err != nil {
+		t.Fatalf("InitGenerateRecipeWithChat: %v", err)
+	}
+	if !recipeResponse.ImagesDisabled {
+		t.Fatal("recipe response doesn't say images are disabled")
+	}
+
+	if status := recorder.waitForStatus(t); status != models.GenerationComplete {
+		t.Fatalf("status = %s, want %s", status, models.GenerationComplete)
+	}
+	recorder.waitForUsage(t)
+	select {
+	case imageURL := <-placeholders:
+		if imageURL != s.Cfg.Images.PlaceholderURL {
+			t.Fatalf("image URL = %q, want the placeholder", imageURL)
+		}
+	default:
+		t.Fatal("recipe didn't get the placeholder image")
+	}
+	if got := len(client.ImageRequests()); got != 0 {
+		t.Fatalf("got %d image requests, want none", got)
+	}
+}
+
+func TestGenerateRecipeWithChatSubRecipes(t *testing.T) {
+	client := &openaitest.MockClient{
+		CreateChatCompletionFunc: func(ctx context.Context, request goopenai.ChatCompletionRequest) (goopenai.ChatCompletionResponse, error) {
+			return openaitest.FunctionCallResponse(request.Model, "create_recipe", openai.FunctionCallArgument{RecipeDef: models.RecipeDef{
+				Title:        "Burger",
+				Ingredients:  models.Ingredients{{Name: "Ground beef", Unit: "g", Amount: 500}},
+				Instructions: []string{"Grill the patties", "Assemble"},
+				ImagePrompt:  "A burger on a bun",
+				Difficulty:   "extreme",
+				SubRecipes: []models.RecipeDef{
+					{Title: "Buns", Ingredients: models.Ingredients{{Name: "Flour", Unit: "g", Amount: 300}}, Allergens: []string{"Gluten"}, Difficulty: " Medium"},
+					{Title: "Sauce", Ingredients: models.Ingredients{{Name: "Mayonnaise", Unit: "tbsp", Amount: 3}}, Difficulty: "trivial"},
+					{Title: "Garnish"}, // No ingredients, not a recipe of its own
+				},
+			}})
+		},
+	}
+	s, recorder := newGenerationService(client)
+	s.Cfg.Images.PlaceholderURL = "https://example.com/placeholder.jpg"
+	s.Repo.(*servicetest.MockRecipeRepository).CreateRecipeFunc = func(recipe *models.Recipe) error {
+		// Like gorm, which sets the foreign key of the creator when the recipe is created
+		recipe.ID = 1
+		recipe.CreatedByID = recipe.CreatedBy.ID
+		return nil
+	}
+	s.Repo.(*servicetest.MockRecipeRepository).UpdateRecipeImageURLFunc = func(recipeID uint, imageURL string, imageKey string) error {
+		return nil
+	}
+	saved := make(chan []*models.Recipe, 1)
+	s.Repo.(*servicetest.MockRecipeRepository).ReplaceSubRecipesFunc = func(parentRecipeID uint, subRecipes []*models.Recipe) error {
+		if parentRecipeID != 1 {
+			t.Errorf("sub-recipes saved for recipe %d, want 1", parentRecipeID)
+		}
+		saved <- subRecipes
+		return nil
+	}
+
+	if _, err := s.InitGenerateRecipeWithChat(context.Background(), generationUser(), "burger", "en", "", true); err != nil {
+		t.Fatalf("InitGenerateRecipeWithChat: %v", err)
+	}
+	if status := recorder.waitForStatus(t); status != models.GenerationComplete {
+		t.Fatalf("status = %s, want %s", status, models.GenerationComplete)
+	}
+
+	var subRecipes []*models.Recipe
+	select {
+	case subRecipes = <-saved:
+	default:
+		t.Fatal("sub-recipes weren't saved")
+	}
+	if len(subRecipes) != 2 || subRecipes[0].Title != "Buns" || subRecipes[1].Title != "Sauce" {
+		t.Fatalf("saved %d sub-recipes, want the buns and the sauce", len(subRecipes))
+	}
+	buns := subRecipes[0]
+	if len(buns.Ingredients) != 1 || buns.Ingredients[0].Name != "Flour" || buns.Ingredients[0].Amount != 300 {
+		t.Fatalf("buns ingredients %+v, want the generated ones", buns.Ingredients)
+	}
+	// Each component is a recipe of its own, owned by the user, with its own history
+	if buns.CreatedByID != 1 || buns.ImageURL != s.Cfg.Images.PlaceholderURL || len(buns.SubRecipes) != 0 {
+		t.Fatalf("buns %+v, want the user's recipe with the placeholder image", buns)
+	}
+	if buns.History == nil || len(buns.History.Entries) != 1 || buns.History.Entries[0].UserPrompt != "Part of Burger" {
+		t.Fatalf("buns history %+v, want an entry saying it's part of the burger", buns.History)
+	}
+	if entry := buns.History.Entries[0].RecipeResponse; entry == nil || entry.Title != "Buns" {
+		t.Fatalf("buns history entry recipe %+v, want the buns", entry)
+	}
+	// Difficulties outside the enum are left out, so they can't break the difficulty filter
+	if buns.Difficulty != models.DifficultyMedium || subRecipes[1].Difficulty != "" {
+		t.Fatalf("sub-recipe difficulties %q and %q, want %q and none", buns.Difficulty, subRecipes[1].Difficulty, models.DifficultyMedium)
+	}
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	if recorder.saved == nil || recorder.saved.Title != "Burger" {
+		t.Fatalf("saved recipe %+v, want the burger", recorder.saved)
+	}
+	if recorder.saved.Difficulty != "" {
+		t.Fatalf("saved difficulty %q, want none", recorder.saved.Difficulty)
+	}
+}
+
+func TestGenerateRecipeWithChatPersonalKeySpendCap(t *testing.T) {
+	t.Setenv("TEST_OPENAI_KEY_ENCRYPTION_KEY", "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+	t.Setenv("TEST_OPENAI_KEY_ENCRYPTION_KEY_ID", "1")
+
+	cfg := &config.Config{}
+	cfg.OptionalEnv.OpenaiKeyEncryptionKey = "TEST_OPENAI_KEY_ENCRYPTION_KEY"
+	cfg.OptionalEnv.OpenaiKeyEncryptionKeyID = "TEST_OPENAI_KEY_ENCRYPTION_KEY_ID"
+	keyring, err := util.OpenAIKeyringFromConfig(cfg)
+	if err != nil {
+		t.Fatalf("OpenAIKeyringFromConfig: %v", err)
+	}
+	encryptedKey, err := util.EncryptOpenAIKey(keyring, "sk-personal")
+	if err != nil {
+		t.Fatalf("EncryptOpenAIKey: %v", err)
+	}
+
+	defaultModel := openai.RecipeModel(cfg)
+	defaultCost := openai.EstimateGenerationCostMicros(cfg, defaultModel)
+	budgetCost := openai.EstimateGenerationCostMicros(cfg, openai.BudgetRecipeModel)
+	if budgetCost >= defaultCost {
+		t.Fatalf("budget model costs %d, not less than the default model's %d", budgetCost, defaultCost)
+	}
+
+	const capCents = 1000 // $10
+	capMicros := int64(capCents) * 10000
+	tests := []struct {
+		name         string
+		remaining    int64 // Micro-dollars left of the cap this month
+		wantModel    string
+		wantRejected bool
+	}{
+		{"under the cap", capMicros, defaultModel, false},
+		{"near the cap", (defaultCost + budgetCost) / 2, openai.BudgetRecipeModel, false},
+		{"over the cap", budgetCost - 1, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &openaitest.MockClient{CreateChatCompletionFunc: recipeCompletion}
+			s, recorder := newGenerationService(client)
+			s.Cfg.OptionalEnv = cfg.OptionalEnv
+			s.UserRepo.(*servicetest.MockUserRepository).AddPersonalKeySpendFunc = func(userID uint, month time.Time, spendMicros int64) error {
+				return nil
+			}
+
+			now := time.Now().UTC()
+			month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+			user := generationUser()
+			user.Settings = &models.UserSettings{
+				UsePersonalAPIKey:      true,
+				EncryptedOpenAIKey:     encryptedKey,
+				MonthlySpendCapCents:   capCents,
+				PersonalKeySpendMicros: capMicros - tt.remaining,
+				PersonalKeySpendMonth:  &month,
+			}
+
+			_, err := s.InitGenerateRecipeWithChat(context.Background(), user, "tomato soup", "en", "", true)
+			if tt.wantRejected {
+				if _, ok := err.(service.TooManyRequestsError); !ok {
+					t.Fatalf("InitGenerateRecipeWithChat error = %v, want service.TooManyRequestsError", err)
+				}
+				if got := len(client.ChatCompletionRequests()); got != 0 {
+					t.Fatalf("got %d OpenAI requests over the cap, want none", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("InitGenerateRecipeWithChat: %v", err)
+			}
+
+			if status := recorder.waitForStatus(t); status != models.GenerationComplete {
+				t.Fatalf("status = %s, want %s", status, models.GenerationComplete)
+			}
+			recorder.waitForUsage(t)
+			requests := client.ChatCompletionRequests()
+			if len(requests) != 1 {
+				t.Fatalf("got %d OpenAI requests, want 1", len(requests))
+			}
+			if requests[0].Model != tt.wantModel {
+				t.Fatalf("generated with %q, want %q", requests[0].Model, tt.wantModel)
+			}
+		})
+	}
+}
+
+func TestGenerateRecipeWithChatOccasion(t *testing.T) {
+	client := &openaitest.MockClient{CreateChatCompletionFunc: recipeCompletion}
+	s, recorder := newGenerationService(client)
+	var associated []string
+	s.Repo.(*servicetest.MockRecipeRepository).UpdateRecipeTagsAssociationFunc = func(recipeID uint, newTags []models.Tag) error {
+		for _, tag := range newTags {
+			associated = append(associated, tag.Hashtag)
+		}
+		return nil
+	}
+
+	recipeResponse, err := s.InitGenerateRecipeWithChat(context.Background(), generationUser(), "turkey", "en", "Thanksgiving", true)
+	if err != nil {
+		t.Fatalf("InitGenerateRecipeWithChat: %v", err)
+	}
+	if recipeResponse.Occasion != "thanksgiving" {
+		t.Fatalf("occasion = %q, want thanksgiving", recipeResponse.Occasion)
+	}
+
+	if status := recorder.waitForStatus(t); status != models.GenerationComplete {
+		t.Fatalf("status = %s, want %s", status, models.GenerationComplete)
+	}
+	requests := client.ChatCompletionRequests()
+	if len(requests) != 1 {
+		t.Fatalf("got %d OpenAI requests, want 1", len(requests))
+	}
+	occasion, _ := models.LookupOccasion("thanksgiving")
+	if sysPrompt := requests[0].Messages[0].Content; !strings.Contains(sysPrompt, occasion.Guidance) {
+		t.Fatalf("system prompt %q doesn't have the occasion's guidance", sysPrompt)
+	}
+	// The occasion's hashtag is added to the ones generated
+	if got, want := strings.Join(associated, ","), "thanksgiving,soup"; got != want {
+		t.Fatalf("associated hashtags %s, want %s", got, want)
+	}
+}
+
+func TestGenerateRecipeWithChatUnknownOccasion(t *testing.T) {
+	client := &openaitest.MockClient{CreateChatCompletionFunc: recipeCompletion}
+	s, _ := newGenerationService(client)
+
+	_, err := s.InitGenerateRecipeWithChat(context.Background(), generationUser(), "turkey", "en", "festivus", true)
+	if !errors.As(err, &service.ValidationError{}) {
+		t.Fatalf("InitGenerateRecipeWithChat error = %v, want a ValidationError", err)
+	}
+	if got := len(client.ChatCompletionRequests()); got != 0 {
+		t.Fatalf("got %d OpenAI requests, want none", got)
+	}
+}
+
+// cacheGenerations has the service cache generations in memory.
+func cacheGenerations(s *service.RecipeService) {
+	s.Cfg.GenerationCache.Enabled = true
+	s.Cfg.GenerationCache.TTLHours = 24
+
+	var mu sync.Mutex
+	cache := make(map[string]models.RecipeGenerationCache)
+	repo := s.Repo.(*servicetest.MockRecipeRepository)
+	repo.GetRecipeGenerationCacheFunc = func(cacheKey string) (*models.RecipeGenerationCache, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		cached, ok := cache[cacheKey]
+		if !ok {
+			return nil, repository.NotFoundError{}
+		}
+		recipeDef := *cached.RecipeDef
+		cached.RecipeDef = &recipeDef
+		return &cached, nil
+	}
+	repo.SaveRecipeGenerationCacheFunc = func(cached *models.RecipeGenerationCache) error {
+		mu.Lock()
+		defer mu.Unlock()
+		cache[cached.CacheKey] = *cached
+		return nil
+	}
+}
+
+func TestGenerateRecipeWithChatCache(t *testing.T) {
+	tests := []struct {
+		name         string
+		personalize  func(personalization *models.Personalization)
+		language     string
+		force        bool
+		wantRequests int
+	}{
+		{"another user with the same settings", func(p *models.Personalization) { p.UID = uuid.New() }, "en", false, 1},
+		{"different unit system", func(p *models.Personalization) { p.UnitSystem = models.Metric }, "en", false, 2},
+		{"different requirements", func(p *models.Personalization) { p.Requirements = "No cilantro" }, "en", false, 2},
+		{"different dietary restrictions", func(p *models.Personalization) { p.DietaryRestrictions = []string{"vegan"} }, "en", false, 2},
+		{"different persona", func(p *models.Personalization) { p.Persona = models.PersonaBudgetStudent }, "en", false, 2},
+		{"different language", func(p *models.Personalization) {}, "fr", false, 2},
+		{"forced", func(p *models.Personalization) {}, "en", true, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &openaitest.MockClient{CreateChatCompletionFunc: recipeCompletion}
+			s, recorder := newGenerationService(client)
+			cacheGenerations(s)
+
+			if _, err := s.InitGenerateRecipeWithChat(context.Background(), generationUser(), "Tomato  soup", "en", "", false); err != nil {
+				t.Fatalf("InitGenerateRecipeWithChat: %v", err)
+			}
+			if status := recorder.waitForStatus(t); status != models.GenerationComplete {
+				t.Fatalf("status = %s, want %s", status, models.GenerationComplete)
+			}
+
+			user := generationUser()
+			user.ID = 2
+			tt.personalize(user.Personalization)
+			// The prompt is normalized, so only the personalization, language, and force flag make a difference
+			if _, err := s.InitGenerateRecipeWithChat(context.Background(), user, "tomato soup", tt.language, "", tt.force); err != nil {
+				t.Fatalf("InitGenerateRecipeWithChat: %v", err)
+			}
+			if status := recorder.waitForStatus(t); status != models.GenerationComplete {
+				t.Fatalf("status = %s, want %s", status, models.GenerationComplete)
+			}
+
+			if got := len(client.ChatCompletionRequests()); got != tt.wantRequests {
+				t.Fatalf("got %d OpenAI requests, want %d", got, tt.wantRequests)
+			}
+			recorder.mu.Lock()
+			defer recorder.mu.Unlock()
+			if recorder.saved == nil || recorder.saved.Title != "Tomato Soup" {
+				t.Fatalf("saved recipe %+v, want the generated one", recorder.saved)
+			}
+		})
+	}
+}
+
+func TestPreviewRecipePromptMatchesGeneration(t *testing.T) {
+	client := &openaitest.MockClient{CreateChatCompletionFunc: recipeCompletion}
+	s, recorder := newGenerationService(client)
+	s.Cfg.OpenaiPrompts.GenNewRecipeSys = "You are CulinaryAI. Use {unitSystem}. {requirements}"
+	s.Cfg.OpenaiPrompts.GenNewRecipeUser = "Create a recipe for {userPrompt}"
+
+	user := generationUser()
+	user.Personalization.UnitSystem = models.Metric
+	user.Personalization.Requirements = "No cilantro"
+	user.Personalization.DietaryRestrictions = []string{"vegan"}
+	user.Personalization.Persona = models.PersonaHomeCook
+
+	preview, err := s.PreviewRecipePrompt(user, "tomato soup", "fr", "game_day")
+	if err != nil {
+		t.Fatalf("PreviewRecipePrompt: %v", err)
+	}
+	if got := len(client.ChatCompletionRequests()); got != 0 {
+		t.Fatalf("previewing made %d OpenAI requests, want none", got)
+	}
+
+	if _, err := s.InitGenerateRecipeWithChat(context.Background(), user, "tomato soup", "fr", "game_day", true); err != nil {
+		t.Fatalf("InitGenerateRecipeWithChat: %v", err)
+	}
+	if status := recorder.waitForStatus(t); status != models.GenerationComplete {
+		t.Fatalf("status = %s, want %s", status, models.GenerationComplete)
+	}
+	requests := client.ChatCompletionRequests()
+	if len(requests) != 1 {
+		t.Fatalf("got %d OpenAI requests, want 1", len(requests))
+	}
+
+	sent := requests[0].Messages
+	if len(preview.Messages) != len(sent) {
+		t.Fatalf("preview has %d messages, generation sent %d", len(preview.Messages), len(sent))
+	}
+	for i, message := range sent {
+		if preview.Messages[i].Role != message.Role || preview.Messages[i].Content != message.Content {
+			t.Fatalf("preview message %d = %+v, generation sent %s: %q", i, preview.Messages[i], message.Role, message.Content)
+		}
+	}
+	// The preview has the personalization in it, not just the templates
+	if !strings.Contains(preview.Messages[0].Content, "No cilantro") || !strings.Contains(preview.Messages[len(preview.Messages)-1].Content, "tomato soup") {
+		t.Fatalf("preview %+v doesn't have the requirements and prompt", preview.Messages)
+	}
+}
+
+func TestGenerateRecipeWithChatTierPrompt(t *testing.T) {
+	client := &openaitest.MockClient{CreateChatCompletionFunc: recipeCompletion}
+	s, recorder := newGenerationService(client)
+	s.Cfg.OpenaiPrompts.GenNewRecipeSys = "You are CulinaryAI. {{.Requirements}}"
+	s.Cfg.OpenaiPrompts.GenNewRecipeSysByTier = map[string]config.OpenaiPromptTemplate{
+		"Premium": "You are CulinaryAI, with elaborate plating notes. {{.Requirements}}",
+	}
+
+	user := generationUser()
+	user.Subscription.SubscriptionTier = models.Premium
+	user.Personalization.Requirements = "{{.UnitSystem}} <no cilantro>"
+
+	preview, err := s.PreviewRecipePrompt(user, "tomato soup", "en", "")
+	if err != nil {
+		t.Fatalf("PreviewRecipePrompt: %v", err)
+	}
+	if want := s.Cfg.OpenaiPrompts.GenNewRecipeSysByTier["Premium"].Version(); preview.PromptVersion != want {
+		t.Fatalf("prompt version = %q, want the Premium variant's %q", preview.PromptVersion, want)
+	}
+
+	if _, err := s.InitGenerateRecipeWithChat(context.Background(), user, "tomato soup", "en", "", true); err != nil {
+		t.Fatalf("InitGenerateRecipeWithChat: %v", err)
+	}
+	if status := recorder.waitForStatus(t); status != models.GenerationComplete {
+		t.Fatalf("status = %s, want %s", status, models.GenerationComplete)
+	}
+	requests := client.ChatCompletionRequests()
+	if len(requests) != 1 {
+		t.Fatalf("got %d OpenAI requests, want 1", len(requests))
+	}
+	// The requirements are inserted as they are, not read as part of the template
+	sysPrompt := requests[0].Messages[0].Content
+	if !strings.Contains(sysPrompt, "elaborate plating notes. {{.UnitSystem}} <no cilantro>") {
+		t.Fatalf("system prompt %q, want the Premium variant with the requirements verbatim", sysPrompt)
+	}
+}
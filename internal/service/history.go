@@ -0,0 +1,245 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/windoze95/saltybytes-api/internal/models"
+)
+
+// HistoryDiffResponse is the response object for the changes between two entries of a recipe's history.
+type HistoryDiffResponse struct {
+	From               int                 `json:"from"`
+	To                 int                 `json:"to"`
+	TitleFrom          string              `json:"title_from"`
+	TitleTo            string              `json:"title_to"`
+	AddedIngredients   []models.Ingredient `json:"added_ingredients"`
+	RemovedIngredients []models.Ingredient `json:"removed_ingredients"`
+	ChangedIngredients []IngredientChange  `json:"changed_ingredients"`
+	InstructionChanges []InstructionChange `json:"instruction_changes"`
+	CookTimeFrom       int                 `json:"cook_time_from"`
+	CookTimeTo         int                 `json:"cook_time_to"`
+	CookTimeDelta      int                 `json:"cook_time_delta"`
+}
+
+// IngredientChange is an ingredient that's in both history entries, with a different amount or unit.
+type IngredientChange struct {
+	Name string            `json:"name"`
+	From models.Ingredient `json:"from"`
+	To   models.Ingredient `json:"to"`
+}
+
+// InstructionChange is an instruction step that was added or removed between two history entries.
+// Step is the 1-based position of the instruction in the entry it's in.
+type InstructionChange struct {
+	Type string `json:"type"`
+	Step int    `json:"step"`
+	Text string `json:"text"`
+}
+
+// InstructionChange types.
+const (
+	InstructionAdded   = "added"
+	InstructionRemoved = "removed"
+)
+
+// DiffHistoryEntries compares two entries of a recipe's history, given their indices in the order they were made,
+// and returns what changed from the first to the second.
+func (s *RecipeService) DiffHistoryEntries(recipeID uint, from, to int) (*HistoryDiffResponse, error) {
+	entries, err := s.getRecipeHistoryEntries(recipeID)
+	if err != nil {
+		return nil, err
+	}
+
+	fromDef, err := historyEntryRecipeDef(entries, from)
+	if err != nil {
+		return nil, err
+	}
+	toDef, err := historyEntryRecipeDef(entries, to)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &HistoryDiffResponse{
+		From:          from,
+		To:            to,
+		TitleFrom:     fromDef.Title,
+		TitleTo:       toDef.Title,
+		CookTimeFrom:  fromDef.CookTime,
+		CookTimeTo:    toDef.CookTime,
+		CookTimeDelta: toDef.CookTime - fromDef.CookTime,
+	}
+	diff.AddedIngredients, diff.RemovedIngredients, diff.ChangedIngredients = diffIngredients(fromDef.Ingredients, toDef.Ingredients)
+	diff.InstructionChanges = diffInstructions(fromDef.Instructions, toDef.Instructions)
+
+	return diff, nil
+}
+
+// RevertRecipe restores the title, ingredients, instructions, cook time, and sub-recipes of one of the user's recipes
+// from the entry of its history at the index. The revert is appended to the history as a new entry, so no version is lost.
+func (s *RecipeService) RevertRecipe(user *models.User, recipeID uint, historyEntryIndex int) (*RecipeResponse, error) {
+	recipe, err := s.Repo.GetRecipeByID(recipeID)
+	if err != nil {
+		return nil, err
+	}
+
+	if recipe.CreatedByID != user.ID {
+		return nil, ForbiddenError{message: "Only the owner can revert this recipe"}
+	}
+
+	if recipe.GenerationStatus != models.GenerationComplete {
+		return nil, ConflictError{message: "Recipe is still being generated"}
+	}
+
+	history, err := s.Repo.GetHistoryByID(recipe.HistoryID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get recipe history: %w", err)
+	}
+
+	revertTo, err := historyEntryRecipeDef(history.Entries, historyEntryIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	// The image prompt and link suggestions stay with the current version, since its image is kept
+	recipe.Title = revertTo.Title
+	recipe.Ingredients = revertTo.Ingredients
+	recipe.Instructions = revertTo.Instructions
+	recipe.CookTime = revertTo.CookTime
+	recipe.SubRecipes = revertTo.SubRecipes
+
+	recipeDef := recipe.RecipeDef
+	entry := models.RecipeHistoryEntry{
+		UserPrompt:     fmt.Sprintf("Reverted to history entry %d", historyEntryIndex),
+		RecipeResponse: &recipeDef,
+		Type:           models.RecipeTypeRevert,
+	}
+	if err := s.Repo.UpdateRecipeDef(recipe, entry); err != nil {
+		return nil, fmt.Errorf("failed to save reverted recipe: %w", err)
+	}
+	s.invalidateCachedRecipe(recipe.ID)
+
+	if err := s.saveSubRecipes(recipe); err != nil {
+		log.Printf("Error saving sub-recipes of reverted recipe %d: %v", recipe.ID, err)
+	}
+
+	return s.GetRecipeByID(recipe.ID, user)
+}
+
+// getRecipeHistoryEntries fetches the entries of a recipe's history, in the order they were made.
+func (s *RecipeService) getRecipeHistoryEntries(recipeID uint) ([]models.RecipeHistoryEntry, error) {
+	recipe, err := s.Repo.GetRecipeByID(recipeID)
+	if err != nil {
+		return nil, err
+	}
+
+	history, err := s.Repo.GetHistoryByID(recipe.HistoryID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get recipe history: %w", err)
+	}
+
+	return history.Entries, nil
+}
+
+// historyEntryRecipeDef returns the recipe def of the history entry at the index.
+// An index out of range, or an entry without a recipe def, is a ValidationError.
+func historyEntryRecipeDef(entries []models.RecipeHistoryEntry, index int) (*models.RecipeDef, error) {
+	if index < 0 || index >= len(entries) {
+		return nil, ValidationError{message: fmt.Sprintf("History entry %d is out of range, the recipe has %d entries", index, len(entries))}
+	}
+
+	recipeDef := entries[index].RecipeResponse
+	if recipeDef == nil {
+		return nil, ValidationError{message: fmt.Sprintf("History entry %d has no recipe", index)}
+	}
+
+	return recipeDef, nil
+}
+
+// diffIngredients compares two ingredient lists by ingredient name, ignoring case.
+func diffIngredients(from, to models.Ingredients) (added, removed []models.Ingredient, changed []IngredientChange) {
+	added, removed, changed = []models.Ingredient{}, []models.Ingredient{}, []IngredientChange{}
+
+	fromByName := make(map[string]models.Ingredient, len(from))
+	for _, ingredient := range from {
+		fromByName[ingredientKey(ingredient)] = ingredient
+	}
+	toByName := make(map[string]models.Ingredient, len(to))
+	for _, ingredient := range to {
+		toByName[ingredientKey(ingredient)] = ingredient
+	}
+
+	for _, ingredient := range to {
+		previous, ok := fromByName[ingredientKey(ingredient)]
+		if !ok {
+			added = append(added, ingredient)
+		} else if previous.Amount != ingredient.Amount || !strings.EqualFold(previous.Unit, ingredient.Unit) {
+			changed = append(changed, IngredientChange{Name: ingredient.Name, From: previous, To: ingredient})
+		}
+		// Only compare the first of repeated names
+		delete(fromByName, ingredientKey(ingredient))
+	}
+	for _, ingredient := range from {
+		if _, ok := toByName[ingredientKey(ingredient)]; !ok {
+			removed = append(removed, ingredient)
+		}
+	}
+
+	return added, removed, changed
+}
+
+// ingredientKey returns the name ingredients are matched by.
+func ingredientKey(ingredient models.Ingredient) string {
+	return strings.ToLower(strings.TrimSpace(ingredient.Name))
+}
+
+// diffInstructions returns the instruction steps removed from the first list and added in the second,
+// keeping the longest run of steps they have in common.
+func diffInstructions(from, to []string) []InstructionChange {
+	// lcs[i][j] is the length of the longest common subsequence of from[i:] and to[j:]
+	lcs := make([][]int, len(from)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(to)+1)
+	}
+	for i := len(from) - 1; i >= 0; i-- {
+		for j := len(to) - 1; j >= 0; j-- {
+			if sameInstruction(from[i], to[j]) {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	changes := []InstructionChange{}
+	i, j := 0, 0
+	for i < len(from) && j < len(to) {
+		switch {
+		case sameInstruction(from[i], to[j]):
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			changes = append(changes, InstructionChange{Type: InstructionRemoved, Step: i + 1, Text: from[i]})
+			i++
+		default:
+			changes = append(changes, InstructionChange{Type: InstructionAdded, Step: j + 1, Text: to[j]})
+			j++
+		}
+	}
+	for ; i < len(from); i++ {
+		changes = append(changes, InstructionChange{Type: InstructionRemoved, Step: i + 1, Text: from[i]})
+	}
+	for ; j < len(to); j++ {
+		changes = append(changes, InstructionChange{Type: InstructionAdded, Step: j + 1, Text: to[j]})
+	}
+
+	return changes
+}
+
+// sameInstruction checks if two instruction steps are the same, ignoring whitespace.
+func sameInstruction(a, b string) bool {
+	return strings.Join(strings.Fields(a), " ") == strings.Join(strings.Fields(b), " ")
+}
@@ -0,0 +1,137 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/windoze95/saltybytes-api/internal/models"
+	"github.com/windoze95/saltybytes-api/internal/util"
+)
+
+// Link suggestions are matched to the recipe whose title shares the most words with them, and at least this share.
+const (
+	minLinkTitleSimilarity = 0.6
+	linkCandidateLimit     = 20
+)
+
+// linkTitleStopWords are left out when comparing titles, since they don't say what the recipe is.
+var linkTitleStopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "the": true, "with": true, "of": true, "for": true, "in": true, "on": true,
+	"homemade": true, "easy": true, "simple": true, "quick": true, "best": true, "classic": true, "recipe": true,
+}
+
+// ResolveLinkSuggestions links the recipe to the existing recipes whose titles match its link suggestions.
+// Suggestions without a match are kept, so they can be resolved once a matching recipe exists.
+func (s *RecipeService) ResolveLinkSuggestions(recipe *models.Recipe) error {
+	if len(recipe.LinkedSuggestions) == 0 {
+		return nil
+	}
+
+	var linkRecipeIDs []uint
+	unresolved := []string{}
+	for _, suggestion := range recipe.LinkedSuggestions {
+		linkRecipeID, err := s.matchLinkSuggestion(recipe.ID, suggestion)
+		if err != nil {
+			return err
+		}
+		if linkRecipeID == 0 {
+			unresolved = append(unresolved, suggestion)
+			continue
+		}
+		linkRecipeIDs = append(linkRecipeIDs, linkRecipeID)
+	}
+
+	if len(linkRecipeIDs) == 0 {
+		return nil
+	}
+
+	if err := s.Repo.LinkRecipes(recipe.ID, linkRecipeIDs, unresolved); err != nil {
+		return fmt.Errorf("failed to link recipes: %w", err)
+	}
+	s.invalidateCachedRecipe(recipe.ID)
+	recipe.LinkedSuggestions = unresolved
+
+	return nil
+}
+
+// ResolveRecipeLinks re-runs the link suggestion resolution of one of the user's recipes, e.g. once recipes matching
+// its suggestions have been created.
+func (s *RecipeService) ResolveRecipeLinks(user *models.User, recipeID uint) (*RecipeResponse, error) {
+	recipe, err := s.Repo.GetRecipeByID(recipeID)
+	if err != nil {
+		return nil, err
+	}
+
+	if recipe.CreatedByID != user.ID {
+		return nil, ForbiddenError{message: "Only the owner can resolve the links of this recipe"}
+	}
+
+	if recipe.GenerationStatus != models.GenerationComplete {
+		return nil, ConflictError{message: "Recipe is still being generated"}
+	}
+
+	if err := s.ResolveLinkSuggestions(recipe); err != nil {
+		return nil, err
+	}
+
+	return s.GetRecipeByID(recipe.ID, user)
+}
+
+// matchLinkSuggestion returns the ID of the recipe, other than recipeID, that best matches a link suggestion by title,
+// 0 if none is similar enough.
+func (s *RecipeService) matchLinkSuggestion(recipeID uint, suggestion string) (uint, error) {
+	words := linkTitleWords(suggestion)
+	if len(words) == 0 {
+		return 0, nil
+	}
+
+	candidates, err := s.Repo.ListRecipeTitlesContaining(words, recipeID, linkCandidateLimit)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find recipes matching link suggestion: %w", err)
+	}
+
+	var bestID uint
+	var bestSimilarity float64
+	for _, candidate := range candidates {
+		// Candidates come shortest title first, so ties keep the closer match
+		similarity := titleSimilarity(words, linkTitleWords(candidate.Title))
+		if similarity >= minLinkTitleSimilarity && similarity > bestSimilarity {
+			bestID, bestSimilarity = candidate.ID, similarity
+		}
+	}
+
+	return bestID, nil
+}
+
+// linkTitleWords splits a title into its distinct lowercase, singular words, without stop words.
+func linkTitleWords(title string) []string {
+	var words []string
+	seen := make(map[string]bool)
+	for _, word := range util.NormalizeWords(title) {
+		if linkTitleStopWords[word] || seen[word] {
+			continue
+		}
+		seen[word] = true
+		words = append(words, word)
+	}
+	return words
+}
+
+// titleSimilarity returns the share of the words of either title that both have, from 0 to 1.
+func titleSimilarity(a, b []string) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+
+	inB := make(map[string]bool, len(b))
+	for _, word := range b {
+		inB[word] = true
+	}
+	shared := 0
+	for _, word := range a {
+		if inB[word] {
+			shared++
+		}
+	}
+
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
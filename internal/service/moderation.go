@@ -0,0 +1,53 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/windoze95/saltybytes-api/internal/models"
+	"github.com/windoze95/saltybytes-api/internal/repository"
+)
+
+// AdminRecipeResponse is a recipe as moderators see it, with whether and by whom it was removed, and what generated it.
+type AdminRecipeResponse struct {
+	*RecipeResponse
+	DeletedAt          *time.Time `json:"deleted_at"`
+	ModeratedByID      *uint      `json:"moderated_by_id"`
+	GeneratedWithModel string     `json:"generated_with_model"`
+	PromptVersion      string     `json:"prompt_version"`
+}
+
+// ListRecipesForAdmin lists a page of every user's recipes that match the filter, newest first, with the total number
+// of them, for moderators.
+func (s *RecipeService) ListRecipesForAdmin(filter repository.AdminRecipeFilter, limit, offset int) ([]*AdminRecipeResponse, int64, error) {
+	recipes, total, err := s.Repo.ListRecipesForAdmin(filter, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	responses := make([]*AdminRecipeResponse, len(recipes))
+	for i, recipeResponse := range s.toRecipeResponses(recipes) {
+		responses[i] = &AdminRecipeResponse{
+			RecipeResponse:     recipeResponse,
+			DeletedAt:          recipes[i].DeletedAt,
+			ModeratedByID:      recipes[i].ModeratedByID,
+			GeneratedWithModel: recipes[i].GeneratedWithModel,
+			PromptVersion:      recipes[i].PromptVersion,
+		}
+	}
+	return responses, total, nil
+}
+
+// ModerateRecipe removes a recipe on behalf of a moderator. It goes to its owner's trash to be purged, but unlike a
+// recipe the owner deleted, they can't restore it.
+func (s *RecipeService) ModerateRecipe(moderator *models.User, recipeID uint) error {
+	if err := s.Repo.ModerateRecipe(recipeID, moderator.ID); err != nil {
+		if _, ok := err.(repository.NotFoundError); ok {
+			return err
+		}
+		return fmt.Errorf("failed to moderate recipe: %w", err)
+	}
+	s.invalidateCachedRecipe(recipeID)
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/windoze95/saltybytes-api/internal/models"
+	"github.com/windoze95/saltybytes-api/internal/openai"
+)
+
+// PairingsResponse is the response object for the drink pairings of a recipe.
+type PairingsResponse struct {
+	RecipeID uint            `json:"recipe_id"`
+	Pairings models.Pairings `json:"pairings"`
+}
+
+// newPairingsResponse creates the PairingsResponse of a recipe, with an empty list when it has no pairings.
+func newPairingsResponse(recipeID uint, pairings models.Pairings) *PairingsResponse {
+	if pairings == nil {
+		pairings = models.Pairings{}
+	}
+	return &PairingsResponse{RecipeID: recipeID, Pairings: pairings}
+}
+
+// GetRecipePairings fetches the drink pairings suggested for a recipe.
+// Recipes from before pairings were suggested have none until they're regenerated.
+func (s *RecipeService) GetRecipePairings(recipeID uint) (*PairingsResponse, error) {
+	recipe, err := s.getCachedRecipe(recipeID)
+	if err != nil {
+		return nil, err
+	}
+
+	return newPairingsResponse(recipe.ID, recipe.Pairings), nil
+}
+
+// RegenerateRecipePairings asks OpenAI for fresh drink pairings for one of the user's recipes, in the given language,
+// and replaces its pairings with them. The rest of the recipe is left as is.
+func (s *RecipeService) RegenerateRecipePairings(user *models.User, recipeID uint, language string) (*PairingsResponse, error) {
+	recipe, err := s.Repo.GetRecipeByID(recipeID)
+	if err != nil {
+		return nil, err
+	}
+
+	if recipe.CreatedByID != user.ID {
+		return nil, ForbiddenError{message: "Only the owner can regenerate this recipe's pairings"}
+	}
+
+	recipeDef := recipe.RecipeDef
+	recipeManager := &openai.RecipeManager{
+		Cfg:             s.Cfg,
+		RecipeDef:       &recipeDef,
+		Language:        language,
+		RecipeGenerator: s.RecipeGenerator,
+	}
+	defer s.recordTokenUsage(user.ID, recipe.ID, recipeManager, false, slog.Default())
+
+	if err := recipeManager.GenerateRecipePairings(); err != nil {
+		return nil, fmt.Errorf("failed to generate pairings: %w", err)
+	}
+
+	pairings := recipeManager.RecipeDef.Pairings
+	if err := s.Repo.UpdateRecipePairings(recipe.ID, pairings); err != nil {
+		return nil, fmt.Errorf("failed to save pairings: %w", err)
+	}
+	s.invalidateCachedRecipe(recipe.ID)
+
+	return newPairingsResponse(recipe.ID, pairings), nil
+}
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/windoze95/saltybytes-api/internal/models"
+	"github.com/windoze95/saltybytes-api/internal/openai"
+)
+
+// MaxPantryIngredients is the most ingredients on hand a recipe can be generated from.
+const MaxPantryIngredients = 30
+
+// MaxPantryIngredientLength is the longest ingredient on hand, in characters.
+const MaxPantryIngredientLength = 100
+
+// InitGenerateRecipeFromIngredients initializes a new recipe generated from the ingredients the user has on hand,
+// preferring them over anything the user would need to buy, and returns it pending like InitGenerateRecipeWithChat.
+// The note optionally says what the user would like to make. The completed recipe lists which of the ingredients it
+// uses and which others it needs.
+func (s *RecipeService) InitGenerateRecipeFromIngredients(ctx context.Context, user *models.User, ingredients []string, note string, language string, occasionKey string, force bool) (*RecipeResponse, error) {
+	pantry, err := normalizePantryIngredients(ingredients)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.initGenerateRecipe(ctx, user, openai.BuildPantryPrompt(pantry, note), language, occasionKey, force, pantry)
+}
+
+// normalizePantryIngredients trims the ingredients on hand and drops blank and repeated ones, regardless of case.
+// No ingredients, too many, or one that's too long is a ValidationError.
+func normalizePantryIngredients(ingredients []string) ([]string, error) {
+	pantry := make([]string, 0, len(ingredients))
+	seen := make(map[string]bool)
+	for _, ingredient := range ingredients {
+		ingredient = strings.Join(strings.Fields(ingredient), " ")
+		key := strings.ToLower(ingredient)
+		if ingredient == "" || seen[key] {
+			continue
+		}
+		if len([]rune(ingredient)) > MaxPantryIngredientLength {
+			return nil, ValidationError{message: fmt.Sprintf("Ingredients can't be longer than %d characters", MaxPantryIngredientLength)}
+		}
+		seen[key] = true
+		pantry = append(pantry, ingredient)
+	}
+
+	if len(pantry) == 0 {
+		return nil, ValidationError{message: "At least one ingredient is required"}
+	}
+	if len(pantry) > MaxPantryIngredients {
+		return nil, ValidationError{message: fmt.Sprintf("At most %d ingredients are allowed", MaxPantryIngredients)}
+	}
+
+	return pantry, nil
+}
+
+// reconcilePantryIngredients checks the ingredients on hand the model says a recipe uses against the ones the user
+// gave. An ingredient on hand is used if the model listed it, or an ingredient of the recipe names it, and is spelled
+// as the user gave it. Additional ingredients that are on hand after all are dropped. Recipes that weren't generated
+// from ingredients on hand are left alone.
+func reconcilePantryIngredients(recipeDef *models.RecipeDef, pantry []string) {
+	if len(pantry) == 0 {
+		return
+	}
+
+	listedUsed := make(map[string]bool)
+	for _, ingredient := range recipeDef.UsedIngredients {
+		listedUsed[strings.ToLower(strings.TrimSpace(ingredient))] = true
+	}
+
+	used := pq.StringArray{}
+	onHand := make(map[string]bool)
+	for _, ingredient := range pantry {
+		key := strings.ToLower(ingredient)
+		onHand[key] = true
+		if listedUsed[key] || recipeNamesIngredient(recipeDef, key) {
+			used = append(used, ingredient)
+		}
+	}
+
+	additional := pq.StringArray{}
+	seen := make(map[string]bool)
+	for _, ingredient := range recipeDef.AdditionalIngredients {
+		ingredient = strings.TrimSpace(ingredient)
+		key := strings.ToLower(ingredient)
+		if ingredient == "" || onHand[key] || seen[key] {
+			continue
+		}
+		seen[key] = true
+		additional = append(additional, ingredient)
+	}
+
+	recipeDef.UsedIngredients = used
+	recipeDef.AdditionalIngredients = additional
+}
+
+// recipeNamesIngredient returns whether any ingredient of the recipe has the lowercased name in its name.
+func recipeNamesIngredient(recipeDef *models.RecipeDef, name string) bool {
+	for _, ingredient := range recipeDef.Ingredients {
+		if strings.Contains(strings.ToLower(ingredient.Name), name) {
+			return true
+		}
+	}
+	return false
+}
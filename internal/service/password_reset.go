@@ -0,0 +1,143 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/windoze95/saltybytes-api/internal/mail"
+	"github.com/windoze95/saltybytes-api/internal/models"
+	"github.com/windoze95/saltybytes-api/internal/repository"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordResetTokenBytes is the number of random bytes in a password reset token.
+const passwordResetTokenBytes = 32
+
+// RequestPasswordReset emails a password reset link to the user with the email address.
+// It succeeds whether or not there's a user with the address, so it can't be used to find out who has an account.
+// Failures are logged rather than returned for the same reason. Users with as many outstanding reset links as the
+// config allows aren't emailed another, so their inbox can't be flooded.
+func (s *UserService) RequestPasswordReset(email string) error {
+	user, err := s.Repo.GetUserByEmail(email)
+	if err != nil {
+		if _, ok := err.(repository.NotFoundError); !ok {
+			log.Printf("Error looking up user for password reset: %v", err)
+		}
+		return nil
+	}
+
+	now := time.Now()
+	outstanding, err := s.Repo.CountOutstandingPasswordResetTokens(user.ID, now)
+	if err != nil {
+		log.Printf("Error counting password reset tokens of user %d: %v", user.ID, err)
+		return nil
+	}
+	if outstanding >= s.Cfg.PasswordReset.MaxOutstanding() {
+		log.Printf("Not emailing user %d another password reset link, they have %d outstanding", user.ID, outstanding)
+		return nil
+	}
+
+	token, tokenHash, err := newPasswordResetToken()
+	if err != nil {
+		log.Printf("Error generating password reset token for user %d: %v", user.ID, err)
+		return nil
+	}
+
+	resetToken := &models.PasswordResetToken{
+		UserID:    user.ID,
+		TokenHash: tokenHash,
+		ExpiresAt: now.Add(s.Cfg.PasswordReset.TokenTTL()),
+	}
+	if err := s.Repo.CreatePasswordResetToken(resetToken); err != nil {
+		log.Printf("Error creating password reset token for user %d: %v", user.ID, err)
+		return nil
+	}
+
+	// Sent in the background, so the response takes as long whether or not the user exists
+	sender := s.Mailer
+	if sender == nil {
+		sender = mail.LogSender{}
+	}
+	subject, body := passwordResetEmail(user, s.passwordResetLink(token), s.Cfg.PasswordReset.TokenTTLMinutes)
+	go func() {
+		if err := sender.Send(user.Email, subject, body); err != nil {
+			log.Printf("Error sending password reset email to user %d: %v", user.ID, err)
+		}
+	}()
+
+	return nil
+}
+
+// ResetPassword sets a new password with a reset token, which can't be used again.
+// Every session of the user is revoked, so anyone logged in with the old password is logged out.
+func (s *UserService) ResetPassword(token, newPassword string) error {
+	if err := s.ValidatePassword(newPassword); err != nil {
+		return ValidationError{message: err.Error()}
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), 10)
+	if err != nil {
+		return fmt.Errorf("error hashing password: %v", err)
+	}
+
+	userID, err := s.Repo.ResetPassword(hashPasswordResetToken(token), string(hashedPassword))
+	if err != nil {
+		if _, ok := err.(repository.NotFoundError); ok {
+			return ValidationError{message: "the password reset link is invalid or has expired"}
+		}
+		return err
+	}
+
+	sessions, err := s.Repo.ListActiveUserSessions(userID)
+	if err != nil {
+		return fmt.Errorf("error listing sessions to revoke: %v", err)
+	}
+	for _, session := range sessions {
+		revoked, err := s.Repo.RevokeUserSession(userID, session.ID)
+		if err != nil {
+			return fmt.Errorf("error revoking session: %v", err)
+		}
+		if err := s.blockSessionToken(revoked); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// passwordResetLink returns the frontend link that resets the password with the token.
+func (s *UserService) passwordResetLink(token string) string {
+	return s.Cfg.PasswordReset.ResetURL + "?token=" + url.QueryEscape(token)
+}
+
+// passwordResetEmail returns the subject and body of the email with a user's password reset link.
+func passwordResetEmail(user *models.User, link string, ttlMinutes int) (string, string) {
+	subject := "Reset your SaltyBytes password"
+	body := fmt.Sprintf("Hi %s,\n\n"+
+		"Someone asked to reset the password of your SaltyBytes account. If it was you, follow this link to choose a new one:\n\n"+
+		"%s\n\n"+
+		"The link works once and expires in %d minutes. If you didn't ask for it, you can ignore this email.\n",
+		user.FirstName, link, ttlMinutes)
+	return subject, body
+}
+
+// newPasswordResetToken generates a random reset token, returning it and the hash it's stored as.
+func newPasswordResetToken() (string, string, error) {
+	b := make([]byte, passwordResetTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("error generating password reset token: %v", err)
+	}
+	token := hex.EncodeToString(b)
+	return token, hashPasswordResetToken(token), nil
+}
+
+// hashPasswordResetToken hashes a reset token, so a leaked database doesn't leak usable tokens.
+func hashPasswordResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
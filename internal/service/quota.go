@@ -0,0 +1,145 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/windoze95/saltybytes-api/internal/models"
+)
+
+// quotaResetBatchSize is how many expired subscriptions are refilled per query of the background reset.
+const quotaResetBatchSize = 100
+
+// generationRefundsKey is the context key of the refunds of a request's recipe generation.
+type generationRefundsKey struct{}
+
+// WithGenerationRefund returns a copy of the context carrying refund, which gives back what the request was charged
+// for its recipe generation. Generations that finish in the background call it if they fail, after the request has
+// been responded to. It's called at most once per generation, but can be called by the request too, so it must be
+// safe to call more than once.
+func WithGenerationRefund(ctx context.Context, refund func()) context.Context {
+	refunds := generationRefunds(ctx)
+	return context.WithValue(ctx, generationRefundsKey{}, append(refunds[:len(refunds):len(refunds)], refund))
+}
+
+// RefundGeneration calls the refunds carried by the context, giving back what its request was charged for its
+// recipe generation.
+func RefundGeneration(ctx context.Context) {
+	for _, refund := range generationRefunds(ctx) {
+		refund()
+	}
+}
+
+// generationRefunds returns the refunds carried by the context.
+func generationRefunds(ctx context.Context) []func() {
+	refunds, _ := ctx.Value(generationRefundsKey{}).([]func())
+	return refunds
+}
+
+// StartQuotaResetter refills the subscriptions whose billing cycle ended every reset interval, in the background.
+// It does nothing when the reset interval isn't positive, subscriptions are still refilled when they're used.
+// Every instance can run one, a cycle is only refilled by whichever instance starts it first.
+func (s *UserService) StartQuotaResetter() {
+	interval := s.Cfg.Limits.QuotaResetInterval()
+	if interval <= 0 {
+		return
+	}
+
+	// Reset goroutine
+	go func() {
+		for range time.Tick(interval) {
+			refilled, err := s.ResetExpiredQuotas()
+			if err != nil {
+				log.Printf("Error resetting expired quotas: %v", err)
+			}
+			log.Printf("Refilled %d subscriptions for a new billing cycle", refilled)
+		}
+	}()
+}
+
+// ResetExpiredQuotas starts the next billing cycle of every subscription whose cycle ended, a batch at a time.
+// It returns how many subscriptions were refilled, even when a later batch failed.
+func (s *UserService) ResetExpiredQuotas() (int, error) {
+	now := time.Now()
+	refilled := 0
+	for {
+		subscriptions, err := s.Repo.ListExpiredSubscriptions(now, quotaResetBatchSize)
+		if err != nil {
+			return refilled, err
+		}
+
+		batchRefilled := 0
+		for i := range subscriptions {
+			ok, err := s.refillSubscription(&subscriptions[i], now)
+			if err != nil {
+				return refilled, err
+			}
+			if ok {
+				batchRefilled++
+			}
+		}
+		refilled += batchRefilled
+
+		// Subscriptions another instance refilled first drop out of the next batch, stop once there's nothing left to do
+		if len(subscriptions) < quotaResetBatchSize || batchRefilled == 0 {
+			return refilled, nil
+		}
+	}
+}
+
+// EnsureQuotaFresh starts the next billing cycle of the user's subscription if the current one has ended,
+// refilling their remaining uses to the allotment of their tier. It's called before the user's quota is checked.
+// It's safe to call more than once, a cycle is only refilled once.
+func (s *UserService) EnsureQuotaFresh(user *models.User) error {
+	if user.Subscription == nil {
+		return errors.New("user's Subscription is nil")
+	}
+
+	if _, err := s.refillSubscription(user.Subscription, time.Now()); err != nil {
+		return fmt.Errorf("error refilling remaining uses: %v", err)
+	}
+
+	return nil
+}
+
+// refillSubscription starts the next billing cycle of the subscription if its current one ended by now,
+// and updates it to the new cycle. It returns false if the cycle hasn't ended or another request refilled it first.
+func (s *UserService) refillSubscription(subscription *models.Subscription, now time.Time) (bool, error) {
+	if now.Before(subscription.ExpiresAt) {
+		return false, nil
+	}
+
+	nextExpiresAt := nextBillingCycleEnd(subscription.ExpiresAt, now)
+	remainingUses := subscription.SubscriptionTier.MonthlyUses()
+	refilled, err := s.Repo.RefillRemainingUses(subscription.UserID, subscription.ExpiresAt, nextExpiresAt, remainingUses)
+	if err != nil || !refilled {
+		return false, err
+	}
+
+	subscription.ExpiresAt = nextExpiresAt
+	subscription.RemainingUses = remainingUses
+	return true, nil
+}
+
+// nextBillingCycleEnd returns the end of the billing cycle that's current at now, for a subscription whose last cycle
+// ended at expiresAt. Cycles are a month long, and keep the day of the month the subscription started on.
+func nextBillingCycleEnd(expiresAt, now time.Time) time.Time {
+	// Subscriptions that never had a cycle start one now
+	if expiresAt.IsZero() {
+		return now.AddDate(0, 1, 0)
+	}
+
+	months := (now.Year()-expiresAt.Year())*12 + int(now.Month()-expiresAt.Month())
+	if months < 1 {
+		months = 1
+	}
+	next := expiresAt.AddDate(0, months, 0)
+	for !next.After(now) {
+		months++
+		next = expiresAt.AddDate(0, months, 0)
+	}
+	return next
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/windoze95/saltybytes-api/internal/models"
+)
+
+// MaxRatingCommentLength is the most characters the comment of a rating can have.
+const MaxRatingCommentLength = 1000
+
+// RecipeRatingResponse is the response object for a user's rating of a recipe.
+type RecipeRatingResponse struct {
+	RecipeID  uint      `json:"recipe_id"`
+	UserID    uint      `json:"user_id"`
+	Username  string    `json:"username"`
+	Score     int       `json:"score"`
+	Comment   string    `json:"comment"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// RateRecipe saves the user's rating of a recipe they collected, from 1 to 5, replacing their earlier rating of it.
+func (s *RecipeService) RateRecipe(user *models.User, recipeID uint, score int, comment string) (*RecipeRatingResponse, error) {
+	if score < 1 || score > 5 {
+		return nil, ValidationError{message: "score must be from 1 to 5"}
+	}
+
+	comment = strings.TrimSpace(comment)
+	if utf8.RuneCountInString(comment) > MaxRatingCommentLength {
+		return nil, ValidationError{message: fmt.Sprintf("comment can be at most %d characters", MaxRatingCommentLength)}
+	}
+
+	// Make sure the recipe exists
+	if _, err := s.Repo.GetRecipeByID(recipeID); err != nil {
+		return nil, err
+	}
+
+	collected, err := s.Repo.IsRecipeCollected(user.ID, recipeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check collected recipe: %w", err)
+	}
+	if !collected {
+		return nil, ForbiddenError{message: "Only collected recipes can be rated"}
+	}
+
+	rating := &models.RecipeRating{
+		RecipeID: recipeID,
+		UserID:   user.ID,
+		Score:    score,
+		Comment:  comment,
+	}
+	if err := s.Repo.UpsertRecipeRating(rating); err != nil {
+		return nil, fmt.Errorf("failed to rate recipe: %w", err)
+	}
+	s.invalidateCachedRecipe(recipeID)
+	rating.User = user
+
+	return toRecipeRatingResponse(rating), nil
+}
+
+// GetRecipeRatings lists a page of a recipe's ratings, most recently changed first, with the total number of them.
+func (s *RecipeService) GetRecipeRatings(recipeID uint, limit, offset int) ([]*RecipeRatingResponse, int64, error) {
+	// Make sure the recipe exists
+	if _, err := s.Repo.GetRecipeByID(recipeID); err != nil {
+		return nil, 0, err
+	}
+
+	ratings, total, err := s.Repo.ListRecipeRatings(recipeID, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	responses := make([]*RecipeRatingResponse, len(ratings))
+	for i := range ratings {
+		responses[i] = toRecipeRatingResponse(&ratings[i])
+	}
+	return responses, total, nil
+}
+
+// toRecipeRatingResponse converts a RecipeRating to a RecipeRatingResponse.
+func toRecipeRatingResponse(rating *models.RecipeRating) *RecipeRatingResponse {
+	username := ""
+	if rating.User != nil {
+		username = rating.User.Username
+	}
+
+	return &RecipeRatingResponse{
+		RecipeID:  rating.RecipeID,
+		UserID:    rating.UserID,
+		Username:  username,
+		Score:     rating.Score,
+		Comment:   rating.Comment,
+		UpdatedAt: rating.UpdatedAt,
+	}
+}
@@ -0,0 +1,100 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// defaultRecaptchaVerifyURL is Google's reCAPTCHA verify endpoint.
+const defaultRecaptchaVerifyURL = "https://www.google.com/recaptcha/api/siteverify"
+
+// defaultRecaptchaTimeout is used when the config doesn't set a timeout.
+const defaultRecaptchaTimeout = 10 * time.Second
+
+// recaptchaVerifyResponse is the response of the reCAPTCHA verify endpoint.
+type recaptchaVerifyResponse struct {
+	Success    bool     `json:"success"`
+	Score      *float64 `json:"score"` // Only set by reCAPTCHA v3
+	ErrorCodes []string `json:"error-codes"`
+}
+
+// RecaptchaRequired reports whether a reCAPTCHA token must be verified, for login or signup.
+func (s *UserService) RecaptchaRequired(login bool) bool {
+	if !s.Cfg.Recaptcha.Enabled {
+		return false
+	}
+	return !login || s.Cfg.Recaptcha.EnforceOnLogin
+}
+
+// VerifyRecaptcha verifies a reCAPTCHA token with Google.
+// A rejected token is a ForbiddenError. When Google can't be reached the token is
+// accepted if the config fails open, otherwise it's an UnavailableError.
+func (s *UserService) VerifyRecaptcha(token string) error {
+	if token == "" {
+		return ForbiddenError{message: "reCAPTCHA token is required"}
+	}
+
+	verification, err := s.requestRecaptchaVerification(token)
+	if err != nil {
+		if s.Cfg.Recaptcha.FailOpen {
+			log.Printf("Error verifying reCAPTCHA, letting the request through: %v", err)
+			return nil
+		}
+		log.Printf("Error verifying reCAPTCHA: %v", err)
+		return UnavailableError{message: "reCAPTCHA verification is unavailable, please try again later"}
+	}
+
+	if !verification.Success {
+		return ForbiddenError{message: "reCAPTCHA verification failed"}
+	}
+	if verification.Score != nil && *verification.Score < s.Cfg.Recaptcha.MinScore {
+		return ForbiddenError{message: "reCAPTCHA verification failed"}
+	}
+
+	return nil
+}
+
+// requestRecaptchaVerification posts a token to the verify endpoint.
+func (s *UserService) requestRecaptchaVerification(token string) (*recaptchaVerifyResponse, error) {
+	secret := s.Cfg.OptionalEnv.RecaptchaSecretKey.Value()
+	if secret == "" {
+		return nil, fmt.Errorf("reCAPTCHA secret key is not set")
+	}
+
+	verifyURL := s.Cfg.Recaptcha.VerifyURL
+	if verifyURL == "" {
+		verifyURL = defaultRecaptchaVerifyURL
+	}
+	timeout := time.Duration(s.Cfg.Recaptcha.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = defaultRecaptchaTimeout
+	}
+	client := &http.Client{Timeout: timeout}
+
+	resp, err := client.PostForm(verifyURL, url.Values{
+		"secret":   {secret},
+		"response": {token},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status from reCAPTCHA verify endpoint: %s", resp.Status)
+	}
+
+	var verification recaptchaVerifyResponse
+	if err := json.NewDecoder(resp.Body).Decode(&verification); err != nil {
+		return nil, fmt.Errorf("error decoding reCAPTCHA response: %v", err)
+	}
+	if len(verification.ErrorCodes) > 0 {
+		log.Printf("reCAPTCHA verification returned error codes: %v", verification.ErrorCodes)
+	}
+
+	return &verification, nil
+}
@@ -0,0 +1,126 @@
+package service_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/windoze95/saltybytes-api/internal/config"
+	"github.com/windoze95/saltybytes-api/internal/service"
+	"github.com/windoze95/saltybytes-api/internal/service/servicetest"
+)
+
+// recaptchaService returns a user service verifying reCAPTCHA tokens with the verify endpoint at verifyURL.
+func recaptchaService(t *testing.T, verifyURL string, failOpen bool) *service.UserService {
+	t.Helper()
+	t.Setenv("TEST_RECAPTCHA_SECRET_KEY", "secret")
+
+	cfg := &config.Config{}
+	cfg.OptionalEnv.RecaptchaSecretKey = "TEST_RECAPTCHA_SECRET_KEY"
+	cfg.Recaptcha = config.RecaptchaOptions{
+		Enabled:   true,
+		MinScore:  0.5,
+		FailOpen:  failOpen,
+		VerifyURL: verifyURL,
+	}
+	return service.NewUserService(cfg, &servicetest.MockUserRepository{}, nil)
+}
+
+func TestVerifyRecaptcha(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		wantErr  bool
+	}{
+		{"success", `{"success": true}`, false},
+		{"success with a high score", `{"success": true, "score": 0.9}`, false},
+		{"failure", `{"success": false, "error-codes": ["invalid-input-response"]}`, true},
+		{"low score", `{"success": true, "score": 0.1}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.PostFormValue("secret") != "secret" || r.PostFormValue("response") != "token" {
+					t.Errorf("got form %v, want the secret and token", r.PostForm)
+				}
+				w.Write([]byte(tt.response))
+			}))
+			defer server.Close()
+
+			err := recaptchaService(t, server.URL, false).VerifyRecaptcha("token")
+			if !tt.wantErr && err != nil {
+				t.Fatalf("VerifyRecaptcha: %v", err)
+			}
+			if tt.wantErr && !errors.As(err, &service.ForbiddenError{}) {
+				t.Fatalf("VerifyRecaptcha error = %v, want a ForbiddenError", err)
+			}
+		})
+	}
+}
+
+func TestVerifyRecaptchaMissingToken(t *testing.T) {
+	s := recaptchaService(t, "http://127.0.0.1:0", true)
+	if err := s.VerifyRecaptcha(""); !errors.As(err, &service.ForbiddenError{}) {
+		t.Fatalf("VerifyRecaptcha error = %v, want a ForbiddenError", err)
+	}
+}
+
+func TestVerifyRecaptchaUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name      string
+		verifyURL string
+		failOpen  bool
+		wantErr   bool
+	}{
+		{"error status, fail closed", server.URL, false, true},
+		{"error status, fail open", server.URL, true, false},
+		{"connection refused, fail closed", "http://127.0.0.1:0", false, true},
+		{"connection refused, fail open", "http://127.0.0.1:0", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := recaptchaService(t, tt.verifyURL, tt.failOpen).VerifyRecaptcha("token")
+			if !tt.wantErr && err != nil {
+				t.Fatalf("VerifyRecaptcha: %v", err)
+			}
+			if tt.wantErr && !errors.As(err, &service.UnavailableError{}) {
+				t.Fatalf("VerifyRecaptcha error = %v, want an UnavailableError", err)
+			}
+		})
+	}
+}
+
+func TestRecaptchaRequired(t *testing.T) {
+	tests := []struct {
+		name           string
+		enabled        bool
+		enforceOnLogin bool
+		wantSignup     bool
+		wantLogin      bool
+	}{
+		{"disabled", false, true, false, false},
+		{"signup only", true, false, true, false},
+		{"signup and login", true, true, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{}
+			cfg.Recaptcha.Enabled = tt.enabled
+			cfg.Recaptcha.EnforceOnLogin = tt.enforceOnLogin
+			s := service.NewUserService(cfg, &servicetest.MockUserRepository{}, nil)
+
+			if got := s.RecaptchaRequired(false); got != tt.wantSignup {
+				t.Fatalf("RecaptchaRequired(signup) = %v, want %v", got, tt.wantSignup)
+			}
+			if got := s.RecaptchaRequired(true); got != tt.wantLogin {
+				t.Fatalf("RecaptchaRequired(login) = %v, want %v", got, tt.wantLogin)
+			}
+		})
+	}
+}
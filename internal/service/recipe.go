@@ -4,236 +4,1492 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"math"
+	"runtime/debug"
+	"slices"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/jinzhu/gorm"
 	"github.com/windoze95/saltybytes-api/internal/config"
+	"github.com/windoze95/saltybytes-api/internal/imaging"
+	"github.com/windoze95/saltybytes-api/internal/logging"
 	"github.com/windoze95/saltybytes-api/internal/models"
 	"github.com/windoze95/saltybytes-api/internal/openai"
+	"github.com/windoze95/saltybytes-api/internal/pdf"
 	"github.com/windoze95/saltybytes-api/internal/repository"
 	"github.com/windoze95/saltybytes-api/internal/s3"
+	"github.com/windoze95/saltybytes-api/internal/util"
+	"golang.org/x/sync/singleflight"
 )
 
 // RecipeService is the business logic layer for recipe-related operations.
 type RecipeService struct {
-	Cfg  *config.Config
-	Repo *repository.RecipeRepository
+	Cfg      *config.Config
+	Repo     RecipeRepository
+	UserRepo UserRepository
+	// RecipeGenerator and ImageGenerator are optional, when nil OpenAI API clients are used.
+	// Set them to generate recipes without real API calls.
+	RecipeGenerator openai.RecipeGenerator
+	ImageGenerator  openai.ImageGenerator
+	// GenerationTimeout bounds how long a recipe and its image may take to generate
+	GenerationTimeout time.Duration
+	// RecipeCache is optional, when nil every view of a recipe reads the database
+	RecipeCache RecipeCache
+	// recipeCacheMetrics counts the hits and misses of the RecipeCache
+	recipeCacheMetrics recipeCacheMetrics
+	// recipeFetches coalesces concurrent fetches of the same recipe into one database read
+	recipeFetches singleflight.Group
+	// explanations coalesces concurrent explanations of the same recipe into one OpenAI call
+	explanations singleflight.Group
+	// imageUploads pauses recipe image uploads while S3 is persistently failing
+	imageUploads *s3.CircuitBreaker
+	// generations streams the progress of the recipe generations in flight
+	generations generationEvents
 }
 
 // RecipeResponse is the response object for recipe-related operations.
 type RecipeResponse struct {
-	ID                     uint               `json:"ID"`
-	Title                  string             `json:"title"`
-	Ingredients            models.Ingredients `json:"ingredients"`
-	Instructions           []string           `json:"instructions"`
-	CookTime               int                `json:"cook_time"`
-	UnitSystem             models.UnitSystem  `json:"unit_system"`
-	LinkedRecipes          []*models.Recipe   `json:"linked_recipes"`
-	LinkedSuggestions      []string           `json:"link_suggestions"`
-	Hashtags               []*models.Tag      `json:"hashtags"`
-	ImageURL               string             `json:"image_url"`
-	CreatedByID            uint               `json:"created_by_id"`
-	CreatedByUsername      string             `json:"created_by_username"`
-	HistoryID              uint               `json:"history_id"`
-	ForkedFromID           *uint              `json:"forked_from_id"`
-	ForkedFromName         *string            `json:"forked_from_name"`
-	UserUnitSystem         models.UnitSystem  `json:"user_unit_system"`
-	PersonalizationUID     uuid.UUID          `json:"personalization_uid"`
-	UserPersonalizationUID uuid.UUID          `json:"user_personalization_uid"`
+	ID                     uint                    `json:"ID"`
+	Title                  string                  `json:"title"`
+	Ingredients            models.Ingredients      `json:"ingredients"`
+	Instructions           []string                `json:"instructions"`
+	CookTime               int                     `json:"cook_time"`
+	Servings               int                     `json:"servings,omitempty"`
+	Difficulty             models.Difficulty       `json:"difficulty,omitempty"`
+	Allergens              []string                `json:"allergens"`
+	Pairings               models.Pairings         `json:"pairings"`
+	UsedIngredients        []string                `json:"used_ingredients,omitempty"`       // Set when generated from the ingredients on hand
+	AdditionalIngredients  []string                `json:"additional_ingredients,omitempty"` // Set when generated from the ingredients on hand
+	AllergenWarnings       []string                `json:"allergen_warnings,omitempty"`      // Allergens of the recipe the viewer excludes
+	UnitSystem             models.UnitSystem       `json:"unit_system"`
+	LinkedRecipes          []*models.Recipe        `json:"linked_recipes"`
+	ParentRecipeID         *uint                   `json:"parent_recipe_id,omitempty"`
+	LinkedSuggestions      []string                `json:"link_suggestions"`
+	Hashtags               []*models.Tag           `json:"hashtags"`
+	ImageURL               string                  `json:"image_url"`
+	ThumbnailURL           string                  `json:"thumbnail_url"`
+	WebPURL                string                  `json:"webp_url"`
+	CreatedByID            uint                    `json:"created_by_id"`
+	CreatedByUsername      string                  `json:"created_by_username"`
+	HistoryID              uint                    `json:"history_id"`
+	ForkedFromID           *uint                   `json:"forked_from_id"`
+	ForkedFromName         *string                 `json:"forked_from_name"`
+	ImagesDisabled         bool                    `json:"images_disabled"`
+	UserPrompt             string                  `json:"user_prompt,omitempty"`
+	Persona                models.Persona          `json:"persona"`
+	Occasion               string                  `json:"occasion,omitempty"`
+	UserUnitSystem         models.UnitSystem       `json:"user_unit_system"`
+	PersonalizationUID     uuid.UUID               `json:"personalization_uid"`
+	UserPersonalizationUID uuid.UUID               `json:"user_personalization_uid"`
+	GenerationStatus       models.GenerationStatus `json:"generation_status"`
+	AverageRating          float64                 `json:"average_rating"`
+	RatingCount            int                     `json:"rating_count"`
 }
 
 // NewRecipeService is the constructor function for initializing a new RecipeService
-func NewRecipeService(cfg *config.Config, repo *repository.RecipeRepository) *RecipeService {
-	return &RecipeService{
-		Cfg:  cfg,
-		Repo: repo,
+func NewRecipeService(cfg *config.Config, repo RecipeRepository, userRepo UserRepository) *RecipeService {
+	uploadOpts := cfg.Images.Upload
+	s := &RecipeService{
+		Cfg:               cfg,
+		Repo:              repo,
+		UserRepo:          userRepo,
+		GenerationTimeout: 5 * time.Minute,
+		imageUploads:      s3.NewCircuitBreaker(uploadOpts.BreakerFailureThreshold, time.Duration(uploadOpts.BreakerCooldownSeconds)*time.Second),
 	}
+	if cacheOpts := cfg.RecipeCache; cacheOpts.Enabled && cacheOpts.TTL() > 0 {
+		s.RecipeCache = NewMemoryRecipeCache(cacheOpts.TTL(), cacheOpts.MaxEntries)
+	}
+	return s
+}
+
+// GetRecipeByID fetches a recipe by its ID.
+// The viewer is optional, when set the response includes the fields specific to them.
+func (s *RecipeService) GetRecipeByID(recipeID uint, viewer *models.User) (*RecipeResponse, error) {
+	// Fetch the recipe by its ID through the cache, shared with any identical fetch in flight.
+	// Errors aren't kept beyond the fetches in flight, so a failed read is retried by the next request.
+	key := strconv.FormatUint(uint64(recipeID), 10)
+	value, err, _ := s.recipeFetches.Do(key, func() (interface{}, error) {
+		return s.getCachedRecipe(recipeID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The recipe is shared between callers, it must not be mutated
+	recipe := value.(*models.Recipe)
+
+	// Create a RecipeResponse from the Recipe, then apply the fields specific to this viewer
+	recipeResponse := toRecipeResponse(recipe)
+	s.applyImageFields(recipeResponse, recipe)
+	applyViewerFields(recipeResponse, recipe, viewer)
+
+	return recipeResponse, nil
+}
+
+// CreateWelcomeRecipe gives a new user their own copy of the curated welcome recipe.
+// It returns nil when the welcome recipe is disabled. The copy isn't a generation, so it doesn't count
+// towards the user's generation limits.
+func (s *RecipeService) CreateWelcomeRecipe(user *models.User) (*RecipeResponse, error) {
+	opts := s.Cfg.WelcomeRecipe
+	if !opts.Enabled || opts.TemplateRecipeID == 0 {
+		return nil, nil
+	}
+
+	template, err := s.Repo.GetRecipeByID(opts.TemplateRecipeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get welcome recipe template: %w", err)
+	}
+
+	recipe := &models.Recipe{
+		RecipeDef:    template.RecipeDef,
+		UnitSystem:   template.UnitSystem,
+		ImageURL:     template.ImageURL,
+		ThumbnailURL: template.ThumbnailURL,
+		WebPURL:      template.WebPURL,
+		CreatedBy:    user,
+		ForkedFromID: &template.ID,
+		History: &models.RecipeHistory{
+			Entries: []models.RecipeHistoryEntry{},
+		},
+	}
+	if user.Personalization != nil {
+		recipe.PersonalizationUID = user.Personalization.UID
+	}
+
+	if err := s.Repo.CreateRecipe(recipe); err != nil {
+		return nil, fmt.Errorf("failed to save welcome recipe: %w", err)
+	}
+
+	recipeDef := template.RecipeDef
+	entry := models.RecipeHistoryEntry{
+		RecipeResponse: &recipeDef,
+		Type:           models.RecipeTypeBasedOn,
+	}
+	if err := s.Repo.UpdateRecipeDef(recipe, entry); err != nil {
+		return nil, fmt.Errorf("failed to save welcome recipe history: %w", err)
+	}
+
+	hashtags := make([]string, len(template.Hashtags))
+	for i, tag := range template.Hashtags {
+		hashtags[i] = tag.Hashtag
+	}
+	if err := s.AssociateTagsWithRecipe(recipe, hashtags); err != nil {
+		slog.Default().Error("associating tags with welcome recipe", "recipe_id", recipe.ID, "error", err)
+	}
+
+	recipeResponse := toRecipeResponse(recipe)
+	s.applyImageFields(recipeResponse, recipe)
+	applyViewerFields(recipeResponse, recipe, user)
+
+	return recipeResponse, nil
+}
+
+// CombinedIngredientsResponse is the response object for the ingredients of a recipe and its sub-recipes together.
+type CombinedIngredientsResponse struct {
+	RecipeID    uint                    `json:"recipe_id"`
+	Ingredients []util.MergedIngredient `json:"ingredients"`
+}
+
+// GetCombinedIngredients merges the ingredients of a recipe and its linked sub-recipes,
+// summing repeated ingredients while keeping how much each recipe needs.
+func (s *RecipeService) GetCombinedIngredients(recipeID uint) (*CombinedIngredientsResponse, error) {
+	recipe, err := s.Repo.GetRecipeWithLinkedRecipes(recipeID)
+	if err != nil {
+		return nil, err
+	}
+
+	recipes := append([]*models.Recipe{recipe}, recipe.LinkedRecipes...)
+
+	return &CombinedIngredientsResponse{
+		RecipeID:    recipe.ID,
+		Ingredients: util.MergeIngredients(recipes),
+	}, nil
+}
+
+// Bounds of the factor a recipe can be scaled by.
+const (
+	MinScaleFactor = 0.1
+	MaxScaleFactor = 50
+)
+
+// ScaleRecipe returns a recipe for the viewer with its ingredient amounts multiplied by the factor, without calling
+// OpenAI. When servings is set the factor is ignored, the recipe is scaled to serve that many instead, which only
+// recipes that say how many they serve can be. It returns the factor the recipe was scaled by.
+// The stored recipe isn't changed.
+func (s *RecipeService) ScaleRecipe(recipeID uint, viewer *models.User, factor float64, servings int) (*RecipeResponse, float64, error) {
+	if servings < 0 {
+		return nil, 0, ValidationError{message: "servings must be positive"}
+	}
+	if servings == 0 && !(factor >= MinScaleFactor && factor <= MaxScaleFactor) {
+		return nil, 0, ValidationError{message: fmt.Sprintf("factor must be between %v and %v", MinScaleFactor, MaxScaleFactor)}
+	}
+
+	// The recipe comes with the viewer's units and allergen warnings, like any view of it
+	recipeResponse, err := s.GetRecipeByID(recipeID, viewer)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if servings > 0 {
+		if recipeResponse.Servings <= 0 {
+			return nil, 0, ValidationError{message: "Recipe doesn't say how many it serves, scale it by a factor instead"}
+		}
+		factor = float64(servings) / float64(recipeResponse.Servings)
+		if factor < MinScaleFactor || factor > MaxScaleFactor {
+			return nil, 0, ValidationError{message: fmt.Sprintf("servings must be between %v and %v times the %d the recipe serves", MinScaleFactor, MaxScaleFactor, recipeResponse.Servings)}
+		}
+		recipeResponse.Servings = servings
+	} else if recipeResponse.Servings > 0 {
+		recipeResponse.Servings = max(1, int(math.Round(float64(recipeResponse.Servings)*factor)))
+	}
+
+	recipeResponse.Ingredients = util.ScaleIngredients(recipeResponse.Ingredients, factor)
+
+	return recipeResponse, factor, nil
+}
+
+// ListRecipesByUser lists a page of the recipes a user created that match the filter, newest first, with the total
+// number of them.
+func (s *RecipeService) ListRecipesByUser(userID uint, filter util.RecipeFilter, limit, offset int) ([]*RecipeResponse, int64, error) {
+	recipes, total, err := s.Repo.ListRecipesByUser(userID, filter, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	return s.toRecipeResponses(recipes), total, nil
+}
+
+// ListCollectedRecipesByUser lists a page of the recipes a user collected that match the filter, newest first, with the
+// total number of them.
+func (s *RecipeService) ListCollectedRecipesByUser(userID uint, filter util.RecipeFilter, limit, offset int) ([]*RecipeResponse, int64, error) {
+	recipes, total, err := s.Repo.ListCollectedRecipesByUser(userID, filter, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	return s.toRecipeResponses(recipes), total, nil
+}
+
+// CollectRecipe saves a recipe to a user's collected recipes. A recipe can only be collected once.
+func (s *RecipeService) CollectRecipe(userID, recipeID uint) error {
+	// Make sure the recipe exists
+	if _, err := s.Repo.GetRecipeByID(recipeID); err != nil {
+		return err
+	}
+
+	collected, err := s.Repo.IsRecipeCollected(userID, recipeID)
+	if err != nil {
+		return fmt.Errorf("failed to check collected recipe: %w", err)
+	}
+	if collected {
+		return ConflictError{message: "Recipe already collected"}
+	}
+
+	if err := s.Repo.CollectRecipe(userID, recipeID); err != nil {
+		return fmt.Errorf("failed to collect recipe: %w", err)
+	}
+
+	return nil
+}
+
+// UncollectRecipe removes a recipe from a user's collected recipes.
+func (s *RecipeService) UncollectRecipe(userID, recipeID uint) error {
+	collected, err := s.Repo.IsRecipeCollected(userID, recipeID)
+	if err != nil {
+		return fmt.Errorf("failed to check collected recipe: %w", err)
+	}
+	if !collected {
+		return repository.NewNotFoundError("Recipe not collected")
+	}
+
+	if err := s.Repo.UncollectRecipe(userID, recipeID); err != nil {
+		return fmt.Errorf("failed to uncollect recipe: %w", err)
+	}
+
+	return nil
+}
+
+// GetRecipesByTag lists a page of the recipes tagged with a hashtag that match the filter, newest first, with the total
+// number of them.
+// The hashtag is cleaned like the tags themselves, so it matches regardless of case, spaces, and a leading '#'.
+func (s *RecipeService) GetRecipesByTag(hashtag string, filter util.RecipeFilter, limit, offset int) ([]*RecipeResponse, int64, error) {
+	recipes, total, err := s.Repo.ListRecipesByTag(cleanHashtag(hashtag), filter, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	return s.toRecipeResponses(recipes), total, nil
+}
+
+// ListRecipeFeed lists a page of the newest recipes that match the filter, newest first, after the cursor of the last page.
+// It returns the cursor of the next page, which is empty when there are no more recipes.
+func (s *RecipeService) ListRecipeFeed(cursor *util.FeedCursor, filter util.RecipeFilter, limit int) ([]*RecipeResponse, string, error) {
+	// One more recipe than the page tells whether there's a next page
+	recipes, err := s.Repo.ListFeedRecipes(cursor, filter, limit+1)
+	if err != nil {
+		return nil, "", err
+	}
+
+	nextCursor := ""
+	if len(recipes) > limit {
+		recipes = recipes[:limit]
+		last := recipes[len(recipes)-1]
+		nextCursor = util.FeedCursor{CreatedAt: last.CreatedAt, ID: last.ID}.Encode()
+	}
+
+	return s.toRecipeResponses(recipes), nextCursor, nil
+}
+
+// ListPopularTags lists the hashtags with the most recipes, with how many recipes each has.
+func (s *RecipeService) ListPopularTags(limit int) ([]repository.NameCount, error) {
+	return s.Repo.ListPopularTags(limit)
+}
+
+// toRecipeResponses converts a list of recipes to RecipeResponses.
+func (s *RecipeService) toRecipeResponses(recipes []models.Recipe) []*RecipeResponse {
+	responses := make([]*RecipeResponse, len(recipes))
+	for i := range recipes {
+		responses[i] = toRecipeResponse(&recipes[i])
+		s.applyImageFields(responses[i], &recipes[i])
+	}
+	return responses
+}
+
+// HistoryResponse is the response object for recipe history-related operations.
+type HistoryResponse struct {
+	Entries []models.RecipeHistoryEntry `json:"entries"`
+}
+
+// GetRecipeHistoryByID fetches a recipe history by its ID.
+// The viewer is optional, the prompts of the entries are only included for the recipe's owner.
+func (s *RecipeService) GetRecipeHistoryByID(ctx context.Context, historyID uint, viewer *models.User) (*HistoryResponse, error) {
+	// Fetch the recipe by its ID from the repository
+	history, err := s.Repo.GetHistoryByID(historyID)
+	if err != nil {
+		return nil, err
+	}
+
+	historyResponse := &HistoryResponse{Entries: history.Entries}
+	if !s.isHistoryOwner(ctx, historyID, viewer) {
+		for i := range historyResponse.Entries {
+			historyResponse.Entries[i].UserPrompt = ""
+		}
+	}
+
+	return historyResponse, nil
+}
+
+// isHistoryOwner reports whether the viewer created the recipe the history belongs to.
+// A history whose owner can't be found is treated as someone else's.
+func (s *RecipeService) isHistoryOwner(ctx context.Context, historyID uint, viewer *models.User) bool {
+	if viewer == nil {
+		return false
+	}
+	ownerID, err := s.Repo.GetRecipeOwnerByHistoryID(historyID)
+	if err != nil {
+		if _, ok := err.(repository.NotFoundError); !ok {
+			logging.FromContext(ctx).Error("getting owner of recipe history", "history_id", historyID, "error", err)
+		}
+		return false
+	}
+	return ownerID == viewer.ID
+}
+
+// InitGenerateRecipeWithChat initializes a new recipe with chat, generated in the given language, and returns it pending,
+// without its content. The completed recipe comes from GetGenerationStatus, or WaitForGeneration, once it's generated.
+// An identical earlier generation is reused when the generation cache is enabled, unless force is set.
+// The context is the request's, the generation logs with its request ID but isn't canceled with it.
+func (s *RecipeService) InitGenerateRecipeWithChat(ctx context.Context, user *models.User, userPrompt string, language string, occasionKey string, force bool) (*RecipeResponse, error) {
+	return s.initGenerateRecipe(ctx, user, userPrompt, language, occasionKey, force, nil)
+}
+
+// initGenerateRecipe initializes a new recipe with chat and starts generating it in the background. When the user's
+// ingredients on hand are given, the recipe is generated from them.
+func (s *RecipeService) initGenerateRecipe(ctx context.Context, user *models.User, userPrompt string, language string, occasionKey string, force bool, pantry []string) (*RecipeResponse, error) {
+	if user.Personalization == nil || user.Personalization.ID == 0 {
+		logging.FromContext(ctx).Error("user's Personalization is nil", "user_id", user.ID)
+		return nil, errors.New("user's Personalization is nil")
+	}
+
+	// The occasion is optional
+	var occasion *models.Occasion
+	if occasionKey != "" {
+		var ok bool
+		if occasion, ok = models.LookupOccasion(occasionKey); !ok {
+			return nil, ValidationError{message: fmt.Sprintf("unknown occasion %q", occasionKey)}
+		}
+	}
+
+	// Decide how the generation is paid for before anything is created
+	plan, err := s.planGeneration(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	plan.pantry = pantry
+	if !force {
+		plan.cacheKey = s.generationCacheKey(user, userPrompt, language, occasion, plan)
+	}
+
+	// Populate initial fields of the Recipe struct
+	recipe := &models.Recipe{
+		CreatedBy:          user,
+		PersonalizationUID: user.Personalization.UID, // Set from user's existing Personalization
+		UnitSystem:         user.Personalization.UnitSystem,
+		UserPrompt:         userPrompt,
+		Persona:            user.Personalization.Persona,
+		GenerationStatus:   models.GenerationPending,
+		History: &models.RecipeHistory{
+			Entries: []models.RecipeHistoryEntry{},
+		},
+	}
+
+	if occasion != nil {
+		recipe.Occasion = occasion.Key
+	}
+	if len(pantry) > 0 {
+		recipe.CreateType = models.RecipeTypeFromIngredients
+	}
+	recipe.PromptHash = recipePromptHash(userPrompt, language, recipe.Occasion)
+
+	// Create a Recipe with the basic Recipe details
+	if err := s.Repo.CreateRecipe(recipe); err != nil {
+		return nil, fmt.Errorf("failed to save recipe record: %w", err)
+	}
+
+	recipeResponse := toRecipeResponse(recipe)
+	s.applyImageFields(recipeResponse, recipe)
+	applyViewerFields(recipeResponse, recipe, user)
+
+	// Track the generation before it starts, so it can be streamed as soon as the recipe ID is returned
+	s.generations.register(recipe.ID)
+	go s.FinishGenerateRecipeWithChat(recipe, user, userPrompt, language, plan)
+
+	// The recipe now has an ID generated by the database
+	return recipeResponse, nil
 }
 
-// GetRecipeByID fetches a recipe by its ID.
-func (s *RecipeService) GetRecipeByID(recipeID uint) (*RecipeResponse, error) {
-	// Fetch the recipe by its ID from the repository
-	recipe, err := s.Repo.GetRecipeByID(recipeID)
-	if err != nil {
-		return nil, err
+// ForkRecipe starts a new recipe for the user from a copy of another recipe, modified by OpenAI per the user prompt.
+// The fork keeps a reference to its source, but gets its own ID, image, and history, seeded with the source recipe.
+// Deleted recipes can't be forked. The fork is generated in the background like a new recipe.
+func (s *RecipeService) ForkRecipe(ctx context.Context, user *models.User, sourceRecipeID uint, userPrompt string, language string) (*RecipeResponse, *models.Recipe, error) {
+	if user.Personalization == nil || user.Personalization.ID == 0 {
+		logging.FromContext(ctx).Error("user's Personalization is nil", "user_id", user.ID)
+		return nil, nil, errors.New("user's Personalization is nil")
+	}
+
+	// Soft-deleted recipes aren't found
+	source, err := s.Repo.GetRecipeByID(sourceRecipeID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	plan, err := s.planGeneration(ctx, user)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sourceDef := source.RecipeDef
+	plan.history = []models.RecipeHistoryEntry{{
+		RecipeResponse: &sourceDef,
+		Type:           models.RecipeTypeBasedOn,
+	}}
+
+	// The fork starts as a copy of the source, without its image
+	recipe := &models.Recipe{
+		RecipeDef:          source.RecipeDef,
+		UnitSystem:         source.UnitSystem,
+		CreatedBy:          user,
+		PersonalizationUID: user.Personalization.UID,
+		ForkedFromID:       &source.ID,
+		CreateType:         models.RecipeTypeRegenChat,
+		UserPrompt:         userPrompt,
+		Persona:            user.Personalization.Persona,
+		Occasion:           source.Occasion,
+		GenerationStatus:   models.GenerationPending,
+		History: &models.RecipeHistory{
+			Entries: plan.history,
+		},
+	}
+
+	if err := s.Repo.CreateRecipe(recipe); err != nil {
+		return nil, nil, fmt.Errorf("failed to save forked recipe record: %w", err)
+	}
+	recipe.ForkedFrom = source
+
+	recipeResponse := toRecipeResponse(recipe)
+	s.applyImageFields(recipeResponse, recipe)
+	applyViewerFields(recipeResponse, recipe, user)
+
+	// Track the generation before it starts, so it can be streamed as soon as the recipe ID is returned
+	s.generations.register(recipe.ID)
+	go s.FinishGenerateRecipeWithChat(recipe, user, userPrompt, language, plan)
+
+	return recipeResponse, recipe, nil
+}
+
+// FinishGenerateRecipeWithChat finishes generating a recipe with chat.
+// A recipe with a history to continue from, such as a fork, is regenerated from it.
+func (s *RecipeService) FinishGenerateRecipeWithChat(recipe *models.Recipe, user *models.User, userPrompt string, language string, plan *generationPlan) {
+	// One context cancels every step of the generation once it times out. Until the recipe is saved, the streams of the
+	// generation cancel it too if every client goes away, after that its image is finished regardless.
+	ctx, cancel := context.WithTimeout(context.Background(), s.GenerationTimeout)
+	defer cancel()
+
+	// Log with the ID of the request that started the generation, so its errors can be traced back to it
+	logger := plan.logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	logger = logger.With("recipe_id", recipe.ID)
+
+	// A panic outside the pipeline fails the recipe too, unless it was already saved
+	var saved atomic.Bool
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error("finishing recipe generation panicked", "panic", r, "stack", string(debug.Stack()))
+			if !saved.Load() {
+				s.failGeneration(recipe.ID, plan, "recipe generation failed", logger)
+			}
+		}
+	}()
+
+	// Streams of the generation are closed once it's done
+	s.generations.setCancel(recipe.ID, cancel)
+	defer s.generations.finish(recipe.ID)
+	s.generations.publish(recipe.ID, GenerationEvent{Type: GenerationRecipeStarted, Data: map[string]interface{}{"recipe_id": recipe.ID}})
+	if err := s.Repo.UpdateRecipeGenerationStatus(recipe.ID, models.GenerationGenerating); err != nil {
+		logger.Error("updating generation status", "error", err)
+	}
+	s.invalidateCachedRecipe(recipe.ID)
+
+	occasion, _ := models.LookupOccasion(recipe.Occasion)
+	recipeManager := s.newChatRecipeManager(user, userPrompt, language, recipe.Persona, occasion)
+	recipeManager.Model = plan.model
+	recipeManager.Ctx = ctx
+	recipeManager.PantryIngredients = plan.pantry
+
+	generate := func() error {
+		return s.generateRecipeWithCache(recipeManager, plan.cacheKey)
+	}
+	if len(plan.history) > 0 {
+		recipeManager.RecipeHistoryEntries = plan.history
+		generate = recipeManager.GenerateRecipeWithRegenChat
+	}
+
+	// Beta users get the recipe streamed as it's generated
+	if user.HasFeature(models.FeatureStreaming) {
+		recipeManager.OnRecipeChunk = func(chunk string) {
+			s.generations.publish(recipe.ID, GenerationEvent{Type: GenerationRecipeChunk, Data: map[string]interface{}{"chunk": chunk}})
+		}
+	}
+
+	// Generate with the user's personal key, and count what it cost towards their monthly spend
+	if plan.apiKey != "" {
+		client := openai.NewClient(plan.apiKey)
+		if recipeManager.RecipeGenerator == nil {
+			recipeManager.RecipeGenerator = client
+		}
+		if recipeManager.ImageGenerator == nil {
+			recipeManager.ImageGenerator = client
+		}
+		defer s.recordPersonalKeySpend(user.ID, recipeManager, plan.logger)
+	}
+
+	// Record what was used for the user's usage report
+	defer s.recordTokenUsage(user.ID, recipe.ID, recipeManager, plan.apiKey != "", plan.logger)
+
+	// The pipeline runs on its own goroutine, so the generation ends on time even if a step doesn't stop with the context.
+	// Its channel is buffered, so the pipeline doesn't block on a generation that already ended.
+	errChan := make(chan error, 1)
+	go func() {
+		defer recoverGeneration(logger, errChan)
+		errChan <- s.runGenerationPipeline(ctx, recipe, recipeManager, generate, &saved, logger)
+	}()
+
+	var err error
+	select {
+	case err = <-errChan:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		s.handleGenerationError(ctx, recipe.ID, plan, err, saved.Load(), logger)
+	}
+}
+
+// generationStep is a step of the recipe generation pipeline, failures are handled per step.
+type generationStep int
+
+// generationStep enum values.
+const (
+	generationStepRecipe generationStep = iota // Generating and saving the recipe, a failure deletes the recipe
+	generationStepImage                        // Generating the image, a failure leaves the recipe without one
+	generationStepUpload                       // Uploading the image, a failure retries the upload later
+)
+
+// generationError is an error in a step of the recipe generation pipeline.
+type generationError struct {
+	step generationStep
+	err  error
+}
+
+func (e *generationError) Error() string {
+	return e.err.Error()
+}
+
+func (e *generationError) Unwrap() error {
+	return e.err
+}
+
+// runGenerationPipeline generates a recipe and saves it, then generates its image and uploads it, publishing the
+// progress to the streams of the generation. Every step stops once the context is done. saved is set once the recipe
+// is saved, from then on the recipe is kept whatever happens to its image. Errors are generationErrors.
+func (s *RecipeService) runGenerationPipeline(ctx context.Context, recipe *models.Recipe, recipeManager *openai.RecipeManager, generate func() error, saved *atomic.Bool, logger *slog.Logger) error {
+	if err := generate(); err != nil {
+		return &generationError{step: generationStepRecipe, err: err}
+	}
+	if err := populateRecipeCoreFields(recipe, recipeManager); err != nil {
+		return &generationError{step: generationStepRecipe, err: err}
+	}
+	if err := ctx.Err(); err != nil {
+		return &generationError{step: generationStepRecipe, err: err}
+	}
+	if err := s.Repo.UpdateRecipeDef(recipe, recipeManager.NextRecipeHistoryEntry); err != nil {
+		return &generationError{step: generationStepRecipe, err: err}
+	}
+	s.invalidateCachedRecipe(recipe.ID)
+	saved.Store(true)
+
+	// The recipe is saved, clients going away no longer cancel the generation
+	s.generations.setCancel(recipe.ID, func() {})
+
+	if err := s.saveSubRecipes(recipe); err != nil {
+		logger.Error("saving sub-recipes", "error", err)
+	}
+	if err := s.ResolveLinkSuggestions(recipe); err != nil {
+		logger.Error("resolving link suggestions", "error", err)
+	}
+	if err := s.AssociateTagsWithRecipe(recipe, recipeManager.RecipeDef.Hashtags); err != nil {
+		logger.Error("associating tags", "error", err)
+	}
+
+	// The recipe is complete once it's saved, its image is finished separately
+	if err := s.Repo.UpdateRecipeGenerationStatus(recipe.ID, models.GenerationComplete); err != nil {
+		logger.Error("updating generation status", "error", err)
+	}
+	s.invalidateCachedRecipe(recipe.ID)
+	recipe.GenerationStatus = models.GenerationComplete
+	s.generations.publish(recipe.ID, GenerationEvent{Type: GenerationRecipeComplete, Data: map[string]interface{}{"recipe_id": recipe.ID, "recipe": recipeManager.RecipeDef}})
+
+	// Image generation is disabled org-wide, fall back to the placeholder
+	if s.Cfg.Images.Disabled {
+		if err := s.usePlaceholderImage(recipe.ID); err != nil {
+			logger.Error("using placeholder image", "error", err)
+		}
+		return nil
+	}
+
+	s.generations.publish(recipe.ID, GenerationEvent{Type: GenerationImageStarted, Data: map[string]interface{}{"recipe_id": recipe.ID}})
+	if err := recipeManager.GenerateRecipeImage(ctx); err != nil {
+		return &generationError{step: generationStepImage, err: err}
+	}
+	if err := ctx.Err(); err != nil {
+		return &generationError{step: generationStepImage, err: err}
+	}
+
+	imageURLs, err := s.uploadRecipeImage(recipe.ID, recipeManager.ImageBytes, recipeManager.ImageSize, logger)
+	if err != nil {
+		return &generationError{step: generationStepUpload, err: err}
+	}
+	if err := s.saveRecipeImageURLs(recipe.ID, imageURLs, logger); err != nil {
+		return &generationError{step: generationStepUpload, err: fmt.Errorf("failed to update recipe image URL: %w", err)}
+	}
+	s.generations.publish(recipe.ID, GenerationEvent{Type: GenerationImageComplete, Data: map[string]interface{}{"recipe_id": recipe.ID, "image_url": imageURLs.Image, "thumbnail_url": imageURLs.Thumbnail}})
+
+	return nil
+}
+
+// handleGenerationError handles a recipe generation that failed, or whose context ended, in a step of its pipeline.
+// Errors without a step, like panics, are in the recipe step until the recipe is saved, and the image step after.
+func (s *RecipeService) handleGenerationError(ctx context.Context, recipeID uint, plan *generationPlan, err error, saved bool, logger *slog.Logger) {
+	step := generationStepRecipe
+	if saved {
+		step = generationStepImage
+	}
+	var stepErr *generationError
+	if errors.As(err, &stepErr) {
+		step = stepErr.step
+	}
+
+	// Steps can fail from the context ending before it's noticed, so the context decides if the generation ran out of time
+	if ctx.Err() != nil && step == generationStepRecipe {
+		err = fmt.Errorf("incomplete recipe generation: timed out after %v", s.GenerationTimeout)
+		if errors.Is(ctx.Err(), context.Canceled) {
+			err = errors.New("incomplete recipe generation: canceled after its stream was closed")
+		}
+		logger.Error("finishing recipe generation", "error", err)
+		s.failGeneration(recipeID, plan, "recipe generation timed out", logger)
+		return
+	}
+	if ctx.Err() != nil && step == generationStepImage {
+		err = fmt.Errorf("incomplete recipe image generation: timed out after %v", s.GenerationTimeout)
+		logger.Error("generating recipe image", "error", err)
+		s.publishGenerationError(recipeID, "image generation timed out")
+		return
+	}
+
+	switch step {
+	case generationStepRecipe:
+		logger.Error("finishing recipe generation", "error", err)
+		s.failGeneration(recipeID, plan, "recipe generation failed", logger)
+	case generationStepImage:
+		logger.Error("generating recipe image", "error", err)
+		s.publishGenerationError(recipeID, "image generation failed")
+	case generationStepUpload:
+		logger.Error("uploading recipe image", "error", err)
+		// Keep the text recipe and show the placeholder until the image can be uploaded
+		if err := s.deferImageUpload(recipeID); err != nil {
+			logger.Error("deferring image upload", "error", err)
+		}
+		s.publishGenerationError(recipeID, "image upload failed, it will be retried")
+	}
+}
+
+// failGeneration tells the streams of a generation that it failed, marks it failed, and deletes its recipe.
+// The request that started the generation gets back what it was charged for it.
+func (s *RecipeService) failGeneration(recipeID uint, plan *generationPlan, message string, logger *slog.Logger) {
+	s.publishGenerationError(recipeID, message)
+	s.markGenerationFailed(recipeID, logger)
+	plan.refund()
+	if err := s.DeleteRecipe(recipeID); err != nil {
+		logger.Error("deleting failed recipe", "error", err)
+		return
+	}
+	logger.Info("failed recipe deleted")
+}
+
+// recoverGeneration turns a panic in a generation goroutine into an error on its error channel, logged with its stack,
+// so the generation fails like any other error instead of taking down the server. It must be deferred.
+func recoverGeneration(logger *slog.Logger, errChan chan<- error) {
+	if r := recover(); r != nil {
+		logger.Error("generation panicked", "panic", r, "stack", string(debug.Stack()))
+		errChan <- fmt.Errorf("generation panicked: %v", r)
+	}
+}
+
+// markGenerationFailed marks a recipe's generation as failed, before the recipe is deleted.
+func (s *RecipeService) markGenerationFailed(recipeID uint, logger *slog.Logger) {
+	if err := s.Repo.UpdateRecipeGenerationStatus(recipeID, models.GenerationFailed); err != nil {
+		logger.Error("updating generation status", "error", err)
+	}
+	s.invalidateCachedRecipe(recipeID)
+}
+
+// newChatRecipeManager creates the recipe manager of a new recipe generated with chat for the user.
+// The occasion is optional.
+func (s *RecipeService) newChatRecipeManager(user *models.User, userPrompt string, language string, persona models.Persona, occasion *models.Occasion) *openai.RecipeManager {
+	return &openai.RecipeManager{
+		SubscriptionTier:    subscriptionTier(user),
+		UserPrompt:          userPrompt,
+		UnitSystem:          user.Personalization.GetUnitSystemText(),
+		Requirements:        user.Personalization.Requirements,
+		DietaryRestrictions: user.Personalization.DietaryRestrictions,
+		Language:            language,
+		Persona:             persona,
+		Occasion:            occasion,
+		Cfg:                 s.Cfg,
+		ImageOptions:        userImageOptions(user),
+		RecipeGenerator:     s.RecipeGenerator,
+		ImageGenerator:      s.ImageGenerator,
+	}
+}
+
+// subscriptionTier returns the user's subscription tier, empty if they have no subscription.
+func subscriptionTier(user *models.User) string {
+	if user.Subscription == nil {
+		return ""
+	}
+	return string(user.Subscription.SubscriptionTier)
+}
+
+// userImageOptions returns the options the user's recipe images are generated with by default.
+func userImageOptions(user *models.User) openai.ImageOptions {
+	if user.Settings == nil {
+		return openai.ImageOptions{}
+	}
+	return openai.ImageOptions{
+		Size:    user.Settings.ImageSize,
+		Quality: user.Settings.ImageQuality,
+		Style:   user.Settings.ImageStyle,
+	}
+}
+
+// PromptPreviewMessage is a message of a prompt preview.
+type PromptPreviewMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// PromptPreviewResponse is the response object for previewing the prompt of a recipe generation.
+type PromptPreviewResponse struct {
+	Messages      []PromptPreviewMessage `json:"messages"`
+	PromptVersion string                 `json:"prompt_version"`
+}
+
+// PreviewRecipePrompt returns the messages a new recipe would be generated from with chat for the user, with their
+// current personalization, without calling OpenAI. The messages never contain API keys.
+func (s *RecipeService) PreviewRecipePrompt(user *models.User, userPrompt string, language string, occasionKey string) (*PromptPreviewResponse, error) {
+	if user.Personalization == nil || user.Personalization.ID == 0 {
+		return nil, errors.New("user's Personalization is nil")
+	}
+
+	var occasion *models.Occasion
+	if occasionKey != "" {
+		var ok bool
+		if occasion, ok = models.LookupOccasion(occasionKey); !ok {
+			return nil, ValidationError{message: fmt.Sprintf("unknown occasion %q", occasionKey)}
+		}
+	}
+
+	recipeManager := s.newChatRecipeManager(user, userPrompt, language, user.Personalization.Persona, occasion)
+	chatCompletionMessages, err := recipeManager.NewRecipeChatMessages()
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]PromptPreviewMessage, len(chatCompletionMessages))
+	for i, message := range chatCompletionMessages {
+		messages[i] = PromptPreviewMessage{Role: message.Role, Content: message.Content}
+	}
+
+	return &PromptPreviewResponse{
+		Messages:      messages,
+		PromptVersion: s.Cfg.OpenaiPrompts.GenNewRecipeSysFor(subscriptionTier(user)).Version(),
+	}, nil
+}
+
+// publishGenerationError tells the streams of a generation that it failed.
+func (s *RecipeService) publishGenerationError(recipeID uint, message string) {
+	s.generations.publish(recipeID, GenerationEvent{Type: GenerationError, Data: map[string]interface{}{"recipe_id": recipeID, "error": message}})
+}
+
+// SubscribeGeneration streams the progress of a recipe's generation to its owner.
+// A recipe that's no longer being generated gets a single event for how it ended. The returned function must be called
+// when the stream ends, and cancels the generation if it's the last stream left.
+func (s *RecipeService) SubscribeGeneration(recipeID uint, user *models.User) (<-chan GenerationEvent, func(), error) {
+	recipe, err := s.Repo.GetRecipeByID(recipeID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if recipe.CreatedByID != user.ID {
+		return nil, nil, ForbiddenError{message: "only the recipe's creator can stream its generation"}
+	}
+
+	if events, unsubscribe, ok := s.generations.subscribe(recipeID); ok {
+		return events, unsubscribe, nil
+	}
+
+	// The generation already finished, the recipe would've been deleted if it failed
+	events := make(chan GenerationEvent, 1)
+	events <- GenerationEvent{Type: GenerationRecipeComplete, Data: map[string]interface{}{"recipe_id": recipe.ID, "recipe": recipe.RecipeDef}}
+	close(events)
+	return events, func() {}, nil
+}
+
+// GenerationStatusResponse is the response object for a recipe's generation status.
+// Recipe is the completed recipe, only set once the status is complete.
+type GenerationStatusResponse struct {
+	RecipeID uint                    `json:"recipe_id"`
+	Status   models.GenerationStatus `json:"status"`
+	Recipe   *RecipeResponse         `json:"recipe,omitempty"`
+}
+
+// GetGenerationStatus gets the generation status of one of the user's recipes, so its generation can be polled.
+// Once the generation is complete, the status comes with the completed recipe.
+// Failed generations are still reported after their recipe is deleted.
+func (s *RecipeService) GetGenerationStatus(recipeID uint, user *models.User) (*GenerationStatusResponse, error) {
+	recipe, err := s.Repo.GetRecipeGenerationStatus(recipeID)
+	if err != nil {
+		return nil, err
+	}
+	if recipe.CreatedByID != user.ID {
+		return nil, ForbiddenError{message: "only the recipe's creator can get its generation status"}
+	}
+	// Recipes the user deleted themselves are gone
+	if recipe.DeletedAt != nil && recipe.GenerationStatus != models.GenerationFailed {
+		return nil, repository.NewNotFoundError("Recipe not found")
+	}
+
+	statusResponse := &GenerationStatusResponse{RecipeID: recipe.ID, Status: recipe.GenerationStatus}
+	if recipe.GenerationStatus == models.GenerationComplete {
+		if statusResponse.Recipe, err = s.GetRecipeByID(recipe.ID, user); err != nil {
+			return nil, err
+		}
+	}
+
+	return statusResponse, nil
+}
+
+// WaitForGeneration waits for the text of a recipe the user is generating, and returns its generation status, which
+// comes with the completed recipe unless the generation failed. The image is still generated in the background.
+// The wait ends with the context, but the generation carries on regardless.
+func (s *RecipeService) WaitForGeneration(ctx context.Context, recipeID uint, user *models.User) (*GenerationStatusResponse, error) {
+	if events, stop, ok := s.generations.watch(recipeID); ok {
+		defer stop()
+	wait:
+		for {
+			select {
+			case event, open := <-events:
+				// A failed generation closes the stream once the recipe is marked failed
+				if !open || event.Type == GenerationRecipeComplete {
+					break wait
+				}
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+	}
+
+	// The generation already finished, or it just did
+	return s.GetGenerationStatus(recipeID, user)
+}
+
+// generationPlan is how a recipe generation is paid for.
+type generationPlan struct {
+	apiKey string // The user's personal OpenAI key, empty to use the platform keys
+	model  string // Overrides the recipe model when set
+	// cacheKey is the generation cache key, empty to skip the cache
+	cacheKey string
+	// history is regenerated from instead of generating a new recipe, when set
+	history []models.RecipeHistoryEntry
+	// pantry is the user's ingredients on hand the recipe is generated from, when set
+	pantry []string
+	// logger logs the generation with the ID of the request that started it
+	logger *slog.Logger
+	// refund gives back what the request that started the generation was charged for it, if it fails
+	refund func()
+}
+
+// planGeneration decides the API key and model of a user's recipe generation.
+// On the platform keys the model depends on the user's subscription tier, a personal key gets the configured model.
+// With a personal key and a monthly spend cap, the model is downgraded when the estimated cost doesn't fit the rest of
+// the month's budget, and the generation is refused when not even the downgraded one does.
+func (s *RecipeService) planGeneration(ctx context.Context, user *models.User) (*generationPlan, error) {
+	logger := logging.FromContext(ctx)
+	refund := func() { RefundGeneration(ctx) }
+	settings := user.Settings
+	if settings == nil || !settings.UsesOpenAIKey() {
+		// The platform keys generate with the model of the user's subscription tier
+		return &generationPlan{model: s.Cfg.Models.RecipeModelFor(subscriptionTier(user)), logger: logger, refund: refund}, nil
+	}
+
+	keyring, err := util.OpenAIKeyringFromConfig(s.Cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load OpenAI key encryption keys: %w", err)
+	}
+	apiKey, err := util.DecryptOpenAIKey(keyring, settings.EncryptedOpenAIKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt personal OpenAI key: %w", err)
+	}
+	plan := &generationPlan{apiKey: apiKey, logger: logger, refund: refund}
+
+	if settings.MonthlySpendCapCents <= 0 {
+		return plan, nil
+	}
+
+	// Cents to micro-dollars
+	capMicros := int64(settings.MonthlySpendCapCents) * 10000
+	remainingMicros := capMicros - settings.PersonalKeySpendMicrosIn(monthStart(time.Now()))
+
+	switch {
+	case openai.EstimateGenerationCostMicros(s.Cfg, openai.RecipeModel(s.Cfg)) <= remainingMicros:
+	case openai.EstimateGenerationCostMicros(s.Cfg, openai.BudgetRecipeModel) <= remainingMicros:
+		logger.Info("user is near their monthly spend cap, generating with the budget model", "user_id", user.ID, "model", openai.BudgetRecipeModel)
+		plan.model = openai.BudgetRecipeModel
+	default:
+		return nil, TooManyRequestsError{message: fmt.Sprintf("Your monthly spend cap of $%.2f for your personal OpenAI key has been reached, it resets at the start of next month", float64(settings.MonthlySpendCapCents)/100)}
+	}
+
+	return plan, nil
+}
+
+// recordPersonalKeySpend adds the estimated cost of a generation to the user's monthly spend on their personal key.
+func (s *RecipeService) recordPersonalKeySpend(userID uint, recipeManager *openai.RecipeManager, logger *slog.Logger) {
+	spendMicros := atomic.LoadInt64(&recipeManager.SpendMicros)
+	if spendMicros == 0 {
+		return
+	}
+
+	if err := s.UserRepo.AddPersonalKeySpend(userID, monthStart(time.Now()), spendMicros); err != nil {
+		logger.Error("recording personal key spend", "user_id", userID, "error", err)
+	}
+}
+
+// recordTokenUsage stores the OpenAI usage of a recipe's generation or change, for the user's usage report.
+func (s *RecipeService) recordTokenUsage(userID uint, recipeID uint, recipeManager *openai.RecipeManager, personalKey bool, logger *slog.Logger) {
+	for _, usage := range recipeManager.Usage() {
+		tokenUsage := &models.TokenUsage{
+			UserID:           userID,
+			RecipeID:         recipeID,
+			APIModel:         usage.Model,
+			PromptTokens:     usage.PromptTokens,
+			CompletionTokens: usage.CompletionTokens,
+			Images:           usage.Images,
+			CostMicros:       usage.CostMicros,
+			PersonalKey:      personalKey,
+		}
+		if err := s.Repo.CreateTokenUsage(tokenUsage); err != nil {
+			logger.Error("recording token usage", "recipe_id", recipeID, "user_id", userID, "error", err)
+		}
+	}
+}
+
+// usePlaceholderImage sets the configured placeholder as the recipe image, if there is one.
+func (s *RecipeService) usePlaceholderImage(recipeID uint) error {
+	placeholderURL := s.Cfg.Images.PlaceholderURL
+	if placeholderURL == "" {
+		return nil
+	}
+
+	if err := s.Repo.UpdateRecipeImageURL(recipeID, placeholderURL, ""); err != nil {
+		return err
+	}
+	s.invalidateCachedRecipe(recipeID)
+
+	return nil
+}
+
+// deferImageUpload sets the placeholder image and marks the recipe for a later image upload.
+func (s *RecipeService) deferImageUpload(recipeID uint) error {
+	if err := s.usePlaceholderImage(recipeID); err != nil {
+		return fmt.Errorf("failed to set placeholder image: %w", err)
+	}
+
+	return s.Repo.UpdateRecipeImageUploadPending(recipeID, true)
+}
+
+// GetRecipeImage fetches a recipe's image from S3.
+func (s *RecipeService) GetRecipeImage(recipeID uint) ([]byte, error) {
+	recipe, err := s.Repo.GetRecipeByID(recipeID)
+	if err != nil {
+		return nil, err
+	}
+
+	if recipe.ImageURL == "" {
+		return nil, repository.NewNotFoundError("Recipe has no image")
+	}
+
+	return s3.GetRecipeImageFromS3(s.Cfg, recipeImageKey(recipe.ID, recipe.ImageKey))
+}
+
+// RecipeImageURLResponse is the URL a recipe's image can be loaded from, with when it expires if it's presigned.
+type RecipeImageURLResponse struct {
+	ImageURL  string     `json:"image_url"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// GetRecipeImageURL returns the URL a recipe's image can be loaded from. With presigned images enabled, an uploaded
+// image gets a presigned URL that expires, otherwise the recipe's image URL is returned as is.
+func (s *RecipeService) GetRecipeImageURL(recipeID uint) (*RecipeImageURLResponse, error) {
+	recipe, err := s.Repo.GetRecipeByID(recipeID)
+	if err != nil {
+		return nil, err
+	}
+
+	if recipe.ImageURL == "" {
+		return nil, repository.NewNotFoundError("Recipe has no image")
+	}
+
+	// Placeholders and images still being uploaded aren't in the bucket
+	if !s.Cfg.Images.Presigned.Enabled || !s.hasUploadedImage(recipe) {
+		return &RecipeImageURLResponse{ImageURL: recipe.ImageURL}, nil
+	}
+
+	imageURL, expiresAt, err := s3.GeneratePresignedRecipeImageURL(s.Cfg, recipeImageKey(recipe.ID, recipe.ImageKey))
+	if err != nil {
+		return nil, err
+	}
+
+	return &RecipeImageURLResponse{ImageURL: imageURL, ExpiresAt: &expiresAt}, nil
+}
+
+// RegenerateRecipeImage generates a new image for one of the user's recipes from its stored image prompt, without
+// regenerating the recipe, and replaces the old image with it.
+// The options that are set override the user's default image options. Recipes without an image prompt get one from their title.
+func (s *RecipeService) RegenerateRecipeImage(ctx context.Context, user *models.User, recipeID uint, options openai.ImageOptions) (*RecipeResponse, error) {
+	if err := openai.ValidateImageOptions(options); err != nil {
+		return nil, ValidationError{message: err.Error()}
+	}
+
+	recipe, err := s.Repo.GetRecipeByID(recipeID)
+	if err != nil {
+		return nil, err
+	}
+
+	if recipe.CreatedByID != user.ID {
+		return nil, ForbiddenError{message: "Only the owner can regenerate this recipe's image"}
+	}
+
+	if s.Cfg.Images.Disabled {
+		return nil, UnavailableError{message: "Image generation is disabled"}
+	}
+
+	recipeDef := recipe.RecipeDef
+	if strings.TrimSpace(recipeDef.ImagePrompt) == "" {
+		if strings.TrimSpace(recipeDef.Title) == "" {
+			return nil, ValidationError{message: "Recipe has nothing to generate an image from"}
+		}
+		recipeDef.ImagePrompt = fmt.Sprintf("A professional food photograph of %s, plated and ready to serve", recipeDef.Title)
+	}
+
+	plan, err := s.planGeneration(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	imageOptions := userImageOptions(user)
+	if options.Size != "" {
+		imageOptions.Size = options.Size
+	}
+	if options.Quality != "" {
+		imageOptions.Quality = options.Quality
+	}
+	if options.Style != "" {
+		imageOptions.Style = options.Style
+	}
+
+	recipeManager := &openai.RecipeManager{
+		Cfg:            s.Cfg,
+		RecipeDef:      &recipeDef,
+		ImageOptions:   imageOptions,
+		ImageGenerator: s.ImageGenerator,
+	}
+
+	// Generate with the user's personal key, and count what it cost towards their monthly spend
+	if plan.apiKey != "" {
+		if recipeManager.ImageGenerator == nil {
+			recipeManager.ImageGenerator = openai.NewClient(plan.apiKey)
+		}
+		defer s.recordPersonalKeySpend(user.ID, recipeManager, plan.logger)
+	}
+
+	// Record what was used for the user's usage report
+	defer s.recordTokenUsage(user.ID, recipe.ID, recipeManager, plan.apiKey != "", plan.logger)
+
+	ctx, cancel := context.WithTimeout(ctx, s.GenerationTimeout)
+	defer cancel()
+	if err := recipeManager.GenerateRecipeImage(ctx); err != nil {
+		return nil, fmt.Errorf("failed to generate recipe image: %w", err)
+	}
+
+	// The new image gets keys of its own, so clients and CDNs don't keep showing a cached copy of the old one
+	imageURLs, err := s.uploadRecipeImage(recipe.ID, recipeManager.ImageBytes, recipeManager.ImageSize, plan.logger)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.saveRecipeImageURLs(recipe.ID, imageURLs, plan.logger); err != nil {
+		return nil, fmt.Errorf("failed to update recipe image URL: %w", err)
+	}
+
+	// The old image is replaced, an image that fails to delete is only logged
+	if oldKey := recipeImageKey(recipe.ID, recipe.ImageKey); s.hasUploadedImage(recipe) && oldKey != imageURLs.Key {
+		for _, s3Key := range s3.GenerateS3ImageKeys(oldKey) {
+			if err := s3.DeleteRecipeImageFromS3(s.Cfg, s3Key); err != nil {
+				plan.logger.Error("deleting recipe old image from S3", "recipe_id", recipe.ID, "s3_key", s3Key, "error", err)
+			}
+		}
+	}
+	if recipe.ImageUploadPending {
+		if err := s.Repo.UpdateRecipeImageUploadPending(recipe.ID, false); err != nil {
+			plan.logger.Error("clearing recipe image upload pending", "recipe_id", recipe.ID, "error", err)
+		}
+	}
+
+	return s.GetRecipeByID(recipe.ID, user)
+}
+
+// RefineRecipe updates one of the user's recipes per a follow-up prompt, continuing the chat of its history.
+// The refined recipe is appended to the history as a new entry, so the versions before it are kept.
+// The recipe image isn't regenerated.
+func (s *RecipeService) RefineRecipe(ctx context.Context, recipeID uint, user *models.User, followupPrompt string, language string) (*RecipeResponse, error) {
+	followupPrompt = strings.TrimSpace(followupPrompt)
+	if followupPrompt == "" {
+		return nil, ValidationError{message: "Follow-up prompt is required"}
+	}
+
+	if user.Personalization == nil || user.Personalization.ID == 0 {
+		logging.FromContext(ctx).Error("user's Personalization is nil", "user_id", user.ID)
+		return nil, errors.New("user's Personalization is nil")
+	}
+
+	recipe, err := s.Repo.GetRecipeByID(recipeID)
+	if err != nil {
+		return nil, err
+	}
+
+	if recipe.CreatedByID != user.ID {
+		return nil, ForbiddenError{message: "Only the owner can refine this recipe"}
+	}
+
+	if recipe.GenerationStatus != models.GenerationComplete {
+		return nil, ConflictError{message: "Recipe is still being generated"}
+	}
+
+	history, err := s.Repo.GetHistoryByID(recipe.HistoryID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get recipe history: %w", err)
+	}
+
+	// Recipes without a history, such as copies, are refined from their current version
+	entries := history.Entries
+	if len(entries) == 0 {
+		recipeDef := recipe.RecipeDef
+		entries = []models.RecipeHistoryEntry{{
+			RecipeResponse: &recipeDef,
+			Type:           models.RecipeTypeBasedOn,
+		}}
+	}
+
+	plan, err := s.planGeneration(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.GenerationTimeout)
+	defer cancel()
+
+	occasion, _ := models.LookupOccasion(recipe.Occasion)
+	recipeManager := s.newChatRecipeManager(user, followupPrompt, language, recipe.Persona, occasion)
+	recipeManager.Model = plan.model
+	recipeManager.Ctx = ctx
+	recipeManager.RecipeHistoryEntries = entries
+
+	// Generate with the user's personal key, and count what it cost towards their monthly spend
+	if plan.apiKey != "" {
+		if recipeManager.RecipeGenerator == nil {
+			recipeManager.RecipeGenerator = openai.NewClient(plan.apiKey)
+		}
+		defer s.recordPersonalKeySpend(user.ID, recipeManager, plan.logger)
+	}
+
+	// Record what was used for the user's usage report
+	defer s.recordTokenUsage(user.ID, recipe.ID, recipeManager, plan.apiKey != "", plan.logger)
+
+	if err := recipeManager.GenerateRecipeWithRegenChat(); err != nil {
+		return nil, fmt.Errorf("failed to refine recipe: %w", err)
 	}
 
-	// Create a RecipeResponse from the Recipe
-	recipeResponse := toRecipeResponse(recipe)
+	recipe.RecipeDef = *recipeManager.RecipeDef
+	// An estimate outside the enum is left out rather than failing the generation
+	recipe.Difficulty = models.NormalizeDifficulty(recipe.Difficulty)
+	recipe.Allergens = models.NormalizeAllergens(recipe.Allergens)
+	recipe.GeneratedWithModel = recipeManager.GeneratedWithModel
+	recipe.PromptVersion = recipeManager.PromptVersion
+	recipe.History = history
+	if err := validateRecipeCoreFields(recipe); err != nil {
+		return nil, fmt.Errorf("failed to refine recipe: %w", err)
+	}
 
-	return recipeResponse, nil
-}
+	if err := s.Repo.UpdateRecipeDef(recipe, recipeManager.NextRecipeHistoryEntry); err != nil {
+		return nil, fmt.Errorf("failed to save refined recipe: %w", err)
+	}
+	s.invalidateCachedRecipe(recipe.ID)
 
-// HistoryResponse is the response object for recipe history-related operations.
-type HistoryResponse struct {
-	Entries []models.RecipeHistoryEntry `json:"entries"`
+	if err := s.saveSubRecipes(recipe); err != nil {
+		plan.logger.Error("saving sub-recipes of refined recipe", "recipe_id", recipe.ID, "error", err)
+	}
+	if err := s.ResolveLinkSuggestions(recipe); err != nil {
+		plan.logger.Error("resolving link suggestions of refined recipe", "recipe_id", recipe.ID, "error", err)
+	}
+
+	hashtags := append([]string{}, recipe.PinnedHashtags...)
+	hashtags = append(hashtags, recipeManager.RecipeDef.Hashtags...)
+	if err := s.AssociateTagsWithRecipe(recipe, hashtags); err != nil {
+		plan.logger.Error("associating tags with refined recipe", "recipe_id", recipe.ID, "error", err)
+	}
+
+	return s.GetRecipeByID(recipe.ID, user)
 }
 
-// GetRecipeHistoryByID fetches a recipe history by its ID.
-func (s *RecipeService) GetRecipeHistoryByID(historyID uint) (*HistoryResponse, error) {
-	// Fetch the recipe by its ID from the repository
-	history, err := s.Repo.GetHistoryByID(historyID)
+// RenderRecipePDF renders a recipe to a printable PDF and returns it with a filename for the download.
+// If the recipe image can't be fetched in time, the PDF is rendered without it.
+func (s *RecipeService) RenderRecipePDF(ctx context.Context, recipeID uint) ([]byte, string, error) {
+	recipe, err := s.Repo.GetRecipeByID(recipeID)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	historyResponse := &HistoryResponse{Entries: history.Entries}
+	pdfBytes, err := s.renderRecipePDF(ctx, recipe)
+	if err != nil {
+		return nil, "", err
+	}
 
-	return historyResponse, nil
+	return pdfBytes, recipeFilename(recipe, "pdf"), nil
 }
 
-// InitGenerateRecipeWithChat initializes a new recipe with chat.
-func (s *RecipeService) InitGenerateRecipeWithChat(user *models.User, userPrompt string) (*RecipeResponse, error) {
-	if user.Personalization.ID == 0 {
-		log.Printf("user %d Personalization is nil", user.ID)
-		return nil, errors.New("user's Personalization is nil")
-	}
+// renderRecipePDF renders a recipe to a printable PDF, with its uploaded image if it can be fetched within
+// pdfImageFetchTimeout.
+func (s *RecipeService) renderRecipePDF(ctx context.Context, recipe *models.Recipe) ([]byte, error) {
+	var imageBytes []byte
+	// Placeholders and images still being uploaded aren't in the bucket
+	if s.hasUploadedImage(recipe) {
+		ctx, cancel := context.WithTimeout(ctx, pdfImageFetchTimeout)
+		defer cancel()
 
-	// Populate initial fields of the Recipe struct
-	recipe := &models.Recipe{
-		CreatedBy:          user,
-		PersonalizationUID: user.Personalization.UID, // Set from user's existing Personalization
-		History: &models.RecipeHistory{
-			Entries: []models.RecipeHistoryEntry{},
-		},
+		var err error
+		imageBytes, err = s3.GetRecipeImageFromS3WithContext(ctx, s.Cfg, recipeImageKey(recipe.ID, recipe.ImageKey))
+		if err != nil {
+			logging.FromContext(ctx).Warn("fetching recipe image for PDF, rendering without it", "recipe_id", recipe.ID, "error", err)
+			imageBytes = nil
+		}
 	}
 
-	// Create a Recipe with the basic Recipe details
-	if err := s.Repo.CreateRecipe(recipe); err != nil {
-		return nil, fmt.Errorf("failed to save recipe record: %w", err)
+	return pdf.RenderRecipe(recipe, imageBytes)
+}
+
+// DeleteRecipe deletes a recipe by its ID.
+func (s *RecipeService) DeleteRecipe(recipeID uint) error {
+	recipe, err := s.Repo.GetRecipeByID(recipeID)
+	if err != nil {
+		return fmt.Errorf("failed to get recipe: %w", err)
 	}
 
-	recipeResponse := toRecipeResponse(recipe)
+	// Delete the recipe from the database
+	if err := s.Repo.DeleteRecipe(recipeID); err != nil {
+		return fmt.Errorf("failed to delete recipe: %w", err)
+	}
+	s.invalidateCachedRecipe(recipeID)
 
-	go s.FinishGenerateRecipeWithChat(recipe, user, userPrompt)
+	// Delete the recipe image and its variants from S3
+	for _, s3Key := range s3.GenerateS3ImageKeys(recipeImageKey(recipeID, recipe.ImageKey)) {
+		if err := s3.DeleteRecipeImageFromS3(s.Cfg, s3Key); err != nil {
+			return fmt.Errorf("failed to delete recipe image from S3: %w", err)
+		}
+	}
 
-	// The recipe now has an ID generated by the database
-	return recipeResponse, nil
+	return nil
 }
 
-// FinishGenerateRecipeWithChat finishes generating a recipe with chat.
-func (s *RecipeService) FinishGenerateRecipeWithChat(recipe *models.Recipe, user *models.User, userPrompt string) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
-	defer cancel()
+// SoftDeleteRecipe moves one of the user's recipes to the trash, and returns when it'll be purged.
+// Its image is kept, so the recipe can be restored until then.
+func (s *RecipeService) SoftDeleteRecipe(user *models.User, recipeID uint) (time.Time, error) {
+	recipe, err := s.Repo.GetRecipeByID(recipeID)
+	if err != nil {
+		return time.Time{}, err
+	}
 
-	recipeErrChan := make(chan error)
-	imageErrChan := make(chan error)
+	if recipe.CreatedByID != user.ID {
+		return time.Time{}, ForbiddenError{message: "Only the owner can delete this recipe"}
+	}
 
-	recipeManager := &openai.RecipeManager{
-		UserPrompt:   userPrompt,
-		UnitSystem:   user.Personalization.GetUnitSystemText(),
-		Requirements: user.Personalization.Requirements,
-		Cfg:          s.Cfg,
+	if err := s.Repo.DeleteRecipe(recipe.ID); err != nil {
+		return time.Time{}, fmt.Errorf("failed to delete recipe: %w", err)
 	}
+	s.invalidateCachedRecipe(recipe.ID)
 
-	// Goroutine to handle recipe generation
-	go func(ctx context.Context, recipeErrChan chan<- error, imageErrChan chan<- error) {
-		if err := recipeManager.GenerateRecipeWithChat(); err != nil {
-			recipeErrChan <- err
-			return
-		}
+	return time.Now().Add(s.Cfg.Trash.Retention()), nil
+}
 
-		// Goroutine to handle image generation and upload
-		go func(ctx context.Context, imageErrChan chan<- error) {
-			if err := recipeManager.GenerateRecipeImage(); err != nil {
-				imageErrChan <- err
-				return
-			}
+// TrashedRecipeResponse is a recipe in the trash, with when it was deleted and when it'll be purged.
+type TrashedRecipeResponse struct {
+	*RecipeResponse
+	DeletedAt time.Time `json:"deleted_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
 
-			imageErrChan <- nil
-		}(ctx, imageErrChan)
+// ListTrashedRecipesByUser lists a page of the recipes in a user's trash, most recently deleted first, with the total number of them.
+// Only the user can see their trash.
+func (s *RecipeService) ListTrashedRecipesByUser(user *models.User, userID uint, limit, offset int) ([]*TrashedRecipeResponse, int64, error) {
+	if userID != user.ID {
+		return nil, 0, ForbiddenError{message: "Only the owner can see this trash"}
+	}
 
-		if err := populateRecipeCoreFields(recipe, recipeManager); err != nil {
-			recipeErrChan <- err
-			return
-		}
+	retention := s.Cfg.Trash.Retention()
+	recipes, total, err := s.Repo.ListTrashedRecipesByUser(userID, time.Now().Add(-retention), limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
 
-		if err := s.Repo.UpdateRecipeDef(recipe, recipeManager.NextRecipeHistoryEntry); err != nil {
-			recipeErrChan <- err
-			return
-		}
+	trashed := make([]*TrashedRecipeResponse, 0, len(recipes))
+	for i, recipeResponse := range s.toRecipeResponses(recipes) {
+		deletedAt := *recipes[i].DeletedAt
+		trashed = append(trashed, &TrashedRecipeResponse{
+			RecipeResponse: recipeResponse,
+			DeletedAt:      deletedAt,
+			ExpiresAt:      deletedAt.Add(retention),
+		})
+	}
 
-		if err := s.AssociateTagsWithRecipe(recipe, recipeManager.RecipeDef.Hashtags); err != nil {
-			log.Println(err)
-		}
+	return trashed, total, nil
+}
 
-		recipeErrChan <- nil
-	}(ctx, recipeErrChan, imageErrChan)
+// RestoreRecipe takes one of the user's recipes out of their trash, as long as it hasn't expired.
+func (s *RecipeService) RestoreRecipe(user *models.User, userID uint, recipeID uint) (*RecipeResponse, error) {
+	if userID != user.ID {
+		return nil, ForbiddenError{message: "Only the owner can restore from this trash"}
+	}
 
-	// Wait for the recipe generation goroutine to finish or timeout
-	select {
-	case err := <-recipeErrChan:
-		if err != nil {
-			recipeID := recipe.ID
-			log.Printf("Error finishing recipe %d generation: %v", recipeID, err)
-			e := s.DeleteRecipe(recipeID)
-			if e != nil {
-				log.Printf("error: failed to delete recipe %d: %v", recipeID, e)
-				return
-			}
-			log.Printf("recipe %d deleted", recipeID)
-			return
-		}
-		// Offloading failed recipes to frontend, Frontend will look for new recipe history entries
-		// if err := s.Repo.UpdateRecipeGenerationStatus(recipe.ID, true); err != nil {
-		// 	log.Printf("error: failed to update GenerationComplete: %v", err)
-		// 	return
-		// }
-	case <-ctx.Done():
-		err := errors.New("incomplete recipe generation: timed out after 5 minutes")
-		recipeID := recipe.ID
-		log.Printf("Error finishing recipe %d generation: %v", recipeID, err)
-		e := s.DeleteRecipe(recipeID)
-		if e != nil {
-			log.Printf("error: failed to delete recipe %d: %v", recipeID, e)
-			return
-		}
-		log.Printf("recipe %d deleted", recipeID)
-		return
+	recipe, err := s.Repo.GetTrashedRecipeByID(recipeID)
+	if err != nil {
+		return nil, err
 	}
 
-	// Wait for the image generation goroutine to finish or timeout
-	select {
-	case err := <-imageErrChan:
-		if err != nil {
-			log.Println(err)
-			return
-		}
+	if recipe.CreatedByID != user.ID {
+		return nil, ForbiddenError{message: "Only the owner can restore this recipe"}
+	}
 
-		var recipeImageURL string
-		if imageURL, err := uploadRecipeImage(recipe.ID, recipeManager, s.Cfg); err != nil {
-			log.Println(err)
-			return
-		} else {
-			recipeImageURL = imageURL
-		}
+	// Failed generations, removed recipes, and expired recipes are waiting to be purged, they can't come back
+	expired := time.Since(*recipe.DeletedAt) > s.Cfg.Trash.Retention()
+	if recipe.GenerationStatus == models.GenerationFailed || recipe.ModeratedByID != nil || expired {
+		return nil, repository.NewNotFoundError("Recipe not found in trash")
+	}
 
-		if err := s.Repo.UpdateRecipeImageURL(recipe.ID, recipeImageURL); err != nil {
-			log.Println(err)
-			return
-		}
-	case <-ctx.Done():
-		err := errors.New("incomplete recipe image generation: timed out after 5 minutes")
-		log.Println(err)
-		return
+	if err := s.Repo.RestoreRecipe(recipe.ID); err != nil {
+		return nil, fmt.Errorf("failed to restore recipe: %w", err)
 	}
+	s.invalidateCachedRecipe(recipe.ID)
+
+	return s.GetRecipeByID(recipe.ID, user)
 }
 
-// DeleteRecipe deletes a recipe by its ID.
-func (s *RecipeService) DeleteRecipe(recipeID uint) error {
-	// Delete the recipe from the database
-	if err := s.Repo.DeleteRecipe(recipeID); err != nil {
-		return fmt.Errorf("failed to delete recipe: %w", err)
+// saveSubRecipes replaces the sub-recipes a recipe owns with those of its current recipe def, each stored as a recipe
+// of its own and linked to it. Sub-recipes without a title or ingredients are skipped.
+func (s *RecipeService) saveSubRecipes(recipe *models.Recipe) error {
+	subRecipes := make([]*models.Recipe, 0, len(recipe.SubRecipes))
+	for _, recipeDef := range recipe.SubRecipes {
+		if strings.TrimSpace(recipeDef.Title) == "" || len(recipeDef.Ingredients) == 0 {
+			continue
+		}
+		recipeDef.SubRecipes = nil
+		recipeDef.Difficulty = models.NormalizeDifficulty(recipeDef.Difficulty)
+		recipeDef.Allergens = models.NormalizeAllergens(recipeDef.Allergens)
+		entryDef := recipeDef
+
+		subRecipes = append(subRecipes, &models.Recipe{
+			RecipeDef:          recipeDef,
+			UnitSystem:         recipe.UnitSystem,
+			ImageURL:           s.Cfg.Images.PlaceholderURL,
+			CreatedByID:        recipe.CreatedByID,
+			PersonalizationUID: recipe.PersonalizationUID,
+			CreateType:         recipe.CreateType,
+			GeneratedWithModel: recipe.GeneratedWithModel,
+			PromptVersion:      recipe.PromptVersion,
+			History: &models.RecipeHistory{
+				Entries: []models.RecipeHistoryEntry{{
+					UserPrompt:     fmt.Sprintf("Part of %s", recipe.Title),
+					RecipeResponse: &entryDef,
+					Type:           recipe.CreateType,
+				}},
+			},
+		})
 	}
 
-	// Delete the recipe image from S3
-	s3Key := s3.GenerateS3Key(recipeID)
-	if err := s3.DeleteRecipeImageFromS3(s.Cfg, s3Key); err != nil {
-		return fmt.Errorf("failed to delete recipe image from S3: %w", err)
+	if err := s.Repo.ReplaceSubRecipes(recipe.ID, subRecipes); err != nil {
+		return err
 	}
+	s.invalidateCachedRecipe(recipe.ID)
 
 	return nil
 }
@@ -253,6 +1509,12 @@ func populateRecipeCoreFields(recipe *models.Recipe, recipeManager *openai.Recip
 	// recipe.ImagePrompt = recipeManager.RecipeDef.ImagePrompt
 
 	recipe.RecipeDef = *recipeManager.RecipeDef
+	// An estimate outside the enum is left out rather than failing the generation
+	recipe.Difficulty = models.NormalizeDifficulty(recipe.Difficulty)
+	recipe.Allergens = models.NormalizeAllergens(recipe.Allergens)
+	reconcilePantryIngredients(&recipe.RecipeDef, recipeManager.PantryIngredients)
+	recipe.GeneratedWithModel = recipeManager.GeneratedWithModel
+	recipe.PromptVersion = recipeManager.PromptVersion
 
 	if recipe.History == nil {
 		return errors.New("recipe history is nil")
@@ -277,25 +1539,346 @@ func validateRecipeCoreFields(recipe *models.Recipe) error {
 	return nil
 }
 
-// uploadRecipeImage uploads the recipe image to S3 and returns the new image URL.
-func uploadRecipeImage(recipeId uint, recipeManager *openai.RecipeManager, cfg *config.Config) (string, error) {
-	s3Key := s3.GenerateS3Key(recipeId)
-	imageURL, err := s3.UploadRecipeImageToS3(cfg, recipeManager.ImageBytes, s3Key)
+// recipeImageURLs are the URLs of an uploaded recipe image and its variants, with the S3 key of the image.
+// A variant's URL is empty if it couldn't be created or uploaded.
+type recipeImageURLs struct {
+	Image     string
+	Thumbnail string
+	WebP      string
+	Key       string
+}
+
+// uploadRecipeImage uploads the recipe image to S3, with the size it was generated at in its metadata,
+// along with its thumbnail and WebP variants, and returns the new image URLs.
+// Only a failure to upload the image itself is returned, the variants are best-effort.
+func (s *RecipeService) uploadRecipeImage(recipeId uint, imageBytes []byte, imageSize string, logger *slog.Logger) (recipeImageURLs, error) {
+	s3Key := s3.GenerateS3Key(s.Cfg, recipeId, imageBytes)
+	metadata := map[string]string{}
+	if imageSize != "" {
+		metadata["image-size"] = imageSize
+	}
+	imageURL, err := s3.UploadRecipeImageWithRetry(s.Cfg, s.imageUploads, imageBytes, s3Key, metadata)
+	if err != nil {
+		return recipeImageURLs{}, errors.New("failed to upload image to S3: " + err.Error())
+	}
+
+	urls := recipeImageURLs{Image: imageURL, Key: s3Key}
+	urls.Thumbnail, urls.WebP = s.uploadRecipeImageVariants(recipeId, s3Key, imageBytes, metadata, logger)
+
+	return urls, nil
+}
+
+// uploadRecipeImageVariants creates the thumbnail and WebP variants of a recipe image and uploads them to S3, under
+// keys derived from the image's, returning their URLs. A variant that fails is logged and its URL left empty.
+func (s *RecipeService) uploadRecipeImageVariants(recipeID uint, s3Key string, imgBytes []byte, metadata map[string]string, logger *slog.Logger) (string, string) {
+	img, err := imaging.Decode(imgBytes)
+	if err != nil {
+		logger.Error("decoding recipe image for its variants", "recipe_id", recipeID, "error", err)
+		return "", ""
+	}
+
+	var thumbnailURL, webpURL string
+
+	if thumbBytes, err := imaging.Thumbnail(img, s.Cfg.Images.ThumbnailWidth); err != nil {
+		logger.Error("creating recipe image thumbnail", "recipe_id", recipeID, "error", err)
+	} else if thumbnailURL, err = s3.UploadRecipeImageWithRetry(s.Cfg, s.imageUploads, thumbBytes, s3.GenerateS3ThumbnailKey(s3Key), metadata); err != nil {
+		logger.Error("uploading recipe image thumbnail to S3", "recipe_id", recipeID, "error", err)
+	}
+
+	if webpBytes, err := imaging.EncodeWebP(img, s.Cfg.Images.WebPQuality); err != nil {
+		logger.Error("creating recipe WebP image", "recipe_id", recipeID, "error", err)
+	} else if webpURL, err = s3.UploadRecipeImageWithRetry(s.Cfg, s.imageUploads, webpBytes, s3.GenerateS3WebPKey(s3Key), metadata); err != nil {
+		logger.Error("uploading recipe WebP image to S3", "recipe_id", recipeID, "error", err)
+	}
+
+	return thumbnailURL, webpURL
+}
+
+// saveRecipeImageURLs stores the URLs and key of a recipe's uploaded image and its variants.
+// Failing to store the variant URLs is only logged, the recipe still has its image.
+func (s *RecipeService) saveRecipeImageURLs(recipeID uint, urls recipeImageURLs, logger *slog.Logger) error {
+	if err := s.Repo.UpdateRecipeImageURL(recipeID, urls.Image, urls.Key); err != nil {
+		return err
+	}
+
+	if err := s.Repo.UpdateRecipeImageVariantURLs(recipeID, urls.Thumbnail, urls.WebP); err != nil {
+		logger.Error("updating recipe image variant URLs", "recipe_id", recipeID, "error", err)
+	}
+	s.invalidateCachedRecipe(recipeID)
+
+	return nil
+}
+
+// recipeImageKey returns the S3 key of a recipe's uploaded image, given the recipe ID and its stored image key.
+// Images uploaded before their keys were stored are under the legacy key.
+func recipeImageKey(recipeID uint, imageKey string) string {
+	if imageKey == "" {
+		return s3.GenerateLegacyS3Key(recipeID)
+	}
+	return imageKey
+}
+
+// hasUploadedImage returns whether a recipe's image is in S3, unlike a placeholder or an image waiting to be uploaded.
+func (s *RecipeService) hasUploadedImage(recipe *models.Recipe) bool {
+	return recipe.ImageURL != "" && recipe.ImageURL != s.Cfg.Images.PlaceholderURL && !recipe.ImageUploadPending
+}
+
+// applyImageFields sets the RecipeResponse fields that depend on how images are served. With presigned images enabled,
+// the URLs of an uploaded image and its variants are presigned at response time, since the bucket is private and
+// presigned URLs expire, so they're never cached with the recipe.
+func (s *RecipeService) applyImageFields(recipeResponse *RecipeResponse, recipe *models.Recipe) {
+	recipeResponse.ImagesDisabled = s.Cfg.Images.Disabled
+
+	if !s.Cfg.Images.Presigned.Enabled || !s.hasUploadedImage(recipe) {
+		return
+	}
+
+	imageKey := recipeImageKey(recipe.ID, recipe.ImageKey)
+	recipeResponse.ImageURL = s.presignRecipeImageURL(recipe.ID, recipe.ImageURL, imageKey)
+	recipeResponse.ThumbnailURL = s.presignRecipeImageURL(recipe.ID, recipe.ThumbnailURL, s3.GenerateS3ThumbnailKey(imageKey))
+	recipeResponse.WebPURL = s.presignRecipeImageURL(recipe.ID, recipe.WebPURL, s3.GenerateS3WebPKey(imageKey))
+}
+
+// presignRecipeImageURL returns a presigned URL for the recipe image under the S3 key, if it has a URL at all.
+// A URL that can't be presigned is left out rather than handing out one the private bucket would refuse.
+func (s *RecipeService) presignRecipeImageURL(recipeID uint, imageURL string, s3Key string) string {
+	if imageURL == "" {
+		return ""
+	}
+
+	presignedURL, _, err := s3.GeneratePresignedRecipeImageURL(s.Cfg, s3Key)
+	if err != nil {
+		slog.Default().Error("presigning recipe image URL", "recipe_id", recipeID, "s3_key", s3Key, "error", err)
+		return ""
+	}
+	return presignedURL
+}
+
+// RetagRecipe asks OpenAI for fresh hashtags based on the recipe's current content and re-associates them.
+// Hashtags pinned by the owner are always kept. If pinnedHashtags is not nil, it replaces the pinned set.
+func (s *RecipeService) RetagRecipe(ctx context.Context, user *models.User, recipeID uint, pinnedHashtags []string) (*RecipeResponse, error) {
+	recipe, err := s.Repo.GetRecipeByID(recipeID)
+	if err != nil {
+		return nil, err
+	}
+
+	if recipe.CreatedByID != user.ID {
+		return nil, ForbiddenError{message: "Only the owner can retag this recipe"}
+	}
+
+	// Replace the pinned hashtags if new ones were provided
+	if pinnedHashtags != nil {
+		cleanedPinnedHashtags := make([]string, 0, len(pinnedHashtags))
+		for _, hashtag := range pinnedHashtags {
+			if cleanedHashtag := cleanHashtag(hashtag); cleanedHashtag != "" {
+				cleanedPinnedHashtags = append(cleanedPinnedHashtags, cleanedHashtag)
+			}
+		}
+
+		if err := s.Repo.UpdateRecipePinnedHashtags(recipe.ID, cleanedPinnedHashtags); err != nil {
+			return nil, fmt.Errorf("failed to update pinned hashtags: %w", err)
+		}
+		s.invalidateCachedRecipe(recipe.ID)
+		recipe.PinnedHashtags = cleanedPinnedHashtags
+	}
+
+	plan, err := s.planGeneration(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	recipeDef := recipe.RecipeDef
+	recipeManager := &openai.RecipeManager{
+		Cfg:             s.Cfg,
+		RecipeDef:       &recipeDef,
+		RecipeGenerator: s.RecipeGenerator,
+	}
+
+	// Generate with the user's personal key, and count what it cost towards their monthly spend
+	if plan.apiKey != "" {
+		if recipeManager.RecipeGenerator == nil {
+			recipeManager.RecipeGenerator = openai.NewClient(plan.apiKey)
+		}
+		defer s.recordPersonalKeySpend(user.ID, recipeManager, plan.logger)
+	}
+
+	// Record what was used for the user's usage report
+	defer s.recordTokenUsage(user.ID, recipe.ID, recipeManager, plan.apiKey != "", plan.logger)
+
+	if err := recipeManager.GenerateRecipeHashtags(); err != nil {
+		return nil, fmt.Errorf("failed to generate hashtags: %w", err)
+	}
+
+	hashtags := append([]string{}, recipe.PinnedHashtags...)
+	hashtags = append(hashtags, recipeManager.RecipeDef.Hashtags...)
+	if err := s.AssociateTagsWithRecipe(recipe, hashtags); err != nil {
+		return nil, err
+	}
+
+	return s.GetRecipeByID(recipe.ID, user)
+}
+
+// RecipeMadeResponse is the response object for logging that a recipe was made.
+type RecipeMadeResponse struct {
+	ID        uint      `json:"ID"`
+	RecipeID  uint      `json:"recipe_id"`
+	MadeAt    time.Time `json:"made_at"`
+	Notes     string    `json:"notes"`
+	Rating    *int      `json:"rating"`
+	MadeCount int       `json:"made_count"`
+}
+
+// LogRecipeMade records that the user made a recipe and returns how many times they've made it.
+// MadeAt defaults to now when nil.
+func (s *RecipeService) LogRecipeMade(user *models.User, recipeID uint, madeAt *time.Time, notes string, rating *int) (*RecipeMadeResponse, error) {
+	if rating != nil && (*rating < 1 || *rating > 5) {
+		return nil, ValidationError{message: "rating must be from 1 to 5"}
+	}
+
+	now := time.Now()
+	if madeAt == nil {
+		madeAt = &now
+	} else if madeAt.After(now) {
+		return nil, ValidationError{message: "made_at can't be in the future"}
+	}
+
+	// Make sure the recipe exists
+	if _, err := s.Repo.GetRecipeByID(recipeID); err != nil {
+		return nil, err
+	}
+
+	made := &models.RecipeMade{
+		RecipeID: recipeID,
+		UserID:   user.ID,
+		MadeAt:   madeAt.UTC(),
+		Notes:    strings.TrimSpace(notes),
+		Rating:   rating,
+	}
+	if err := s.Repo.CreateRecipeMade(made); err != nil {
+		return nil, fmt.Errorf("failed to log recipe made: %w", err)
+	}
+
+	madeCount, err := s.Repo.CountRecipeMadeByUser(recipeID, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count recipe made: %w", err)
+	}
+
+	return &RecipeMadeResponse{
+		ID:        made.ID,
+		RecipeID:  made.RecipeID,
+		MadeAt:    made.MadeAt,
+		Notes:     made.Notes,
+		Rating:    made.Rating,
+		MadeCount: madeCount,
+	}, nil
+}
+
+// ExplainRecipe explains the techniques behind a recipe's key steps in the given language.
+// Explanations are cached per recipe and language, so only the first request calls OpenAI, paid for like a generation
+// of the user who made it.
+func (s *RecipeService) ExplainRecipe(ctx context.Context, user *models.User, recipeID uint, language string) (*models.RecipeExplanation, error) {
+	explanation, err := s.Repo.GetRecipeExplanation(recipeID, language)
+	if err == nil {
+		return explanation, nil
+	}
+	if _, ok := err.(repository.NotFoundError); !ok {
+		return nil, err
+	}
+
+	key := fmt.Sprintf("%d:%s", recipeID, language)
+	value, err, _ := s.explanations.Do(key, func() (interface{}, error) {
+		return s.generateRecipeExplanation(ctx, user, recipeID, language)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return value.(*models.RecipeExplanation), nil
+}
+
+// generateRecipeExplanation asks OpenAI to explain a recipe for the user and caches the explanation.
+func (s *RecipeService) generateRecipeExplanation(ctx context.Context, user *models.User, recipeID uint, language string) (*models.RecipeExplanation, error) {
+	recipe, err := s.Repo.GetRecipeByID(recipeID)
+	if err != nil {
+		return nil, err
+	}
+
+	plan, err := s.planGeneration(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	recipeDef := recipe.RecipeDef
+	recipeManager := &openai.RecipeManager{
+		Cfg:             s.Cfg,
+		RecipeDef:       &recipeDef,
+		Language:        language,
+		RecipeGenerator: s.RecipeGenerator,
+	}
+
+	// Generate with the user's personal key, and count what it cost towards their monthly spend
+	if plan.apiKey != "" {
+		if recipeManager.RecipeGenerator == nil {
+			recipeManager.RecipeGenerator = openai.NewClient(plan.apiKey)
+		}
+		defer s.recordPersonalKeySpend(user.ID, recipeManager, plan.logger)
+	}
+
+	// Record what was used for the user's usage report
+	defer s.recordTokenUsage(user.ID, recipe.ID, recipeManager, plan.apiKey != "", plan.logger)
+
+	if err := recipeManager.ExplainRecipe(); err != nil {
+		return nil, fmt.Errorf("failed to explain recipe: %w", err)
+	}
+
+	explanation := &models.RecipeExplanation{
+		RecipeID:   recipe.ID,
+		Language:   language,
+		Summary:    recipeManager.Explanation.Summary,
+		Techniques: recipeManager.Explanation.Techniques,
+	}
+
+	// The explanation is still returned if it can't be cached
+	if err := s.Repo.CreateRecipeExplanation(explanation); err != nil {
+		plan.logger.Error("caching recipe explanation", "recipe_id", recipe.ID, "error", err)
+	}
+
+	return explanation, nil
+}
+
+// NormalizeTags re-cleans every existing tag with the current hashtag cleaning, merging tags that collide.
+func (s *RecipeService) NormalizeTags() (*repository.TagNormalizationResult, error) {
+	result, err := s.Repo.NormalizeTags(cleanHashtag)
 	if err != nil {
-		return "", errors.New("failed to upload image to S3: " + err.Error())
+		return nil, err
 	}
 
-	return imageURL, nil
+	// Any recipe can have a renamed or merged tag
+	s.clearRecipeCache()
+
+	return result, nil
 }
 
 // AssociateTagsWithRecipe checks if each hashtag exists as a Tag in the database.
+// A recipe themed for an occasion is always tagged with the occasion.
 // If it does, it uses the existing Tag's ID and Name.
 func (s *RecipeService) AssociateTagsWithRecipe(recipe *models.Recipe, tags []string) error {
 	var associatedTags []models.Tag
+	seen := make(map[string]bool)
+
+	if occasion, ok := models.LookupOccasion(recipe.Occasion); ok {
+		tags = append([]string{occasion.Hashtag}, tags...)
+	}
 
 	for _, hashtag := range tags {
 		cleanedHashtag := cleanHashtag(hashtag)
 
+		// Skip empty and repeated hashtags
+		if cleanedHashtag == "" || seen[cleanedHashtag] {
+			continue
+		}
+		seen[cleanedHashtag] = true
+
 		// Search for the tag by the cleaned name
 		existingTag, err := s.Repo.FindTagByName(cleanedHashtag)
 		if err == nil {
@@ -314,6 +1897,7 @@ func (s *RecipeService) AssociateTagsWithRecipe(recipe *models.Recipe, tags []st
 	if err := s.Repo.UpdateRecipeTagsAssociation(recipe.ID, associatedTags); err != nil {
 		return fmt.Errorf("failed to update recipe with tags: %v", err)
 	}
+	s.invalidateCachedRecipe(recipe.ID)
 	// recipe.Hashtags = associatedTags
 
 	return nil
@@ -331,24 +1915,97 @@ func toRecipeResponse(r *models.Recipe) *RecipeResponse {
 		forkedFromName = &r.ForkedFrom.Title
 	}
 
+	allergens := []string(r.Allergens)
+	if allergens == nil {
+		allergens = []string{}
+	}
+	pairings := r.Pairings
+	if pairings == nil {
+		pairings = models.Pairings{}
+	}
+
 	return &RecipeResponse{
-		ID:                 r.ID,
-		Title:              r.Title,
-		Ingredients:        r.Ingredients,
-		Instructions:       r.Instructions,
-		CookTime:           r.CookTime,
-		UnitSystem:         r.UnitSystem,
-		LinkedRecipes:      r.LinkedRecipes,
-		LinkedSuggestions:  r.LinkedSuggestions,
-		Hashtags:           r.Hashtags,
-		ImageURL:           r.ImageURL,
-		CreatedByID:        r.CreatedByID,
-		CreatedByUsername:  r.CreatedBy.Username,
-		HistoryID:          r.HistoryID,
-		ForkedFromID:       forkedFromID,
-		ForkedFromName:     forkedFromName,
-		PersonalizationUID: r.PersonalizationUID,
+		ID:                    r.ID,
+		Title:                 r.Title,
+		Ingredients:           r.Ingredients,
+		Instructions:          r.Instructions,
+		CookTime:              r.CookTime,
+		Servings:              r.Servings,
+		Difficulty:            r.Difficulty,
+		Allergens:             allergens,
+		Pairings:              pairings,
+		UsedIngredients:       r.UsedIngredients,
+		AdditionalIngredients: r.AdditionalIngredients,
+		UnitSystem:            r.UnitSystem,
+		LinkedRecipes:         r.LinkedRecipes,
+		ParentRecipeID:        r.ParentRecipeID,
+		LinkedSuggestions:     r.LinkedSuggestions,
+		Hashtags:              r.Hashtags,
+		ImageURL:              r.ImageURL,
+		ThumbnailURL:          r.ThumbnailURL,
+		WebPURL:               r.WebPURL,
+		CreatedByID:           r.CreatedByID,
+		CreatedByUsername:     r.CreatedBy.Username,
+		HistoryID:             r.HistoryID,
+		ForkedFromID:          forkedFromID,
+		ForkedFromName:        forkedFromName,
+		PersonalizationUID:    r.PersonalizationUID,
+		Persona:               r.Persona,
+		Occasion:              r.Occasion,
+		GenerationStatus:      r.GenerationStatus,
+		AverageRating:         r.AverageRating,
+		RatingCount:           r.RatingCount,
+	}
+}
+
+// applyViewerFields sets the RecipeResponse fields that depend on who is viewing the recipe.
+func applyViewerFields(recipeResponse *RecipeResponse, recipe *models.Recipe, viewer *models.User) {
+	if viewer == nil {
+		return
+	}
+
+	if viewer.Personalization != nil {
+		recipeResponse.UserUnitSystem = viewer.Personalization.UnitSystem
+		recipeResponse.UserPersonalizationUID = viewer.Personalization.UID
+
+		// Ingredients are shown in the viewer's unit system, converting a copy since the recipe may be shared
+		recipeResponse.Ingredients = util.ConvertUnits(recipe.Ingredients, viewer.Personalization.UnitSystem)
+		recipeResponse.UnitSystem = viewer.Personalization.UnitSystem
+
+		// The recipe is still shown when it has allergens the viewer excludes, with a warning
+		for _, allergen := range recipe.Allergens {
+			if slices.Contains(viewer.Personalization.AllergenExclusions, allergen) {
+				recipeResponse.AllergenWarnings = append(recipeResponse.AllergenWarnings, allergen)
+			}
+		}
+	}
+
+	// Only the owner gets to see what the recipe was generated from
+	if viewer.ID == recipe.CreatedByID {
+		recipeResponse.UserPrompt = recipe.UserPrompt
+	}
+}
+
+// recipeFilename returns a download filename derived from the recipe title, e.g. "chicken-soup.pdf".
+func recipeFilename(recipe *models.Recipe, extension string) string {
+	var builder strings.Builder
+	lastWasDash := true
+	for _, r := range strings.ToLower(recipe.Title) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			builder.WriteRune(r)
+			lastWasDash = false
+		} else if !lastWasDash {
+			builder.WriteRune('-')
+			lastWasDash = true
+		}
+	}
+
+	name := strings.TrimSuffix(builder.String(), "-")
+	if name == "" {
+		name = fmt.Sprintf("recipe-%d", recipe.ID)
 	}
+
+	return name + "." + extension
 }
 
 // cleanHashtag formats a hashtag string.
@@ -0,0 +1,177 @@
+package service
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/windoze95/saltybytes-api/internal/models"
+)
+
+// RecipeCache caches recipes by their ID, so viewing a popular recipe doesn't read the database every time.
+// Cached recipes are shared between requests, so they must not be mutated.
+// Implementations must be safe for concurrent use.
+type RecipeCache interface {
+	// Get returns the cached recipe, and false if it isn't cached or has expired.
+	Get(recipeID uint) (*models.Recipe, bool)
+	// Set caches the recipe under its ID.
+	Set(recipe *models.Recipe)
+	// Invalidate removes the recipe from the cache.
+	Invalidate(recipeID uint)
+	// Clear removes every recipe from the cache.
+	Clear()
+	// Len returns how many recipes are cached.
+	Len() int
+}
+
+// RecipeCacheStats are the hit and miss counts of the recipe cache.
+type RecipeCacheStats struct {
+	Enabled bool  `json:"enabled"`
+	Hits    int64 `json:"hits"`
+	Misses  int64 `json:"misses"`
+	Entries int   `json:"entries"`
+}
+
+// recipeCacheMetrics counts the hits and misses of the recipe cache, whichever implementation it is.
+type recipeCacheMetrics struct {
+	hits   atomic.Int64
+	misses atomic.Int64
+	// version is bumped by every invalidation, a fetch that raced one isn't cached since it may have read the old recipe
+	version atomic.Uint64
+}
+
+// memoryRecipeCache is a RecipeCache in the memory of this instance.
+// Invalidations don't reach other instances, so the TTL bounds how stale their copies can be.
+type memoryRecipeCache struct {
+	mu         sync.RWMutex
+	entries    map[uint]memoryRecipeCacheEntry
+	ttl        time.Duration
+	maxEntries int
+}
+
+// memoryRecipeCacheEntry is a cached recipe and when it expires.
+type memoryRecipeCacheEntry struct {
+	recipe    *models.Recipe
+	expiresAt time.Time
+}
+
+// NewMemoryRecipeCache creates an in-memory RecipeCache whose recipes expire after the TTL.
+// Once it holds maxEntries recipes, expired ones are evicted first, then arbitrary ones. 0 means no limit.
+func NewMemoryRecipeCache(ttl time.Duration, maxEntries int) RecipeCache {
+	return &memoryRecipeCache{
+		entries:    make(map[uint]memoryRecipeCacheEntry),
+		ttl:        ttl,
+		maxEntries: maxEntries,
+	}
+}
+
+func (c *memoryRecipeCache) Get(recipeID uint) (*models.Recipe, bool) {
+	c.mu.RLock()
+	entry, ok := c.entries[recipeID]
+	c.mu.RUnlock()
+	if !ok || time.Now().After(entry.expiresAt) {
+		return nil, false
+	}
+	return entry.recipe, true
+}
+
+func (c *memoryRecipeCache) Set(recipe *models.Recipe) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if _, exists := c.entries[recipe.ID]; !exists && c.maxEntries > 0 && len(c.entries) >= c.maxEntries {
+		c.evict(now)
+	}
+	c.entries[recipe.ID] = memoryRecipeCacheEntry{recipe: recipe, expiresAt: now.Add(c.ttl)}
+}
+
+// evict makes room for one more recipe, removing every expired recipe, or an arbitrary one if none have expired.
+// The caller must hold the lock.
+func (c *memoryRecipeCache) evict(now time.Time) {
+	for id, entry := range c.entries {
+		if now.After(entry.expiresAt) {
+			delete(c.entries, id)
+		}
+	}
+	for id := range c.entries {
+		if len(c.entries) < c.maxEntries {
+			return
+		}
+		delete(c.entries, id)
+	}
+}
+
+func (c *memoryRecipeCache) Invalidate(recipeID uint) {
+	c.mu.Lock()
+	delete(c.entries, recipeID)
+	c.mu.Unlock()
+}
+
+func (c *memoryRecipeCache) Clear() {
+	c.mu.Lock()
+	c.entries = make(map[uint]memoryRecipeCacheEntry)
+	c.mu.Unlock()
+}
+
+func (c *memoryRecipeCache) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.entries)
+}
+
+// getCachedRecipe fetches a recipe through the cache, reading the database on a miss.
+// Only completed recipes are cached, those still generating change too often to be worth it.
+func (s *RecipeService) getCachedRecipe(recipeID uint) (*models.Recipe, error) {
+	if s.RecipeCache == nil {
+		return s.Repo.GetRecipeByID(recipeID)
+	}
+
+	if recipe, ok := s.RecipeCache.Get(recipeID); ok {
+		s.recipeCacheMetrics.hits.Add(1)
+		return recipe, nil
+	}
+	s.recipeCacheMetrics.misses.Add(1)
+
+	version := s.recipeCacheMetrics.version.Load()
+	recipe, err := s.Repo.GetRecipeByID(recipeID)
+	if err != nil {
+		return nil, err
+	}
+	if recipe.GenerationStatus == models.GenerationComplete && s.recipeCacheMetrics.version.Load() == version {
+		s.RecipeCache.Set(recipe)
+	}
+
+	return recipe, nil
+}
+
+// invalidateCachedRecipe removes a recipe from the cache after it changed, so its next view reads the change.
+func (s *RecipeService) invalidateCachedRecipe(recipeID uint) {
+	if s.RecipeCache == nil {
+		return
+	}
+	s.recipeCacheMetrics.version.Add(1)
+	s.RecipeCache.Invalidate(recipeID)
+}
+
+// clearRecipeCache removes every recipe from the cache, after a change that can touch any of them.
+func (s *RecipeService) clearRecipeCache() {
+	if s.RecipeCache == nil {
+		return
+	}
+	s.recipeCacheMetrics.version.Add(1)
+	s.RecipeCache.Clear()
+}
+
+// GetRecipeCacheStats returns the hit and miss counts of the recipe cache since the API started.
+func (s *RecipeService) GetRecipeCacheStats() RecipeCacheStats {
+	stats := RecipeCacheStats{
+		Enabled: s.RecipeCache != nil,
+		Hits:    s.recipeCacheMetrics.hits.Load(),
+		Misses:  s.recipeCacheMetrics.misses.Load(),
+	}
+	if s.RecipeCache != nil {
+		stats.Entries = s.RecipeCache.Len()
+	}
+	return stats
+}
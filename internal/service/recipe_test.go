@@ -0,0 +1,701 @@
+package service_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+	goopenai "github.com/sashabaranov/go-openai"
+	"github.com/windoze95/saltybytes-api/internal/config"
+	"github.com/windoze95/saltybytes-api/internal/models"
+	"github.com/windoze95/saltybytes-api/internal/openai"
+	"github.com/windoze95/saltybytes-api/internal/openai/openaitest"
+	"github.com/windoze95/saltybytes-api/internal/repository"
+	"github.com/windoze95/saltybytes-api/internal/service"
+	"github.com/windoze95/saltybytes-api/internal/service/servicetest"
+	"github.com/windoze95/saltybytes-api/internal/util"
+)
+
+// testUser returns a user with the ID.
+func testUser(id uint) *models.User {
+	return &models.User{Model: gorm.Model{ID: id}}
+}
+
+func TestGetRecipeHistoryByIDHidesPromptsFromNonOwners(t *testing.T) {
+	repo := &servicetest.MockRecipeRepository{
+		GetHistoryByIDFunc: func(historyID uint) (*models.RecipeHistory, error) {
+			return &models.RecipeHistory{Entries: []models.RecipeHistoryEntry{
+				{UserPrompt: "something with my allergy"},
+				{UserPrompt: "less salt"},
+			}}, nil
+		},
+		GetRecipeOwnerByHistoryIDFunc: func(historyID uint) (uint, error) {
+			if historyID == 2 {
+				return 0, repository.NotFoundError{}
+			}
+			return 1, nil
+		},
+	}
+	s := service.NewRecipeService(&config.Config{}, repo, &servicetest.MockUserRepository{})
+
+	tests := []struct {
+		name        string
+		historyID   uint
+		viewer      *models.User
+		wantPrompts bool
+	}{
+		{"owner", 1, testUser(1), true},
+		{"other user", 1, testUser(2), false},
+		{"anonymous", 1, nil, false},
+		{"owner not found", 2, testUser(1), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			history, err := s.GetRecipeHistoryByID(context.Background(), tt.historyID, tt.viewer)
+			if err != nil {
+				t.Fatalf("GetRecipeHistoryByID: %v", err)
+			}
+			if len(history.Entries) != 2 {
+				t.Fatalf("got %d entries, want 2", len(history.Entries))
+			}
+			for _, entry := range history.Entries {
+				if hasPrompt := entry.UserPrompt != ""; hasPrompt != tt.wantPrompts {
+					t.Fatalf("entry prompt = %q, want prompts %v", entry.UserPrompt, tt.wantPrompts)
+				}
+			}
+		})
+	}
+}
+
+func TestRetagRecipeKeepsPinnedHashtags(t *testing.T) {
+	tests := []struct {
+		name           string
+		pinnedHashtags []string
+		want           []string
+	}{
+		{"existing pins", nil, []string{"grandmas", "pasta", "quick"}},
+		{"new pins", []string{"#Sunday Dinner", " "}, []string{"sundaydinner", "pasta", "quick"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var associated []string
+			repo := &servicetest.MockRecipeRepository{
+				GetRecipeByIDFunc: func(recipeID uint) (*models.Recipe, error) {
+					return &models.Recipe{
+						Model:          gorm.Model{ID: recipeID},
+						CreatedByID:    1,
+						CreatedBy:      testUser(1),
+						PinnedHashtags: pq.StringArray{"grandmas"},
+						RecipeDef:      models.RecipeDef{Title: "Pasta"},
+					}, nil
+				},
+				UpdateRecipePinnedHashtagsFunc: func(recipeID uint, pinnedHashtags []string) error {
+					return nil
+				},
+				FindTagByNameFunc: func(hashtag string) (*models.Tag, error) {
+					return nil, gorm.ErrRecordNotFound
+				},
+				CreateTagFunc: func(tag *models.Tag) error {
+					return nil
+				},
+				UpdateRecipeTagsAssociationFunc: func(recipeID uint, tags []models.Tag) error {
+					for _, tag := range tags {
+						associated = append(associated, tag.Hashtag)
+					}
+					return nil
+				},
+				CreateTokenUsageFunc: func(tokenUsage *models.TokenUsage) error {
+					return nil
+				},
+			}
+			client := &openaitest.MockClient{
+				CreateChatCompletionFunc: func(ctx context.Context, request goopenai.ChatCompletionRequest) (goopenai.ChatCompletionResponse, error) {
+					return openaitest.FunctionCallResponse(request.Model, "create_hashtags", openai.HashtagsFunctionCallArgument{Hashtags: []string{"pasta", "quick"}})
+				},
+			}
+			s := service.NewRecipeService(&config.Config{}, repo, &servicetest.MockUserRepository{})
+			s.RecipeGenerator = client
+
+			if _, err := s.RetagRecipe(context.Background(), testUser(1), 3, tt.pinnedHashtags); err != nil {
+				t.Fatalf("RetagRecipe: %v", err)
+			}
+			if strings.Join(associated, ",") != strings.Join(tt.want, ",") {
+				t.Fatalf("associated hashtags %v, want %v", associated, tt.want)
+			}
+			if len(client.ChatCompletionRequests()) != 1 {
+				t.Fatalf("got %d OpenAI requests, want 1", len(client.ChatCompletionRequests()))
+			}
+		})
+	}
+}
+
+func TestAssociateTagsWithRecipe(t *testing.T) {
+	var created, associated []string
+	repo := &servicetest.MockRecipeRepository{
+		FindTagByNameFunc: func(tagName string) (*models.Tag, error) {
+			if tagName == "pasta" {
+				return &models.Tag{Model: gorm.Model{ID: 4}, Hashtag: "pasta"}, nil
+			}
+			return nil, gorm.ErrRecordNotFound
+		},
+		CreateTagFunc: func(tag *models.Tag) error {
+			created = append(created, tag.Hashtag)
+			return nil
+		},
+		UpdateRecipeTagsAssociationFunc: func(recipeID uint, newTags []models.Tag) error {
+			for _, tag := range newTags {
+				associated = append(associated, tag.Hashtag)
+			}
+			return nil
+		},
+	}
+	s := service.NewRecipeService(&config.Config{}, repo, &servicetest.MockUserRepository{})
+
+	recipe := &models.Recipe{Model: gorm.Model{ID: 3}, Occasion: "game_day"}
+	if err := s.AssociateTagsWithRecipe(recipe, []string{"#Pasta", "Quick Dinner", "pasta", " "}); err != nil {
+		t.Fatalf("AssociateTagsWithRecipe: %v", err)
+	}
+
+	// The occasion's hashtag comes first, the hashtags are cleaned and only associated once, and existing tags are reused
+	if got, want := strings.Join(associated, ","), "gameday,pasta,quickdinner"; got != want {
+		t.Fatalf("associated hashtags %s, want %s", got, want)
+	}
+	if got, want := strings.Join(created, ","), "gameday,quickdinner"; got != want {
+		t.Fatalf("created hashtags %s, want %s", got, want)
+	}
+}
+
+func TestListRecipesForAdminShowsWhatGeneratedRecipes(t *testing.T) {
+	repo := &servicetest.MockRecipeRepository{
+		ListRecipesForAdminFunc: func(filter repository.AdminRecipeFilter, limit, offset int) ([]models.Recipe, int64, error) {
+			return []models.Recipe{{
+				Model:              gorm.Model{ID: 3},
+				CreatedBy:          testUser(1),
+				GeneratedWithModel: "gpt-4o-2024-08-06",
+				PromptVersion:      "0123456789ab",
+			}}, 1, nil
+		},
+	}
+	s := service.NewRecipeService(&config.Config{}, repo, &servicetest.MockUserRepository{})
+
+	recipes, _, err := s.ListRecipesForAdmin(repository.AdminRecipeFilter{}, 10, 0)
+	if err != nil {
+		t.Fatalf("ListRecipesForAdmin: %v", err)
+	}
+	if len(recipes) != 1 {
+		t.Fatalf("got %d recipes, want 1", len(recipes))
+	}
+	if recipes[0].GeneratedWithModel != "gpt-4o-2024-08-06" || recipes[0].PromptVersion != "0123456789ab" {
+		t.Fatalf("got model %q and prompt version %q, want the recipe's", recipes[0].GeneratedWithModel, recipes[0].PromptVersion)
+	}
+}
+
+func TestExplainRecipeIsCachedPerLanguage(t *testing.T) {
+	cached := make(map[string]*models.RecipeExplanation)
+	var usages []models.TokenUsage
+	repo := &servicetest.MockRecipeRepository{
+		GetRecipeExplanationFunc: func(recipeID uint, language string) (*models.RecipeExplanation, error) {
+			if explanation, ok := cached[fmt.Sprintf("%d:%s", recipeID, language)]; ok {
+				return explanation, nil
+			}
+			return nil, repository.NotFoundError{}
+		},
+		CreateRecipeExplanationFunc: func(explanation *models.RecipeExplanation) error {
+			cached[fmt.Sprintf("%d:%s", explanation.RecipeID, explanation.Language)] = explanation
+			return nil
+		},
+		GetRecipeByIDFunc: func(recipeID uint) (*models.Recipe, error) {
+			return &models.Recipe{Model: gorm.Model{ID: recipeID}, RecipeDef: models.RecipeDef{Title: "Risotto"}}, nil
+		},
+		CreateTokenUsageFunc: func(usage *models.TokenUsage) error {
+			usages = append(usages, *usage)
+			return nil
+		},
+	}
+	client := &openaitest.MockClient{
+		CreateChatCompletionFunc: func(ctx context.Context, request goopenai.ChatCompletionRequest) (goopenai.ChatCompletionResponse, error) {
+			response, err := openaitest.FunctionCallResponse(request.Model, "explain_recipe", openai.ExplanationFunctionCallArgument{Summary: "Stir often"})
+			response.Usage = goopenai.Usage{PromptTokens: 100, CompletionTokens: 50}
+			return response, err
+		},
+	}
+	s := service.NewRecipeService(&config.Config{}, repo, &servicetest.MockUserRepository{})
+	s.RecipeGenerator = client
+
+	calls := []struct {
+		language  string
+		wantCalls int
+	}{
+		{"en", 1},
+		{"en", 1}, // Cached
+		{"it", 2}, // Another language is explained separately
+		{"it", 2},
+	}
+	for _, call := range calls {
+		explanation, err := s.ExplainRecipe(context.Background(), testUser(1), 3, call.language)
+		if err != nil {
+			t.Fatalf("ExplainRecipe(%s): %v", call.language, err)
+		}
+		if explanation.Summary != "Stir often" || explanation.Language != call.language {
+			t.Fatalf("got explanation %+v, want the %s one", explanation, call.language)
+		}
+		if got := len(client.ChatCompletionRequests()); got != call.wantCalls {
+			t.Fatalf("after explaining in %s, got %d OpenAI requests, want %d", call.language, got, call.wantCalls)
+		}
+		// Each OpenAI request is recorded against the user who made it
+		if len(usages) != call.wantCalls || usages[len(usages)-1].UserID != 1 || usages[len(usages)-1].RecipeID != 3 {
+			t.Fatalf("after explaining in %s, recorded usage %+v, want %d for user 1 and recipe 3", call.language, usages, call.wantCalls)
+		}
+	}
+}
+
+func TestGetRecipeByIDCoalescesConcurrentFetches(t *testing.T) {
+	var fetches atomic.Int32
+	release := make(chan struct{})
+	repo := &servicetest.MockRecipeRepository{
+		GetRecipeByIDFunc: func(recipeID uint) (*models.Recipe, error) {
+			fetches.Add(1)
+			<-release
+			return &models.Recipe{Model: gorm.Model{ID: recipeID}, CreatedByID: 1, CreatedBy: testUser(1), UserPrompt: "tomato soup"}, nil
+		},
+	}
+	s := service.NewRecipeService(&config.Config{}, repo, &servicetest.MockUserRepository{})
+
+	const viewers = 10
+	var wg sync.WaitGroup
+	responses := make([]*service.RecipeResponse, viewers)
+	errs := make([]error, viewers)
+	for i := 0; i < viewers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// Viewer 1 owns the recipe
+			responses[i], errs[i] = s.GetRecipeByID(3, testUser(uint(i+1)))
+		}(i)
+	}
+	// Give every fetch time to join the one in flight
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if got := fetches.Load(); got != 1 {
+		t.Fatalf("got %d database reads, want 1", got)
+	}
+	for i, response := range responses {
+		if errs[i] != nil {
+			t.Fatalf("GetRecipeByID: %v", errs[i])
+		}
+		// The fields specific to the viewer are applied after the shared fetch
+		if isOwner := i == 0; (response.UserPrompt != "") != isOwner {
+			t.Fatalf("viewer %d got prompt %q, want it only for the owner", i+1, response.UserPrompt)
+		}
+	}
+}
+
+func TestGetRecipeByIDDoesNotKeepErrors(t *testing.T) {
+	fetches := 0
+	repo := &servicetest.MockRecipeRepository{
+		GetRecipeByIDFunc: func(recipeID uint) (*models.Recipe, error) {
+			fetches++
+			if fetches == 1 {
+				return nil, errors.New("connection reset")
+			}
+			return &models.Recipe{Model: gorm.Model{ID: recipeID}, CreatedBy: testUser(1)}, nil
+		},
+	}
+	s := service.NewRecipeService(&config.Config{}, repo, &servicetest.MockUserRepository{})
+
+	if _, err := s.GetRecipeByID(3, nil); err == nil {
+		t.Fatal("GetRecipeByID succeeded, want the database error")
+	}
+	if _, err := s.GetRecipeByID(3, nil); err != nil {
+		t.Fatalf("GetRecipeByID after a failed read: %v", err)
+	}
+	if fetches != 2 {
+		t.Fatalf("got %d database reads, want 2", fetches)
+	}
+}
+
+func TestLogRecipeMadeCountsEntries(t *testing.T) {
+	var logged []models.RecipeMade
+	repo := &servicetest.MockRecipeRepository{
+		GetRecipeByIDFunc: func(recipeID uint) (*models.Recipe, error) {
+			return &models.Recipe{Model: gorm.Model{ID: recipeID}}, nil
+		},
+		CreateRecipeMadeFunc: func(made *models.RecipeMade) error {
+			made.ID = uint(len(logged) + 1)
+			logged = append(logged, *made)
+			return nil
+		},
+		CountRecipeMadeByUserFunc: func(recipeID uint, userID uint) (int, error) {
+			count := 0
+			for _, made := range logged {
+				if made.RecipeID == recipeID && made.UserID == userID {
+					count++
+				}
+			}
+			return count, nil
+		},
+	}
+	s := service.NewRecipeService(&config.Config{}, repo, &servicetest.MockUserRepository{})
+
+	// Made entries are kept over time, each one counted
+	rating := 4
+	lastWeek := time.Now().Add(-7 * 24 * time.Hour)
+	first, err := s.LogRecipeMade(testUser(1), 3, &lastWeek, "  Needed more salt ", &rating)
+	if err != nil {
+		t.Fatalf("LogRecipeMade: %v", err)
+	}
+	if first.MadeCount != 1 || first.Notes != "Needed more salt" || *first.Rating != 4 || !first.MadeAt.Equal(lastWeek.UTC()) {
+		t.Fatalf("got %+v, want the first entry as logged", first)
+	}
+
+	second, err := s.LogRecipeMade(testUser(1), 3, nil, "", nil)
+	if err != nil {
+		t.Fatalf("LogRecipeMade: %v", err)
+	}
+	if second.MadeCount != 2 || second.ID == first.ID {
+		t.Fatalf("got %+v, want a second entry", second)
+	}
+	if time.Since(second.MadeAt) > time.Minute {
+		t.Fatalf("made at %v, want it to default to now", second.MadeAt)
+	}
+
+	// Another user's entries are counted separately
+	other, err := s.LogRecipeMade(testUser(2), 3, nil, "", nil)
+	if err != nil {
+		t.Fatalf("LogRecipeMade: %v", err)
+	}
+	if other.MadeCount != 1 {
+		t.Fatalf("other user's made count = %d, want 1", other.MadeCount)
+	}
+}
+
+func TestLogRecipeMadeInvalid(t *testing.T) {
+	tooHigh, tooLow := 6, 0
+	tomorrow := time.Now().Add(24 * time.Hour)
+
+	tests := []struct {
+		name   string
+		madeAt *time.Time
+		rating *int
+	}{
+		{"rating too high", nil, &tooHigh},
+		{"rating too low", nil, &tooLow},
+		{"made in the future", &tomorrow, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &servicetest.MockRecipeRepository{
+				CreateRecipeMadeFunc: func(made *models.RecipeMade) error {
+					t.Fatal("invalid entry was logged")
+					return nil
+				},
+			}
+			s := service.NewRecipeService(&config.Config{}, repo, &servicetest.MockUserRepository{})
+
+			_, err := s.LogRecipeMade(testUser(1), 3, tt.madeAt, "", tt.rating)
+			if _, ok := err.(service.ValidationError); !ok {
+				t.Fatalf("LogRecipeMade error = %v, want a validation error", err)
+			}
+		})
+	}
+}
+
+func TestCreateWelcomeRecipe(t *testing.T) {
+	template := &models.Recipe{
+		Model:     gorm.Model{ID: 7},
+		RecipeDef: models.RecipeDef{Title: "Welcome Pancakes"},
+		ImageURL:  "https://example.com/pancakes.jpg",
+		Hashtags:  []*models.Tag{{Hashtag: "breakfast"}},
+	}
+	var created *models.Recipe
+	var history models.RecipeHistoryEntry
+	var associated []string
+	repo := &servicetest.MockRecipeRepository{
+		GetRecipeByIDFunc: func(recipeID uint) (*models.Recipe, error) {
+			if recipeID != template.ID {
+				return nil, repository.NotFoundError{}
+			}
+			return template, nil
+		},
+		CreateRecipeFunc: func(recipe *models.Recipe) error {
+			recipe.ID = 20
+			created = recipe
+			return nil
+		},
+		UpdateRecipeDefFunc: func(recipe *models.Recipe, newHistoryEntry models.RecipeHistoryEntry) error {
+			history = newHistoryEntry
+			return nil
+		},
+		FindTagByNameFunc: func(tagName string) (*models.Tag, error) {
+			return nil, gorm.ErrRecordNotFound
+		},
+		CreateTagFunc: func(tag *models.Tag) error {
+			return nil
+		},
+		UpdateRecipeTagsAssociationFunc: func(recipeID uint, newTags []models.Tag) error {
+			for _, tag := range newTags {
+				associated = append(associated, tag.Hashtag)
+			}
+			return nil
+		},
+	}
+	cfg := &config.Config{}
+	cfg.WelcomeRecipe.Enabled = true
+	cfg.WelcomeRecipe.TemplateRecipeID = template.ID
+	// The user repository has no funcs, so spending one of the user's generations would panic
+	s := service.NewRecipeService(cfg, repo, &servicetest.MockUserRepository{})
+
+	user := testUser(3)
+	recipeResponse, err := s.CreateWelcomeRecipe(user)
+	if err != nil {
+		t.Fatalf("CreateWelcomeRecipe: %v", err)
+	}
+	if recipeResponse == nil || recipeResponse.ID != 20 || recipeResponse.Title != "Welcome Pancakes" {
+		t.Fatalf("got recipe %+v, want the copy of the welcome recipe", recipeResponse)
+	}
+	if created.CreatedBy != user || created.ForkedFromID == nil || *created.ForkedFromID != template.ID || created.ImageURL != template.ImageURL {
+		t.Fatalf("created recipe %+v, want the user's copy of the template", created)
+	}
+	if history.Type != models.RecipeTypeBasedOn || history.RecipeResponse == nil || history.RecipeResponse.Title != "Welcome Pancakes" {
+		t.Fatalf("history entry %+v, want the template's recipe", history)
+	}
+	if got := strings.Join(associated, ","); got != "breakfast" {
+		t.Fatalf("associated hashtags %s, want the template's", got)
+	}
+}
+
+func TestCreateWelcomeRecipeDisabled(t *testing.T) {
+	tests := []struct {
+		name             string
+		enabled          bool
+		templateRecipeID uint
+	}{
+		{"disabled", false, 7},
+		{"no template", true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{}
+			cfg.WelcomeRecipe.Enabled = tt.enabled
+			cfg.WelcomeRecipe.TemplateRecipeID = tt.templateRecipeID
+			// The repository has no funcs, so creating a recipe would panic
+			s := service.NewRecipeService(cfg, &servicetest.MockRecipeRepository{}, &servicetest.MockUserRepository{})
+
+			recipeResponse, err := s.CreateWelcomeRecipe(testUser(3))
+			if err != nil || recipeResponse != nil {
+				t.Fatalf("got recipe %+v and error %v, want neither", recipeResponse, err)
+			}
+		})
+	}
+}
+
+func TestScaleRecipe(t *testing.T) {
+	stored := &models.Recipe{
+		Model:     gorm.Model{ID: 5},
+		RecipeDef: models.RecipeDef{Title: "Omelette", Servings: 2, Ingredients: models.Ingredients{{Name: "Eggs", Amount: 3}, {Name: "Milk", Unit: "tbsp", Amount: 2}}},
+		CreatedBy: testUser(1),
+	}
+	repo := &servicetest.MockRecipeRepository{
+		GetRecipeByIDFunc: func(recipeID uint) (*models.Recipe, error) {
+			return stored, nil
+		},
+	}
+	s := service.NewRecipeService(&config.Config{}, repo, &servicetest.MockUserRepository{})
+
+	recipeResponse, factor, err := s.ScaleRecipe(5, nil, 0.5, 0)
+	if err != nil {
+		t.Fatalf("ScaleRecipe: %v", err)
+	}
+	// Half of 3 eggs rounds to whole eggs
+	if got := recipeResponse.Ingredients; got[0].Amount != 2 || got[1].Amount != 1 {
+		t.Fatalf("scaled ingredients %+v, want 2 eggs and 1 tbsp of milk", got)
+	}
+	if factor != 0.5 || recipeResponse.Servings != 1 {
+		t.Fatalf("scaled by %v to serve %d, want 0.5 to serve 1", factor, recipeResponse.Servings)
+	}
+	if stored.Ingredients[0].Amount != 3 || stored.Ingredients[1].Amount != 2 || stored.Servings != 2 {
+		t.Fatalf("stored recipe %+v was changed", stored.RecipeDef)
+	}
+}
+
+func TestScaleRecipeToServings(t *testing.T) {
+	repo := &servicetest.MockRecipeRepository{
+		GetRecipeByIDFunc: func(recipeID uint) (*models.Recipe, error) {
+			recipe := &models.Recipe{
+				Model:      gorm.Model{ID: recipeID},
+				RecipeDef:  models.RecipeDef{Title: "Pancakes", Servings: 4, Ingredients: models.Ingredients{{Name: "Milk", Unit: "cup", Amount: 1}}},
+				UnitSystem: models.USCustomary,
+				CreatedBy:  testUser(1),
+			}
+			// Recipe 9 doesn't say how many it serves
+			if recipeID == 9 {
+				recipe.Servings = 0
+			}
+			return recipe, nil
+		},
+	}
+	s := service.NewRecipeService(&config.Config{}, repo, &servicetest.MockUserRepository{})
+
+	// The scaled recipe is in the viewer's units, like any view of it
+	viewer := testUser(2)
+	viewer.Personalization = &models.Personalization{UnitSystem: models.Metric}
+	recipeResponse, factor, err := s.ScaleRecipe(8, viewer, 0, 8)
+	if err != nil {
+		t.Fatalf("ScaleRecipe: %v", err)
+	}
+	if factor != 2 || recipeResponse.Servings != 8 {
+		t.Fatalf("scaled by %v to serve %d, want 2 to serve 8", factor, recipeResponse.Servings)
+	}
+	if got := recipeResponse.Ingredients[0]; got.Unit != "mL" || got.Amount != 473 {
+		t.Fatalf("got %+v, want 473 mL", got)
+	}
+
+	for _, tt := range []struct {
+		name     string
+		recipeID uint
+		servings int
+	}{
+		{"negative servings", 8, -1},
+		{"too many servings", 8, 201},
+		{"recipe without servings", 9, 2},
+	} {
+		if _, _, err := s.ScaleRecipe(tt.recipeID, viewer, 0, tt.servings); !errors.As(err, &service.ValidationError{}) {
+			t.Fatalf("%s: ScaleRecipe error = %v, want a ValidationError", tt.name, err)
+		}
+	}
+}
+
+func TestScaleRecipeFactorOutOfRange(t *testing.T) {
+	// The repository has no funcs, so the recipe is never fetched
+	s := service.NewRecipeService(&config.Config{}, &servicetest.MockRecipeRepository{}, &servicetest.MockUserRepository{})
+
+	for _, factor := range []float64{0, 0.05, -2, 51, math.NaN(), math.Inf(1)} {
+		if _, _, err := s.ScaleRecipe(5, nil, factor, 0); !errors.As(err, &service.ValidationError{}) {
+			t.Fatalf("ScaleRecipe(%v) error = %v, want a ValidationError", factor, err)
+		}
+	}
+}
+
+func TestGetRecipeByIDConvertsToViewerUnits(t *testing.T) {
+	repo := &servicetest.MockRecipeRepository{
+		GetRecipeByIDFunc: func(recipeID uint) (*models.Recipe, error) {
+			return &models.Recipe{
+				Model:      gorm.Model{ID: recipeID},
+				RecipeDef:  models.RecipeDef{Title: "Pancakes", Ingredients: models.Ingredients{{Name: "Milk", Unit: "cup", Amount: 1}}},
+				UnitSystem: models.USCustomary,
+				CreatedBy:  testUser(1),
+			}, nil
+		},
+	}
+	s := service.NewRecipeService(&config.Config{}, repo, &servicetest.MockUserRepository{})
+
+	viewer := testUser(2)
+	viewer.Personalization = &models.Personalization{UnitSystem: models.Metric}
+	recipeResponse, err := s.GetRecipeByID(8, viewer)
+	if err != nil {
+		t.Fatalf("GetRecipeByID: %v", err)
+	}
+	if got := recipeResponse.Ingredients[0]; got.Unit != "mL" || got.Amount != 236.59 || recipeResponse.UnitSystem != models.Metric {
+		t.Fatalf("got %+v in %v, want 236.59 mL in metric", got, recipeResponse.UnitSystem)
+	}
+
+	// Anonymous viewers get the recipe as it was stored
+	recipeResponse, err = s.GetRecipeByID(8, nil)
+	if err != nil {
+		t.Fatalf("GetRecipeByID: %v", err)
+	}
+	if got := recipeResponse.Ingredients[0]; got.Unit != "cup" || got.Amount != 1 {
+		t.Fatalf("got %+v, want 1 cup", got)
+	}
+}
+
+func TestRecipeResponsesPresignImageURLs(t *testing.T) {
+	t.Setenv("TEST_AWS_REGION", "us-east-1")
+	t.Setenv("TEST_AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("TEST_AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("TEST_S3_BUCKET", "private-bucket")
+	cfg := &config.Config{}
+	cfg.Env.AWSRegion = "TEST_AWS_REGION"
+	cfg.Env.AWSAccessKeyID = "TEST_AWS_ACCESS_KEY_ID"
+	cfg.Env.AWSSecretAccessKey = "TEST_AWS_SECRET_ACCESS_KEY"
+	cfg.Env.S3Bucket = "TEST_S3_BUCKET"
+	cfg.Images.PlaceholderURL = "https://example.com/placeholder.jpg"
+	cfg.Images.Presigned.Enabled = true
+
+	uploaded := models.Recipe{
+		Model:        gorm.Model{ID: 1},
+		RecipeDef:    models.RecipeDef{Title: "Soup"},
+		ImageURL:     "https://private-bucket.s3.amazonaws.com/recipes/1/abc.jpg",
+		ThumbnailURL: "https://private-bucket.s3.amazonaws.com/recipes/1/abc_thumb.jpg",
+		WebPURL:      "https://private-bucket.s3.amazonaws.com/recipes/1/abc.webp",
+		ImageKey:     "recipes/1/abc.jpg",
+		CreatedBy:    testUser(1),
+	}
+	placeholder := models.Recipe{
+		Model:     gorm.Model{ID: 2},
+		RecipeDef: models.RecipeDef{Title: "Stew"},
+		ImageURL:  cfg.Images.PlaceholderURL,
+		CreatedBy: testUser(1),
+	}
+	repo := &servicetest.MockRecipeRepository{
+		GetRecipeByIDFunc: func(recipeID uint) (*models.Recipe, error) {
+			recipe := uploaded
+			return &recipe, nil
+		},
+		ListRecipesByUserFunc: func(userID uint, filter util.RecipeFilter, limit, offset int) ([]models.Recipe, int64, error) {
+			return []models.Recipe{uploaded, placeholder}, 2, nil
+		},
+	}
+	s := service.NewRecipeService(cfg, repo, &servicetest.MockUserRepository{})
+
+	presigned := func(url, key string) bool {
+		return strings.Contains(url, "X-Amz-Signature=") && strings.Contains(url, key)
+	}
+
+	recipeResponse, err := s.GetRecipeByID(1, nil)
+	if err != nil {
+		t.Fatalf("GetRecipeByID: %v", err)
+	}
+	if !presigned(recipeResponse.ImageURL, "recipes/1/abc.jpg") ||
+		!presigned(recipeResponse.ThumbnailURL, "recipes/1/abc_thumb.jpg") ||
+		!presigned(recipeResponse.WebPURL, "recipes/1/abc.webp") {
+		t.Fatalf("got image URLs %q, %q, %q, want them presigned", recipeResponse.ImageURL, recipeResponse.ThumbnailURL, recipeResponse.WebPURL)
+	}
+
+	recipes, _, err := s.ListRecipesByUser(1, util.RecipeFilter{}, 10, 0)
+	if err != nil {
+		t.Fatalf("ListRecipesByUser: %v", err)
+	}
+	if !presigned(recipes[0].ImageURL, "recipes/1/abc.jpg") {
+		t.Fatalf("listed image URL %q, want it presigned", recipes[0].ImageURL)
+	}
+	// Placeholders aren't in the bucket
+	if recipes[1].ImageURL != cfg.Images.PlaceholderURL {
+		t.Fatalf("listed placeholder URL %q, want %q", recipes[1].ImageURL, cfg.Images.PlaceholderURL)
+	}
+
+	export, err := s.ExportRecipe(context.Background(), 1, service.ExportFormatJSON)
+	if err != nil {
+		t.Fatalf("ExportRecipe: %v", err)
+	}
+	var document struct {
+		ImageURL string `json:"image_url"`
+	}
+	if err := json.Unmarshal(export.Data, &document); err != nil {
+		t.Fatalf("unmarshaling export: %v", err)
+	}
+	if !presigned(document.ImageURL, "recipes/1/abc.jpg") {
+		t.Fatalf("exported image URL %q, want it presigned", document.ImageURL)
+	}
+}
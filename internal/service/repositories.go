@@ -0,0 +1,105 @@
+package service
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/windoze95/saltybytes-api/internal/models"
+	"github.com/windoze95/saltybytes-api/internal/repository"
+	"github.com/windoze95/saltybytes-api/internal/util"
+)
+
+// Make sure the repositories implement the interfaces the services depend on.
+var (
+	_ RecipeRepository = (*repository.RecipeRepository)(nil)
+	_ UserRepository   = (*repository.UserRepository)(nil)
+)
+
+// RecipeRepository is the recipe storage RecipeService depends on.
+// It's implemented by repository.RecipeRepository.
+type RecipeRepository interface {
+	GetRecipeByID(recipeID uint) (*models.Recipe, error)
+	GetRecipeWithLinkedRecipes(recipeID uint) (*models.Recipe, error)
+	ListRecipesByUser(userID uint, filter util.RecipeFilter, limit, offset int) ([]models.Recipe, int64, error)
+	ListCollectedRecipesByUser(userID uint, filter util.RecipeFilter, limit, offset int) ([]models.Recipe, int64, error)
+	IsRecipeCollected(userID uint, recipeID uint) (bool, error)
+	CollectRecipe(userID uint, recipeID uint) error
+	UncollectRecipe(userID uint, recipeID uint) error
+	ListRecipesByTag(hashtag string, filter util.RecipeFilter, limit, offset int) ([]models.Recipe, int64, error)
+	ListFeedRecipes(before *util.FeedCursor, filter util.RecipeFilter, limit int) ([]models.Recipe, error)
+	ListPopularTags(limit int) ([]repository.NameCount, error)
+	GetHistoryByID(historyID uint) (*models.RecipeHistory, error)
+	GetRecipeOwnerByHistoryID(historyID uint) (uint, error)
+	CreateRecipe(recipe *models.Recipe) error
+	DeleteRecipe(recipeID uint) error
+	ListTrashedRecipesByUser(userID uint, deletedSince time.Time, limit, offset int) ([]models.Recipe, int64, error)
+	ListRecipesForAdmin(filter repository.AdminRecipeFilter, limit, offset int) ([]models.Recipe, int64, error)
+	ModerateRecipe(recipeID uint, moderatorID uint) error
+	GetTrashedRecipeByID(recipeID uint) (*models.Recipe, error)
+	PurgeTrashedRecipes(deletedBefore time.Time, batchSize int) ([]models.Recipe, error)
+	ListRecipeOwnership(recipeIDs []uint) ([]models.Recipe, error)
+	PurgeRecipes(recipeIDs []uint) error
+	RestoreRecipe(recipeID uint) error
+	UpdateRecipeImageURL(recipeID uint, imageURL, imageKey string) error
+	UpdateRecipeImageVariantURLs(recipeID uint, thumbnailURL, webpURL string) error
+	UpdateRecipeImageUploadPending(recipeID uint, pending bool) error
+	UpdateRecipeGenerationStatus(recipeID uint, status models.GenerationStatus) error
+	GetRecipeGenerationStatus(recipeID uint) (*models.Recipe, error)
+	FindRecentRecipeByPromptHash(userID uint, promptHash string, personalizationUID uuid.UUID, since time.Time) (*models.Recipe, error)
+	UpdateRecipePinnedHashtags(recipeID uint, pinnedHashtags []string) error
+	UpdateRecipePairings(recipeID uint, pairings models.Pairings) error
+	UpdateRecipeDef(recipe *models.Recipe, newRecipeHistoryEntry models.RecipeHistoryEntry) error
+	ReplaceSubRecipes(parentID uint, subRecipes []*models.Recipe) error
+	ListRecipeTitlesContaining(words []string, excludeID uint, limit int) ([]repository.RecipeTitle, error)
+	LinkRecipes(recipeID uint, linkRecipeIDs []uint, linkSuggestions []string) error
+	CreateTokenUsage(usage *models.TokenUsage) error
+	FindTagByName(tagName string) (*models.Tag, error)
+	CreateTag(tag *models.Tag) error
+	UpdateRecipeTagsAssociation(recipeID uint, newTags []models.Tag) error
+	CreateRecipeMade(made *models.RecipeMade) error
+	CountRecipeMadeByUser(recipeID uint, userID uint) (int, error)
+	UpsertRecipeRating(rating *models.RecipeRating) error
+	ListRecipeRatings(recipeID uint, limit, offset int) ([]models.RecipeRating, int64, error)
+	NormalizeTags(normalize func(string) string) (*repository.TagNormalizationResult, error)
+	GetRecipeExplanation(recipeID uint, language string) (*models.RecipeExplanation, error)
+	CreateRecipeExplanation(explanation *models.RecipeExplanation) error
+	GetRecipeGenerationCache(cacheKey string) (*models.RecipeGenerationCache, error)
+	SaveRecipeGenerationCache(cached *models.RecipeGenerationCache) error
+}
+
+// UserRepository is the user storage UserService depends on.
+// It's implemented by repository.UserRepository.
+type UserRepository interface {
+	CreateUser(user *models.User) (*models.User, error)
+	ImportUsers(users []*models.User) ([]error, error)
+	GetUserByID(userID uint) (*models.User, error)
+	GetUserAuthByUsername(username string) (*models.User, error)
+	GetUserByEmail(email string) (*models.User, error)
+	GetUserByFacebookID(facebookID string) (*models.User, error)
+	CreatePasswordResetToken(token *models.PasswordResetToken) error
+	CountOutstandingPasswordResetTokens(userID uint, now time.Time) (int, error)
+	ResetPassword(tokenHash string, hashedPassword string) (uint, error)
+	SetUserFeatureFlag(userID uint, flag string, enabled bool) error
+	SetUserRole(userID uint, role models.Role) error
+	UpdateSettingsAndPersonalization(userID uint, applyChanges func(*models.UserSettings, *models.Personalization) error) error
+	UpdatePersonalization(userID uint, updatedPersonalization *models.Personalization) error
+	IncrementDailyGenerations(userID uint, day time.Time, dailyCap int) (bool, error)
+	RestoreDailyGeneration(userID uint, day time.Time) error
+	DecrementRemainingUses(userID uint) (bool, error)
+	RestoreRemainingUse(userID uint) error
+	RefillRemainingUses(userID uint, expiresAt, nextExpiresAt time.Time, remainingUses int) (bool, error)
+	ListExpiredSubscriptions(before time.Time, limit int) ([]models.Subscription, error)
+	StartStripeSubscription(eventID string, userID uint, customerID string, tier models.SubscriptionTier, expiresAt time.Time, remainingUses int) error
+	EndStripeSubscription(eventID string, customerID string, tier models.SubscriptionTier, expiresAt time.Time, remainingUses int) (uint, error)
+	UsernameExists(username string) (bool, error)
+	BackfillPersonalizations() (*repository.PersonalizationBackfillResult, error)
+	ReencryptOpenAIKeys(reencrypt func(ciphertext string) (string, bool, error)) (*repository.OpenAIKeyReencryptionResult, error)
+	EmailExists(email string) (bool, error)
+	RecordLogin(session *models.UserSession) error
+	ListActiveUserSessions(userID uint) ([]models.UserSession, error)
+	RevokeUserSession(userID uint, sessionID uint) (*models.UserSession, error)
+	RevokeUserSessionByToken(userID uint, tokenID string) (*models.UserSession, error)
+	GetUserRecipeStats(userID uint, since time.Time, topN int) (*repository.UserRecipeStats, error)
+	GetTokenUsageByUser(userID uint, since time.Time) ([]repository.ModelUsage, error)
+	AddPersonalKeySpend(userID uint, month time.Time, spendMicros int64) error
+}
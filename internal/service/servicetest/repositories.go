@@ -0,0 +1,737 @@
+// Package servicetest provides mock repositories, so the service business logic can be exercised without a database.
+package servicetest
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/windoze95/saltybytes-api/internal/models"
+	"github.com/windoze95/saltybytes-api/internal/repository"
+	"github.com/windoze95/saltybytes-api/internal/service"
+	"github.com/windoze95/saltybytes-api/internal/util"
+)
+
+// MockRecipeRepository is a mock of service.RecipeRepository. Each method calls its func field.
+// Methods whose func field isn't set fall through to the embedded interface, which panics when it's nil.
+type MockRecipeRepository struct {
+	service.RecipeRepository
+
+	GetRecipeByIDFunc                  func(recipeID uint) (*models.Recipe, error)
+	GetRecipeWithLinkedRecipesFunc     func(recipeID uint) (*models.Recipe, error)
+	ListRecipesByUserFunc              func(userID uint, filter util.RecipeFilter, limit, offset int) ([]models.Recipe, int64, error)
+	ListCollectedRecipesByUserFunc     func(userID uint, filter util.RecipeFilter, limit, offset int) ([]models.Recipe, int64, error)
+	IsRecipeCollectedFunc              func(userID uint, recipeID uint) (bool, error)
+	CollectRecipeFunc                  func(userID uint, recipeID uint) error
+	UncollectRecipeFunc                func(userID uint, recipeID uint) error
+	ListRecipesByTagFunc               func(hashtag string, filter util.RecipeFilter, limit, offset int) ([]models.Recipe, int64, error)
+	ListFeedRecipesFunc                func(before *util.FeedCursor, filter util.RecipeFilter, limit int) ([]models.Recipe, error)
+	ListPopularTagsFunc                func(limit int) ([]repository.NameCount, error)
+	GetHistoryByIDFunc                 func(historyID uint) (*models.RecipeHistory, error)
+	GetRecipeOwnerByHistoryIDFunc      func(historyID uint) (uint, error)
+	CreateRecipeFunc                   func(recipe *models.Recipe) error
+	DeleteRecipeFunc                   func(recipeID uint) error
+	ListTrashedRecipesByUserFunc       func(userID uint, deletedSince time.Time, limit, offset int) ([]models.Recipe, int64, error)
+	ListRecipesForAdminFunc            func(filter repository.AdminRecipeFilter, limit, offset int) ([]models.Recipe, int64, error)
+	ModerateRecipeFunc                 func(recipeID uint, moderatorID uint) error
+	GetTrashedRecipeByIDFunc           func(recipeID uint) (*models.Recipe, error)
+	PurgeTrashedRecipesFunc            func(deletedBefore time.Time, batchSize int) ([]models.Recipe, error)
+	ListRecipeOwnershipFunc            func(recipeIDs []uint) ([]models.Recipe, error)
+	PurgeRecipesFunc                   func(recipeIDs []uint) error
+	RestoreRecipeFunc                  func(recipeID uint) error
+	UpdateRecipeImageURLFunc           func(recipeID uint, imageURL, imageKey string) error
+	UpdateRecipeImageVariantURLsFunc   func(recipeID uint, thumbnailURL, webpURL string) error
+	UpdateRecipeImageUploadPendingFunc func(recipeID uint, pending bool) error
+	UpdateRecipeGenerationStatusFunc   func(recipeID uint, status models.GenerationStatus) error
+	GetRecipeGenerationStatusFunc      func(recipeID uint) (*models.Recipe, error)
+	FindRecentRecipeByPromptHashFunc   func(userID uint, promptHash string, personalizationUID uuid.UUID, since time.Time) (*models.Recipe, error)
+	UpdateRecipePinnedHashtagsFunc     func(recipeID uint, pinnedHashtags []string) error
+	UpdateRecipePairingsFunc           func(recipeID uint, pairings models.Pairings) error
+	UpdateRecipeDefFunc                func(recipe *models.Recipe, newRecipeHistoryEntry models.RecipeHistoryEntry) error
+	ReplaceSubRecipesFunc              func(parentID uint, subRecipes []*models.Recipe) error
+	ListRecipeTitlesContainingFunc     func(words []string, excludeID uint, limit int) ([]repository.RecipeTitle, error)
+	LinkRecipesFunc                    func(recipeID uint, linkRecipeIDs []uint, linkSuggestions []string) error
+	CreateTokenUsageFunc               func(usage *models.TokenUsage) error
+	FindTagByNameFunc                  func(tagName string) (*models.Tag, error)
+	CreateTagFunc                      func(tag *models.Tag) error
+	UpdateRecipeTagsAssociationFunc    func(recipeID uint, newTags []models.Tag) error
+	CreateRecipeMadeFunc               func(made *models.RecipeMade) error
+	CountRecipeMadeByUserFunc          func(recipeID uint, userID uint) (int, error)
+	UpsertRecipeRatingFunc             func(rating *models.RecipeRating) error
+	ListRecipeRatingsFunc              func(recipeID uint, limit, offset int) ([]models.RecipeRating, int64, error)
+	NormalizeTagsFunc                  func(normalize func(string) string) (*repository.TagNormalizationResult, error)
+	GetRecipeExplanationFunc           func(recipeID uint, language string) (*models.RecipeExplanation, error)
+	CreateRecipeExplanationFunc        func(explanation *models.RecipeExplanation) error
+	GetRecipeGenerationCacheFunc       func(cacheKey string) (*models.RecipeGenerationCache, error)
+	SaveRecipeGenerationCacheFunc      func(cached *models.RecipeGenerationCache) error
+}
+
+// GetRecipeByID calls GetRecipeByIDFunc.
+func (m *MockRecipeRepository) GetRecipeByID(recipeID uint) (*models.Recipe, error) {
+	if m.GetRecipeByIDFunc == nil {
+		return m.RecipeRepository.GetRecipeByID(recipeID)
+	}
+	return m.GetRecipeByIDFunc(recipeID)
+}
+
+// GetRecipeWithLinkedRecipes calls GetRecipeWithLinkedRecipesFunc.
+func (m *MockRecipeRepository) GetRecipeWithLinkedRecipes(recipeID uint) (*models.Recipe, error) {
+	if m.GetRecipeWithLinkedRecipesFunc == nil {
+		return m.RecipeRepository.GetRecipeWithLinkedRecipes(recipeID)
+	}
+	return m.GetRecipeWithLinkedRecipesFunc(recipeID)
+}
+
+// ListRecipesByUser calls ListRecipesByUserFunc.
+func (m *MockRecipeRepository) ListRecipesByUser(userID uint, filter util.RecipeFilter, limit, offset int) ([]models.Recipe, int64, error) {
+	if m.ListRecipesByUserFunc == nil {
+		return m.RecipeRepository.ListRecipesByUser(userID, filter, limit, offset)
+	}
+	return m.ListRecipesByUserFunc(userID, filter, limit, offset)
+}
+
+// ListCollectedRecipesByUser calls ListCollectedRecipesByUserFunc.
+func (m *MockRecipeRepository) ListCollectedRecipesByUser(userID uint, filter util.RecipeFilter, limit, offset int) ([]models.Recipe, int64, error) {
+	if m.ListCollectedRecipesByUserFunc == nil {
+		return m.RecipeRepository.ListCollectedRecipesByUser(userID, filter, limit, offset)
+	}
+	return m.ListCollectedRecipesByUserFunc(userID, filter, limit, offset)
+}
+
+// IsRecipeCollected calls IsRecipeCollectedFunc.
+func (m *MockRecipeRepository) IsRecipeCollected(userID uint, recipeID uint) (bool, error) {
+	if m.IsRecipeCollectedFunc == nil {
+		return m.RecipeRepository.IsRecipeCollected(userID, recipeID)
+	}
+	return m.IsRecipeCollectedFunc(userID, recipeID)
+}
+
+// CollectRecipe calls CollectRecipeFunc.
+func (m *MockRecipeRepository) CollectRecipe(userID uint, recipeID uint) error {
+	if m.CollectRecipeFunc == nil {
+		return m.RecipeRepository.CollectRecipe(userID, recipeID)
+	}
+	return m.CollectRecipeFunc(userID, recipeID)
+}
+
+// UncollectRecipe calls UncollectRecipeFunc.
+func (m *MockRecipeRepository) UncollectRecipe(userID uint, recipeID uint) error {
+	if m.UncollectRecipeFunc == nil {
+		return m.RecipeRepository.UncollectRecipe(userID, recipeID)
+	}
+	return m.UncollectRecipeFunc(userID, recipeID)
+}
+
+// ListRecipesByTag calls ListRecipesByTagFunc.
+func (m *MockRecipeRepository) ListRecipesByTag(hashtag string, filter util.RecipeFilter, limit, offset int) ([]models.Recipe, int64, error) {
+	if m.ListRecipesByTagFunc == nil {
+		return m.RecipeRepository.ListRecipesByTag(hashtag, filter, limit, offset)
+	}
+	return m.ListRecipesByTagFunc(hashtag, filter, limit, offset)
+}
+
+// ListFeedRecipes calls ListFeedRecipesFunc.
+func (m *MockRecipeRepository) ListFeedRecipes(before *util.FeedCursor, filter util.RecipeFilter, limit int) ([]models.Recipe, error) {
+	if m.ListFeedRecipesFunc == nil {
+		return m.RecipeRepository.ListFeedRecipes(before, filter, limit)
+	}
+	return m.ListFeedRecipesFunc(before, filter, limit)
+}
+
+// ListPopularTags calls ListPopularTagsFunc.
+func (m *MockRecipeRepository) ListPopularTags(limit int) ([]repository.NameCount, error) {
+	if m.ListPopularTagsFunc == nil {
+		return m.RecipeRepository.ListPopularTags(limit)
+	}
+	return m.ListPopularTagsFunc(limit)
+}
+
+// GetHistoryByID calls GetHistoryByIDFunc.
+func (m *MockRecipeRepository) GetHistoryByID(historyID uint) (*models.RecipeHistory, error) {
+	if m.GetHistoryByIDFunc == nil {
+		return m.RecipeRepository.GetHistoryByID(historyID)
+	}
+	return m.GetHistoryByIDFunc(historyID)
+}
+
+// GetRecipeOwnerByHistoryID calls GetRecipeOwnerByHistoryIDFunc.
+func (m *MockRecipeRepository) GetRecipeOwnerByHistoryID(historyID uint) (uint, error) {
+	if m.GetRecipeOwnerByHistoryIDFunc == nil {
+		return m.RecipeRepository.GetRecipeOwnerByHistoryID(historyID)
+	}
+	return m.GetRecipeOwnerByHistoryIDFunc(historyID)
+}
+
+// CreateRecipe calls CreateRecipeFunc.
+func (m *MockRecipeRepository) CreateRecipe(recipe *models.Recipe) error {
+	if m.CreateRecipeFunc == nil {
+		return m.RecipeRepository.CreateRecipe(recipe)
+	}
+	return m.CreateRecipeFunc(recipe)
+}
+
+// DeleteRecipe calls DeleteRecipeFunc.
+func (m *MockRecipeRepository) DeleteRecipe(recipeID uint) error {
+	if m.DeleteRecipeFunc == nil {
+		return m.RecipeRepository.DeleteRecipe(recipeID)
+	}
+	return m.DeleteRecipeFunc(recipeID)
+}
+
+// ListTrashedRecipesByUser calls ListTrashedRecipesByUserFunc.
+func (m *MockRecipeRepository) ListTrashedRecipesByUser(userID uint, deletedSince time.Time, limit, offset int) ([]models.Recipe, int64, error) {
+	if m.ListTrashedRecipesByUserFunc == nil {
+		return m.RecipeRepository.ListTrashedRecipesByUser(userID, deletedSince, limit, offset)
+	}
+	return m.ListTrashedRecipesByUserFunc(userID, deletedSince, limit, offset)
+}
+
+// ListRecipesForAdmin calls ListRecipesForAdminFunc.
+func (m *MockRecipeRepository) ListRecipesForAdmin(filter repository.AdminRecipeFilter, limit, offset int) ([]models.Recipe, int64, error) {
+	if m.ListRecipesForAdminFunc == nil {
+		return m.RecipeRepository.ListRecipesForAdmin(filter, limit, offset)
+	}
+	return m.ListRecipesForAdminFunc(filter, limit, offset)
+}
+
+// ModerateRecipe calls ModerateRecipeFunc.
+func (m *MockRecipeRepository) ModerateRecipe(recipeID uint, moderatorID uint) error {
+	if m.ModerateRecipeFunc == nil {
+		return m.RecipeRepository.ModerateRecipe(recipeID, moderatorID)
+	}
+	return m.ModerateRecipeFunc(recipeID, moderatorID)
+}
+
+// GetTrashedRecipeByID calls GetTrashedRecipeByIDFunc.
+func (m *MockRecipeRepository) GetTrashedRecipeByID(recipeID uint) (*models.Recipe, error) {
+	if m.GetTrashedRecipeByIDFunc == nil {
+		return m.RecipeRepository.GetTrashedRecipeByID(recipeID)
+	}
+	return m.GetTrashedRecipeByIDFunc(recipeID)
+}
+
+// PurgeTrashedRecipes calls PurgeTrashedRecipesFunc.
+func (m *MockRecipeRepository) PurgeTrashedRecipes(deletedBefore time.Time, batchSize int) ([]models.Recipe, error) {
+	if m.PurgeTrashedRecipesFunc == nil {
+		return m.RecipeRepository.PurgeTrashedRecipes(deletedBefore, batchSize)
+	}
+	return m.PurgeTrashedRecipesFunc(deletedBefore, batchSize)
+}
+
+// ListRecipeOwnership calls ListRecipeOwnershipFunc.
+func (m *MockRecipeRepository) ListRecipeOwnership(recipeIDs []uint) ([]models.Recipe, error) {
+	if m.ListRecipeOwnershipFunc == nil {
+		return m.RecipeRepository.ListRecipeOwnership(recipeIDs)
+	}
+	return m.ListRecipeOwnershipFunc(recipeIDs)
+}
+
+// PurgeRecipes calls PurgeRecipesFunc.
+func (m *MockRecipeRepository) PurgeRecipes(recipeIDs []uint) error {
+	if m.PurgeRecipesFunc == nil {
+		return m.RecipeRepository.PurgeRecipes(recipeIDs)
+	}
+	return m.PurgeRecipesFunc(recipeIDs)
+}
+
+// RestoreRecipe calls RestoreRecipeFunc.
+func (m *MockRecipeRepository) RestoreRecipe(recipeID uint) error {
+	if m.RestoreRecipeFunc == nil {
+		return m.RecipeRepository.RestoreRecipe(recipeID)
+	}
+	return m.RestoreRecipeFunc(recipeID)
+}
+
+// UpdateRecipeImageURL calls UpdateRecipeImageURLFunc.
+func (m *MockRecipeRepository) UpdateRecipeImageURL(recipeID uint, imageURL, imageKey string) error {
+	if m.UpdateRecipeImageURLFunc == nil {
+		return m.RecipeRepository.UpdateRecipeImageURL(recipeID, imageURL, imageKey)
+	}
+	return m.UpdateRecipeImageURLFunc(recipeID, imageURL, imageKey)
+}
+
+// UpdateRecipeImageVariantURLs calls UpdateRecipeImageVariantURLsFunc.
+func (m *MockRecipeRepository) UpdateRecipeImageVariantURLs(recipeID uint, thumbnailURL, webpURL string) error {
+	if m.UpdateRecipeImageVariantURLsFunc == nil {
+		return m.RecipeRepository.UpdateRecipeImageVariantURLs(recipeID, thumbnailURL, webpURL)
+	}
+	return m.UpdateRecipeImageVariantURLsFunc(recipeID, thumbnailURL, webpURL)
+}
+
+// UpdateRecipeImageUploadPending calls UpdateRecipeImageUploadPendingFunc.
+func (m *MockRecipeRepository) UpdateRecipeImageUploadPending(recipeID uint, pending bool) error {
+	if m.UpdateRecipeImageUploadPendingFunc == nil {
+		return m.RecipeRepository.UpdateRecipeImageUploadPending(recipeID, pending)
+	}
+	return m.UpdateRecipeImageUploadPendingFunc(recipeID, pending)
+}
+
+// UpdateRecipeGenerationStatus calls UpdateRecipeGenerationStatusFunc.
+func (m *MockRecipeRepository) UpdateRecipeGenerationStatus(recipeID uint, status models.GenerationStatus) error {
+	if m.UpdateRecipeGenerationStatusFunc == nil {
+		return m.RecipeRepository.UpdateRecipeGenerationStatus(recipeID, status)
+	}
+	return m.UpdateRecipeGenerationStatusFunc(recipeID, status)
+}
+
+// GetRecipeGenerationStatus calls GetRecipeGenerationStatusFunc.
+func (m *MockRecipeRepository) GetRecipeGenerationStatus(recipeID uint) (*models.Recipe, error) {
+	if m.GetRecipeGenerationStatusFunc == nil {
+		return m.RecipeRepository.GetRecipeGenerationStatus(recipeID)
+	}
+	return m.GetRecipeGenerationStatusFunc(recipeID)
+}
+
+// FindRecentRecipeByPromptHash calls FindRecentRecipeByPromptHashFunc.
+func (m *MockRecipeRepository) FindRecentRecipeByPromptHash(userID uint, promptHash string, personalizationUID uuid.UUID, since time.Time) (*models.Recipe, error) {
+	if m.FindRecentRecipeByPromptHashFunc == nil {
+		return m.RecipeRepository.FindRecentRecipeByPromptHash(userID, promptHash, personalizationUID, since)
+	}
+	return m.FindRecentRecipeByPromptHashFunc(userID, promptHash, personalizationUID, since)
+}
+
+// UpdateRecipePinnedHashtags calls UpdateRecipePinnedHashtagsFunc.
+func (m *MockRecipeRepository) UpdateRecipePinnedHashtags(recipeID uint, pinnedHashtags []string) error {
+	if m.UpdateRecipePinnedHashtagsFunc == nil {
+		return m.RecipeRepository.UpdateRecipePinnedHashtags(recipeID, pinnedHashtags)
+	}
+	return m.UpdateRecipePinnedHashtagsFunc(recipeID, pinnedHashtags)
+}
+
+// UpdateRecipePairings calls UpdateRecipePairingsFunc.
+func (m *MockRecipeRepository) UpdateRecipePairings(recipeID uint, pairings models.Pairings) error {
+	if m.UpdateRecipePairingsFunc == nil {
+		return m.RecipeRepository.UpdateRecipePairings(recipeID, pairings)
+	}
+	return m.UpdateRecipePairingsFunc(recipeID, pairings)
+}
+
+// UpdateRecipeDef calls UpdateRecipeDefFunc.
+func (m *MockRecipeRepository) UpdateRecipeDef(recipe *models.Recipe, newRecipeHistoryEntry models.RecipeHistoryEntry) error {
+	if m.UpdateRecipeDefFunc == nil {
+		return m.RecipeRepository.UpdateRecipeDef(recipe, newRecipeHistoryEntry)
+	}
+	return m.UpdateRecipeDefFunc(recipe, newRecipeHistoryEntry)
+}
+
+// ReplaceSubRecipes calls ReplaceSubRecipesFunc.
+func (m *MockRecipeRepository) ReplaceSubRecipes(parentID uint, subRecipes []*models.Recipe) error {
+	if m.ReplaceSubRecipesFunc == nil {
+		return m.RecipeRepository.ReplaceSubRecipes(parentID, subRecipes)
+	}
+	return m.ReplaceSubRecipesFunc(parentID, subRecipes)
+}
+
+// ListRecipeTitlesContaining calls ListRecipeTitlesContainingFunc.
+func (m *MockRecipeRepository) ListRecipeTitlesContaining(words []string, excludeID uint, limit int) ([]repository.RecipeTitle, error) {
+	if m.ListRecipeTitlesContainingFunc == nil {
+		return m.RecipeRepository.ListRecipeTitlesContaining(words, excludeID, limit)
+	}
+	return m.ListRecipeTitlesContainingFunc(words, excludeID, limit)
+}
+
+// LinkRecipes calls LinkRecipesFunc.
+func (m *MockRecipeRepository) LinkRecipes(recipeID uint, linkRecipeIDs []uint, linkSuggestions []string) error {
+	if m.LinkRecipesFunc == nil {
+		return m.RecipeRepository.LinkRecipes(recipeID, linkRecipeIDs, linkSuggestions)
+	}
+	return m.LinkRecipesFunc(recipeID, linkRecipeIDs, linkSuggestions)
+}
+
+// CreateTokenUsage calls CreateTokenUsageFunc.
+func (m *MockRecipeRepository) CreateTokenUsage(usage *models.TokenUsage) error {
+	if m.CreateTokenUsageFunc == nil {
+		return m.RecipeRepository.CreateTokenUsage(usage)
+	}
+	return m.CreateTokenUsageFunc(usage)
+}
+
+// FindTagByName calls FindTagByNameFunc.
+func (m *MockRecipeRepository) FindTagByName(tagName string) (*models.Tag, error) {
+	if m.FindTagByNameFunc == nil {
+		return m.RecipeRepository.FindTagByName(tagName)
+	}
+	return m.FindTagByNameFunc(tagName)
+}
+
+// CreateTag calls CreateTagFunc.
+func (m *MockRecipeRepository) CreateTag(tag *models.Tag) error {
+	if m.CreateTagFunc == nil {
+		return m.RecipeRepository.CreateTag(tag)
+	}
+	return m.CreateTagFunc(tag)
+}
+
+// UpdateRecipeTagsAssociation calls UpdateRecipeTagsAssociationFunc.
+func (m *MockRecipeRepository) UpdateRecipeTagsAssociation(recipeID uint, newTags []models.Tag) error {
+	if m.UpdateRecipeTagsAssociationFunc == nil {
+		return m.RecipeRepository.UpdateRecipeTagsAssociation(recipeID, newTags)
+	}
+	return m.UpdateRecipeTagsAssociationFunc(recipeID, newTags)
+}
+
+// CreateRecipeMade calls CreateRecipeMadeFunc.
+func (m *MockRecipeRepository) CreateRecipeMade(made *models.RecipeMade) error {
+	if m.CreateRecipeMadeFunc == nil {
+		return m.RecipeRepository.CreateRecipeMade(made)
+	}
+	return m.CreateRecipeMadeFunc(made)
+}
+
+// CountRecipeMadeByUser calls CountRecipeMadeByUserFunc.
+func (m *MockRecipeRepository) CountRecipeMadeByUser(recipeID uint, userID uint) (int, error) {
+	if m.CountRecipeMadeByUserFunc == nil {
+		return m.RecipeRepository.CountRecipeMadeByUser(recipeID, userID)
+	}
+	return m.CountRecipeMadeByUserFunc(recipeID, userID)
+}
+
+// UpsertRecipeRating calls UpsertRecipeRatingFunc.
+func (m *MockRecipeRepository) UpsertRecipeRating(rating *models.RecipeRating) error {
+	if m.UpsertRecipeRatingFunc == nil {
+		return m.RecipeRepository.UpsertRecipeRating(rating)
+	}
+	return m.UpsertRecipeRatingFunc(rating)
+}
+
+// ListRecipeRatings calls ListRecipeRatingsFunc.
+func (m *MockRecipeRepository) ListRecipeRatings(recipeID uint, limit, offset int) ([]models.RecipeRating, int64, error) {
+	if m.ListRecipeRatingsFunc == nil {
+		return m.RecipeRepository.ListRecipeRatings(recipeID, limit, offset)
+	}
+	return m.ListRecipeRatingsFunc(recipeID, limit, offset)
+}
+
+// NormalizeTags calls NormalizeTagsFunc.
+func (m *MockRecipeRepository) NormalizeTags(normalize func(string) string) (*repository.TagNormalizationResult, error) {
+	if m.NormalizeTagsFunc == nil {
+		return m.RecipeRepository.NormalizeTags(normalize)
+	}
+	return m.NormalizeTagsFunc(normalize)
+}
+
+// GetRecipeExplanation calls GetRecipeExplanationFunc.
+func (m *MockRecipeRepository) GetRecipeExplanation(recipeID uint, language string) (*models.RecipeExplanation, error) {
+	if m.GetRecipeExplanationFunc == nil {
+		return m.RecipeRepository.GetRecipeExplanation(recipeID, language)
+	}
+	return m.GetRecipeExplanationFunc(recipeID, language)
+}
+
+// CreateRecipeExplanation calls CreateRecipeExplanationFunc.
+func (m *MockRecipeRepository) CreateRecipeExplanation(explanation *models.RecipeExplanation) error {
+	if m.CreateRecipeExplanationFunc == nil {
+		return m.RecipeRepository.CreateRecipeExplanation(explanation)
+	}
+	return m.CreateRecipeExplanationFunc(explanation)
+}
+
+// GetRecipeGenerationCache calls GetRecipeGenerationCacheFunc.
+func (m *MockRecipeRepository) GetRecipeGenerationCache(cacheKey string) (*models.RecipeGenerationCache, error) {
+	if m.GetRecipeGenerationCacheFunc == nil {
+		return m.RecipeRepository.GetRecipeGenerationCache(cacheKey)
+	}
+	return m.GetRecipeGenerationCacheFunc(cacheKey)
+}
+
+// SaveRecipeGenerationCache calls SaveRecipeGenerationCacheFunc.
+func (m *MockRecipeRepository) SaveRecipeGenerationCache(cached *models.RecipeGenerationCache) error {
+	if m.SaveRecipeGenerationCacheFunc == nil {
+		return m.RecipeRepository.SaveRecipeGenerationCache(cached)
+	}
+	return m.SaveRecipeGenerationCacheFunc(cached)
+}
+
+// MockUserRepository is a mock of service.UserRepository. Each method calls its func field.
+// Methods whose func field isn't set fall through to the embedded interface, which panics when it's nil.
+type MockUserRepository struct {
+	service.UserRepository
+
+	CreateUserFunc                          func(user *models.User) (*models.User, error)
+	ImportUsersFunc                         func(users []*models.User) ([]error, error)
+	GetUserByIDFunc                         func(userID uint) (*models.User, error)
+	GetUserAuthByUsernameFunc               func(username string) (*models.User, error)
+	GetUserByEmailFunc                      func(email string) (*models.User, error)
+	GetUserByFacebookIDFunc                 func(facebookID string) (*models.User, error)
+	CreatePasswordResetTokenFunc            func(token *models.PasswordResetToken) error
+	CountOutstandingPasswordResetTokensFunc func(userID uint, now time.Time) (int, error)
+	ResetPasswordFunc                       func(tokenHash string, hashedPassword string) (uint, error)
+	SetUserFeatureFlagFunc                  func(userID uint, flag string, enabled bool) error
+	SetUserRoleFunc                         func(userID uint, role models.Role) error
+	UpdateSettingsAndPersonalizationFunc    func(userID uint, applyChanges func(*models.UserSettings, *models.Personalization) error) error
+	UpdatePersonalizationFunc               func(userID uint, updatedPersonalization *models.Personalization) error
+	IncrementDailyGenerationsFunc           func(userID uint, day time.Time, dailyCap int) (bool, error)
+	RestoreDailyGenerationFunc              func(userID uint, day time.Time) error
+	DecrementRemainingUsesFunc              func(userID uint) (bool, error)
+	RestoreRemainingUseFunc                 func(userID uint) error
+	RefillRemainingUsesFunc                 func(userID uint, expiresAt, nextExpiresAt time.Time, remainingUses int) (bool, error)
+	ListExpiredSubscriptionsFunc            func(before time.Time, limit int) ([]models.Subscription, error)
+	StartStripeSubscriptionFunc             func(eventID string, userID uint, customerID string, tier models.SubscriptionTier, expiresAt time.Time, remainingUses int) error
+	EndStripeSubscriptionFunc               func(eventID string, customerID string, tier models.SubscriptionTier, expiresAt time.Time, remainingUses int) (uint, error)
+	UsernameExistsFunc                      func(username string) (bool, error)
+	BackfillPersonalizationsFunc            func() (*repository.PersonalizationBackfillResult, error)
+	ReencryptOpenAIKeysFunc                 func(reencrypt func(ciphertext string) (string, bool, error)) (*repository.OpenAIKeyReencryptionResult, error)
+	EmailExistsFunc                         func(email string) (bool, error)
+	RecordLoginFunc                         func(session *models.UserSession) error
+	ListActiveUserSessionsFunc              func(userID uint) ([]models.UserSession, error)
+	RevokeUserSessionFunc                   func(userID uint, sessionID uint) (*models.UserSession, error)
+	RevokeUserSessionByTokenFunc            func(userID uint, tokenID string) (*models.UserSession, error)
+	GetUserRecipeStatsFunc                  func(userID uint, since time.Time, topN int) (*repository.UserRecipeStats, error)
+	GetTokenUsageByUserFunc                 func(userID uint, since time.Time) ([]repository.ModelUsage, error)
+	AddPersonalKeySpendFunc                 func(userID uint, month time.Time, spendMicros int64) error
+}
+
+// CreateUser calls CreateUserFunc.
+func (m *MockUserRepository) CreateUser(user *models.User) (*models.User, error) {
+	if m.CreateUserFunc == nil {
+		return m.UserRepository.CreateUser(user)
+	}
+	return m.CreateUserFunc(user)
+}
+
+// ImportUsers calls ImportUsersFunc.
+func (m *MockUserRepository) ImportUsers(users []*models.User) ([]error, error) {
+	if m.ImportUsersFunc == nil {
+		return m.UserRepository.ImportUsers(users)
+	}
+	return m.ImportUsersFunc(users)
+}
+
+// GetUserByID calls GetUserByIDFunc.
+func (m *MockUserRepository) GetUserByID(userID uint) (*models.User, error) {
+	if m.GetUserByIDFunc == nil {
+		return m.UserRepository.GetUserByID(userID)
+	}
+	return m.GetUserByIDFunc(userID)
+}
+
+// GetUserAuthByUsername calls GetUserAuthByUsernameFunc.
+func (m *MockUserRepository) GetUserAuthByUsername(username string) (*models.User, error) {
+	if m.GetUserAuthByUsernameFunc == nil {
+		return m.UserRepository.GetUserAuthByUsername(username)
+	}
+	return m.GetUserAuthByUsernameFunc(username)
+}
+
+// GetUserByEmail calls GetUserByEmailFunc.
+func (m *MockUserRepository) GetUserByEmail(email string) (*models.User, error) {
+	if m.GetUserByEmailFunc == nil {
+		return m.UserRepository.GetUserByEmail(email)
+	}
+	return m.GetUserByEmailFunc(email)
+}
+
+// GetUserByFacebookID calls GetUserByFacebookIDFunc.
+func (m *MockUserRepository) GetUserByFacebookID(facebookID string) (*models.User, error) {
+	if m.GetUserByFacebookIDFunc == nil {
+		return m.UserRepository.GetUserByFacebookID(facebookID)
+	}
+	return m.GetUserByFacebookIDFunc(facebookID)
+}
+
+// CreatePasswordResetToken calls CreatePasswordResetTokenFunc.
+func (m *MockUserRepository) CreatePasswordResetToken(token *models.PasswordResetToken) error {
+	if m.CreatePasswordResetTokenFunc == nil {
+		return m.UserRepository.CreatePasswordResetToken(token)
+	}
+	return m.CreatePasswordResetTokenFunc(token)
+}
+
+// CountOutstandingPasswordResetTokens calls CountOutstandingPasswordResetTokensFunc.
+func (m *MockUserRepository) CountOutstandingPasswordResetTokens(userID uint, now time.Time) (int, error) {
+	if m.CountOutstandingPasswordResetTokensFunc == nil {
+		return m.UserRepository.CountOutstandingPasswordResetTokens(userID, now)
+	}
+	return m.CountOutstandingPasswordResetTokensFunc(userID, now)
+}
+
+// ResetPassword calls ResetPasswordFunc.
+func (m *MockUserRepository) ResetPassword(tokenHash string, hashedPassword string) (uint, error) {
+	if m.ResetPasswordFunc == nil {
+		return m.UserRepository.ResetPassword(tokenHash, hashedPassword)
+	}
+	return m.ResetPasswordFunc(tokenHash, hashedPassword)
+}
+
+// SetUserFeatureFlag calls SetUserFeatureFlagFunc.
+func (m *MockUserRepository) SetUserFeatureFlag(userID uint, flag string, enabled bool) error {
+	if m.SetUserFeatureFlagFunc == nil {
+		return m.UserRepository.SetUserFeatureFlag(userID, flag, enabled)
+	}
+	return m.SetUserFeatureFlagFunc(userID, flag, enabled)
+}
+
+// SetUserRole calls SetUserRoleFunc.
+func (m *MockUserRepository) SetUserRole(userID uint, role models.Role) error {
+	if m.SetUserRoleFunc == nil {
+		return m.UserRepository.SetUserRole(userID, role)
+	}
+	return m.SetUserRoleFunc(userID, role)
+}
+
+// UpdateSettingsAndPersonalization calls UpdateSettingsAndPersonalizationFunc.
+func (m *MockUserRepository) UpdateSettingsAndPersonalization(userID uint, applyChanges func(*models.UserSettings, *models.Personalization) error) error {
+	if m.UpdateSettingsAndPersonalizationFunc == nil {
+		return m.UserRepository.UpdateSettingsAndPersonalization(userID, applyChanges)
+	}
+	return m.UpdateSettingsAndPersonalizationFunc(userID, applyChanges)
+}
+
+// UpdatePersonalization calls UpdatePersonalizationFunc.
+func (m *MockUserRepository) UpdatePersonalization(userID uint, updatedPersonalization *models.Personalization) error {
+	if m.UpdatePersonalizationFunc == nil {
+		return m.UserRepository.UpdatePersonalization(userID, updatedPersonalization)
+	}
+	return m.UpdatePersonalizationFunc(userID, updatedPersonalization)
+}
+
+// IncrementDailyGenerations calls IncrementDailyGenerationsFunc.
+func (m *MockUserRepository) IncrementDailyGenerations(userID uint, day time.Time, dailyCap int) (bool, error) {
+	if m.IncrementDailyGenerationsFunc == nil {
+		return m.UserRepository.IncrementDailyGenerations(userID, day, dailyCap)
+	}
+	return m.IncrementDailyGenerationsFunc(userID, day, dailyCap)
+}
+
+// RestoreDailyGeneration calls RestoreDailyGenerationFunc.
+func (m *MockUserRepository) RestoreDailyGeneration(userID uint, day time.Time) error {
+	if m.RestoreDailyGenerationFunc == nil {
+		return m.UserRepository.RestoreDailyGeneration(userID, day)
+	}
+	return m.RestoreDailyGenerationFunc(userID, day)
+}
+
+// DecrementRemainingUses calls DecrementRemainingUsesFunc.
+func (m *MockUserRepository) DecrementRemainingUses(userID uint) (bool, error) {
+	if m.DecrementRemainingUsesFunc == nil {
+		return m.UserRepository.DecrementRemainingUses(userID)
+	}
+	return m.DecrementRemainingUsesFunc(userID)
+}
+
+// RestoreRemainingUse calls RestoreRemainingUseFunc.
+func (m *MockUserRepository) RestoreRemainingUse(userID uint) error {
+	if m.RestoreRemainingUseFunc == nil {
+		return m.UserRepository.RestoreRemainingUse(userID)
+	}
+	return m.RestoreRemainingUseFunc(userID)
+}
+
+// RefillRemainingUses calls RefillRemainingUsesFunc.
+func (m *MockUserRepository) RefillRemainingUses(userID uint, expiresAt, nextExpiresAt time.Time, remainingUses int) (bool, error) {
+	if m.RefillRemainingUsesFunc == nil {
+		return m.UserRepository.RefillRemainingUses(userID, expiresAt, nextExpiresAt, remainingUses)
+	}
+	return m.RefillRemainingUsesFunc(userID, expiresAt, nextExpiresAt, remainingUses)
+}
+
+// ListExpiredSubscriptions calls ListExpiredSubscriptionsFunc.
+func (m *MockUserRepository) ListExpiredSubscriptions(before time.Time, limit int) ([]models.Subscription, error) {
+	if m.ListExpiredSubscriptionsFunc == nil {
+		return m.UserRepository.ListExpiredSubscriptions(before, limit)
+	}
+	return m.ListExpiredSubscriptionsFunc(before, limit)
+}
+
+// StartStripeSubscription calls StartStripeSubscriptionFunc.
+func (m *MockUserRepository) StartStripeSubscription(eventID string, userID uint, customerID string, tier models.SubscriptionTier, expiresAt time.Time, remainingUses int) error {
+	if m.StartStripeSubscriptionFunc == nil {
+		return m.UserRepository.StartStripeSubscription(eventID, userID, customerID, tier, expiresAt, remainingUses)
+	}
+	return m.StartStripeSubscriptionFunc(eventID, userID, customerID, tier, expiresAt, remainingUses)
+}
+
+// EndStripeSubscription calls EndStripeSubscriptionFunc.
+func (m *MockUserRepository) EndStripeSubscription(eventID string, customerID string, tier models.SubscriptionTier, expiresAt time.Time, remainingUses int) (uint, error) {
+	if m.EndStripeSubscriptionFunc == nil {
+		return m.UserRepository.EndStripeSubscription(eventID, customerID, tier, expiresAt, remainingUses)
+	}
+	return m.EndStripeSubscriptionFunc(eventID, customerID, tier, expiresAt, remainingUses)
+}
+
+// UsernameExists calls UsernameExistsFunc.
+func (m *MockUserRepository) UsernameExists(username string) (bool, error) {
+	if m.UsernameExistsFunc == nil {
+		return m.UserRepository.UsernameExists(username)
+	}
+	return m.UsernameExistsFunc(username)
+}
+
+// BackfillPersonalizations calls BackfillPersonalizationsFunc.
+func (m *MockUserRepository) BackfillPersonalizations() (*repository.PersonalizationBackfillResult, error) {
+	if m.BackfillPersonalizationsFunc == nil {
+		return m.UserRepository.BackfillPersonalizations()
+	}
+	return m.BackfillPersonalizationsFunc()
+}
+
+// ReencryptOpenAIKeys calls ReencryptOpenAIKeysFunc.
+func (m *MockUserRepository) ReencryptOpenAIKeys(reencrypt func(ciphertext string) (string, bool, error)) (*repository.OpenAIKeyReencryptionResult, error) {
+	if m.ReencryptOpenAIKeysFunc == nil {
+		return m.UserRepository.ReencryptOpenAIKeys(reencrypt)
+	}
+	return m.ReencryptOpenAIKeysFunc(reencrypt)
+}
+
+// EmailExists calls EmailExistsFunc.
+func (m *MockUserRepository) EmailExists(email string) (bool, error) {
+	if m.EmailExistsFunc == nil {
+		return m.UserRepository.EmailExists(email)
+	}
+	return m.EmailExistsFunc(email)
+}
+
+// RecordLogin calls RecordLoginFunc.
+func (m *MockUserRepository) RecordLogin(session *models.UserSession) error {
+	if m.RecordLoginFunc == nil {
+		return m.UserRepository.RecordLogin(session)
+	}
+	return m.RecordLoginFunc(session)
+}
+
+// ListActiveUserSessions calls ListActiveUserSessionsFunc.
+func (m *MockUserRepository) ListActiveUserSessions(userID uint) ([]models.UserSession, error) {
+	if m.ListActiveUserSessionsFunc == nil {
+		return m.UserRepository.ListActiveUserSessions(userID)
+	}
+	return m.ListActiveUserSessionsFunc(userID)
+}
+
+// RevokeUserSession calls RevokeUserSessionFunc.
+func (m *MockUserRepository) RevokeUserSession(userID uint, sessionID uint) (*models.UserSession, error) {
+	if m.RevokeUserSessionFunc == nil {
+		return m.UserRepository.RevokeUserSession(userID, sessionID)
+	}
+	return m.RevokeUserSessionFunc(userID, sessionID)
+}
+
+// RevokeUserSessionByToken calls RevokeUserSessionByTokenFunc.
+func (m *MockUserRepository) RevokeUserSessionByToken(userID uint, tokenID string) (*models.UserSession, error) {
+	if m.RevokeUserSessionByTokenFunc == nil {
+		return m.UserRepository.RevokeUserSessionByToken(userID, tokenID)
+	}
+	return m.RevokeUserSessionByTokenFunc(userID, tokenID)
+}
+
+// GetUserRecipeStats calls GetUserRecipeStatsFunc.
+func (m *MockUserRepository) GetUserRecipeStats(userID uint, since time.Time, topN int) (*repository.UserRecipeStats, error) {
+	if m.GetUserRecipeStatsFunc == nil {
+		return m.UserRepository.GetUserRecipeStats(userID, since, topN)
+	}
+	return m.GetUserRecipeStatsFunc(userID, since, topN)
+}
+
+// GetTokenUsageByUser calls GetTokenUsageByUserFunc.
+func (m *MockUserRepository) GetTokenUsageByUser(userID uint, since time.Time) ([]repository.ModelUsage, error) {
+	if m.GetTokenUsageByUserFunc == nil {
+		return m.UserRepository.GetTokenUsageByUser(userID, since)
+	}
+	return m.GetTokenUsageByUserFunc(userID, since)
+}
+
+// AddPersonalKeySpend calls AddPersonalKeySpendFunc.
+func (m *MockUserRepository) AddPersonalKeySpend(userID uint, month time.Time, spendMicros int64) error {
+	if m.AddPersonalKeySpendFunc == nil {
+		return m.UserRepository.AddPersonalKeySpend(userID, month, spendMicros)
+	}
+	return m.AddPersonalKeySpendFunc(userID, month, spendMicros)
+}
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/windoze95/saltybytes-api/internal/models"
+	"github.com/windoze95/saltybytes-api/internal/util"
+)
+
+// MaxShoppingListRecipes is the most recipes one shopping list can be built from, not counting their sub-recipes.
+const MaxShoppingListRecipes = 20
+
+// ShoppingListResponse is the response object for the shopping list of one or more recipes.
+type ShoppingListResponse struct {
+	RecipeIDs  []uint                 `json:"recipe_ids"`
+	Categories []ShoppingListCategory `json:"categories"`
+}
+
+// ShoppingListCategory is the items of a shopping list in one grocery store category.
+type ShoppingListCategory struct {
+	Category string                  `json:"category"`
+	Items    []util.MergedIngredient `json:"items"`
+}
+
+// BuildShoppingList merges the ingredients of the recipes and their linked sub-recipes into a shopping list,
+// grouped by grocery store category in store order. Ingredients with the same name, regardless of case, and unit
+// are summed, the same ingredient in different units is listed once per unit.
+// A recipe that's listed twice, or is also a sub-recipe of another, is only counted once.
+func (s *RecipeService) BuildShoppingList(recipeIDs ...uint) (*ShoppingListResponse, error) {
+	if len(recipeIDs) == 0 {
+		return nil, ValidationError{message: "At least one recipe is required"}
+	}
+
+	response := &ShoppingListResponse{RecipeIDs: []uint{}, Categories: []ShoppingListCategory{}}
+	var recipes []*models.Recipe
+	included := make(map[uint]bool)
+	for _, recipeID := range recipeIDs {
+		if included[recipeID] {
+			continue
+		}
+		if len(response.RecipeIDs) == MaxShoppingListRecipes {
+			return nil, ValidationError{message: fmt.Sprintf("A shopping list can have at most %d recipes", MaxShoppingListRecipes)}
+		}
+
+		recipe, err := s.Repo.GetRecipeWithLinkedRecipes(recipeID)
+		if err != nil {
+			return nil, err
+		}
+		response.RecipeIDs = append(response.RecipeIDs, recipe.ID)
+
+		for _, r := range append([]*models.Recipe{recipe}, recipe.LinkedRecipes...) {
+			if r == nil || included[r.ID] {
+				continue
+			}
+			included[r.ID] = true
+			recipes = append(recipes, r)
+		}
+	}
+
+	byCategory := make(map[string][]util.MergedIngredient)
+	for _, ingredient := range util.MergeIngredients(recipes) {
+		category := util.CategorizeIngredient(ingredient.Name)
+		byCategory[category] = append(byCategory[category], ingredient)
+	}
+	for _, category := range util.GroceryCategoryOrder {
+		if items := byCategory[category]; len(items) > 0 {
+			response.Categories = append(response.Categories, ShoppingListCategory{Category: category, Items: items})
+		}
+	}
+
+	return response, nil
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/windoze95/saltybytes-api/internal/models"
+	"github.com/windoze95/saltybytes-api/internal/repository"
+)
+
+// recipePromptHash returns the hash recipes generated from the same prompt share. The prompt is normalized like the
+// generation cache does, and the language and occasion are part of it, since they change the recipe generated.
+func recipePromptHash(userPrompt string, language string, occasionKey string) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{normalizeCachePrompt(userPrompt), language, occasionKey}, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// FindSimilarRecentRecipe finds the user's newest recipe generated from the same prompt, language, and occasion within
+// the configured window, so it can be returned instead of generating the recipe again. It must also have been
+// generated for the user's current personalization. It returns nil when there's no such recipe.
+func (s *RecipeService) FindSimilarRecentRecipe(user *models.User, userPrompt string, language string, occasionKey string) (*RecipeResponse, error) {
+	window := s.Cfg.SimilarRecipes.Window()
+	if window <= 0 || user.Personalization == nil {
+		return nil, nil
+	}
+
+	// An unknown occasion can't match, generating rejects it
+	if occasionKey != "" {
+		occasion, ok := models.LookupOccasion(occasionKey)
+		if !ok {
+			return nil, nil
+		}
+		occasionKey = occasion.Key
+	}
+
+	promptHash := recipePromptHash(userPrompt, language, occasionKey)
+	recipe, err := s.Repo.FindRecentRecipeByPromptHash(user.ID, promptHash, user.Personalization.UID, time.Now().Add(-window))
+	if err != nil {
+		if _, ok := err.(repository.NotFoundError); ok {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to find similar recipe: %w", err)
+	}
+
+	recipeResponse := toRecipeResponse(recipe)
+	s.applyImageFields(recipeResponse, recipe)
+	applyViewerFields(recipeResponse, recipe, user)
+
+	return recipeResponse, nil
+}
@@ -0,0 +1,208 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/windoze95/saltybytes-api/internal/models"
+	"github.com/windoze95/saltybytes-api/internal/repository"
+)
+
+// defaultStripeSignatureTolerance is used when the config doesn't set a signature tolerance.
+const defaultStripeSignatureTolerance = 5 * time.Minute
+
+// defaultStripeSubscriptionDays is used when the config doesn't set how long a subscription lasts.
+const defaultStripeSubscriptionDays = 31
+
+// Stripe webhook event types that are handled, the others are ignored.
+const (
+	stripeCheckoutSessionCompleted = "checkout.session.completed"
+	stripeSubscriptionDeleted      = "customer.subscription.deleted"
+)
+
+// stripeEvent is a Stripe webhook event, with the object it's about left raw until its type is known.
+type stripeEvent struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	Data struct {
+		Object json.RawMessage `json:"object"`
+	} `json:"data"`
+}
+
+// stripeCheckoutSession is the part of a Stripe checkout session the webhook uses.
+// Sessions are created with the user's ID as the client reference ID, and the tier they buy in the metadata.
+type stripeCheckoutSession struct {
+	ClientReferenceID string            `json:"client_reference_id"`
+	Customer          string            `json:"customer"`
+	Mode              string            `json:"mode"`
+	PaymentStatus     string            `json:"payment_status"`
+	Metadata          map[string]string `json:"metadata"`
+}
+
+// stripeSubscription is the part of a Stripe subscription the webhook uses.
+type stripeSubscription struct {
+	Customer string `json:"customer"`
+}
+
+// HandleStripeWebhook verifies the signature of a Stripe webhook event and applies it to the subscription of the user
+// it's for. An unsigned or invalid event is a ValidationError, and events can't be handled at all until the webhook
+// secret is configured. Event types that aren't handled, events for users that no longer exist, and events that were
+// already applied are ignored.
+func (s *UserService) HandleStripeWebhook(payload []byte, signatureHeader string) error {
+	secret := s.Cfg.OptionalEnv.StripeWebhookSecret.Value()
+	if secret == "" {
+		log.Printf("Error handling Stripe webhook: $%s is not set", s.Cfg.OptionalEnv.StripeWebhookSecret)
+		return UnavailableError{message: "Stripe webhooks are not configured"}
+	}
+
+	tolerance := s.Cfg.Stripe.SignatureTolerance()
+	if tolerance <= 0 {
+		tolerance = defaultStripeSignatureTolerance
+	}
+	if err := verifyStripeSignature(payload, signatureHeader, secret, tolerance, time.Now()); err != nil {
+		return err
+	}
+
+	var event stripeEvent
+	if err := json.Unmarshal(payload, &event); err != nil || event.ID == "" {
+		return ValidationError{message: "Invalid Stripe event"}
+	}
+
+	switch event.Type {
+	case stripeCheckoutSessionCompleted:
+		var session stripeCheckoutSession
+		if err := json.Unmarshal(event.Data.Object, &session); err != nil {
+			return ValidationError{message: "Invalid Stripe checkout session"}
+		}
+		return s.startStripeSubscription(event.ID, &session)
+	case stripeSubscriptionDeleted:
+		var subscription stripeSubscription
+		if err := json.Unmarshal(event.Data.Object, &subscription); err != nil {
+			return ValidationError{message: "Invalid Stripe subscription"}
+		}
+		return s.endStripeSubscription(event.ID, &subscription)
+	default:
+		return nil
+	}
+}
+
+// startStripeSubscription upgrades the user who completed a subscription checkout to the tier they bought,
+// and stores their Stripe customer ID so the subscription can be ended later.
+func (s *UserService) startStripeSubscription(eventID string, session *stripeCheckoutSession) error {
+	// Payment sessions aren't subscriptions, and unpaid ones are completed again once they're paid
+	if session.Mode != "subscription" || session.PaymentStatus == "unpaid" {
+		return nil
+	}
+
+	userID, err := strconv.ParseUint(session.ClientReferenceID, 10, 32)
+	if err != nil || userID == 0 {
+		return ValidationError{message: "Stripe checkout session has no valid client reference ID"}
+	}
+	if session.Customer == "" {
+		return ValidationError{message: "Stripe checkout session has no customer"}
+	}
+	tier := models.SubscriptionTier(session.Metadata["tier"])
+	if tier == models.Free || !(&models.Subscription{SubscriptionTier: tier}).IsValidSubscriptionTier() {
+		return ValidationError{message: "Stripe checkout session has no valid subscription tier"}
+	}
+
+	days := s.Cfg.Stripe.SubscriptionDays
+	if days <= 0 {
+		days = defaultStripeSubscriptionDays
+	}
+	expiresAt := time.Now().AddDate(0, 0, days)
+
+	err = s.Repo.StartStripeSubscription(eventID, uint(userID), session.Customer, tier, expiresAt, tier.MonthlyUses())
+	if err != nil {
+		switch err.(type) {
+		case repository.AlreadyProcessedError:
+			// Stripe delivers events at least once, a redelivery mustn't refill the uses or extend the subscription
+			log.Printf("Skipping Stripe event %s, it was already applied", eventID)
+			return nil
+		case repository.NotFoundError, repository.DuplicateError:
+			// Retrying won't help, so the event is acknowledged
+			log.Printf("Error handling Stripe event %s, ignoring it: user %d: %v", eventID, userID, err)
+			return nil
+		default:
+			return fmt.Errorf("failed to start subscription: %w", err)
+		}
+	}
+
+	log.Printf("Started %s subscription of user %d from Stripe event %s", tier, userID, eventID)
+	return nil
+}
+
+// endStripeSubscription downgrades the user whose Stripe subscription ended to the free tier,
+// starting a billing cycle of the free tier right away.
+func (s *UserService) endStripeSubscription(eventID string, subscription *stripeSubscription) error {
+	if subscription.Customer == "" {
+		return ValidationError{message: "Stripe subscription has no customer"}
+	}
+
+	expiresAt := time.Now().AddDate(0, 1, 0)
+	userID, err := s.Repo.EndStripeSubscription(eventID, subscription.Customer, models.Free, expiresAt, models.Free.MonthlyUses())
+	if err != nil {
+		switch err.(type) {
+		case repository.AlreadyProcessedError:
+			log.Printf("Skipping Stripe event %s, it was already applied", eventID)
+			return nil
+		case repository.NotFoundError:
+			log.Printf("Error handling Stripe event %s, ignoring it: no user has customer %s", eventID, subscription.Customer)
+			return nil
+		default:
+			return fmt.Errorf("failed to end subscription: %w", err)
+		}
+	}
+
+	log.Printf("Ended subscription of user %d from Stripe event %s", userID, eventID)
+	return nil
+}
+
+// verifyStripeSignature verifies the Stripe-Signature header of a webhook event, t=<timestamp>,v1=<signature>,...
+// Each v1 signature is an HMAC-SHA256 of "<timestamp>.<payload>" with the webhook secret, one matching is enough.
+// Signatures older or newer than the tolerance are rejected, so captured events can't be replayed.
+func verifyStripeSignature(payload []byte, header, secret string, tolerance time.Duration, now time.Time) error {
+	var timestamp string
+	var signatures [][]byte
+	for _, pair := range strings.Split(header, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			if signature, err := hex.DecodeString(value); err == nil {
+				signatures = append(signatures, signature)
+			}
+		}
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return ValidationError{message: "Stripe signature is missing or malformed"}
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > tolerance || age < -tolerance {
+		return ValidationError{message: "Stripe signature timestamp is outside the tolerance"}
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	expected := mac.Sum(nil)
+	for _, signature := range signatures {
+		if hmac.Equal(signature, expected) {
+			return nil
+		}
+	}
+
+	return ValidationError{message: "Stripe signature is invalid"}
+}
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"log"
+	"time"
+
+	"github.com/windoze95/saltybytes-api/internal/s3"
+)
+
+// defaultTrashPurgeBatchSize is how many recipes are purged per transaction when the batch size isn't configured.
+const defaultTrashPurgeBatchSize = 100
+
+// StartTrashPurger purges the expired recipes from the trash every purge interval, in the background.
+// It does nothing when the purge interval or the retention window isn't positive.
+// Every instance can run one, each batch is locked so a recipe is only purged once.
+func (s *RecipeService) StartTrashPurger() {
+	interval := s.Cfg.Trash.PurgeInterval()
+	if interval <= 0 || s.Cfg.Trash.Retention() <= 0 {
+		return
+	}
+
+	// Purge goroutine
+	go func() {
+		for range time.Tick(interval) {
+			purged, err := s.PurgeExpiredTrash()
+			if err != nil {
+				log.Printf("Error purging expired trash, after purging %d recipes: %v", purged, err)
+			} else if purged > 0 {
+				log.Printf("Purged %d expired recipes from the trash", purged)
+			}
+		}
+	}()
+}
+
+// PurgeExpiredTrash permanently deletes the recipes that have been in the trash longer than the retention window,
+// and their images, a batch at a time. It returns how many recipes were purged, even when a later batch failed.
+func (s *RecipeService) PurgeExpiredTrash() (int, error) {
+	deletedBefore := time.Now().Add(-s.Cfg.Trash.Retention())
+	batchSize := s.Cfg.Trash.PurgeBatchSize
+	if batchSize <= 0 {
+		batchSize = defaultTrashPurgeBatchSize
+	}
+
+	purged := 0
+	for {
+		recipes, err := s.Repo.PurgeTrashedRecipes(deletedBefore, batchSize)
+		if err != nil {
+			return purged, err
+		}
+
+		// The recipes are gone already, an image that fails to delete is only logged
+		for _, recipe := range recipes {
+			for _, s3Key := range s3.GenerateS3ImageKeys(recipeImageKey(recipe.ID, recipe.ImageKey)) {
+				if err := s3.DeleteRecipeImageFromS3(s.Cfg, s3Key); err != nil {
+					log.Printf("Error purging recipe %d image %s from S3: %v", recipe.ID, s3Key, err)
+				}
+			}
+		}
+		purged += len(recipes)
+
+		// A short batch means the rest are purged or locked by another instance
+		if len(recipes) < batchSize {
+			return purged, nil
+		}
+	}
+}
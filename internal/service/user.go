@@ -4,21 +4,31 @@ import (
 	"errors"
 	"fmt"
 	"regexp"
+	"sort"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	goaway "github.com/TwiN/go-away"
 	"github.com/asaskevich/govalidator"
+	"github.com/google/uuid"
 	"github.com/windoze95/saltybytes-api/internal/config"
+	"github.com/windoze95/saltybytes-api/internal/mail"
 	"github.com/windoze95/saltybytes-api/internal/models"
+	"github.com/windoze95/saltybytes-api/internal/openai"
 	"github.com/windoze95/saltybytes-api/internal/repository"
+	"github.com/windoze95/saltybytes-api/internal/util"
 	"golang.org/x/crypto/bcrypt"
 )
 
 // UserService is the business logic layer for user-related operations.
 type UserService struct {
 	Cfg  *config.Config
-	Repo *repository.UserRepository
+	Repo UserRepository
+	// TokenBlocklist holds the tokens of revoked sessions.
+	TokenBlocklist util.TokenBlocklist
+	// Mailer is optional, when nil emails are logged instead of sent.
+	Mailer mail.Sender
 }
 
 // UserResponse is the response object for user-related operations.
@@ -30,14 +40,16 @@ type UserResponse struct {
 }
 
 // NewUserService is the constructor function for initializing a new UserService
-func NewUserService(cfg *config.Config, repo *repository.UserRepository) *UserService {
+func NewUserService(cfg *config.Config, repo UserRepository, tokenBlocklist util.TokenBlocklist) *UserService {
 	return &UserService{
-		Cfg:  cfg,
-		Repo: repo,
+		Cfg:            cfg,
+		Repo:           repo,
+		TokenBlocklist: tokenBlocklist,
 	}
 }
 
-// CreateUser creates a new user.
+// CreateUser creates a new user who logs in with a password, with their auth, subscription, settings, and
+// personalization. A username or email that's already taken is a ConflictError.
 func (s *UserService) CreateUser(username, firstName, email, password string) (*models.User, error) {
 	// Hash password
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 10)
@@ -47,35 +59,318 @@ func (s *UserService) CreateUser(username, firstName, email, password string) (*
 
 	hashedPasswordStr := string(hashedPassword)
 
-	// Create User and UserSettings
-	user := &models.User{
+	// Create the user with everything that belongs to them
+	return s.createUser(newStandardUser(username, firstName, email, hashedPasswordStr, models.Free))
+}
+
+// createUser saves a user built by newStandardUser, with all of their associations in one transaction.
+// A username or email that's already taken is a ConflictError.
+func (s *UserService) createUser(user *models.User) (*models.User, error) {
+	user, err := s.Repo.CreateUser(user)
+	if err != nil {
+		if e, ok := err.(repository.DuplicateError); ok {
+			return nil, ConflictError{message: e.Error()}
+		}
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// newStandardUser builds a user with password auth and the default settings and personalization.
+func newStandardUser(username, firstName, email, hashedPassword string, tier models.SubscriptionTier) *models.User {
+	return &models.User{
 		Username:  username,
 		FirstName: firstName,
-		Email:     email,
+		Email:     normalizeEmail(email),
 		Auth: &models.UserAuth{
-			HashedPassword: hashedPasswordStr,
+			HashedPassword: hashedPassword,
 			AuthType:       models.Standard,
 		},
 		Subscription: &models.Subscription{
-			SubscriptionTier: models.Free,
+			SubscriptionTier: tier,
 			ExpiresAt:        time.Now().AddDate(0, 1, 0), // One month from now
+			RemainingUses:    tier.MonthlyUses(),
 		},
 		Settings: &models.UserSettings{
 			KeepScreenAwake: true, // Default value
 		},
 		Personalization: &models.Personalization{
 			UnitSystem: models.USCustomary, // Default value
-			// UID:        uuid.New(),
+			UID:        uuid.New(),
 		},
 		// CollectedRecipes: []*models.Recipe{},
 	}
+}
+
+// maxUserImportRows is the most users a single import may contain.
+const maxUserImportRows = 500
+
+// UserImportStatus is the outcome of importing a single user.
+type UserImportStatus string
+
+// UserImportStatus values.
+const (
+	UserImportCreated  UserImportStatus = "created"
+	UserImportConflict UserImportStatus = "conflict"
+	UserImportInvalid  UserImportStatus = "invalid"
+)
+
+// UserImportRow is a single user to import.
+// Exactly one of HashedPassword (a bcrypt hash) and TempPassword must be set.
+type UserImportRow struct {
+	Username       string `json:"username"`
+	FirstName      string `json:"first_name"`
+	Email          string `json:"email"`
+	HashedPassword string `json:"hashed_password"`
+	TempPassword   string `json:"temp_password"`
+	Tier           string `json:"tier"`
+}
 
-	user, err = s.Repo.CreateUser(user)
+// UserImportResult is the outcome of importing the row at Index.
+type UserImportResult struct {
+	Index    int              `json:"index"`
+	Username string           `json:"username"`
+	Status   UserImportStatus `json:"status"`
+	Error    string           `json:"error,omitempty"`
+	UserID   uint             `json:"user_id,omitempty"`
+}
+
+// ImportUsers validates and creates users in bulk, for admins.
+// Rows that fail validation or collide with an existing or earlier user are skipped
+// and reported; the rest are created together.
+func (s *UserService) ImportUsers(rows []UserImportRow) ([]UserImportResult, error) {
+	if len(rows) == 0 {
+		return nil, ValidationError{message: "no users to import"}
+	}
+	if len(rows) > maxUserImportRows {
+		return nil, ValidationError{message: fmt.Sprintf("at most %d users can be imported at once", maxUserImportRows)}
+	}
+
+	results := make([]UserImportResult, len(rows))
+	var users []*models.User
+	var userIndexes []int
+	seenUsernames := make(map[string]bool)
+	seenEmails := make(map[string]bool)
+	for i, row := range rows {
+		results[i] = UserImportResult{Index: i, Username: row.Username}
+
+		lowerUsername := strings.ToLower(row.Username)
+		lowerEmail := normalizeEmail(row.Email)
+		if seenUsernames[lowerUsername] {
+			results[i].Status = UserImportConflict
+			results[i].Error = "username appears earlier in the import"
+			continue
+		}
+		if seenEmails[lowerEmail] {
+			results[i].Status = UserImportConflict
+			results[i].Error = "email appears earlier in the import"
+			continue
+		}
+
+		exists, err := s.Repo.UsernameExists(row.Username)
+		if err != nil {
+			return nil, fmt.Errorf("error checking username: %v", err)
+		}
+		if exists {
+			results[i].Status = UserImportConflict
+			results[i].Error = "username is already taken"
+			continue
+		}
+
+		exists, err = s.Repo.EmailExists(row.Email)
+		if err != nil {
+			return nil, fmt.Errorf("error checking email: %v", err)
+		}
+		if exists {
+			results[i].Status = UserImportConflict
+			results[i].Error = "email is already registered"
+			continue
+		}
+
+		user, err := s.newImportedUser(row)
+		if err != nil {
+			results[i].Status = UserImportInvalid
+			results[i].Error = err.Error()
+			continue
+		}
+
+		seenUsernames[lowerUsername] = true
+		seenEmails[lowerEmail] = true
+		users = append(users, user)
+		userIndexes = append(userIndexes, i)
+	}
+
+	if len(users) == 0 {
+		return results, nil
+	}
+
+	conflicts, err := s.Repo.ImportUsers(users)
 	if err != nil {
+		return nil, fmt.Errorf("error importing users: %v", err)
+	}
+	for j, i := range userIndexes {
+		if conflicts[j] != nil {
+			results[i].Status = UserImportConflict
+			results[i].Error = conflicts[j].Error()
+			continue
+		}
+		results[i].Status = UserImportCreated
+		results[i].UserID = users[j].ID
+	}
+
+	return results, nil
+}
+
+// newImportedUser validates an import row and builds the user it describes.
+func (s *UserService) newImportedUser(row UserImportRow) (*models.User, error) {
+	if err := s.ValidateUsername(row.Username); err != nil {
+		return nil, err
+	}
+	if err := s.ValidateEmail(row.Email); err != nil {
 		return nil, err
 	}
 
-	return user, nil
+	tier := models.Free
+	if row.Tier != "" {
+		subscription := models.Subscription{SubscriptionTier: models.SubscriptionTier(row.Tier)}
+		if !subscription.IsValidSubscriptionTier() {
+			return nil, fmt.Errorf("invalid tier %q", row.Tier)
+		}
+		tier = subscription.SubscriptionTier
+	}
+
+	var hashedPassword string
+	switch {
+	case row.HashedPassword != "" && row.TempPassword != "":
+		return nil, errors.New("only one of hashed_password and temp_password can be set")
+	case row.HashedPassword != "":
+		if _, err := bcrypt.Cost([]byte(row.HashedPassword)); err != nil {
+			return nil, errors.New("hashed_password must be a bcrypt hash")
+		}
+		hashedPassword = row.HashedPassword
+	case row.TempPassword != "":
+		if err := s.ValidatePassword(row.TempPassword); err != nil {
+			return nil, err
+		}
+		hashed, err := bcrypt.GenerateFromPassword([]byte(row.TempPassword), 10)
+		if err != nil {
+			return nil, fmt.Errorf("error hashing password: %v", err)
+		}
+		hashedPassword = string(hashed)
+	default:
+		return nil, errors.New("either hashed_password or temp_password is required")
+	}
+
+	return newStandardUser(row.Username, row.FirstName, row.Email, hashedPassword, tier), nil
+}
+
+// SessionResponse is the response object for a user's login session.
+type SessionResponse struct {
+	ID        uint      `json:"id"`
+	UserAgent string    `json:"user_agent"`
+	IPAddress string    `json:"ip_address"`
+	IssuedAt  time.Time `json:"issued_at"`
+	Current   bool      `json:"current"` // The session of the token the request was made with
+}
+
+// StartSession records a login from a device and returns the session, whose TokenID is the jti claim of its token.
+// The session expires after the configured token lifetime.
+func (s *UserService) StartSession(userID uint, userAgent, ipAddress string) (*models.UserSession, error) {
+	issuedAt := time.Now()
+	expiresAt := issuedAt.Add(s.Cfg.Auth.TokenTTL())
+	session := &models.UserSession{
+		UserID:    userID,
+		TokenID:   uuid.New().String(),
+		UserAgent: userAgent,
+		IPAddress: ipAddress,
+		IssuedAt:  issuedAt,
+		ExpiresAt: &expiresAt,
+	}
+	if err := s.Repo.RecordLogin(session); err != nil {
+		return nil, fmt.Errorf("error recording login: %v", err)
+	}
+	return session, nil
+}
+
+// RefreshSession exchanges a session's token for a new session, when the token expires within the refresh window.
+// The old session is revoked before the new one is started, so its token can't be used or refreshed again, and a
+// refresh that fails never leaves both tokens working.
+func (s *UserService) RefreshSession(userID uint, tokenID string, expiresAt time.Time, userAgent, ipAddress string) (*models.UserSession, error) {
+	if tokenID == "" {
+		return nil, ValidationError{message: "this token predates sessions and can't be refreshed, log in again to get one that can"}
+	}
+	if expiresAt.IsZero() {
+		return nil, ValidationError{message: "this token doesn't expire, there's no need to refresh it"}
+	}
+	window := s.Cfg.Auth.RefreshWindow()
+	if time.Until(expiresAt) > window {
+		return nil, ValidationError{message: fmt.Sprintf("tokens can only be refreshed in the last %v before they expire", window)}
+	}
+
+	oldSession, err := s.Repo.RevokeUserSessionByToken(userID, tokenID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.blockSessionToken(oldSession); err != nil {
+		return nil, err
+	}
+
+	return s.StartSession(userID, userAgent, ipAddress)
+}
+
+// ListSessions lists a user's active sessions, marking the one with the current token ID.
+func (s *UserService) ListSessions(userID uint, currentTokenID string) ([]SessionResponse, error) {
+	sessions, err := s.Repo.ListActiveUserSessions(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	responses := make([]SessionResponse, len(sessions))
+	for i, session := range sessions {
+		responses[i] = SessionResponse{
+			ID:        session.ID,
+			UserAgent: session.UserAgent,
+			IPAddress: session.IPAddress,
+			IssuedAt:  session.IssuedAt,
+			Current:   currentTokenID != "" && session.TokenID == currentTokenID,
+		}
+	}
+	return responses, nil
+}
+
+// RevokeSession revokes one of a user's sessions, invalidating its token.
+func (s *UserService) RevokeSession(userID uint, sessionID uint) error {
+	session, err := s.Repo.RevokeUserSession(userID, sessionID)
+	if err != nil {
+		return err
+	}
+	return s.blockSessionToken(session)
+}
+
+// LogoutUser revokes the session of the token a user is logged in with, invalidating the token.
+func (s *UserService) LogoutUser(userID uint, tokenID string) error {
+	if tokenID == "" {
+		return ValidationError{message: "this token predates sessions and can't be logged out, log in again to get one that can"}
+	}
+
+	session, err := s.Repo.RevokeUserSessionByToken(userID, tokenID)
+	if err != nil {
+		return err
+	}
+	return s.blockSessionToken(session)
+}
+
+// blockSessionToken adds the token of a revoked session to the blocklist until the token expires.
+func (s *UserService) blockSessionToken(session *models.UserSession) error {
+	var expiresAt time.Time
+	if session.ExpiresAt != nil {
+		expiresAt = *session.ExpiresAt
+	}
+	if err := s.TokenBlocklist.Block(session.TokenID, expiresAt); err != nil {
+		return fmt.Errorf("error blocking token: %v", err)
+	}
+	return nil
 }
 
 // LoginUser logs in a user.
@@ -89,13 +384,13 @@ func (s *UserService) LoginUser(username, password string) (*UserResponse, error
 		return nil, errors.New("invalid username or password")
 	}
 
-	userResponse := toUserResponse(user)
+	userResponse := ToUserResponse(user)
 
 	return userResponse, nil
 }
 
-// toUserResponse converts a User to a UserResponse.
-func toUserResponse(user *models.User) *UserResponse {
+// ToUserResponse converts a User to a UserResponse.
+func ToUserResponse(user *models.User) *UserResponse {
 	return &UserResponse{
 		ID:        user.ID,
 		Username:  user.Username,
@@ -109,11 +404,459 @@ func (s *UserService) GetUserByID(userID uint) (*models.User, error) {
 	return s.Repo.GetUserByID(userID)
 }
 
+// UserStatsResponse is the response object for a user's stats dashboard.
+type UserStatsResponse struct {
+	TotalRecipes         int                    `json:"total_recipes"`
+	RecipesThisMonth     int                    `json:"recipes_this_month"`
+	TotalCookTime        int                    `json:"total_cook_time"`
+	TimesMade            int                    `json:"times_made"`
+	FavoriteHashtag      string                 `json:"favorite_hashtag"`
+	TopHashtags          []repository.NameCount `json:"top_hashtags"`
+	TopIngredients       []repository.NameCount `json:"top_ingredients"`
+	GenerationsToday     int                    `json:"generations_today"`
+	DailyGenerationLimit int                    `json:"daily_generation_limit"`
+}
+
+// GetUserStats aggregates a user's recipes and usage for their stats dashboard.
+func (s *UserService) GetUserStats(user *models.User) (*UserStatsResponse, error) {
+	// Number of ingredients and hashtags to rank
+	const topN = 5
+
+	now := time.Now().UTC()
+	stats, err := s.Repo.GetUserRecipeStats(user.ID, monthStart(now), topN)
+	if err != nil {
+		return nil, fmt.Errorf("error aggregating user stats: %v", err)
+	}
+
+	statsResponse := &UserStatsResponse{
+		TotalRecipes:     stats.RecipeCount,
+		RecipesThisMonth: stats.RecipesSince,
+		TotalCookTime:    stats.TotalCookTime,
+		TimesMade:        stats.TimesMade,
+		TopHashtags:      stats.TopHashtags,
+		TopIngredients:   stats.TopIngredients,
+	}
+	if len(stats.TopHashtags) > 0 {
+		statsResponse.FavoriteHashtag = stats.TopHashtags[0].Name
+	}
+
+	// The daily count only applies to the day it was counted on
+	if subscription := user.Subscription; subscription != nil {
+		statsResponse.DailyGenerationLimit = s.Cfg.Limits.DailyGenerationCap(string(subscription.SubscriptionTier))
+		today := now.Truncate(24 * time.Hour)
+		if subscription.GenerationsDay != nil && subscription.GenerationsDay.Equal(today) {
+			statsResponse.GenerationsToday = subscription.GenerationsToday
+		}
+	}
+
+	return statsResponse, nil
+}
+
+// TokenUsageResponse is the response object for a user's OpenAI usage in the current billing period.
+type TokenUsageResponse struct {
+	PeriodStart      time.Time `json:"period_start"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	TotalTokens      int       `json:"total_tokens"`
+	Images           int       `json:"images"`
+	// EstimatedCostUSD is the estimated cost of all the usage, PersonalKeyCostUSD of what the user's personal key paid for.
+	EstimatedCostUSD   float64                 `json:"estimated_cost_usd"`
+	PersonalKeyCostUSD float64                 `json:"personal_key_cost_usd"`
+	Models             []repository.ModelUsage `json:"models"`
+}
+
+// GetTokenUsage sums the user's OpenAI usage in the current billing period, the calendar month in UTC.
+func (s *UserService) GetTokenUsage(user *models.User) (*TokenUsageResponse, error) {
+	periodStart := monthStart(time.Now())
+	usages, err := s.Repo.GetTokenUsageByUser(user.ID, periodStart)
+	if err != nil {
+		return nil, fmt.Errorf("error summing token usage: %v", err)
+	}
+
+	usageResponse := &TokenUsageResponse{PeriodStart: periodStart, Models: []repository.ModelUsage{}}
+	var costMicros, personalKeyCostMicros int64
+	for _, usage := range usages {
+		usageResponse.PromptTokens += usage.PromptTokens
+		usageResponse.CompletionTokens += usage.CompletionTokens
+		usageResponse.Images += usage.Images
+		costMicros += usage.CostMicros
+		personalKeyCostMicros += usage.PersonalKeyCostMicros
+		usageResponse.Models = append(usageResponse.Models, usage)
+	}
+	usageResponse.TotalTokens = usageResponse.PromptTokens + usageResponse.CompletionTokens
+	usageResponse.EstimatedCostUSD = float64(costMicros) / 1e6
+	usageResponse.PersonalKeyCostUSD = float64(personalKeyCostMicros) / 1e6
+
+	return usageResponse, nil
+}
+
+// monthStart returns the start of the UTC month of t.
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// ConsumeDailyGeneration counts a recipe generation against the user's daily cap for their subscription tier.
+// The count resets at midnight UTC. It returns the day the generation was counted on, so it can be restored if the
+// generation fails, or the zero time if it wasn't counted because the tier has no cap.
+func (s *UserService) ConsumeDailyGeneration(user *models.User) (time.Time, error) {
+	if user.Subscription == nil {
+		return time.Time{}, errors.New("user's Subscription is nil")
+	}
+
+	dailyCap := s.Cfg.Limits.DailyGenerationCap(string(user.Subscription.SubscriptionTier))
+	if dailyCap <= 0 {
+		return time.Time{}, nil
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	counted, err := s.Repo.IncrementDailyGenerations(user.ID, today, dailyCap)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error counting daily generation: %v", err)
+	}
+	if !counted {
+		return time.Time{}, TooManyRequestsError{message: fmt.Sprintf("Daily limit of %d recipe generations reached, it resets at midnight UTC", dailyCap)}
+	}
+
+	return today, nil
+}
+
+// RestoreDailyGeneration gives back a recipe generation counted on the day against the user's daily cap, that failed.
+func (s *UserService) RestoreDailyGeneration(user *models.User, day time.Time) error {
+	return s.Repo.RestoreDailyGeneration(user.ID, day)
+}
+
+// ConsumeSubscriptionUse spends one of the user's remaining uses for the billing cycle on a recipe generation,
+// refilling them first if a new cycle has started. It returns whether a use was spent, so it can be restored if the
+// generation fails. Generating with a personal OpenAI key doesn't count against the subscription.
+func (s *UserService) ConsumeSubscriptionUse(user *models.User) (bool, error) {
+	if user.Subscription == nil {
+		return false, errors.New("user's Subscription is nil")
+	}
+	if user.Settings != nil && user.Settings.UsesOpenAIKey() {
+		return false, nil
+	}
+
+	if err := s.EnsureQuotaFresh(user); err != nil {
+		return false, err
+	}
+
+	spent, err := s.Repo.DecrementRemainingUses(user.ID)
+	if err != nil {
+		return false, fmt.Errorf("error spending remaining use: %v", err)
+	}
+	if !spent {
+		return false, PaymentRequiredError{message: fmt.Sprintf("You have no remaining uses this billing cycle, they refill on %s, or upgrade your subscription to keep generating recipes",
+			user.Subscription.ExpiresAt.UTC().Format("January 2"))}
+	}
+
+	return true, nil
+}
+
+// RestoreSubscriptionUse gives back a remaining use spent on a recipe generation that failed.
+func (s *UserService) RestoreSubscriptionUse(user *models.User) error {
+	return s.Repo.RestoreRemainingUse(user.ID)
+}
+
+// SettingsUpdate is a partial update of a user's settings and personalization.
+// Fields left nil are not changed.
+type SettingsUpdate struct {
+	OpenAIKey         *string            `json:"openai_key"`
+	KeepScreenAwake   *bool              `json:"keep_screen_awake"`
+	UsePersonalAPIKey *bool              `json:"use_personal_api_key"`
+	UnitSystem        *models.UnitSystem `json:"unit_system"`
+	Language          *string            `json:"language"`
+	Persona           *models.Persona    `json:"persona"`
+	// DietaryRestrictions replaces the user's dietary restrictions, an empty list removes them
+	DietaryRestrictions *[]string `json:"dietary_restrictions"`
+	// AllergenExclusions replaces the allergens the user avoids, an empty list removes them
+	AllergenExclusions *[]string `json:"allergen_exclusions"`
+	// MonthlySpendCapCents caps the estimated monthly spend on the personal OpenAI key, 0 removes the cap
+	MonthlySpendCapCents *int `json:"monthly_spend_cap_cents"`
+	// ImageSize, ImageQuality, and ImageStyle set the default options of the user's recipe images, empty resets them
+	ImageSize    *string `json:"image_size"`
+	ImageQuality *string `json:"image_quality"`
+	ImageStyle   *string `json:"image_style"`
+}
+
+// SettingsResponse is the response object for settings-related operations.
+type SettingsResponse struct {
+	Settings        *models.UserSettings    `json:"settings"`
+	HasOpenAIKey    bool                    `json:"has_openai_key"`
+	Personalization *models.Personalization `json:"personalization"`
+}
+
+// UpdateSettings applies a partial update to a user's settings and personalization in one transaction,
+// and returns the full updated settings.
+func (s *UserService) UpdateSettings(user *models.User, update *SettingsUpdate) (*SettingsResponse, error) {
+	if err := validateSettingsUpdate(update); err != nil {
+		return nil, err
+	}
+
+	// Encrypt the personal key before opening the transaction
+	var encryptedOpenAIKey *string
+	if update.OpenAIKey != nil {
+		encrypted := ""
+		if key := strings.TrimSpace(*update.OpenAIKey); key != "" {
+			if s.Cfg.OptionalEnv.OpenaiKeyEncryptionKey.Value() == "" {
+				return nil, ValidationError{message: "personal OpenAI keys are not enabled"}
+			}
+
+			keyring, err := util.OpenAIKeyringFromConfig(s.Cfg)
+			if err != nil {
+				return nil, fmt.Errorf("error loading OpenAI key encryption keys: %v", err)
+			}
+			encrypted, err = util.EncryptOpenAIKey(keyring, key)
+			if err != nil {
+				return nil, fmt.Errorf("error encrypting OpenAI key: %v", err)
+			}
+		}
+		encryptedOpenAIKey = &encrypted
+	}
+
+	err := s.Repo.UpdateSettingsAndPersonalization(user.ID, func(settings *models.UserSettings, personalization *models.Personalization) error {
+		previous := *personalization
+		if encryptedOpenAIKey != nil {
+			settings.EncryptedOpenAIKey = *encryptedOpenAIKey
+		}
+		if update.KeepScreenAwake != nil {
+			settings.KeepScreenAwake = *update.KeepScreenAwake
+		}
+		if update.UsePersonalAPIKey != nil {
+			settings.UsePersonalAPIKey = *update.UsePersonalAPIKey
+		}
+		if update.UnitSystem != nil {
+			personalization.UnitSystem = *update.UnitSystem
+		}
+		if update.Language != nil {
+			personalization.Language = *update.Language
+		}
+		if update.Persona != nil {
+			personalization.Persona = *update.Persona
+		}
+		if update.DietaryRestrictions != nil {
+			personalization.DietaryRestrictions = normalizeDietaryRestrictions(*update.DietaryRestrictions)
+		}
+		if update.AllergenExclusions != nil {
+			personalization.AllergenExclusions = models.NormalizeAllergens(*update.AllergenExclusions)
+		}
+		if update.MonthlySpendCapCents != nil {
+			settings.MonthlySpendCapCents = *update.MonthlySpendCapCents
+		}
+		if update.ImageSize != nil {
+			settings.ImageSize = *update.ImageSize
+		}
+		if update.ImageQuality != nil {
+			settings.ImageQuality = *update.ImageQuality
+		}
+		if update.ImageStyle != nil {
+			settings.ImageStyle = *update.ImageStyle
+		}
+
+		// Validate the combination of the stored and updated settings
+		if settings.UsePersonalAPIKey && !settings.HasOpenAIKey() {
+			return ValidationError{message: "using a personal API key requires an OpenAI key"}
+		}
+
+		rotatePersonalizationUID(&previous, personalization)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	updatedUser, err := s.Repo.GetUserByID(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SettingsResponse{
+		Settings:        updatedUser.Settings,
+		HasOpenAIKey:    updatedUser.Settings.HasOpenAIKey(),
+		Personalization: updatedUser.Personalization,
+	}, nil
+}
+
+// validateSettingsUpdate validates the fields of a settings update on their own.
+func validateSettingsUpdate(update *SettingsUpdate) error {
+	if update.UnitSystem != nil {
+		personalization := models.Personalization{UnitSystem: *update.UnitSystem}
+		if !personalization.IsValidUnitSystem() {
+			return ValidationError{message: "invalid unit system"}
+		}
+	}
+
+	if update.Language != nil && !models.IsSupportedLanguage(*update.Language) {
+		return ValidationError{message: fmt.Sprintf("unsupported language '%s'", *update.Language)}
+	}
+
+	if update.Persona != nil && !update.Persona.IsValidPersona() {
+		return ValidationError{message: fmt.Sprintf("unknown persona '%s'", *update.Persona)}
+	}
+
+	if update.MonthlySpendCapCents != nil && *update.MonthlySpendCapCents < 0 {
+		return ValidationError{message: "monthly spend cap can't be negative"}
+	}
+
+	imageOptions := openai.ImageOptions{}
+	if update.ImageSize != nil {
+		imageOptions.Size = *update.ImageSize
+	}
+	if update.ImageQuality != nil {
+		imageOptions.Quality = *update.ImageQuality
+	}
+	if update.ImageStyle != nil {
+		imageOptions.Style = *update.ImageStyle
+	}
+	if err := openai.ValidateImageOptions(imageOptions); err != nil {
+		return ValidationError{message: err.Error()}
+	}
+
+	if update.DietaryRestrictions != nil {
+		for _, restriction := range normalizeDietaryRestrictions(*update.DietaryRestrictions) {
+			if !models.DietaryRestriction(restriction).IsValidDietaryRestriction() {
+				return ValidationError{message: fmt.Sprintf("unknown dietary restriction '%s'", restriction)}
+			}
+		}
+	}
+
+	if update.AllergenExclusions != nil {
+		for _, allergen := range *update.AllergenExclusions {
+			allergen = strings.ToLower(strings.TrimSpace(allergen))
+			if allergen != "" && !models.Allergen(allergen).IsValidAllergen() {
+				return ValidationError{message: fmt.Sprintf("unknown allergen '%s'", allergen)}
+			}
+		}
+	}
+
+	return nil
+}
+
+// normalizeDietaryRestrictions lowercases and trims dietary restrictions, and drops blank and repeated ones.
+// They're sorted, so the same restrictions are always stored the same way.
+func normalizeDietaryRestrictions(restrictions []string) []string {
+	normalized := make([]string, 0, len(restrictions))
+	seen := make(map[string]bool, len(restrictions))
+	for _, restriction := range restrictions {
+		restriction = strings.ToLower(strings.TrimSpace(restriction))
+		if restriction == "" || seen[restriction] {
+			continue
+		}
+		seen[restriction] = true
+		normalized = append(normalized, restriction)
+	}
+	sort.Strings(normalized)
+	return normalized
+}
+
+// SetFeatureFlag enables or disables a beta feature for a user.
+func (s *UserService) SetFeatureFlag(userID uint, flag models.FeatureFlag, enabled bool) error {
+	if !flag.IsValidFeatureFlag() {
+		return ValidationError{message: fmt.Sprintf("unknown feature flag '%s'", flag)}
+	}
+
+	return s.Repo.SetUserFeatureFlag(userID, string(flag), enabled)
+}
+
+// MaxRequirementsLength is the most characters a user's requirements can have, since they're sent with every generation.
+const MaxRequirementsLength = 500
+
+// UpdateGuidingContent replaces the unit system and requirements recipes are generated for the user with.
+func (s *UserService) UpdateGuidingContent(userID uint, unitSystem models.UnitSystem, requirements string) error {
+	requirements = strings.TrimSpace(requirements)
+	if err := validateGuidingContent(unitSystem, requirements); err != nil {
+		return err
+	}
+
+	return s.Repo.UpdateSettingsAndPersonalization(userID, func(settings *models.UserSettings, personalization *models.Personalization) error {
+		previous := *personalization
+		personalization.UnitSystem = unitSystem
+		personalization.Requirements = requirements
+		rotatePersonalizationUID(&previous, personalization)
+		return nil
+	})
+}
+
+// validateGuidingContent validates the unit system and requirements of a personalization.
+func validateGuidingContent(unitSystem models.UnitSystem, requirements string) error {
+	personalization := models.Personalization{UnitSystem: unitSystem}
+	if !personalization.IsValidUnitSystem() {
+		return ValidationError{message: "invalid unit system"}
+	}
+
+	if utf8.RuneCountInString(requirements) > MaxRequirementsLength {
+		return ValidationError{message: fmt.Sprintf("requirements can be at most %d characters", MaxRequirementsLength)}
+	}
+
+	if newProfanityDetector().IsProfane(requirements) {
+		return ValidationError{message: "requirements contain inappropriate language"}
+	}
+
+	return nil
+}
+
+// rotatePersonalizationUID gives the personalization a new UID if anything recipes are generated with has changed
+// since previous, so whatever is keyed on the UID sees it as new.
+func rotatePersonalizationUID(previous, personalization *models.Personalization) {
+	if previous.UnitSystem == personalization.UnitSystem &&
+		previous.Requirements == personalization.Requirements &&
+		previous.Language == personalization.Language &&
+		previous.Persona == personalization.Persona &&
+		strings.Join(previous.DietaryRestrictions, ",") == strings.Join(personalization.DietaryRestrictions, ",") {
+		return
+	}
+	personalization.UID = uuid.New()
+}
+
+// SetRole changes a user's role on behalf of an admin. Admins can't change their own role, so there's always one left.
+func (s *UserService) SetRole(admin *models.User, userID uint, role models.Role) error {
+	if !role.IsValidRole() {
+		return ValidationError{message: fmt.Sprintf("unknown role '%s'", role)}
+	}
+
+	if userID == admin.ID {
+		return ForbiddenError{message: "Admins can't change their own role"}
+	}
+
+	return s.Repo.SetUserRole(userID, role)
+}
+
 // UpdatePersonalization updates a user's personalization settings.
 func (s *UserService) UpdatePersonalization(user *models.User, updatedPersonalization *models.Personalization) error {
 	return s.Repo.UpdatePersonalization(user.ID, updatedPersonalization)
 }
 
+// RotateOpenAIKeyEncryption re-encrypts every stored personal OpenAI key with the current encryption key, for admins.
+// Once it reports no failures, the previous encryption keys are no longer needed.
+func (s *UserService) RotateOpenAIKeyEncryption() (*repository.OpenAIKeyReencryptionResult, error) {
+	keyring, err := util.OpenAIKeyringFromConfig(s.Cfg)
+	if err != nil {
+		return nil, ValidationError{message: fmt.Sprintf("OpenAI key encryption keys are not configured: %v", err)}
+	}
+
+	return s.Repo.ReencryptOpenAIKeys(func(ciphertext string) (string, bool, error) {
+		if !keyring.NeedsReencryption(ciphertext) {
+			return "", false, nil
+		}
+
+		plaintext, err := util.DecryptOpenAIKey(keyring, ciphertext)
+		if err != nil {
+			return "", false, err
+		}
+		reencrypted, err := util.EncryptOpenAIKey(keyring, plaintext)
+		if err != nil {
+			return "", false, err
+		}
+		return reencrypted, true, nil
+	})
+}
+
+// BackfillPersonalizations gives every existing user the personalization recipe generation needs, for admins.
+func (s *UserService) BackfillPersonalizations() (*repository.PersonalizationBackfillResult, error) {
+	return s.Repo.BackfillPersonalizations()
+}
+
 // ValidateUsername validates a username against a set of rules.
 func (s *UserService) ValidateUsername(username string) error {
 	// Check if the username already exists.
@@ -187,8 +930,7 @@ func (s *UserService) ValidateUsername(username string) error {
 	}
 
 	// Profanity check
-	profanityDetector := goaway.NewProfanityDetector().WithSanitizeLeetSpeak(true).WithSanitizeSpecialCharacters(true).WithSanitizeAccents(false)
-	if profanityDetector.IsProfane(username) {
+	if newProfanityDetector().IsProfane(username) {
 		return fmt.Errorf("username contains inappropriate language")
 	}
 
@@ -196,14 +938,36 @@ func (s *UserService) ValidateUsername(username string) error {
 	return nil
 }
 
+// newProfanityDetector returns the profanity detector user-written text is checked with.
+func newProfanityDetector() *goaway.ProfanityDetector {
+	return goaway.NewProfanityDetector().WithSanitizeLeetSpeak(true).WithSanitizeSpecialCharacters(true).WithSanitizeAccents(false)
+}
+
 // ValidateEmail validates an email address against a set of rules.
 func (s *UserService) ValidateEmail(email string) error {
+	email = normalizeEmail(email)
 	if !govalidator.IsEmail(email) {
 		return fmt.Errorf("invalid email format")
 	}
+
+	// Check if the email is already registered, regardless of case.
+	// This is also caught as a known error in the repository.
+	exists, err := s.Repo.EmailExists(email)
+	if err != nil {
+		return fmt.Errorf("error checking email: %v", err)
+	}
+	if exists {
+		return fmt.Errorf("email is already registered")
+	}
+
 	return nil
 }
 
+// normalizeEmail trims and lowercases an email address, the form emails are checked and stored in.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
 // ValidatePassword validates a password against a set of rules.
 func (s *UserService) ValidatePassword(password string) error {
 	if len(password) < 8 {
@@ -0,0 +1,689 @@
+package service_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/windoze95/saltybytes-api/internal/config"
+	"github.com/windoze95/saltybytes-api/internal/models"
+	"github.com/windoze95/saltybytes-api/internal/repository"
+	"github.com/windoze95/saltybytes-api/internal/service"
+	"github.com/windoze95/saltybytes-api/internal/service/servicetest"
+	"github.com/windoze95/saltybytes-api/internal/util"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestValidateUsername(t *testing.T) {
+	repo := &servicetest.MockUserRepository{
+		UsernameExistsFunc: func(username string) (bool, error) {
+			return username == "taken", nil
+		},
+	}
+	s := service.NewUserService(&config.Config{}, repo, nil)
+
+	tests := []struct {
+		username string
+		wantErr  bool
+	}{
+		{"chef42", false},
+		{"taken", true},
+		{"ab", true},
+		{"chef_42", true},
+		{"admin", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.username, func(t *testing.T) {
+			if err := s.ValidateUsername(tt.username); (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateUsername(%q) = %v, want error %v", tt.username, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateEmail(t *testing.T) {
+	var checked []string
+	repo := &servicetest.MockUserRepository{
+		EmailExistsFunc: func(email string) (bool, error) {
+			checked = append(checked, email)
+			return email == "chef@example.com", nil
+		},
+	}
+	s := service.NewUserService(&config.Config{}, repo, nil)
+
+	tests := []struct {
+		email   string
+		wantErr string
+	}{
+		{"cook@example.com", ""},
+		{"chef@example.com", "email is already registered"},
+		// Duplicates are found regardless of case and surrounding whitespace
+		{"Chef@Example.COM", "email is already registered"},
+		{"  chef@example.com ", "email is already registered"},
+		{"not an email", "invalid email format"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.email, func(t *testing.T) {
+			err := s.ValidateEmail(tt.email)
+			if (err == nil && tt.wantErr != "") || (err != nil && err.Error() != tt.wantErr) {
+				t.Fatalf("ValidateEmail(%q) = %v, want %q", tt.email, err, tt.wantErr)
+			}
+		})
+	}
+	for _, email := range checked {
+		if email != strings.ToLower(strings.TrimSpace(email)) {
+			t.Fatalf("checked %q, want emails checked normalized", email)
+		}
+	}
+}
+
+func TestCreateUserNormalizesEmail(t *testing.T) {
+	var created *models.User
+	repo := &servicetest.MockUserRepository{
+		CreateUserFunc: func(user *models.User) (*models.User, error) {
+			created = user
+			return user, nil
+		},
+	}
+	s := service.NewUserService(&config.Config{}, repo, nil)
+
+	if _, err := s.CreateUser("chef42", "Chef", " Chef@Example.COM ", "password123"); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if created.Email != "chef@example.com" {
+		t.Fatalf("stored email %q, want %q", created.Email, "chef@example.com")
+	}
+}
+
+func TestCreateUserDuplicateEmail(t *testing.T) {
+	// Another signup took the email after it was validated
+	repo := &servicetest.MockUserRepository{
+		CreateUserFunc: func(user *models.User) (*models.User, error) {
+			return nil, repository.DuplicateError{}
+		},
+	}
+	s := service.NewUserService(&config.Config{}, repo, nil)
+
+	if _, err := s.CreateUser("chef42", "Chef", "chef@example.com", "password123"); !errors.As(err, &service.ConflictError{}) {
+		t.Fatalf("CreateUser error = %v, want a ConflictError", err)
+	}
+}
+
+func TestConsumeSubscriptionUse(t *testing.T) {
+	tests := []struct {
+		name          string
+		remainingUses int
+		expired       bool
+		personalKey   bool
+		wantSpent     bool
+		wantErr       bool
+		wantRefill    bool
+	}{
+		{"spends a use", 3, false, false, true, false, false},
+		{"no uses left", 0, false, false, false, true, false},
+		{"refills an ended cycle first", 0, true, false, true, false, true},
+		{"personal key", 0, false, true, false, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			remainingUses := tt.remainingUses
+			refilled := false
+			repo := &servicetest.MockUserRepository{
+				DecrementRemainingUsesFunc: func(userID uint) (bool, error) {
+					if remainingUses == 0 {
+						return false, nil
+					}
+					remainingUses--
+					return true, nil
+				},
+				RefillRemainingUsesFunc: func(userID uint, expiresAt, nextExpiresAt time.Time, uses int) (bool, error) {
+					refilled = true
+					remainingUses = uses
+					return true, nil
+				},
+			}
+			s := service.NewUserService(&config.Config{}, repo, nil)
+
+			expiresAt := time.Now().Add(24 * time.Hour)
+			if tt.expired {
+				expiresAt = time.Now().Add(-time.Hour)
+			}
+			user := testUser(1)
+			user.Subscription = &models.Subscription{UserID: 1, SubscriptionTier: models.Free, RemainingUses: tt.remainingUses, ExpiresAt: expiresAt}
+			if tt.personalKey {
+				user.Settings = &models.UserSettings{UsePersonalAPIKey: true, EncryptedOpenAIKey: "encrypted"}
+			}
+
+			spent, err := s.ConsumeSubscriptionUse(user)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ConsumeSubscriptionUse error = %v, want error %v", err, tt.wantErr)
+			}
+			if _, ok := err.(service.PaymentRequiredError); err != nil && !ok {
+				t.Fatalf("error is %T, want service.PaymentRequiredError", err)
+			}
+			if spent != tt.wantSpent {
+				t.Fatalf("spent = %v, want %v", spent, tt.wantSpent)
+			}
+			if refilled != tt.wantRefill {
+				t.Fatalf("refilled = %v, want %v", refilled, tt.wantRefill)
+			}
+		})
+	}
+}
+
+// settingsRepository returns a mock repository storing one user's settings and personalization, whose updates are
+// only kept when they succeed, like the transaction of the real one.
+func settingsRepository(settings models.UserSettings, personalization models.Personalization) *servicetest.MockUserRepository {
+	return &servicetest.MockUserRepository{
+		UpdateSettingsAndPersonalizationFunc: func(userID uint, applyChanges func(*models.UserSettings, *models.Personalization) error) error {
+			updatedSettings, updatedPersonalization := settings, personalization
+			if err := applyChanges(&updatedSettings, &updatedPersonalization); err != nil {
+				return err
+			}
+			settings, personalization = updatedSettings, updatedPersonalization
+			return nil
+		},
+		GetUserByIDFunc: func(userID uint) (*models.User, error) {
+			user := testUser(userID)
+			storedSettings, storedPersonalization := settings, personalization
+			user.Settings, user.Personalization = &storedSettings, &storedPersonalization
+			return user, nil
+		},
+	}
+}
+
+func TestUpdateSettingsPartial(t *testing.T) {
+	repo := settingsRepository(
+		models.UserSettings{KeepScreenAwake: true, EncryptedOpenAIKey: "encrypted"},
+		models.Personalization{UnitSystem: models.USCustomary, Language: "en"},
+	)
+	s := service.NewUserService(&config.Config{}, repo, nil)
+
+	metric, usePersonalAPIKey := models.Metric, true
+	settingsResponse, err := s.UpdateSettings(testUser(1), &service.SettingsUpdate{UnitSystem: &metric, UsePersonalAPIKey: &usePersonalAPIKey})
+	if err != nil {
+		t.Fatalf("UpdateSettings: %v", err)
+	}
+
+	// Only the given fields change, the full updated settings are returned
+	if settingsResponse.Personalization.UnitSystem != models.Metric || !settingsResponse.Settings.UsePersonalAPIKey {
+		t.Fatalf("got %+v and %+v, want the updated fields", settingsResponse.Settings, settingsResponse.Personalization)
+	}
+	if !settingsResponse.Settings.KeepScreenAwake || settingsResponse.Personalization.Language != "en" || !settingsResponse.HasOpenAIKey {
+		t.Fatalf("got %+v and %+v, want the other fields unchanged", settingsResponse.Settings, settingsResponse.Personalization)
+	}
+}
+
+func TestUpdateSettingsInvalid(t *testing.T) {
+	usePersonalAPIKey, emptyKey := true, ""
+	klingon, negative := "tlh", -1
+	invalidUnitSystem := models.UnitSystem(7)
+
+	keyInUse := models.UserSettings{UsePersonalAPIKey: true, EncryptedOpenAIKey: "encrypted"}
+
+	tests := []struct {
+		name     string
+		settings models.UserSettings
+		update   service.SettingsUpdate
+	}{
+		{"personal key without a key", models.UserSettings{}, service.SettingsUpdate{UsePersonalAPIKey: &usePersonalAPIKey}},
+		{"removing the key in use", keyInUse, service.SettingsUpdate{OpenAIKey: &emptyKey}},
+		{"unsupported language", models.UserSettings{}, service.SettingsUpdate{Language: &klingon}},
+		{"invalid unit system", models.UserSettings{}, service.SettingsUpdate{UnitSystem: &invalidUnitSystem}},
+		{"negative spend cap", models.UserSettings{}, service.SettingsUpdate{MonthlySpendCapCents: &negative}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := settingsRepository(tt.settings, models.Personalization{Language: "en"})
+			s := service.NewUserService(&config.Config{}, repo, nil)
+
+			_, err := s.UpdateSettings(testUser(1), &tt.update)
+			if _, ok := err.(service.ValidationError); !ok {
+				t.Fatalf("UpdateSettings error = %v, want a validation error", err)
+			}
+
+			// Nothing is stored when the update is invalid
+			user, _ := repo.GetUserByID(1)
+			if *user.Settings != tt.settings || user.Personalization.Language != "en" {
+				t.Fatalf("got %+v and %+v, want them unchanged", user.Settings, user.Personalization)
+			}
+		})
+	}
+}
+
+func TestGetUserStats(t *testing.T) {
+	now := time.Now().UTC()
+	today := now.Truncate(24 * time.Hour)
+	yesterday := today.Add(-24 * time.Hour)
+
+	tests := []struct {
+		name                 string
+		generationsDay       *time.Time
+		wantGenerationsToday int
+	}{
+		{"counted today", &today, 3},
+		{"counted yesterday", &yesterday, 0},
+		{"never counted", nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotSince time.Time
+			repo := &servicetest.MockUserRepository{
+				GetUserRecipeStatsFunc: func(userID uint, since time.Time, topN int) (*repository.UserRecipeStats, error) {
+					gotSince = since
+					return &repository.UserRecipeStats{
+						RecipeCount:    12,
+						RecipesSince:   4,
+						TotalCookTime:  540,
+						TimesMade:      7,
+						TopIngredients: []repository.NameCount{{Name: "garlic", Count: 9}, {Name: "onion", Count: 6}},
+						TopHashtags:    []repository.NameCount{{Name: "pasta", Count: 5}, {Name: "quick", Count: 3}},
+					}, nil
+				},
+			}
+			cfg := &config.Config{}
+			cfg.Limits.DailyGenerationCaps = map[string]int{string(models.Free): 10}
+			s := service.NewUserService(cfg, repo, nil)
+
+			user := testUser(1)
+			user.Subscription = &models.Subscription{SubscriptionTier: models.Free, GenerationsToday: 3, GenerationsDay: tt.generationsDay}
+			stats, err := s.GetUserStats(user)
+			if err != nil {
+				t.Fatalf("GetUserStats: %v", err)
+			}
+
+			// This month's recipes are counted from the start of the UTC month
+			if wantSince := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC); !gotSince.Equal(wantSince) {
+				t.Fatalf("recipes counted since %v, want %v", gotSince, wantSince)
+			}
+			if stats.TotalRecipes != 12 || stats.RecipesThisMonth != 4 || stats.TotalCookTime != 540 || stats.TimesMade != 7 {
+				t.Fatalf("got %+v, want the repository's aggregates", stats)
+			}
+			if stats.FavoriteHashtag != "pasta" || len(stats.TopIngredients) != 2 || stats.TopIngredients[0].Name != "garlic" {
+				t.Fatalf("got favorite hashtag %q and top ingredients %v, want pasta and garlic first", stats.FavoriteHashtag, stats.TopIngredients)
+			}
+			if stats.DailyGenerationLimit != 10 || stats.GenerationsToday != tt.wantGenerationsToday {
+				t.Fatalf("got %d of %d generations today, want %d of 10", stats.GenerationsToday, stats.DailyGenerationLimit, tt.wantGenerationsToday)
+			}
+		})
+	}
+}
+
+func TestImportUsers(t *testing.T) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte("Secret1!"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("hashing password: %v", err)
+	}
+
+	var imported []*models.User
+	repo := &servicetest.MockUserRepository{
+		UsernameExistsFunc: func(username string) (bool, error) {
+			return username == "taken", nil
+		},
+		EmailExistsFunc: func(email string) (bool, error) {
+			return email == "used@example.com", nil
+		},
+		ImportUsersFunc: func(users []*models.User) ([]error, error) {
+			imported = users
+			conflicts := make([]error, len(users))
+			for i, user := range users {
+				// Taken by a signup after the import checked it
+				if user.Username == "racer" {
+					conflicts[i] = errors.New("username already in use")
+					continue
+				}
+				user.ID = uint(100 + i)
+			}
+			return conflicts, nil
+		},
+	}
+	s := service.NewUserService(&config.Config{}, repo, nil)
+
+	rows := []service.UserImportRow{
+		{Username: "chef42", Email: "Chef42@Example.com", TempPassword: "Secret1!", Tier: "Premium"},
+		{Username: "baker7", Email: "baker7@example.com", HashedPassword: string(hashedPassword)},
+		{Username: "taken", Email: "taken@example.com", TempPassword: "Secret1!"},
+		{Username: "newcook", Email: "used@example.com", TempPassword: "Secret1!"},
+		{Username: "CHEF42", Email: "other@example.com", TempPassword: "Secret1!"},
+		{Username: "nopassword", Email: "nopassword@example.com"},
+		{Username: "badtier", Email: "badtier@example.com", TempPassword: "Secret1!", Tier: "Gold"},
+		{Username: "badhash", Email: "badhash@example.com", HashedPassword: "Secret1!"},
+		{Username: "racer", Email: "racer@example.com", TempPassword: "Secret1!"},
+	}
+	results, err := s.ImportUsers(rows)
+	if err != nil {
+		t.Fatalf("ImportUsers: %v", err)
+	}
+
+	want := []struct {
+		status service.UserImportStatus
+		userID uint
+	}{
+		{service.UserImportCreated, 100},
+		{service.UserImportCreated, 101},
+		{service.UserImportConflict, 0},
+		{service.UserImportConflict, 0},
+		{service.UserImportConflict, 0}, // Same username as the first row
+		{service.UserImportInvalid, 0},
+		{service.UserImportInvalid, 0},
+		{service.UserImportInvalid, 0},
+		{service.UserImportConflict, 0},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("got %d results, want %d", len(results), len(want))
+	}
+	for i, result := range results {
+		if result.Index != i || result.Username != rows[i].Username {
+			t.Fatalf("result %d is for row %d %q", i, result.Index, result.Username)
+		}
+		if result.Status != want[i].status || result.UserID != want[i].userID {
+			t.Fatalf("row %d got %s with user %d (%s), want %s with user %d", i, result.Status, result.UserID, result.Error, want[i].status, want[i].userID)
+		}
+		if result.Status != service.UserImportCreated && result.Error == "" {
+			t.Fatalf("row %d is %s without an error", i, result.Status)
+		}
+	}
+
+	// Only the valid rows reach the repository, with their auth, subscription, and personalization
+	if len(imported) != 3 {
+		t.Fatalf("imported %d users, want 3", len(imported))
+	}
+	chef := imported[0]
+	if chef.Email != "chef42@example.com" || chef.Subscription == nil || chef.Subscription.SubscriptionTier != models.Premium || chef.Personalization == nil {
+		t.Fatalf("got user %+v, want a normalized email, a Premium subscription, and a personalization", chef)
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(chef.Auth.HashedPassword), []byte("Secret1!")); err != nil {
+		t.Fatalf("temp password wasn't hashed: %v", err)
+	}
+	if imported[1].Auth.HashedPassword != string(hashedPassword) || imported[1].Subscription.SubscriptionTier != models.Free {
+		t.Fatal("pre-hashed password or default tier wasn't kept")
+	}
+}
+
+func TestImportUsersInvalidBatch(t *testing.T) {
+	s := service.NewUserService(&config.Config{}, &servicetest.MockUserRepository{}, nil)
+
+	if _, err := s.ImportUsers(nil); !errors.As(err, &service.ValidationError{}) {
+		t.Fatalf("empty import error = %v, want a ValidationError", err)
+	}
+	if _, err := s.ImportUsers(make([]service.UserImportRow, 501)); !errors.As(err, &service.ValidationError{}) {
+		t.Fatalf("oversized import error = %v, want a ValidationError", err)
+	}
+}
+
+// sessionRepository returns a mock repository keeping login sessions in memory.
+func sessionRepository() *servicetest.MockUserRepository {
+	var sessions []*models.UserSession
+	revoke := func(userID uint, match func(session *models.UserSession) bool) (*models.UserSession, error) {
+		for _, session := range sessions {
+			if session.UserID == userID && session.RevokedAt == nil && match(session) {
+				now := time.Now()
+				session.RevokedAt = &now
+				return session, nil
+			}
+		}
+		return nil, repository.NotFoundError{}
+	}
+	return &servicetest.MockUserRepository{
+		RecordLoginFunc: func(session *models.UserSession) error {
+			session.ID = uint(len(sessions) + 1)
+			sessions = append(sessions, session)
+			return nil
+		},
+		ListActiveUserSessionsFunc: func(userID uint) ([]models.UserSession, error) {
+			var active []models.UserSession
+			for _, session := range sessions {
+				if session.UserID == userID && session.RevokedAt == nil {
+					active = append(active, *session)
+				}
+			}
+			return active, nil
+		},
+		RevokeUserSessionFunc: func(userID uint, sessionID uint) (*models.UserSession, error) {
+			return revoke(userID, func(session *models.UserSession) bool { return session.ID == sessionID })
+		},
+		RevokeUserSessionByTokenFunc: func(userID uint, tokenID string) (*models.UserSession, error) {
+			return revoke(userID, func(session *models.UserSession) bool { return session.TokenID == tokenID })
+		},
+	}
+}
+
+func TestListSessions(t *testing.T) {
+	s := service.NewUserService(&config.Config{}, sessionRepository(), util.NewMemoryTokenBlocklist(time.Hour))
+
+	phone, err := s.StartSession(1, "Phone", "10.0.0.1")
+	if err != nil {
+		t.Fatalf("StartSession: %v", err)
+	}
+	if _, err := s.StartSession(1, "Laptop", "10.0.0.2"); err != nil {
+		t.Fatalf("StartSession: %v", err)
+	}
+	if _, err := s.StartSession(2, "Someone else's phone", "10.0.0.3"); err != nil {
+		t.Fatalf("StartSession: %v", err)
+	}
+
+	sessions, err := s.ListSessions(1, phone.TokenID)
+	if err != nil {
+		t.Fatalf("ListSessions: %v", err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("got %d sessions, want the user's 2", len(sessions))
+	}
+	for _, session := range sessions {
+		wantCurrent := session.UserAgent == "Phone"
+		if session.Current != wantCurrent {
+			t.Fatalf("session %+v has Current %v, want %v", session, session.Current, wantCurrent)
+		}
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+	blocklist := util.NewMemoryTokenBlocklist(time.Hour)
+	cfg := &config.Config{}
+	cfg.Auth.TokenTTLHours = 24
+	s := service.NewUserService(cfg, sessionRepository(), blocklist)
+
+	phone, err := s.StartSession(1, "Phone", "10.0.0.1")
+	if err != nil {
+		t.Fatalf("StartSession: %v", err)
+	}
+	laptop, err := s.StartSession(1, "Laptop", "10.0.0.2")
+	if err != nil {
+		t.Fatalf("StartSession: %v", err)
+	}
+
+	// Another user can't revoke the session
+	if err := s.RevokeSession(2, phone.ID); !errors.As(err, &repository.NotFoundError{}) {
+		t.Fatalf("RevokeSession by another user error = %v, want a NotFoundError", err)
+	}
+	if err := s.RevokeSession(1, phone.ID); err != nil {
+		t.Fatalf("RevokeSession: %v", err)
+	}
+
+	for _, tt := range []struct {
+		session     *models.UserSession
+		wantBlocked bool
+	}{
+		{phone, true},
+		{laptop, false},
+	} {
+		if blocked, _ := blocklist.IsBlocked(tt.session.TokenID); blocked != tt.wantBlocked {
+			t.Fatalf("%s token blocked = %v, want %v", tt.session.UserAgent, blocked, tt.wantBlocked)
+		}
+	}
+	sessions, err := s.ListSessions(1, "")
+	if err != nil {
+		t.Fatalf("ListSessions: %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].ID != laptop.ID {
+		t.Fatalf("got sessions %+v, want only the laptop's", sessions)
+	}
+
+	// A revoked session can't be revoked again
+	if err := s.RevokeSession(1, phone.ID); !errors.As(err, &repository.NotFoundError{}) {
+		t.Fatalf("revoking again error = %v, want a NotFoundError", err)
+	}
+}
+
+func TestRefreshSession(t *testing.T) {
+	blocklist := util.NewMemoryTokenBlocklist(time.Hour)
+	cfg := &config.Config{}
+	cfg.Auth.TokenTTLHours = 24
+	cfg.Auth.RefreshWindowHours = 1
+	s := service.NewUserService(cfg, sessionRepository(), blocklist)
+
+	old, err := s.StartSession(1, "Phone", "10.0.0.1")
+	if err != nil {
+		t.Fatalf("StartSession: %v", err)
+	}
+
+	// Outside the refresh window the token keeps working as is
+	if _, err := s.RefreshSession(1, old.TokenID, time.Now().Add(2*time.Hour), "Phone", "10.0.0.1"); !errors.As(err, &service.ValidationError{}) {
+		t.Fatalf("refreshing early error = %v, want a ValidationError", err)
+	}
+
+	refreshed, err := s.RefreshSession(1, old.TokenID, time.Now().Add(10*time.Minute), "Phone", "10.0.0.1")
+	if err != nil {
+		t.Fatalf("RefreshSession: %v", err)
+	}
+	if refreshed.TokenID == old.TokenID || refreshed.ExpiresAt == nil || time.Until(*refreshed.ExpiresAt) < 23*time.Hour {
+		t.Fatalf("refreshed session %+v, want a new token valid for the token TTL", refreshed)
+	}
+	if blocked, _ := blocklist.IsBlocked(old.TokenID); !blocked {
+		t.Fatal("refreshed token isn't blocked")
+	}
+	if blocked, _ := blocklist.IsBlocked(refreshed.TokenID); blocked {
+		t.Fatal("new token is blocked")
+	}
+
+	// The old token can't be refreshed twice
+	if _, err := s.RefreshSession(1, old.TokenID, time.Now().Add(10*time.Minute), "Phone", "10.0.0.1"); !errors.As(err, &repository.NotFoundError{}) {
+		t.Fatalf("refreshing again error = %v, want a NotFoundError", err)
+	}
+}
+
+func TestRefreshSessionRevokeFails(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Auth.TokenTTLHours = 24
+	cfg.Auth.RefreshWindowHours = 1
+	repo := sessionRepository()
+	s := service.NewUserService(cfg, repo, util.NewMemoryTokenBlocklist(time.Hour))
+
+	old, err := s.StartSession(1, "Phone", "10.0.0.1")
+	if err != nil {
+		t.Fatalf("StartSession: %v", err)
+	}
+	repo.RevokeUserSessionByTokenFunc = func(userID uint, tokenID string) (*models.UserSession, error) {
+		return nil, errors.New("database is down")
+	}
+
+	if _, err := s.RefreshSession(1, old.TokenID, time.Now().Add(10*time.Minute), "Phone", "10.0.0.1"); err == nil {
+		t.Fatal("RefreshSession succeeded, want the revoke error")
+	}
+	// No new session was started next to the old one
+	sessions, err := s.ListSessions(1, old.TokenID)
+	if err != nil {
+		t.Fatalf("ListSessions: %v", err)
+	}
+	if len(sessions) != 1 || !sessions[0].Current {
+		t.Fatalf("got sessions %+v, want only the old one", sessions)
+	}
+}
+
+func TestRefreshSessionInvalid(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Auth.RefreshWindowHours = 1
+	// The repository has no funcs, so starting a session would panic
+	s := service.NewUserService(cfg, &servicetest.MockUserRepository{}, util.NewMemoryTokenBlocklist(time.Hour))
+
+	tests := []struct {
+		name      string
+		tokenID   string
+		expiresAt time.Time
+	}{
+		{"token without an ID", "", time.Now().Add(10 * time.Minute)},
+		{"token that doesn't expire", "token", time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := s.RefreshSession(1, tt.tokenID, tt.expiresAt, "Phone", "10.0.0.1"); !errors.As(err, &service.ValidationError{}) {
+				t.Fatalf("RefreshSession error = %v, want a ValidationError", err)
+			}
+		})
+	}
+}
+
+// recordingSender records the addresses emails are sent to.
+type recordingSender struct {
+	sent chan string
+}
+
+func (r *recordingSender) Send(to, subject, body string) error {
+	r.sent <- to
+	return nil
+}
+
+func TestRequestPasswordReset(t *testing.T) {
+	users := map[string]*models.User{
+		"chef@example.com":   {Email: "chef@example.com"},
+		"broken@example.com": {Email: "broken@example.com"},
+		"busy@example.com":   {Email: "busy@example.com"},
+	}
+	users["chef@example.com"].ID = 1
+	users["broken@example.com"].ID = 2
+	users["busy@example.com"].ID = 3
+	var created []uint
+	repo := &servicetest.MockUserRepository{
+		GetUserByEmailFunc: func(email string) (*models.User, error) {
+			if user, ok := users[email]; ok {
+				return user, nil
+			}
+			return nil, repository.NewNotFoundError("User not found")
+		},
+		CountOutstandingPasswordResetTokensFunc: func(userID uint, now time.Time) (int, error) {
+			// The busy user already has as many reset links as they can
+			if userID == 3 {
+				return 3, nil
+			}
+			return 0, nil
+		},
+		CreatePasswordResetTokenFunc: func(token *models.PasswordResetToken) error {
+			if token.UserID == 2 {
+				return errors.New("database is down")
+			}
+			created = append(created, token.UserID)
+			return nil
+		},
+	}
+	cfg := &config.Config{}
+	cfg.PasswordReset.TokenTTLMinutes = 60
+	cfg.PasswordReset.MaxOutstandingTokens = 3
+	s := service.NewUserService(cfg, repo, nil)
+	sender := &recordingSender{sent: make(chan string, 4)}
+	s.Mailer = sender
+
+	// Every request succeeds alike, so the response doesn't tell which emails have an account
+	for _, email := range []string{"chef@example.com", "nobody@example.com", "broken@example.com", "busy@example.com"} {
+		if err := s.RequestPasswordReset(email); err != nil {
+			t.Fatalf("RequestPasswordReset(%q) = %v, want nil", email, err)
+		}
+	}
+
+	if len(created) != 1 || created[0] != 1 {
+		t.Fatalf("created reset tokens for users %v, want only user 1", created)
+	}
+	select {
+	case to := <-sender.sent:
+		if to != "chef@example.com" {
+			t.Fatalf("emailed %s, want chef@example.com", to)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("reset link wasn't emailed")
+	}
+	select {
+	case to := <-sender.sent:
+		t.Fatalf("emailed %s too, want only chef@example.com", to)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
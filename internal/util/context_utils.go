@@ -2,6 +2,7 @@ package util
 
 import (
 	"errors"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/windoze95/saltybytes-api/internal/models"
@@ -36,3 +37,15 @@ func GetUserIDFromContext(c *gin.Context) (uint, error) {
 
 	return userID, nil
 }
+
+// GetTokenIDFromContext gets the ID (jti claim) of the request's token from the context.
+// It's empty for tokens issued without one.
+func GetTokenIDFromContext(c *gin.Context) string {
+	return c.GetString("token_id")
+}
+
+// GetTokenExpiryFromContext gets when the request's token expires (exp claim) from the context.
+// It's the zero time for tokens that don't expire.
+func GetTokenExpiryFromContext(c *gin.Context) time.Time {
+	return c.GetTime("token_expires_at")
+}
@@ -9,8 +9,19 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"regexp"
+	"strings"
+
+	"github.com/windoze95/saltybytes-api/internal/config"
 )
 
+// DefaultOpenAIKeyID is the ID of the current OpenAI key encryption key when none is configured.
+// OpenAI keys encrypted before keys had IDs were encrypted with this key, in the legacy format.
+const DefaultOpenAIKeyID = "1"
+
+// openAIKeyIDPattern is the pattern encryption key IDs must match, so they can't be confused with the ciphertext.
+var openAIKeyIDPattern = regexp.MustCompile(`^[A-Za-z0-9]{1,16}$`)
+
 // CipherConfig is the configuration for the cipher.
 type CipherConfig struct {
 	EncryptionKey []byte
@@ -25,50 +36,167 @@ func GetOpenAIKeyCipherConfig(encryptionKeyHex string) (*CipherConfig, error) {
 	if err != nil {
 		return nil, fmt.Errorf("unable to decode openai key encryption key hex: %v", err)
 	}
+	if _, err := aes.NewCipher(encryptionKey); err != nil {
+		return nil, fmt.Errorf("invalid openai key encryption key: %v", err)
+	}
 	return &CipherConfig{
 		EncryptionKey: encryptionKey,
 	}, nil
 }
 
-// EncryptOpenAIKey encrypts the OpenAI key with the secret key.
-func EncryptOpenAIKey(encryptionKeyHex string, plaintext string) (string, error) {
-	config, err := GetOpenAIKeyCipherConfig(encryptionKeyHex)
+// OpenAIKeyring holds the keys personal OpenAI keys are encrypted with. New encryptions always use the current key,
+// the previous keys are kept so the OpenAI keys encrypted with them can be decrypted until they're re-encrypted.
+type OpenAIKeyring struct {
+	CurrentID string
+	keys      map[string]*CipherConfig
+}
+
+// NewOpenAIKeyring creates a keyring from the hex encoded current key and its ID, and the previous keys as a
+// comma-separated list of id:hex pairs.
+func NewOpenAIKeyring(currentID, currentKeyHex, previousKeys string) (*OpenAIKeyring, error) {
+	if currentID == "" {
+		currentID = DefaultOpenAIKeyID
+	}
+	if !openAIKeyIDPattern.MatchString(currentID) {
+		return nil, fmt.Errorf("invalid openai key encryption key ID %q, it must be 1 to 16 letters and digits", currentID)
+	}
+
+	current, err := GetOpenAIKeyCipherConfig(currentKeyHex)
+	if err != nil {
+		return nil, err
+	}
+	keyring := &OpenAIKeyring{
+		CurrentID: currentID,
+		keys:      map[string]*CipherConfig{currentID: current},
+	}
+
+	for _, pair := range strings.Split(previousKeys, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		id, keyHex, ok := strings.Cut(pair, ":")
+		if !ok || !openAIKeyIDPattern.MatchString(id) {
+			return nil, errors.New("previous openai key encryption keys must be a comma-separated list of id:hex pairs")
+		}
+		if _, exists := keyring.keys[id]; exists {
+			return nil, fmt.Errorf("openai key encryption key ID %q is used twice", id)
+		}
+		previous, err := GetOpenAIKeyCipherConfig(keyHex)
+		if err != nil {
+			return nil, fmt.Errorf("previous key %q: %w", id, err)
+		}
+		keyring.keys[id] = previous
+	}
+
+	return keyring, nil
+}
+
+// OpenAIKeyringFromConfig creates the keyring from the optional environment variables of the config.
+func OpenAIKeyringFromConfig(cfg *config.Config) (*OpenAIKeyring, error) {
+	return NewOpenAIKeyring(
+		cfg.OptionalEnv.OpenaiKeyEncryptionKeyID.Value(),
+		cfg.OptionalEnv.OpenaiKeyEncryptionKey.Value(),
+		cfg.OptionalEnv.OpenaiKeyPreviousEncryptionKeys.Value(),
+	)
+}
+
+// EncryptOpenAIKey encrypts the OpenAI key with the current key of the keyring.
+// The ciphertext is prefixed with the key's ID, as v<ID>:, so it can be decrypted after the current key changes.
+func EncryptOpenAIKey(keyring *OpenAIKeyring, plaintext string) (string, error) {
+	ciphertext, err := seal(keyring.keys[keyring.CurrentID], plaintext)
 	if err != nil {
 		return "", err
 	}
-	return encrypt(config, plaintext)
+	return "v" + keyring.CurrentID + ":" + ciphertext, nil
+}
+
+// DecryptOpenAIKey decrypts the OpenAI key with the key of the keyring it was encrypted with.
+// Ciphertexts without a key ID are from before keys had IDs, and are decrypted with DefaultOpenAIKeyID in the legacy format.
+func DecryptOpenAIKey(keyring *OpenAIKeyring, ciphertext string) (string, error) {
+	id, sealed, versioned := openAIKeyCiphertextID(ciphertext)
+	if !versioned {
+		config, ok := keyring.keys[DefaultOpenAIKeyID]
+		if !ok {
+			return "", fmt.Errorf("openai key encryption key %q of the legacy ciphertext is not configured", DefaultOpenAIKeyID)
+		}
+		return decryptLegacy(config, ciphertext)
+	}
+
+	config, ok := keyring.keys[id]
+	if !ok {
+		return "", fmt.Errorf("openai key encryption key %q is not configured", id)
+	}
+	return open(config, sealed)
+}
+
+// NeedsReencryption checks if the OpenAI key ciphertext isn't encrypted with the current key of the keyring.
+func (k *OpenAIKeyring) NeedsReencryption(ciphertext string) bool {
+	id, _, versioned := openAIKeyCiphertextID(ciphertext)
+	return !versioned || id != k.CurrentID
+}
+
+// openAIKeyCiphertextID splits a versioned ciphertext into its key ID and the sealed OpenAI key.
+// Legacy ciphertexts are URL-safe base64, which never has a colon, so they can't be mistaken for versioned ones.
+func openAIKeyCiphertextID(ciphertext string) (id string, sealed string, versioned bool) {
+	prefix, sealed, ok := strings.Cut(ciphertext, ":")
+	if !ok || !strings.HasPrefix(prefix, "v") {
+		return "", "", false
+	}
+	return strings.TrimPrefix(prefix, "v"), sealed, true
 }
 
-// DecryptOpenAIKey decrypts the OpenAI key with the secret key.
-func DecryptOpenAIKey(encryptionKeyHex string, ciphertext string) (string, error) {
-	config, err := GetOpenAIKeyCipherConfig(encryptionKeyHex)
+// seal encrypts and authenticates the plaintext with AES-GCM under a random nonce, which is prepended to the result.
+func seal(config *CipherConfig, plaintext string) (string, error) {
+	gcm, err := newGCM(config)
 	if err != nil {
 		return "", err
 	}
-	return decrypt(config, ciphertext)
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", fmt.Errorf("error reading nonce: %v", err)
+	}
+
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+	return base64.URLEncoding.EncodeToString(sealed), nil
 }
 
-// encrypt encrypts the plaintext with the secret key.
-func encrypt(config *CipherConfig, plaintext string) (string, error) {
-	block, err := aes.NewCipher(config.EncryptionKey)
+// open decrypts a ciphertext made by seal, failing if it was made with another key or has been tampered with.
+func open(config *CipherConfig, ciphertext string) (string, error) {
+	gcm, err := newGCM(config)
 	if err != nil {
-		return "Error creating block cipher", err
+		return "", err
+	}
+	decodedCiphertext, err := base64.URLEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", err
 	}
 
-	ciphertext := make([]byte, aes.BlockSize+len(plaintext))
-	iv := ciphertext[:aes.BlockSize]
-	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
-		return "Error reading cipher size", err
+	if len(decodedCiphertext) < gcm.NonceSize() {
+		return "", errors.New("ciphertext is too short")
 	}
 
-	stream := cipher.NewCFBEncrypter(block, iv)
-	stream.XORKeyStream(ciphertext[aes.BlockSize:], []byte(plaintext))
+	nonce := decodedCiphertext[:gcm.NonceSize()]
+	plaintext, err := gcm.Open(nil, nonce, decodedCiphertext[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("unable to decrypt ciphertext, it's corrupt or was encrypted with another key")
+	}
 
-	return base64.URLEncoding.EncodeToString(ciphertext), nil
+	return string(plaintext), nil
+}
+
+// newGCM creates the AES-GCM cipher of the key.
+func newGCM(config *CipherConfig) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(config.EncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
 }
 
-// decrypt decrypts the ciphertext with the secret key.
-func decrypt(config *CipherConfig, ciphertext string) (string, error) {
+// decryptLegacy decrypts a ciphertext in the legacy, unauthenticated AES-CFB format.
+func decryptLegacy(config *CipherConfig, ciphertext string) (string, error) {
 	block, err := aes.NewCipher(config.EncryptionKey)
 	if err != nil {
 		return "", err
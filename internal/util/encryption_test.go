@@ -0,0 +1,133 @@
+package util
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"strings"
+	"testing"
+)
+
+const (
+	oldKeyHex = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+	newKeyHex = "1f1e1d1c1b1a191817161514131211100f0e0d0c0b0a09080706050403020100"
+)
+
+// newTestKeyring creates a keyring, failing the test if it can't.
+func newTestKeyring(t *testing.T, currentID, currentKeyHex, previousKeys string) *OpenAIKeyring {
+	t.Helper()
+	keyring, err := NewOpenAIKeyring(currentID, currentKeyHex, previousKeys)
+	if err != nil {
+		t.Fatalf("NewOpenAIKeyring: %v", err)
+	}
+	return keyring
+}
+
+func TestDecryptOpenAIKeyAfterRotation(t *testing.T) {
+	oldKeyring := newTestKeyring(t, "1", oldKeyHex, "")
+	ciphertext, err := EncryptOpenAIKey(oldKeyring, "sk-old")
+	if err != nil {
+		t.Fatalf("EncryptOpenAIKey: %v", err)
+	}
+	if !strings.HasPrefix(ciphertext, "v1:") {
+		t.Fatalf("ciphertext %q isn't prefixed with its key ID", ciphertext)
+	}
+
+	// Rotate to key 2, keeping key 1 as a previous key
+	rotated := newTestKeyring(t, "2", newKeyHex, "1:"+oldKeyHex)
+	plaintext, err := DecryptOpenAIKey(rotated, ciphertext)
+	if err != nil {
+		t.Fatalf("DecryptOpenAIKey after rotation: %v", err)
+	}
+	if plaintext != "sk-old" {
+		t.Fatalf("plaintext = %q, want %q", plaintext, "sk-old")
+	}
+	if !rotated.NeedsReencryption(ciphertext) {
+		t.Fatal("ciphertext of the previous key doesn't need re-encryption")
+	}
+
+	reencrypted, err := EncryptOpenAIKey(rotated, plaintext)
+	if err != nil {
+		t.Fatalf("EncryptOpenAIKey after rotation: %v", err)
+	}
+	if !strings.HasPrefix(reencrypted, "v2:") || rotated.NeedsReencryption(reencrypted) {
+		t.Fatalf("re-encrypted ciphertext %q isn't under the current key", reencrypted)
+	}
+}
+
+func TestDecryptOpenAIKeyUnknownKeyID(t *testing.T) {
+	oldKeyring := newTestKeyring(t, "1", oldKeyHex, "")
+	ciphertext, err := EncryptOpenAIKey(oldKeyring, "sk-old")
+	if err != nil {
+		t.Fatalf("EncryptOpenAIKey: %v", err)
+	}
+
+	// Key 1 was dropped from the keyring
+	rotated := newTestKeyring(t, "2", newKeyHex, "")
+	if _, err := DecryptOpenAIKey(rotated, ciphertext); err == nil || !strings.Contains(err.Error(), `"1" is not configured`) {
+		t.Fatalf("DecryptOpenAIKey error = %v, want key 1 not configured", err)
+	}
+	if _, err := DecryptOpenAIKey(rotated, "v9:"+strings.TrimPrefix(ciphertext, "v1:")); err == nil {
+		t.Fatal("ciphertext of an unknown key ID was decrypted")
+	}
+}
+
+func TestDecryptOpenAIKeyWrongKey(t *testing.T) {
+	ciphertext, err := EncryptOpenAIKey(newTestKeyring(t, "1", oldKeyHex, ""), "sk-old")
+	if err != nil {
+		t.Fatalf("EncryptOpenAIKey: %v", err)
+	}
+
+	// Same ID, different key, the authentication fails instead of returning garbage
+	if _, err := DecryptOpenAIKey(newTestKeyring(t, "1", newKeyHex, ""), ciphertext); err == nil {
+		t.Fatal("ciphertext was decrypted with another key")
+	}
+}
+
+func TestDecryptOpenAIKeyLegacy(t *testing.T) {
+	// Encrypt in the legacy AES-CFB format, without a key ID
+	keyring := newTestKeyring(t, "2", newKeyHex, DefaultOpenAIKeyID+":"+oldKeyHex)
+	block, err := aes.NewCipher(keyring.keys[DefaultOpenAIKeyID].EncryptionKey)
+	if err != nil {
+		t.Fatalf("creating cipher: %v", err)
+	}
+	sealed := make([]byte, aes.BlockSize+len("sk-legacy"))
+	if _, err := io.ReadFull(rand.Reader, sealed[:aes.BlockSize]); err != nil {
+		t.Fatalf("reading IV: %v", err)
+	}
+	cipher.NewCFBEncrypter(block, sealed[:aes.BlockSize]).XORKeyStream(sealed[aes.BlockSize:], []byte("sk-legacy"))
+	ciphertext := base64.URLEncoding.EncodeToString(sealed)
+
+	plaintext, err := DecryptOpenAIKey(keyring, ciphertext)
+	if err != nil {
+		t.Fatalf("DecryptOpenAIKey: %v", err)
+	}
+	if plaintext != "sk-legacy" {
+		t.Fatalf("plaintext = %q, want %q", plaintext, "sk-legacy")
+	}
+	if !keyring.NeedsReencryption(ciphertext) {
+		t.Fatal("legacy ciphertext doesn't need re-encryption")
+	}
+}
+
+func TestNewOpenAIKeyringInvalid(t *testing.T) {
+	tests := []struct {
+		name         string
+		currentID    string
+		previousKeys string
+	}{
+		{"invalid ID", "key-1", ""},
+		{"previous key without an ID", "2", oldKeyHex},
+		{"ID used twice", "2", "2:" + oldKeyHex},
+		{"invalid previous key", "2", "1:nothex"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewOpenAIKeyring(tt.currentID, newKeyHex, tt.previousKeys); err == nil {
+				t.Fatal("NewOpenAIKeyring succeeded, want an error")
+			}
+		})
+	}
+}
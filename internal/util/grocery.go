@@ -0,0 +1,161 @@
+package util
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Grocery store categories used to order shopping lists by aisle.
+const (
+	GroceryCategoryProduce  = "produce"
+	GroceryCategoryMeat     = "meat"
+	GroceryCategorySeafood  = "seafood"
+	GroceryCategoryDairy    = "dairy"
+	GroceryCategoryBakery   = "bakery"
+	GroceryCategoryFrozen   = "frozen"
+	GroceryCategorySpices   = "spices"
+	GroceryCategoryPantry   = "pantry"
+	GroceryCategoryBeverage = "beverages"
+	GroceryCategoryOther    = "other"
+)
+
+// GroceryCategoryOrder is the order categories are listed in, roughly following a store's layout.
+var GroceryCategoryOrder = []string{
+	GroceryCategoryProduce,
+	GroceryCategoryBakery,
+	GroceryCategoryMeat,
+	GroceryCategorySeafood,
+	GroceryCategoryDairy,
+	GroceryCategoryFrozen,
+	GroceryCategoryPantry,
+	GroceryCategorySpices,
+	GroceryCategoryBeverage,
+	GroceryCategoryOther,
+}
+
+// groceryPhrases are multi-word ingredient names whose category differs from their last word's.
+var groceryPhrases = map[string]string{
+	"black pepper":     GroceryCategorySpices,
+	"white pepper":     GroceryCategorySpices,
+	"cayenne pepper":   GroceryCategorySpices,
+	"red pepper flake": GroceryCategorySpices,
+	"sour cream":       GroceryCategoryDairy,
+	"cream cheese":     GroceryCategoryDairy,
+	"heavy cream":      GroceryCategoryDairy,
+	"coconut milk":     GroceryCategoryPantry,
+	"peanut butter":    GroceryCategoryPantry,
+	"ice cream":        GroceryCategoryFrozen,
+	"frozen pea":       GroceryCategoryFrozen,
+	"green onion":      GroceryCategoryProduce,
+	"garlic clove":     GroceryCategoryProduce,
+	"baking powder":    GroceryCategoryPantry,
+	"baking soda":      GroceryCategoryPantry,
+}
+
+// groceryWords maps single words to a category. Ingredient names are matched from the last word backwards,
+// since the last word is usually the noun ("chicken broth" is broth, not chicken).
+var groceryWords = map[string]string{
+	// Produce
+	"apple": GroceryCategoryProduce, "avocado": GroceryCategoryProduce, "banana": GroceryCategoryProduce,
+	"basil": GroceryCategoryProduce, "bean sprout": GroceryCategoryProduce, "broccoli": GroceryCategoryProduce,
+	"cabbage": GroceryCategoryProduce, "carrot": GroceryCategoryProduce, "celery": GroceryCategoryProduce,
+	"cilantro": GroceryCategoryProduce, "cucumber": GroceryCategoryProduce, "garlic": GroceryCategoryProduce,
+	"ginger": GroceryCategoryProduce, "jalapeno": GroceryCategoryProduce, "kale": GroceryCategoryProduce,
+	"lemon": GroceryCategoryProduce, "lettuce": GroceryCategoryProduce, "lime": GroceryCategoryProduce,
+	"mushroom": GroceryCategoryProduce, "onion": GroceryCategoryProduce, "orange": GroceryCategoryProduce,
+	"parsley": GroceryCategoryProduce, "pepper": GroceryCategoryProduce, "potato": GroceryCategoryProduce,
+	"scallion": GroceryCategoryProduce, "shallot": GroceryCategoryProduce, "spinach": GroceryCategoryProduce,
+	"tomato": GroceryCategoryProduce, "zucchini": GroceryCategoryProduce, "berry": GroceryCategoryProduce,
+	"strawberry": GroceryCategoryProduce, "blueberry": GroceryCategoryProduce, "thyme": GroceryCategoryProduce,
+	"rosemary": GroceryCategoryProduce, "mint": GroceryCategoryProduce, "dill": GroceryCategoryProduce,
+	// Meat
+	"bacon": GroceryCategoryMeat, "beef": GroceryCategoryMeat, "chicken": GroceryCategoryMeat,
+	"ham": GroceryCategoryMeat, "lamb": GroceryCategoryMeat, "pork": GroceryCategoryMeat,
+	"sausage": GroceryCategoryMeat, "steak": GroceryCategoryMeat, "turkey": GroceryCategoryMeat,
+	"breast": GroceryCategoryMeat, "thigh": GroceryCategoryMeat, "chorizo": GroceryCategoryMeat,
+	// Seafood
+	"cod": GroceryCategorySeafood, "crab": GroceryCategorySeafood, "fish": GroceryCategorySeafood,
+	"salmon": GroceryCategorySeafood, "shrimp": GroceryCategorySeafood, "tuna": GroceryCategorySeafood,
+	"scallop": GroceryCategorySeafood, "mussel": GroceryCategorySeafood, "tilapia": GroceryCategorySeafood,
+	// Dairy
+	"butter": GroceryCategoryDairy, "cheddar": GroceryCategoryDairy, "cheese": GroceryCategoryDairy,
+	"cream": GroceryCategoryDairy, "egg": GroceryCategoryDairy, "milk": GroceryCategoryDairy,
+	"mozzarella": GroceryCategoryDairy, "parmesan": GroceryCategoryDairy, "yogurt": GroceryCategoryDairy,
+	"feta": GroceryCategoryDairy, "buttermilk": GroceryCategoryDairy,
+	// Bakery
+	"bread": GroceryCategoryBakery, "bun": GroceryCategoryBakery, "baguette": GroceryCategoryBakery,
+	"tortilla": GroceryCategoryBakery, "pita": GroceryCategoryBakery, "roll": GroceryCategoryBakery,
+	// Pantry
+	"broth": GroceryCategoryPantry, "flour": GroceryCategoryPantry, "honey": GroceryCategoryPantry,
+	"noodle": GroceryCategoryPantry, "oil": GroceryCategoryPantry, "pasta": GroceryCategoryPantry,
+	"rice": GroceryCategoryPantry, "sauce": GroceryCategoryPantry, "spaghetti": GroceryCategoryPantry,
+	"stock": GroceryCategoryPantry, "sugar": GroceryCategoryPantry, "vinegar": GroceryCategoryPantry,
+	"bean": GroceryCategoryPantry, "lentil": GroceryCategoryPantry, "oat": GroceryCategoryPantry,
+	"chickpea": GroceryCategoryPantry, "syrup": GroceryCategoryPantry, "mustard": GroceryCategoryPantry,
+	"ketchup": GroceryCategoryPantry, "mayonnaise": GroceryCategoryPantry, "breadcrumb": GroceryCategoryPantry,
+	"chocolate": GroceryCategoryPantry, "nut": GroceryCategoryPantry, "almond": GroceryCategoryPantry,
+	"walnut": GroceryCategoryPantry, "yeast": GroceryCategoryPantry, "cornstarch": GroceryCategoryPantry,
+	"vanilla": GroceryCategoryPantry, "water": GroceryCategoryPantry,
+	// Spices
+	"cinnamon": GroceryCategorySpices, "cumin": GroceryCategorySpices, "nutmeg": GroceryCategorySpices,
+	"oregano": GroceryCategorySpices, "paprika": GroceryCategorySpices, "salt": GroceryCategorySpices,
+	"turmeric": GroceryCategorySpices, "chili powder": GroceryCategorySpices, "peppercorn": GroceryCategorySpices,
+	"clove": GroceryCategorySpices, "coriander": GroceryCategorySpices, "seasoning": GroceryCategorySpices,
+	// Frozen
+	"frozen": GroceryCategoryFrozen,
+	// Beverages
+	"beer": GroceryCategoryBeverage, "coffee": GroceryCategoryBeverage, "juice": GroceryCategoryBeverage,
+	"tea": GroceryCategoryBeverage, "wine": GroceryCategoryBeverage, "soda": GroceryCategoryBeverage,
+}
+
+// CategorizeIngredient returns the grocery store category for an ingredient name, or GroceryCategoryOther if it isn't known.
+func CategorizeIngredient(name string) string {
+	words := NormalizeWords(name)
+	normalized := strings.Join(words, " ")
+
+	for phrase, category := range groceryPhrases {
+		if strings.Contains(" "+normalized+" ", " "+phrase+" ") {
+			return category
+		}
+	}
+
+	// Check two-word keys before single words, from the end of the name backwards
+	for i := len(words) - 1; i >= 0; i-- {
+		if i > 0 {
+			if category, ok := groceryWords[words[i-1]+" "+words[i]]; ok {
+				return category
+			}
+		}
+		if category, ok := groceryWords[words[i]]; ok {
+			return category
+		}
+	}
+
+	return GroceryCategoryOther
+}
+
+// NormalizeWords splits a name into lowercase, singular words, dropping anything that isn't a letter.
+func NormalizeWords(name string) []string {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	for i, word := range words {
+		words[i] = singularize(word)
+	}
+	return words
+}
+
+// singularize strips common English plural endings so "tomatoes" and "carrots" match their singular keys.
+func singularize(word string) string {
+	switch {
+	case strings.HasSuffix(word, "ies") && len(word) > 4:
+		return strings.TrimSuffix(word, "ies") + "y"
+	case strings.HasSuffix(word, "oes") && len(word) > 4:
+		return strings.TrimSuffix(word, "es")
+	case strings.HasSuffix(word, "ss"):
+		return word
+	case strings.HasSuffix(word, "s") && len(word) > 3:
+		return strings.TrimSuffix(word, "s")
+	}
+	return word
+}
@@ -0,0 +1,34 @@
+package util
+
+import "testing"
+
+func TestCategorizeIngredient(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Tomatoes", GroceryCategoryProduce},
+		{"Red bell peppers", GroceryCategoryProduce},
+		{"Garlic cloves, minced", GroceryCategoryProduce},
+		{"Green onions", GroceryCategoryProduce},
+		{"Boneless chicken breasts", GroceryCategoryMeat},
+		{"Salmon fillets", GroceryCategorySeafood},
+		{"Large eggs", GroceryCategoryDairy},
+		{"Sour cream", GroceryCategoryDairy},
+		{"Chicken broth", GroceryCategoryPantry},
+		{"All-purpose flour", GroceryCategoryPantry},
+		{"Freshly ground black pepper", GroceryCategorySpices},
+		{"Kosher salt", GroceryCategorySpices},
+		{"Frozen peas", GroceryCategoryFrozen},
+		{"Dry white wine", GroceryCategoryBeverage},
+		{"Dragon fruit", GroceryCategoryOther},
+		{"", GroceryCategoryOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CategorizeIngredient(tt.name); got != tt.want {
+				t.Fatalf("CategorizeIngredient(%q) = %q, want %q", tt.name, got, tt.want)
+			}
+		})
+	}
+}
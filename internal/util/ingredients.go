@@ -0,0 +1,133 @@
+package util
+
+import (
+	"math"
+	"strings"
+
+	"github.com/windoze95/saltybytes-api/internal/models"
+)
+
+// IngredientSource is the share of a merged ingredient that comes from one recipe.
+type IngredientSource struct {
+	RecipeID    uint    `json:"recipe_id"`
+	RecipeTitle string  `json:"recipe_title"`
+	Amount      float64 `json:"amount"`
+}
+
+// MergedIngredient is an ingredient summed across recipes, with the breakdown per recipe.
+type MergedIngredient struct {
+	Name    string             `json:"name"`
+	Unit    string             `json:"unit"`
+	Amount  float64            `json:"amount"`
+	Sources []IngredientSource `json:"sources"`
+}
+
+// MergeIngredients deduplicates the ingredients of a main recipe and its sub-recipes, summing the amounts of
+// ingredients with the same name and unit. Names match regardless of case, punctuation, and plurals.
+// The same ingredient in different units is kept as separate entries, since not every unit converts.
+// Ingredients are listed in the order they first appear.
+func MergeIngredients(recipes []*models.Recipe) []MergedIngredient {
+	var merged []MergedIngredient
+	indexes := make(map[string]int)
+
+	for _, recipe := range recipes {
+		if recipe == nil {
+			continue
+		}
+		for _, ingredient := range recipe.Ingredients {
+			key := ingredientKey(ingredient)
+			if key == "" {
+				continue
+			}
+
+			i, ok := indexes[key]
+			if !ok {
+				i = len(merged)
+				indexes[key] = i
+				merged = append(merged, MergedIngredient{
+					Name: strings.TrimSpace(ingredient.Name),
+					Unit: strings.TrimSpace(ingredient.Unit),
+				})
+			}
+
+			merged[i].Amount += ingredient.Amount
+			merged[i].Sources = append(merged[i].Sources, IngredientSource{
+				RecipeID:    recipe.ID,
+				RecipeTitle: recipe.Title,
+				Amount:      ingredient.Amount,
+			})
+		}
+	}
+
+	return merged
+}
+
+// ingredientKey identifies an ingredient by its normalized name and unit, empty if it has no name.
+func ingredientKey(ingredient models.Ingredient) string {
+	name := strings.Join(NormalizeWords(ingredient.Name), " ")
+	if name == "" {
+		return ""
+	}
+	return name + "|" + strings.ToLower(strings.TrimSpace(ingredient.Unit))
+}
+
+// countUnits are the units, besides none, that count whole items, so scaled amounts are rounded to whole numbers.
+var countUnits = map[string]bool{
+	"piece": true, "whole": true, "clove": true, "egg": true, "slice": true, "can": true, "large": true,
+	"medium": true, "small": true, "head": true, "bunch": true, "stalk": true, "sprig": true,
+}
+
+// fineUnits are the units measured in small amounts, so scaled amounts are rounded to the nearest 1/8.
+var fineUnits = map[string]bool{
+	"teaspoon": true, "tsp": true, "tablespoon": true, "tbsp": true, "pinch": true, "dash": true,
+}
+
+// coarseUnits are the units measured with cups and scales, so scaled amounts are rounded to the nearest 1/4.
+var coarseUnits = map[string]bool{
+	"cup": true, "oz": true, "ounce": true, "lb": true, "lbs": true, "pound": true, "pint": true, "quart": true,
+	"gallon": true, "kg": true, "kilogram": true, "l": true, "liter": true, "litre": true,
+}
+
+// wholeUnits are the metric units too small to need a fraction, so scaled amounts are rounded to whole numbers.
+var wholeUnits = map[string]bool{
+	"g": true, "gram": true, "ml": true, "milliliter": true, "millilitre": true,
+}
+
+// ScaleIngredients returns a copy of the ingredients with their amounts multiplied by the factor.
+// Amounts are rounded to what can be measured in their unit, so counted items stay whole and spoons and cups land on
+// kitchen fractions. An ingredient that had an amount never rounds down to nothing.
+func ScaleIngredients(ingredients models.Ingredients, factor float64) models.Ingredients {
+	scaled := make(models.Ingredients, len(ingredients))
+	for i, ingredient := range ingredients {
+		scaled[i] = ingredient
+		if ingredient.Amount == 0 {
+			continue
+		}
+		scaled[i].Amount = roundScaledAmount(ingredient.Amount*factor, ingredient.Unit)
+	}
+	return scaled
+}
+
+// roundScaledAmount rounds a scaled amount to the precision its unit is measured in, and to at least the smallest
+// measurable amount.
+func roundScaledAmount(amount float64, unit string) float64 {
+	var step float64
+	switch unitWord := singularize(strings.ToLower(strings.Trim(strings.TrimSpace(unit), "."))); {
+	case unitWord == "" || countUnits[unitWord]:
+		step = 1
+	case fineUnits[unitWord]:
+		step = 0.125
+	case coarseUnits[unitWord]:
+		step = 0.25
+	case wholeUnits[unitWord]:
+		step = 1
+	default:
+		step = 0.01
+	}
+
+	rounded := math.Round(amount/step) * step
+	if rounded < step {
+		return step
+	}
+	return rounded
+}
@@ -0,0 +1,92 @@
+package util
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/windoze95/saltybytes-api/internal/models"
+)
+
+func TestMergeIngredients(t *testing.T) {
+	main := &models.Recipe{Model: gorm.Model{ID: 1}, RecipeDef: models.RecipeDef{Title: "Tacos", Ingredients: models.Ingredients{
+		{Name: "Salt", Unit: "tsp", Amount: 1},
+		{Name: "Tortillas", Unit: "", Amount: 8},
+		{Name: "Lime", Unit: "", Amount: 1},
+	}}}
+	salsa := &models.Recipe{Model: gorm.Model{ID: 2}, RecipeDef: models.RecipeDef{Title: "Salsa", Ingredients: models.Ingredients{
+		{Name: "Tomatoes", Unit: "", Amount: 4},
+		{Name: "salt", Unit: "TSP", Amount: 0.5},
+		{Name: "Limes", Unit: "", Amount: 2},
+	}}}
+	pickles := &models.Recipe{Model: gorm.Model{ID: 3}, RecipeDef: models.RecipeDef{Title: "Pickled Onions", Ingredients: models.Ingredients{
+		{Name: "Salt.", Unit: "tsp", Amount: 1},
+		{Name: "Salt", Unit: "g", Amount: 5},
+		{Name: " ", Unit: "cup", Amount: 1},
+	}}}
+
+	got := MergeIngredients([]*models.Recipe{main, salsa, nil, pickles})
+
+	// Salt in teaspoons is summed across all three recipes, in grams it's kept apart, and the nameless ingredient is dropped
+	want := []MergedIngredient{
+		{Name: "Salt", Unit: "tsp", Amount: 2.5, Sources: []IngredientSource{
+			{RecipeID: 1, RecipeTitle: "Tacos", Amount: 1},
+			{RecipeID: 2, RecipeTitle: "Salsa", Amount: 0.5},
+			{RecipeID: 3, RecipeTitle: "Pickled Onions", Amount: 1},
+		}},
+		{Name: "Tortillas", Amount: 8, Sources: []IngredientSource{{RecipeID: 1, RecipeTitle: "Tacos", Amount: 8}}},
+		{Name: "Lime", Amount: 3, Sources: []IngredientSource{
+			{RecipeID: 1, RecipeTitle: "Tacos", Amount: 1},
+			{RecipeID: 2, RecipeTitle: "Salsa", Amount: 2},
+		}},
+		{Name: "Tomatoes", Amount: 4, Sources: []IngredientSource{{RecipeID: 2, RecipeTitle: "Salsa", Amount: 4}}},
+		{Name: "Salt", Unit: "g", Amount: 5, Sources: []IngredientSource{{RecipeID: 3, RecipeTitle: "Pickled Onions", Amount: 5}}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestMergeIngredientsNoRecipes(t *testing.T) {
+	if got := MergeIngredients(nil); len(got) != 0 {
+		t.Fatalf("got %+v, want no ingredients", got)
+	}
+}
+
+func TestScaleIngredients(t *testing.T) {
+	ingredients := models.Ingredients{
+		{Name: "Eggs", Unit: "", Amount: 2},
+		{Name: "Garlic", Unit: "cloves", Amount: 3},
+		{Name: "Salt", Unit: "tsp", Amount: 1},
+		{Name: "Flour", Unit: "cups", Amount: 1.5},
+		{Name: "Butter", Unit: "g", Amount: 115},
+		{Name: "Saffron", Unit: "threads", Amount: 12},
+		{Name: "Pepper", Unit: "", Amount: 0},
+	}
+
+	tests := []struct {
+		name   string
+		factor float64
+		want   []float64
+	}{
+		{"doubled", 2, []float64{4, 6, 2, 3, 230, 24, 0}},
+		{"a third", 1.0 / 3, []float64{1, 1, 0.375, 0.5, 38, 4, 0}},
+		{"a tenth never rounds to nothing", 0.1, []float64{1, 1, 0.125, 0.25, 12, 1.2, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scaled := ScaleIngredients(ingredients, tt.factor)
+			for i, ingredient := range scaled {
+				if math.Abs(ingredient.Amount-tt.want[i]) > 1e-9 {
+					t.Fatalf("%s = %v %s, want %v", ingredient.Name, ingredient.Amount, ingredient.Unit, tt.want[i])
+				}
+			}
+		})
+	}
+
+	// The ingredients scaled are left as they were
+	if ingredients[0].Amount != 2 {
+		t.Fatalf("original eggs = %v, want 2", ingredients[0].Amount)
+	}
+}
@@ -0,0 +1,61 @@
+package util
+
+import (
+	"strings"
+	"unicode"
+)
+
+// englishMarkers are common English words, including kitchen vocabulary, that rarely appear in recipes written in other languages.
+var englishMarkers = map[string]bool{
+	"the": true, "and": true, "with": true, "until": true, "into": true, "over": true, "then": true,
+	"of": true, "for": true, "about": true, "your": true, "from": true, "this": true, "that": true,
+	"each": true, "add": true, "stir": true, "minutes": true, "heat": true, "cook": true, "bake": true,
+	"pan": true, "bowl": true, "large": true, "small": true, "chopped": true, "sliced": true, "mix": true,
+	"serve": true, "remove": true, "place": true, "season": true, "whisk": true, "boil": true, "simmer": true,
+	"chicken": true, "beef": true, "pork": true, "salt": true, "pepper": true, "garlic": true, "onion": true,
+	"butter": true, "sugar": true, "flour": true, "water": true, "oil": true, "egg": true, "eggs": true,
+	"milk": true, "cheese": true, "cream": true, "lemon": true, "juice": true, "sauce": true, "fresh": true,
+	"ground": true, "black": true, "olive": true, "green": true, "white": true,
+}
+
+// LooksEnglish reports whether at least the given share of words in the text are common English words.
+func LooksEnglish(text string, minShare float64) bool {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	if len(words) == 0 {
+		return false
+	}
+
+	markers := 0
+	for _, word := range words {
+		if englishMarkers[word] {
+			markers++
+		}
+	}
+
+	return float64(markers)/float64(len(words)) >= minShare
+}
+
+// EnglishTextRatio returns the share of non-empty texts that look English.
+func EnglishTextRatio(texts []string) float64 {
+	// A third of the words being English markers is far beyond what other languages share by coincidence
+	const minShare = 0.3
+
+	total := 0
+	english := 0
+	for _, text := range texts {
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		total++
+		if LooksEnglish(text, minShare) {
+			english++
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+
+	return float64(english) / float64(total)
+}
@@ -0,0 +1,24 @@
+package util
+
+import "testing"
+
+func TestEnglishTextRatio(t *testing.T) {
+	tests := []struct {
+		name  string
+		texts []string
+		want  float64
+	}{
+		{"italian", []string{"Risotto ai funghi", "Tostare il riso nel burro", "Aggiungere il brodo poco alla volta"}, 0},
+		{"english", []string{"Add the rice to the pan", "Stir in the stock until absorbed"}, 1},
+		{"mixed", []string{"Risotto ai funghi", "Add the rice to the pan and stir", "Aggiungere il brodo", "Serve with the cheese"}, 0.5},
+		{"empty texts are skipped", []string{"", "  ", "Stir the sauce"}, 1},
+		{"nothing", nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := EnglishTextRatio(tt.texts); got != tt.want {
+				t.Fatalf("EnglishTextRatio = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
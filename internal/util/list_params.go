@@ -0,0 +1,286 @@
+package util
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/windoze95/saltybytes-api/internal/models"
+)
+
+// Query parameters of list endpoints.
+const (
+	PageQueryParam     = "page"
+	PageSizeQueryParam = "page_size"
+	SortQueryParam     = "sort"
+	OrderQueryParam    = "order"
+	LimitQueryParam    = "limit"
+	OffsetQueryParam   = "offset"
+	CursorQueryParam   = "cursor"
+)
+
+// Query parameters that filter recipe listings.
+const (
+	MinCookTimeQueryParam = "min_cook_time"
+	MaxCookTimeQueryParam = "max_cook_time"
+	DifficultyQueryParam  = "difficulty"
+)
+
+// MaxCookTimeFilter is the longest cook time, in minutes, recipe listings can be filtered by.
+const MaxCookTimeFilter = 24 * 60
+
+// Page size bounds of list endpoints.
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// Sort orders.
+const (
+	OrderAsc  = "asc"
+	OrderDesc = "desc"
+)
+
+// SortFields is the allowlist of fields a resource's listing can be sorted by.
+// Only these columns ever reach the ORDER BY clause.
+type SortFields struct {
+	// Columns maps the sort query values to the columns they sort by.
+	Columns map[string]string
+	// Default is the sort query value used when none is given.
+	Default string
+	// DefaultOrder is the order used when none is given.
+	DefaultOrder string
+}
+
+// RecipeSortFields are the fields recipe listings can be sorted by.
+var RecipeSortFields = SortFields{
+	Columns: map[string]string{
+		"created_at": "recipes.created_at",
+		"updated_at": "recipes.updated_at",
+		"title":      "recipes.title",
+		"cook_time":  "recipes.cook_time",
+	},
+	Default:      "created_at",
+	DefaultOrder: OrderDesc,
+}
+
+// ListParams are the validated pagination and sorting of a list request.
+type ListParams struct {
+	Page     int    // 1-based
+	PageSize int    // Between 1 and MaxPageSize
+	Sort     string // The sort query value
+	Column   string // The allowlisted column Sort maps to
+	Order    string // OrderAsc or OrderDesc
+}
+
+// Offset returns the number of rows before the page.
+func (p ListParams) Offset() int {
+	return (p.Page - 1) * p.PageSize
+}
+
+// OrderBy returns the ORDER BY expression, built only from the allowlisted column and order.
+func (p ListParams) OrderBy() string {
+	return p.Column + " " + strings.ToUpper(p.Order)
+}
+
+// ListParamError is an error type for an invalid pagination or sorting query parameter.
+type ListParamError struct {
+	message string
+}
+
+// Error returns the error message.
+func (e ListParamError) Error() string {
+	return e.message
+}
+
+// ParseListParams parses the page, page_size, sort, and order query parameters of a list request.
+// Out of range pages and page sizes are clamped, while malformed numbers, unknown sort fields, and unknown orders
+// are a ListParamError.
+func ParseListParams(c *gin.Context, fields SortFields) (ListParams, error) {
+	params := ListParams{
+		Page:     1,
+		PageSize: DefaultPageSize,
+		Sort:     fields.Default,
+		Order:    fields.DefaultOrder,
+	}
+	if params.Order == "" {
+		params.Order = OrderAsc
+	}
+
+	if pageStr := c.Query(PageQueryParam); pageStr != "" {
+		page, err := strconv.Atoi(pageStr)
+		if err != nil {
+			return ListParams{}, ListParamError{message: fmt.Sprintf("%s must be a number", PageQueryParam)}
+		}
+		params.Page = page
+	}
+	if params.Page < 1 {
+		params.Page = 1
+	}
+
+	if pageSizeStr := c.Query(PageSizeQueryParam); pageSizeStr != "" {
+		pageSize, err := strconv.Atoi(pageSizeStr)
+		if err != nil {
+			return ListParams{}, ListParamError{message: fmt.Sprintf("%s must be a number", PageSizeQueryParam)}
+		}
+		params.PageSize = pageSize
+	}
+	if params.PageSize < 1 {
+		params.PageSize = 1
+	} else if params.PageSize > MaxPageSize {
+		params.PageSize = MaxPageSize
+	}
+
+	if sort := c.Query(SortQueryParam); sort != "" {
+		params.Sort = strings.ToLower(sort)
+	}
+	column, ok := fields.Columns[params.Sort]
+	if !ok {
+		return ListParams{}, ListParamError{message: fmt.Sprintf("can't sort by %q", params.Sort)}
+	}
+	params.Column = column
+
+	if order := c.Query(OrderQueryParam); order != "" {
+		params.Order = strings.ToLower(order)
+	}
+	if params.Order != OrderAsc && params.Order != OrderDesc {
+		return ListParams{}, ListParamError{message: fmt.Sprintf("%s must be %s or %s", OrderQueryParam, OrderAsc, OrderDesc)}
+	}
+
+	return params, nil
+}
+
+// ParseLimitOffset parses the limit and offset query parameters of a list request.
+// The limit defaults to DefaultPageSize and is capped at MaxPageSize, and a negative offset is treated as 0.
+// Malformed numbers are a ListParamError.
+func ParseLimitOffset(c *gin.Context) (limit, offset int, err error) {
+	limit = DefaultPageSize
+	if limitStr := c.Query(LimitQueryParam); limitStr != "" {
+		if limit, err = strconv.Atoi(limitStr); err != nil {
+			return 0, 0, ListParamError{message: fmt.Sprintf("%s must be a number", LimitQueryParam)}
+		}
+	}
+	if limit < 1 {
+		limit = 1
+	} else if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+
+	if offsetStr := c.Query(OffsetQueryParam); offsetStr != "" {
+		if offset, err = strconv.Atoi(offsetStr); err != nil {
+			return 0, 0, ListParamError{message: fmt.Sprintf("%s must be a number", OffsetQueryParam)}
+		}
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	return limit, offset, nil
+}
+
+// FeedCursor is the position of a recipe in a listing ordered by newest first, the listing continues after it.
+// Recipes created at the same time are ordered by their ID, so every recipe has a distinct position.
+type FeedCursor struct {
+	CreatedAt time.Time
+	ID        uint
+}
+
+// Encode returns the cursor as an opaque string for the cursor query parameter.
+func (f FeedCursor) Encode() string {
+	raw := f.CreatedAt.UTC().Format(time.RFC3339Nano) + "," + strconv.FormatUint(uint64(f.ID), 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodeFeedCursor parses a cursor made by FeedCursor.Encode. Anything else is a ListParamError.
+func DecodeFeedCursor(cursor string) (*FeedCursor, error) {
+	invalid := ListParamError{message: fmt.Sprintf("%s is invalid", CursorQueryParam)}
+
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, invalid
+	}
+	createdAtStr, idStr, ok := strings.Cut(string(raw), ",")
+	if !ok {
+		return nil, invalid
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, createdAtStr)
+	if err != nil {
+		return nil, invalid
+	}
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		return nil, invalid
+	}
+
+	return &FeedCursor{CreatedAt: createdAt, ID: uint(id)}, nil
+}
+
+// ParseLimitCursor parses the limit and cursor query parameters of a list request paginated by cursor.
+// The limit is parsed like ParseLimitOffset does, and the cursor is nil for the first page.
+// Malformed numbers and cursors are a ListParamError.
+func ParseLimitCursor(c *gin.Context) (limit int, cursor *FeedCursor, err error) {
+	if limit, _, err = ParseLimitOffset(c); err != nil {
+		return 0, nil, err
+	}
+
+	if cursorStr := c.Query(CursorQueryParam); cursorStr != "" {
+		if cursor, err = DecodeFeedCursor(cursorStr); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	return limit, cursor, nil
+}
+
+// RecipeFilter narrows a recipe listing down. Zero values don't filter.
+type RecipeFilter struct {
+	MinCookTime int // Minutes
+	MaxCookTime int // Minutes
+	Difficulty  models.Difficulty
+	// ExcludeAllergens leaves out recipes with any of these allergens, set from the viewer's exclusions
+	ExcludeAllergens []string
+}
+
+// ParseRecipeFilter parses the min_cook_time, max_cook_time, and difficulty query parameters of a recipe listing.
+// Malformed numbers, cook times that are out of range or don't leave any room between them, and unknown difficulties
+// are a ListParamError.
+func ParseRecipeFilter(c *gin.Context) (RecipeFilter, error) {
+	var filter RecipeFilter
+
+	cookTimes := []struct {
+		param string
+		value *int
+	}{
+		{MinCookTimeQueryParam, &filter.MinCookTime},
+		{MaxCookTimeQueryParam, &filter.MaxCookTime},
+	}
+	for _, cookTime := range cookTimes {
+		valueStr := c.Query(cookTime.param)
+		if valueStr == "" {
+			continue
+		}
+		value, err := strconv.Atoi(valueStr)
+		if err != nil {
+			return RecipeFilter{}, ListParamError{message: fmt.Sprintf("%s must be a number of minutes", cookTime.param)}
+		}
+		if value < 1 || value > MaxCookTimeFilter {
+			return RecipeFilter{}, ListParamError{message: fmt.Sprintf("%s must be from 1 to %d minutes", cookTime.param, MaxCookTimeFilter)}
+		}
+		*cookTime.value = value
+	}
+	if filter.MinCookTime > 0 && filter.MaxCookTime > 0 && filter.MinCookTime > filter.MaxCookTime {
+		return RecipeFilter{}, ListParamError{message: fmt.Sprintf("%s can't be more than %s", MinCookTimeQueryParam, MaxCookTimeQueryParam)}
+	}
+
+	if difficulty := c.Query(DifficultyQueryParam); difficulty != "" {
+		filter.Difficulty = models.Difficulty(strings.ToLower(difficulty))
+		if !filter.Difficulty.IsValidDifficulty() {
+			return RecipeFilter{}, ListParamError{message: fmt.Sprintf("unknown %s %q", DifficultyQueryParam, difficulty)}
+		}
+	}
+
+	return filter, nil
+}
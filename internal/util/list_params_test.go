@@ -0,0 +1,100 @@
+package util
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// listContext returns a gin context for a list request with the query.
+func listContext(query string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/v1/recipes?"+query, nil)
+	return c
+}
+
+func TestParseListParams(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  ListParams
+	}{
+		{"defaults", "", ListParams{Page: 1, PageSize: DefaultPageSize, Sort: "created_at", Column: "recipes.created_at", Order: OrderDesc}},
+		{"given", "page=3&page_size=10&sort=Title&order=ASC", ListParams{Page: 3, PageSize: 10, Sort: "title", Column: "recipes.title", Order: OrderAsc}},
+		{"page below 1", "page=-2", ListParams{Page: 1, PageSize: DefaultPageSize, Sort: "created_at", Column: "recipes.created_at", Order: OrderDesc}},
+		{"page size below 1", "page_size=0", ListParams{Page: 1, PageSize: 1, Sort: "created_at", Column: "recipes.created_at", Order: OrderDesc}},
+		{"page size above the max", "page_size=1000", ListParams{Page: 1, PageSize: MaxPageSize, Sort: "created_at", Column: "recipes.created_at", Order: OrderDesc}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseListParams(listContext(tt.query), RecipeSortFields)
+			if err != nil {
+				t.Fatalf("ParseListParams: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseListParamsInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{"page isn't a number", "page=two"},
+		{"page size isn't a number", "page_size=all"},
+		{"sort field not allowed", "sort=password"},
+		{"sort field is SQL", "sort=created_at%3BDROP%20TABLE%20recipes"},
+		{"sort by the column name", "sort=recipes.title"},
+		{"unknown order", "order=sideways"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseListParams(listContext(tt.query), RecipeSortFields); !errors.As(err, &ListParamError{}) {
+				t.Fatalf("ParseListParams error = %v, want a ListParamError", err)
+			}
+		})
+	}
+}
+
+func TestListParamsOffsetAndOrderBy(t *testing.T) {
+	params := ListParams{Page: 3, PageSize: 20, Column: "recipes.title", Order: OrderAsc}
+	if got := params.Offset(); got != 40 {
+		t.Fatalf("Offset() = %d, want 40", got)
+	}
+	if got := params.OrderBy(); got != "recipes.title ASC" {
+		t.Fatalf("OrderBy() = %q, want %q", got, "recipes.title ASC")
+	}
+}
+
+func TestParseLimitOffset(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		wantLimit  int
+		wantOffset int
+		wantErr    bool
+	}{
+		{"defaults", "", DefaultPageSize, 0, false},
+		{"given", "limit=5&offset=10", 5, 10, false},
+		{"clamped", "limit=1000&offset=-1", MaxPageSize, 0, false},
+		{"limit isn't a number", "limit=many", 0, 0, true},
+		{"offset isn't a number", "offset=first", 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limit, offset, err := ParseLimitOffset(listContext(tt.query))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseLimitOffset error = %v, want error %v", err, tt.wantErr)
+			}
+			if limit != tt.wantLimit || offset != tt.wantOffset {
+				t.Fatalf("got limit %d and offset %d, want %d and %d", limit, offset, tt.wantLimit, tt.wantOffset)
+			}
+		})
+	}
+}
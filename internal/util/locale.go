@@ -0,0 +1,46 @@
+package util
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/windoze95/saltybytes-api/internal/config"
+	"github.com/windoze95/saltybytes-api/internal/models"
+)
+
+// Request-level locale overrides.
+const (
+	LocaleQueryParam = "locale"
+	LocaleHeader     = "X-SaltyBytes-Locale"
+)
+
+// ResolveLocale resolves the language to use for a request, used for the generation language, unit display, and translations.
+// In order of precedence:
+//  1. The locale query parameter
+//  2. The X-SaltyBytes-Locale header
+//  3. The user's personalization, when there's a user
+//  4. The configured default language
+//  5. English
+//
+// Unsupported languages are skipped. The user and request are optional.
+func ResolveLocale(cfg *config.Config, user *models.User, r *http.Request) string {
+	var candidates []string
+	if r != nil {
+		candidates = append(candidates, r.URL.Query().Get(LocaleQueryParam), r.Header.Get(LocaleHeader))
+	}
+	if user != nil && user.Personalization != nil {
+		candidates = append(candidates, user.Personalization.Language)
+	}
+	if cfg != nil {
+		candidates = append(candidates, cfg.Locale.DefaultLanguage)
+	}
+
+	for _, candidate := range candidates {
+		language := strings.ToLower(strings.TrimSpace(candidate))
+		if models.IsSupportedLanguage(language) {
+			return language
+		}
+	}
+
+	return models.DefaultLanguage
+}
@@ -0,0 +1,58 @@
+package util
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/windoze95/saltybytes-api/internal/config"
+	"github.com/windoze95/saltybytes-api/internal/models"
+)
+
+func TestResolveLocale(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Locale.DefaultLanguage = "fr"
+	germanUser := &models.User{Personalization: &models.Personalization{Language: "de"}}
+
+	tests := []struct {
+		name   string
+		cfg    *config.Config
+		user   *models.User
+		param  string
+		header string
+		want   string
+	}{
+		{"query parameter", cfg, germanUser, "es", "it", "es"},
+		{"header", cfg, germanUser, "", "it", "it"},
+		{"header is case insensitive", cfg, germanUser, "", " IT ", "it"},
+		{"user's personalization", cfg, germanUser, "", "", "de"},
+		{"configured default", cfg, nil, "", "", "fr"},
+		{"user without personalization", cfg, &models.User{}, "", "", "fr"},
+		{"english", &config.Config{}, nil, "", "", models.DefaultLanguage},
+		{"no config", nil, nil, "", "", models.DefaultLanguage},
+		{"unsupported languages are skipped", cfg, germanUser, "tlh", "xx", "de"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := "/"
+			if tt.param != "" {
+				target += "?" + LocaleQueryParam + "=" + tt.param
+			}
+			r := httptest.NewRequest(http.MethodGet, target, nil)
+			if tt.header != "" {
+				r.Header.Set(LocaleHeader, tt.header)
+			}
+
+			if got := ResolveLocale(tt.cfg, tt.user, r); got != tt.want {
+				t.Fatalf("ResolveLocale = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveLocaleWithoutRequest(t *testing.T) {
+	user := &models.User{Personalization: &models.Personalization{Language: "ja"}}
+	if got := ResolveLocale(nil, user, nil); got != "ja" {
+		t.Fatalf("ResolveLocale = %q, want ja", got)
+	}
+}
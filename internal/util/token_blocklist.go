@@ -0,0 +1,60 @@
+package util
+
+import (
+	"sync"
+	"time"
+)
+
+// TokenBlocklist is a store of revoked token IDs (jti claims), checked on every authenticated request.
+// Implementations can keep it in memory, in the database, or in a shared cache like Redis.
+type TokenBlocklist interface {
+	// Block revokes a token until it expires, a zero expiry blocks it for good.
+	Block(tokenID string, expiresAt time.Time) error
+	// IsBlocked checks if a token has been revoked.
+	IsBlocked(tokenID string) (bool, error)
+}
+
+// Make sure MemoryTokenBlocklist is a TokenBlocklist.
+var _ TokenBlocklist = (*MemoryTokenBlocklist)(nil)
+
+// MemoryTokenBlocklist is a TokenBlocklist kept in memory.
+// It's only shared within one process and is lost on restart, so it suits development and single instances.
+type MemoryTokenBlocklist struct {
+	blocked sync.Map // Token ID to the time.Time it expires at
+}
+
+// NewMemoryTokenBlocklist creates a MemoryTokenBlocklist that drops expired tokens every cleanupInterval.
+func NewMemoryTokenBlocklist(cleanupInterval time.Duration) *MemoryTokenBlocklist {
+	b := &MemoryTokenBlocklist{}
+
+	// Cleanup goroutine
+	go func() {
+		for range time.Tick(cleanupInterval) {
+			b.blocked.Range(func(key, value interface{}) bool {
+				if expired(value.(time.Time)) {
+					b.blocked.Delete(key)
+				}
+				return true
+			})
+		}
+	}()
+
+	return b
+}
+
+// Block revokes a token until it expires.
+func (b *MemoryTokenBlocklist) Block(tokenID string, expiresAt time.Time) error {
+	b.blocked.Store(tokenID, expiresAt)
+	return nil
+}
+
+// IsBlocked checks if a token has been revoked.
+func (b *MemoryTokenBlocklist) IsBlocked(tokenID string) (bool, error) {
+	value, ok := b.blocked.Load(tokenID)
+	return ok && !expired(value.(time.Time)), nil
+}
+
+// expired checks if a blocklist expiry has passed, a zero expiry never does.
+func expired(expiresAt time.Time) bool {
+	return !expiresAt.IsZero() && time.Now().After(expiresAt)
+}
@@ -0,0 +1,77 @@
+package util
+
+import (
+	"math"
+
+	"github.com/windoze95/saltybytes-api/internal/models"
+)
+
+// conversionTargets are the units amounts are converted into, per unit system and kind, from smallest to largest.
+// An amount is converted into the largest unit it's at least one of. Approximate units like pinch aren't targets.
+var conversionTargets = map[string]map[models.UnitKind][]string{
+	models.USCustomaryText: {
+		models.UnitKindVolume: {"tsp", "tbsp", "cup", "qt", "gal"},
+		models.UnitKindWeight: {"oz", "lb"},
+	},
+	models.MetricText: {
+		models.UnitKindVolume: {"mL", "L"},
+		models.UnitKindWeight: {"g", "kg"},
+	},
+}
+
+// minConvertedAmount is the smallest amount of a unit an ingredient is converted into.
+const minConvertedAmount = 0.01
+
+// ConvertUnits returns a copy of the ingredients with their amounts converted into the unit system.
+// Ingredients already in the unit system, counted in pieces, or measured in units that don't convert cleanly (pinch,
+// dash, drop, and unknown units) are left unchanged, as are amounts too small for the smallest unit of the system.
+func ConvertUnits(ingredients models.Ingredients, unitSystem models.UnitSystem) models.Ingredients {
+	if ingredients == nil {
+		return nil
+	}
+	system := (&models.Personalization{UnitSystem: unitSystem}).GetUnitSystemText()
+
+	converted := make(models.Ingredients, len(ingredients))
+	for i, ingredient := range ingredients {
+		converted[i] = ingredient
+
+		unit, ok := models.LookupUnit(ingredient.Unit)
+		if !ok || unit.ToBase == 0 || unit.InSystem(system) {
+			continue
+		}
+		targets := conversionTargets[system][unit.Kind]
+		if len(targets) == 0 {
+			continue
+		}
+
+		baseAmount := ingredient.Amount * unit.ToBase
+		target, _ := models.LookupUnit(targets[0])
+		for _, symbol := range targets[1:] {
+			larger, _ := models.LookupUnit(symbol)
+			if baseAmount < larger.ToBase {
+				break
+			}
+			target = larger
+		}
+
+		// Amounts too small to show in the smallest target unit keep their own unit instead of rounding to nothing
+		amount := baseAmount / target.ToBase
+		if amount < minConvertedAmount {
+			continue
+		}
+
+		converted[i].Unit = target.Symbol
+		converted[i].Amount = roundConvertedAmount(amount)
+	}
+	return converted
+}
+
+// roundConvertedAmount rounds a converted amount to two decimal places, or to three significant digits when it's less
+// than one, so small amounts of large units like ounces aren't thrown off by the rounding.
+func roundConvertedAmount(amount float64) float64 {
+	if amount >= 1 {
+		return math.Round(amount*100) / 100
+	}
+	scale := math.Pow(10, 2-math.Floor(math.Log10(amount)))
+	return math.Round(amount*scale) / scale
+}
@@ -0,0 +1,78 @@
+package util
+
+import (
+	"math"
+	"testing"
+
+	"github.com/windoze95/saltybytes-api/internal/models"
+)
+
+func TestConvertUnits(t *testing.T) {
+	tests := []struct {
+		name       string
+		ingredient models.Ingredient
+		unitSystem models.UnitSystem
+		want       models.Ingredient
+	}{
+		{"grams to ounces", models.Ingredient{Name: "Cheese", Unit: "g", Amount: 100}, models.USCustomary, models.Ingredient{Name: "Cheese", Unit: "oz", Amount: 3.53}},
+		{"kilograms to pounds", models.Ingredient{Name: "Beef", Unit: "kg", Amount: 1}, models.USCustomary, models.Ingredient{Name: "Beef", Unit: "lb", Amount: 2.2}},
+		{"milliliters to cups", models.Ingredient{Name: "Milk", Unit: "mL", Amount: 250}, models.USCustomary, models.Ingredient{Name: "Milk", Unit: "cup", Amount: 1.06}},
+		{"milliliters to teaspoons", models.Ingredient{Name: "Vanilla", Unit: "mL", Amount: 5}, models.USCustomary, models.Ingredient{Name: "Vanilla", Unit: "tsp", Amount: 1.01}},
+		{"cups to milliliters", models.Ingredient{Name: "Flour", Unit: "cup", Amount: 2}, models.Metric, models.Ingredient{Name: "Flour", Unit: "mL", Amount: 473.18}},
+		{"gallons to liters", models.Ingredient{Name: "Stock", Unit: "gal", Amount: 1}, models.Metric, models.Ingredient{Name: "Stock", Unit: "L", Amount: 3.79}},
+		{"pounds to kilograms", models.Ingredient{Name: "Potatoes", Unit: "lb", Amount: 3}, models.Metric, models.Ingredient{Name: "Potatoes", Unit: "kg", Amount: 1.36}},
+		{"ounces to grams", models.Ingredient{Name: "Chocolate", Unit: "oz", Amount: 4}, models.Metric, models.Ingredient{Name: "Chocolate", Unit: "g", Amount: 113.4}},
+		{"already in the unit system", models.Ingredient{Name: "Sugar", Unit: "g", Amount: 200}, models.Metric, models.Ingredient{Name: "Sugar", Unit: "g", Amount: 200}},
+		{"pinch", models.Ingredient{Name: "Salt", Unit: "pinch", Amount: 1}, models.Metric, models.Ingredient{Name: "Salt", Unit: "pinch", Amount: 1}},
+		{"dash", models.Ingredient{Name: "Hot sauce", Unit: "dash", Amount: 2}, models.USCustomary, models.Ingredient{Name: "Hot sauce", Unit: "dash", Amount: 2}},
+		{"pieces", models.Ingredient{Name: "Eggs", Unit: "pieces", Amount: 3}, models.Metric, models.Ingredient{Name: "Eggs", Unit: "pieces", Amount: 3}},
+		{"too small to convert", models.Ingredient{Name: "Saffron", Unit: "mg", Amount: 50}, models.USCustomary, models.Ingredient{Name: "Saffron", Unit: "mg", Amount: 50}},
+		{"no amount", models.Ingredient{Name: "Olive oil", Unit: "mL", Amount: 0}, models.USCustomary, models.Ingredient{Name: "Olive oil", Unit: "mL", Amount: 0}},
+		{"small amount of a large unit", models.Ingredient{Name: "Yeast", Unit: "g", Amount: 7}, models.USCustomary, models.Ingredient{Name: "Yeast", Unit: "oz", Amount: 0.247}},
+		{"unknown unit", models.Ingredient{Name: "Basil", Unit: "handful", Amount: 1}, models.Metric, models.Ingredient{Name: "Basil", Unit: "handful", Amount: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ConvertUnits(models.Ingredients{tt.ingredient}, tt.unitSystem)
+			if got[0] != tt.want {
+				t.Fatalf("got %+v, want %+v", got[0], tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertUnitsRoundTrip(t *testing.T) {
+	for _, unit := range models.Units {
+		if unit.ToBase == 0 || len(unit.Systems) != 1 {
+			continue
+		}
+		otherSystem, system := models.Metric, models.USCustomary
+		if unit.InSystem(models.MetricText) {
+			otherSystem, system = models.USCustomary, models.Metric
+		}
+
+		for _, amount := range []float64{1, 2.5, 10, 750} {
+			original := models.Ingredients{{Name: "Water", Unit: unit.Symbol, Amount: amount}}
+			roundTrip := ConvertUnits(ConvertUnits(original, otherSystem), system)
+
+			back, _ := models.LookupUnit(roundTrip[0].Unit)
+			got := roundTrip[0].Amount * back.ToBase
+			want := amount * unit.ToBase
+			// Each conversion rounds to two decimal places, so small amounts of large units lose the most
+			if relErr := math.Abs(got-want) / want; relErr > 0.02 {
+				t.Fatalf("%v %s came back as %v %s, off by %.1f%%", amount, unit.Symbol, roundTrip[0].Amount, roundTrip[0].Unit, relErr*100)
+			}
+		}
+	}
+}
+
+func TestConvertUnitsLeavesIngredientsUnchanged(t *testing.T) {
+	ingredients := models.Ingredients{{Name: "Milk", Unit: "cup", Amount: 1}}
+	ConvertUnits(ingredients, models.Metric)
+	if ingredients[0].Unit != "cup" || ingredients[0].Amount != 1 {
+		t.Fatalf("ingredients were changed to %+v", ingredients[0])
+	}
+	if ConvertUnits(nil, models.Metric) != nil {
+		t.Fatal("converting no ingredients didn't return nil")
+	}
+}